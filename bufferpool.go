@@ -0,0 +1,75 @@
+// Copyright 2019 The GoPacket Authors. All rights reserved.
+//
+// Use of this source code is governed by a BSD-style license
+// that can be found in the LICENSE file in the root of the source
+// tree.
+
+package gopacket
+
+import (
+	"fmt"
+	"sync"
+)
+
+// BufferPool is a sync.Pool-backed allocator for packet data, used by
+// PacketSource.Pool to recycle the buffers of released packets.  It is safe
+// for concurrent use.
+//
+// Using a packet after releasing it is a bug which pooling makes hard to
+// spot, since the packet's data silently changes when its buffer is reused.
+// Building with the gopacket_debug tag makes such bugs visible: released
+// buffers are poisoned by filling them with 0xDE bytes, buffers which were
+// written to after being released cause a panic when they are next
+// borrowed, and so does releasing a packet twice.
+type BufferPool struct {
+	size int
+	pool sync.Pool
+}
+
+// poisonByte fills released buffers in debug builds.
+const poisonByte = 0xde
+
+// NewBufferPool returns a pool of buffers of size bytes, which should be at
+// least the capture length of the packets read, such as a pcap file's
+// snaplen.  Larger packets get buffers of their own, which aren't pooled.
+func NewBufferPool(size int) *BufferPool {
+	return &BufferPool{size: size}
+}
+
+// Get borrows a buffer of length n from the pool.
+func (b *BufferPool) Get(n int) []byte {
+	if n > b.size {
+		return make([]byte, n)
+	}
+	if buf, ok := b.pool.Get().(*[]byte); ok {
+		if poolDebug {
+			checkPoison(*buf)
+		}
+		return (*buf)[:n]
+	}
+	return make([]byte, n, b.size)
+}
+
+// Put returns a buffer from Get to the pool.  Buffers which didn't come from
+// the pool are ignored.
+func (b *BufferPool) Put(buf []byte) {
+	if cap(buf) != b.size {
+		return
+	}
+	buf = buf[:b.size]
+	if poolDebug {
+		for i := range buf {
+			buf[i] = poisonByte
+		}
+	}
+	b.pool.Put(&buf)
+}
+
+// checkPoison panics if a released buffer has been written to.
+func checkPoison(buf []byte) {
+	for i, c := range buf {
+		if c != poisonByte {
+			panic(fmt.Sprintf("gopacket: released packet buffer modified at offset %d", i))
+		}
+	}
+}
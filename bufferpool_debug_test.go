@@ -0,0 +1,51 @@
+// Copyright 2019 The GoPacket Authors. All rights reserved.
+//
+// Use of this source code is governed by a BSD-style license
+// that can be found in the LICENSE file in the root of the source
+// tree.
+
+// +build gopacket_debug
+
+package gopacket
+
+import (
+	"bytes"
+	"testing"
+)
+
+func expectPanic(t *testing.T, what string, fn func()) {
+	defer func() {
+		if recover() == nil {
+			t.Errorf("%s did not panic", what)
+		}
+	}()
+	fn()
+}
+
+func TestBufferPoolPoison(t *testing.T) {
+	src := &zeroCopySource{buf: make([]byte, 20), n: 1}
+	ps := NewPacketSource(src, DecodePayload)
+	ps.Pool = NewBufferPool(64)
+	packet, err := ps.NextPacket()
+	if err != nil {
+		t.Fatal(err)
+	}
+	payload := packet.ApplicationLayer().Payload()
+	packet.(Releaser).Release()
+	if want := bytes.Repeat([]byte{poisonByte}, 20); !bytes.Equal(payload, want) {
+		t.Errorf("released payload not poisoned: %v", payload)
+	}
+	expectPanic(t, "double Release", packet.(Releaser).Release)
+
+	pool := NewBufferPool(64)
+	buf := pool.Get(10)
+	pool.Put(buf)
+	buf[3] = 1
+	// sync.Pool may drop buffers, so only a reused buffer is checked.
+	defer func() {
+		recover()
+	}()
+	if got := pool.Get(10); &got[0] == &buf[0] {
+		t.Error("modified buffer was reused")
+	}
+}
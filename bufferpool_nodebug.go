@@ -0,0 +1,12 @@
+// Copyright 2019 The GoPacket Authors. All rights reserved.
+//
+// Use of this source code is governed by a BSD-style license
+// that can be found in the LICENSE file in the root of the source
+// tree.
+
+// +build !gopacket_debug
+
+package gopacket
+
+// poolDebug enables checks for misuse of pooled packets.
+const poolDebug = false
@@ -0,0 +1,118 @@
+// Copyright 2019 The GoPacket Authors. All rights reserved.
+//
+// Use of this source code is governed by a BSD-style license
+// that can be found in the LICENSE file in the root of the source
+// tree.
+
+package gopacket
+
+import (
+	"bytes"
+	"io"
+	"testing"
+)
+
+// zeroCopySource returns numbered packets in one reused buffer.
+type zeroCopySource struct {
+	buf  []byte
+	i, n int
+}
+
+func (s *zeroCopySource) read() ([]byte, CaptureInfo, error) {
+	if s.i == s.n {
+		return nil, CaptureInfo{}, io.EOF
+	}
+	for j := range s.buf {
+		s.buf[j] = byte(s.i)
+	}
+	s.i++
+	return s.buf, CaptureInfo{CaptureLength: len(s.buf), Length: len(s.buf)}, nil
+}
+
+func (s *zeroCopySource) ReadPacketData() ([]byte, CaptureInfo, error) {
+	data, ci, err := s.read()
+	buf := make([]byte, len(data))
+	copy(buf, data)
+	return buf, ci, err
+}
+
+func (s *zeroCopySource) ZeroCopyReadPacketData() ([]byte, CaptureInfo, error) {
+	return s.read()
+}
+
+// copyingSource hides zeroCopySource's ZeroCopyReadPacketData.
+type copyingSource struct {
+	PacketDataSource
+}
+
+func TestPacketSourcePool(t *testing.T) {
+	pool := NewBufferPool(64)
+	src := &zeroCopySource{buf: make([]byte, 20), n: 10}
+	ps := NewPacketSource(src, DecodePayload)
+	ps.Pool = pool
+	var packets []Packet
+	for packet := range ps.Packets() {
+		packets = append(packets, packet)
+	}
+	if len(packets) != 10 {
+		t.Fatalf("got %d packets, want 10", len(packets))
+	}
+	for i, packet := range packets {
+		want := bytes.Repeat([]byte{byte(i)}, 20)
+		if !bytes.Equal(packet.Data(), want) || !bytes.Equal(packet.ApplicationLayer().Payload(), want) {
+			t.Errorf("packet %d: got data %v", i, packet.Data())
+		}
+		if cap(packet.Data()) != 64 {
+			t.Errorf("packet %d: data not from pool", i)
+		}
+		packet.(Releaser).Release()
+		if !poolDebug {
+			packet.(Releaser).Release() // ignored
+		}
+	}
+	if buf := pool.Get(30); len(buf) != 30 || cap(buf) != 64 {
+		t.Errorf("Get(30) returned len %d, cap %d", len(buf), cap(buf))
+	}
+	if buf := pool.Get(100); len(buf) != 100 {
+		t.Errorf("Get(100) returned len %d", len(buf))
+	}
+}
+
+func TestPacketSourcePoolWorkers(t *testing.T) {
+	for _, flowAffinity := range []bool{false, true} {
+		src := &zeroCopySource{buf: make([]byte, 20), n: 1000}
+		ps := NewPacketSource(src, DecodePayload)
+		ps.Pool = NewBufferPool(64)
+		ps.Workers = 4
+		ps.FlowAffinity = flowAffinity
+		i := 0
+		for packet := range ps.Packets() {
+			if !bytes.Equal(packet.Data(), bytes.Repeat([]byte{byte(i)}, 20)) {
+				t.Fatalf("packet %d: got data %v", i, packet.Data())
+			}
+			packet.(Releaser).Release()
+			i++
+		}
+		if i != 1000 {
+			t.Errorf("got %d packets, want 1000", i)
+		}
+	}
+}
+
+func TestPacketSourcePoolCopyingSource(t *testing.T) {
+	src := &zeroCopySource{buf: make([]byte, 20), n: 1}
+	ps := NewPacketSource(copyingSource{src}, DecodePayload)
+	ps.Pool = NewBufferPool(64)
+	packet, err := ps.NextPacket()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cap(packet.Data()) != 20 {
+		t.Error("data from a copying source was copied")
+	}
+	// Release leaves data it didn't borrow alone.
+	packet.(Releaser).Release()
+	if !bytes.Equal(packet.Data(), make([]byte, 20)) {
+		t.Errorf("data changed by Release: %v", packet.Data())
+	}
+}
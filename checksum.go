@@ -0,0 +1,57 @@
+// Copyright 2019 The GoPacket Authors. All rights reserved.
+//
+// Use of this source code is governed by a BSD-style license
+// that can be found in the LICENSE file in the root of the source
+// tree.
+
+package gopacket
+
+// ChecksumStatus is the result of verifying a layer's checksum while decoding.
+// Checksums are only verified if DecodeOptions.VerifyChecksums (or
+// DecodingLayerParserOptions.VerifyChecksums) is set; otherwise every layer
+// reports ChecksumUnverified.
+type ChecksumStatus uint8
+
+const (
+	// ChecksumUnverified means the checksum was not checked, either because
+	// verification was not requested, or because it could not be done (the
+	// packet was truncated, or a pseudo-header was needed but no suitable
+	// network layer was found).
+	ChecksumUnverified ChecksumStatus = iota
+	// ChecksumGood means the checksum matched the layer's data.
+	ChecksumGood
+	// ChecksumBad means the checksum did not match the layer's data.
+	ChecksumBad
+	// ChecksumZero means the checksum field was zero and did not match the
+	// layer's data.  Either the sender didn't compute a checksum (which is
+	// allowed for UDP over IPv4), or checksum computation was offloaded to the
+	// NIC and the packet was captured on the sending host before it was filled
+	// in.
+	ChecksumZero
+)
+
+func (c ChecksumStatus) String() string {
+	switch c {
+	case ChecksumUnverified:
+		return "Unverified"
+	case ChecksumGood:
+		return "Good"
+	case ChecksumBad:
+		return "Bad"
+	case ChecksumZero:
+		return "Zero"
+	}
+	return "Unknown"
+}
+
+// ChecksumVerifier is implemented by layers which carry a checksum that can be
+// verified after decoding.  Verification never fails decoding: a bad checksum
+// is recorded on the layer, so ErrorLayer stays reserved for real decode
+// failures.
+type ChecksumVerifier interface {
+	// VerifyChecksum checks the layer's checksum against its contents and
+	// payload, records the result in the layer and returns it.  network is
+	// the closest enclosing network layer, used by protocols whose checksum
+	// covers a pseudo-header.  It may be nil.
+	VerifyChecksum(network NetworkLayer) ChecksumStatus
+}
@@ -7,6 +7,7 @@
 package gopacket
 
 import (
+	"encoding/json"
 	"errors"
 )
 
@@ -150,6 +151,11 @@ func (d *DecodeFailure) Dump() (s string) {
 // LayerType returns LayerTypeDecodeFailure
 func (d *DecodeFailure) LayerType() LayerType { return LayerTypeDecodeFailure }
 
+// MarshalJSON implements json.Marshaler, reporting the decoding error.
+func (d *DecodeFailure) MarshalJSON() ([]byte, error) {
+	return json.Marshal(struct{ Error string }{d.err.Error()})
+}
+
 // decodeUnknown "decodes" unsupported data types by returning an error.
 // This decoder will thus always return a DecodeFailure layer.
 func decodeUnknown(data []byte, p PacketBuilder) error {
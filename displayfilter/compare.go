@@ -0,0 +1,340 @@
+// Copyright 2019 The GoPacket Authors. All rights reserved.
+//
+// Use of this source code is governed by a BSD-style license
+// that can be found in the LICENSE file in the root of the source
+// tree.
+
+package displayfilter
+
+import (
+	"bytes"
+	"encoding/hex"
+	"fmt"
+	"math"
+	"net"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// op is a comparison operator.
+type op int
+
+const (
+	opEq op = iota
+	opNe
+	opLt
+	opLe
+	opGt
+	opGe
+	opContains
+	opMatches
+)
+
+var opNames = map[string]op{
+	"==": opEq, "eq": opEq, "!=": opNe, "ne": opNe,
+	"<": opLt, "lt": opLt, "<=": opLe, "le": opLe,
+	">": opGt, "gt": opGt, ">=": opGe, "ge": opGe,
+	"contains": opContains, "matches": opMatches, "~": opMatches,
+}
+
+// literal is a value in a filter expression.
+type literal struct {
+	text   string
+	quoted bool // a "string", which is never taken as a number or address
+}
+
+func (l literal) String() string {
+	if l.quoted {
+		return strconv.Quote(l.text)
+	}
+	return l.text
+}
+
+// predicate tests a single field value.
+type predicate func(v reflect.Value) bool
+
+var (
+	ipType       = reflect.TypeOf(net.IP(nil))
+	macType      = reflect.TypeOf(net.HardwareAddr(nil))
+	durationType = reflect.TypeOf(time.Duration(0))
+	stringerType = reflect.TypeOf((*fmt.Stringer)(nil)).Elem()
+)
+
+// compare compiles "field o lit" for a field of type t.  opNe is compiled as
+// opEq; the caller inverts the result across all values.
+func compare(t reflect.Type, o op, lit literal) (predicate, error) {
+	if o == opNe {
+		o = opEq
+	}
+	switch {
+	case t == ipType:
+		return compareIP(o, lit)
+	case t == macType:
+		if o != opEq || lit.quoted {
+			break
+		}
+		mac, err := net.ParseMAC(lit.text)
+		if err != nil {
+			return nil, fmt.Errorf("%v is not an ethernet address", lit)
+		}
+		return func(v reflect.Value) bool { return bytes.Equal(v.Bytes(), mac) }, nil
+	case isLeaf(t):
+		return compareBytes(o, lit)
+	case t == durationType:
+		d, err := time.ParseDuration(lit.text)
+		if err != nil {
+			n, nerr := strconv.ParseInt(lit.text, 0, 64)
+			if nerr != nil || lit.quoted {
+				return nil, fmt.Errorf("%v is not a duration", lit)
+			}
+			d = time.Duration(n)
+		}
+		return compareOrdered(o, func(v reflect.Value) int { return cmpInt(v.Int(), int64(d)) })
+	}
+
+	switch t.Kind() {
+	case reflect.Bool:
+		if o != opEq {
+			break
+		}
+		b, err := strconv.ParseBool(lit.text)
+		if err != nil || lit.quoted {
+			return nil, fmt.Errorf("%v is not a boolean", lit)
+		}
+		return func(v reflect.Value) bool { return v.Bool() == b }, nil
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		n, err := strconv.ParseInt(lit.text, 0, 64)
+		if err != nil || lit.quoted {
+			if k, ok := enumValue(t, lit); ok {
+				n = int64(k)
+			} else if o == opEq && t.Implements(stringerType) {
+				return compareString(t, lit), nil
+			} else {
+				return nil, fmt.Errorf("%v is not a valid %v", lit, t)
+			}
+		}
+		return compareOrdered(o, func(v reflect.Value) int { return cmpInt(v.Int(), n) })
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		n, err := strconv.ParseUint(lit.text, 0, 64)
+		if err != nil || lit.quoted {
+			if k, ok := enumValue(t, lit); ok {
+				n = uint64(k)
+			} else if o == opEq && t.Implements(stringerType) {
+				return compareString(t, lit), nil
+			} else {
+				return nil, fmt.Errorf("%v is not a valid %v", lit, t)
+			}
+		}
+		return compareOrdered(o, func(v reflect.Value) int { return cmpUint(v.Uint(), n) })
+	case reflect.Float32, reflect.Float64:
+		f, err := strconv.ParseFloat(lit.text, 64)
+		if err != nil || lit.quoted {
+			return nil, fmt.Errorf("%v is not a number", lit)
+		}
+		return compareOrdered(o, func(v reflect.Value) int {
+			switch x := v.Float(); {
+			case x < f:
+				return -1
+			case x > f:
+				return 1
+			case math.IsNaN(x):
+				return 2 // unordered, so nothing matches
+			}
+			return 0
+		})
+	case reflect.String:
+		return compareText(o, lit, func(v reflect.Value) string { return v.String() })
+	default:
+		return nil, fmt.Errorf("fields of type %v can only be tested for presence", t)
+	}
+	return nil, fmt.Errorf("%v is not supported for fields of type %v", o, t)
+}
+
+var opSymbols = [...]string{"==", "!=", "<", "<=", ">", ">=", "contains", "matches"}
+
+func (o op) String() string { return opSymbols[o] }
+
+func cmpInt(a, b int64) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	}
+	return 0
+}
+
+func cmpUint(a, b uint64) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	}
+	return 0
+}
+
+// compareOrdered builds a predicate from a three-way comparison with the
+// literal.
+func compareOrdered(o op, cmp func(reflect.Value) int) (predicate, error) {
+	switch o {
+	case opEq:
+		return func(v reflect.Value) bool { return cmp(v) == 0 }, nil
+	case opLt:
+		return func(v reflect.Value) bool { return cmp(v) == -1 }, nil
+	case opLe:
+		return func(v reflect.Value) bool { c := cmp(v); return c == -1 || c == 0 }, nil
+	case opGt:
+		return func(v reflect.Value) bool { return cmp(v) == 1 }, nil
+	case opGe:
+		return func(v reflect.Value) bool { c := cmp(v); return c == 1 || c == 0 }, nil
+	}
+	return nil, fmt.Errorf("%v is not supported for numbers", o)
+}
+
+// enumValue finds the value of a small integer type whose String method
+// returns lit, so that "ip.proto == TCP" works.  For port types, whose String
+// looks like "80(http)", the name in parentheses matches too.
+func enumValue(t reflect.Type, lit literal) (uint64, bool) {
+	if !t.Implements(stringerType) || t.Bits() > 16 {
+		return 0, false
+	}
+	max := uint64(1)<<uint(t.Bits()) - 1
+	v := reflect.New(t).Elem()
+	for i := uint64(0); i <= max; i++ {
+		if t.Kind() >= reflect.Uint && t.Kind() <= reflect.Uintptr {
+			v.SetUint(i)
+		} else {
+			v.SetInt(int64(i))
+		}
+		s := v.Interface().(fmt.Stringer).String()
+		if strings.EqualFold(s, lit.text) {
+			return i, true
+		}
+		if open := strings.IndexByte(s, '('); open >= 0 && strings.HasSuffix(s, ")") &&
+			strings.EqualFold(s[open+1:len(s)-1], lit.text) {
+			return i, true
+		}
+	}
+	return 0, false
+}
+
+// compareString matches values of a Stringer type by name.
+func compareString(t reflect.Type, lit literal) predicate {
+	return func(v reflect.Value) bool {
+		return strings.EqualFold(v.Interface().(fmt.Stringer).String(), lit.text)
+	}
+}
+
+// compareText compiles string comparisons.
+func compareText(o op, lit literal, text func(reflect.Value) string) (predicate, error) {
+	s := lit.text
+	switch o {
+	case opContains:
+		return func(v reflect.Value) bool { return strings.Contains(text(v), s) }, nil
+	case opMatches:
+		re, err := regexp.Compile(s)
+		if err != nil {
+			return nil, err
+		}
+		return func(v reflect.Value) bool { return re.MatchString(text(v)) }, nil
+	}
+	return compareOrdered(o, func(v reflect.Value) int { return strings.Compare(text(v), s) })
+}
+
+// bytesOf returns the bytes of a byte slice or array.
+func bytesOf(v reflect.Value) []byte {
+	if v.Kind() == reflect.Slice {
+		return v.Bytes()
+	}
+	b := make([]byte, v.Len())
+	reflect.Copy(reflect.ValueOf(b), v)
+	return b
+}
+
+// compareBytes compiles comparisons with byte fields.  Literals are compared
+// as text, which suits names stored as bytes such as DNS names; unquoted hex
+// bytes separated by ':', '-' or '.', as in "de:ad:be:ef", match those bytes
+// too.
+func compareBytes(o op, lit literal) (predicate, error) {
+	text, err := compareText(o, lit, func(v reflect.Value) string { return string(bytesOf(v)) })
+	if err != nil || lit.quoted {
+		return text, err
+	}
+	b, ok := parseHexBytes(lit.text)
+	if !ok {
+		return text, nil
+	}
+	switch o {
+	case opEq:
+		return func(v reflect.Value) bool { return bytes.Equal(bytesOf(v), b) || text(v) }, nil
+	case opContains:
+		return func(v reflect.Value) bool { return bytes.Contains(bytesOf(v), b) || text(v) }, nil
+	}
+	return text, nil
+}
+
+func parseHexBytes(s string) ([]byte, bool) {
+	sep := -1
+	for _, c := range []byte{':', '-', '.'} {
+		if strings.IndexByte(s, c) >= 0 {
+			sep = int(c)
+			break
+		}
+	}
+	if sep < 0 {
+		return nil, false
+	}
+	var b []byte
+	for _, part := range strings.Split(s, string(rune(sep))) {
+		if len(part) != 2 {
+			return nil, false
+		}
+		x, err := hex.DecodeString(part)
+		if err != nil {
+			return nil, false
+		}
+		b = append(b, x...)
+	}
+	return b, true
+}
+
+// compareIP compiles comparisons with addresses and CIDR networks.
+func compareIP(o op, lit literal) (predicate, error) {
+	if o != opEq || lit.quoted {
+		return nil, fmt.Errorf("%v is not supported for addresses", o)
+	}
+	if strings.Contains(lit.text, "/") {
+		_, network, err := net.ParseCIDR(lit.text)
+		if err != nil {
+			return nil, fmt.Errorf("%v is not a network", lit)
+		}
+		return func(v reflect.Value) bool { return network.Contains(net.IP(v.Bytes())) }, nil
+	}
+	ip := net.ParseIP(lit.text)
+	if ip == nil {
+		return nil, fmt.Errorf("%v is not an address; host names are not resolved", lit)
+	}
+	return func(v reflect.Value) bool { return ip.Equal(net.IP(v.Bytes())) }, nil
+}
+
+// member compiles one element of a set, which may be a range "lo..hi".
+func member(t reflect.Type, lit literal) (predicate, error) {
+	if !lit.quoted {
+		if i := strings.Index(lit.text, ".."); i > 0 {
+			lo, err := compare(t, opGe, literal{text: lit.text[:i]})
+			if err != nil {
+				return nil, err
+			}
+			hi, err := compare(t, opLe, literal{text: lit.text[i+2:]})
+			if err != nil {
+				return nil, err
+			}
+			return func(v reflect.Value) bool { return lo(v) && hi(v) }, nil
+		}
+	}
+	return compare(t, opEq, lit)
+}
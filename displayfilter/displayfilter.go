@@ -0,0 +1,420 @@
+// Copyright 2019 The GoPacket Authors. All rights reserved.
+//
+// Use of this source code is governed by a BSD-style license
+// that can be found in the LICENSE file in the root of the source
+// tree.
+
+/*
+Package displayfilter filters decoded packets with Wireshark-style display
+filter expressions, such as
+
+	dns.qry.name == "example.com" && ip.ttl < 5
+	tcp.flags.syn && !tcp.flags.ack
+	ip.src == 10.0.0.0/8 or udp.port in {53 5353 8000..8080}
+
+Unlike BPF filters, which only see raw bytes, display filters see the fields
+of the layers gopacket decoded:
+
+	f, err := displayfilter.Compile(`dns.qry.name contains "example"`)
+	if err != nil {
+		...
+	}
+	for packet := range packetSource.Packets() {
+		if f.Match(packet) {
+			...
+		}
+	}
+
+# Fields
+
+Field names are derived from the layer structs: the name of the layer type
+followed by the path of exported struct fields, all matched case
+insensitively, so "ipv4.ttl" is IPv4.TTL and "dns.questions.name" is the
+Name of each of DNS.Questions.  Fields of embedded structs are reached
+directly, fields of slice elements yield one value per element, and a layer
+name on its own, like "dns", tests whether the packet has that layer.
+Common Wireshark names such as ip.src, ip.addr, tcp.port, tcp.flags.syn,
+eth.src and dns.qry.name are provided as aliases, and the "frame"
+pseudo-layer gives the packet metadata (frame.len, frame.cap_len).
+
+Layers from the layers package are known by default; use RegisterLayer to
+add others.  Referring to a field that doesn't exist is a compile error.
+
+# Operators
+
+Comparisons are ==, !=, <, <=, >, >= (or eq, ne, lt, le, gt, ge), contains
+and matches (or ~), which takes a regular expression.  "field in {a b c}"
+tests membership, where numeric members may be ranges like 8000..8080.
+Expressions combine with && (and), || (or) and ! (not), and && binds more
+tightly than ||.
+
+As in Wireshark, a comparison is true if any value of the field satisfies
+it, except for !=, which is true if the field is present and no value
+equals the literal.  A field on its own tests for presence, except that
+boolean fields must also be true, so that "tcp.flags.syn" matches SYN
+packets.
+
+# Values
+
+Numbers may be decimal, hex (0x) or octal (0) literals; fields whose types
+have a String method, like layers.IPProtocol, can also be compared with
+names, as in "ip.proto == TCP".  Addresses compare with IP addresses or CIDR
+networks, and ethernet addresses with MACs.  Strings are double-quoted, and
+byte fields compare with strings or with hex bytes such as de:ad:be:ef.
+*/
+package displayfilter
+
+import (
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+
+	"github.com/google/gopacket"
+)
+
+// Filter is a compiled display filter.  It is safe for concurrent use.
+type Filter struct {
+	expr string
+	root node
+}
+
+// Compile parses a display filter expression.  An empty expression matches
+// every packet.
+func Compile(expr string) (*Filter, error) {
+	toks, err := lex(expr)
+	if err != nil {
+		return nil, err
+	}
+	p := &parser{toks: toks}
+	var root node = constNode(true)
+	if p.peek().kind != tokEOF {
+		if root, err = p.parseOr(); err != nil {
+			return nil, err
+		}
+		if p.peek().kind != tokEOF {
+			return nil, p.errorf("syntax error")
+		}
+	}
+	return &Filter{expr: expr, root: root}, nil
+}
+
+// MustCompile is like Compile, but panics if the expression can't be
+// compiled.
+func MustCompile(expr string) *Filter {
+	f, err := Compile(expr)
+	if err != nil {
+		panic(fmt.Sprintf("displayfilter: Compile(%q): %v", expr, err))
+	}
+	return f
+}
+
+// Match returns true if p matches the filter.
+func (f *Filter) Match(p gopacket.Packet) bool {
+	return f.root.eval(p)
+}
+
+// String returns the expression the filter was compiled from.
+func (f *Filter) String() string {
+	return f.expr
+}
+
+type node interface {
+	eval(p gopacket.Packet) bool
+}
+
+type constNode bool
+type andNode struct{ a, b node }
+type orNode struct{ a, b node }
+type notNode struct{ n node }
+
+func (n constNode) eval(gopacket.Packet) bool { return bool(n) }
+func (n andNode) eval(p gopacket.Packet) bool { return n.a.eval(p) && n.b.eval(p) }
+func (n orNode) eval(p gopacket.Packet) bool  { return n.a.eval(p) || n.b.eval(p) }
+func (n notNode) eval(p gopacket.Packet) bool { return !n.n.eval(p) }
+
+// testNode tests the values of a field.  preds has a predicate for each of
+// refs; a nil predicate accepts any value.
+type testNode struct {
+	refs  []*fieldRef
+	preds []predicate
+	none  bool // true if the field must be present with no matching value
+}
+
+func (n *testNode) eval(p gopacket.Packet) bool {
+	found, matched := false, false
+	for i, ref := range n.refs {
+		pred := n.preds[i]
+		ref.each(p, func(v reflect.Value) bool {
+			found = true
+			matched = pred == nil || pred(v)
+			return !matched
+		})
+		if matched {
+			break
+		}
+	}
+	if n.none {
+		return found && !matched
+	}
+	return matched
+}
+
+type tokenKind int
+
+const (
+	tokEOF tokenKind = iota
+	tokWord
+	tokString
+	tokOp
+)
+
+type token struct {
+	kind tokenKind
+	text string
+	pos  int
+}
+
+func (t token) String() string {
+	switch t.kind {
+	case tokEOF:
+		return "end of expression"
+	case tokString:
+		return strconv.Quote(t.text)
+	}
+	return fmt.Sprintf("%q", t.text)
+}
+
+func isWordByte(c byte) bool {
+	return c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' ||
+		c == '_' || c == '.' || c == ':' || c == '/' || c == '-'
+}
+
+var ops = []string{"==", "!=", "<=", ">=", "&&", "||", "<", ">", "!", "(", ")", "{", "}", ",", "~"}
+
+func lex(expr string) ([]token, error) {
+	var toks []token
+	for i := 0; i < len(expr); {
+		c := expr[i]
+		switch {
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r':
+			i++
+			continue
+		case c == '"':
+			end := i + 1
+			for ; end < len(expr) && expr[end] != '"'; end++ {
+				if expr[end] == '\\' {
+					end++
+				}
+			}
+			if end >= len(expr) {
+				return nil, fmt.Errorf("unterminated string at offset %d", i)
+			}
+			s, err := strconv.Unquote(expr[i : end+1])
+			if err != nil {
+				return nil, fmt.Errorf("invalid string at offset %d: %v", i, err)
+			}
+			toks = append(toks, token{tokString, s, i})
+			i = end + 1
+			continue
+		case isWordByte(c):
+			start := i
+			for i < len(expr) && isWordByte(expr[i]) {
+				i++
+			}
+			toks = append(toks, token{tokWord, expr[start:i], start})
+			continue
+		}
+		op := ""
+		for _, o := range ops {
+			if strings.HasPrefix(expr[i:], o) {
+				op = o
+				break
+			}
+		}
+		if op == "" {
+			return nil, fmt.Errorf("unexpected character %q at offset %d", c, i)
+		}
+		toks = append(toks, token{tokOp, op, i})
+		i += len(op)
+	}
+	return append(toks, token{tokEOF, "", len(expr)}), nil
+}
+
+type parser struct {
+	toks []token
+	pos  int
+}
+
+func (p *parser) peek() token { return p.toks[p.pos] }
+
+func (p *parser) next() token {
+	t := p.toks[p.pos]
+	if t.kind != tokEOF {
+		p.pos++
+	}
+	return t
+}
+
+// is returns true if the next token is an operator or word spelled s.
+func (p *parser) is(s ...string) bool {
+	t := p.peek()
+	if t.kind != tokOp && t.kind != tokWord {
+		return false
+	}
+	for _, x := range s {
+		if t.text == x {
+			return true
+		}
+	}
+	return false
+}
+
+func (p *parser) errorf(format string, args ...interface{}) error {
+	t := p.peek()
+	return fmt.Errorf("%s near %v at offset %d", fmt.Sprintf(format, args...), t, t.pos)
+}
+
+func (p *parser) parseOr() (node, error) {
+	n, err := p.parseAnd()
+	for err == nil && p.is("||", "or") {
+		p.next()
+		var m node
+		if m, err = p.parseAnd(); err == nil {
+			n = orNode{n, m}
+		}
+	}
+	return n, err
+}
+
+func (p *parser) parseAnd() (node, error) {
+	n, err := p.parseNot()
+	for err == nil && p.is("&&", "and") {
+		p.next()
+		var m node
+		if m, err = p.parseNot(); err == nil {
+			n = andNode{n, m}
+		}
+	}
+	return n, err
+}
+
+func (p *parser) parseNot() (node, error) {
+	switch {
+	case p.is("!", "not"):
+		p.next()
+		n, err := p.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		return notNode{n}, nil
+	case p.is("("):
+		p.next()
+		n, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		if !p.is(")") {
+			return nil, p.errorf("expected \")\"")
+		}
+		p.next()
+		return n, nil
+	}
+	return p.parseTest()
+}
+
+// parseTest parses a field, optionally followed by a comparison or set.
+func (p *parser) parseTest() (node, error) {
+	t := p.peek()
+	if t.kind != tokWord {
+		return nil, p.errorf("expected field")
+	}
+	refs, err := resolveField(t.text)
+	if err != nil {
+		return nil, fmt.Errorf("%v at offset %d", err, t.pos)
+	}
+	p.next()
+	n := &testNode{refs: refs, preds: make([]predicate, len(refs))}
+
+	if p.is("in") {
+		p.next()
+		return p.parseSet(n)
+	}
+	o, ok := opNames[p.peek().text]
+	if !ok || (p.peek().kind != tokOp && p.peek().kind != tokWord) {
+		// A presence test.
+		for i, ref := range refs {
+			if ref.leaf.Kind() == reflect.Bool {
+				n.preds[i] = func(v reflect.Value) bool { return v.Bool() }
+			}
+		}
+		return n, nil
+	}
+	p.next()
+	lit, err := p.parseLiteral()
+	if err != nil {
+		return nil, err
+	}
+	for i, ref := range refs {
+		if n.preds[i], err = compare(ref.leaf, o, lit); err != nil {
+			return nil, fmt.Errorf("%s: %v at offset %d", t.text, err, t.pos)
+		}
+	}
+	n.none = o == opNe
+	return n, nil
+}
+
+func (p *parser) parseLiteral() (literal, error) {
+	t := p.peek()
+	switch t.kind {
+	case tokWord:
+		p.next()
+		return literal{text: t.text}, nil
+	case tokString:
+		p.next()
+		return literal{text: t.text, quoted: true}, nil
+	}
+	return literal{}, p.errorf("expected value")
+}
+
+// parseSet parses "{a b c}", whose members may be separated by commas.
+func (p *parser) parseSet(n *testNode) (node, error) {
+	if !p.is("{") {
+		return nil, p.errorf("expected \"{\"")
+	}
+	p.next()
+	var lits []literal
+	for !p.is("}") {
+		lit, err := p.parseLiteral()
+		if err != nil {
+			return nil, err
+		}
+		lits = append(lits, lit)
+		if p.is(",") {
+			p.next()
+		}
+	}
+	p.next()
+	if len(lits) == 0 {
+		return nil, p.errorf("empty set")
+	}
+	for i, ref := range n.refs {
+		var members []predicate
+		for _, lit := range lits {
+			m, err := member(ref.leaf, lit)
+			if err != nil {
+				return nil, err
+			}
+			members = append(members, m)
+		}
+		n.preds[i] = func(v reflect.Value) bool {
+			for _, m := range members {
+				if m(v) {
+					return true
+				}
+			}
+			return false
+		}
+	}
+	return n, nil
+}
@@ -0,0 +1,239 @@
+// Copyright 2019 The GoPacket Authors. All rights reserved.
+//
+// Use of this source code is governed by a BSD-style license
+// that can be found in the LICENSE file in the root of the source
+// tree.
+
+package displayfilter
+
+import (
+	"net"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+	"github.com/google/gopacket/pcapgo"
+)
+
+func readTestPackets(t *testing.T, filename string) []gopacket.Packet {
+	f, err := os.Open(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	r, err := pcapgo.NewReader(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var packets []gopacket.Packet
+	for p := range gopacket.NewPacketSource(r, r.LinkType()).Packets() {
+		packets = append(packets, p)
+	}
+	return packets
+}
+
+func TestFilterPcap(t *testing.T) {
+	for _, test := range []struct {
+		filename, expr string
+		want           int
+	}{
+		{"../pcap/test_dns.pcap", "", 10},
+		{"../pcap/test_dns.pcap", "dns", 10},
+		{"../pcap/test_dns.pcap", "tcp", 0},
+		{"../pcap/test_dns.pcap", `dns.qry.name == "xage.ru"`, 1},
+		{"../pcap/test_dns.pcap", `dns.qry.name contains "vtomske"`, 2},
+		{"../pcap/test_dns.pcap", `dns.qry.name matches "\\.com$"`, 3},
+		{"../pcap/test_dns.pcap", `dns.qry.name ~ "^[a-z]+\\.ru$"`, 4},
+		{"../pcap/test_dns.pcap", "dns.qry.type == AAAA", 2},
+		{"../pcap/test_dns.pcap", "dns.qry.type != A", 2},
+		{"../pcap/test_dns.pcap", "dns.flags.response", 0},
+		{"../pcap/test_dns.pcap", "!dns.flags.response", 10},
+		{"../pcap/test_dns.pcap", "ip.ttl < 56", 3},
+		{"../pcap/test_dns.pcap", "ip.ttl ge 58", 3},
+		{"../pcap/test_dns.pcap", "ip.dst == 95.211.92.15", 3},
+		{"../pcap/test_dns.pcap", "ip.addr == 109.60.0.0/16", 2},
+		{"../pcap/test_dns.pcap", "ip.proto == UDP && udp.port == 53", 10},
+		{"../pcap/test_dns.pcap", "udp.dstport in {1..52 54..65535}", 0},
+		{"../pcap/test_dns.pcap", "ip.ttl in {52, 53 80..90}", 3},
+		{"../pcap/test_dns.pcap", "frame.len > 85", 3},
+		{"../pcap/test_ethernet.pcap", "tcp.flags.syn && !tcp.flags.ack", 1},
+		{"../pcap/test_ethernet.pcap", "tcp.flags.syn", 2},
+		{"../pcap/test_ethernet.pcap", "tcp.srcport == http", 5},
+		{"../pcap/test_ethernet.pcap", "tcp.port == 44644 and frame.len > 100", 2},
+		{"../pcap/test_ethernet.pcap", "tcp.port != 80", 0},
+		{"../pcap/test_ethernet.pcap", "ip.src == 10.1.1.1 or (tcp.flags.syn and not tcp.flags.ack)", 6},
+		{"../pcap/test_ethernet.pcap", "eth.type == IPv4 && ip.flags == DF", 10},
+		{"../pcap/test_ethernet.pcap", "payload", 3},
+		{"../pcap/test_ethernet.pcap", `data.data contains "HTTP/1."`, 2},
+		{"../pcap/test_ethernet.pcap", "dns || udp", 0},
+	} {
+		f, err := Compile(test.expr)
+		if err != nil {
+			t.Errorf("%q: %v", test.expr, err)
+			continue
+		}
+		got := 0
+		for _, p := range readTestPackets(t, test.filename) {
+			if f.Match(p) {
+				got++
+			}
+		}
+		if got != test.want {
+			t.Errorf("%s %q: matched %d packets, want %d", test.filename, test.expr, got, test.want)
+		}
+	}
+}
+
+func TestFilterSynthetic(t *testing.T) {
+	eth := &layers.Ethernet{
+		SrcMAC:       net.HardwareAddr{0xde, 0xad, 0xbe, 0xef, 0x00, 0x01},
+		DstMAC:       net.HardwareAddr{0xff, 0xff, 0xff, 0xff, 0xff, 0xff},
+		EthernetType: layers.EthernetTypeIPv4,
+	}
+	ip := &layers.IPv4{
+		Version:  4,
+		TTL:      3,
+		Protocol: layers.IPProtocolUDP,
+		SrcIP:    net.IP{192, 168, 0, 1},
+		DstIP:    net.IP{10, 0, 0, 7},
+	}
+	udp := &layers.UDP{SrcPort: 5000, DstPort: 6000}
+	udp.SetNetworkLayerForChecksum(ip)
+	buf := gopacket.NewSerializeBuffer()
+	opts := gopacket.SerializeOptions{FixLengths: true, ComputeChecksums: true}
+	payload := gopacket.Payload{0xca, 0xfe, 'h', 'i'}
+	if err := gopacket.SerializeLayers(buf, opts, eth, ip, udp, payload); err != nil {
+		t.Fatal(err)
+	}
+	p := gopacket.NewPacket(buf.Bytes(), layers.LinkTypeEthernet, gopacket.Default)
+
+	for _, test := range []struct {
+		expr string
+		want bool
+	}{
+		{"ip.ttl < 5", true},
+		{"ip.ttl < 3", false},
+		{"ip.ttl == 0x3", true},
+		{"ip.addr == 10.0.0.0/8", true},
+		{"ip.src == 10.0.0.0/8", false},
+		{"ip.src != 10.0.0.0/8", true},
+		{"ip.addr != 10.0.0.7", false},
+		{"ipv6.addr != ::1", false},
+		{"eth.src == de:ad:be:ef:00:01", true},
+		{"eth.addr == ff:ff:ff:ff:ff:ff", true},
+		{"eth.src == ff:ff:ff:ff:ff:ff", false},
+		{"udp.port in {53 6000}", true},
+		{"udp.port in {5001..5999}", false},
+		{"data.data == ca:fe:68:69", true},
+		{"data.data contains fe:68", true},
+		{`data.data contains "hi"`, true},
+		{"ip.proto == udp", true},
+		{"ip.proto == 17", true},
+		{"tcp.flags.syn", false},
+		{"!tcp.flags.syn", true},
+		{"!tcp && udp", true},
+		{"ip.ttl > 1 && ip.ttl < 5 || tcp", true},
+		{"tcp || ip.ttl > 1 && ip.ttl < 2", false},
+		{"ipv4.dstip == 10.0.0.7", true},
+		{"IPv4.TTL == 3", true},
+	} {
+		f, err := Compile(test.expr)
+		if err != nil {
+			t.Errorf("%q: %v", test.expr, err)
+			continue
+		}
+		if got := f.Match(p); got != test.want {
+			t.Errorf("%q: got %v, want %v", test.expr, got, test.want)
+		}
+		if f.String() != test.expr {
+			t.Errorf("%q: String() = %q", test.expr, f.String())
+		}
+	}
+}
+
+func TestCompileErrors(t *testing.T) {
+	for _, test := range []struct {
+		expr, err string
+	}{
+		{"ip.bogus == 1", "unknown field"},
+		{"nosuchlayer", "unknown field"},
+		{"ip.ttl ==", "expected value"},
+		{"ip.ttl == foo", "not a valid"},
+		{"ip.src == example.com", "not an address"},
+		{"ip.src < 10.0.0.1", "not supported"},
+		{"(ip.ttl == 1", `expected ")"`},
+		{"ip.ttl == 1 ip.ttl", "syntax error"},
+		{"ip.ttl in 1", `expected "{"`},
+		{"ip.ttl in {}", "empty set"},
+		{`dns.qry.name matches "("`, "missing closing"},
+		{`dns.qry.name == "abc`, "unterminated string"},
+		{"ip.ttl == 1 $", "unexpected character"},
+		{"&& ip", "expected field"},
+	} {
+		_, err := Compile(test.expr)
+		if err == nil {
+			t.Errorf("%q: no error", test.expr)
+		} else if !strings.Contains(err.Error(), test.err) {
+			t.Errorf("%q: got error %q, want %q", test.expr, err, test.err)
+		}
+	}
+}
+
+type testLayer struct {
+	Count uint16
+	Name  string
+}
+
+var testLayerType = gopacket.RegisterLayerType(1999, gopacket.LayerTypeMetadata{Name: "DisplayFilterTest"})
+
+func (l *testLayer) LayerType() gopacket.LayerType { return testLayerType }
+func (l *testLayer) LayerContents() []byte         { return nil }
+func (l *testLayer) LayerPayload() []byte          { return nil }
+
+func TestRegisterLayer(t *testing.T) {
+	if _, err := Compile("displayfiltertest.count > 1"); err == nil {
+		t.Fatal("unregistered layer compiled")
+	}
+	RegisterLayer(&testLayer{})
+	f := MustCompile(`displayfiltertest.count > 1 && displayfiltertest.name == "x"`)
+	p := gopacket.NewPacket(nil, gopacket.DecodeFunc(func(data []byte, pb gopacket.PacketBuilder) error {
+		pb.AddLayer(&testLayer{Count: 2, Name: "x"})
+		return nil
+	}), gopacket.Default)
+	if !f.Match(p) {
+		t.Error("registered layer did not match")
+	}
+}
+
+func TestGeneratedLayers(t *testing.T) {
+	for _, name := range []string{
+		"rmcp", "dot11mgmtassociationreq", "mdns", "http", "quic", "bgp",
+		"nbns", "nbds", "ipfix", "netflowv5", "netflowv9",
+	} {
+		if _, err := Compile(name); err != nil {
+			t.Errorf("%q: %v", name, err)
+		}
+	}
+}
+
+func TestMDNSMatch(t *testing.T) {
+	dns := &layers.DNS{
+		Questions: []layers.DNSQuestion{{Name: []byte("printer.local"), Type: layers.DNSTypeA, Class: layers.DNSClassIN}},
+	}
+	udp := &layers.UDP{SrcPort: 5353, DstPort: 5353}
+	ip := &layers.IPv4{Version: 4, TTL: 255, Protocol: layers.IPProtocolUDP, SrcIP: net.IP{10, 0, 0, 1}, DstIP: net.IP{224, 0, 0, 251}}
+	udp.SetNetworkLayerForChecksum(ip)
+	buf := gopacket.NewSerializeBuffer()
+	opts := gopacket.SerializeOptions{FixLengths: true, ComputeChecksums: true}
+	if err := gopacket.SerializeLayers(buf, opts, ip, udp, dns); err != nil {
+		t.Fatal(err)
+	}
+	p := gopacket.NewPacket(buf.Bytes(), layers.LayerTypeIPv4, gopacket.Default)
+	for _, expr := range []string{"dns", "mdns", `dns.qry.name == "printer.local"`} {
+		if !MustCompile(expr).Match(p) {
+			t.Errorf("%q did not match mDNS packet", expr)
+		}
+	}
+}
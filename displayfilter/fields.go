@@ -0,0 +1,312 @@
+// Copyright 2019 The GoPacket Authors. All rights reserved.
+//
+// Use of this source code is governed by a BSD-style license
+// that can be found in the LICENSE file in the root of the source
+// tree.
+
+package displayfilter
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+
+	"github.com/google/gopacket"
+)
+
+// layerInfo describes a layer struct fields can be looked up in.
+type layerInfo struct {
+	layerType gopacket.LayerType
+	typ       reflect.Type // the type stored in the packet, usually a pointer
+}
+
+//go:generate go run gen.go
+
+// registry maps lower-case layer names to the layers with that name.
+// Several Go types may share a layer type, like layers.IGMP and
+// layers.IGMPv1or2.
+var registry = map[string][]layerInfo{}
+
+// RegisterLayer makes the fields of a layer available to filters, under the
+// lower-cased name of its layer type.  l is only used for its type and layer
+// type; a zero value will do.  All layers in the layers package are
+// registered by default, by layers_generated.go.
+//
+// RegisterLayer is not safe to call concurrently with Compile, so call it
+// from an init function.
+func RegisterLayer(l gopacket.Layer) {
+	name := strings.ToLower(l.LayerType().String())
+	info := layerInfo{l.LayerType(), reflect.TypeOf(l)}
+	for _, existing := range registry[name] {
+		if existing == info {
+			return
+		}
+	}
+	registry[name] = append(registry[name], info)
+}
+
+// layerAliases are the Wireshark names of layers whose names differ from
+// their gopacket layer type.
+var layerAliases = map[string]string{
+	"eth":  "ethernet",
+	"ip":   "ipv4",
+	"vlan": "dot1q",
+	"icmp": "icmpv4",
+	"data": "payload",
+}
+
+// fieldAliases map common Wireshark field names to gopacket field paths.
+// Names with several paths match if any of them does, like Wireshark's
+// ip.addr.
+var fieldAliases = map[string][]string{
+	"eth.src":  {"ethernet.SrcMAC"},
+	"eth.dst":  {"ethernet.DstMAC"},
+	"eth.addr": {"ethernet.SrcMAC", "ethernet.DstMAC"},
+	"eth.type": {"ethernet.EthernetType"},
+
+	"vlan.id":       {"dot1q.VLANIdentifier"},
+	"vlan.priority": {"dot1q.Priority"},
+	"vlan.etype":    {"dot1q.Type"},
+
+	"ip.src":         {"ipv4.SrcIP"},
+	"ip.dst":         {"ipv4.DstIP"},
+	"ip.addr":        {"ipv4.SrcIP", "ipv4.DstIP"},
+	"ip.proto":       {"ipv4.Protocol"},
+	"ip.len":         {"ipv4.Length"},
+	"ip.id":          {"ipv4.Id"},
+	"ip.hdr_len":     {"ipv4.IHL"},
+	"ip.dsfield":     {"ipv4.TOS"},
+	"ip.frag_offset": {"ipv4.FragOffset"},
+
+	"ipv6.src":  {"ipv6.SrcIP"},
+	"ipv6.dst":  {"ipv6.DstIP"},
+	"ipv6.addr": {"ipv6.SrcIP", "ipv6.DstIP"},
+	"ipv6.nxt":  {"ipv6.NextHeader"},
+	"ipv6.hlim": {"ipv6.HopLimit"},
+	"ipv6.plen": {"ipv6.Length"},
+	"ipv6.flow": {"ipv6.FlowLabel"},
+
+	"tcp.srcport":           {"tcp.SrcPort"},
+	"tcp.dstport":           {"tcp.DstPort"},
+	"tcp.port":              {"tcp.SrcPort", "tcp.DstPort"},
+	"tcp.seq":               {"tcp.Seq"},
+	"tcp.ack":               {"tcp.Ack"},
+	"tcp.window_size_value": {"tcp.Window"},
+	"tcp.urgent_pointer":    {"tcp.Urgent"},
+	"tcp.flags.fin":         {"tcp.FIN"},
+	"tcp.flags.syn":         {"tcp.SYN"},
+	"tcp.flags.reset":       {"tcp.RST"},
+	"tcp.flags.push":        {"tcp.PSH"},
+	"tcp.flags.ack":         {"tcp.ACK"},
+	"tcp.flags.urg":         {"tcp.URG"},
+	"tcp.flags.ece":         {"tcp.ECE"},
+	"tcp.flags.cwr":         {"tcp.CWR"},
+	"tcp.flags.ns":          {"tcp.NS"},
+
+	"udp.srcport": {"udp.SrcPort"},
+	"udp.dstport": {"udp.DstPort"},
+	"udp.port":    {"udp.SrcPort", "udp.DstPort"},
+
+	"arp.opcode": {"arp.Operation"},
+
+	"dns.id":             {"dns.ID"},
+	"dns.flags.response": {"dns.QR"},
+	"dns.flags.opcode":   {"dns.OpCode"},
+	"dns.flags.rcode":    {"dns.ResponseCode"},
+	"dns.qry.name":       {"dns.Questions.Name"},
+	"dns.qry.type":       {"dns.Questions.Type"},
+	"dns.qry.class":      {"dns.Questions.Class"},
+	"dns.resp.name":      {"dns.Answers.Name"},
+	"dns.resp.type":      {"dns.Answers.Type"},
+	"dns.resp.ttl":       {"dns.Answers.TTL"},
+	"dns.a":              {"dns.Answers.IP"},
+	"dns.aaaa":           {"dns.Answers.IP"},
+	"dns.cname":          {"dns.Answers.CNAME"},
+	"dns.count.queries":  {"dns.QDCount"},
+	"dns.count.answers":  {"dns.ANCount"},
+
+	"mdns": {"dns.MDNS"},
+
+	"data.data": {"payload"},
+
+	"frame.len":          {"frame.Length"},
+	"frame.cap_len":      {"frame.CaptureLength"},
+	"frame.interface_id": {"frame.InterfaceIndex"},
+}
+
+// frame is the pseudo-layer holding a packet's metadata.
+const frame = "frame"
+
+var metadataType = reflect.TypeOf(&gopacket.PacketMetadata{})
+
+// fieldRef is a field resolved against one layer type.  An empty path
+// refers to the layer itself.
+type fieldRef struct {
+	layer layerInfo
+	frame bool
+	path  [][]int // indexes of the struct fields, as for reflect.Value.FieldByIndex
+	leaf  reflect.Type
+}
+
+// resolveField resolves a field name to the layers it may be found in.
+func resolveField(name string) ([]*fieldRef, error) {
+	paths, ok := fieldAliases[strings.ToLower(name)]
+	if !ok {
+		paths = []string{name}
+	}
+	var refs []*fieldRef
+	for _, path := range paths {
+		segs := strings.Split(path, ".")
+		layerName := strings.ToLower(segs[0])
+		if alias, ok := layerAliases[layerName]; ok {
+			layerName = alias
+		}
+		var infos []layerInfo
+		if layerName == frame {
+			infos = []layerInfo{{typ: metadataType}}
+		} else if infos = registry[layerName]; len(infos) == 0 {
+			return nil, fmt.Errorf("unknown field %q: no layer %q", name, segs[0])
+		}
+		var firstErr error
+		for _, info := range infos {
+			ref := &fieldRef{layer: info, frame: layerName == frame}
+			var err error
+			if ref.path, ref.leaf, err = resolvePath(info.typ, segs[1:]); err != nil {
+				if firstErr == nil {
+					firstErr = err
+				}
+				continue
+			}
+			refs = append(refs, ref)
+		}
+		if len(refs) == 0 {
+			return nil, fmt.Errorf("unknown field %q: %v", name, firstErr)
+		}
+	}
+	return refs, nil
+}
+
+// isLeaf returns true for slices and arrays which are compared as a whole,
+// like []byte, net.IP and net.HardwareAddr.
+func isLeaf(t reflect.Type) bool {
+	return (t.Kind() == reflect.Slice || t.Kind() == reflect.Array) && t.Elem().Kind() == reflect.Uint8
+}
+
+// elem strips pointers and, except for byte slices, slices and arrays from t,
+// giving the type of the values a field yields.
+func elem(t reflect.Type) reflect.Type {
+	for {
+		switch t.Kind() {
+		case reflect.Ptr:
+			t = t.Elem()
+		case reflect.Slice, reflect.Array:
+			if isLeaf(t) {
+				return t
+			}
+			t = t.Elem()
+		default:
+			return t
+		}
+	}
+}
+
+// findField finds the exported field called name in t, matching case
+// insensitively and looking through embedded structs.  An exact match wins
+// over case-insensitive ones, which must be unambiguous.
+func findField(t reflect.Type, name string) ([]int, bool, error) {
+	var exact, folded [][]int
+	var walk func(t reflect.Type, prefix []int)
+	walk = func(t reflect.Type, prefix []int) {
+		for i := 0; i < t.NumField(); i++ {
+			f := t.Field(i)
+			if f.PkgPath != "" {
+				continue
+			}
+			index := append(append([]int(nil), prefix...), i)
+			if f.Anonymous && f.Type.Kind() == reflect.Struct {
+				walk(f.Type, index)
+				continue
+			}
+			if f.Name == name {
+				exact = append(exact, index)
+			} else if strings.EqualFold(f.Name, name) {
+				folded = append(folded, index)
+			}
+		}
+	}
+	walk(t, nil)
+	switch {
+	case len(exact) == 1:
+		return exact[0], true, nil
+	case len(exact) == 0 && len(folded) == 1:
+		return folded[0], true, nil
+	case len(folded) > 1:
+		return nil, false, fmt.Errorf("%q is ambiguous in %v", name, t)
+	}
+	return nil, false, nil
+}
+
+func resolvePath(t reflect.Type, segs []string) ([][]int, reflect.Type, error) {
+	var path [][]int
+	for _, seg := range segs {
+		t = elem(t)
+		if t.Kind() != reflect.Struct {
+			return nil, nil, fmt.Errorf("%v has no field %q", t, seg)
+		}
+		index, ok, err := findField(t, seg)
+		if err != nil {
+			return nil, nil, err
+		} else if !ok {
+			return nil, nil, fmt.Errorf("%v has no field %q", t, seg)
+		}
+		path = append(path, index)
+		t = t.FieldByIndex(index).Type
+	}
+	return path, elem(t), nil
+}
+
+// each calls fn with each value of the field in p, stopping when fn returns
+// false.
+func (r *fieldRef) each(p gopacket.Packet, fn func(reflect.Value) bool) {
+	if r.frame {
+		walkValue(reflect.ValueOf(p.Metadata()), r.path, fn)
+		return
+	}
+	for _, l := range p.Layers() {
+		if l.LayerType() != r.layer.layerType || reflect.TypeOf(l) != r.layer.typ {
+			continue
+		}
+		if !walkValue(reflect.ValueOf(l), r.path, fn) {
+			return
+		}
+	}
+}
+
+// walkValue follows path from v, expanding pointers and slices, and calls fn
+// on what it finds.  It returns false if fn asked to stop.
+func walkValue(v reflect.Value, path [][]int, fn func(reflect.Value) bool) bool {
+	switch v.Kind() {
+	case reflect.Ptr, reflect.Interface:
+		if v.IsNil() {
+			return true
+		}
+		return walkValue(v.Elem(), path, fn)
+	case reflect.Slice, reflect.Array:
+		if !isLeaf(v.Type()) {
+			for i := 0; i < v.Len(); i++ {
+				if !walkValue(v.Index(i), path, fn) {
+					return false
+				}
+			}
+			return true
+		}
+		if len(path) == 0 && v.Kind() == reflect.Slice && v.IsNil() {
+			// Unset addresses and byte fields are absent.
+			return true
+		}
+	}
+	if len(path) == 0 {
+		return fn(v)
+	}
+	return walkValue(v.FieldByIndex(path[0]), path[1:], fn)
+}
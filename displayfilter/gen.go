@@ -0,0 +1,89 @@
+// Copyright 2019 The GoPacket Authors. All rights reserved.
+//
+// Use of this source code is governed by a BSD-style license
+// that can be found in the LICENSE file in the root of the source
+// tree.
+
+// +build ignore
+
+// This binary type checks the layers package and writes the registration of
+// all its layers, the struct types whose pointers implement gopacket.Layer,
+// to layers_generated.go.
+//
+//  go run gen.go
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"go/format"
+	"go/importer"
+	"go/token"
+	"go/types"
+	"io/ioutil"
+	"log"
+	"sort"
+)
+
+const header = `// Copyright 2019 The GoPacket Authors. All rights reserved.
+//
+// Use of this source code is governed by a BSD-style license
+// that can be found in the LICENSE file in the root of the source
+// tree.
+
+// Created by gen.go, don't edit manually.
+
+package displayfilter
+
+import (
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+)
+
+func init() {
+	for _, l := range []gopacket.Layer{
+		&gopacket.Payload{}, &gopacket.Fragment{},
+`
+
+func main() {
+	imp := importer.ForCompiler(token.NewFileSet(), "source", nil)
+	gopacket, err := imp.Import("github.com/google/gopacket")
+	if err != nil {
+		log.Fatal(err)
+	}
+	layers, err := imp.Import("github.com/google/gopacket/layers")
+	if err != nil {
+		log.Fatal(err)
+	}
+	layer := gopacket.Scope().Lookup("Layer").Type().Underlying().(*types.Interface)
+
+	var names []string
+	scope := layers.Scope()
+	for _, name := range scope.Names() {
+		tn, ok := scope.Lookup(name).(*types.TypeName)
+		if !ok || !tn.Exported() || tn.IsAlias() {
+			continue
+		}
+		if _, ok := tn.Type().Underlying().(*types.Struct); !ok {
+			continue
+		}
+		if types.Implements(types.NewPointer(tn.Type()), layer) {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	var buf bytes.Buffer
+	buf.WriteString(header)
+	for _, name := range names {
+		fmt.Fprintf(&buf, "\t\t&layers.%s{},\n", name)
+	}
+	buf.WriteString("\t} {\n\t\tRegisterLayer(l)\n\t}\n}\n")
+	src, err := format.Source(buf.Bytes())
+	if err != nil {
+		log.Fatal(err)
+	}
+	if err := ioutil.WriteFile("layers_generated.go", src, 0644); err != nil {
+		log.Fatal(err)
+	}
+}
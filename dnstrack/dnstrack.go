@@ -0,0 +1,389 @@
+// Copyright 2019 The GoPacket Authors. All rights reserved.
+//
+// Use of this source code is governed by a BSD-style license
+// that can be found in the LICENSE file in the root of the source
+// tree.
+
+// Package dnstrack matches DNS queries with their responses, measuring the
+// latency of resolvers.
+//
+// UDP packets are added to a Tracker directly, and the DNS messages of TCP
+// connections, which are framed by a 2-byte length, are parsed from streams
+// reassembled by the reassembly package:
+//
+//	tracker := dnstrack.New(dnstrack.Config{
+//		Transaction: func(t *dnstrack.Transaction) {
+//			fmt.Println(t)
+//		},
+//	})
+//	assembler := reassembly.NewAssembler(reassembly.NewStreamPool(&dnstrack.StreamFactory{Tracker: tracker}))
+//	for packet := range packetSource.Packets() {
+//		if tcp, ok := packet.TransportLayer().(*layers.TCP); ok {
+//			assembler.AssembleWithContext(packet.NetworkLayer().NetworkFlow(), tcp, captureContext(packet.Metadata().CaptureInfo))
+//		} else {
+//			tracker.AddPacket(packet)
+//		}
+//	}
+//	assembler.FlushAll()
+//	tracker.Flush()
+//
+// where captureContext is a reassembly.AssemblerContext returning the
+// packet's CaptureInfo, by whose timestamp the messages of TCP streams are
+// timed.
+//
+// A query and a response belong to the same Transaction when they are
+// between the same client and server, by the same protocol, and have the
+// same ID and question, names being compared without regard to case.  Since
+// clients reuse IDs, a query only matches a response while it is pending:
+// until it is answered or times out.  A query repeated while the first is
+// pending is counted as a retransmission of it.
+//
+// Timeouts are measured by packet timestamps, as in the flowtable package.
+package dnstrack
+
+import (
+	"container/list"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+)
+
+// Defaults for Config fields left zero.
+const (
+	DefaultTimeout    = 5 * time.Second
+	DefaultMaxPending = 1 << 16
+)
+
+// Config configures a Tracker.
+type Config struct {
+	// Timeout is how long a query waits for a response, from the last time
+	// it was sent.  If zero, DefaultTimeout is used.
+	Timeout time.Duration
+	// MaxPending bounds the number of queries waiting for a response.  When
+	// a new query would exceed it, the query sent longest ago is evicted.
+	// If zero, DefaultMaxPending is used.
+	MaxPending int
+	// Transaction, if set, is called with each transaction once it has
+	// ended: when it is answered, times out, is evicted or flushed, and for
+	// each response which matches no query.
+	Transaction func(*Transaction)
+}
+
+// Question is the question of a DNS message, by which queries and responses
+// are matched.
+type Question struct {
+	// Name is lowercase, as names are matched without regard to case.
+	Name  string
+	Type  layers.DNSType
+	Class layers.DNSClass
+}
+
+func (q Question) String() string {
+	return fmt.Sprintf("%s %v %v", q.Name, q.Class, q.Type)
+}
+
+// Key identifies a transaction.
+type Key struct {
+	// Network and Transport are the flows from the client to the server.
+	Network, Transport gopacket.Flow
+	// Protocol is UDP or TCP.
+	Protocol layers.IPProtocol
+	ID       uint16
+	// Question is the first question of the message, or empty for the
+	// rare messages without one.
+	Question Question
+}
+
+// idKey is a Key without its question.
+type idKey struct {
+	network, transport gopacket.Flow
+	protocol           layers.IPProtocol
+	id                 uint16
+}
+
+func (k Key) idKey() idKey {
+	return idKey{k.Network, k.Transport, k.Protocol, k.ID}
+}
+
+func (k Key) String() string {
+	client, server := k.Network.Endpoints()
+	cport, sport := k.Transport.Endpoints()
+	return fmt.Sprintf("%v %v:%v->%v:%v id %#04x", k.Protocol, client, cport, server, sport, k.ID)
+}
+
+// Status says how a transaction ended.
+type Status int
+
+const (
+	// StatusPending transactions are waiting for a response.
+	StatusPending Status = iota
+	// StatusAnswered transactions have a query and a response.
+	StatusAnswered
+	// StatusTimeout queries saw no response within Config.Timeout.
+	StatusTimeout
+	// StatusUnsolicited transactions have a response which matched no
+	// pending query, as happens for responses to queries sent before the
+	// capture started, or which arrive after their query timed out.
+	StatusUnsolicited
+	// StatusEvicted queries were evicted to stay within Config.MaxPending.
+	StatusEvicted
+	// StatusFlushed queries were pending when Tracker.Flush was called.
+	StatusFlushed
+)
+
+var statusNames = []string{"pending", "answered", "timeout", "unsolicited", "evicted", "flushed"}
+
+func (s Status) String() string {
+	if s < 0 || int(s) >= len(statusNames) {
+		return fmt.Sprintf("Status(%d)", int(s))
+	}
+	return statusNames[s]
+}
+
+// Transaction is a DNS query and its response.
+type Transaction struct {
+	Key Key
+	// Query is nil for unsolicited responses, and Response is nil for
+	// queries which weren't answered.  Query is the first of the query's
+	// transmissions.
+	Query, Response *layers.DNS
+	// QueryTime and LastQueryTime are the timestamps of the first and last
+	// transmissions of the query, and ResponseTime that of the response.
+	QueryTime, LastQueryTime, ResponseTime time.Time
+	// RTT is the time from the first transmission of the query to the
+	// response, which is the latency the client saw.
+	RTT time.Duration
+	// Retransmissions counts the transmissions of the query after the
+	// first.
+	Retransmissions int
+	// ResponseCode is that of the response, extended by EDNS(0).
+	ResponseCode layers.DNSResponseCode
+	// Answers summarizes the records of the response's answer section, such
+	// as "A 192.0.2.1" or "CNAME www.example.com".
+	Answers []string
+	Status  Status
+}
+
+func (t *Transaction) String() string {
+	s := fmt.Sprintf("%v %v: %v", t.Key, t.Key.Question, t.Status)
+	if t.Response != nil {
+		s += fmt.Sprintf(" %v", t.ResponseCode)
+		if t.Query != nil {
+			s += fmt.Sprintf(" rtt %v", t.RTT)
+		}
+		if len(t.Answers) > 0 {
+			s += " [" + strings.Join(t.Answers, ", ") + "]"
+		}
+	}
+	if t.Retransmissions > 0 {
+		s += fmt.Sprintf(" retransmissions %d", t.Retransmissions)
+	}
+	return s
+}
+
+// answerSummary returns a short description of a record.
+func answerSummary(rr *layers.DNSResourceRecord) string {
+	var data string
+	switch rr.Type {
+	case layers.DNSTypeA, layers.DNSTypeAAAA:
+		data = rr.IP.String()
+	case layers.DNSTypeCNAME:
+		data = string(rr.CNAME)
+	case layers.DNSTypeNS:
+		data = string(rr.NS)
+	case layers.DNSTypePTR:
+		data = string(rr.PTR)
+	case layers.DNSTypeMX:
+		data = fmt.Sprintf("%d %s", rr.MX.Preference, rr.MX.Name)
+	case layers.DNSTypeSRV:
+		data = fmt.Sprintf("%d %d %d %s", rr.SRV.Priority, rr.SRV.Weight, rr.SRV.Port, rr.SRV.Name)
+	case layers.DNSTypeSOA:
+		data = fmt.Sprintf("%s %s %d", rr.SOA.MName, rr.SOA.RName, rr.SOA.Serial)
+	case layers.DNSTypeTXT:
+		txts := make([]string, len(rr.TXTs))
+		for i, txt := range rr.TXTs {
+			txts[i] = strconv.Quote(string(txt))
+		}
+		data = strings.Join(txts, " ")
+	case layers.DNSTypeSVCB, layers.DNSTypeHTTPS:
+		data = rr.SVCB.String()
+	default:
+		return rr.Type.String()
+	}
+	return rr.Type.String() + " " + data
+}
+
+// Tracker matches DNS queries with their responses.  It is not safe for
+// concurrent use.
+type Tracker struct {
+	config  Config
+	pending map[idKey][]*list.Element
+	// queue orders the pending queries by their last transmission, oldest
+	// first.
+	queue *list.List
+	now   time.Time
+}
+
+// New creates a Tracker.
+func New(config Config) *Tracker {
+	if config.Timeout <= 0 {
+		config.Timeout = DefaultTimeout
+	}
+	if config.MaxPending <= 0 {
+		config.MaxPending = DefaultMaxPending
+	}
+	return &Tracker{
+		config:  config,
+		pending: make(map[idKey][]*list.Element),
+		queue:   list.New(),
+	}
+}
+
+// Len returns the number of pending queries.
+func (t *Tracker) Len() int {
+	return t.queue.Len()
+}
+
+// AddPacket adds the DNS message of a UDP packet, returning its
+// transaction, or nil if the packet has none.  TCP packets are ignored:
+// their messages are added by a StreamFactory.
+func (t *Tracker) AddPacket(p gopacket.Packet) *Transaction {
+	d, ok := p.Layer(layers.LayerTypeDNS).(*layers.DNS)
+	udp, isUDP := p.TransportLayer().(*layers.UDP)
+	if !ok || !isUDP || p.NetworkLayer() == nil {
+		return nil
+	}
+	return t.AddMessage(p.Metadata().Timestamp, p.NetworkLayer().NetworkFlow(), udp.TransportFlow(), layers.IPProtocolUDP, d)
+}
+
+// AddMessage adds a DNS message sent over the given flows at time ts,
+// returning its transaction.  The Tracker keeps the message, so layers
+// decoded by a gopacket.DecodingLayerParser must not be reused.
+//
+// The transactions of queries are updated in place by later messages, and
+// handed to Config.Transaction once they end.  Those of responses have
+// ended.
+func (t *Tracker) AddMessage(ts time.Time, network, transport gopacket.Flow, protocol layers.IPProtocol, d *layers.DNS) *Transaction {
+	t.Expire(ts)
+	k := Key{Network: network, Transport: transport, Protocol: protocol, ID: d.ID}
+	if d.QR {
+		k.Network, k.Transport = network.Reverse(), transport.Reverse()
+	}
+	if len(d.Questions) > 0 {
+		q := &d.Questions[0]
+		k.Question = Question{Name: strings.ToLower(string(q.Name)), Type: q.Type, Class: q.Class}
+	}
+	if d.QR {
+		return t.response(ts, k, len(d.Questions) == 0, d)
+	}
+	return t.query(ts, k, d)
+}
+
+func (t *Tracker) query(ts time.Time, k Key, d *layers.DNS) *Transaction {
+	if e := t.find(k, false); e != nil {
+		tr := e.Value.(*Transaction)
+		tr.Retransmissions++
+		if ts.After(tr.LastQueryTime) {
+			tr.LastQueryTime = ts
+		}
+		t.queue.MoveToBack(e)
+		return tr
+	}
+	if t.queue.Len() >= t.config.MaxPending {
+		t.end(t.queue.Front(), StatusEvicted)
+	}
+	tr := &Transaction{Key: k, Query: d, QueryTime: ts, LastQueryTime: ts}
+	ik := k.idKey()
+	t.pending[ik] = append(t.pending[ik], t.queue.PushBack(tr))
+	return tr
+}
+
+func (t *Tracker) response(ts time.Time, k Key, anyQuestion bool, d *layers.DNS) *Transaction {
+	var tr *Transaction
+	if e := t.find(k, anyQuestion); e != nil {
+		tr = e.Value.(*Transaction)
+		t.remove(e)
+		tr.Status = StatusAnswered
+		tr.RTT = ts.Sub(tr.QueryTime)
+	} else {
+		tr = &Transaction{Key: k, Status: StatusUnsolicited}
+	}
+	tr.Response, tr.ResponseTime = d, ts
+	tr.ResponseCode = d.ResponseCode
+	if rc := d.ExtendedResponseCode(); rc <= 0xff {
+		tr.ResponseCode = layers.DNSResponseCode(rc)
+	}
+	tr.Answers = make([]string, len(d.Answers))
+	for i := range d.Answers {
+		tr.Answers[i] = answerSummary(&d.Answers[i])
+	}
+	if t.config.Transaction != nil {
+		t.config.Transaction(tr)
+	}
+	return tr
+}
+
+// find returns the pending query of k, or if anyQuestion is set, the oldest
+// pending query with k's ID, as matches responses without a question.
+func (t *Tracker) find(k Key, anyQuestion bool) *list.Element {
+	for _, e := range t.pending[k.idKey()] {
+		if anyQuestion || e.Value.(*Transaction).Key.Question == k.Question {
+			return e
+		}
+	}
+	return nil
+}
+
+func (t *Tracker) remove(e *list.Element) {
+	tr := t.queue.Remove(e).(*Transaction)
+	ik := tr.Key.idKey()
+	es := t.pending[ik]
+	for i := range es {
+		if es[i] == e {
+			es = append(es[:i], es[i+1:]...)
+			break
+		}
+	}
+	if len(es) == 0 {
+		delete(t.pending, ik)
+	} else {
+		t.pending[ik] = es
+	}
+}
+
+func (t *Tracker) end(e *list.Element, status Status) {
+	tr := e.Value.(*Transaction)
+	t.remove(e)
+	tr.Status = status
+	if t.config.Transaction != nil {
+		t.config.Transaction(tr)
+	}
+}
+
+// Expire times out the queries which have waited longer than the timeout at
+// time now.  Adding messages does this as their timestamps advance, but
+// live captures should also call Expire periodically, so that queries time
+// out during lulls in traffic.  Times earlier than that of the latest
+// message are ignored.
+func (t *Tracker) Expire(now time.Time) {
+	if now.After(t.now) {
+		t.now = now
+	}
+	for e := t.queue.Front(); e != nil; e = t.queue.Front() {
+		if t.now.Sub(e.Value.(*Transaction).LastQueryTime) < t.config.Timeout {
+			break
+		}
+		t.end(e, StatusTimeout)
+	}
+}
+
+// Flush ends all pending queries, oldest first.
+func (t *Tracker) Flush() {
+	for e := t.queue.Front(); e != nil; e = t.queue.Front() {
+		t.end(e, StatusFlushed)
+	}
+}
@@ -0,0 +1,156 @@
+// Copyright 2019 The GoPacket Authors. All rights reserved.
+//
+// Use of this source code is governed by a BSD-style license
+// that can be found in the LICENSE file in the root of the source
+// tree.
+
+package dnstrack
+
+import (
+	"net"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+)
+
+var (
+	client   = net.IP{10, 0, 0, 2}
+	resolver = net.IP{10, 0, 0, 53}
+	t0       = time.Unix(1500000000, 0)
+)
+
+func testQuery(id uint16, name string) *layers.DNS {
+	return &layers.DNS{
+		ID: id, RD: true,
+		Questions: []layers.DNSQuestion{{Name: []byte(name), Type: layers.DNSTypeA, Class: layers.DNSClassIN}},
+	}
+}
+
+func testResponse(id uint16, name string, rcode layers.DNSResponseCode, answers ...layers.DNSResourceRecord) *layers.DNS {
+	d := testQuery(id, name)
+	d.QR, d.RA, d.ResponseCode, d.Answers = true, true, rcode, answers
+	return d
+}
+
+// udpPacket builds a UDP packet of a DNS message between the client's port
+// and the resolver at t0+at.
+func udpPacket(t *testing.T, d *layers.DNS, port int, at time.Duration) gopacket.Packet {
+	ip := &layers.IPv4{Version: 4, TTL: 64, Protocol: layers.IPProtocolUDP, SrcIP: client, DstIP: resolver}
+	udp := &layers.UDP{SrcPort: layers.UDPPort(port), DstPort: 53}
+	if d.QR {
+		ip.SrcIP, ip.DstIP = resolver, client
+		udp.SrcPort, udp.DstPort = 53, layers.UDPPort(port)
+	}
+	udp.SetNetworkLayerForChecksum(ip)
+	buf := gopacket.NewSerializeBuffer()
+	opts := gopacket.SerializeOptions{FixLengths: true, ComputeChecksums: true}
+	if err := gopacket.SerializeLayers(buf, opts, ip, udp, d); err != nil {
+		t.Fatal(err)
+	}
+	p := gopacket.NewPacket(buf.Bytes(), layers.LayerTypeIPv4, gopacket.Default)
+	p.Metadata().Timestamp = t0.Add(at)
+	return p
+}
+
+func TestAnswered(t *testing.T) {
+	var ended []*Transaction
+	tracker := New(Config{Transaction: func(tr *Transaction) { ended = append(ended, tr) }})
+	q := tracker.AddPacket(udpPacket(t, testQuery(1, "Example.COM"), 5000, 0))
+	if q == nil || q.Status != StatusPending || tracker.Len() != 1 {
+		t.Fatalf("query transaction %v, %d pending", q, tracker.Len())
+	}
+	// 0x20 randomization changes the case of names.
+	answer := layers.DNSResourceRecord{Name: []byte("example.com"), Type: layers.DNSTypeA, Class: layers.DNSClassIN, TTL: 60, IP: net.IP{192, 0, 2, 1}}
+	cname := layers.DNSResourceRecord{Name: []byte("example.com"), Type: layers.DNSTypeCNAME, Class: layers.DNSClassIN, TTL: 60, CNAME: []byte("www.example.net")}
+	r := tracker.AddPacket(udpPacket(t, testResponse(1, "example.com", layers.DNSResponseCodeNoErr, cname, answer), 5000, 12*time.Millisecond))
+	if r != q || len(ended) != 1 || ended[0] != q || tracker.Len() != 0 {
+		t.Fatalf("response transaction %v, ended %v", r, ended)
+	}
+	if q.Status != StatusAnswered || q.RTT != 12*time.Millisecond || q.ResponseCode != layers.DNSResponseCodeNoErr ||
+		q.Query == nil || q.Response == nil || !q.ResponseTime.Equal(t0.Add(12*time.Millisecond)) {
+		t.Errorf("transaction %+v", q)
+	}
+	if want := []string{"CNAME www.example.net", "A 192.0.2.1"}; !reflect.DeepEqual(q.Answers, want) {
+		t.Errorf("answers %q, want %q", q.Answers, want)
+	}
+	if q.Key.Question != (Question{"example.com", layers.DNSTypeA, layers.DNSClassIN}) || q.Key.Protocol != layers.IPProtocolUDP ||
+		q.Key.Network.Src().String() != "10.0.0.2" || q.Key.Transport.String() != "5000->53" {
+		t.Errorf("key %+v", q.Key)
+	}
+	if want := "UDP 10.0.0.2:5000->10.0.0.53:53 id 0x0001 example.com IN A: answered No Error rtt 12ms [CNAME www.example.net, A 192.0.2.1]"; q.String() != want {
+		t.Errorf("got  %s\nwant %s", q, want)
+	}
+}
+
+func TestRetransmissionAndReuse(t *testing.T) {
+	var ended []*Transaction
+	tracker := New(Config{Timeout: 2 * time.Second, Transaction: func(tr *Transaction) { ended = append(ended, tr) }})
+	q := tracker.AddPacket(udpPacket(t, testQuery(7, "a.example"), 5000, 0))
+	// The same ID is used for another name, and from another port.
+	other := tracker.AddPacket(udpPacket(t, testQuery(7, "b.example"), 5000, 10*time.Millisecond))
+	port := tracker.AddPacket(udpPacket(t, testQuery(7, "a.example"), 5001, 20*time.Millisecond))
+	if other == q || port == q || tracker.Len() != 3 {
+		t.Fatalf("%d pending", tracker.Len())
+	}
+	// Retransmissions keep a query from timing out.
+	tracker.AddPacket(udpPacket(t, testQuery(7, "a.example"), 5000, time.Second))
+	tracker.AddPacket(udpPacket(t, testQuery(7, "a.example"), 5000, 2*time.Second))
+	if len(ended) != 0 {
+		t.Fatalf("ended %v", ended)
+	}
+	tracker.AddPacket(udpPacket(t, testResponse(7, "a.example", layers.DNSResponseCodeNXDomain), 5000, 2100*time.Millisecond))
+	if len(ended) != 3 || ended[0] != other || ended[1] != port || ended[2] != q {
+		t.Fatalf("ended %v", ended)
+	}
+	if other.Status != StatusTimeout || port.Status != StatusTimeout || other.Response != nil {
+		t.Errorf("timed out %v, %v", other, port)
+	}
+	if q.Status != StatusAnswered || q.Retransmissions != 2 || q.RTT != 2100*time.Millisecond ||
+		!q.LastQueryTime.Equal(t0.Add(2*time.Second)) || q.ResponseCode != layers.DNSResponseCodeNXDomain {
+		t.Errorf("retransmitted %v", q)
+	}
+
+	// Once answered, the ID is free for a new query, and a duplicate
+	// response matches nothing.
+	again := tracker.AddPacket(udpPacket(t, testQuery(7, "a.example"), 5000, 3*time.Second))
+	if again == q || again.Retransmissions != 0 {
+		t.Errorf("reused ID %v", again)
+	}
+	tracker.Flush()
+	dup := tracker.AddPacket(udpPacket(t, testResponse(7, "a.example", layers.DNSResponseCodeNXDomain), 5000, 3*time.Second))
+	if again.Status != StatusFlushed || dup.Status != StatusUnsolicited || dup.Query != nil || len(ended) != 5 {
+		t.Errorf("flushed %v, duplicate %v", again, dup)
+	}
+}
+
+func TestMatching(t *testing.T) {
+	tracker := New(Config{MaxPending: 2})
+	first := tracker.AddPacket(udpPacket(t, testQuery(1, "a.example"), 5000, 0))
+	tracker.AddPacket(udpPacket(t, testQuery(2, "a.example"), 5000, 0))
+	tracker.AddPacket(udpPacket(t, testQuery(3, "a.example"), 5000, 0))
+	if first.Status != StatusEvicted || tracker.Len() != 2 {
+		t.Errorf("first query %v, %d pending", first.Status, tracker.Len())
+	}
+
+	// A response without a question matches by ID.
+	formerr := testResponse(2, "", layers.DNSResponseCodeFormErr)
+	formerr.Questions = nil
+	if tr := tracker.AddPacket(udpPacket(t, formerr, 5000, time.Millisecond)); tr.Status != StatusAnswered || tr.Key.ID != 2 {
+		t.Errorf("question-less response %v", tr)
+	}
+	// A response to another question doesn't.
+	if tr := tracker.AddPacket(udpPacket(t, testResponse(3, "b.example", 0), 5000, time.Millisecond)); tr.Status != StatusUnsolicited {
+		t.Errorf("response to another question %v", tr)
+	}
+
+	// The extended response code of EDNS(0).
+	r := testResponse(3, "a.example", layers.DNSResponseCodeNoErr)
+	r.SetEDNS(layers.DNSEDNS{UDPSize: 1232, ExtendedRCode: 1})
+	r.ResponseCode = 7
+	if tr := tracker.AddPacket(udpPacket(t, r, 5000, time.Millisecond)); tr.ResponseCode != layers.DNSResponseCodeBadCookie {
+		t.Errorf("response code %v", tr.ResponseCode)
+	}
+}
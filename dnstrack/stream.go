@@ -0,0 +1,109 @@
+// Copyright 2019 The GoPacket Authors. All rights reserved.
+//
+// Use of this source code is governed by a BSD-style license
+// that can be found in the LICENSE file in the root of the source
+// tree.
+
+package dnstrack
+
+import (
+	"encoding/binary"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+	"github.com/google/gopacket/reassembly"
+)
+
+// StreamFactory is a reassembly.StreamFactory which creates a Stream for
+// each connection, adding the DNS messages of connections to a Tracker.
+type StreamFactory struct {
+	Tracker *Tracker
+	// Error, if set, is called with the errors decoding the messages of each
+	// stream.  The rest of the direction's data is then ignored, as its
+	// framing is lost.
+	Error func(s *Stream, dir reassembly.TCPFlowDirection, err error)
+}
+
+// New implements reassembly.StreamFactory.
+func (f *StreamFactory) New(netFlow, tcpFlow gopacket.Flow, tcp *layers.TCP, ac reassembly.AssemblerContext) reassembly.Stream {
+	return &Stream{Net: netFlow, Transport: tcpFlow, factory: f}
+}
+
+// Stream is a reassembly.Stream which parses the length-prefixed DNS
+// messages of a TCP connection, see RFC 1035 section 4.2.2.
+type Stream struct {
+	// Net and Transport are the flows of the connection's first packet.
+	Net, Transport gopacket.Flow
+
+	factory *StreamFactory
+	// buf holds the start of the next message of each direction, and lost
+	// is set for directions whose data was lost.
+	buf  [2][]byte
+	lost [2]bool
+}
+
+func dirIndex(dir reassembly.TCPFlowDirection) int {
+	if dir == reassembly.TCPDirClientToServer {
+		return 0
+	}
+	return 1
+}
+
+// Accept implements reassembly.Stream, accepting all packets.  Streams
+// whose SYN wasn't seen start at their first packet, in the hope that it
+// starts a message.
+func (s *Stream) Accept(tcp *layers.TCP, ci gopacket.CaptureInfo, dir reassembly.TCPFlowDirection, nextSeq reassembly.Sequence, start *bool, ac reassembly.AssemblerContext) bool {
+	if nextSeq == -1 && !tcp.SYN {
+		*start = true
+	}
+	return true
+}
+
+// ReassembledSG implements reassembly.Stream.  Since messages can't be
+// found in the middle of a stream, a direction is ignored after data of it
+// is lost.
+func (s *Stream) ReassembledSG(sg reassembly.ScatterGather, ac reassembly.AssemblerContext) {
+	dir, _, _, skip := sg.Info()
+	i := dirIndex(dir)
+	if skip != 0 {
+		s.buf[i], s.lost[i] = nil, true
+	}
+	length, _ := sg.Lengths()
+	if length == 0 || s.lost[i] {
+		return
+	}
+	ci := sg.CaptureInfo(0)
+	if ac != nil {
+		ci = ac.GetCaptureInfo()
+	}
+	netFlow, tcpFlow := s.Net, s.Transport
+	if dir == reassembly.TCPDirServerToClient {
+		netFlow, tcpFlow = netFlow.Reverse(), tcpFlow.Reverse()
+	}
+
+	buf := append(s.buf[i], sg.Fetch(length)...)
+	for len(buf) >= 2 {
+		end := 2 + int(binary.BigEndian.Uint16(buf))
+		if len(buf) < end {
+			break
+		}
+		d := &layers.DNS{}
+		if err := d.DecodeFromBytes(append([]byte(nil), buf[2:end]...), gopacket.NilDecodeFeedback); err != nil {
+			s.buf[i], s.lost[i] = nil, true
+			if s.factory.Error != nil {
+				s.factory.Error(s, dir, err)
+			}
+			return
+		}
+		s.factory.Tracker.AddMessage(ci.Timestamp, netFlow, tcpFlow, layers.IPProtocolTCP, d)
+		buf = buf[end:]
+	}
+	s.buf[i] = append([]byte(nil), buf...)
+}
+
+// ReassemblyComplete implements reassembly.Stream.  The incomplete messages
+// at the end of the stream are dropped.
+func (s *Stream) ReassemblyComplete(ac reassembly.AssemblerContext) bool {
+	s.buf = [2][]byte{}
+	return true
+}
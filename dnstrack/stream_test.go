@@ -0,0 +1,142 @@
+// Copyright 2019 The GoPacket Authors. All rights reserved.
+//
+// Use of this source code is governed by a BSD-style license
+// that can be found in the LICENSE file in the root of the source
+// tree.
+
+package dnstrack
+
+import (
+	"encoding/binary"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+	"github.com/google/gopacket/reassembly"
+)
+
+type testContext gopacket.CaptureInfo
+
+func (c *testContext) GetCaptureInfo() gopacket.CaptureInfo {
+	return gopacket.CaptureInfo(*c)
+}
+
+// testConn sends TCP segments of a connection from the client to the
+// resolver's port 53 to an assembler.
+type testConn struct {
+	a   *reassembly.Assembler
+	seq [2]uint32
+}
+
+func (c *testConn) send(toClient bool, at time.Duration, tcp layers.TCP) {
+	flow := gopacket.NewFlow(layers.EndpointIPv4, client, resolver)
+	i := 0
+	tcp.SrcPort, tcp.DstPort = 40000, 53
+	if toClient {
+		i = 1
+		flow = flow.Reverse()
+		tcp.SrcPort, tcp.DstPort = 53, 40000
+	}
+	tcp.Seq = c.seq[i]
+	tcp.ACK = !tcp.SYN || i == 1
+	tcp.Ack = c.seq[1-i]
+	c.seq[i] += uint32(len(tcp.Payload))
+	if tcp.SYN || tcp.FIN {
+		c.seq[i]++
+	}
+	// Decoding the segment sets the ports of its transport flow.
+	buf := gopacket.NewSerializeBuffer()
+	if err := gopacket.SerializeLayers(buf, gopacket.SerializeOptions{FixLengths: true}, &tcp, gopacket.Payload(tcp.Payload)); err != nil {
+		panic(err)
+	}
+	var decoded layers.TCP
+	if err := decoded.DecodeFromBytes(buf.Bytes(), gopacket.NilDecodeFeedback); err != nil {
+		panic(err)
+	}
+	c.a.AssembleWithContext(flow, &decoded, &testContext{Timestamp: t0.Add(at)})
+}
+
+func (c *testConn) data(toClient bool, at time.Duration, data []byte) {
+	c.send(toClient, at, layers.TCP{BaseLayer: layers.BaseLayer{Payload: data}})
+}
+
+// framed returns the serialized messages, each prefixed by its length.
+func framed(t *testing.T, ds ...*layers.DNS) []byte {
+	var data []byte
+	for _, d := range ds {
+		buf := gopacket.NewSerializeBuffer()
+		if err := d.SerializeTo(buf, gopacket.SerializeOptions{FixLengths: true}); err != nil {
+			t.Fatal(err)
+		}
+		data = append(data, 0, 0)
+		binary.BigEndian.PutUint16(data[len(data)-2:], uint16(len(buf.Bytes())))
+		data = append(data, buf.Bytes()...)
+	}
+	return data
+}
+
+func TestStream(t *testing.T) {
+	var ended []*Transaction
+	var errs []error
+	tracker := New(Config{Transaction: func(tr *Transaction) { ended = append(ended, tr) }})
+	f := &StreamFactory{
+		Tracker: tracker,
+		Error: func(s *Stream, dir reassembly.TCPFlowDirection, err error) {
+			errs = append(errs, err)
+		},
+	}
+	c := &testConn{a: reassembly.NewAssembler(reassembly.NewStreamPool(f)), seq: [2]uint32{1000, 5000}}
+	c.send(false, 0, layers.TCP{SYN: true})
+	c.send(true, time.Millisecond, layers.TCP{SYN: true})
+	// Two pipelined queries, the second split across segments.
+	queries := framed(t, testQuery(1, "a.example"), testQuery(2, "b.example"))
+	c.data(false, 2*time.Millisecond, queries[:40])
+	c.data(false, 3*time.Millisecond, queries[40:])
+	if tracker.Len() != 2 {
+		t.Fatalf("%d pending", tracker.Len())
+	}
+	// Responses out of order, in one segment.
+	answer := layers.DNSResourceRecord{Name: []byte("a.example"), Type: layers.DNSTypeAAAA, Class: layers.DNSClassIN, IP: net.ParseIP("2001:db8::1")}
+	c.data(true, 30*time.Millisecond, framed(t, testResponse(2, "b.example", layers.DNSResponseCodeServFail), testResponse(1, "a.example", 0, answer)))
+	// A lost segment ends the parsing of the direction.
+	c.seq[0] += 10
+	c.data(false, 40*time.Millisecond, framed(t, testQuery(3, "c.example")))
+	c.send(false, 50*time.Millisecond, layers.TCP{FIN: true})
+	c.send(true, 50*time.Millisecond, layers.TCP{FIN: true})
+	c.a.FlushAll()
+
+	if len(ended) != 2 || tracker.Len() != 0 || len(errs) != 0 {
+		t.Fatalf("ended %v, %d pending, errors %v", ended, tracker.Len(), errs)
+	}
+	if tr := ended[0]; tr.Key.ID != 2 || tr.Status != StatusAnswered || tr.RTT != 27*time.Millisecond || tr.ResponseCode != layers.DNSResponseCodeServFail {
+		t.Errorf("first transaction %v", tr)
+	}
+	tr := ended[1]
+	if tr.Key.ID != 1 || tr.RTT != 28*time.Millisecond || len(tr.Answers) != 1 || tr.Answers[0] != "AAAA 2001:db8::1" {
+		t.Errorf("second transaction %v", tr)
+	}
+	if tr.Key.Protocol != layers.IPProtocolTCP || tr.Key.Transport.String() != "40000->53" || tr.Key.Network.Dst().String() != "10.0.0.53" {
+		t.Errorf("key %v", tr.Key)
+	}
+}
+
+func TestStreamError(t *testing.T) {
+	var errs []error
+	tracker := New(Config{})
+	f := &StreamFactory{
+		Tracker: tracker,
+		Error: func(s *Stream, dir reassembly.TCPFlowDirection, err error) {
+			errs = append(errs, err)
+		},
+	}
+	c := &testConn{a: reassembly.NewAssembler(reassembly.NewStreamPool(f)), seq: [2]uint32{1000, 5000}}
+	c.send(false, 0, layers.TCP{SYN: true})
+	c.data(false, time.Millisecond, []byte{0, 3, 1, 2, 3})
+	c.data(false, 2*time.Millisecond, framed(t, testQuery(1, "a.example")))
+	c.a.FlushAll()
+	if len(errs) != 1 || tracker.Len() != 0 {
+		t.Errorf("errors %v, %d pending", errs, tracker.Len())
+	}
+}
@@ -130,6 +130,20 @@ the many caveats above that for some implementations either or both may be
 dangerous.
 
 
+Checksum Verification
+
+Checksums are computed when serializing, but by default they aren't checked
+when decoding.  Setting VerifyChecksums makes each layer that implements
+ChecksumVerifier (IPv4, TCP, UDP, ICMP, SCTP, ...) check its checksum as it's
+decoded, storing the result in the layer.  A bad checksum doesn't stop
+decoding, so ErrorLayer still only reports decoding failures.
+
+ p := gopacket.NewPacket(data, layers.LayerTypeEthernet, gopacket.DecodeOptions{VerifyChecksums: true})
+ if tcp, ok := p.Layer(layers.LayerTypeTCP).(*layers.TCP); ok {
+   fmt.Println("TCP checksum", tcp.ChecksumStatus)
+ }
+
+
 Pointers To Known Layers
 
 During decoding, certain layers are stored in the packet as well-known
@@ -0,0 +1,82 @@
+// Copyright 2019 The GoPacket Authors. All rights reserved.
+//
+// Use of this source code is governed by a BSD-style license
+// that can be found in the LICENSE file in the root of the source
+// tree.
+
+// The harexport binary reads a pcap or pcapng file, reassembles the HTTP/1.x
+// transactions in it, and writes them as an HTTP Archive (HAR) which
+// browser developer tools can open.  It needs no libpcap.
+package main
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/json"
+	"flag"
+	"io"
+	"log"
+	"os"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/examples/util"
+	"github.com/google/gopacket/layers"
+	"github.com/google/gopacket/pcapgo"
+	"github.com/google/gopacket/reassembly/httpstream"
+)
+
+var fname = flag.String("r", "", "Filename to read from")
+var output = flag.String("o", "", "Filename to write the HAR to, instead of stdout")
+var maxBody = flag.Int("maxbody", 1<<20, "Maximum number of bytes kept of each body, 0 for no limit")
+
+type packetReader interface {
+	gopacket.PacketDataSource
+	LinkType() layers.LinkType
+}
+
+// openCapture returns a reader of a pcap or pcapng file.
+func openCapture(r io.Reader) (packetReader, error) {
+	br := bufio.NewReader(r)
+	magic, err := br.Peek(4)
+	if err != nil {
+		return nil, err
+	}
+	// The section header block type of pcapng.
+	if binary.BigEndian.Uint32(magic) == 0x0a0d0d0a {
+		return pcapgo.NewNgReader(br, pcapgo.DefaultNgReaderOptions)
+	}
+	return pcapgo.NewReader(br)
+}
+
+func main() {
+	defer util.Run()()
+	if *fname == "" {
+		log.Fatal("No file to read from, use -r")
+	}
+	f, err := os.Open(*fname)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer f.Close()
+	r, err := openCapture(f)
+	if err != nil {
+		log.Fatal(err)
+	}
+	source := gopacket.NewPacketSource(r, r.LinkType())
+	source.Lazy = true
+	har := httpstream.ReadHAR(source.Packets(), *maxBody)
+
+	w := os.Stdout
+	if *output != "" {
+		if w, err = os.Create(*output); err != nil {
+			log.Fatal(err)
+		}
+		defer w.Close()
+	}
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(har); err != nil {
+		log.Fatal(err)
+	}
+	log.Printf("%d entries", len(har.Log.Entries))
+}
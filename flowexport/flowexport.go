@@ -0,0 +1,425 @@
+// Copyright 2019 The GoPacket Authors. All rights reserved.
+//
+// Use of this source code is governed by a BSD-style license
+// that can be found in the LICENSE file in the root of the source
+// tree.
+
+// Package flowexport exports flow records to IPFIX or NetFlow v9 collectors.
+//
+// An Exporter packs records into messages no larger than the MTU, preceded
+// by the templates describing them, which it sends again periodically since
+// collectors listening on UDP may have missed them.  Messages are handed to
+// an output function as layers.IPFIX or layers.NetFlowV9 layers, which can
+// be serialized on their own, to send them on a UDP socket, or under
+// Ethernet, IP and UDP layers, to write them to a pcap file:
+//
+//	conn, err := net.Dial("udp", "collector:4739")
+//	...
+//	exporter, err := flowexport.New(flowexport.Config{
+//		Output: flowexport.WriterOutput(conn),
+//	})
+//	...
+//	table := flowtable.New(flowtable.Config{
+//		Expired: func(f *flowtable.Flow) {
+//			if err := exporter.AddFlow(f); err != nil {
+//				...
+//			}
+//		},
+//	})
+//	for packet := range packetSource.Packets() {
+//		table.AddPacket(packet)
+//	}
+//	table.Flush()
+//	exporter.Flush(time.Now())
+//
+// Records of other templates can be exported with AddTemplate and Add.
+//
+// Exporters use packet time, as flowtable does: the times passed to Add and
+// Flush date messages and decide when templates are resent.
+package flowexport
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/flowtable"
+	"github.com/google/gopacket/layers"
+)
+
+// Defaults for Config fields left zero.
+const (
+	DefaultMTU              = 1500
+	DefaultTemplateInterval = time.Minute
+)
+
+// headerRoom is the room left in the MTU for IPv6 and UDP headers.
+const headerRoom = 40 + 8
+
+// Config configures an Exporter.
+type Config struct {
+	// Version is 10 for IPFIX, the default, or 9 for NetFlow v9.
+	Version uint16
+	// ObservationDomainID is the observation domain of the messages, known
+	// as the source ID in NetFlow v9.
+	ObservationDomainID uint32
+	// MTU bounds the size of messages, which leave room for IP and UDP
+	// headers.  If zero, DefaultMTU is used.
+	MTU int
+	// TemplateInterval is how often templates are sent again.  If zero,
+	// DefaultTemplateInterval is used.
+	TemplateInterval time.Duration
+	// Output is called with each message, a *layers.IPFIX or a
+	// *layers.NetFlowV9 whose lengths are already set.  It must not keep
+	// the message after it returns.
+	Output func(gopacket.SerializableLayer) error
+}
+
+// Exporter packs records into IPFIX or NetFlow v9 messages.  It is not safe
+// for concurrent use.
+type Exporter struct {
+	config    Config
+	maxSize   int
+	header    int
+	templates map[uint16]layers.IPFIXTemplate
+	// order holds the template IDs in the order they were added.
+	order []uint16
+	// unsent holds the IDs of templates added since templates were last
+	// sent.
+	unsent        []uint16
+	templatesSent time.Time
+	// start is the first time seen, which NetFlow v9 uptimes count from.
+	start time.Time
+	// sets holds the message being built, of size bytes.
+	sets    []layers.IPFIXSet
+	size    int
+	count   int
+	records int
+	// sequence is the number of data records sent for IPFIX, and of
+	// messages sent for NetFlow v9.
+	sequence uint32
+}
+
+// New returns an Exporter.
+func New(config Config) (*Exporter, error) {
+	if config.Version == 0 {
+		config.Version = 10
+	}
+	if config.MTU == 0 {
+		config.MTU = DefaultMTU
+	}
+	if config.TemplateInterval == 0 {
+		config.TemplateInterval = DefaultTemplateInterval
+	}
+	e := &Exporter{config: config, templates: make(map[uint16]layers.IPFIXTemplate)}
+	switch config.Version {
+	case 9:
+		e.header = 20
+	case 10:
+		e.header = 16
+	default:
+		return nil, fmt.Errorf("unsupported version %d", config.Version)
+	}
+	if config.Output == nil {
+		return nil, errors.New("no Output")
+	}
+	e.maxSize = config.MTU - headerRoom
+	if e.maxSize > 0xffff {
+		e.maxSize = 0xffff
+	}
+	if e.maxSize < e.header+4+flowRecordLength(&FlowTemplateIPv6) {
+		return nil, fmt.Errorf("MTU %d too small", config.MTU)
+	}
+	e.size = e.header
+	return e, nil
+}
+
+// WriterOutput returns an Output function which serializes messages and
+// writes each with one call to w, such as a UDP connection.
+func WriterOutput(w io.Writer) func(gopacket.SerializableLayer) error {
+	buf := gopacket.NewSerializeBuffer()
+	return func(msg gopacket.SerializableLayer) error {
+		if err := gopacket.SerializeLayers(buf, gopacket.SerializeOptions{}, msg); err != nil {
+			return err
+		}
+		_, err := w.Write(buf.Bytes())
+		return err
+	}
+}
+
+// AddTemplate adds or replaces a template, which is sent before the next
+// record.  Options templates have a non-zero ScopeFieldCount.
+func (e *Exporter) AddTemplate(t layers.IPFIXTemplate) error {
+	if t.ID < layers.IPFIXMinDataSetID {
+		return fmt.Errorf("invalid template ID %d", t.ID)
+	}
+	if len(t.Fields) == 0 || int(t.ScopeFieldCount) > len(t.Fields) {
+		return fmt.Errorf("template %d has %d fields and %d scope fields", t.ID, len(t.Fields), t.ScopeFieldCount)
+	}
+	for _, f := range t.Fields {
+		if e.config.Version == 9 && (f.EnterpriseNumber != 0 || f.Length == layers.IPFIXVariableLength) {
+			return fmt.Errorf("template %d field %v is not supported by NetFlow v9", t.ID, f.ID)
+		}
+	}
+	if e.header+4+templateLength(&t) > e.maxSize {
+		return fmt.Errorf("template %d too large for the MTU", t.ID)
+	}
+	if _, ok := e.templates[t.ID]; !ok {
+		e.order = append(e.order, t.ID)
+	}
+	t.Fields = append([]layers.IPFIXFieldSpecifier(nil), t.Fields...)
+	e.templates[t.ID] = t
+	e.unsent = append(e.unsent, t.ID)
+	return nil
+}
+
+// Add adds a record of a template, with a value for each of its fields as
+// taken by layers.IPFIXField.SetValue.  If the record doesn't fit in the
+// message being built, that message is sent first.
+func (e *Exporter) Add(now time.Time, templateID uint16, values ...interface{}) error {
+	t, ok := e.templates[templateID]
+	if !ok {
+		return fmt.Errorf("unknown template %d", templateID)
+	}
+	if len(values) != len(t.Fields) {
+		return fmt.Errorf("template %d has %d fields, got %d values", templateID, len(t.Fields), len(values))
+	}
+	r := layers.IPFIXRecord{TemplateID: templateID, Fields: make([]layers.IPFIXField, len(t.Fields))}
+	length := 0
+	for i, spec := range t.Fields {
+		f := &r.Fields[i]
+		f.IPFIXFieldSpecifier = spec
+		f.Scope = i < int(t.ScopeFieldCount)
+		if err := f.SetValue(values[i]); err != nil {
+			return fmt.Errorf("template %d: %v", templateID, err)
+		}
+		length += fieldLength(f)
+	}
+	if err := e.sendTemplates(now, false); err != nil {
+		return err
+	}
+	set, err := e.appendSet(now, templateID, length)
+	if err != nil {
+		return err
+	}
+	set.Records = append(set.Records, r)
+	e.count++
+	e.records++
+	return nil
+}
+
+// Flush sends the message being built, and the templates if they are due.
+func (e *Exporter) Flush(now time.Time) error {
+	if err := e.sendTemplates(now, false); err != nil {
+		return err
+	}
+	if len(e.sets) == 0 {
+		return nil
+	}
+	return e.send(now)
+}
+
+// SendTemplates sends all templates in the next message, whether they are
+// due or not, such as when a collector restarts.
+func (e *Exporter) SendTemplates(now time.Time) error {
+	return e.sendTemplates(now, true)
+}
+
+func (e *Exporter) sendTemplates(now time.Time, force bool) error {
+	if e.start.IsZero() {
+		e.start = now
+	}
+	ids := e.order
+	if !force && now.Sub(e.templatesSent) < e.config.TemplateInterval {
+		// Only send templates added since.
+		ids = e.unsent
+	}
+	for _, id := range ids {
+		t := e.templates[id]
+		setID := layers.IPFIXTemplateSetID
+		switch {
+		case e.config.Version == 9 && t.ScopeFieldCount > 0:
+			setID = layers.NetFlowV9OptionsTemplateSetID
+		case e.config.Version == 9:
+			setID = layers.NetFlowV9TemplateSetID
+		case t.ScopeFieldCount > 0:
+			setID = layers.IPFIXOptionsTemplateSetID
+		}
+		set, err := e.appendSet(now, setID, templateLength(&t))
+		if err != nil {
+			return err
+		}
+		set.Templates = append(set.Templates, t)
+		e.count++
+	}
+	if len(ids) == len(e.order) {
+		e.templatesSent = now
+	}
+	e.unsent = nil
+	return nil
+}
+
+// appendSet makes room for length bytes in a set with the given ID at the
+// end of the message, sending the message first if they don't fit.
+func (e *Exporter) appendSet(now time.Time, id uint16, length int) (*layers.IPFIXSet, error) {
+	if n := len(e.sets); n > 0 && e.sets[n-1].ID == id && e.size+length <= e.maxSize {
+		e.size += length
+		return &e.sets[n-1], nil
+	}
+	if e.size+4+length > e.maxSize && len(e.sets) > 0 {
+		if err := e.send(now); err != nil {
+			return nil, err
+		}
+	}
+	if e.size+4+length > e.maxSize {
+		return nil, fmt.Errorf("%d bytes of set %d too large for the MTU", length, id)
+	}
+	e.sets = append(e.sets, layers.IPFIXSet{ID: id})
+	e.size += 4 + length
+	return &e.sets[len(e.sets)-1], nil
+}
+
+func (e *Exporter) send(now time.Time) error {
+	var msg gopacket.SerializableLayer
+	if e.config.Version == 9 {
+		msg = &layers.NetFlowV9{
+			Version:        9,
+			Count:          uint16(e.count),
+			SysUptime:      uint32(now.Sub(e.start) / time.Millisecond),
+			UnixSecs:       uint32(now.Unix()),
+			SequenceNumber: e.sequence,
+			SourceID:       e.config.ObservationDomainID,
+			FlowSets:       e.sets,
+		}
+		e.sequence++
+	} else {
+		msg = &layers.IPFIX{
+			Version:             10,
+			Length:              uint16(e.size),
+			ExportTime:          uint32(now.Unix()),
+			SequenceNumber:      e.sequence,
+			ObservationDomainID: e.config.ObservationDomainID,
+			Sets:                e.sets,
+		}
+		e.sequence += uint32(e.records)
+	}
+	e.sets, e.size, e.count, e.records = nil, e.header, 0, 0
+	return e.config.Output(msg)
+}
+
+func templateLength(t *layers.IPFIXTemplate) int {
+	n := 4
+	if t.ScopeFieldCount > 0 {
+		n += 2
+	}
+	for _, f := range t.Fields {
+		n += 4
+		if f.EnterpriseNumber != 0 {
+			n += 4
+		}
+	}
+	return n
+}
+
+func fieldLength(f *layers.IPFIXField) int {
+	switch {
+	case f.Length != layers.IPFIXVariableLength:
+		return int(f.Length)
+	case len(f.Data) < 255:
+		return 1 + len(f.Data)
+	default:
+		return 3 + len(f.Data)
+	}
+}
+
+// The IDs of the templates of AddFlow.
+const (
+	FlowTemplateIPv4ID uint16 = 256
+	FlowTemplateIPv6ID uint16 = 257
+)
+
+func flowTemplate(id uint16, src, dst layers.IPFIXElementID, addrLength uint16) layers.IPFIXTemplate {
+	return layers.IPFIXTemplate{ID: id, Fields: []layers.IPFIXFieldSpecifier{
+		{ID: src, Length: addrLength},
+		{ID: dst, Length: addrLength},
+		{ID: layers.IPFIXSourceTransportPort, Length: 2},
+		{ID: layers.IPFIXDestinationTransportPort, Length: 2},
+		{ID: layers.IPFIXProtocolIdentifier, Length: 1},
+		{ID: layers.IPFIXTCPControlBits, Length: 2},
+		{ID: layers.IPFIXPacketDeltaCount, Length: 8},
+		{ID: layers.IPFIXOctetDeltaCount, Length: 8},
+		{ID: layers.IPFIXFlowStartMilliseconds, Length: 8},
+		{ID: layers.IPFIXFlowEndMilliseconds, Length: 8},
+		{ID: layers.IPFIXFlowEndReason, Length: 1},
+	}}
+}
+
+// The templates of AddFlow, which adds them when it first needs them.
+var (
+	FlowTemplateIPv4 = flowTemplate(FlowTemplateIPv4ID, layers.IPFIXSourceIPv4Address, layers.IPFIXDestinationIPv4Address, 4)
+	FlowTemplateIPv6 = flowTemplate(FlowTemplateIPv6ID, layers.IPFIXSourceIPv6Address, layers.IPFIXDestinationIPv6Address, 16)
+)
+
+func flowRecordLength(t *layers.IPFIXTemplate) int {
+	n := 0
+	for _, f := range t.Fields {
+		n += int(f.Length)
+	}
+	return n
+}
+
+// Flow end reasons, as defined by RFC 5102.
+var flowEndReasons = map[flowtable.ExpiryReason]uint8{
+	flowtable.ExpiryNone:    0x04, // forced end
+	flowtable.ExpiryIdle:    0x01, // idle timeout
+	flowtable.ExpiryActive:  0x02, // active timeout
+	flowtable.ExpiryEvicted: 0x05, // lack of resources
+	flowtable.ExpiryFlushed: 0x04, // forced end
+}
+
+// AddFlow adds a record of each direction of a flow which saw packets,
+// using FlowTemplateIPv4 or FlowTemplateIPv6 and the time of its last
+// packet.  Flows which aren't IP flows are ignored.
+func (e *Exporter) AddFlow(f *flowtable.Flow) error {
+	var t *layers.IPFIXTemplate
+	switch f.Key.Network.EndpointType() {
+	case layers.EndpointIPv4:
+		t = &FlowTemplateIPv4
+	case layers.EndpointIPv6:
+		t = &FlowTemplateIPv6
+	default:
+		return nil
+	}
+	if _, ok := e.templates[t.ID]; !ok {
+		if err := e.AddTemplate(*t); err != nil {
+			return err
+		}
+	}
+	key := f.Key
+	for _, c := range []flowtable.Counters{f.Forward, f.Reverse} {
+		if c.Packets > 0 {
+			src, dst := key.Network.Endpoints()
+			var sport, dport uint16
+			if key.Transport.EndpointType() != gopacket.EndpointInvalid {
+				s, d := key.Transport.Endpoints()
+				sport, dport = portOf(s), portOf(d)
+			}
+			if err := e.Add(f.Last, t.ID,
+				src.Raw(), dst.Raw(), sport, dport, uint8(key.Protocol), uint16(c.TCPFlags),
+				c.Packets, c.Bytes, f.First, f.Last, flowEndReasons[f.Reason]); err != nil {
+				return err
+			}
+		}
+		key = key.Reverse()
+	}
+	return nil
+}
+
+func portOf(e gopacket.Endpoint) uint16 {
+	if raw := e.Raw(); len(raw) == 2 {
+		return uint16(raw[0])<<8 | uint16(raw[1])
+	}
+	return 0
+}
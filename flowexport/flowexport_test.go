@@ -0,0 +1,362 @@
+// Copyright 2019 The GoPacket Authors. All rights reserved.
+//
+// Use of this source code is governed by a BSD-style license
+// that can be found in the LICENSE file in the root of the source
+// tree.
+
+package flowexport
+
+import (
+	"bytes"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/flowtable"
+	"github.com/google/gopacket/layers"
+)
+
+var (
+	exporterIP = net.IP{192, 0, 2, 1}
+	t0         = time.Unix(1500000000, 0)
+)
+
+// collector serializes the messages of an Exporter and decodes them again.
+type collector struct {
+	t        *testing.T
+	decoder  *layers.IPFIXDecoder
+	messages [][]byte
+	sets     [][]layers.IPFIXSet
+}
+
+func newCollector(t *testing.T) *collector {
+	return &collector{t: t, decoder: layers.NewIPFIXDecoder()}
+}
+
+func (c *collector) output(msg gopacket.SerializableLayer) error {
+	buf := gopacket.NewSerializeBuffer()
+	if err := gopacket.SerializeLayers(buf, gopacket.SerializeOptions{}, msg); err != nil {
+		return err
+	}
+	data := append([]byte(nil), buf.Bytes()...)
+	c.messages = append(c.messages, data)
+	switch msg.(type) {
+	case *layers.IPFIX:
+		var ix layers.IPFIX
+		if err := c.decoder.DecodeIPFIX(exporterIP, data, &ix); err != nil {
+			c.t.Fatal(err)
+		}
+		c.sets = append(c.sets, ix.Sets)
+	case *layers.NetFlowV9:
+		var n layers.NetFlowV9
+		if err := c.decoder.DecodeNetFlowV9(exporterIP, data, &n); err != nil {
+			c.t.Fatal(err)
+		}
+		if int(n.Count) != countOf(n.FlowSets) {
+			c.t.Errorf("NetFlow v9 count %d for %d templates and records", n.Count, countOf(n.FlowSets))
+		}
+		c.sets = append(c.sets, n.FlowSets)
+	}
+	return nil
+}
+
+func countOf(sets []layers.IPFIXSet) int {
+	n := 0
+	for _, s := range sets {
+		n += len(s.Templates) + len(s.Records)
+	}
+	return n
+}
+
+// records returns the decoded records of all messages, failing if any
+// data set wasn't decoded.
+func (c *collector) records() []layers.IPFIXRecord {
+	var records []layers.IPFIXRecord
+	for _, sets := range c.sets {
+		for _, s := range sets {
+			if s.ID >= layers.IPFIXMinDataSetID && s.Records == nil {
+				c.t.Fatalf("data set %d not decoded", s.ID)
+			}
+			records = append(records, s.Records...)
+		}
+	}
+	return records
+}
+
+func TestRoundTrip(t *testing.T) {
+	c := newCollector(t)
+	e, err := New(Config{ObservationDomainID: 7, Output: c.output})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := layers.IPFIXTemplate{ID: 300, Fields: []layers.IPFIXFieldSpecifier{
+		{ID: layers.IPFIXSourceIPv6Address, Length: 16},
+		{ID: layers.IPFIXSourceMacAddress, Length: 6},
+		{ID: layers.IPFIXOctetDeltaCount, Length: 4},
+		{ID: layers.IPFIXInterfaceName, Length: layers.IPFIXVariableLength},
+		{ID: layers.IPFIXFlowStartMicroseconds, Length: 8},
+		{ID: 1, Length: 3, EnterpriseNumber: 32473},
+	}}
+	options := layers.IPFIXTemplate{ID: 301, ScopeFieldCount: 1, Fields: []layers.IPFIXFieldSpecifier{
+		{ID: layers.IPFIXExportingProcessID, Length: 4},
+		{ID: layers.IPFIXExportedFlowRecordTotalCount, Length: 8},
+	}}
+	for _, tmpl := range []layers.IPFIXTemplate{tmpl, options} {
+		if err := e.AddTemplate(tmpl); err != nil {
+			t.Fatal(err)
+		}
+	}
+	mac := net.HardwareAddr{0, 1, 2, 3, 4, 5}
+	start := t0.Add(1500 * time.Microsecond)
+	if err := e.Add(t0, 300, net.ParseIP("2001:db8::1"), mac, 5000, "eth0", start, []byte{1, 2, 3}); err != nil {
+		t.Fatal(err)
+	}
+	if err := e.Add(t0, 301, 1, uint64(1)); err != nil {
+		t.Fatal(err)
+	}
+	if err := e.Add(t0, 300, 1, 2, 3, 4, 5, 6); err == nil {
+		t.Error("no error for invalid values")
+	}
+	if err := e.Add(t0, 300, nil, nil); err == nil {
+		t.Error("no error for the wrong number of values")
+	}
+	if err := e.Add(t0, 302); err == nil {
+		t.Error("no error for an unknown template")
+	}
+	if len(c.messages) != 0 {
+		t.Fatal("message sent before Flush")
+	}
+	if err := e.Flush(t0); err != nil {
+		t.Fatal(err)
+	}
+	if len(c.messages) != 1 {
+		t.Fatalf("sent %d messages", len(c.messages))
+	}
+	records := c.records()
+	if len(records) != 2 {
+		t.Fatalf("got %d records", len(records))
+	}
+	r := records[0]
+	if f, _ := r.Get(layers.IPFIXSourceIPv6Address); !f.Value.(net.IP).Equal(net.ParseIP("2001:db8::1")) {
+		t.Errorf("got address %v", f.Value)
+	}
+	if f, _ := r.Get(layers.IPFIXSourceMacAddress); f.Value.(net.HardwareAddr).String() != mac.String() {
+		t.Errorf("got MAC %v", f.Value)
+	}
+	if f, _ := r.Get(layers.IPFIXOctetDeltaCount); f.Value != uint64(5000) {
+		t.Errorf("got octets %#v", f.Value)
+	}
+	if f, _ := r.Get(layers.IPFIXInterfaceName); f.Value != "eth0" {
+		t.Errorf("got interface %#v", f.Value)
+	}
+	if f, _ := r.Get(layers.IPFIXFlowStartMicroseconds); !f.Value.(time.Time).Equal(start) {
+		t.Errorf("got start %v, want %v", f.Value, start)
+	}
+	if f := r.Fields[5]; f.EnterpriseNumber != 32473 || !bytes.Equal(f.Data, []byte{1, 2, 3}) {
+		t.Errorf("got enterprise field %+v", f)
+	}
+	if o := records[1]; !o.Fields[0].Scope || o.Fields[1].Value != uint64(1) {
+		t.Errorf("got options record %+v", o)
+	}
+}
+
+func flow(src, dst net.IP, sport, dport uint16, packets uint64) *flowtable.Flow {
+	network := layers.EndpointIPv4
+	if src.To4() == nil {
+		network = layers.EndpointIPv6
+	} else {
+		src, dst = src.To4(), dst.To4()
+	}
+	return &flowtable.Flow{
+		Key: flowtable.Key{
+			Network:   gopacket.NewFlow(network, src, dst),
+			Transport: gopacket.NewFlow(layers.EndpointUDPPort, []byte{byte(sport >> 8), byte(sport)}, []byte{byte(dport >> 8), byte(dport)}),
+			Protocol:  layers.IPProtocolUDP,
+		},
+		Forward: flowtable.Counters{Packets: packets, Bytes: packets * 100},
+		Reverse: flowtable.Counters{Packets: 1, Bytes: 60},
+		First:   t0,
+		Last:    t0.Add(time.Second),
+		Reason:  flowtable.ExpiryIdle,
+	}
+}
+
+func TestSplitting(t *testing.T) {
+	for _, version := range []uint16{9, 10} {
+		c := newCollector(t)
+		const mtu = 400
+		e, err := New(Config{Version: version, MTU: mtu, Output: c.output})
+		if err != nil {
+			t.Fatal(err)
+		}
+		for i := 0; i < 20; i++ {
+			f := flow(net.IP{10, 0, 0, 1}, net.IP{10, 0, 0, 2}, uint16(1000+i), 53, uint64(i+1))
+			if i%2 == 1 {
+				f = flow(net.ParseIP("2001:db8::1"), net.ParseIP("2001:db8::2"), uint16(1000+i), 53, uint64(i+1))
+			}
+			if err := e.AddFlow(f); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err := e.Flush(t0.Add(time.Second)); err != nil {
+			t.Fatal(err)
+		}
+		if len(c.messages) < 2 {
+			t.Fatalf("version %d: sent %d messages", version, len(c.messages))
+		}
+		for i, m := range c.messages {
+			if len(m) > mtu-headerRoom {
+				t.Errorf("version %d: message %d of %d bytes", version, i, len(m))
+			}
+		}
+		records := c.records()
+		if len(records) != 40 {
+			t.Fatalf("version %d: got %d records", version, len(records))
+		}
+		// Records alternate between forward and reverse directions.
+		for i := 0; i < 40; i += 2 {
+			fwd, rev := records[i], records[i+1]
+			if f, _ := fwd.Get(layers.IPFIXSourceTransportPort); f.Value != uint64(1000+i/2) {
+				t.Errorf("version %d: record %d port %v", version, i, f.Value)
+			}
+			if f, _ := fwd.Get(layers.IPFIXPacketDeltaCount); f.Value != uint64(i/2+1) {
+				t.Errorf("version %d: record %d packets %v", version, i, f.Value)
+			}
+			if f, _ := rev.Get(layers.IPFIXDestinationTransportPort); f.Value != uint64(1000+i/2) {
+				t.Errorf("version %d: record %d reverse port %v", version, i+1, f.Value)
+			}
+			if f, _ := rev.Get(layers.IPFIXFlowEndReason); f.Value != uint64(1) {
+				t.Errorf("version %d: record %d end reason %v", version, i+1, f.Value)
+			}
+		}
+		// IPFIX sequence numbers count records, and NetFlow v9 ones
+		// messages.
+		var ix layers.IPFIX
+		var n layers.NetFlowV9
+		if version == 10 {
+			ix.DecodeFromBytes(c.messages[1], gopacket.NilDecodeFeedback)
+			records := 0
+			for _, s := range c.sets[0] {
+				records += len(s.Records)
+			}
+			if int(ix.SequenceNumber) != records {
+				t.Errorf("IPFIX sequence number %d", ix.SequenceNumber)
+			}
+		} else {
+			n.DecodeFromBytes(c.messages[1], gopacket.NilDecodeFeedback)
+			if n.SequenceNumber != 1 {
+				t.Errorf("NetFlow v9 sequence number %d", n.SequenceNumber)
+			}
+		}
+	}
+}
+
+func TestTemplateResend(t *testing.T) {
+	c := newCollector(t)
+	e, err := New(Config{TemplateInterval: 10 * time.Second, Output: c.output})
+	if err != nil {
+		t.Fatal(err)
+	}
+	hasTemplates := func(i int) bool {
+		return len(c.sets[i]) > 0 && len(c.sets[i][0].Templates) > 0
+	}
+	add := func(at time.Duration) {
+		f := flow(net.IP{10, 0, 0, 1}, net.IP{10, 0, 0, 2}, 1, 2, 1)
+		f.Last = t0.Add(at)
+		if err := e.AddFlow(f); err != nil {
+			t.Fatal(err)
+		}
+		if err := e.Flush(t0.Add(at)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	add(0)
+	add(5 * time.Second)
+	add(11 * time.Second)
+	if len(c.messages) != 3 || !hasTemplates(0) || hasTemplates(1) || !hasTemplates(2) {
+		t.Errorf("templates not resent: %d messages", len(c.messages))
+	}
+	// Idle exporters send templates on Flush.
+	if err := e.Flush(t0.Add(25 * time.Second)); err != nil {
+		t.Fatal(err)
+	}
+	if len(c.messages) != 4 || !hasTemplates(3) {
+		t.Error("Flush did not send due templates")
+	}
+	// A collector which missed the templates can't decode the records.
+	late := layers.NewIPFIXDecoder()
+	var ix layers.IPFIX
+	if err := late.DecodeIPFIX(exporterIP, c.messages[1], &ix); err != nil {
+		t.Fatal(err)
+	}
+	if ix.Sets[0].Records != nil {
+		t.Error("records decoded without templates")
+	}
+}
+
+func TestPcap(t *testing.T) {
+	var packets []gopacket.Packet
+	e, err := New(Config{Output: func(msg gopacket.SerializableLayer) error {
+		eth := &layers.Ethernet{SrcMAC: net.HardwareAddr{0, 0, 0, 0, 0, 1}, DstMAC: net.HardwareAddr{0, 0, 0, 0, 0, 2}, EthernetType: layers.EthernetTypeIPv4}
+		ip := &layers.IPv4{Version: 4, TTL: 64, Protocol: layers.IPProtocolUDP, SrcIP: exporterIP, DstIP: net.IP{192, 0, 2, 2}}
+		udp := &layers.UDP{SrcPort: 50000, DstPort: 4739}
+		udp.SetNetworkLayerForChecksum(ip)
+		buf := gopacket.NewSerializeBuffer()
+		opts := gopacket.SerializeOptions{FixLengths: true, ComputeChecksums: true}
+		if err := gopacket.SerializeLayers(buf, opts, eth, ip, udp, msg); err != nil {
+			return err
+		}
+		packets = append(packets, gopacket.NewPacket(buf.Bytes(), layers.LayerTypeEthernet, gopacket.Default))
+		return nil
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := e.AddFlow(flow(net.IP{10, 0, 0, 1}, net.IP{10, 0, 0, 2}, 1234, 80, 3)); err != nil {
+		t.Fatal(err)
+	}
+	if err := e.Flush(t0); err != nil {
+		t.Fatal(err)
+	}
+	if len(packets) != 1 {
+		t.Fatalf("sent %d messages", len(packets))
+	}
+	d := layers.NewIPFIXDecoder()
+	if err := d.DecodePacket(packets[0]); err != nil {
+		t.Fatal(err)
+	}
+	ix, ok := packets[0].ApplicationLayer().(*layers.IPFIX)
+	if !ok {
+		t.Fatalf("got layers %v", packets[0].Layers())
+	}
+	if len(ix.Sets) != 2 || len(ix.Sets[1].Records) != 2 {
+		t.Fatalf("got sets %+v", ix.Sets)
+	}
+	if f, _ := ix.Sets[1].Records[0].Get(layers.IPFIXFlowStartMilliseconds); !f.Value.(time.Time).Equal(t0) {
+		t.Errorf("got start %v", f.Value)
+	}
+}
+
+func TestConfig(t *testing.T) {
+	output := func(gopacket.SerializableLayer) error { return nil }
+	for _, c := range []Config{
+		{Version: 5, Output: output},
+		{MTU: 100, Output: output},
+		{},
+	} {
+		if _, err := New(c); err == nil {
+			t.Errorf("no error for %+v", c)
+		}
+	}
+	e, err := New(Config{Version: 9, Output: output})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := e.AddTemplate(layers.IPFIXTemplate{ID: 256, Fields: []layers.IPFIXFieldSpecifier{{ID: 1, Length: 4, EnterpriseNumber: 1}}}); err == nil {
+		t.Error("NetFlow v9 exporter accepted an enterprise element")
+	}
+	if err := e.AddTemplate(layers.IPFIXTemplate{ID: 2, Fields: []layers.IPFIXFieldSpecifier{{ID: 1, Length: 4}}}); err == nil {
+		t.Error("accepted template ID 2")
+	}
+}
@@ -0,0 +1,421 @@
+// Copyright 2019 The GoPacket Authors. All rights reserved.
+//
+// Use of this source code is governed by a BSD-style license
+// that can be found in the LICENSE file in the root of the source
+// tree.
+
+// Package flowtable tracks bidirectional flows, counting the packets and
+// bytes of each direction, in the way of NetFlow or conntrack.
+//
+// Packets are added to a Table, either as gopacket.Packets or as the layers
+// decoded by a gopacket.DecodingLayerParser:
+//
+//	table := flowtable.New(flowtable.Config{
+//		IdleTimeout: 30 * time.Second,
+//		Expired: func(f *flowtable.Flow) {
+//			fmt.Println(f)
+//		},
+//	})
+//	for packet := range packetSource.Packets() {
+//		table.AddPacket(packet)
+//	}
+//	table.Flush()
+//
+// IP packets are grouped into flows by address, transport protocol and, for
+// TCP, UDP, UDP-Lite and SCTP, by port.  ICMP flows have no ports, so all ICMP
+// between two hosts is one flow.  Other packets are grouped by their link
+// layer addresses.  A flow's packets in either direction belong to the same
+// Flow, whose Key is oriented in the direction of the first packet seen.
+//
+// Flows expire when they have been idle for longer than the idle timeout,
+// when they have been active for longer than the active timeout, or when the
+// table is full.  Timeouts are measured by packet timestamps rather than the
+// clock, so that reading a capture file gives the same flows however fast it
+// is read.  Expired flows are handed to the Expired callback.
+package flowtable
+
+import (
+	"container/list"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+)
+
+// Defaults for Config fields left zero.  The timeouts are those of Cisco's
+// NetFlow.
+const (
+	DefaultIdleTimeout = 15 * time.Second
+	DefaultMaxFlows    = 1 << 18
+)
+
+// Config configures a Table.
+type Config struct {
+	// IdleTimeout expires flows which have seen no packets for this long.
+	// If zero, DefaultIdleTimeout is used.
+	IdleTimeout time.Duration
+	// ActiveTimeout, if non-zero, expires flows this long after their first
+	// packet even if they are still active, so that long-lived flows are
+	// reported periodically.  Later packets start a new Flow.
+	ActiveTimeout time.Duration
+	// MaxFlows bounds the number of flows tracked.  When a new flow would
+	// exceed it, the least recently active flow is evicted.  If zero,
+	// DefaultMaxFlows is used.
+	MaxFlows int
+	// Expired, if set, is called with each flow as it expires.  The Flow is
+	// no longer used by the Table, and may be kept.
+	Expired func(*Flow)
+}
+
+// Key identifies a flow.
+type Key struct {
+	// Link is the link layer flow of non-IP packets, and is unset for IP.
+	Link gopacket.Flow
+	// Network is the flow between the IP addresses.
+	Network gopacket.Flow
+	// Transport is the flow between ports, for protocols with ports.
+	Transport gopacket.Flow
+	// Protocol is the IP protocol carried, such as TCP or ICMPv4.
+	Protocol layers.IPProtocol
+}
+
+// Reverse returns the key of the flow in the opposite direction.
+func (k Key) Reverse() Key {
+	return Key{
+		Link:      k.Link.Reverse(),
+		Network:   k.Network.Reverse(),
+		Transport: k.Transport.Reverse(),
+		Protocol:  k.Protocol,
+	}
+}
+
+// canonical returns k or its reverse, whichever is smaller, so that both
+// directions of a flow share a table entry.
+func (k Key) canonical() Key {
+	for _, f := range []gopacket.Flow{k.Link, k.Network, k.Transport} {
+		src, dst := f.Endpoints()
+		if src.LessThan(dst) {
+			return k
+		} else if dst.LessThan(src) {
+			return k.Reverse()
+		}
+	}
+	return k
+}
+
+func (k Key) String() string {
+	if k.Network.EndpointType() == gopacket.EndpointInvalid {
+		return k.Link.String()
+	}
+	if k.Transport.EndpointType() == gopacket.EndpointInvalid {
+		return fmt.Sprintf("%v %v", k.Protocol, k.Network)
+	}
+	src, dst := k.Network.Endpoints()
+	sport, dport := k.Transport.Endpoints()
+	return fmt.Sprintf("%v %v:%v->%v:%v", k.Protocol, src, sport, dst, dport)
+}
+
+// TCPFlags is a set of TCP header flags.
+type TCPFlags uint16
+
+// The TCP flags, in the order of their bits in the header.
+const (
+	TCPFlagFIN TCPFlags = 1 << iota
+	TCPFlagSYN
+	TCPFlagRST
+	TCPFlagPSH
+	TCPFlagACK
+	TCPFlagURG
+	TCPFlagECE
+	TCPFlagCWR
+	TCPFlagNS
+)
+
+var tcpFlagNames = []string{"FIN", "SYN", "RST", "PSH", "ACK", "URG", "ECE", "CWR", "NS"}
+
+// TCPFlagsOf returns the flags set in a TCP header.
+func TCPFlagsOf(tcp *layers.TCP) TCPFlags {
+	var f TCPFlags
+	for i, set := range []bool{tcp.FIN, tcp.SYN, tcp.RST, tcp.PSH, tcp.ACK, tcp.URG, tcp.ECE, tcp.CWR, tcp.NS} {
+		if set {
+			f |= 1 << uint(i)
+		}
+	}
+	return f
+}
+
+func (f TCPFlags) String() string {
+	var names []string
+	for i, name := range tcpFlagNames {
+		if f&(1<<uint(i)) != 0 {
+			names = append(names, name)
+		}
+	}
+	return strings.Join(names, "|")
+}
+
+// Counters count the packets of one direction of a flow.
+type Counters struct {
+	Packets uint64
+	// Bytes is the total length of the packets on the wire, including link
+	// layer headers.
+	Bytes uint64
+	// TCPFlags is the union of the flags of the TCP packets.
+	TCPFlags TCPFlags
+}
+
+// ExpiryReason says why a flow expired.
+type ExpiryReason int
+
+const (
+	// ExpiryNone is the reason of flows which haven't expired.
+	ExpiryNone ExpiryReason = iota
+	// ExpiryIdle flows saw no packets for Config.IdleTimeout.
+	ExpiryIdle
+	// ExpiryActive flows lasted longer than Config.ActiveTimeout.
+	ExpiryActive
+	// ExpiryEvicted flows were evicted to stay within Config.MaxFlows.
+	ExpiryEvicted
+	// ExpiryFlushed flows were expired by Table.Flush.
+	ExpiryFlushed
+)
+
+var expiryReasonNames = []string{"none", "idle", "active", "evicted", "flushed"}
+
+func (r ExpiryReason) String() string {
+	if r < 0 || int(r) >= len(expiryReasonNames) {
+		return fmt.Sprintf("ExpiryReason(%d)", int(r))
+	}
+	return expiryReasonNames[r]
+}
+
+// Flow is a bidirectional flow.
+type Flow struct {
+	// Key identifies the flow, in the direction of its first packet.
+	Key Key
+	// Forward counts the packets in the direction of Key, and Reverse those
+	// in the opposite direction.
+	Forward, Reverse Counters
+	// First and Last are the timestamps of the first and last packets.
+	First, Last time.Time
+	// Reason is why the flow expired, or ExpiryNone if it is still in the
+	// Table.
+	Reason ExpiryReason
+}
+
+// Duration returns the time between the flow's first and last packets.
+func (f *Flow) Duration() time.Duration {
+	return f.Last.Sub(f.First)
+}
+
+func (f *Flow) String() string {
+	return fmt.Sprintf("%v packets %d/%d bytes %d/%d duration %v", f.Key,
+		f.Forward.Packets, f.Reverse.Packets, f.Forward.Bytes, f.Reverse.Bytes, f.Duration())
+}
+
+// Table tracks flows.  It is not safe for concurrent use.
+type Table struct {
+	config Config
+	flows  map[Key]*list.Element
+	// lru orders the flows by activity, most recent first.
+	lru *list.List
+	now time.Time
+}
+
+// New creates an empty Table.
+func New(config Config) *Table {
+	if config.IdleTimeout <= 0 {
+		config.IdleTimeout = DefaultIdleTimeout
+	}
+	if config.MaxFlows <= 0 {
+		config.MaxFlows = DefaultMaxFlows
+	}
+	return &Table{
+		config: config,
+		flows:  make(map[Key]*list.Element),
+		lru:    list.New(),
+	}
+}
+
+// Len returns the number of flows in the table.
+func (t *Table) Len() int {
+	return len(t.flows)
+}
+
+// Get returns the flow with the given key, in either direction, or nil.  The
+// Flow is updated in place by later packets.
+func (t *Table) Get(k Key) *Flow {
+	if e, ok := t.flows[k.canonical()]; ok {
+		return e.Value.(*Flow)
+	}
+	return nil
+}
+
+// AddPacket adds a packet to its flow, returning the flow.  It returns nil
+// for packets which have neither a network nor a link layer.
+func (t *Table) AddPacket(p gopacket.Packet) *Flow {
+	var tr gopacket.Layer
+	if l := p.TransportLayer(); l != nil {
+		tr = l
+	} else if l := p.Layer(layers.LayerTypeICMPv4); l != nil {
+		tr = l
+	} else if l := p.Layer(layers.LayerTypeICMPv6); l != nil {
+		tr = l
+	}
+	return t.add(p.Metadata().CaptureInfo, p.LinkLayer(), p.NetworkLayer(), tr)
+}
+
+// AddLayers adds a packet decoded by a gopacket.DecodingLayerParser to its
+// flow, returning the flow.  decoded is the list of layer types the parser
+// decoded, and decoders are the layers it decodes into, in any order:
+//
+//	parser := gopacket.NewDecodingLayerParser(layers.LayerTypeEthernet, &eth, &ip4, &ip6, &tcp, &udp)
+//	...
+//	if err := parser.DecodeLayers(data, &decoded); err == nil {
+//		table.AddLayers(ci, decoded, &eth, &ip4, &ip6, &tcp, &udp)
+//	}
+//
+// Undecoded layers may hold values from earlier packets, so only those in
+// decoded are used.
+func (t *Table) AddLayers(ci gopacket.CaptureInfo, decoded []gopacket.LayerType, decoders ...gopacket.DecodingLayer) *Flow {
+	var link gopacket.LinkLayer
+	var network gopacket.NetworkLayer
+	var tr gopacket.Layer
+	for _, typ := range decoded {
+		for _, d := range decoders {
+			l, ok := d.(gopacket.Layer)
+			if !ok || l.LayerType() != typ {
+				continue
+			}
+			switch l := l.(type) {
+			case gopacket.LinkLayer:
+				if link == nil {
+					link = l
+				}
+			case gopacket.NetworkLayer:
+				if network == nil {
+					network = l
+				}
+			case gopacket.TransportLayer, *layers.ICMPv4, *layers.ICMPv6:
+				if tr == nil {
+					tr = l
+				}
+			}
+			break
+		}
+	}
+	return t.add(ci, link, network, tr)
+}
+
+// key returns the key of a packet, and the TCP flags it carries.
+func key(link gopacket.LinkLayer, network gopacket.NetworkLayer, tr gopacket.Layer) (k Key, flags TCPFlags, ok bool) {
+	if network == nil {
+		if link == nil {
+			return Key{}, 0, false
+		}
+		return Key{Link: link.LinkFlow()}, 0, true
+	}
+	k.Network = network.NetworkFlow()
+	switch tr := tr.(type) {
+	case *layers.TCP:
+		k.Protocol = layers.IPProtocolTCP
+		flags = TCPFlagsOf(tr)
+	case *layers.UDP:
+		k.Protocol = layers.IPProtocolUDP
+	case *layers.UDPLite:
+		k.Protocol = layers.IPProtocolUDPLite
+	case *layers.SCTP:
+		k.Protocol = layers.IPProtocolSCTP
+	case *layers.ICMPv4:
+		k.Protocol = layers.IPProtocolICMPv4
+	case *layers.ICMPv6:
+		k.Protocol = layers.IPProtocolICMPv6
+	default:
+		switch ip := network.(type) {
+		case *layers.IPv4:
+			k.Protocol = ip.Protocol
+		case *layers.IPv6:
+			k.Protocol = ip.NextHeader
+		}
+	}
+	if tl, ok := tr.(gopacket.TransportLayer); ok && k.Protocol != 0 {
+		k.Transport = tl.TransportFlow()
+	}
+	return k, flags, true
+}
+
+func (t *Table) add(ci gopacket.CaptureInfo, link gopacket.LinkLayer, network gopacket.NetworkLayer, tr gopacket.Layer) *Flow {
+	k, flags, ok := key(link, network, tr)
+	if !ok {
+		return nil
+	}
+	ts := ci.Timestamp
+	t.Expire(ts)
+	ck := k.canonical()
+	e, ok := t.flows[ck]
+	if ok && t.config.ActiveTimeout > 0 && ts.Sub(e.Value.(*Flow).First) >= t.config.ActiveTimeout {
+		t.expire(e, ExpiryActive)
+		ok = false
+	}
+	if ok {
+		t.lru.MoveToFront(e)
+	} else {
+		if len(t.flows) >= t.config.MaxFlows {
+			t.expire(t.lru.Back(), ExpiryEvicted)
+		}
+		e = t.lru.PushFront(&Flow{Key: k, First: ts, Last: ts})
+		t.flows[ck] = e
+	}
+
+	f := e.Value.(*Flow)
+	c := &f.Forward
+	if k != f.Key {
+		c = &f.Reverse
+	}
+	c.Packets++
+	c.Bytes += uint64(ci.Length)
+	c.TCPFlags |= flags
+	if ts.After(f.Last) {
+		f.Last = ts
+	}
+	if ts.Before(f.First) {
+		f.First = ts
+	}
+	return f
+}
+
+// Expire expires the flows which have been idle for longer than the idle
+// timeout at time now.  Adding packets does this as their timestamps advance,
+// but live captures should also call Expire periodically, so that flows
+// expire during lulls in traffic.  Times earlier than that of the latest
+// packet are ignored.
+func (t *Table) Expire(now time.Time) {
+	if now.After(t.now) {
+		t.now = now
+	}
+	for e := t.lru.Back(); e != nil; e = t.lru.Back() {
+		if t.now.Sub(e.Value.(*Flow).Last) < t.config.IdleTimeout {
+			break
+		}
+		t.expire(e, ExpiryIdle)
+	}
+}
+
+// Flush expires all flows, least recently active first.
+func (t *Table) Flush() {
+	for e := t.lru.Back(); e != nil; e = t.lru.Back() {
+		t.expire(e, ExpiryFlushed)
+	}
+}
+
+func (t *Table) expire(e *list.Element, reason ExpiryReason) {
+	f := e.Value.(*Flow)
+	t.lru.Remove(e)
+	delete(t.flows, f.Key.canonical())
+	f.Reason = reason
+	if t.config.Expired != nil {
+		t.config.Expired(f)
+	}
+}
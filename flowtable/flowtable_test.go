@@ -0,0 +1,232 @@
+// Copyright 2019 The GoPacket Authors. All rights reserved.
+//
+// Use of this source code is governed by a BSD-style license
+// that can be found in the LICENSE file in the root of the source
+// tree.
+
+package flowtable
+
+import (
+	"io"
+	"net"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+	"github.com/google/gopacket/pcapgo"
+)
+
+var (
+	macA = net.HardwareAddr{0, 0, 0, 0, 0, 1}
+	macB = net.HardwareAddr{0, 0, 0, 0, 0, 2}
+	ipA  = net.IP{10, 0, 0, 1}
+	ipB  = net.IP{10, 0, 0, 2}
+	t0   = time.Unix(1500000000, 0)
+)
+
+// udpPacket builds a UDP packet from a:sport to b:dport at t0+at.
+func udpPacket(t *testing.T, a, b net.IP, sport, dport int, at time.Duration, payload int) gopacket.Packet {
+	ip := &layers.IPv4{Version: 4, TTL: 64, Protocol: layers.IPProtocolUDP, SrcIP: a, DstIP: b}
+	udp := &layers.UDP{SrcPort: layers.UDPPort(sport), DstPort: layers.UDPPort(dport)}
+	udp.SetNetworkLayerForChecksum(ip)
+	return buildPacket(t, at, ip, udp, gopacket.Payload(make([]byte, payload)))
+}
+
+func buildPacket(t *testing.T, at time.Duration, ls ...gopacket.SerializableLayer) gopacket.Packet {
+	eth := &layers.Ethernet{SrcMAC: macA, DstMAC: macB, EthernetType: layers.EthernetTypeIPv4}
+	if arp, ok := ls[0].(*layers.ARP); ok {
+		eth.EthernetType = layers.EthernetTypeARP
+		eth.SrcMAC = arp.SourceHwAddress
+	}
+	buf := gopacket.NewSerializeBuffer()
+	opts := gopacket.SerializeOptions{FixLengths: true, ComputeChecksums: true}
+	if err := gopacket.SerializeLayers(buf, opts, append([]gopacket.SerializableLayer{eth}, ls...)...); err != nil {
+		t.Fatal(err)
+	}
+	p := gopacket.NewPacket(buf.Bytes(), layers.LinkTypeEthernet, gopacket.Default)
+	p.Metadata().Timestamp = t0.Add(at)
+	p.Metadata().Length = len(buf.Bytes())
+	return p
+}
+
+func TestBidirectional(t *testing.T) {
+	var expired []*Flow
+	table := New(Config{Expired: func(f *Flow) { expired = append(expired, f) }})
+	f := table.AddPacket(udpPacket(t, ipB, ipA, 5000, 53, 0, 20))
+	table.AddPacket(udpPacket(t, ipA, ipB, 53, 5000, time.Second, 100))
+	table.AddPacket(udpPacket(t, ipB, ipA, 5000, 53, 2*time.Second, 20))
+	table.AddPacket(udpPacket(t, ipB, ipA, 5001, 53, 2*time.Second, 10))
+	if table.Len() != 2 {
+		t.Fatalf("got %d flows, want 2", table.Len())
+	}
+	if f.Key.Network.Src().String() != "10.0.0.2" || f.Key.Transport.String() != "5000->53" {
+		t.Errorf("flow key %v not in the direction of the first packet", f.Key)
+	}
+	if f.Key.Protocol != layers.IPProtocolUDP {
+		t.Errorf("got protocol %v", f.Key.Protocol)
+	}
+	// 14 + 20 + 8 bytes of headers.
+	if want := (Counters{Packets: 2, Bytes: 2 * 62}); f.Forward != want {
+		t.Errorf("forward %+v, want %+v", f.Forward, want)
+	}
+	if want := (Counters{Packets: 1, Bytes: 142}); f.Reverse != want {
+		t.Errorf("reverse %+v, want %+v", f.Reverse, want)
+	}
+	if f.Duration() != 2*time.Second || !f.First.Equal(t0) {
+		t.Errorf("first %v, duration %v", f.First, f.Duration())
+	}
+	if g := table.Get(f.Key.Reverse()); g != f {
+		t.Error("Get of reverse key did not find the flow")
+	}
+	if len(expired) != 0 {
+		t.Errorf("%d flows expired early", len(expired))
+	}
+	table.Flush()
+	if len(expired) != 2 || expired[0] != f || f.Reason != ExpiryFlushed || table.Len() != 0 {
+		t.Errorf("Flush expired %d flows, table has %d", len(expired), table.Len())
+	}
+}
+
+func TestTimeouts(t *testing.T) {
+	var expired []*Flow
+	table := New(Config{
+		IdleTimeout:   10 * time.Second,
+		ActiveTimeout: time.Minute,
+		Expired:       func(f *Flow) { expired = append(expired, f) },
+	})
+	// A flow with a packet every 5s, and one idle after its first packet.
+	table.AddPacket(udpPacket(t, ipA, ipB, 1, 2, 0, 0))
+	table.AddPacket(udpPacket(t, ipA, ipB, 3, 4, 0, 0))
+	for at := 5 * time.Second; at < 70*time.Second; at += 5 * time.Second {
+		table.AddPacket(udpPacket(t, ipA, ipB, 1, 2, at, 0))
+	}
+	if len(expired) != 2 {
+		t.Fatalf("got %d expired flows, want 2", len(expired))
+	}
+	if f := expired[0]; f.Reason != ExpiryIdle || f.Key.Transport.String() != "3->4" {
+		t.Errorf("first expired %v, reason %v", f, f.Reason)
+	}
+	if f := expired[1]; f.Reason != ExpiryActive || f.Forward.Packets != 12 || f.Duration() != 55*time.Second {
+		t.Errorf("second expired %v, reason %v", f, f.Reason)
+	}
+	if table.Len() != 1 || table.Get(expired[1].Key).Forward.Packets != 2 {
+		t.Error("active timeout did not start a new flow")
+	}
+	// Expire advances time without packets.
+	table.Expire(t0.Add(74 * time.Second))
+	if table.Len() != 1 {
+		t.Error("flow expired early")
+	}
+	table.Expire(t0.Add(75 * time.Second))
+	if table.Len() != 0 || expired[2].Reason != ExpiryIdle {
+		t.Error("flow did not expire")
+	}
+}
+
+func TestMaxFlows(t *testing.T) {
+	var expired []*Flow
+	table := New(Config{MaxFlows: 3, Expired: func(f *Flow) { expired = append(expired, f) }})
+	for i := 0; i < 5; i++ {
+		table.AddPacket(udpPacket(t, ipA, ipB, 1000+i, 53, time.Duration(i), 0))
+		// Keep the first flow active.
+		table.AddPacket(udpPacket(t, ipA, ipB, 1000, 53, time.Duration(i), 0))
+	}
+	if table.Len() != 3 || len(expired) != 2 {
+		t.Fatalf("table has %d flows and expired %d", table.Len(), len(expired))
+	}
+	for i, f := range expired {
+		if f.Reason != ExpiryEvicted || f.Key.Transport.Src().String() != []string{"1001", "1002"}[i] {
+			t.Errorf("evicted %v, reason %v", f, f.Reason)
+		}
+	}
+}
+
+func TestProtocols(t *testing.T) {
+	table := New(Config{})
+	arp := &layers.ARP{
+		AddrType: layers.LinkTypeEthernet, Protocol: layers.EthernetTypeIPv4,
+		HwAddressSize: 6, ProtAddressSize: 4, Operation: layers.ARPRequest,
+		SourceHwAddress: macB, SourceProtAddress: ipB,
+		DstHwAddress: make([]byte, 6), DstProtAddress: ipA,
+	}
+	f := table.AddPacket(buildPacket(t, 0, arp))
+	if f == nil || f.Key.Link.String() != "00:00:00:00:00:02->00:00:00:00:00:02" {
+		t.Errorf("ARP flow %v", f)
+	}
+
+	ip := &layers.IPv4{Version: 4, TTL: 64, Protocol: layers.IPProtocolICMPv4, SrcIP: ipA, DstIP: ipB}
+	ping := &layers.ICMPv4{TypeCode: layers.CreateICMPv4TypeCode(layers.ICMPv4TypeEchoRequest, 0)}
+	f = table.AddPacket(buildPacket(t, 0, ip, ping))
+	ip.SrcIP, ip.DstIP = ipB, ipA
+	ping.TypeCode = layers.CreateICMPv4TypeCode(layers.ICMPv4TypeEchoReply, 0)
+	table.AddPacket(buildPacket(t, 0, ip, ping))
+	if f.Key.Protocol != layers.IPProtocolICMPv4 || f.Forward.Packets != 1 || f.Reverse.Packets != 1 {
+		t.Errorf("ICMP flow %v", f)
+	}
+	if f.Key.String() != "ICMPv4 10.0.0.1->10.0.0.2" {
+		t.Errorf("ICMP flow key %q", f.Key)
+	}
+
+	ip.Protocol = layers.IPProtocolGRE
+	f = table.AddPacket(buildPacket(t, 0, ip, gopacket.Payload{1, 2, 3, 4}))
+	if f.Key.Protocol != layers.IPProtocolGRE {
+		t.Errorf("GRE flow %v", f)
+	}
+	if table.Len() != 3 {
+		t.Errorf("got %d flows, want 3", table.Len())
+	}
+}
+
+func TestPcap(t *testing.T) {
+	f, err := os.Open("../pcap/test_ethernet.pcap")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	r, err := pcapgo.NewReader(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var eth layers.Ethernet
+	var ip4 layers.IPv4
+	var tcp layers.TCP
+	var payload gopacket.Payload
+	parser := gopacket.NewDecodingLayerParser(layers.LayerTypeEthernet, &eth, &ip4, &tcp, &payload)
+	var decoded []gopacket.LayerType
+
+	table := New(Config{})
+	var bytes uint64
+	for {
+		data, ci, err := r.ReadPacketData()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			t.Fatal(err)
+		}
+		if err := parser.DecodeLayers(data, &decoded); err != nil {
+			t.Fatal(err)
+		}
+		table.AddLayers(ci, decoded, &payload, &tcp, &ip4, &eth)
+		bytes += uint64(ci.Length)
+	}
+	if table.Len() != 1 {
+		t.Fatalf("got %d flows, want 1", table.Len())
+	}
+	table.config.Expired = func(f *Flow) {
+		if f.Key.String() != "TCP 10.1.1.2:44644->10.1.1.1:80" {
+			t.Errorf("got flow %v", f.Key)
+		}
+		if f.Forward.Packets != 5 || f.Reverse.Packets != 5 || f.Forward.Bytes+f.Reverse.Bytes != bytes {
+			t.Errorf("got counters %+v %+v", f.Forward, f.Reverse)
+		}
+		if f.Forward.TCPFlags != TCPFlagSYN|TCPFlagACK|TCPFlagPSH|TCPFlagFIN {
+			t.Errorf("got forward flags %v", f.Forward.TCPFlags)
+		}
+		if f.Reverse.TCPFlags&(TCPFlagSYN|TCPFlagACK) != TCPFlagSYN|TCPFlagACK {
+			t.Errorf("got reverse flags %v", f.Reverse.TCPFlags)
+		}
+	}
+	table.Flush()
+}
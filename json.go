@@ -0,0 +1,427 @@
+// Copyright 2019 The GoPacket Authors. All rights reserved.
+//
+// Use of this source code is governed by a BSD-style license
+// that can be found in the LICENSE file in the root of the source
+// tree.
+
+package gopacket
+
+import (
+	"bytes"
+	"encoding"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// maxJSONDepth bounds how deeply MarshalPacketJSON follows nested values, so a
+// layer containing a reference cycle produces an error instead of looping.
+const maxJSONDepth = 32
+
+// MarshalPacketJSON returns a JSON representation of a packet, suitable for
+// feeding into log pipelines.  The output is a single object:
+//
+//	{
+//	  "metadata": {"timestamp": ..., "capture_length": ..., "length": ...,
+//	               "interface_index": ..., "truncated": ...},
+//	  "layers": [
+//	    {"type": "Ethernet", "contents_length": 14, "payload_length": 46,
+//	     "fields": {"SrcMAC": "00:11:22:33:44:55", ...}},
+//	    ...
+//	  ]
+//	}
+//
+// The output is deterministic: the same packet always produces the same
+// bytes.  Each layer's fields are built by reflection over its exported
+// fields, in declaration order, with these rules:
+//   - BaseLayer (the raw contents and payload) is omitted.
+//   - Values implementing json.Marshaler or encoding.TextMarshaler use them,
+//     so for example net.IP is written as a string.
+//   - Integers whose type has a String method (enums such as IPProtocol) are
+//     written as {"name": "TCP", "value": 6}.
+//   - Byte slices and arrays are written as hex strings.  Other byte-based
+//     types with a String method, like net.HardwareAddr, use it.
+//   - time.Duration is written using its String method.
+//   - A field tagged `json:"-"` is skipped, and `json:"name"` renames it.
+//     Byte slices tagged `gopacket:"text"` are written as strings, not hex.
+//
+// A layer can override all of this by implementing json.Marshaler, in which
+// case its MarshalJSON output is used as the layer's "fields".
+//
+// Packets returned by NewPacket also implement json.Marshaler using this
+// function.
+func MarshalPacketJSON(p Packet) ([]byte, error) {
+	var b bytes.Buffer
+	m := p.Metadata()
+	b.WriteString(`{"metadata":{"timestamp":`)
+	if m.Timestamp.IsZero() {
+		b.WriteString("null")
+	} else {
+		writeJSONString(&b, m.Timestamp.UTC().Format(time.RFC3339Nano))
+	}
+	fmt.Fprintf(&b, `,"capture_length":%d,"length":%d,"interface_index":%d,"truncated":%t},"layers":[`,
+		m.CaptureLength, m.Length, m.InterfaceIndex, m.Truncated)
+	for i, l := range p.Layers() {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		if err := writeLayerJSON(&b, l); err != nil {
+			return nil, fmt.Errorf("layer %d (%v): %v", i, l.LayerType(), err)
+		}
+	}
+	b.WriteString("]}")
+	return b.Bytes(), nil
+}
+
+// MarshalLayerJSON returns the JSON representation of a single layer, as used
+// for each entry of the "layers" list by MarshalPacketJSON.
+func MarshalLayerJSON(l Layer) ([]byte, error) {
+	var b bytes.Buffer
+	if err := writeLayerJSON(&b, l); err != nil {
+		return nil, err
+	}
+	return b.Bytes(), nil
+}
+
+func writeLayerJSON(b *bytes.Buffer, l Layer) error {
+	b.WriteString(`{"type":`)
+	writeJSONString(b, l.LayerType().String())
+	fmt.Fprintf(b, `,"contents_length":%d,"payload_length":%d,"fields":`, len(l.LayerContents()), len(l.LayerPayload()))
+	if m, ok := l.(json.Marshaler); ok {
+		data, err := m.MarshalJSON()
+		if err != nil {
+			return err
+		}
+		if err := json.Compact(b, data); err != nil {
+			return err
+		}
+	} else {
+		v := reflect.ValueOf(l)
+		for v.Kind() == reflect.Ptr || v.Kind() == reflect.Interface {
+			v = v.Elem()
+		}
+		if v.Kind() == reflect.Struct {
+			if err := writeJSONValue(b, v, 0); err != nil {
+				return err
+			}
+		} else {
+			// Layers such as Payload aren't structs; give them a field anyway
+			// so "fields" is always an object.
+			b.WriteString(`{"Data":`)
+			if v.Kind() == reflect.Slice && v.Type().Elem().Kind() == reflect.Uint8 {
+				// Don't let Payload's String method summarize the data.
+				writeJSONString(b, hex.EncodeToString(v.Bytes()))
+			} else if err := writeJSONValue(b, v, 0); err != nil {
+				return err
+			}
+			b.WriteByte('}')
+		}
+	}
+	b.WriteByte('}')
+	return nil
+}
+
+var (
+	jsonMarshalerType = reflect.TypeOf((*json.Marshaler)(nil)).Elem()
+	textMarshalerType = reflect.TypeOf((*encoding.TextMarshaler)(nil)).Elem()
+	stringerType      = reflect.TypeOf((*fmt.Stringer)(nil)).Elem()
+	durationType      = reflect.TypeOf(time.Duration(0))
+)
+
+// implementer returns v, or its address, as an interface value implementing
+// typ, if either one does.
+func implementer(v reflect.Value, typ reflect.Type) (interface{}, bool) {
+	if v.Type().Implements(typ) && v.CanInterface() {
+		if v.Kind() == reflect.Ptr && v.IsNil() {
+			return nil, false
+		}
+		return v.Interface(), true
+	}
+	if v.CanAddr() && reflect.PtrTo(v.Type()).Implements(typ) && v.Addr().CanInterface() {
+		return v.Addr().Interface(), true
+	}
+	return nil, false
+}
+
+func writeJSONString(b *bytes.Buffer, s string) {
+	// json.Marshal of a string cannot fail.
+	data, _ := json.Marshal(s)
+	b.Write(data)
+}
+
+func writeJSONValue(b *bytes.Buffer, v reflect.Value, depth int) error {
+	if depth > maxJSONDepth {
+		return errors.New("value nested too deeply")
+	}
+	if !v.IsValid() {
+		b.WriteString("null")
+		return nil
+	}
+	v = exported(v)
+	if v.Type() == durationType {
+		writeJSONString(b, time.Duration(v.Int()).String())
+		return nil
+	}
+	if i, ok := implementer(v, jsonMarshalerType); ok {
+		data, err := i.(json.Marshaler).MarshalJSON()
+		if err != nil {
+			return err
+		}
+		return json.Compact(b, data)
+	}
+	if i, ok := implementer(v, textMarshalerType); ok {
+		text, err := i.(encoding.TextMarshaler).MarshalText()
+		if err != nil {
+			return err
+		}
+		writeJSONString(b, string(text))
+		return nil
+	}
+	switch v.Kind() {
+	case reflect.Ptr, reflect.Interface:
+		if v.IsNil() {
+			b.WriteString("null")
+			return nil
+		}
+		return writeJSONValue(b, v.Elem(), depth+1)
+	case reflect.Bool:
+		b.WriteString(strconv.FormatBool(v.Bool()))
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		writeJSONEnum(b, v, strconv.FormatInt(v.Int(), 10))
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		writeJSONEnum(b, v, strconv.FormatUint(v.Uint(), 10))
+	case reflect.Float32, reflect.Float64:
+		f := v.Float()
+		if math.IsNaN(f) || math.IsInf(f, 0) {
+			b.WriteString("null")
+		} else {
+			b.WriteString(strconv.FormatFloat(f, 'g', -1, v.Type().Bits()))
+		}
+	case reflect.String:
+		writeJSONString(b, v.String())
+	case reflect.Slice, reflect.Array:
+		if v.Kind() == reflect.Slice && v.IsNil() {
+			b.WriteString("null")
+			return nil
+		}
+		if v.Type().Elem().Kind() == reflect.Uint8 {
+			if s, ok := implementer(v, stringerType); ok && v.Type().Name() != "" {
+				writeJSONString(b, s.(fmt.Stringer).String())
+				return nil
+			}
+			data := make([]byte, v.Len())
+			for i := range data {
+				data[i] = byte(v.Index(i).Uint())
+			}
+			writeJSONString(b, hex.EncodeToString(data))
+			return nil
+		}
+		b.WriteByte('[')
+		for i := 0; i < v.Len(); i++ {
+			if i > 0 {
+				b.WriteByte(',')
+			}
+			if err := writeJSONValue(b, v.Index(i), depth+1); err != nil {
+				return err
+			}
+		}
+		b.WriteByte(']')
+	case reflect.Map:
+		if v.IsNil() {
+			b.WriteString("null")
+			return nil
+		}
+		// Sort by key, as encoding/json does, to keep output deterministic.
+		keys := v.MapKeys()
+		names := make([]string, len(keys))
+		for i, k := range keys {
+			names[i] = fmt.Sprint(k.Interface())
+		}
+		order := make([]int, len(keys))
+		for i := range order {
+			order[i] = i
+		}
+		sort.Slice(order, func(i, j int) bool { return names[order[i]] < names[order[j]] })
+		b.WriteByte('{')
+		for n, i := range order {
+			if n > 0 {
+				b.WriteByte(',')
+			}
+			writeJSONString(b, names[i])
+			b.WriteByte(':')
+			if err := writeJSONValue(b, v.MapIndex(keys[i]), depth+1); err != nil {
+				return err
+			}
+		}
+		b.WriteByte('}')
+	case reflect.Struct:
+		return writeJSONStruct(b, v, depth)
+	default:
+		// Functions, channels and the like have no JSON representation.
+		b.WriteString("null")
+	}
+	return nil
+}
+
+// exported returns a copy of v that can be passed to Interface, if v is a
+// basic value read through an unexported field (such as the fields promoted
+// from an unexported embedded struct).  This lets its methods, like String,
+// be used.
+func exported(v reflect.Value) reflect.Value {
+	if v.CanInterface() {
+		return v
+	}
+	c := reflect.New(v.Type()).Elem()
+	switch v.Kind() {
+	case reflect.Bool:
+		c.SetBool(v.Bool())
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		c.SetInt(v.Int())
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		c.SetUint(v.Uint())
+	case reflect.Float32, reflect.Float64:
+		c.SetFloat(v.Float())
+	case reflect.String:
+		c.SetString(v.String())
+	case reflect.Slice:
+		if v.Type().Elem().Kind() != reflect.Uint8 {
+			return v
+		}
+		c.SetBytes(v.Bytes())
+	default:
+		return v
+	}
+	return c
+}
+
+// writeJSONEnum writes an integer, as {"name": ..., "value": ...} if its type
+// has a String method.
+func writeJSONEnum(b *bytes.Buffer, v reflect.Value, num string) {
+	s, ok := implementer(v, stringerType)
+	if !ok {
+		b.WriteString(num)
+		return
+	}
+	b.WriteString(`{"name":`)
+	writeJSONString(b, s.(fmt.Stringer).String())
+	b.WriteString(`,"value":`)
+	b.WriteString(num)
+	b.WriteByte('}')
+}
+
+func writeJSONStruct(b *bytes.Buffer, v reflect.Value, depth int) error {
+	if !hasExportedFields(v.Type()) {
+		// Opaque structs such as Endpoint are best described by their String
+		// method.
+		if s, ok := implementer(v, stringerType); ok {
+			writeJSONString(b, s.(fmt.Stringer).String())
+			return nil
+		}
+	}
+	b.WriteByte('{')
+	first := true
+	if err := writeJSONFields(b, v, depth, &first); err != nil {
+		return err
+	}
+	b.WriteByte('}')
+	return nil
+}
+
+// writeJSONFields writes the exported fields of struct v, flattening
+// anonymous struct fields into their parent like encoding/json does.
+func writeJSONFields(b *bytes.Buffer, v reflect.Value, depth int, first *bool) error {
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if f.Anonymous {
+			if f.Name == "BaseLayer" {
+				continue
+			}
+			fv := v.Field(i)
+			if fv.Kind() == reflect.Ptr {
+				if fv.IsNil() {
+					continue
+				}
+				fv = fv.Elem()
+			}
+			if fv.Kind() == reflect.Struct {
+				if err := writeJSONFields(b, fv, depth, first); err != nil {
+					return err
+				}
+				continue
+			}
+		}
+		if f.PkgPath != "" { // unexported
+			continue
+		}
+		tag := f.Tag.Get("json")
+		if tag == "-" {
+			continue
+		}
+		name := f.Name
+		if i := strings.IndexByte(tag, ','); i >= 0 {
+			tag = tag[:i]
+		}
+		if tag != "" {
+			name = tag
+		}
+		if !*first {
+			b.WriteByte(',')
+		}
+		*first = false
+		writeJSONString(b, name)
+		b.WriteByte(':')
+		var err error
+		if f.Tag.Get("gopacket") == "text" {
+			err = writeJSONText(b, v.Field(i), depth+1)
+		} else {
+			err = writeJSONValue(b, v.Field(i), depth+1)
+		}
+		if err != nil {
+			return fmt.Errorf("%s: %v", f.Name, err)
+		}
+	}
+	return nil
+}
+
+// writeJSONText writes a byte slice, or a slice of them, as strings instead of
+// hex.
+func writeJSONText(b *bytes.Buffer, v reflect.Value, depth int) error {
+	if v.Kind() != reflect.Slice || v.IsNil() {
+		return writeJSONValue(b, v, depth)
+	}
+	switch v.Type().Elem().Kind() {
+	case reflect.Uint8:
+		writeJSONString(b, string(v.Bytes()))
+	case reflect.Slice:
+		b.WriteByte('[')
+		for i := 0; i < v.Len(); i++ {
+			if i > 0 {
+				b.WriteByte(',')
+			}
+			if err := writeJSONText(b, v.Index(i), depth+1); err != nil {
+				return err
+			}
+		}
+		b.WriteByte(']')
+	default:
+		return writeJSONValue(b, v, depth)
+	}
+	return nil
+}
+
+func hasExportedFields(t reflect.Type) bool {
+	for i := 0; i < t.NumField(); i++ {
+		if t.Field(i).PkgPath == "" {
+			return true
+		}
+	}
+	return false
+}
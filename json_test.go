@@ -0,0 +1,132 @@
+// Copyright 2019 The GoPacket Authors. All rights reserved.
+//
+// Use of this source code is governed by a BSD-style license
+// that can be found in the LICENSE file in the root of the source
+// tree.
+
+package gopacket_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+	"github.com/google/gopacket/pcapgo"
+)
+
+var updateGolden = flag.Bool("update", false, "update golden files in testdata")
+
+// TestMarshalPacketJSONGolden decodes every packet of the test pcaps and
+// compares their JSON output, one packet per line, with the golden files in
+// testdata.
+func TestMarshalPacketJSONGolden(t *testing.T) {
+	pcaps, err := filepath.Glob("pcap/test_*.pcap")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pcaps) == 0 {
+		t.Fatal("no test pcaps found")
+	}
+	for _, name := range pcaps {
+		f, err := os.Open(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		r, err := pcapgo.NewReader(f)
+		if err != nil {
+			t.Fatal(name, err)
+		}
+		var got bytes.Buffer
+		source := gopacket.NewPacketSource(r, r.LinkType())
+		for {
+			p, err := source.NextPacket()
+			if err == io.EOF {
+				break
+			} else if err != nil {
+				t.Fatal(name, err)
+			}
+			data, err := gopacket.MarshalPacketJSON(p)
+			if err != nil {
+				t.Fatal(name, err)
+			}
+			if !json.Valid(data) {
+				t.Fatalf("%s: invalid JSON %s", name, data)
+			}
+			got.Write(data)
+			got.WriteByte('\n')
+		}
+		f.Close()
+
+		golden := filepath.Join("testdata", strings.TrimSuffix(filepath.Base(name), ".pcap")+".json")
+		if *updateGolden {
+			if err := ioutil.WriteFile(golden, got.Bytes(), 0644); err != nil {
+				t.Fatal(err)
+			}
+			continue
+		}
+		want, err := ioutil.ReadFile(golden)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(got.Bytes(), want) {
+			gotLines, wantLines := strings.Split(got.String(), "\n"), strings.Split(string(want), "\n")
+			for i := range gotLines {
+				if i >= len(wantLines) || gotLines[i] != wantLines[i] {
+					t.Errorf("%s: packet %d differs from %s\ngot:  %s", name, i+1, golden, gotLines[i])
+					break
+				}
+			}
+		}
+	}
+}
+
+func TestMarshalPacketJSONDeterministic(t *testing.T) {
+	data := []byte{
+		0x00, 0x00, 0x0c, 0x9f, 0xf0, 0x20, 0xbc, 0x30, 0x5b, 0xe8, 0xd3, 0x49,
+		0x08, 0x00, 0x45, 0x00, 0x00, 0x1c, 0x00, 0x00, 0x40, 0x00, 0x40, 0x11,
+		0x00, 0x00, 0x0a, 0x00, 0x00, 0x01, 0x0a, 0x00, 0x00, 0x02, 0x30, 0x39,
+		0x00, 0x35, 0x00, 0x08, 0x00, 0x00,
+	}
+	eager := gopacket.NewPacket(data, layers.LayerTypeEthernet, gopacket.Default)
+	lazy := gopacket.NewPacket(data, layers.LayerTypeEthernet, gopacket.Lazy)
+	a, err := json.Marshal(eager)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := json.Marshal(lazy)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(a, b) {
+		t.Errorf("eager and lazy packets differ:\n%s\n%s", a, b)
+	}
+
+	var decoded struct {
+		Layers []struct {
+			Type   string
+			Fields map[string]interface{}
+		}
+	}
+	if err := json.Unmarshal(a, &decoded); err != nil {
+		t.Fatal(err)
+	}
+	if len(decoded.Layers) < 3 || decoded.Layers[2].Type != "UDP" {
+		t.Fatalf("unexpected layers: %s", a)
+	}
+	ip := decoded.Layers[1].Fields
+	if ip["SrcIP"] != "10.0.0.1" {
+		t.Errorf("IPv4 SrcIP, got %v want 10.0.0.1", ip["SrcIP"])
+	}
+	proto, _ := ip["Protocol"].(map[string]interface{})
+	if proto["name"] != "UDP" || proto["value"] != float64(17) {
+		t.Errorf("IPv4 Protocol, got %v want UDP/17", ip["Protocol"])
+	}
+}
@@ -0,0 +1,688 @@
+// Copyright 2019 The GoPacket Authors. All rights reserved.
+//
+// Use of this source code is governed by a BSD-style license
+// that can be found in the LICENSE file in the root of the source
+// tree.
+
+package layers
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+
+	"github.com/google/gopacket"
+)
+
+// bgpHeaderLen is the length of the header of BGP messages: the marker, the
+// length and the type.
+const bgpHeaderLen = 19
+
+// BGPType is the type of a BGP message.
+type BGPType uint8
+
+// BGPType known values.
+const (
+	BGPTypeOpen         BGPType = 1
+	BGPTypeUpdate       BGPType = 2
+	BGPTypeNotification BGPType = 3
+	BGPTypeKeepalive    BGPType = 4
+	BGPTypeRouteRefresh BGPType = 5
+)
+
+func (t BGPType) String() string {
+	switch t {
+	case BGPTypeOpen:
+		return "OPEN"
+	case BGPTypeUpdate:
+		return "UPDATE"
+	case BGPTypeNotification:
+		return "NOTIFICATION"
+	case BGPTypeKeepalive:
+		return "KEEPALIVE"
+	case BGPTypeRouteRefresh:
+		return "ROUTE-REFRESH"
+	}
+	return fmt.Sprintf("Unknown(%d)", uint8(t))
+}
+
+// BGPAFI is an address family identifier.
+type BGPAFI uint16
+
+// BGPAFI known values.
+const (
+	BGPAFIIPv4  BGPAFI = 1
+	BGPAFIIPv6  BGPAFI = 2
+	BGPAFIL2VPN BGPAFI = 25
+)
+
+func (a BGPAFI) String() string {
+	switch a {
+	case BGPAFIIPv4:
+		return "IPv4"
+	case BGPAFIIPv6:
+		return "IPv6"
+	case BGPAFIL2VPN:
+		return "L2VPN"
+	}
+	return fmt.Sprintf("AFI(%d)", uint16(a))
+}
+
+// BGPSAFI is a subsequent address family identifier.
+type BGPSAFI uint8
+
+// BGPSAFI known values.
+const (
+	BGPSAFIUnicast   BGPSAFI = 1
+	BGPSAFIMulticast BGPSAFI = 2
+	BGPSAFIMPLSLabel BGPSAFI = 4
+	BGPSAFIEVPN      BGPSAFI = 70
+	BGPSAFIMPLSVPN   BGPSAFI = 128
+)
+
+func (s BGPSAFI) String() string {
+	switch s {
+	case BGPSAFIUnicast:
+		return "Unicast"
+	case BGPSAFIMulticast:
+		return "Multicast"
+	case BGPSAFIMPLSLabel:
+		return "MPLS Label"
+	case BGPSAFIEVPN:
+		return "EVPN"
+	case BGPSAFIMPLSVPN:
+		return "MPLS VPN"
+	}
+	return fmt.Sprintf("SAFI(%d)", uint8(s))
+}
+
+// BGPAddressFamily is the family of the routes of MP-BGP, see RFC 4760.
+type BGPAddressFamily struct {
+	AFI  BGPAFI
+	SAFI BGPSAFI
+}
+
+func (f BGPAddressFamily) String() string {
+	return f.AFI.String() + "/" + f.SAFI.String()
+}
+
+// BGPCapabilityCode is the code of a capability advertised in an OPEN
+// message, see RFC 5492.
+type BGPCapabilityCode uint8
+
+// BGPCapabilityCode known values.
+const (
+	BGPCapabilityMultiProtocol        BGPCapabilityCode = 1
+	BGPCapabilityRouteRefresh         BGPCapabilityCode = 2
+	BGPCapabilityExtendedNextHop      BGPCapabilityCode = 5
+	BGPCapabilityExtendedMessage      BGPCapabilityCode = 6
+	BGPCapabilityGracefulRestart      BGPCapabilityCode = 64
+	BGPCapabilityFourOctetAS          BGPCapabilityCode = 65
+	BGPCapabilityAddPath              BGPCapabilityCode = 69
+	BGPCapabilityEnhancedRouteRefresh BGPCapabilityCode = 70
+	BGPCapabilityFQDN                 BGPCapabilityCode = 73
+)
+
+func (c BGPCapabilityCode) String() string {
+	switch c {
+	case BGPCapabilityMultiProtocol:
+		return "Multiprotocol"
+	case BGPCapabilityRouteRefresh:
+		return "Route Refresh"
+	case BGPCapabilityExtendedNextHop:
+		return "Extended Next Hop"
+	case BGPCapabilityExtendedMessage:
+		return "Extended Message"
+	case BGPCapabilityGracefulRestart:
+		return "Graceful Restart"
+	case BGPCapabilityFourOctetAS:
+		return "4-octet AS"
+	case BGPCapabilityAddPath:
+		return "ADD-PATH"
+	case BGPCapabilityEnhancedRouteRefresh:
+		return "Enhanced Route Refresh"
+	case BGPCapabilityFQDN:
+		return "FQDN"
+	}
+	return fmt.Sprintf("Capability(%d)", uint8(c))
+}
+
+// BGPAddPathMode says whether a speaker can send, receive, or both send and
+// receive multiple paths of the routes of a family, see RFC 7911.
+type BGPAddPathMode uint8
+
+// BGPAddPathMode known values.
+const (
+	BGPAddPathReceive BGPAddPathMode = 1
+	BGPAddPathSend    BGPAddPathMode = 2
+	BGPAddPathBoth    BGPAddPathMode = 3
+)
+
+// BGPAddPathFamily is a family of an ADD-PATH capability.
+type BGPAddPathFamily struct {
+	BGPAddressFamily
+	Mode BGPAddPathMode
+}
+
+// BGPGracefulRestartFamily is a family of a Graceful Restart capability.
+type BGPGracefulRestartFamily struct {
+	BGPAddressFamily
+	// ForwardingPreserved tells whether the forwarding state of the family
+	// was kept across the restart.
+	ForwardingPreserved bool
+}
+
+// BGPGracefulRestart is the value of a Graceful Restart capability, see RFC
+// 4724 and RFC 8538.
+type BGPGracefulRestart struct {
+	// Restarted is the Restart State bit, and Notification the bit which
+	// extends graceful restart to NOTIFICATION messages.
+	Restarted, Notification bool
+	// Time is the restart time in seconds, of 12 bits.
+	Time     uint16
+	Families []BGPGracefulRestartFamily
+}
+
+// BGPCapability is a capability advertised in an OPEN message.  The values of
+// the multiprotocol, 4-octet AS, ADD-PATH and graceful restart capabilities
+// are decoded into their fields, from which they are also encoded; other
+// capabilities are encoded from Data.
+type BGPCapability struct {
+	Code BGPCapabilityCode
+	// Data is the value as it was sent.
+	Data []byte
+
+	MultiProtocol   BGPAddressFamily
+	ASN             uint32
+	AddPath         []BGPAddPathFamily
+	GracefulRestart BGPGracefulRestart
+}
+
+func (c *BGPCapability) decode(data []byte) error {
+	c.Data = data
+	switch c.Code {
+	case BGPCapabilityMultiProtocol:
+		if len(data) != 4 {
+			return errors.New("BGP multiprotocol capability length not 4")
+		}
+		c.MultiProtocol = BGPAddressFamily{BGPAFI(binary.BigEndian.Uint16(data)), BGPSAFI(data[3])}
+	case BGPCapabilityFourOctetAS:
+		if len(data) != 4 {
+			return errors.New("BGP 4-octet AS capability length not 4")
+		}
+		c.ASN = binary.BigEndian.Uint32(data)
+	case BGPCapabilityAddPath:
+		if len(data)%4 != 0 {
+			return errors.New("BGP ADD-PATH capability length not a multiple of 4")
+		}
+		for i := 0; i < len(data); i += 4 {
+			f := BGPAddressFamily{BGPAFI(binary.BigEndian.Uint16(data[i:])), BGPSAFI(data[i+2])}
+			c.AddPath = append(c.AddPath, BGPAddPathFamily{f, BGPAddPathMode(data[i+3])})
+		}
+	case BGPCapabilityGracefulRestart:
+		if len(data) < 2 || len(data)%4 != 2 {
+			return errors.New("BGP graceful restart capability has bad length")
+		}
+		flags := binary.BigEndian.Uint16(data)
+		c.GracefulRestart = BGPGracefulRestart{
+			Restarted:    flags&0x8000 != 0,
+			Notification: flags&0x4000 != 0,
+			Time:         flags & 0x0fff,
+		}
+		for i := 2; i < len(data); i += 4 {
+			f := BGPAddressFamily{BGPAFI(binary.BigEndian.Uint16(data[i:])), BGPSAFI(data[i+2])}
+			c.GracefulRestart.Families = append(c.GracefulRestart.Families, BGPGracefulRestartFamily{f, data[i+3]&0x80 != 0})
+		}
+	}
+	return nil
+}
+
+func (c *BGPCapability) encode(b []byte) []byte {
+	b = append(b, byte(c.Code), 0)
+	start := len(b)
+	switch c.Code {
+	case BGPCapabilityMultiProtocol:
+		b = append(b, byte(c.MultiProtocol.AFI>>8), byte(c.MultiProtocol.AFI), 0, byte(c.MultiProtocol.SAFI))
+	case BGPCapabilityFourOctetAS:
+		b = appendUint32(b, c.ASN)
+	case BGPCapabilityAddPath:
+		for _, f := range c.AddPath {
+			b = append(b, byte(f.AFI>>8), byte(f.AFI), byte(f.SAFI), byte(f.Mode))
+		}
+	case BGPCapabilityGracefulRestart:
+		g := &c.GracefulRestart
+		flags := g.Time & 0x0fff
+		if g.Restarted {
+			flags |= 0x8000
+		}
+		if g.Notification {
+			flags |= 0x4000
+		}
+		b = append(b, byte(flags>>8), byte(flags))
+		for _, f := range g.Families {
+			b = append(b, byte(f.AFI>>8), byte(f.AFI), byte(f.SAFI), byte(b2i(f.ForwardingPreserved)<<7))
+		}
+	default:
+		b = append(b, c.Data...)
+	}
+	b[start-1] = byte(len(b) - start)
+	return b
+}
+
+// BGPOpenParameter is an optional parameter of an OPEN message other than
+// capabilities, such as the deprecated authentication parameter.
+type BGPOpenParameter struct {
+	Type uint8
+	Data []byte
+}
+
+// bgpParameterCapabilities is the type of the optional parameters of OPEN
+// messages which hold capabilities.
+const bgpParameterCapabilities = 2
+
+// BGPOpen is the body of an OPEN message, see RFC 4271 section 4.2.
+type BGPOpen struct {
+	Version uint8
+	// MyAS is the 2-octet AS number of the sender, which is AS_TRANS, 23456,
+	// for speakers of 4-octet AS numbers.  See ASN.
+	MyAS       uint16
+	HoldTime   uint16
+	Identifier net.IP
+	// Capabilities holds the capabilities of all the capability parameters.
+	// They are encoded as a single parameter.
+	Capabilities []BGPCapability
+	Parameters   []BGPOpenParameter
+}
+
+// Capability returns the first capability with the given code, or nil.
+func (o *BGPOpen) Capability(code BGPCapabilityCode) *BGPCapability {
+	for i := range o.Capabilities {
+		if o.Capabilities[i].Code == code {
+			return &o.Capabilities[i]
+		}
+	}
+	return nil
+}
+
+// ASN returns the AS number of the sender: that of its 4-octet AS
+// capability if it has one, or else MyAS.
+func (o *BGPOpen) ASN() uint32 {
+	if c := o.Capability(BGPCapabilityFourOctetAS); c != nil {
+		return c.ASN
+	}
+	return uint32(o.MyAS)
+}
+
+func (o *BGPOpen) decode(data []byte) error {
+	if len(data) < 10 {
+		return errors.New("BGP OPEN message too short")
+	}
+	*o = BGPOpen{
+		Version:    data[0],
+		MyAS:       binary.BigEndian.Uint16(data[1:]),
+		HoldTime:   binary.BigEndian.Uint16(data[3:]),
+		Identifier: net.IP(data[5:9]),
+	}
+	params := data[10:]
+	// The extended format of RFC 9072 has 2-byte lengths.
+	extended := len(params) > 0 && data[9] == 255 && params[0] == 255
+	hdr := 2
+	if extended {
+		if len(params) < 3 || int(binary.BigEndian.Uint16(params[1:])) != len(params)-3 {
+			return errors.New("BGP OPEN extended optional parameters length mismatch")
+		}
+		params, hdr = params[3:], 3
+	} else if int(data[9]) != len(params) {
+		return errors.New("BGP OPEN optional parameters length mismatch")
+	}
+	for len(params) > 0 {
+		if len(params) < hdr {
+			return errors.New("BGP OPEN optional parameter truncated")
+		}
+		typ, n := params[0], int(params[1])
+		if extended {
+			n = int(binary.BigEndian.Uint16(params[1:]))
+		}
+		if len(params) < hdr+n {
+			return errors.New("BGP OPEN optional parameter truncated")
+		}
+		value := params[hdr : hdr+n]
+		params = params[hdr+n:]
+		if typ != bgpParameterCapabilities {
+			o.Parameters = append(o.Parameters, BGPOpenParameter{typ, value})
+			continue
+		}
+		for len(value) > 0 {
+			if len(value) < 2 || len(value) < 2+int(value[1]) {
+				return errors.New("BGP capability truncated")
+			}
+			c := BGPCapability{Code: BGPCapabilityCode(value[0])}
+			if err := c.decode(value[2 : 2+int(value[1])]); err != nil {
+				return err
+			}
+			o.Capabilities = append(o.Capabilities, c)
+			value = value[2+int(value[1]):]
+		}
+	}
+	return nil
+}
+
+func (o *BGPOpen) encode(b []byte) []byte {
+	b = append(b, o.Version, byte(o.MyAS>>8), byte(o.MyAS), byte(o.HoldTime>>8), byte(o.HoldTime))
+	b = append(b, o.Identifier.To4()...)
+	for len(b) < 9 {
+		b = append(b, 0)
+	}
+
+	var caps []byte
+	for i := range o.Capabilities {
+		caps = o.Capabilities[i].encode(caps)
+	}
+	params := []BGPOpenParameter(nil)
+	if len(o.Capabilities) > 0 {
+		params = append(params, BGPOpenParameter{bgpParameterCapabilities, caps})
+	}
+	params = append(params, o.Parameters...)
+	total, extended := 0, false
+	for _, p := range params {
+		total += 2 + len(p.Data)
+		extended = extended || len(p.Data) > 255
+	}
+	if extended || total > 255 {
+		b = append(b, 255, 255, 0, 0)
+		start := len(b)
+		for _, p := range params {
+			b = append(b, p.Type, byte(len(p.Data)>>8), byte(len(p.Data)))
+			b = append(b, p.Data...)
+		}
+		binary.BigEndian.PutUint16(b[start-2:], uint16(len(b)-start))
+		return b
+	}
+	b = append(b, byte(total))
+	for _, p := range params {
+		b = append(b, p.Type, byte(len(p.Data)))
+		b = append(b, p.Data...)
+	}
+	return b
+}
+
+// BGPErrorCode is the error of a NOTIFICATION message.
+type BGPErrorCode uint8
+
+// BGPErrorCode known values.
+const (
+	BGPErrorMessageHeader BGPErrorCode = 1
+	BGPErrorOpenMessage   BGPErrorCode = 2
+	BGPErrorUpdateMessage BGPErrorCode = 3
+	BGPErrorHoldTimer     BGPErrorCode = 4
+	BGPErrorFSM           BGPErrorCode = 5
+	BGPErrorCease         BGPErrorCode = 6
+	BGPErrorRouteRefresh  BGPErrorCode = 7
+)
+
+func (c BGPErrorCode) String() string {
+	switch c {
+	case BGPErrorMessageHeader:
+		return "Message Header Error"
+	case BGPErrorOpenMessage:
+		return "OPEN Message Error"
+	case BGPErrorUpdateMessage:
+		return "UPDATE Message Error"
+	case BGPErrorHoldTimer:
+		return "Hold Timer Expired"
+	case BGPErrorFSM:
+		return "Finite State Machine Error"
+	case BGPErrorCease:
+		return "Cease"
+	case BGPErrorRouteRefresh:
+		return "ROUTE-REFRESH Message Error"
+	}
+	return fmt.Sprintf("Error(%d)", uint8(c))
+}
+
+// BGPNotification is the body of a NOTIFICATION message.
+type BGPNotification struct {
+	Code    BGPErrorCode
+	Subcode uint8
+	Data    []byte
+}
+
+// BGPRouteRefresh is the body of a ROUTE-REFRESH message, see RFC 2918 and
+// RFC 7313.
+type BGPRouteRefresh struct {
+	BGPAddressFamily
+	// Subtype is 0 for a request, and 1 and 2 for the beginning and end of
+	// an enhanced route refresh.
+	Subtype uint8
+}
+
+// BGPSession holds what the peers of a session negotiated in their OPEN
+// messages which changes how UPDATE messages are encoded, and which the
+// messages don't say themselves.
+type BGPSession struct {
+	// TwoOctetAS is set for sessions with a peer which doesn't support
+	// 4-octet AS numbers, whose AS_PATH attributes have 2-octet ones.
+	TwoOctetAS bool
+	// AddPath holds the families whose NLRI carry path identifiers.
+	AddPath []BGPAddressFamily
+}
+
+// NewBGPSession returns the session of the messages sent by the speaker of
+// the OPEN message sender to that of receiver.
+func NewBGPSession(sender, receiver *BGPOpen) BGPSession {
+	s := BGPSession{
+		TwoOctetAS: sender.Capability(BGPCapabilityFourOctetAS) == nil || receiver.Capability(BGPCapabilityFourOctetAS) == nil,
+	}
+	modes := func(o *BGPOpen) map[BGPAddressFamily]BGPAddPathMode {
+		m := make(map[BGPAddressFamily]BGPAddPathMode)
+		for _, c := range o.Capabilities {
+			if c.Code == BGPCapabilityAddPath {
+				for _, f := range c.AddPath {
+					m[f.BGPAddressFamily] = f.Mode
+				}
+			}
+		}
+		return m
+	}
+	received := modes(receiver)
+	for _, c := range sender.Capabilities {
+		if c.Code != BGPCapabilityAddPath {
+			continue
+		}
+		for _, f := range c.AddPath {
+			if f.Mode&BGPAddPathSend != 0 && received[f.BGPAddressFamily]&BGPAddPathReceive != 0 {
+				s.AddPath = append(s.AddPath, f.BGPAddressFamily)
+			}
+		}
+	}
+	return s
+}
+
+func (s *BGPSession) addPath(f BGPAddressFamily) bool {
+	for _, a := range s.AddPath {
+		if a == f {
+			return true
+		}
+	}
+	return false
+}
+
+// BGP is a BGP-4 message, see RFC 4271.  Each message of a TCP segment is a
+// layer, whose payload is the messages which follow it.
+//
+// BGP is only decoded from the TCP packets of port 179 when
+// gopacket.DecodeOptions.DecodeStreamsAsDatagrams is set, and only from
+// packets which start a message.  The part of a message split across
+// packets is left as payload; the reassembly/bgpstream package decodes the
+// messages of reassembled streams.
+type BGP struct {
+	BaseLayer
+
+	// Session, which is left as it is by DecodeFromBytes, says how UPDATE
+	// messages are encoded.  Messages decoded by packets use that of a
+	// session of 4-octet AS numbers without ADD-PATH.
+	Session BGPSession
+
+	Type BGPType
+	// Length is the length of the message, header included.
+	Length uint16
+
+	// The body of the message, by its type.  KEEPALIVE messages have none.
+	Open         BGPOpen
+	Update       BGPUpdate
+	Notification BGPNotification
+	RouteRefresh BGPRouteRefresh
+}
+
+// LayerType returns LayerTypeBGP.
+func (b *BGP) LayerType() gopacket.LayerType { return LayerTypeBGP }
+
+// CanDecode returns LayerTypeBGP.
+func (b *BGP) CanDecode() gopacket.LayerClass { return LayerTypeBGP }
+
+// NextLayerType returns LayerTypeBGP if messages follow this one, and
+// gopacket.LayerTypeZero otherwise.
+func (b *BGP) NextLayerType() gopacket.LayerType {
+	if len(b.Payload) > 0 {
+		return LayerTypeBGP
+	}
+	return gopacket.LayerTypeZero
+}
+
+// bgpMarker tells whether data starts with the marker of the BGP header,
+// which is all ones.
+func bgpMarker(data []byte) bool {
+	if len(data) < 16 {
+		return false
+	}
+	for _, b := range data[:16] {
+		if b != 0xff {
+			return false
+		}
+	}
+	return true
+}
+
+// BGPMessageLength returns the length of the BGP message at the start of a
+// stream's data, or 0 if the data doesn't yet hold the header.  It returns
+// an error if the data doesn't start with a message.
+func BGPMessageLength(data []byte) (int, error) {
+	if len(data) < bgpHeaderLen {
+		return 0, nil
+	}
+	if !bgpMarker(data) {
+		return 0, errors.New("BGP marker not all ones")
+	}
+	n := int(binary.BigEndian.Uint16(data[16:]))
+	if n < bgpHeaderLen {
+		return 0, fmt.Errorf("BGP message length %d too short", n)
+	}
+	return n, nil
+}
+
+func decodeBGP(data []byte, p gopacket.PacketBuilder) error {
+	// Packets in the middle of a message are left as payload, and so is
+	// the start of a message continued in the next packets.
+	if !bgpMarker(data) {
+		return p.NextDecoder(gopacket.LayerTypePayload)
+	}
+	if n, err := BGPMessageLength(data); err == nil && (n == 0 || n > len(data)) {
+		return p.NextDecoder(gopacket.LayerTypePayload)
+	}
+	b := &BGP{}
+	if err := b.DecodeFromBytes(data, p); err != nil {
+		return err
+	}
+	p.AddLayer(b)
+	if len(b.Payload) > 0 {
+		return p.NextDecoder(gopacket.DecodeFunc(decodeBGP))
+	}
+	return nil
+}
+
+// DecodeFromBytes decodes the message at the start of data into the BGP
+// struct, leaving the rest of data as its payload.
+func (b *BGP) DecodeFromBytes(data []byte, df gopacket.DecodeFeedback) error {
+	n, err := BGPMessageLength(data)
+	if err != nil {
+		return err
+	}
+	if n == 0 || n > len(data) {
+		df.SetTruncated()
+		return errors.New("BGP message truncated")
+	}
+	*b = BGP{
+		BaseLayer: BaseLayer{Contents: data[:n], Payload: data[n:]},
+		Session:   b.Session,
+		Type:      BGPType(data[18]),
+		Length:    uint16(n),
+	}
+	body := data[bgpHeaderLen:n]
+	switch b.Type {
+	case BGPTypeOpen:
+		return b.Open.decode(body)
+	case BGPTypeUpdate:
+		return b.Update.decode(body, &b.Session)
+	case BGPTypeNotification:
+		if len(body) < 2 {
+			return errors.New("BGP NOTIFICATION message too short")
+		}
+		b.Notification = BGPNotification{BGPErrorCode(body[0]), body[1], body[2:]}
+	case BGPTypeKeepalive:
+		if len(body) != 0 {
+			return errors.New("BGP KEEPALIVE message has a body")
+		}
+	case BGPTypeRouteRefresh:
+		if len(body) != 4 {
+			return errors.New("BGP ROUTE-REFRESH message length not 23")
+		}
+		f := BGPAddressFamily{BGPAFI(binary.BigEndian.Uint16(body)), BGPSAFI(body[3])}
+		b.RouteRefresh = BGPRouteRefresh{f, body[2]}
+	default:
+		return fmt.Errorf("unknown BGP message type %d", uint8(b.Type))
+	}
+	return nil
+}
+
+// SerializeTo writes the serialized form of this layer into the
+// SerializationBuffer, implementing gopacket.SerializableLayer.
+func (b *BGP) SerializeTo(buf gopacket.SerializeBuffer, opts gopacket.SerializeOptions) error {
+	msg := make([]byte, bgpHeaderLen, 64)
+	for i := 0; i < 16; i++ {
+		msg[i] = 0xff
+	}
+	msg[18] = byte(b.Type)
+	switch b.Type {
+	case BGPTypeOpen:
+		msg = b.Open.encode(msg)
+	case BGPTypeUpdate:
+		var err error
+		if msg, err = b.Update.encode(msg, &b.Session); err != nil {
+			return err
+		}
+	case BGPTypeNotification:
+		msg = append(msg, byte(b.Notification.Code), b.Notification.Subcode)
+		msg = append(msg, b.Notification.Data...)
+	case BGPTypeRouteRefresh:
+		r := &b.RouteRefresh
+		msg = append(msg, byte(r.AFI>>8), byte(r.AFI), r.Subtype, byte(r.SAFI))
+	}
+	if len(msg) > 0xffff {
+		return fmt.Errorf("BGP message length %d too long", len(msg))
+	}
+	if opts.FixLengths {
+		b.Length = uint16(len(msg))
+	}
+	binary.BigEndian.PutUint16(msg[16:], b.Length)
+	data, err := buf.PrependBytes(len(msg))
+	if err != nil {
+		return err
+	}
+	copy(data, msg)
+	return nil
+}
+
+func appendUint32(b []byte, v uint32) []byte {
+	return append(b, byte(v>>24), byte(v>>16), byte(v>>8), byte(v))
+}
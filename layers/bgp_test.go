@@ -0,0 +1,361 @@
+// Copyright 2019 The GoPacket Authors. All rights reserved.
+//
+// Use of this source code is governed by a BSD-style license
+// that can be found in the LICENSE file in the root of the source
+// tree.
+
+package layers
+
+import (
+	"bytes"
+	"net"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/google/gopacket"
+)
+
+var testBGPMarker = strings.Repeat("ff", 16)
+
+// testBGPOpen is an OPEN message of AS 65000 with each capability in a
+// parameter of its own, as FRRouting sends them.
+var testBGPOpen = testBGPMarker + "004b01" + "04fde800b40a0000012e" +
+	"0206010400010001" + // IPv4 unicast
+	"0206010400020001" + // IPv6 unicast
+	"02020200" + // route refresh
+	"02064104" + "0000fde8" + // 4-octet AS
+	"0206450400010103" + // ADD-PATH IPv4 unicast, send and receive
+	"020840064078000101" + "80" // graceful restart, 120s
+
+func testSerializeBGP(t *testing.T, b *BGP) []byte {
+	buf := gopacket.NewSerializeBuffer()
+	if err := b.SerializeTo(buf, gopacket.SerializeOptions{FixLengths: true}); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestBGPOpen(t *testing.T) {
+	var b BGP
+	if err := b.DecodeFromBytes(testHex(t, testBGPOpen), gopacket.NilDecodeFeedback); err != nil {
+		t.Fatal(err)
+	}
+	o := &b.Open
+	if b.Type != BGPTypeOpen || b.Length != 75 || o.Version != 4 || o.MyAS != 65000 || o.HoldTime != 180 ||
+		!o.Identifier.Equal(net.IP{10, 0, 0, 1}) || len(o.Capabilities) != 6 || len(o.Parameters) != 0 {
+		t.Fatalf("OPEN %+v", o)
+	}
+	if o.ASN() != 65000 || o.Capabilities[1].MultiProtocol != (BGPAddressFamily{BGPAFIIPv6, BGPSAFIUnicast}) {
+		t.Errorf("capabilities %+v", o.Capabilities)
+	}
+	want := []BGPAddPathFamily{{BGPAddressFamily{BGPAFIIPv4, BGPSAFIUnicast}, BGPAddPathBoth}}
+	if c := o.Capability(BGPCapabilityAddPath); c == nil || !reflect.DeepEqual(c.AddPath, want) {
+		t.Errorf("ADD-PATH %+v", c)
+	}
+	gr := o.Capability(BGPCapabilityGracefulRestart).GracefulRestart
+	if gr.Restarted || !gr.Notification || gr.Time != 120 || len(gr.Families) != 1 || !gr.Families[0].ForwardingPreserved {
+		t.Errorf("graceful restart %+v", gr)
+	}
+
+	// The capabilities are serialized as one parameter.
+	data := testSerializeBGP(t, &b)
+	if b.Length != 75-10 || data[28] != 36 || data[29] != 2 || data[30] != 34 {
+		t.Fatalf("serialized %x", data)
+	}
+	var again BGP
+	if err := again.DecodeFromBytes(data, gopacket.NilDecodeFeedback); err != nil {
+		t.Fatal(err)
+	}
+	for i := range again.Open.Capabilities {
+		if c, w := again.Open.Capabilities[i], o.Capabilities[i]; !bytes.Equal(c.Data, w.Data) || c.Code != w.Code {
+			t.Errorf("capability %v reserialized as %x, want %x", c.Code, c.Data, w.Data)
+		}
+	}
+}
+
+func TestBGPOpenExtendedParameters(t *testing.T) {
+	// Enough ADD-PATH families for the parameters to need the extended
+	// format of RFC 9072.
+	c := BGPCapability{Code: BGPCapabilityAddPath}
+	for i := 0; i < 70; i++ {
+		c.AddPath = append(c.AddPath, BGPAddPathFamily{BGPAddressFamily{BGPAFI(i), BGPSAFIUnicast}, BGPAddPathReceive})
+	}
+	caps := []BGPCapability{{Code: BGPCapabilityFourOctetAS, ASN: 4200000000}}
+	for i := 0; i < 4; i++ {
+		c.AddPath = c.AddPath[:60]
+		caps = append(caps, BGPCapability{Code: BGPCapabilityAddPath, AddPath: c.AddPath})
+	}
+	b := &BGP{Type: BGPTypeOpen, Open: BGPOpen{Version: 4, MyAS: 23456, Identifier: net.IP{192, 0, 2, 1}, Capabilities: caps}}
+	data := testSerializeBGP(t, b)
+	if data[28] != 255 || data[29] != 255 {
+		t.Fatalf("parameters length %x", data[28:32])
+	}
+	var got BGP
+	if err := got.DecodeFromBytes(data, gopacket.NilDecodeFeedback); err != nil {
+		t.Fatal(err)
+	}
+	if got.Open.ASN() != 4200000000 || len(got.Open.Capabilities) != 5 || len(got.Open.Capabilities[4].AddPath) != 60 {
+		t.Errorf("OPEN %+v", got.Open)
+	}
+}
+
+func TestBGPSession(t *testing.T) {
+	ipv4 := BGPAddressFamily{BGPAFIIPv4, BGPSAFIUnicast}
+	ipv6 := BGPAddressFamily{BGPAFIIPv6, BGPSAFIUnicast}
+	a := &BGPOpen{Capabilities: []BGPCapability{
+		{Code: BGPCapabilityFourOctetAS, ASN: 65000},
+		{Code: BGPCapabilityAddPath, AddPath: []BGPAddPathFamily{{ipv4, BGPAddPathSend}, {ipv6, BGPAddPathBoth}}},
+	}}
+	b := &BGPOpen{Capabilities: []BGPCapability{
+		{Code: BGPCapabilityFourOctetAS, ASN: 65001},
+		{Code: BGPCapabilityAddPath, AddPath: []BGPAddPathFamily{{ipv4, BGPAddPathReceive}, {ipv6, BGPAddPathSend}}},
+	}}
+	if s := NewBGPSession(a, b); s.TwoOctetAS || !reflect.DeepEqual(s.AddPath, []BGPAddressFamily{ipv4}) {
+		t.Errorf("session of a %+v", s)
+	}
+	if s := NewBGPSession(b, a); s.TwoOctetAS || !reflect.DeepEqual(s.AddPath, []BGPAddressFamily{ipv6}) {
+		t.Errorf("session of b %+v", s)
+	}
+	if s := NewBGPSession(a, &BGPOpen{}); !s.TwoOctetAS || len(s.AddPath) != 0 {
+		t.Errorf("session with old speaker %+v", s)
+	}
+}
+
+// testBGPUpdate withdraws 192.168.100.0/24 and announces 10.1.0.0/16 and
+// 192.0.2.1/32.
+var testBGPUpdate = testBGPMarker + "005c02" + "0004" + "18c0a864" + "0039" +
+	"40010100" + // ORIGIN IGP
+	"40020e0203" + "0000fde8" + "0000fde9" + "00030d40" + // AS_PATH 65000 65001 200000
+	"4003040a000001" + // NEXT_HOP
+	"80040400000064" + // MED 100
+	"c00804fde80064" + // COMMUNITIES 65000:100
+	"c0200c" + "0000fde8" + "00000001" + "00000002" + // LARGE_COMMUNITY
+	"100a01" + "20c0000201"
+
+func TestBGPUpdate(t *testing.T) {
+	data := testHex(t, testBGPUpdate)
+	var b BGP
+	if err := b.DecodeFromBytes(data, gopacket.NilDecodeFeedback); err != nil {
+		t.Fatal(err)
+	}
+	u := &b.Update
+	if len(u.WithdrawnRoutes) != 1 || u.WithdrawnRoutes[0].Prefix.String() != "192.168.100.0/24" ||
+		len(u.NLRI) != 2 || u.NLRI[0].Prefix.String() != "10.1.0.0/16" || u.NLRI[1].String() != "192.0.2.1/32" {
+		t.Errorf("routes %v, %v", u.WithdrawnRoutes, u.NLRI)
+	}
+	if len(u.PathAttributes) != 6 {
+		t.Fatalf("attributes %+v", u.PathAttributes)
+	}
+	want := []BGPASPathSegment{{BGPASSequence, []uint32{65000, 65001, 200000}}}
+	if a := u.Attribute(BGPAttributeASPath); !reflect.DeepEqual(a.ASPath, want) {
+		t.Errorf("AS path %+v", a.ASPath)
+	}
+	if a := u.Attribute(BGPAttributeNextHop); !a.NextHop.Equal(net.IP{10, 0, 0, 1}) || a.Flags != BGPAttributeTransitive {
+		t.Errorf("next hop %+v", a)
+	}
+	if u.Attribute(BGPAttributeMED).MED != 100 || u.Attribute(BGPAttributeOrigin).Origin != BGPOriginIGP {
+		t.Error("MED or origin")
+	}
+	if c := u.Attribute(BGPAttributeCommunities).Communities; len(c) != 1 || c[0].String() != "65000:100" {
+		t.Errorf("communities %v", c)
+	}
+	if c := u.Attribute(BGPAttributeLargeCommunities).LargeCommunities; len(c) != 1 || c[0].String() != "65000:1:2" {
+		t.Errorf("large communities %v", c)
+	}
+	if _, ok := u.EndOfRIB(); ok {
+		t.Error("End-of-RIB")
+	}
+	if got := testSerializeBGP(t, &b); !bytes.Equal(got, data) {
+		t.Errorf("serialized\n%x, want\n%x", got, data)
+	}
+
+	// Sessions with old speakers have 2-octet AS paths.
+	b = BGP{Session: BGPSession{TwoOctetAS: true}, Type: BGPTypeUpdate}
+	b.Update.PathAttributes = []BGPPathAttribute{{Flags: BGPAttributeTransitive, Type: BGPAttributeASPath, ASPath: []BGPASPathSegment{{BGPASSequence, []uint32{65000, 23456}}}}}
+	data = testSerializeBGP(t, &b)
+	if !bytes.HasSuffix(data, []byte{0x40, 2, 6, 2, 2, 0xfd, 0xe8, 0x5b, 0xa0}) {
+		t.Errorf("2-octet AS path %x", data)
+	}
+	b.Session.TwoOctetAS = false
+	if err := b.DecodeFromBytes(data, gopacket.NilDecodeFeedback); err == nil {
+		t.Error("decoded 2-octet AS path as 4-octet")
+	}
+}
+
+func TestBGPMPReach(t *testing.T) {
+	// A VPNv4 route, label 100, RD 65000:100, and an IPv6 End-of-RIB.
+	vpn := testBGPMarker + "003b02" + "0000" + "0024" +
+		"900e0020" + "000180" + "0c" + "0000000000000000" + "0a000001" + "00" +
+		"70" + "000641" + "0000fde800000064" + "0a0200"
+	var b BGP
+	if err := b.DecodeFromBytes(testHex(t, vpn), gopacket.NilDecodeFeedback); err != nil {
+		t.Fatal(err)
+	}
+	r := b.Update.PathAttributes[0].MPReach
+	if r.BGPAddressFamily != (BGPAddressFamily{BGPAFIIPv4, BGPSAFIMPLSVPN}) || len(r.NextHops) != 1 || !r.NextHops[0].Equal(net.IP{10, 0, 0, 1}) || len(r.NLRI) != 1 {
+		t.Fatalf("MP_REACH_NLRI %+v", r)
+	}
+	if n := r.NLRI[0]; !reflect.DeepEqual(n.Labels, []uint32{0x641}) || n.RD.String() != "65000:100" || n.Prefix.String() != "10.2.0.0/24" {
+		t.Errorf("VPN route %+v", n)
+	}
+	if got := testSerializeBGP(t, &b); !bytes.Equal(got, testHex(t, vpn)) {
+		t.Errorf("serialized\n%x, want\n%x", got, testHex(t, vpn))
+	}
+
+	eor := testBGPMarker + "001d02" + "0000" + "0006" + "800f03000201"
+	if err := b.DecodeFromBytes(testHex(t, eor), gopacket.NilDecodeFeedback); err != nil {
+		t.Fatal(err)
+	}
+	if f, ok := b.Update.EndOfRIB(); !ok || f != (BGPAddressFamily{BGPAFIIPv6, BGPSAFIUnicast}) {
+		t.Errorf("End-of-RIB %v %v", f, ok)
+	}
+}
+
+func TestBGPMultiprotocolRoundTrip(t *testing.T) {
+	_, v6, _ := net.ParseCIDR("2001:db8:1::/48")
+	_, v4, _ := net.ParseCIDR("10.9.0.0/16")
+	mac := net.HardwareAddr{0x00, 0x11, 0x22, 0x33, 0x44, 0x55}
+	b := &BGP{
+		Session: BGPSession{AddPath: []BGPAddressFamily{{BGPAFIIPv6, BGPSAFIUnicast}}},
+		Type:    BGPTypeUpdate,
+		Update: BGPUpdate{PathAttributes: []BGPPathAttribute{
+			{Flags: BGPAttributeOptional, Type: BGPAttributeMPReachNLRI, MPReach: BGPMPReachNLRI{
+				BGPAddressFamily: BGPAddressFamily{BGPAFIIPv6, BGPSAFIUnicast},
+				NextHops:         []net.IP{net.ParseIP("2001:db8::1"), net.ParseIP("fe80::1")},
+				NLRI:             []BGPNLRI{{PathID: 7, Prefix: *v6}},
+			}},
+			{Flags: BGPAttributeOptional, Type: BGPAttributeMPReachNLRI, MPReach: BGPMPReachNLRI{
+				BGPAddressFamily: BGPAddressFamily{BGPAFIL2VPN, BGPSAFIEVPN},
+				NextHops:         []net.IP{net.IP{192, 0, 2, 1}},
+				NLRI: []BGPNLRI{
+					{RD: 1<<48 | 0xc0000201<<16 | 5, Labels: []uint32{0x3e8 << 4},
+						EVPN: BGPEVPNRoute{Type: BGPEVPNMACIPAdvertisement, EthernetTag: 0, MAC: mac, IP: net.IP{10, 0, 0, 5}}},
+					{RD: 65000<<32 | 1, EVPN: BGPEVPNRoute{Type: BGPEVPNInclusiveMulticast, IP: net.IP{192, 0, 2, 1}}},
+					{RD: 65000<<32 | 1, Prefix: *v4, Labels: []uint32{0x7d0 << 4},
+						EVPN: BGPEVPNRoute{Type: BGPEVPNIPPrefix, Gateway: net.IPv4zero.To4()}},
+				},
+			}},
+			{Flags: BGPAttributeOptional | BGPAttributeTransitive, Type: BGPAttributeExtendedCommunities,
+				ExtendedCommunities: []BGPExtendedCommunity{0x0002fde800000064}},
+		}},
+	}
+	data := testSerializeBGP(t, b)
+	got := &BGP{Session: b.Session}
+	if err := got.DecodeFromBytes(data, gopacket.NilDecodeFeedback); err != nil {
+		t.Fatal(err)
+	}
+	if again := testSerializeBGP(t, got); !bytes.Equal(again, data) {
+		t.Errorf("reserialized\n%x, want\n%x", again, data)
+	}
+
+	r6 := got.Update.PathAttributes[0].MPReach
+	if len(r6.NextHops) != 2 || !r6.NextHops[1].Equal(net.ParseIP("fe80::1")) || len(r6.NLRI) != 1 ||
+		r6.NLRI[0].PathID != 7 || r6.NLRI[0].Prefix.String() != "2001:db8:1::/48" {
+		t.Errorf("IPv6 %+v", r6)
+	}
+	evpn := got.Update.PathAttributes[1].MPReach.NLRI
+	if len(evpn) != 3 {
+		t.Fatalf("EVPN routes %+v", evpn)
+	}
+	if n := evpn[0]; n.RD.String() != "192.0.2.1:5" || n.EVPN.MAC.String() != mac.String() || !n.EVPN.IP.Equal(net.IP{10, 0, 0, 5}) ||
+		!reflect.DeepEqual(n.Labels, []uint32{0x3e80}) {
+		t.Errorf("MAC/IP route %+v", n)
+	}
+	if n := evpn[1]; n.EVPN.Type != BGPEVPNInclusiveMulticast || !n.EVPN.IP.Equal(net.IP{192, 0, 2, 1}) || n.RD.String() != "65000:1" {
+		t.Errorf("inclusive multicast route %+v", n)
+	}
+	if n := evpn[2]; n.Prefix.String() != "10.9.0.0/16" || !n.EVPN.Gateway.Equal(net.IPv4zero) {
+		t.Errorf("IP prefix route %+v", n)
+	}
+	if c := got.Update.Attribute(BGPAttributeExtendedCommunities).ExtendedCommunities; len(c) != 1 || c[0] != 0x0002fde800000064 {
+		t.Errorf("extended communities %x", c)
+	}
+
+	// Without ADD-PATH the path identifier is taken for a prefix.
+	got.Session = BGPSession{}
+	if err := got.DecodeFromBytes(data, gopacket.NilDecodeFeedback); err == nil {
+		t.Error("decoded without ADD-PATH")
+	}
+}
+
+func TestBGPPacket(t *testing.T) {
+	notification := &BGP{Type: BGPTypeNotification, Notification: BGPNotification{Code: BGPErrorCease, Subcode: 2, Data: []byte("bye")}}
+	refresh := &BGP{Type: BGPTypeRouteRefresh, RouteRefresh: BGPRouteRefresh{BGPAddressFamily: BGPAddressFamily{BGPAFIIPv6, BGPSAFIUnicast}}}
+	ip := &IPv4{Version: 4, TTL: 1, Protocol: IPProtocolTCP, SrcIP: net.IP{10, 0, 0, 1}, DstIP: net.IP{10, 0, 0, 2}}
+	tcp := &TCP{SrcPort: 179, DstPort: 40000, ACK: true, PSH: true, Window: 1000}
+	tcp.SetNetworkLayerForChecksum(ip)
+	buf := gopacket.NewSerializeBuffer()
+	opts := gopacket.SerializeOptions{FixLengths: true, ComputeChecksums: true}
+	if err := gopacket.SerializeLayers(buf, opts, ip, tcp, &BGP{Type: BGPTypeKeepalive}, refresh, notification); err != nil {
+		t.Fatal(err)
+	}
+	p := gopacket.NewPacket(buf.Bytes(), LayerTypeIPv4, gopacket.DecodeOptions{DecodeStreamsAsDatagrams: true})
+	if p.ErrorLayer() != nil {
+		t.Fatal(p.ErrorLayer().Error())
+	}
+	checkLayers(p, []gopacket.LayerType{LayerTypeIPv4, LayerTypeTCP, LayerTypeBGP, LayerTypeBGP, LayerTypeBGP}, t)
+	ls := p.Layers()
+	if b := ls[2].(*BGP); b.Type != BGPTypeKeepalive || b.Length != 19 || len(b.Payload) != 23+24 {
+		t.Errorf("KEEPALIVE %+v", b)
+	}
+	if b := ls[3].(*BGP); b.RouteRefresh != refresh.RouteRefresh {
+		t.Errorf("ROUTE-REFRESH %+v", b.RouteRefresh)
+	}
+	if b := ls[4].(*BGP); !reflect.DeepEqual(b.Notification, notification.Notification) || b.Notification.Code.String() != "Cease" {
+		t.Errorf("NOTIFICATION %+v", b.Notification)
+	}
+
+	// A packet in the middle of a message is payload.
+	data := buf.Bytes()[:len(buf.Bytes())-5]
+	p = gopacket.NewPacket(append(data[:40:40], data[45:]...), LayerTypeIPv4, gopacket.DecodeOptions{DecodeStreamsAsDatagrams: true})
+	checkLayers(p, []gopacket.LayerType{LayerTypeIPv4, LayerTypeTCP, gopacket.LayerTypePayload}, t)
+	// So is a message continued in the next packet.
+	p = gopacket.NewPacket(data, LayerTypeIPv4, gopacket.DecodeOptions{DecodeStreamsAsDatagrams: true})
+	if p.ErrorLayer() != nil {
+		t.Fatal(p.ErrorLayer().Error())
+	}
+	checkLayers(p, []gopacket.LayerType{LayerTypeIPv4, LayerTypeTCP, LayerTypeBGP, LayerTypeBGP, gopacket.LayerTypePayload}, t)
+	if pl := p.ApplicationLayer().Payload(); len(pl) != 24-5 {
+		t.Errorf("partial message payload %x", pl)
+	}
+}
+
+func TestBGPMessageLength(t *testing.T) {
+	stream := testHex(t, testBGPOpen+testBGPUpdate)
+	var types []BGPType
+	for len(stream) > 0 {
+		n, err := BGPMessageLength(stream)
+		if err != nil || n == 0 || n > len(stream) {
+			t.Fatalf("length %d, %v", n, err)
+		}
+		var b BGP
+		if err := b.DecodeFromBytes(stream[:n], gopacket.NilDecodeFeedback); err != nil {
+			t.Fatal(err)
+		}
+		types = append(types, b.Type)
+		stream = stream[n:]
+	}
+	if len(types) != 2 || types[0] != BGPTypeOpen || types[1] != BGPTypeUpdate {
+		t.Errorf("types %v", types)
+	}
+	if n, err := BGPMessageLength(testHex(t, testBGPMarker)); n != 0 || err != nil {
+		t.Errorf("partial header %d, %v", n, err)
+	}
+	if _, err := BGPMessageLength(make([]byte, 19)); err == nil {
+		t.Error("bad marker")
+	}
+
+	for _, data := range []string{
+		testBGPMarker + "001204",
+		testBGPMarker + "00140400",
+		testBGPMarker + "001409",
+		testBGPMarker + "001e02" + "0000" + "0007" + "40010200",
+		testBGPMarker + "001b02" + "0000" + "0000" + "21000000",
+	} {
+		var b BGP
+		if err := b.DecodeFromBytes(testHex(t, data), gopacket.NilDecodeFeedback); err == nil {
+			t.Errorf("%s decoded", data[32:])
+		}
+	}
+}
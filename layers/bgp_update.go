@@ -0,0 +1,933 @@
+// Copyright 2019 The GoPacket Authors. All rights reserved.
+//
+// Use of this source code is governed by a BSD-style license
+// that can be found in the LICENSE file in the root of the source
+// tree.
+
+package layers
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+)
+
+// BGPAttributeFlags are the flags of a path attribute.
+type BGPAttributeFlags uint8
+
+// The BGPAttributeFlags.
+const (
+	BGPAttributeOptional       BGPAttributeFlags = 0x80
+	BGPAttributeTransitive     BGPAttributeFlags = 0x40
+	BGPAttributePartial        BGPAttributeFlags = 0x20
+	BGPAttributeExtendedLength BGPAttributeFlags = 0x10
+)
+
+// BGPAttributeType is the type of a path attribute.
+type BGPAttributeType uint8
+
+// BGPAttributeType known values.
+const (
+	BGPAttributeOrigin              BGPAttributeType = 1
+	BGPAttributeASPath              BGPAttributeType = 2
+	BGPAttributeNextHop             BGPAttributeType = 3
+	BGPAttributeMED                 BGPAttributeType = 4
+	BGPAttributeLocalPref           BGPAttributeType = 5
+	BGPAttributeAtomicAggregate     BGPAttributeType = 6
+	BGPAttributeAggregator          BGPAttributeType = 7
+	BGPAttributeCommunities         BGPAttributeType = 8
+	BGPAttributeOriginatorID        BGPAttributeType = 9
+	BGPAttributeClusterList         BGPAttributeType = 10
+	BGPAttributeMPReachNLRI         BGPAttributeType = 14
+	BGPAttributeMPUnreachNLRI       BGPAttributeType = 15
+	BGPAttributeExtendedCommunities BGPAttributeType = 16
+	BGPAttributeAS4Path             BGPAttributeType = 17
+	BGPAttributeAS4Aggregator       BGPAttributeType = 18
+	BGPAttributeLargeCommunities    BGPAttributeType = 32
+)
+
+func (t BGPAttributeType) String() string {
+	switch t {
+	case BGPAttributeOrigin:
+		return "ORIGIN"
+	case BGPAttributeASPath:
+		return "AS_PATH"
+	case BGPAttributeNextHop:
+		return "NEXT_HOP"
+	case BGPAttributeMED:
+		return "MULTI_EXIT_DISC"
+	case BGPAttributeLocalPref:
+		return "LOCAL_PREF"
+	case BGPAttributeAtomicAggregate:
+		return "ATOMIC_AGGREGATE"
+	case BGPAttributeAggregator:
+		return "AGGREGATOR"
+	case BGPAttributeCommunities:
+		return "COMMUNITIES"
+	case BGPAttributeOriginatorID:
+		return "ORIGINATOR_ID"
+	case BGPAttributeClusterList:
+		return "CLUSTER_LIST"
+	case BGPAttributeMPReachNLRI:
+		return "MP_REACH_NLRI"
+	case BGPAttributeMPUnreachNLRI:
+		return "MP_UNREACH_NLRI"
+	case BGPAttributeExtendedCommunities:
+		return "EXTENDED_COMMUNITIES"
+	case BGPAttributeAS4Path:
+		return "AS4_PATH"
+	case BGPAttributeAS4Aggregator:
+		return "AS4_AGGREGATOR"
+	case BGPAttributeLargeCommunities:
+		return "LARGE_COMMUNITY"
+	}
+	return fmt.Sprintf("Attribute(%d)", uint8(t))
+}
+
+// BGPOrigin is the value of an ORIGIN attribute.
+type BGPOrigin uint8
+
+// BGPOrigin known values.
+const (
+	BGPOriginIGP        BGPOrigin = 0
+	BGPOriginEGP        BGPOrigin = 1
+	BGPOriginIncomplete BGPOrigin = 2
+)
+
+func (o BGPOrigin) String() string {
+	switch o {
+	case BGPOriginIGP:
+		return "IGP"
+	case BGPOriginEGP:
+		return "EGP"
+	case BGPOriginIncomplete:
+		return "INCOMPLETE"
+	}
+	return fmt.Sprintf("Origin(%d)", uint8(o))
+}
+
+// BGPASPathSegmentType is the type of a segment of an AS path.
+type BGPASPathSegmentType uint8
+
+// BGPASPathSegmentType known values.
+const (
+	BGPASSet            BGPASPathSegmentType = 1
+	BGPASSequence       BGPASPathSegmentType = 2
+	BGPASConfedSequence BGPASPathSegmentType = 3
+	BGPASConfedSet      BGPASPathSegmentType = 4
+)
+
+// BGPASPathSegment is a segment of an AS_PATH or AS4_PATH attribute.
+type BGPASPathSegment struct {
+	Type BGPASPathSegmentType
+	ASNs []uint32
+}
+
+// BGPAggregator is the value of an AGGREGATOR or AS4_AGGREGATOR attribute.
+type BGPAggregator struct {
+	ASN     uint32
+	Address net.IP
+}
+
+// BGPCommunity is a community of a COMMUNITIES attribute, see RFC 1997.
+type BGPCommunity uint32
+
+func (c BGPCommunity) String() string {
+	return fmt.Sprintf("%d:%d", c>>16, c&0xffff)
+}
+
+// BGPExtendedCommunity is a community of an EXTENDED_COMMUNITIES attribute,
+// see RFC 4360, whose first byte is its type, and second, for most types,
+// its subtype.
+type BGPExtendedCommunity uint64
+
+// BGPLargeCommunity is a community of a LARGE_COMMUNITY attribute, see RFC
+// 8092.
+type BGPLargeCommunity struct {
+	GlobalAdmin, LocalData1, LocalData2 uint32
+}
+
+func (c BGPLargeCommunity) String() string {
+	return fmt.Sprintf("%d:%d:%d", c.GlobalAdmin, c.LocalData1, c.LocalData2)
+}
+
+// BGPRouteDistinguisher is the route distinguisher of the routes of VPNs,
+// see RFC 4364 section 4.2.
+type BGPRouteDistinguisher uint64
+
+func (rd BGPRouteDistinguisher) String() string {
+	switch rd >> 48 {
+	case 0:
+		return fmt.Sprintf("%d:%d", rd>>32&0xffff, rd&0xffffffff)
+	case 1:
+		ip := net.IPv4(byte(rd>>40), byte(rd>>32), byte(rd>>24), byte(rd>>16))
+		return fmt.Sprintf("%v:%d", ip, rd&0xffff)
+	case 2:
+		return fmt.Sprintf("%d:%d", rd>>16&0xffffffff, rd&0xffff)
+	}
+	return fmt.Sprintf("%#016x", uint64(rd))
+}
+
+// BGPEVPNRouteType is the type of an EVPN route, see RFC 7432 and RFC 9136.
+type BGPEVPNRouteType uint8
+
+// BGPEVPNRouteType known values.
+const (
+	BGPEVPNEthernetAutoDiscovery BGPEVPNRouteType = 1
+	BGPEVPNMACIPAdvertisement    BGPEVPNRouteType = 2
+	BGPEVPNInclusiveMulticast    BGPEVPNRouteType = 3
+	BGPEVPNEthernetSegment       BGPEVPNRouteType = 4
+	BGPEVPNIPPrefix              BGPEVPNRouteType = 5
+)
+
+// BGPEVPNRoute holds the fields of an EVPN route which aren't those of all
+// NLRI.  Routes of unknown types are kept in Data.
+type BGPEVPNRoute struct {
+	Type BGPEVPNRouteType
+	// ESI is the Ethernet segment identifier of all types but 3.
+	ESI [10]byte
+	// EthernetTag is that of types 1, 2, 3 and 5.
+	EthernetTag uint32
+	// MAC is the MAC address of type 2.
+	MAC net.HardwareAddr
+	// IP is the IP address of type 2, which may be nil, and the originating
+	// router's IP address of types 3 and 4.
+	IP net.IP
+	// Gateway is the gateway IP address of type 5.
+	Gateway net.IP
+	Data    []byte
+}
+
+// BGPNLRI is a route of the NLRI or withdrawn routes of an UPDATE message, or
+// of its MP_REACH_NLRI and MP_UNREACH_NLRI attributes.
+type BGPNLRI struct {
+	// PathID is the path identifier of ADD-PATH sessions.
+	PathID uint32
+	// Labels are the MPLS label stack entries of labeled and VPN routes,
+	// as sent: each is a 20-bit label, 3 traffic class bits and the bottom
+	// of stack bit.
+	Labels []uint32
+	// RD is the route distinguisher of VPN and EVPN routes.
+	RD BGPRouteDistinguisher
+	// Prefix is the prefix of the route, but for EVPN routes other than
+	// those of IP prefixes.
+	Prefix net.IPNet
+	// EVPN holds the fields of EVPN routes.
+	EVPN BGPEVPNRoute
+}
+
+func (n *BGPNLRI) String() string {
+	s := n.Prefix.String()
+	if n.Prefix.IP == nil {
+		s = fmt.Sprintf("EVPN type %d", n.EVPN.Type)
+		if n.EVPN.MAC != nil {
+			s += " " + n.EVPN.MAC.String()
+		}
+		if n.EVPN.IP != nil {
+			s += " " + n.EVPN.IP.String()
+		}
+	}
+	if n.RD != 0 {
+		s = "RD " + n.RD.String() + " " + s
+	}
+	if n.PathID != 0 {
+		s += fmt.Sprintf(" path %d", n.PathID)
+	}
+	return s
+}
+
+// BGPMPReachNLRI is the value of an MP_REACH_NLRI attribute, see RFC 4760.
+type BGPMPReachNLRI struct {
+	BGPAddressFamily
+	// NextHops is the next hop, followed for IPv6 by any link-local next
+	// hop.  The zero route distinguishers of the next hops of VPN families
+	// are left out.
+	NextHops []net.IP
+	NLRI     []BGPNLRI
+	// RawNLRI holds the NLRI of families whose NLRI aren't decoded.
+	RawNLRI []byte
+}
+
+// BGPMPUnreachNLRI is the value of an MP_UNREACH_NLRI attribute.
+type BGPMPUnreachNLRI struct {
+	BGPAddressFamily
+	Withdrawn    []BGPNLRI
+	RawWithdrawn []byte
+}
+
+// BGPPathAttribute is a path attribute of an UPDATE message.  The values of
+// the attributes of the known types are decoded into the fields of their
+// type, from which they are also encoded; other attributes are encoded from
+// Data.
+type BGPPathAttribute struct {
+	// Flags are set to have the extended length bit when the value is too
+	// long for a byte.
+	Flags BGPAttributeFlags
+	Type  BGPAttributeType
+	// Data is the value as it was sent.
+	Data []byte
+
+	Origin BGPOrigin
+	// ASPath is the value of AS_PATH and AS4_PATH attributes.
+	ASPath  []BGPASPathSegment
+	NextHop net.IP
+	MED     uint32
+	// LocalPref is the value of LOCAL_PREF attributes.
+	LocalPref uint32
+	// Aggregator is the value of AGGREGATOR and AS4_AGGREGATOR attributes.
+	Aggregator          BGPAggregator
+	Communities         []BGPCommunity
+	OriginatorID        net.IP
+	ClusterList         []net.IP
+	MPReach             BGPMPReachNLRI
+	MPUnreach           BGPMPUnreachNLRI
+	ExtendedCommunities []BGPExtendedCommunity
+	LargeCommunities    []BGPLargeCommunity
+}
+
+// BGPUpdate is the body of an UPDATE message, see RFC 4271 section 4.3.
+// Its withdrawn routes and NLRI are IPv4 unicast routes; those of other
+// families are in MP_REACH_NLRI and MP_UNREACH_NLRI attributes.
+type BGPUpdate struct {
+	WithdrawnRoutes []BGPNLRI
+	PathAttributes  []BGPPathAttribute
+	NLRI            []BGPNLRI
+}
+
+// Attribute returns the first path attribute of the given type, or nil.
+func (u *BGPUpdate) Attribute(t BGPAttributeType) *BGPPathAttribute {
+	for i := range u.PathAttributes {
+		if u.PathAttributes[i].Type == t {
+			return &u.PathAttributes[i]
+		}
+	}
+	return nil
+}
+
+// EndOfRIB tells whether the message is the End-of-RIB marker of a family,
+// see RFC 4724 section 2: an empty UPDATE for IPv4 unicast, or one with
+// nothing but an empty MP_UNREACH_NLRI attribute.
+func (u *BGPUpdate) EndOfRIB() (BGPAddressFamily, bool) {
+	if len(u.WithdrawnRoutes) > 0 || len(u.NLRI) > 0 {
+		return BGPAddressFamily{}, false
+	}
+	switch len(u.PathAttributes) {
+	case 0:
+		return BGPAddressFamily{BGPAFIIPv4, BGPSAFIUnicast}, true
+	case 1:
+		if a := &u.PathAttributes[0]; a.Type == BGPAttributeMPUnreachNLRI && len(a.Data) == 3 {
+			return a.MPUnreach.BGPAddressFamily, true
+		}
+	}
+	return BGPAddressFamily{}, false
+}
+
+var bgpIPv4Unicast = BGPAddressFamily{BGPAFIIPv4, BGPSAFIUnicast}
+
+func (u *BGPUpdate) decode(data []byte, s *BGPSession) error {
+	*u = BGPUpdate{}
+	if len(data) < 4 {
+		return errors.New("BGP UPDATE message too short")
+	}
+	n := int(binary.BigEndian.Uint16(data))
+	if len(data) < 4+n {
+		return errors.New("BGP UPDATE withdrawn routes length too long")
+	}
+	var err error
+	if u.WithdrawnRoutes, err = decodeBGPNLRI(data[2:2+n], bgpIPv4Unicast, s.addPath(bgpIPv4Unicast)); err != nil {
+		return err
+	}
+	data = data[2+n:]
+	n = int(binary.BigEndian.Uint16(data))
+	if len(data) < 2+n {
+		return errors.New("BGP UPDATE path attributes length too long")
+	}
+	attrs := data[2 : 2+n]
+	for len(attrs) > 0 {
+		if len(attrs) < 3 {
+			return errors.New("BGP path attribute truncated")
+		}
+		a := BGPPathAttribute{Flags: BGPAttributeFlags(attrs[0]), Type: BGPAttributeType(attrs[1])}
+		hdr, vlen := 3, int(attrs[2])
+		if a.Flags&BGPAttributeExtendedLength != 0 {
+			if len(attrs) < 4 {
+				return errors.New("BGP path attribute truncated")
+			}
+			hdr, vlen = 4, int(binary.BigEndian.Uint16(attrs[2:]))
+		}
+		if len(attrs) < hdr+vlen {
+			return errors.New("BGP path attribute truncated")
+		}
+		if err := a.decode(attrs[hdr:hdr+vlen], s); err != nil {
+			return err
+		}
+		u.PathAttributes = append(u.PathAttributes, a)
+		attrs = attrs[hdr+vlen:]
+	}
+	u.NLRI, err = decodeBGPNLRI(data[2+n:], bgpIPv4Unicast, s.addPath(bgpIPv4Unicast))
+	return err
+}
+
+func (u *BGPUpdate) encode(b []byte, s *BGPSession) ([]byte, error) {
+	var err error
+	b = append(b, 0, 0)
+	start := len(b)
+	if b, err = encodeBGPNLRI(b, u.WithdrawnRoutes, bgpIPv4Unicast, s.addPath(bgpIPv4Unicast)); err != nil {
+		return nil, err
+	}
+	binary.BigEndian.PutUint16(b[start-2:], uint16(len(b)-start))
+	b = append(b, 0, 0)
+	start = len(b)
+	for i := range u.PathAttributes {
+		if b, err = u.PathAttributes[i].encode(b, s); err != nil {
+			return nil, err
+		}
+	}
+	binary.BigEndian.PutUint16(b[start-2:], uint16(len(b)-start))
+	return encodeBGPNLRI(b, u.NLRI, bgpIPv4Unicast, s.addPath(bgpIPv4Unicast))
+}
+
+func bgpIPs(data []byte) []net.IP {
+	ips := make([]net.IP, len(data)/4)
+	for i := range ips {
+		ips[i] = net.IP(data[4*i : 4*i+4])
+	}
+	return ips
+}
+
+func (a *BGPPathAttribute) decode(data []byte, s *BGPSession) error {
+	a.Data = data
+	// lengthIs checks for attributes of a fixed length, or of a multiple of
+	// the length with multiple set.
+	lengthIs := func(n int, multiple bool) error {
+		if len(data) == n || multiple && len(data)%n == 0 {
+			return nil
+		}
+		return fmt.Errorf("BGP %v attribute has bad length %d", a.Type, len(data))
+	}
+	switch a.Type {
+	case BGPAttributeOrigin:
+		if err := lengthIs(1, false); err != nil {
+			return err
+		}
+		a.Origin = BGPOrigin(data[0])
+	case BGPAttributeASPath, BGPAttributeAS4Path:
+		width := 4
+		if s.TwoOctetAS && a.Type == BGPAttributeASPath {
+			width = 2
+		}
+		for d := data; len(d) > 0; {
+			if len(d) < 2 || len(d) < 2+width*int(d[1]) {
+				return fmt.Errorf("BGP %v segment truncated", a.Type)
+			}
+			seg := BGPASPathSegment{Type: BGPASPathSegmentType(d[0]), ASNs: make([]uint32, d[1])}
+			for i := range seg.ASNs {
+				if width == 2 {
+					seg.ASNs[i] = uint32(binary.BigEndian.Uint16(d[2+2*i:]))
+				} else {
+					seg.ASNs[i] = binary.BigEndian.Uint32(d[2+4*i:])
+				}
+			}
+			a.ASPath = append(a.ASPath, seg)
+			d = d[2+width*len(seg.ASNs):]
+		}
+	case BGPAttributeNextHop, BGPAttributeOriginatorID:
+		if err := lengthIs(4, false); err != nil {
+			return err
+		}
+		if a.Type == BGPAttributeNextHop {
+			a.NextHop = net.IP(data)
+		} else {
+			a.OriginatorID = net.IP(data)
+		}
+	case BGPAttributeMED, BGPAttributeLocalPref:
+		if err := lengthIs(4, false); err != nil {
+			return err
+		}
+		if a.Type == BGPAttributeMED {
+			a.MED = binary.BigEndian.Uint32(data)
+		} else {
+			a.LocalPref = binary.BigEndian.Uint32(data)
+		}
+	case BGPAttributeAtomicAggregate:
+		return lengthIs(0, false)
+	case BGPAttributeAggregator, BGPAttributeAS4Aggregator:
+		// The length tells the width of the AS number of AGGREGATOR.
+		switch {
+		case len(data) == 6 && a.Type == BGPAttributeAggregator:
+			a.Aggregator = BGPAggregator{uint32(binary.BigEndian.Uint16(data)), net.IP(data[2:6])}
+		case len(data) == 8:
+			a.Aggregator = BGPAggregator{binary.BigEndian.Uint32(data), net.IP(data[4:8])}
+		default:
+			return lengthIs(8, false)
+		}
+	case BGPAttributeCommunities:
+		if err := lengthIs(4, true); err != nil {
+			return err
+		}
+		a.Communities = make([]BGPCommunity, len(data)/4)
+		for i := range a.Communities {
+			a.Communities[i] = BGPCommunity(binary.BigEndian.Uint32(data[4*i:]))
+		}
+	case BGPAttributeClusterList:
+		if err := lengthIs(4, true); err != nil {
+			return err
+		}
+		a.ClusterList = bgpIPs(data)
+	case BGPAttributeExtendedCommunities:
+		if err := lengthIs(8, true); err != nil {
+			return err
+		}
+		a.ExtendedCommunities = make([]BGPExtendedCommunity, len(data)/8)
+		for i := range a.ExtendedCommunities {
+			a.ExtendedCommunities[i] = BGPExtendedCommunity(binary.BigEndian.Uint64(data[8*i:]))
+		}
+	case BGPAttributeLargeCommunities:
+		if err := lengthIs(12, true); err != nil {
+			return err
+		}
+		a.LargeCommunities = make([]BGPLargeCommunity, len(data)/12)
+		for i := range a.LargeCommunities {
+			d := data[12*i:]
+			a.LargeCommunities[i] = BGPLargeCommunity{binary.BigEndian.Uint32(d), binary.BigEndian.Uint32(d[4:]), binary.BigEndian.Uint32(d[8:])}
+		}
+	case BGPAttributeMPReachNLRI:
+		return a.MPReach.decode(data, s)
+	case BGPAttributeMPUnreachNLRI:
+		if len(data) < 3 {
+			return errors.New("BGP MP_UNREACH_NLRI attribute too short")
+		}
+		u := &a.MPUnreach
+		u.BGPAddressFamily = BGPAddressFamily{BGPAFI(binary.BigEndian.Uint16(data)), BGPSAFI(data[2])}
+		if !bgpNLRIKnown(u.BGPAddressFamily) {
+			u.RawWithdrawn = data[3:]
+			return nil
+		}
+		var err error
+		u.Withdrawn, err = decodeBGPNLRI(data[3:], u.BGPAddressFamily, s.addPath(u.BGPAddressFamily))
+		return err
+	}
+	return nil
+}
+
+func (a *BGPPathAttribute) encode(b []byte, s *BGPSession) ([]byte, error) {
+	var v []byte
+	var err error
+	switch a.Type {
+	case BGPAttributeOrigin:
+		v = []byte{byte(a.Origin)}
+	case BGPAttributeASPath, BGPAttributeAS4Path:
+		for _, seg := range a.ASPath {
+			if len(seg.ASNs) > 255 {
+				return nil, errors.New("BGP AS path segment of more than 255 ASNs")
+			}
+			v = append(v, byte(seg.Type), byte(len(seg.ASNs)))
+			for _, asn := range seg.ASNs {
+				if s.TwoOctetAS && a.Type == BGPAttributeASPath {
+					v = append(v, byte(asn>>8), byte(asn))
+				} else {
+					v = appendUint32(v, asn)
+				}
+			}
+		}
+	case BGPAttributeNextHop:
+		v = append(v, a.NextHop.To4()...)
+	case BGPAttributeOriginatorID:
+		v = append(v, a.OriginatorID.To4()...)
+	case BGPAttributeMED:
+		v = appendUint32(v, a.MED)
+	case BGPAttributeLocalPref:
+		v = appendUint32(v, a.LocalPref)
+	case BGPAttributeAtomicAggregate:
+	case BGPAttributeAggregator, BGPAttributeAS4Aggregator:
+		if s.TwoOctetAS && a.Type == BGPAttributeAggregator {
+			v = append(v, byte(a.Aggregator.ASN>>8), byte(a.Aggregator.ASN))
+		} else {
+			v = appendUint32(v, a.Aggregator.ASN)
+		}
+		v = append(v, a.Aggregator.Address.To4()...)
+	case BGPAttributeCommunities:
+		for _, c := range a.Communities {
+			v = appendUint32(v, uint32(c))
+		}
+	case BGPAttributeClusterList:
+		for _, ip := range a.ClusterList {
+			v = append(v, ip.To4()...)
+		}
+	case BGPAttributeExtendedCommunities:
+		for _, c := range a.ExtendedCommunities {
+			v = appendUint32(appendUint32(v, uint32(c>>32)), uint32(c))
+		}
+	case BGPAttributeLargeCommunities:
+		for _, c := range a.LargeCommunities {
+			v = appendUint32(appendUint32(appendUint32(v, c.GlobalAdmin), c.LocalData1), c.LocalData2)
+		}
+	case BGPAttributeMPReachNLRI:
+		if v, err = a.MPReach.encode(v, s); err != nil {
+			return nil, err
+		}
+	case BGPAttributeMPUnreachNLRI:
+		u := &a.MPUnreach
+		v = append(v, byte(u.AFI>>8), byte(u.AFI), byte(u.SAFI))
+		if !bgpNLRIKnown(u.BGPAddressFamily) {
+			v = append(v, u.RawWithdrawn...)
+		} else if v, err = encodeBGPNLRI(v, u.Withdrawn, u.BGPAddressFamily, s.addPath(u.BGPAddressFamily)); err != nil {
+			return nil, err
+		}
+	default:
+		v = a.Data
+	}
+	if len(v) > 0xffff {
+		return nil, fmt.Errorf("BGP %v attribute too long", a.Type)
+	}
+	if len(v) > 255 {
+		a.Flags |= BGPAttributeExtendedLength
+	}
+	b = append(b, byte(a.Flags), byte(a.Type))
+	if a.Flags&BGPAttributeExtendedLength != 0 {
+		b = append(b, byte(len(v)>>8))
+	}
+	b = append(b, byte(len(v)))
+	return append(b, v...), nil
+}
+
+func (r *BGPMPReachNLRI) decode(data []byte, s *BGPSession) error {
+	if len(data) < 5 || len(data) < 5+int(data[3]) {
+		return errors.New("BGP MP_REACH_NLRI attribute truncated")
+	}
+	r.BGPAddressFamily = BGPAddressFamily{BGPAFI(binary.BigEndian.Uint16(data)), BGPSAFI(data[2])}
+	nh := data[4 : 4+int(data[3])]
+	// The next hops of VPN families have a route distinguisher.
+	rd := 0
+	if r.SAFI == BGPSAFIMPLSVPN {
+		rd = 8
+	}
+	switch len(nh) {
+	case 4 + rd, 16 + rd:
+		r.NextHops = []net.IP{net.IP(nh[rd:])}
+	case 32 + 2*rd:
+		r.NextHops = []net.IP{net.IP(nh[rd : rd+16]), net.IP(nh[2*rd+16:])}
+	default:
+		return fmt.Errorf("BGP MP_REACH_NLRI next hop has bad length %d", len(nh))
+	}
+	// A reserved byte follows the next hop.
+	nlri := data[5+len(nh):]
+	if !bgpNLRIKnown(r.BGPAddressFamily) {
+		r.RawNLRI = nlri
+		return nil
+	}
+	var err error
+	r.NLRI, err = decodeBGPNLRI(nlri, r.BGPAddressFamily, s.addPath(r.BGPAddressFamily))
+	return err
+}
+
+func (r *BGPMPReachNLRI) encode(b []byte, s *BGPSession) ([]byte, error) {
+	b = append(b, byte(r.AFI>>8), byte(r.AFI), byte(r.SAFI), 0)
+	start := len(b)
+	for _, ip := range r.NextHops {
+		if r.SAFI == BGPSAFIMPLSVPN {
+			b = append(b, 0, 0, 0, 0, 0, 0, 0, 0)
+		}
+		if ip4 := ip.To4(); ip4 != nil && len(r.NextHops) == 1 {
+			b = append(b, ip4...)
+		} else {
+			b = append(b, ip.To16()...)
+		}
+	}
+	if len(b)-start > 255 {
+		return nil, errors.New("BGP MP_REACH_NLRI next hop too long")
+	}
+	b[start-1] = byte(len(b) - start)
+	b = append(b, 0)
+	if !bgpNLRIKnown(r.BGPAddressFamily) {
+		return append(b, r.RawNLRI...), nil
+	}
+	return encodeBGPNLRI(b, r.NLRI, r.BGPAddressFamily, s.addPath(r.BGPAddressFamily))
+}
+
+// bgpNLRIKnown tells whether the NLRI of a family are decoded.
+func bgpNLRIKnown(f BGPAddressFamily) bool {
+	switch f.AFI {
+	case BGPAFIIPv4, BGPAFIIPv6:
+		switch f.SAFI {
+		case BGPSAFIUnicast, BGPSAFIMulticast, BGPSAFIMPLSLabel, BGPSAFIMPLSVPN:
+			return true
+		}
+	case BGPAFIL2VPN:
+		return f.SAFI == BGPSAFIEVPN
+	}
+	return false
+}
+
+// bgpWithdrawnLabel is the label stack entry of withdrawn labeled routes,
+// see RFC 8277 section 2.4.
+const bgpWithdrawnLabel = 0x800000
+
+// decodeBGPLabels decodes a label stack up to its bottom entry, or the
+// entry of withdrawn routes.
+func decodeBGPLabels(data []byte) ([]uint32, int, error) {
+	var labels []uint32
+	for off := 0; off+3 <= len(data); off += 3 {
+		l := uint32(data[off])<<16 | uint32(data[off+1])<<8 | uint32(data[off+2])
+		labels = append(labels, l)
+		if l&1 != 0 || l == bgpWithdrawnLabel || l == 0 {
+			return labels, off + 3, nil
+		}
+	}
+	return nil, 0, errors.New("BGP label stack truncated")
+}
+
+func appendBGPLabels(b []byte, labels []uint32) []byte {
+	for _, l := range labels {
+		b = append(b, byte(l>>16), byte(l>>8), byte(l))
+	}
+	return b
+}
+
+func decodeBGPNLRI(data []byte, f BGPAddressFamily, addPath bool) ([]BGPNLRI, error) {
+	var routes []BGPNLRI
+	for len(data) > 0 {
+		var n BGPNLRI
+		if addPath {
+			if len(data) < 4 {
+				return nil, errors.New("BGP NLRI path identifier truncated")
+			}
+			n.PathID = binary.BigEndian.Uint32(data)
+			data = data[4:]
+		}
+		if len(data) < 1 {
+			return nil, errors.New("BGP NLRI truncated")
+		}
+		if f.SAFI == BGPSAFIEVPN {
+			if len(data) < 2 || len(data) < 2+int(data[1]) {
+				return nil, errors.New("BGP EVPN NLRI truncated")
+			}
+			if err := n.decodeEVPN(BGPEVPNRouteType(data[0]), data[2:2+int(data[1])]); err != nil {
+				return nil, err
+			}
+			routes = append(routes, n)
+			data = data[2+int(data[1]):]
+			continue
+		}
+
+		bits := int(data[0])
+		size := (bits + 7) / 8
+		if len(data) < 1+size {
+			return nil, errors.New("BGP NLRI truncated")
+		}
+		d := data[1 : 1+size]
+		data = data[1+size:]
+		if f.SAFI == BGPSAFIMPLSLabel || f.SAFI == BGPSAFIMPLSVPN {
+			var off int
+			var err error
+			if n.Labels, off, err = decodeBGPLabels(d); err != nil {
+				return nil, err
+			}
+			d, bits = d[off:], bits-8*off
+		}
+		if f.SAFI == BGPSAFIMPLSVPN {
+			if len(d) < 8 {
+				return nil, errors.New("BGP VPN NLRI route distinguisher truncated")
+			}
+			n.RD = BGPRouteDistinguisher(binary.BigEndian.Uint64(d))
+			d, bits = d[8:], bits-64
+		}
+		ip := make(net.IP, 4)
+		if f.AFI == BGPAFIIPv6 {
+			ip = make(net.IP, 16)
+		}
+		if bits < 0 || bits > 8*len(ip) {
+			return nil, fmt.Errorf("BGP NLRI prefix length %d out of range", bits)
+		}
+		copy(ip, d)
+		n.Prefix = net.IPNet{IP: ip, Mask: net.CIDRMask(bits, 8*len(ip))}
+		routes = append(routes, n)
+	}
+	return routes, nil
+}
+
+func encodeBGPNLRI(b []byte, routes []BGPNLRI, f BGPAddressFamily, addPath bool) ([]byte, error) {
+	for i := range routes {
+		n := &routes[i]
+		if addPath {
+			b = appendUint32(b, n.PathID)
+		}
+		if f.SAFI == BGPSAFIEVPN {
+			b = append(b, byte(n.EVPN.Type), 0)
+			start := len(b)
+			b = n.encodeEVPN(b)
+			if len(b)-start > 255 {
+				return nil, errors.New("BGP EVPN route too long")
+			}
+			b[start-1] = byte(len(b) - start)
+			continue
+		}
+
+		ip := n.Prefix.IP.To4()
+		if f.AFI == BGPAFIIPv6 || ip == nil {
+			ip = n.Prefix.IP.To16()
+		}
+		ones, _ := n.Prefix.Mask.Size()
+		bits := ones
+		if f.SAFI == BGPSAFIMPLSLabel || f.SAFI == BGPSAFIMPLSVPN {
+			bits += 24 * len(n.Labels)
+		}
+		if f.SAFI == BGPSAFIMPLSVPN {
+			bits += 64
+		}
+		if bits > 255 {
+			return nil, fmt.Errorf("BGP NLRI %v too long", n.Prefix.String())
+		}
+		b = append(b, byte(bits))
+		if f.SAFI == BGPSAFIMPLSLabel || f.SAFI == BGPSAFIMPLSVPN {
+			b = appendBGPLabels(b, n.Labels)
+		}
+		if f.SAFI == BGPSAFIMPLSVPN {
+			b = appendUint32(appendUint32(b, uint32(n.RD>>32)), uint32(n.RD))
+		}
+		b = append(b, ip.Mask(n.Prefix.Mask)[:(ones+7)/8]...)
+	}
+	return b, nil
+}
+
+// bgpIPOfLength decodes the IP address of EVPN routes which follows its
+// length in bits, returning the length of both.
+func bgpIPOfLength(data []byte) (net.IP, int, error) {
+	if len(data) < 1 {
+		return nil, 0, errors.New("BGP EVPN route truncated")
+	}
+	n := int(data[0]) / 8
+	if n != 0 && n != 4 && n != 16 || len(data) < 1+n || int(data[0])%8 != 0 {
+		return nil, 0, errors.New("BGP EVPN route has bad IP address length")
+	}
+	if n == 0 {
+		return nil, 1, nil
+	}
+	return net.IP(data[1 : 1+n]), 1 + n, nil
+}
+
+func appendBGPIPOfLength(b []byte, ip net.IP) []byte {
+	if ip4 := ip.To4(); ip4 != nil {
+		ip = ip4
+	}
+	b = append(b, byte(8*len(ip)))
+	return append(b, ip...)
+}
+
+func (n *BGPNLRI) decodeEVPN(t BGPEVPNRouteType, data []byte) error {
+	e := &n.EVPN
+	*e = BGPEVPNRoute{Type: t, Data: data}
+	if t < BGPEVPNEthernetAutoDiscovery || t > BGPEVPNIPPrefix {
+		return nil
+	}
+	truncated := errors.New("BGP EVPN route truncated")
+	if len(data) < 8 {
+		return truncated
+	}
+	n.RD = BGPRouteDistinguisher(binary.BigEndian.Uint64(data))
+	d := data[8:]
+	if t != BGPEVPNInclusiveMulticast {
+		if len(d) < 10 {
+			return truncated
+		}
+		copy(e.ESI[:], d)
+		d = d[10:]
+	}
+	if t != BGPEVPNEthernetSegment {
+		if len(d) < 4 {
+			return truncated
+		}
+		e.EthernetTag = binary.BigEndian.Uint32(d)
+		d = d[4:]
+	}
+	var err error
+	var off int
+	switch t {
+	case BGPEVPNMACIPAdvertisement:
+		if len(d) < 7 || d[0] != 48 {
+			return errors.New("BGP EVPN MAC address truncated")
+		}
+		e.MAC = net.HardwareAddr(d[1:7])
+		if e.IP, off, err = bgpIPOfLength(d[7:]); err != nil {
+			return err
+		}
+		d = d[7+off:]
+	case BGPEVPNInclusiveMulticast, BGPEVPNEthernetSegment:
+		if e.IP, off, err = bgpIPOfLength(d); err != nil {
+			return err
+		}
+		d = d[off:]
+	case BGPEVPNIPPrefix:
+		// The prefix and gateway are both IPv4 or both IPv6 addresses,
+		// followed by a label.
+		if len(d) != 1+4+4+3 && len(d) != 1+16+16+3 {
+			return errors.New("BGP EVPN IP prefix route has bad length")
+		}
+		size := (len(d) - 4) / 2
+		if int(d[0]) > 8*size {
+			return fmt.Errorf("BGP EVPN prefix length %d out of range", d[0])
+		}
+		n.Prefix = net.IPNet{IP: net.IP(d[1 : 1+size]), Mask: net.CIDRMask(int(d[0]), 8*size)}
+		e.Gateway = net.IP(d[1+size : 1+2*size])
+		d = d[1+2*size:]
+	}
+	if t == BGPEVPNInclusiveMulticast || t == BGPEVPNEthernetSegment {
+		if len(d) != 0 {
+			return errors.New("BGP EVPN route has bad length")
+		}
+		return nil
+	}
+	// Types 1 and 5 have a label, and type 2 one or two.
+	if len(d) != 3 && (t != BGPEVPNMACIPAdvertisement || len(d) != 6) {
+		return errors.New("BGP EVPN route has bad label length")
+	}
+	for ; len(d) > 0; d = d[3:] {
+		n.Labels = append(n.Labels, uint32(d[0])<<16|uint32(d[1])<<8|uint32(d[2]))
+	}
+	return nil
+}
+
+func (n *BGPNLRI) encodeEVPN(b []byte) []byte {
+	e := &n.EVPN
+	t := e.Type
+	if t < BGPEVPNEthernetAutoDiscovery || t > BGPEVPNIPPrefix {
+		return append(b, e.Data...)
+	}
+	b = appendUint32(appendUint32(b, uint32(n.RD>>32)), uint32(n.RD))
+	if t != BGPEVPNInclusiveMulticast {
+		b = append(b, e.ESI[:]...)
+	}
+	if t != BGPEVPNEthernetSegment {
+		b = appendUint32(b, e.EthernetTag)
+	}
+	switch t {
+	case BGPEVPNMACIPAdvertisement:
+		b = append(b, 48)
+		b = append(b, e.MAC...)
+		if e.IP == nil {
+			b = append(b, 0)
+		} else {
+			b = appendBGPIPOfLength(b, e.IP)
+		}
+	case BGPEVPNInclusiveMulticast, BGPEVPNEthernetSegment:
+		return appendBGPIPOfLength(b, e.IP)
+	case BGPEVPNIPPrefix:
+		ones, _ := n.Prefix.Mask.Size()
+		ip, gw := n.Prefix.IP.To4(), e.Gateway.To4()
+		if ip == nil || (gw == nil && e.Gateway != nil) {
+			ip, gw = n.Prefix.IP.To16(), e.Gateway.To16()
+		}
+		if gw == nil {
+			gw = make(net.IP, len(ip))
+		}
+		b = append(b, byte(ones))
+		b = append(b, ip.Mask(n.Prefix.Mask)...)
+		b = append(b, gw...)
+	}
+	labels := n.Labels
+	if len(labels) == 0 {
+		labels = []uint32{0}
+	}
+	return appendBGPLabels(b, labels)
+}
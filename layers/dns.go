@@ -52,25 +52,38 @@ type DNSType uint16
 
 // DNSType known values.
 const (
-	DNSTypeA     DNSType = 1  // a host address
-	DNSTypeNS    DNSType = 2  // an authoritative name server
-	DNSTypeMD    DNSType = 3  // a mail destination (Obsolete - use MX)
-	DNSTypeMF    DNSType = 4  // a mail forwarder (Obsolete - use MX)
-	DNSTypeCNAME DNSType = 5  // the canonical name for an alias
-	DNSTypeSOA   DNSType = 6  // marks the start of a zone of authority
-	DNSTypeMB    DNSType = 7  // a mailbox domain name (EXPERIMENTAL)
-	DNSTypeMG    DNSType = 8  // a mail group member (EXPERIMENTAL)
-	DNSTypeMR    DNSType = 9  // a mail rename domain name (EXPERIMENTAL)
-	DNSTypeNULL  DNSType = 10 // a null RR (EXPERIMENTAL)
-	DNSTypeWKS   DNSType = 11 // a well known service description
-	DNSTypePTR   DNSType = 12 // a domain name pointer
-	DNSTypeHINFO DNSType = 13 // host information
-	DNSTypeMINFO DNSType = 14 // mailbox or mail list information
-	DNSTypeMX    DNSType = 15 // mail exchange
-	DNSTypeTXT   DNSType = 16 // text strings
-	DNSTypeAAAA  DNSType = 28 // a IPv6 host address [RFC3596]
-	DNSTypeSRV   DNSType = 33 // server discovery [RFC2782] [RFC6195]
-	DNSTypeOPT   DNSType = 41 // OPT Pseudo-RR [RFC6891]
+	DNSTypeA      DNSType = 1   // a host address
+	DNSTypeNS     DNSType = 2   // an authoritative name server
+	DNSTypeMD     DNSType = 3   // a mail destination (Obsolete - use MX)
+	DNSTypeMF     DNSType = 4   // a mail forwarder (Obsolete - use MX)
+	DNSTypeCNAME  DNSType = 5   // the canonical name for an alias
+	DNSTypeSOA    DNSType = 6   // marks the start of a zone of authority
+	DNSTypeMB     DNSType = 7   // a mailbox domain name (EXPERIMENTAL)
+	DNSTypeMG     DNSType = 8   // a mail group member (EXPERIMENTAL)
+	DNSTypeMR     DNSType = 9   // a mail rename domain name (EXPERIMENTAL)
+	DNSTypeNULL   DNSType = 10  // a null RR (EXPERIMENTAL)
+	DNSTypeWKS    DNSType = 11  // a well known service description
+	DNSTypePTR    DNSType = 12  // a domain name pointer
+	DNSTypeHINFO  DNSType = 13  // host information
+	DNSTypeMINFO  DNSType = 14  // mailbox or mail list information
+	DNSTypeMX     DNSType = 15  // mail exchange
+	DNSTypeTXT    DNSType = 16  // text strings
+	DNSTypeAAAA   DNSType = 28  // a IPv6 host address [RFC3596]
+	DNSTypeSRV    DNSType = 33  // server discovery [RFC2782] [RFC6195]
+	DNSTypeNAPTR  DNSType = 35  // naming authority pointer [RFC3403]
+	DNSTypeCERT   DNSType = 37  // a certificate [RFC4398]
+	DNSTypeOPT    DNSType = 41  // OPT Pseudo-RR [RFC6891]
+	DNSTypeDS     DNSType = 43  // delegation signer [RFC4034]
+	DNSTypeSSHFP  DNSType = 44  // SSH key fingerprint [RFC4255]
+	DNSTypeRRSIG  DNSType = 46  // a resource record signature [RFC4034]
+	DNSTypeNSEC   DNSType = 47  // next secure [RFC4034]
+	DNSTypeDNSKEY DNSType = 48  // a DNS public key [RFC4034]
+	DNSTypeNSEC3  DNSType = 50  // hashed next secure [RFC5155]
+	DNSTypeTLSA   DNSType = 52  // a TLS certificate association [RFC6698]
+	DNSTypeSVCB   DNSType = 64  // general purpose service binding [RFC9460]
+	DNSTypeHTTPS  DNSType = 65  // service binding for HTTPS [RFC9460]
+	DNSTypeURI    DNSType = 256 // a URI [RFC7553]
+	DNSTypeCAA    DNSType = 257 // certification authority authorization [RFC8659]
 )
 
 func (dt DNSType) String() string {
@@ -113,8 +126,34 @@ func (dt DNSType) String() string {
 		return "AAAA"
 	case DNSTypeSRV:
 		return "SRV"
+	case DNSTypeNAPTR:
+		return "NAPTR"
+	case DNSTypeCERT:
+		return "CERT"
 	case DNSTypeOPT:
 		return "OPT"
+	case DNSTypeDS:
+		return "DS"
+	case DNSTypeSSHFP:
+		return "SSHFP"
+	case DNSTypeRRSIG:
+		return "RRSIG"
+	case DNSTypeNSEC:
+		return "NSEC"
+	case DNSTypeDNSKEY:
+		return "DNSKEY"
+	case DNSTypeNSEC3:
+		return "NSEC3"
+	case DNSTypeTLSA:
+		return "TLSA"
+	case DNSTypeSVCB:
+		return "SVCB"
+	case DNSTypeHTTPS:
+		return "HTTPS"
+	case DNSTypeURI:
+		return "URI"
+	case DNSTypeCAA:
+		return "CAA"
 	}
 }
 
@@ -123,25 +162,26 @@ type DNSResponseCode uint8
 
 // DNSResponseCode known values.
 const (
-	DNSResponseCodeNoErr    DNSResponseCode = 0  // No error
-	DNSResponseCodeFormErr  DNSResponseCode = 1  // Format Error                       [RFC1035]
-	DNSResponseCodeServFail DNSResponseCode = 2  // Server Failure                     [RFC1035]
-	DNSResponseCodeNXDomain DNSResponseCode = 3  // Non-Existent Domain                [RFC1035]
-	DNSResponseCodeNotImp   DNSResponseCode = 4  // Not Implemented                    [RFC1035]
-	DNSResponseCodeRefused  DNSResponseCode = 5  // Query Refused                      [RFC1035]
-	DNSResponseCodeYXDomain DNSResponseCode = 6  // Name Exists when it should not     [RFC2136]
-	DNSResponseCodeYXRRSet  DNSResponseCode = 7  // RR Set Exists when it should not   [RFC2136]
-	DNSResponseCodeNXRRSet  DNSResponseCode = 8  // RR Set that should exist does not  [RFC2136]
-	DNSResponseCodeNotAuth  DNSResponseCode = 9  // Server Not Authoritative for zone  [RFC2136]
-	DNSResponseCodeNotZone  DNSResponseCode = 10 // Name not contained in zone         [RFC2136]
-	DNSResponseCodeBadVers  DNSResponseCode = 16 // Bad OPT Version                    [RFC2671]
-	DNSResponseCodeBadSig   DNSResponseCode = 16 // TSIG Signature Failure             [RFC2845]
-	DNSResponseCodeBadKey   DNSResponseCode = 17 // Key not recognized                 [RFC2845]
-	DNSResponseCodeBadTime  DNSResponseCode = 18 // Signature out of time window       [RFC2845]
-	DNSResponseCodeBadMode  DNSResponseCode = 19 // Bad TKEY Mode                      [RFC2930]
-	DNSResponseCodeBadName  DNSResponseCode = 20 // Duplicate key name                 [RFC2930]
-	DNSResponseCodeBadAlg   DNSResponseCode = 21 // Algorithm not supported            [RFC2930]
-	DNSResponseCodeBadTruc  DNSResponseCode = 22 // Bad Truncation                     [RFC4635]
+	DNSResponseCodeNoErr     DNSResponseCode = 0  // No error
+	DNSResponseCodeFormErr   DNSResponseCode = 1  // Format Error                       [RFC1035]
+	DNSResponseCodeServFail  DNSResponseCode = 2  // Server Failure                     [RFC1035]
+	DNSResponseCodeNXDomain  DNSResponseCode = 3  // Non-Existent Domain                [RFC1035]
+	DNSResponseCodeNotImp    DNSResponseCode = 4  // Not Implemented                    [RFC1035]
+	DNSResponseCodeRefused   DNSResponseCode = 5  // Query Refused                      [RFC1035]
+	DNSResponseCodeYXDomain  DNSResponseCode = 6  // Name Exists when it should not     [RFC2136]
+	DNSResponseCodeYXRRSet   DNSResponseCode = 7  // RR Set Exists when it should not   [RFC2136]
+	DNSResponseCodeNXRRSet   DNSResponseCode = 8  // RR Set that should exist does not  [RFC2136]
+	DNSResponseCodeNotAuth   DNSResponseCode = 9  // Server Not Authoritative for zone  [RFC2136]
+	DNSResponseCodeNotZone   DNSResponseCode = 10 // Name not contained in zone         [RFC2136]
+	DNSResponseCodeBadVers   DNSResponseCode = 16 // Bad OPT Version                    [RFC2671]
+	DNSResponseCodeBadSig    DNSResponseCode = 16 // TSIG Signature Failure             [RFC2845]
+	DNSResponseCodeBadKey    DNSResponseCode = 17 // Key not recognized                 [RFC2845]
+	DNSResponseCodeBadTime   DNSResponseCode = 18 // Signature out of time window       [RFC2845]
+	DNSResponseCodeBadMode   DNSResponseCode = 19 // Bad TKEY Mode                      [RFC2930]
+	DNSResponseCodeBadName   DNSResponseCode = 20 // Duplicate key name                 [RFC2930]
+	DNSResponseCodeBadAlg    DNSResponseCode = 21 // Algorithm not supported            [RFC2930]
+	DNSResponseCodeBadTruc   DNSResponseCode = 22 // Bad Truncation                     [RFC4635]
+	DNSResponseCodeBadCookie DNSResponseCode = 23 // Bad/missing Server Cookie          [RFC7873]
 )
 
 func (drc DNSResponseCode) String() string {
@@ -184,6 +224,8 @@ func (drc DNSResponseCode) String() string {
 		return "Algorithm not supported"
 	case DNSResponseCodeBadTruc:
 		return "Bad Truncation"
+	case DNSResponseCodeBadCookie:
+		return "Bad/missing Server Cookie"
 	}
 }
 
@@ -266,6 +308,13 @@ type DNS struct {
 	NSCount      uint16 // Number of authorities to expect
 	ARCount      uint16 // Number of additional records to expect
 
+	// MDNS is set for multicast DNS messages of RFC 6762, which are those
+	// decoded as LayerTypeMDNS from UDP port 5353.  The top bit of their
+	// classes is decoded as the UnicastResponse bit of questions and the
+	// CacheFlush bit of records.  DecodeFromBytes clears it: mDNS is decoded
+	// with a DecodingLayerParser by an MDNS layer.
+	MDNS bool
+
 	// Entries
 	Questions   []DNSQuestion
 	Answers     []DNSResourceRecord
@@ -296,6 +345,11 @@ func decodeDNS(data []byte, p gopacket.PacketBuilder) error {
 
 // DecodeFromBytes decodes the slice into the DNS struct.
 func (d *DNS) DecodeFromBytes(data []byte, df gopacket.DecodeFeedback) error {
+	return d.decode(data, df, false)
+}
+
+func (d *DNS) decode(data []byte, df gopacket.DecodeFeedback, mdns bool) error {
+	d.MDNS = mdns
 	d.buffer = d.buffer[:0]
 
 	if len(data) < 12 {
@@ -369,6 +423,10 @@ func (d *DNS) DecodeFromBytes(data []byte, df gopacket.DecodeFeedback) error {
 		}
 	}
 
+	if d.MDNS {
+		d.splitMDNSClasses()
+	}
+
 	if uint16(len(d.Questions)) != d.QDCount {
 		return errDecodeQueryBadQDCount
 	} else if uint16(len(d.Answers)) != d.ANCount {
@@ -433,6 +491,30 @@ func recSize(rr *DNSResourceRecord) int {
 			l += len(opt.Data)
 		}
 		return l
+	case DNSTypeDS:
+		return rr.DS.size()
+	case DNSTypeDNSKEY:
+		return rr.DNSKEY.size()
+	case DNSTypeRRSIG:
+		return rr.RRSIG.size()
+	case DNSTypeNSEC:
+		return rr.NSEC.size()
+	case DNSTypeNSEC3:
+		return rr.NSEC3.size()
+	case DNSTypeCAA:
+		return rr.CAA.size()
+	case DNSTypeNAPTR:
+		return rr.NAPTR.size()
+	case DNSTypeTLSA:
+		return rr.TLSA.size()
+	case DNSTypeSSHFP:
+		return rr.SSHFP.size()
+	case DNSTypeSVCB, DNSTypeHTTPS:
+		return rr.SVCB.size()
+	case DNSTypeURI:
+		return rr.URI.size()
+	case DNSTypeCERT:
+		return rr.CERT.size()
 	}
 
 	return 0
@@ -616,9 +698,12 @@ loop:
 
 // DNSQuestion wraps a single request (question) within a DNS query.
 type DNSQuestion struct {
-	Name  []byte
+	Name  []byte `gopacket:"text"`
 	Type  DNSType
 	Class DNSClass
+
+	// UnicastResponse is the QU bit of mDNS questions.
+	UnicastResponse bool
 }
 
 func (q *DNSQuestion) decode(data []byte, offset int, df gopacket.DecodeFeedback, buffer *[]byte) (int, error) {
@@ -638,7 +723,7 @@ func (q *DNSQuestion) encode(data []byte, offset int) int {
 	noff := encodeName(q.Name, data, offset)
 	nSz := noff - offset
 	binary.BigEndian.PutUint16(data[noff:], uint16(q.Type))
-	binary.BigEndian.PutUint16(data[noff+2:], uint16(q.Class))
+	binary.BigEndian.PutUint16(data[noff+2:], uint16(q.Class)|mdnsClassBit(q.UnicastResponse))
 	return nSz + 4
 }
 
@@ -667,26 +752,41 @@ func (q *DNSQuestion) encode(data []byte, offset int) int {
 // response.
 type DNSResourceRecord struct {
 	// Header
-	Name  []byte
+	Name  []byte `gopacket:"text"`
 	Type  DNSType
 	Class DNSClass
 	TTL   uint32
 
+	// CacheFlush is the cache-flush bit of mDNS records.
+	CacheFlush bool
+
 	// RDATA Raw Values
 	DataLength uint16
 	Data       []byte
 
 	// RDATA Decoded Values
 	IP             net.IP
-	NS, CNAME, PTR []byte
-	TXTs           [][]byte
+	NS, CNAME, PTR []byte   `gopacket:"text"`
+	TXTs           [][]byte `gopacket:"text"`
 	SOA            DNSSOA
 	SRV            DNSSRV
 	MX             DNSMX
 	OPT            []DNSOPT // See RFC 6891, section 6.1.2
+	DS             DNSDS
+	DNSKEY         DNSDNSKEY
+	RRSIG          DNSRRSIG
+	NSEC           DNSNSEC
+	NSEC3          DNSNSEC3
+	CAA            DNSCAA
+	NAPTR          DNSNAPTR
+	TLSA           DNSTLSA
+	SSHFP          DNSSSHFP
+	SVCB           DNSSVCB // Also holds HTTPS records
+	URI            DNSURI
+	CERT           DNSCERT
 
 	// Undecoded TXT for backward compatibility
-	TXT []byte
+	TXT []byte `gopacket:"text"`
 }
 
 // decode decodes the resource record, returning the total length of the record.
@@ -744,9 +844,10 @@ func (rr *DNSResourceRecord) encode(data []byte, offset int, opts gopacket.Seria
 	nSz := noff - offset
 
 	binary.BigEndian.PutUint16(data[noff:], uint16(rr.Type))
-	binary.BigEndian.PutUint16(data[noff+2:], uint16(rr.Class))
+	binary.BigEndian.PutUint16(data[noff+2:], uint16(rr.Class)|mdnsClassBit(rr.CacheFlush))
 	binary.BigEndian.PutUint32(data[noff+4:], uint32(rr.TTL))
 
+	var err error
 	switch rr.Type {
 	case DNSTypeA:
 		copy(data[noff+10:], rr.IP.To4())
@@ -789,9 +890,36 @@ func (rr *DNSResourceRecord) encode(data []byte, offset int, opts gopacket.Seria
 			copy(data[noff2+4:], opt.Data)
 			noff2 += 4 + len(opt.Data)
 		}
+	case DNSTypeDS:
+		rr.DS.encode(data[noff+10:])
+	case DNSTypeDNSKEY:
+		rr.DNSKEY.encode(data[noff+10:])
+	case DNSTypeRRSIG:
+		rr.RRSIG.encode(data, noff+10)
+	case DNSTypeNSEC:
+		rr.NSEC.encode(data, noff+10)
+	case DNSTypeNSEC3:
+		err = rr.NSEC3.encode(data[noff+10:])
+	case DNSTypeCAA:
+		err = rr.CAA.encode(data[noff+10:])
+	case DNSTypeNAPTR:
+		err = rr.NAPTR.encode(data, noff+10)
+	case DNSTypeTLSA:
+		rr.TLSA.encode(data[noff+10:])
+	case DNSTypeSSHFP:
+		rr.SSHFP.encode(data[noff+10:])
+	case DNSTypeSVCB, DNSTypeHTTPS:
+		err = rr.SVCB.encode(data, noff+10)
+	case DNSTypeURI:
+		rr.URI.encode(data[noff+10:])
+	case DNSTypeCERT:
+		rr.CERT.encode(data[noff+10:])
 	default:
 		return 0, fmt.Errorf("serializing resource record of type %v not supported", rr.Type)
 	}
+	if err != nil {
+		return 0, err
+	}
 
 	// DataLength
 	dSz := recSize(rr)
@@ -939,6 +1067,30 @@ func (rr *DNSResourceRecord) decodeRData(data []byte, offset int, buffer *[]byte
 			return err
 		}
 		rr.OPT = allOPT
+	case DNSTypeDS:
+		return rr.DS.decode(rr.Data)
+	case DNSTypeDNSKEY:
+		return rr.DNSKEY.decode(rr.Data)
+	case DNSTypeRRSIG:
+		return rr.RRSIG.decode(data, offset, offset+len(rr.Data), buffer)
+	case DNSTypeNSEC:
+		return rr.NSEC.decode(data, offset, offset+len(rr.Data), buffer)
+	case DNSTypeNSEC3:
+		return rr.NSEC3.decode(rr.Data)
+	case DNSTypeCAA:
+		return rr.CAA.decode(rr.Data)
+	case DNSTypeNAPTR:
+		return rr.NAPTR.decode(data, offset, offset+len(rr.Data), buffer)
+	case DNSTypeTLSA:
+		return rr.TLSA.decode(rr.Data)
+	case DNSTypeSSHFP:
+		return rr.SSHFP.decode(rr.Data)
+	case DNSTypeSVCB, DNSTypeHTTPS:
+		return rr.SVCB.decode(data, offset, offset+len(rr.Data), buffer)
+	case DNSTypeURI:
+		return rr.URI.decode(rr.Data)
+	case DNSTypeCERT:
+		return rr.CERT.decode(rr.Data)
 	}
 	return nil
 }
@@ -946,7 +1098,7 @@ func (rr *DNSResourceRecord) decodeRData(data []byte, offset int, buffer *[]byte
 // DNSSOA is a Start of Authority record.  Each domain requires a SOA record at
 // the cutover where a domain is delegated from its parent.
 type DNSSOA struct {
-	MName, RName                            []byte
+	MName, RName                            []byte `gopacket:"text"`
 	Serial, Refresh, Retry, Expire, Minimum uint32
 }
 
@@ -954,14 +1106,14 @@ type DNSSOA struct {
 // server/service.
 type DNSSRV struct {
 	Priority, Weight, Port uint16
-	Name                   []byte
+	Name                   []byte `gopacket:"text"`
 }
 
 // DNSMX is a mail exchange record, defining a mail server for a recipient's
 // domain.
 type DNSMX struct {
 	Preference uint16
-	Name       []byte
+	Name       []byte `gopacket:"text"`
 }
 
 // DNSOptionCode represents the code of a DNS Option, see RFC6891, section 6.1.2
@@ -993,6 +1145,8 @@ func (doc DNSOptionCode) String() string {
 		return "CodeChain"
 	case DNSOptionCodeEDNSKeyTag:
 		return "CodeEDNSKeyTag"
+	case DNSOptionCodeExtendedDNSError:
+		return "ExtendedDNSError"
 	case DNSOptionCodeEDNSClientTag:
 		return "EDNSClientTag"
 	case DNSOptionCodeEDNSServerTag:
@@ -1015,6 +1169,7 @@ const (
 	DNSOptionCodePadding          DNSOptionCode = 12
 	DNSOptionCodeChain            DNSOptionCode = 13
 	DNSOptionCodeEDNSKeyTag       DNSOptionCode = 14
+	DNSOptionCodeExtendedDNSError DNSOptionCode = 15
 	DNSOptionCodeEDNSClientTag    DNSOptionCode = 16
 	DNSOptionCodeEDNSServerTag    DNSOptionCode = 17
 	DNSOptionCodeDeviceID         DNSOptionCode = 26946
@@ -1043,6 +1198,7 @@ var (
 	errDNSNameHasNoData        = errors.New("no dns data found for name")
 
 	errCharStringMissData = errors.New("Insufficient data for a <character-string>")
+	errDNSRDataTooShort   = errors.New("resource record data too short")
 
 	errDecodeRecordLength = errors.New("resource record length exceeds data")
 
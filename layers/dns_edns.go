@@ -0,0 +1,402 @@
+// Copyright 2019 The GoPacket Authors. All rights reserved.
+//
+// Use of this source code is governed by a BSD-style license
+// that can be found in the LICENSE file in the root of the source
+// tree.
+
+package layers
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"time"
+)
+
+// DNSEDNS is the EDNS(0) header which the class and TTL fields of an OPT
+// pseudo-record carry, with the record's options, see RFC 6891 section
+// 6.1.3.
+type DNSEDNS struct {
+	// UDPSize is the requestor's UDP payload size, sent as the class.
+	UDPSize uint16
+	// ExtendedRCode is the upper 8 bits of the 12-bit response code, whose
+	// lower 4 bits are the ResponseCode of the header.
+	ExtendedRCode uint8
+	Version       uint8
+	// DO is the DNSSEC OK bit of RFC 3225.
+	DO bool
+	// Z is the rest of the flags, which must be zero.
+	Z       uint16
+	Options []DNSOPT
+}
+
+// EDNS decodes the EDNS(0) header of an OPT record.  It returns false if
+// the record isn't an OPT record.
+func (rr *DNSResourceRecord) EDNS() (DNSEDNS, bool) {
+	if rr.Type != DNSTypeOPT {
+		return DNSEDNS{}, false
+	}
+	return DNSEDNS{
+		UDPSize:       uint16(rr.Class),
+		ExtendedRCode: uint8(rr.TTL >> 24),
+		Version:       uint8(rr.TTL >> 16),
+		DO:            rr.TTL&0x8000 != 0,
+		Z:             uint16(rr.TTL & 0x7fff),
+		Options:       rr.OPT,
+	}, true
+}
+
+// ResourceRecord returns the OPT record of the header, with its class and
+// TTL fields set from it.
+func (e DNSEDNS) ResourceRecord() DNSResourceRecord {
+	ttl := uint32(e.ExtendedRCode)<<24 | uint32(e.Version)<<16 | uint32(e.Z&0x7fff)
+	if e.DO {
+		ttl |= 0x8000
+	}
+	return DNSResourceRecord{
+		Type:  DNSTypeOPT,
+		Class: DNSClass(e.UDPSize),
+		TTL:   ttl,
+		OPT:   e.Options,
+	}
+}
+
+// OPT returns the OPT record of the additional section, or nil if it has
+// none.
+func (d *DNS) OPT() *DNSResourceRecord {
+	for i := range d.Additionals {
+		if d.Additionals[i].Type == DNSTypeOPT {
+			return &d.Additionals[i]
+		}
+	}
+	return nil
+}
+
+// EDNS returns the EDNS(0) header of the message.  It returns false if the
+// message has no OPT record.
+func (d *DNS) EDNS() (DNSEDNS, bool) {
+	if rr := d.OPT(); rr != nil {
+		return rr.EDNS()
+	}
+	return DNSEDNS{}, false
+}
+
+// SetEDNS sets the OPT record of the message to that of e, replacing the
+// one it has, or adding one to the additional section.  Set FixLengths
+// when serializing to count it in ARCount.
+func (d *DNS) SetEDNS(e DNSEDNS) {
+	if rr := d.OPT(); rr != nil {
+		*rr = e.ResourceRecord()
+		return
+	}
+	d.Additionals = append(d.Additionals, e.ResourceRecord())
+}
+
+// ExtendedResponseCode returns the 12-bit response code of the message,
+// which is its ResponseCode if it has no OPT record.
+func (d *DNS) ExtendedResponseCode() uint16 {
+	rcode := uint16(d.ResponseCode & 0xf)
+	if e, ok := d.EDNS(); ok {
+		rcode |= uint16(e.ExtendedRCode) << 4
+	}
+	return rcode
+}
+
+// Option returns the first option of the given code, or nil if there is
+// none.
+func (e *DNSEDNS) Option(code DNSOptionCode) *DNSOPT {
+	for i := range e.Options {
+		if e.Options[i].Code == code {
+			return &e.Options[i]
+		}
+	}
+	return nil
+}
+
+// DNSEDNSClientSubnet is the data of an EDNS Client Subnet option, see RFC
+// 7871.
+type DNSEDNSClientSubnet struct {
+	// Family is 1 for IPv4 and 2 for IPv6.
+	Family             uint16
+	SourcePrefixLength uint8
+	ScopePrefixLength  uint8
+	// Address is padded to the address length of the family when decoded,
+	// and truncated to SourcePrefixLength bits when encoded.
+	Address net.IP
+}
+
+func (s *DNSEDNSClientSubnet) addrLen() int {
+	switch s.Family {
+	case 1:
+		return net.IPv4len
+	case 2:
+		return net.IPv6len
+	}
+	return 0
+}
+
+// DecodeFromBytes decodes the data of an EDNS Client Subnet option.
+func (s *DNSEDNSClientSubnet) DecodeFromBytes(data []byte) error {
+	if len(data) < 4 {
+		return fmt.Errorf("EDNS client subnet option of length %d too short", len(data))
+	}
+	s.Family = binary.BigEndian.Uint16(data)
+	s.SourcePrefixLength = data[2]
+	s.ScopePrefixLength = data[3]
+	n := s.addrLen()
+	if n == 0 {
+		return fmt.Errorf("EDNS client subnet of unknown family %d", s.Family)
+	}
+	if int(s.SourcePrefixLength) > 8*n || len(data)-4 != (int(s.SourcePrefixLength)+7)/8 {
+		return fmt.Errorf("EDNS client subnet of %d address bytes for a /%d prefix", len(data)-4, s.SourcePrefixLength)
+	}
+	s.Address = make(net.IP, n)
+	copy(s.Address, data[4:])
+	return nil
+}
+
+// Encode returns the data of the option, whose address bits after the
+// source prefix are cleared.  It fails if Family is unknown, if Address
+// isn't an address of Family, or if SourcePrefixLength is longer than
+// Family's addresses.
+func (s *DNSEDNSClientSubnet) Encode() ([]byte, error) {
+	addr := s.Address
+	switch s.Family {
+	case 1:
+		addr = addr.To4()
+	case 2:
+		if addr.To4() != nil {
+			addr = nil
+		}
+	default:
+		return nil, fmt.Errorf("EDNS client subnet of unknown family %d", s.Family)
+	}
+	if len(addr) != s.addrLen() {
+		return nil, fmt.Errorf("EDNS client subnet address %v not of family %d", s.Address, s.Family)
+	}
+	if int(s.SourcePrefixLength) > 8*len(addr) {
+		return nil, fmt.Errorf("EDNS client subnet prefix /%d longer than family %d addresses", s.SourcePrefixLength, s.Family)
+	}
+	n := (int(s.SourcePrefixLength) + 7) / 8
+	data := make([]byte, 4+n)
+	binary.BigEndian.PutUint16(data, s.Family)
+	data[2] = s.SourcePrefixLength
+	data[3] = s.ScopePrefixLength
+	copy(data[4:], addr[:n])
+	if bits := s.SourcePrefixLength % 8; bits != 0 {
+		data[3+n] &= 0xff << (8 - bits)
+	}
+	return data, nil
+}
+
+// Option returns the EDNS Client Subnet option of the data, failing as
+// Encode does.
+func (s *DNSEDNSClientSubnet) Option() (DNSOPT, error) {
+	data, err := s.Encode()
+	if err != nil {
+		return DNSOPT{}, err
+	}
+	return DNSOPT{Code: DNSOptionCodeEDNSClientSubnet, Data: data}, nil
+}
+
+// DNSEDNSCookie is the data of a DNS Cookie option, see RFC 7873.
+type DNSEDNSCookie struct {
+	// Client is the 8-byte client cookie.
+	Client []byte
+	// Server is the server cookie of 8 to 32 bytes, which is empty in the
+	// first query to a server.
+	Server []byte
+}
+
+// DecodeFromBytes decodes the data of a DNS Cookie option.
+func (c *DNSEDNSCookie) DecodeFromBytes(data []byte) error {
+	if len(data) != 8 && (len(data) < 16 || len(data) > 40) {
+		return fmt.Errorf("DNS cookie option of invalid length %d", len(data))
+	}
+	c.Client = data[:8]
+	c.Server = data[8:]
+	return nil
+}
+
+// Encode returns the data of the option.
+func (c *DNSEDNSCookie) Encode() []byte {
+	return append(append([]byte(nil), c.Client...), c.Server...)
+}
+
+// Option returns the DNS Cookie option of the data.
+func (c *DNSEDNSCookie) Option() DNSOPT {
+	return DNSOPT{Code: DNSOptionCodeCookie, Data: c.Encode()}
+}
+
+// DNSEDNSKeepAlive is the data of an edns-tcp-keepalive option, see RFC
+// 7828.
+type DNSEDNSKeepAlive struct {
+	// HasTimeout is set if the option carries a timeout, as responses do,
+	// and isn't for queries.
+	HasTimeout bool
+	// Timeout is the idle timeout, in units of 100 milliseconds.
+	Timeout uint16
+}
+
+// DecodeFromBytes decodes the data of an edns-tcp-keepalive option.
+func (k *DNSEDNSKeepAlive) DecodeFromBytes(data []byte) error {
+	switch len(data) {
+	case 0:
+		*k = DNSEDNSKeepAlive{}
+	case 2:
+		k.HasTimeout = true
+		k.Timeout = binary.BigEndian.Uint16(data)
+	default:
+		return fmt.Errorf("edns-tcp-keepalive option of invalid length %d", len(data))
+	}
+	return nil
+}
+
+// Encode returns the data of the option.
+func (k *DNSEDNSKeepAlive) Encode() []byte {
+	if !k.HasTimeout {
+		return []byte{}
+	}
+	return []byte{byte(k.Timeout >> 8), byte(k.Timeout)}
+}
+
+// Option returns the edns-tcp-keepalive option of the data.
+func (k *DNSEDNSKeepAlive) Option() DNSOPT {
+	return DNSOPT{Code: DNSOptionCodeEDNSKeepAlive, Data: k.Encode()}
+}
+
+// Duration returns the timeout as a time.Duration.
+func (k *DNSEDNSKeepAlive) Duration() time.Duration {
+	return time.Duration(k.Timeout) * 100 * time.Millisecond
+}
+
+// DNSExtendedErrorCode is the INFO-CODE of an Extended DNS Error option.
+type DNSExtendedErrorCode uint16
+
+// DNSExtendedErrorCode values of RFC 8914.
+const (
+	DNSExtendedErrorOther                      DNSExtendedErrorCode = 0
+	DNSExtendedErrorUnsupportedDNSKEYAlgorithm DNSExtendedErrorCode = 1
+	DNSExtendedErrorUnsupportedDSDigestType    DNSExtendedErrorCode = 2
+	DNSExtendedErrorStaleAnswer                DNSExtendedErrorCode = 3
+	DNSExtendedErrorForgedAnswer               DNSExtendedErrorCode = 4
+	DNSExtendedErrorDNSSECIndeterminate        DNSExtendedErrorCode = 5
+	DNSExtendedErrorDNSSECBogus                DNSExtendedErrorCode = 6
+	DNSExtendedErrorSignatureExpired           DNSExtendedErrorCode = 7
+	DNSExtendedErrorSignatureNotYetValid       DNSExtendedErrorCode = 8
+	DNSExtendedErrorDNSKEYMissing              DNSExtendedErrorCode = 9
+	DNSExtendedErrorRRSIGsMissing              DNSExtendedErrorCode = 10
+	DNSExtendedErrorNoZoneKeyBitSet            DNSExtendedErrorCode = 11
+	DNSExtendedErrorNSECMissing                DNSExtendedErrorCode = 12
+	DNSExtendedErrorCachedError                DNSExtendedErrorCode = 13
+	DNSExtendedErrorNotReady                   DNSExtendedErrorCode = 14
+	DNSExtendedErrorBlocked                    DNSExtendedErrorCode = 15
+	DNSExtendedErrorCensored                   DNSExtendedErrorCode = 16
+	DNSExtendedErrorFiltered                   DNSExtendedErrorCode = 17
+	DNSExtendedErrorProhibited                 DNSExtendedErrorCode = 18
+	DNSExtendedErrorStaleNXDomainAnswer        DNSExtendedErrorCode = 19
+	DNSExtendedErrorNotAuthoritative           DNSExtendedErrorCode = 20
+	DNSExtendedErrorNotSupported               DNSExtendedErrorCode = 21
+	DNSExtendedErrorNoReachableAuthority       DNSExtendedErrorCode = 22
+	DNSExtendedErrorNetworkError               DNSExtendedErrorCode = 23
+	DNSExtendedErrorInvalidData                DNSExtendedErrorCode = 24
+)
+
+var dnsExtendedErrorNames = []string{
+	"Other Error",
+	"Unsupported DNSKEY Algorithm",
+	"Unsupported DS Digest Type",
+	"Stale Answer",
+	"Forged Answer",
+	"DNSSEC Indeterminate",
+	"DNSSEC Bogus",
+	"Signature Expired",
+	"Signature Not Yet Valid",
+	"DNSKEY Missing",
+	"RRSIGs Missing",
+	"No Zone Key Bit Set",
+	"NSEC Missing",
+	"Cached Error",
+	"Not Ready",
+	"Blocked",
+	"Censored",
+	"Filtered",
+	"Prohibited",
+	"Stale NXDOMAIN Answer",
+	"Not Authoritative",
+	"Not Supported",
+	"No Reachable Authority",
+	"Network Error",
+	"Invalid Data",
+}
+
+func (c DNSExtendedErrorCode) String() string {
+	if int(c) < len(dnsExtendedErrorNames) {
+		return dnsExtendedErrorNames[c]
+	}
+	return strconv.Itoa(int(c))
+}
+
+// DNSEDNSExtendedError is the data of an Extended DNS Error option, see
+// RFC 8914.
+type DNSEDNSExtendedError struct {
+	InfoCode DNSExtendedErrorCode
+	// ExtraText is UTF-8 text for humans, which may be empty.
+	ExtraText []byte
+}
+
+// DecodeFromBytes decodes the data of an Extended DNS Error option.
+func (e *DNSEDNSExtendedError) DecodeFromBytes(data []byte) error {
+	if len(data) < 2 {
+		return errors.New("extended DNS error option too short")
+	}
+	e.InfoCode = DNSExtendedErrorCode(binary.BigEndian.Uint16(data))
+	e.ExtraText = data[2:]
+	return nil
+}
+
+// Encode returns the data of the option.
+func (e *DNSEDNSExtendedError) Encode() []byte {
+	return append([]byte{byte(e.InfoCode >> 8), byte(e.InfoCode)}, e.ExtraText...)
+}
+
+// Option returns the Extended DNS Error option of the data.
+func (e *DNSEDNSExtendedError) Option() DNSOPT {
+	return DNSOPT{Code: DNSOptionCodeExtendedDNSError, Data: e.Encode()}
+}
+
+func (e *DNSEDNSExtendedError) String() string {
+	if len(e.ExtraText) == 0 {
+		return e.InfoCode.String()
+	}
+	return fmt.Sprintf("%v: %s", e.InfoCode, e.ExtraText)
+}
+
+// NewDNSOPTNSID returns an NSID option of RFC 5001, which is empty in
+// queries and carries the server's identifier in responses.
+func NewDNSOPTNSID(id []byte) DNSOPT {
+	if id == nil {
+		id = []byte{}
+	}
+	return DNSOPT{Code: DNSOptionCodeNSID, Data: id}
+}
+
+// NewDNSOPTPadding returns a Padding option of RFC 7830 of n zero bytes,
+// which adds n+4 bytes to the message.
+func NewDNSOPTPadding(n int) DNSOPT {
+	return DNSOPT{Code: DNSOptionCodePadding, Data: make([]byte, n)}
+}
+
+// DNSPaddingLength returns the length of the Padding option data which
+// pads a message of size bytes, without the option, to a multiple of
+// blockSize bytes, as RFC 8467 recommends with block sizes of 128 for
+// queries and 468 for responses.
+func DNSPaddingLength(size, blockSize int) int {
+	n := blockSize - (size+4)%blockSize
+	if n == blockSize {
+		return 0
+	}
+	return n
+}
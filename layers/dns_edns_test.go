@@ -0,0 +1,205 @@
+// Copyright 2019 The GoPacket Authors. All rights reserved.
+//
+// Use of this source code is governed by a BSD-style license
+// that can be found in the LICENSE file in the root of the source
+// tree.
+
+package layers
+
+import (
+	"bytes"
+	"net"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/google/gopacket"
+)
+
+func TestDNSEDNSQuery(t *testing.T) {
+	d := &DNS{
+		ID: 0x1234, RD: true,
+		Questions: []DNSQuestion{{Name: []byte("example.com"), Type: DNSTypeA, Class: DNSClassIN}},
+	}
+	ecs := DNSEDNSClientSubnet{Family: 1, SourcePrefixLength: 20, Address: net.IP{192, 0, 2, 130}}
+	cookie := DNSEDNSCookie{Client: []byte{1, 2, 3, 4, 5, 6, 7, 8}}
+	var keepalive DNSEDNSKeepAlive
+	ecsOption, err := ecs.Option()
+	if err != nil {
+		t.Fatal(err)
+	}
+	d.SetEDNS(DNSEDNS{
+		UDPSize: 1232,
+		DO:      true,
+		Options: []DNSOPT{ecsOption, cookie.Option(), keepalive.Option(), NewDNSOPTNSID(nil)},
+	})
+	// 12 bytes of header, 17 of question, and 11 of OPT record, with 31 of
+	// options.
+	pad := DNSPaddingLength(71, 128)
+	e, _ := d.EDNS()
+	e.Options = append(e.Options, NewDNSOPTPadding(pad))
+	d.SetEDNS(e)
+	if len(d.Additionals) != 1 {
+		t.Fatalf("%d additional records", len(d.Additionals))
+	}
+
+	buf := gopacket.NewSerializeBuffer()
+	if err := gopacket.SerializeLayers(buf, gopacket.SerializeOptions{FixLengths: true}, d); err != nil {
+		t.Fatal(err)
+	}
+	data := buf.Bytes()
+	if len(data) != 128 {
+		t.Errorf("padded query of %d bytes", len(data))
+	}
+	opt := data[29:]
+	want := []byte{
+		0, 0x00, 0x29, 0x04, 0xd0, 0x00, 0x00, 0x80, 0x00, 0x00, byte(31 + 4 + pad),
+		0x00, 0x08, 0x00, 0x07, 0x00, 0x01, 20, 0, 192, 0, 0, // the last bits cleared
+		0x00, 0x0a, 0x00, 0x08, 1, 2, 3, 4, 5, 6, 7, 8,
+		0x00, 0x0b, 0x00, 0x00,
+		0x00, 0x03, 0x00, 0x00,
+		0x00, 0x0c, 0x00, byte(pad),
+	}
+	if !bytes.Equal(opt[:len(want)], want) {
+		t.Errorf("OPT record\n%x, want\n%x", opt[:len(want)], want)
+	}
+
+	var got DNS
+	if err := got.DecodeFromBytes(data, gopacket.NilDecodeFeedback); err != nil {
+		t.Fatal(err)
+	}
+	e, ok := got.EDNS()
+	if !ok || e.UDPSize != 1232 || !e.DO || e.Version != 0 || e.ExtendedRCode != 0 || e.Z != 0 || len(e.Options) != 5 {
+		t.Fatalf("EDNS %+v, %v", e, ok)
+	}
+	var gotECS DNSEDNSClientSubnet
+	if err := gotECS.DecodeFromBytes(e.Option(DNSOptionCodeEDNSClientSubnet).Data); err != nil {
+		t.Fatal(err)
+	}
+	if !gotECS.Address.Equal(net.IP{192, 0, 0, 0}) || gotECS.SourcePrefixLength != 20 || gotECS.Family != 1 {
+		t.Errorf("client subnet %+v", gotECS)
+	}
+	var gotCookie DNSEDNSCookie
+	if err := gotCookie.DecodeFromBytes(e.Option(DNSOptionCodeCookie).Data); err != nil || !reflect.DeepEqual(gotCookie.Client, cookie.Client) || len(gotCookie.Server) != 0 {
+		t.Errorf("cookie %+v, %v", gotCookie, err)
+	}
+	var gotKeepalive DNSEDNSKeepAlive
+	if err := gotKeepalive.DecodeFromBytes(e.Option(DNSOptionCodeEDNSKeepAlive).Data); err != nil || gotKeepalive.HasTimeout {
+		t.Errorf("keepalive %+v, %v", gotKeepalive, err)
+	}
+	if o := e.Option(DNSOptionCodeExtendedDNSError); o != nil {
+		t.Errorf("unexpected option %v", o)
+	}
+}
+
+func TestDNSEDNSResponse(t *testing.T) {
+	// A BADVERS response to a query of EDNS version 1, with the extended
+	// error of a resolver which refused it.
+	data := testHex(t, "1234800000000000000000010000290200010080000030"+
+		"000a00180102030405060708"+"a1a2a3a4a5a6a7a8a9aaabacadaeafb0"+
+		"000b0002012c"+
+		"000f000a00127265667573656421")
+	var d DNS
+	if err := d.DecodeFromBytes(data, gopacket.NilDecodeFeedback); err != nil {
+		t.Fatal(err)
+	}
+	if rcode := d.ExtendedResponseCode(); rcode != uint16(DNSResponseCodeBadVers) {
+		t.Errorf("response code %d", rcode)
+	}
+	e, ok := d.EDNS()
+	if !ok || e.UDPSize != 512 || e.ExtendedRCode != 1 || e.Version != 0 || !e.DO {
+		t.Fatalf("EDNS %+v, %v", e, ok)
+	}
+
+	var cookie DNSEDNSCookie
+	if err := cookie.DecodeFromBytes(e.Option(DNSOptionCodeCookie).Data); err != nil || len(cookie.Client) != 8 || len(cookie.Server) != 16 {
+		t.Errorf("cookie %+v, %v", cookie, err)
+	}
+	var keepalive DNSEDNSKeepAlive
+	if err := keepalive.DecodeFromBytes(e.Option(DNSOptionCodeEDNSKeepAlive).Data); err != nil || !keepalive.HasTimeout || keepalive.Duration() != 30*time.Second {
+		t.Errorf("keepalive %+v, %v", keepalive, err)
+	}
+	var ede DNSEDNSExtendedError
+	if err := ede.DecodeFromBytes(e.Option(DNSOptionCodeExtendedDNSError).Data); err != nil || ede.InfoCode != DNSExtendedErrorProhibited {
+		t.Errorf("extended error %+v, %v", ede, err)
+	}
+	if s := ede.String(); s != "Prohibited: refused!" {
+		t.Errorf("extended error %q", s)
+	}
+
+	// The record and its options serialize as they were.
+	rebuilt := DNS{ID: d.ID, QR: true, OpCode: d.OpCode, ResponseCode: d.ResponseCode}
+	e.Options = []DNSOPT{cookie.Option(), keepalive.Option(), ede.Option()}
+	rebuilt.SetEDNS(e)
+	buf := gopacket.NewSerializeBuffer()
+	if err := gopacket.SerializeLayers(buf, gopacket.SerializeOptions{FixLengths: true}, &rebuilt); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(buf.Bytes(), data) {
+		t.Errorf("serialized\n%x, want\n%x", buf.Bytes(), data)
+	}
+}
+
+func TestDNSEDNSClientSubnet(t *testing.T) {
+	s := DNSEDNSClientSubnet{Family: 2, SourcePrefixLength: 56, ScopePrefixLength: 48, Address: net.ParseIP("2001:db8:1:2ff::1")}
+	data, err := s.Encode()
+	if want := testHex(t, "000238302001"+"0db8000102"); err != nil || !bytes.Equal(data, want) {
+		t.Errorf("encoded %x, %v, want %x", data, err, want)
+	}
+	var got DNSEDNSClientSubnet
+	if err := got.DecodeFromBytes(data); err != nil || !got.Address.Equal(net.ParseIP("2001:db8:1:200::")) || got.ScopePrefixLength != 48 {
+		t.Errorf("decoded %+v, %v", got, err)
+	}
+	// A zero prefix has no address bytes.
+	s = DNSEDNSClientSubnet{Family: 1, Address: net.IPv4zero}
+	if data, err := s.Encode(); err != nil || !bytes.Equal(data, []byte{0, 1, 0, 0}) {
+		t.Errorf("encoded %x, %v", data, err)
+	}
+	// An IPv4-mapped IPv6 address is IPv4.
+	s = DNSEDNSClientSubnet{Family: 1, SourcePrefixLength: 24, Address: net.ParseIP("192.0.2.1")}
+	if data, err := s.Encode(); err != nil || !bytes.Equal(data, testHex(t, "00011800c00002")) {
+		t.Errorf("encoded %x, %v", data, err)
+	}
+	for _, bad := range []DNSEDNSClientSubnet{
+		{Family: 1, SourcePrefixLength: 33, Address: net.IP{192, 0, 2, 1}},
+		{Family: 2, SourcePrefixLength: 129, Address: net.ParseIP("2001:db8::1")},
+		{Family: 1, SourcePrefixLength: 24, Address: net.ParseIP("2001:db8::1")},
+		{Family: 2, SourcePrefixLength: 24, Address: net.IP{192, 0, 2, 1}},
+		{Family: 1, SourcePrefixLength: 24},
+		{Family: 3, Address: net.IP{192, 0, 2, 1}},
+	} {
+		if data, err := bad.Encode(); err == nil {
+			t.Errorf("client subnet %+v encoded as %x", bad, data)
+		}
+	}
+
+	for _, bad := range []string{"000118", "00031800c00002", "00011800c000", "00012100c0000201", "0001180000c0000201"} {
+		if err := got.DecodeFromBytes(testHex(t, bad)); err == nil {
+			t.Errorf("client subnet %s decoded", bad)
+		}
+	}
+}
+
+func TestDNSEDNSMalformedOptions(t *testing.T) {
+	var cookie DNSEDNSCookie
+	for _, n := range []int{0, 7, 9, 15, 41} {
+		if err := cookie.DecodeFromBytes(make([]byte, n)); err == nil {
+			t.Errorf("cookie of %d bytes decoded", n)
+		}
+	}
+	var keepalive DNSEDNSKeepAlive
+	if err := keepalive.DecodeFromBytes([]byte{1}); err == nil {
+		t.Error("keepalive of 1 byte decoded")
+	}
+	var ede DNSEDNSExtendedError
+	if err := ede.DecodeFromBytes([]byte{1}); err == nil {
+		t.Error("extended error of 1 byte decoded")
+	}
+	if s := DNSExtendedErrorCode(49152).String(); s != "49152" {
+		t.Errorf("unknown info code %q", s)
+	}
+	var rr DNSResourceRecord
+	if _, ok := rr.EDNS(); ok {
+		t.Error("EDNS of a record which isn't OPT")
+	}
+}
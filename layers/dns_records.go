@@ -0,0 +1,762 @@
+// Copyright 2019 The GoPacket Authors. All rights reserved.
+//
+// Use of this source code is governed by a BSD-style license
+// that can be found in the LICENSE file in the root of the source
+// tree.
+
+package layers
+
+import (
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// DNSSECAlgorithm is the algorithm of a DNSKEY, RRSIG or DS record, see
+// the IANA DNS Security Algorithm Numbers registry.
+type DNSSECAlgorithm uint8
+
+// DNSSECAlgorithm known values.
+const (
+	DNSSECAlgorithmRSAMD5           DNSSECAlgorithm = 1
+	DNSSECAlgorithmDH               DNSSECAlgorithm = 2
+	DNSSECAlgorithmDSA              DNSSECAlgorithm = 3
+	DNSSECAlgorithmRSASHA1          DNSSECAlgorithm = 5
+	DNSSECAlgorithmDSANSEC3SHA1     DNSSECAlgorithm = 6
+	DNSSECAlgorithmRSASHA1NSEC3SHA1 DNSSECAlgorithm = 7
+	DNSSECAlgorithmRSASHA256        DNSSECAlgorithm = 8
+	DNSSECAlgorithmRSASHA512        DNSSECAlgorithm = 10
+	DNSSECAlgorithmECCGOST          DNSSECAlgorithm = 12
+	DNSSECAlgorithmECDSAP256SHA256  DNSSECAlgorithm = 13
+	DNSSECAlgorithmECDSAP384SHA384  DNSSECAlgorithm = 14
+	DNSSECAlgorithmED25519          DNSSECAlgorithm = 15
+	DNSSECAlgorithmED448            DNSSECAlgorithm = 16
+)
+
+var dnssecAlgorithmNames = map[DNSSECAlgorithm]string{
+	DNSSECAlgorithmRSAMD5:           "RSAMD5",
+	DNSSECAlgorithmDH:               "DH",
+	DNSSECAlgorithmDSA:              "DSA",
+	DNSSECAlgorithmRSASHA1:          "RSASHA1",
+	DNSSECAlgorithmDSANSEC3SHA1:     "DSA-NSEC3-SHA1",
+	DNSSECAlgorithmRSASHA1NSEC3SHA1: "RSASHA1-NSEC3-SHA1",
+	DNSSECAlgorithmRSASHA256:        "RSASHA256",
+	DNSSECAlgorithmRSASHA512:        "RSASHA512",
+	DNSSECAlgorithmECCGOST:          "ECC-GOST",
+	DNSSECAlgorithmECDSAP256SHA256:  "ECDSAP256SHA256",
+	DNSSECAlgorithmECDSAP384SHA384:  "ECDSAP384SHA384",
+	DNSSECAlgorithmED25519:          "ED25519",
+	DNSSECAlgorithmED448:            "ED448",
+}
+
+func (a DNSSECAlgorithm) String() string {
+	if name, ok := dnssecAlgorithmNames[a]; ok {
+		return name
+	}
+	return strconv.Itoa(int(a))
+}
+
+// DNSSECDigestType is the digest algorithm of a DS record.
+type DNSSECDigestType uint8
+
+// DNSSECDigestType known values.
+const (
+	DNSSECDigestSHA1   DNSSECDigestType = 1
+	DNSSECDigestSHA256 DNSSECDigestType = 2
+	DNSSECDigestGOST   DNSSECDigestType = 3
+	DNSSECDigestSHA384 DNSSECDigestType = 4
+)
+
+func (d DNSSECDigestType) String() string {
+	switch d {
+	case DNSSECDigestSHA1:
+		return "SHA-1"
+	case DNSSECDigestSHA256:
+		return "SHA-256"
+	case DNSSECDigestGOST:
+		return "GOST R 34.11-94"
+	case DNSSECDigestSHA384:
+		return "SHA-384"
+	}
+	return strconv.Itoa(int(d))
+}
+
+// dnsNameSize returns the size of the uncompressed encoding of a name.
+func dnsNameSize(name []byte) int {
+	if len(name) == 0 {
+		return 1
+	}
+	return len(name) + 2
+}
+
+// decodeRDataName decodes the name at offset of a record's data, which
+// ends at end, and returns the offset following it.
+func decodeRDataName(data []byte, offset, end int, buffer *[]byte) ([]byte, int, error) {
+	if offset >= end {
+		return nil, 0, errDNSRDataTooShort
+	}
+	name, next, err := decodeName(data, offset, buffer, 1)
+	if err != nil {
+		return nil, 0, err
+	}
+	if next > end {
+		return nil, 0, errDNSRDataTooShort
+	}
+	return name, next, nil
+}
+
+// decodeRDataString decodes the <character-string> at the start of b, and
+// returns it with the rest of b.
+func decodeRDataString(b []byte) ([]byte, []byte, error) {
+	if len(b) < 1 || len(b) < 1+int(b[0]) {
+		return nil, nil, errCharStringMissData
+	}
+	return b[1 : 1+int(b[0])], b[1+int(b[0]):], nil
+}
+
+// encodeRDataString encodes s, the field what of a record, as a
+// <character-string> at the start of data, and returns its size.  It fails
+// if s is longer than 255 bytes.
+func encodeRDataString(data, s []byte, what string) (int, error) {
+	if len(s) > 255 {
+		return 0, fmt.Errorf("DNS %s of %d bytes", what, len(s))
+	}
+	data[0] = byte(len(s))
+	return 1 + copy(data[1:], s), nil
+}
+
+// DNSDS is a Delegation Signer record, see RFC 4034 section 5.
+type DNSDS struct {
+	KeyTag     uint16
+	Algorithm  DNSSECAlgorithm
+	DigestType DNSSECDigestType
+	Digest     []byte
+}
+
+func (ds *DNSDS) decode(rdata []byte) error {
+	if len(rdata) < 4 {
+		return errDNSRDataTooShort
+	}
+	ds.KeyTag = binary.BigEndian.Uint16(rdata)
+	ds.Algorithm = DNSSECAlgorithm(rdata[2])
+	ds.DigestType = DNSSECDigestType(rdata[3])
+	ds.Digest = rdata[4:]
+	return nil
+}
+
+func (ds *DNSDS) size() int { return 4 + len(ds.Digest) }
+
+func (ds *DNSDS) encode(data []byte) {
+	binary.BigEndian.PutUint16(data, ds.KeyTag)
+	data[2] = byte(ds.Algorithm)
+	data[3] = byte(ds.DigestType)
+	copy(data[4:], ds.Digest)
+}
+
+// DNSDNSKEY is a DNS Public Key record, see RFC 4034 section 2.
+type DNSDNSKEY struct {
+	// Flags has DNSKEYFlagZone set for zone keys, and DNSKEYFlagSEP for key
+	// signing keys.
+	Flags     uint16
+	Protocol  uint8
+	Algorithm DNSSECAlgorithm
+	PublicKey []byte
+}
+
+// Flags of DNSKEY records.
+const (
+	DNSKEYFlagZone   uint16 = 0x0100
+	DNSKEYFlagRevoke uint16 = 0x0080
+	DNSKEYFlagSEP    uint16 = 0x0001
+)
+
+func (k *DNSDNSKEY) decode(rdata []byte) error {
+	if len(rdata) < 4 {
+		return errDNSRDataTooShort
+	}
+	k.Flags = binary.BigEndian.Uint16(rdata)
+	k.Protocol = rdata[2]
+	k.Algorithm = DNSSECAlgorithm(rdata[3])
+	k.PublicKey = rdata[4:]
+	return nil
+}
+
+func (k *DNSDNSKEY) size() int { return 4 + len(k.PublicKey) }
+
+func (k *DNSDNSKEY) encode(data []byte) {
+	binary.BigEndian.PutUint16(data, k.Flags)
+	data[2] = k.Protocol
+	data[3] = byte(k.Algorithm)
+	copy(data[4:], k.PublicKey)
+}
+
+// KeyTag returns the key tag of the key, which DS and RRSIG records
+// refer to it by, see RFC 4034 appendix B.
+func (k *DNSDNSKEY) KeyTag() uint16 {
+	rdata := make([]byte, k.size())
+	k.encode(rdata)
+	var ac uint32
+	for i, b := range rdata {
+		if i&1 == 0 {
+			ac += uint32(b) << 8
+		} else {
+			ac += uint32(b)
+		}
+	}
+	ac += ac >> 16 & 0xffff
+	return uint16(ac)
+}
+
+// DNSRRSIG is a Resource Record Signature, see RFC 4034 section 3.
+type DNSRRSIG struct {
+	TypeCovered DNSType
+	Algorithm   DNSSECAlgorithm
+	Labels      uint8
+	OriginalTTL uint32
+	// Expiration and Inception are in seconds since the Unix epoch, modulo
+	// 2^32.
+	Expiration, Inception uint32
+	KeyTag                uint16
+	SignerName            []byte `gopacket:"text"`
+	Signature             []byte
+}
+
+func (s *DNSRRSIG) decode(data []byte, offset, end int, buffer *[]byte) error {
+	if end-offset < 18 {
+		return errDNSRDataTooShort
+	}
+	rdata := data[offset:end]
+	s.TypeCovered = DNSType(binary.BigEndian.Uint16(rdata))
+	s.Algorithm = DNSSECAlgorithm(rdata[2])
+	s.Labels = rdata[3]
+	s.OriginalTTL = binary.BigEndian.Uint32(rdata[4:])
+	s.Expiration = binary.BigEndian.Uint32(rdata[8:])
+	s.Inception = binary.BigEndian.Uint32(rdata[12:])
+	s.KeyTag = binary.BigEndian.Uint16(rdata[16:])
+	name, next, err := decodeRDataName(data, offset+18, end, buffer)
+	if err != nil {
+		return err
+	}
+	s.SignerName = name
+	s.Signature = data[next:end]
+	return nil
+}
+
+func (s *DNSRRSIG) size() int { return 18 + dnsNameSize(s.SignerName) + len(s.Signature) }
+
+func (s *DNSRRSIG) encode(data []byte, offset int) {
+	b := data[offset:]
+	binary.BigEndian.PutUint16(b, uint16(s.TypeCovered))
+	b[2] = byte(s.Algorithm)
+	b[3] = s.Labels
+	binary.BigEndian.PutUint32(b[4:], s.OriginalTTL)
+	binary.BigEndian.PutUint32(b[8:], s.Expiration)
+	binary.BigEndian.PutUint32(b[12:], s.Inception)
+	binary.BigEndian.PutUint16(b[16:], s.KeyTag)
+	off := encodeName(s.SignerName, data, offset+18)
+	copy(data[off:], s.Signature)
+}
+
+// DNSNSEC is a Next Secure record, see RFC 4034 section 4.
+type DNSNSEC struct {
+	NextDomain []byte `gopacket:"text"`
+	// Types are the types of the records of the owner name, in order.
+	Types []DNSType
+}
+
+func (n *DNSNSEC) decode(data []byte, offset, end int, buffer *[]byte) error {
+	name, next, err := decodeRDataName(data, offset, end, buffer)
+	if err != nil {
+		return err
+	}
+	n.NextDomain = name
+	n.Types, err = decodeDNSTypeBitmap(data[next:end])
+	return err
+}
+
+func (n *DNSNSEC) size() int { return dnsNameSize(n.NextDomain) + len(encodeDNSTypeBitmap(n.Types)) }
+
+func (n *DNSNSEC) encode(data []byte, offset int) {
+	off := encodeName(n.NextDomain, data, offset)
+	copy(data[off:], encodeDNSTypeBitmap(n.Types))
+}
+
+// DNSNSEC3 is a hashed Next Secure record, see RFC 5155 section 3.
+type DNSNSEC3 struct {
+	HashAlgorithm uint8
+	// Flags has the opt-out flag as its lowest bit.
+	Flags      uint8
+	Iterations uint16
+	Salt       []byte
+	// NextHashedOwner is the hash as sent, not in its base32 text form.
+	NextHashedOwner []byte
+	Types           []DNSType
+}
+
+func (n *DNSNSEC3) decode(rdata []byte) error {
+	if len(rdata) < 5 {
+		return errDNSRDataTooShort
+	}
+	n.HashAlgorithm = rdata[0]
+	n.Flags = rdata[1]
+	n.Iterations = binary.BigEndian.Uint16(rdata[2:])
+	var err error
+	var rest []byte
+	if n.Salt, rest, err = decodeRDataString(rdata[4:]); err != nil {
+		return err
+	}
+	if n.NextHashedOwner, rest, err = decodeRDataString(rest); err != nil {
+		return err
+	}
+	n.Types, err = decodeDNSTypeBitmap(rest)
+	return err
+}
+
+func (n *DNSNSEC3) size() int {
+	return 6 + len(n.Salt) + len(n.NextHashedOwner) + len(encodeDNSTypeBitmap(n.Types))
+}
+
+func (n *DNSNSEC3) encode(data []byte) error {
+	data[0] = n.HashAlgorithm
+	data[1] = n.Flags
+	binary.BigEndian.PutUint16(data[2:], n.Iterations)
+	size, err := encodeRDataString(data[4:], n.Salt, "NSEC3 salt")
+	if err != nil {
+		return err
+	}
+	off := 4 + size
+	if size, err = encodeRDataString(data[off:], n.NextHashedOwner, "NSEC3 next hashed owner"); err != nil {
+		return err
+	}
+	off += size
+	copy(data[off:], encodeDNSTypeBitmap(n.Types))
+	return nil
+}
+
+// decodeDNSTypeBitmap decodes the type bit maps of NSEC and NSEC3 records.
+func decodeDNSTypeBitmap(b []byte) ([]DNSType, error) {
+	types := []DNSType{}
+	for len(b) > 0 {
+		if len(b) < 2 || b[1] == 0 || b[1] > 32 || len(b) < 2+int(b[1]) {
+			return nil, errors.New("malformed DNS type bit map")
+		}
+		window := int(b[0]) << 8
+		for i, bits := range b[2 : 2+int(b[1])] {
+			for j := 0; j < 8; j++ {
+				if bits&(0x80>>uint(j)) != 0 {
+					types = append(types, DNSType(window+i*8+j))
+				}
+			}
+		}
+		b = b[2+int(b[1]):]
+	}
+	return types, nil
+}
+
+// encodeDNSTypeBitmap encodes types as the type bit maps of NSEC and NSEC3
+// records.
+func encodeDNSTypeBitmap(types []DNSType) []byte {
+	sorted := append([]DNSType(nil), types...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+	var b []byte
+	start := -1
+	for _, t := range sorted {
+		window, bit := int(t>>8), int(t&0xff)
+		if start < 0 || int(b[start]) != window {
+			start = len(b)
+			b = append(b, byte(window), 0)
+		}
+		for int(b[start+1]) <= bit/8 {
+			b = append(b, 0)
+			b[start+1]++
+		}
+		b[start+2+bit/8] |= 0x80 >> uint(bit%8)
+	}
+	return b
+}
+
+// DNSCAA is a Certification Authority Authorization record, see RFC 8659.
+type DNSCAA struct {
+	// Flags has the issuer critical flag as its highest bit.
+	Flags uint8
+	Tag   []byte `gopacket:"text"`
+	Value []byte `gopacket:"text"`
+}
+
+func (c *DNSCAA) decode(rdata []byte) error {
+	if len(rdata) < 2 || len(rdata) < 2+int(rdata[1]) {
+		return errDNSRDataTooShort
+	}
+	c.Flags = rdata[0]
+	c.Tag = rdata[2 : 2+int(rdata[1])]
+	c.Value = rdata[2+int(rdata[1]):]
+	return nil
+}
+
+func (c *DNSCAA) size() int { return 2 + len(c.Tag) + len(c.Value) }
+
+func (c *DNSCAA) encode(data []byte) error {
+	data[0] = c.Flags
+	size, err := encodeRDataString(data[1:], c.Tag, "CAA tag")
+	if err != nil {
+		return err
+	}
+	copy(data[1+size:], c.Value)
+	return nil
+}
+
+// DNSNAPTR is a Naming Authority Pointer record, see RFC 3403.
+type DNSNAPTR struct {
+	Order, Preference      uint16
+	Flags, Service, Regexp []byte `gopacket:"text"`
+	Replacement            []byte `gopacket:"text"`
+}
+
+func (n *DNSNAPTR) decode(data []byte, offset, end int, buffer *[]byte) error {
+	if end-offset < 4 {
+		return errDNSRDataTooShort
+	}
+	n.Order = binary.BigEndian.Uint16(data[offset:])
+	n.Preference = binary.BigEndian.Uint16(data[offset+2:])
+	rest := data[offset+4 : end]
+	var err error
+	for _, s := range []*[]byte{&n.Flags, &n.Service, &n.Regexp} {
+		if *s, rest, err = decodeRDataString(rest); err != nil {
+			return err
+		}
+	}
+	n.Replacement, _, err = decodeRDataName(data, end-len(rest), end, buffer)
+	return err
+}
+
+func (n *DNSNAPTR) size() int {
+	return 7 + len(n.Flags) + len(n.Service) + len(n.Regexp) + dnsNameSize(n.Replacement)
+}
+
+func (n *DNSNAPTR) encode(data []byte, offset int) error {
+	binary.BigEndian.PutUint16(data[offset:], n.Order)
+	binary.BigEndian.PutUint16(data[offset+2:], n.Preference)
+	off := offset + 4
+	for _, s := range [][]byte{n.Flags, n.Service, n.Regexp} {
+		size, err := encodeRDataString(data[off:], s, "NAPTR string")
+		if err != nil {
+			return err
+		}
+		off += size
+	}
+	encodeName(n.Replacement, data, off)
+	return nil
+}
+
+// DNSTLSA is a TLS certificate association record of DANE, see RFC 6698.
+type DNSTLSA struct {
+	Usage, Selector, MatchingType uint8
+	Certificate                   []byte
+}
+
+func (t *DNSTLSA) decode(rdata []byte) error {
+	if len(rdata) < 3 {
+		return errDNSRDataTooShort
+	}
+	t.Usage, t.Selector, t.MatchingType = rdata[0], rdata[1], rdata[2]
+	t.Certificate = rdata[3:]
+	return nil
+}
+
+func (t *DNSTLSA) size() int { return 3 + len(t.Certificate) }
+
+func (t *DNSTLSA) encode(data []byte) {
+	data[0], data[1], data[2] = t.Usage, t.Selector, t.MatchingType
+	copy(data[3:], t.Certificate)
+}
+
+// DNSSSHFP is an SSH public key fingerprint record, see RFC 4255.
+type DNSSSHFP struct {
+	Algorithm, FingerprintType uint8
+	Fingerprint                []byte
+}
+
+func (s *DNSSSHFP) decode(rdata []byte) error {
+	if len(rdata) < 2 {
+		return errDNSRDataTooShort
+	}
+	s.Algorithm, s.FingerprintType = rdata[0], rdata[1]
+	s.Fingerprint = rdata[2:]
+	return nil
+}
+
+func (s *DNSSSHFP) size() int { return 2 + len(s.Fingerprint) }
+
+func (s *DNSSSHFP) encode(data []byte) {
+	data[0], data[1] = s.Algorithm, s.FingerprintType
+	copy(data[2:], s.Fingerprint)
+}
+
+// DNSURI is a Uniform Resource Identifier record, see RFC 7553.
+type DNSURI struct {
+	Priority, Weight uint16
+	Target           []byte `gopacket:"text"`
+}
+
+func (u *DNSURI) decode(rdata []byte) error {
+	if len(rdata) < 4 {
+		return errDNSRDataTooShort
+	}
+	u.Priority = binary.BigEndian.Uint16(rdata)
+	u.Weight = binary.BigEndian.Uint16(rdata[2:])
+	u.Target = rdata[4:]
+	return nil
+}
+
+func (u *DNSURI) size() int { return 4 + len(u.Target) }
+
+func (u *DNSURI) encode(data []byte) {
+	binary.BigEndian.PutUint16(data, u.Priority)
+	binary.BigEndian.PutUint16(data[2:], u.Weight)
+	copy(data[4:], u.Target)
+}
+
+// DNSCERT is a certificate record, see RFC 4398.
+type DNSCERT struct {
+	Type        uint16
+	KeyTag      uint16
+	Algorithm   DNSSECAlgorithm
+	Certificate []byte
+}
+
+func (c *DNSCERT) decode(rdata []byte) error {
+	if len(rdata) < 5 {
+		return errDNSRDataTooShort
+	}
+	c.Type = binary.BigEndian.Uint16(rdata)
+	c.KeyTag = binary.BigEndian.Uint16(rdata[2:])
+	c.Algorithm = DNSSECAlgorithm(rdata[4])
+	c.Certificate = rdata[5:]
+	return nil
+}
+
+func (c *DNSCERT) size() int { return 5 + len(c.Certificate) }
+
+func (c *DNSCERT) encode(data []byte) {
+	binary.BigEndian.PutUint16(data, c.Type)
+	binary.BigEndian.PutUint16(data[2:], c.KeyTag)
+	data[4] = byte(c.Algorithm)
+	copy(data[5:], c.Certificate)
+}
+
+// DNSSVCBParamKey is the key of a service parameter of SVCB and HTTPS
+// records, see RFC 9460.
+type DNSSVCBParamKey uint16
+
+// DNSSVCBParamKey known values.
+const (
+	DNSSVCBParamMandatory     DNSSVCBParamKey = 0
+	DNSSVCBParamALPN          DNSSVCBParamKey = 1
+	DNSSVCBParamNoDefaultALPN DNSSVCBParamKey = 2
+	DNSSVCBParamPort          DNSSVCBParamKey = 3
+	DNSSVCBParamIPv4Hint      DNSSVCBParamKey = 4
+	DNSSVCBParamECH           DNSSVCBParamKey = 5
+	DNSSVCBParamIPv6Hint      DNSSVCBParamKey = 6
+	DNSSVCBParamDOHPath       DNSSVCBParamKey = 7
+	DNSSVCBParamOHTTP         DNSSVCBParamKey = 8
+)
+
+var dnsSVCBParamKeyNames = map[DNSSVCBParamKey]string{
+	DNSSVCBParamMandatory:     "mandatory",
+	DNSSVCBParamALPN:          "alpn",
+	DNSSVCBParamNoDefaultALPN: "no-default-alpn",
+	DNSSVCBParamPort:          "port",
+	DNSSVCBParamIPv4Hint:      "ipv4hint",
+	DNSSVCBParamECH:           "ech",
+	DNSSVCBParamIPv6Hint:      "ipv6hint",
+	DNSSVCBParamDOHPath:       "dohpath",
+	DNSSVCBParamOHTTP:         "ohttp",
+}
+
+// String returns the key's name in the presentation format, which is
+// keyNNNNN for unknown keys.
+func (k DNSSVCBParamKey) String() string {
+	if name, ok := dnsSVCBParamKeyNames[k]; ok {
+		return name
+	}
+	return "key" + strconv.Itoa(int(k))
+}
+
+// DNSSVCBParam is a service parameter of an SVCB or HTTPS record.
+type DNSSVCBParam struct {
+	Key   DNSSVCBParamKey
+	Value []byte
+}
+
+// String returns the parameter in the presentation format.
+func (p DNSSVCBParam) String() string {
+	var values []string
+	switch p.Key {
+	case DNSSVCBParamNoDefaultALPN, DNSSVCBParamOHTTP:
+		return p.Key.String()
+	case DNSSVCBParamMandatory:
+		for v := p.Value; len(v) >= 2; v = v[2:] {
+			values = append(values, DNSSVCBParamKey(binary.BigEndian.Uint16(v)).String())
+		}
+	case DNSSVCBParamALPN:
+		for _, id := range p.alpn() {
+			values = append(values, string(id))
+		}
+	case DNSSVCBParamPort:
+		if len(p.Value) == 2 {
+			values = append(values, strconv.Itoa(int(binary.BigEndian.Uint16(p.Value))))
+		}
+	case DNSSVCBParamIPv4Hint, DNSSVCBParamIPv6Hint:
+		for _, ip := range p.ipHints() {
+			values = append(values, ip.String())
+		}
+	case DNSSVCBParamECH:
+		values = append(values, base64.StdEncoding.EncodeToString(p.Value))
+	default:
+		values = append(values, strconv.Quote(string(p.Value)))
+	}
+	return p.Key.String() + "=" + strings.Join(values, ",")
+}
+
+// alpn returns the protocol IDs of an alpn parameter.
+func (p DNSSVCBParam) alpn() [][]byte {
+	var ids [][]byte
+	for v := p.Value; len(v) > 0; {
+		id, rest, err := decodeRDataString(v)
+		if err != nil {
+			break
+		}
+		ids = append(ids, id)
+		v = rest
+	}
+	return ids
+}
+
+// ipHints returns the addresses of an ipv4hint or ipv6hint parameter.
+func (p DNSSVCBParam) ipHints() []net.IP {
+	n := net.IPv4len
+	if p.Key == DNSSVCBParamIPv6Hint {
+		n = net.IPv6len
+	}
+	var ips []net.IP
+	for v := p.Value; len(v) >= n; v = v[n:] {
+		ips = append(ips, net.IP(v[:n]))
+	}
+	return ips
+}
+
+// DNSSVCB is a Service Binding record, of type SVCB or HTTPS, see RFC
+// 9460.  A zero Priority is the AliasMode, whose record has no
+// parameters.
+type DNSSVCB struct {
+	Priority uint16
+	// Target is the target name, which is empty for the root, ".".
+	Target []byte `gopacket:"text"`
+	// Params are in the order they were sent, which is that of their keys.
+	Params []DNSSVCBParam
+}
+
+func (s *DNSSVCB) decode(data []byte, offset, end int, buffer *[]byte) error {
+	if end-offset < 3 {
+		return errDNSRDataTooShort
+	}
+	s.Priority = binary.BigEndian.Uint16(data[offset:])
+	name, next, err := decodeRDataName(data, offset+2, end, buffer)
+	if err != nil {
+		return err
+	}
+	s.Target = name
+	s.Params = nil
+	for b := data[next:end]; len(b) > 0; {
+		if len(b) < 4 || len(b) < 4+int(binary.BigEndian.Uint16(b[2:])) {
+			return fmt.Errorf("SVCB parameter of %d bytes truncated", len(b))
+		}
+		n := int(binary.BigEndian.Uint16(b[2:]))
+		s.Params = append(s.Params, DNSSVCBParam{
+			Key:   DNSSVCBParamKey(binary.BigEndian.Uint16(b)),
+			Value: b[4 : 4+n],
+		})
+		b = b[4+n:]
+	}
+	return nil
+}
+
+func (s *DNSSVCB) size() int {
+	n := 2 + dnsNameSize(s.Target)
+	for _, p := range s.Params {
+		n += 4 + len(p.Value)
+	}
+	return n
+}
+
+func (s *DNSSVCB) encode(data []byte, offset int) error {
+	binary.BigEndian.PutUint16(data[offset:], s.Priority)
+	off := encodeName(s.Target, data, offset+2)
+	for _, p := range s.Params {
+		if len(p.Value) > 0xffff {
+			return fmt.Errorf("SVCB %v parameter of %d bytes", p.Key, len(p.Value))
+		}
+		binary.BigEndian.PutUint16(data[off:], uint16(p.Key))
+		binary.BigEndian.PutUint16(data[off+2:], uint16(len(p.Value)))
+		off += 4 + copy(data[off+4:], p.Value)
+	}
+	return nil
+}
+
+// Param returns the value of the parameter with the given key.
+func (s *DNSSVCB) Param(key DNSSVCBParamKey) ([]byte, bool) {
+	for _, p := range s.Params {
+		if p.Key == key {
+			return p.Value, true
+		}
+	}
+	return nil, false
+}
+
+// ALPN returns the protocol IDs of the alpn parameter.
+func (s *DNSSVCB) ALPN() []string {
+	v, _ := s.Param(DNSSVCBParamALPN)
+	var ids []string
+	for _, id := range (DNSSVCBParam{Key: DNSSVCBParamALPN, Value: v}).alpn() {
+		ids = append(ids, string(id))
+	}
+	return ids
+}
+
+// Port returns the port parameter.
+func (s *DNSSVCB) Port() (uint16, bool) {
+	v, ok := s.Param(DNSSVCBParamPort)
+	if !ok || len(v) != 2 {
+		return 0, false
+	}
+	return binary.BigEndian.Uint16(v), true
+}
+
+// IPHints returns the addresses of the ipv4hint and ipv6hint parameters.
+func (s *DNSSVCB) IPHints() []net.IP {
+	var ips []net.IP
+	for _, p := range s.Params {
+		if p.Key == DNSSVCBParamIPv4Hint || p.Key == DNSSVCBParamIPv6Hint {
+			ips = append(ips, p.ipHints()...)
+		}
+	}
+	return ips
+}
+
+// String returns the record's data in the presentation format.
+func (s *DNSSVCB) String() string {
+	target := string(s.Target)
+	if target == "" {
+		target = "."
+	}
+	fields := []string{strconv.Itoa(int(s.Priority)), target}
+	for _, p := range s.Params {
+		fields = append(fields, p.String())
+	}
+	return strings.Join(fields, " ")
+}
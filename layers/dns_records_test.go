@@ -0,0 +1,516 @@
+// Copyright 2019 The GoPacket Authors. All rights reserved.
+//
+// Use of this source code is governed by a BSD-style license
+// that can be found in the LICENSE file in the root of the source
+// tree.
+
+package layers
+
+import (
+	"bytes"
+	"encoding/binary"
+	"net"
+	"reflect"
+	"testing"
+
+	"github.com/google/gopacket"
+)
+
+// testDNSResponse returns a response with no question and one answer of the
+// given type and data, whose names aren't compressed, so that serializing
+// its decoding gives it back.
+func testDNSResponse(name string, typ DNSType, rdata []byte) []byte {
+	data := []byte{0xbe, 0xef, 0x81, 0x80, 0, 0, 0, 1, 0, 0, 0, 0}
+	n := make([]byte, len(name)+2)
+	data = append(data, n[:encodeName([]byte(name), n, 0)]...)
+	var b [10]byte
+	binary.BigEndian.PutUint16(b[0:], uint16(typ))
+	binary.BigEndian.PutUint16(b[2:], uint16(DNSClassIN))
+	binary.BigEndian.PutUint32(b[4:], 3600)
+	binary.BigEndian.PutUint16(b[8:], uint16(len(rdata)))
+	data = append(data, b[:]...)
+	return append(data, rdata...)
+}
+
+// The records are the examples of the RFCs defining their types, except
+// for the CAA record of google.com and the HTTPS record of cloudflare.com.
+var testDNSRecords = []struct {
+	name, owner string
+	typ         DNSType
+	rdata       string
+	check       func(t *testing.T, rr *DNSResourceRecord)
+}{
+	{
+		name: "DNSKEY", owner: "dskey.example.com", typ: DNSTypeDNSKEY,
+		rdata: "0100030501039e8a247418e318903b215a848acfd5f37f026bd4062db26c774c690968d5d56df8bfda91e6f36d9a279888f41333357c5e6029990d10fdf5663062a512763326980a615ddbf17a05ddfcce7e5fb3abcca05a31b0957452d4521e83870789063115bf97f6c308ccf57cdc9ce7fe10f6ed1bd0cc0660038c50dcdb0feb963c2f17",
+		check: func(t *testing.T, rr *DNSResourceRecord) {
+			k := rr.DNSKEY
+			if k.Flags != DNSKEYFlagZone || k.Protocol != 3 || k.Algorithm != DNSSECAlgorithmRSASHA1 || len(k.PublicKey) != 130 {
+				t.Errorf("DNSKEY %+v", k)
+			}
+			if tag := k.KeyTag(); tag != 60485 {
+				t.Errorf("key tag %d, want 60485", tag)
+			}
+		},
+	},
+	{
+		name: "DS", owner: "dskey.example.com", typ: DNSTypeDS,
+		rdata: "ec4505012bb183af5f22588179a53b0a98631fad1a292118",
+		check: func(t *testing.T, rr *DNSResourceRecord) {
+			ds := rr.DS
+			if ds.KeyTag != 60485 || ds.Algorithm != DNSSECAlgorithmRSASHA1 || ds.DigestType != DNSSECDigestSHA1 || len(ds.Digest) != 20 {
+				t.Errorf("DS %+v", ds)
+			}
+		},
+	},
+	{
+		name: "RRSIG", owner: "host.example.com", typ: DNSTypeRRSIG,
+		rdata: "00010503000151803e7c9dd73e5510d70a52076578616d706c6503636f6d00a090755ba58d1affa576f4375831b4310920e481218d18a9f164eb3d81afd3b875d3c75428631e0cf2a28d50875f70c329d7dbfafea807dc1fba1dc34c95d401f23f334ce63bfcf3f1b5b44739e5f0eded18d6b33f040a911376d173d757a9f0c1fa1798941bb0b36b2df9062790fa7f0166f2737eea907378341fb12dc0a77a",
+		check: func(t *testing.T, rr *DNSResourceRecord) {
+			s := rr.RRSIG
+			if s.TypeCovered != DNSTypeA || s.Algorithm != DNSSECAlgorithmRSASHA1 || s.Labels != 3 || s.OriginalTTL != 86400 ||
+				s.Expiration != 1048354263 || s.Inception != 1045762263 || s.KeyTag != 2642 ||
+				string(s.SignerName) != "example.com" || len(s.Signature) != 128 {
+				t.Errorf("RRSIG %+v", s)
+			}
+		},
+	},
+	{
+		name: "NSEC", owner: "alfa.example.com", typ: DNSTypeNSEC,
+		rdata: "04686f7374076578616d706c6503636f6d000006400100000003041b000000000000000000000000000000000000000000000000000020",
+		check: func(t *testing.T, rr *DNSResourceRecord) {
+			want := []DNSType{DNSTypeA, DNSTypeMX, DNSTypeRRSIG, DNSTypeNSEC, 1234}
+			if string(rr.NSEC.NextDomain) != "host.example.com" || !reflect.DeepEqual(rr.NSEC.Types, want) {
+				t.Errorf("NSEC %+v", rr.NSEC)
+			}
+		},
+	},
+	{
+		name: "NSEC3", owner: "0p9mhaveqvm6t7vbl5lop2u3t2rp3tom.example", typ: DNSTypeNSEC3,
+		rdata: "0101000c04aabbccdd14174eb2409fe28bcb4887a1836f957f0a8425e27b000722010000000290",
+		check: func(t *testing.T, rr *DNSResourceRecord) {
+			n := rr.NSEC3
+			want := []DNSType{DNSTypeNS, DNSTypeSOA, DNSTypeMX, DNSTypeRRSIG, DNSTypeDNSKEY, 51}
+			if n.HashAlgorithm != 1 || n.Flags != 1 || n.Iterations != 12 || !bytes.Equal(n.Salt, []byte{0xaa, 0xbb, 0xcc, 0xdd}) ||
+				len(n.NextHashedOwner) != 20 || !reflect.DeepEqual(n.Types, want) {
+				t.Errorf("NSEC3 %+v", n)
+			}
+		},
+	},
+	{
+		name: "CAA", owner: "google.com", typ: DNSTypeCAA,
+		rdata: "00056973737565706b692e676f6f67",
+		check: func(t *testing.T, rr *DNSResourceRecord) {
+			if c := rr.CAA; c.Flags != 0 || string(c.Tag) != "issue" || string(c.Value) != "pki.goog" {
+				t.Errorf("CAA %+v", c)
+			}
+		},
+	},
+	{
+		name: "NAPTR", owner: "cid.urn.arpa", typ: DNSTypeNAPTR,
+		rdata: "0064000a000021215e75726e3a6369643a2e2b40285b5e5c2e5d2b5c2e29282e2a2924215c32216900",
+		check: func(t *testing.T, rr *DNSResourceRecord) {
+			n := rr.NAPTR
+			if n.Order != 100 || n.Preference != 10 || len(n.Flags) != 0 || len(n.Service) != 0 ||
+				string(n.Regexp) != `!^urn:cid:.+@([^\.]+\.)(.*)$!\2!i` || len(n.Replacement) != 0 {
+				t.Errorf("NAPTR %+v", n)
+			}
+		},
+	},
+	{
+		name: "TLSA", owner: "_443._tcp.www.example.com", typ: DNSTypeTLSA,
+		rdata: "000001d2abde240d7cd3ee6b4b28c54df034b97983a1d16e8a410e4561cb106618e971",
+		check: func(t *testing.T, rr *DNSResourceRecord) {
+			if a := rr.TLSA; a.Usage != 0 || a.Selector != 0 || a.MatchingType != 1 || len(a.Certificate) != 32 {
+				t.Errorf("TLSA %+v", a)
+			}
+		},
+	},
+	{
+		name: "SSHFP", owner: "host.example", typ: DNSTypeSSHFP,
+		rdata: "0201123456789abcdef67890123456789abcdef67890",
+		check: func(t *testing.T, rr *DNSResourceRecord) {
+			if s := rr.SSHFP; s.Algorithm != 2 || s.FingerprintType != 1 || len(s.Fingerprint) != 20 {
+				t.Errorf("SSHFP %+v", s)
+			}
+		},
+	},
+	{
+		name: "URI", owner: "_ftp._tcp.example.com", typ: DNSTypeURI,
+		rdata: "000a00016674703a2f2f667470312e6578616d706c652e636f6d2f7075626c6963",
+		check: func(t *testing.T, rr *DNSResourceRecord) {
+			if u := rr.URI; u.Priority != 10 || u.Weight != 1 || string(u.Target) != "ftp://ftp1.example.com/public" {
+				t.Errorf("URI %+v", u)
+			}
+		},
+	},
+	{
+		name: "CERT", owner: "example.com", typ: DNSTypeCERT,
+		rdata: "0001303908308201",
+		check: func(t *testing.T, rr *DNSResourceRecord) {
+			if c := rr.CERT; c.Type != 1 || c.KeyTag != 12345 || c.Algorithm != DNSSECAlgorithmRSASHA256 || len(c.Certificate) != 3 {
+				t.Errorf("CERT %+v", c)
+			}
+		},
+	},
+	{
+		name: "HTTPS AliasMode", owner: "example.com", typ: DNSTypeHTTPS,
+		rdata: "000003666f6f076578616d706c6503636f6d00",
+		check: func(t *testing.T, rr *DNSResourceRecord) {
+			if s := rr.SVCB.String(); s != "0 foo.example.com" {
+				t.Errorf("HTTPS %s", s)
+			}
+		},
+	},
+	{
+		name: "SVCB port", owner: "example.com", typ: DNSTypeSVCB,
+		rdata: "001003666f6f076578616d706c6503636f6d00000300020035",
+		check: func(t *testing.T, rr *DNSResourceRecord) {
+			if port, ok := rr.SVCB.Port(); !ok || port != 53 {
+				t.Errorf("port %d, %v", port, ok)
+			}
+			if s := rr.SVCB.String(); s != "16 foo.example.com port=53" {
+				t.Errorf("SVCB %s", s)
+			}
+		},
+	},
+	{
+		name: "SVCB mandatory", owner: "example.com", typ: DNSTypeSVCB,
+		rdata: "001003666f6f076578616d706c65036f7267000000000400010004000100090268320568332d313900040004c0000201",
+		check: func(t *testing.T, rr *DNSResourceRecord) {
+			if s := rr.SVCB.String(); s != "16 foo.example.org mandatory=alpn,ipv4hint alpn=h2,h3-19 ipv4hint=192.0.2.1" {
+				t.Errorf("SVCB %s", s)
+			}
+		},
+	},
+	{
+		name: "HTTPS", owner: "cloudflare.com", typ: DNSTypeHTTPS,
+		rdata: "0001000001000602683302683200040008681084e5681085e500060020260647000000000000000000681084e5260647000000000000000000681085e5",
+		check: func(t *testing.T, rr *DNSResourceRecord) {
+			s := rr.SVCB
+			if s.Priority != 1 || len(s.Target) != 0 || !reflect.DeepEqual(s.ALPN(), []string{"h3", "h2"}) {
+				t.Errorf("HTTPS %+v", s)
+			}
+			want := []net.IP{
+				net.ParseIP("104.16.132.229").To4(), net.ParseIP("104.16.133.229").To4(),
+				net.ParseIP("2606:4700::6810:84e5"), net.ParseIP("2606:4700::6810:85e5"),
+			}
+			if ips := s.IPHints(); !reflect.DeepEqual(ips, want) {
+				t.Errorf("IP hints %v, want %v", ips, want)
+			}
+			if _, ok := s.Port(); ok {
+				t.Error("port without a port parameter")
+			}
+		},
+	},
+}
+
+func TestDNSRecordsRoundTrip(t *testing.T) {
+	for _, test := range testDNSRecords {
+		t.Run(test.name, func(t *testing.T) {
+			data := testDNSResponse(test.owner, test.typ, testHex(t, test.rdata))
+			var d DNS
+			if err := d.DecodeFromBytes(data, gopacket.NilDecodeFeedback); err != nil {
+				t.Fatal(err)
+			}
+			if len(d.Answers) != 1 || d.Answers[0].Type != test.typ {
+				t.Fatalf("answers %v", d.Answers)
+			}
+			test.check(t, &d.Answers[0])
+
+			buf := gopacket.NewSerializeBuffer()
+			if err := gopacket.SerializeLayers(buf, gopacket.SerializeOptions{FixLengths: true}, &d); err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(buf.Bytes(), data) {
+				t.Errorf("serialized\n%x, want\n%x", buf.Bytes(), data)
+			}
+		})
+	}
+}
+
+func TestDNSRecordsMalformed(t *testing.T) {
+	for _, test := range testDNSRecords {
+		rdata := testHex(t, test.rdata)
+		// Every type has a fixed part or a name, so no data is malformed.
+		for _, n := range []int{0, 1} {
+			var d DNS
+			if err := d.DecodeFromBytes(testDNSResponse(test.owner, test.typ, rdata[:n]), gopacket.NilDecodeFeedback); err == nil {
+				t.Errorf("%s of %d bytes decoded", test.name, n)
+			}
+		}
+	}
+	for _, bitmap := range [][]byte{{0}, {0, 0}, append([]byte{0, 33}, make([]byte, 33)...), {0, 2, 1}} {
+		if _, err := decodeDNSTypeBitmap(bitmap); err == nil {
+			t.Errorf("type bit map %x decoded", bitmap)
+		}
+	}
+}
+
+func TestDNSSVCBSerialize(t *testing.T) {
+	// Params are written in the order they are given.
+	d := &DNS{
+		ID: 1, QR: true,
+		Answers: []DNSResourceRecord{{
+			Name: []byte("example.com"), Type: DNSTypeHTTPS, Class: DNSClassIN, TTL: 300,
+			SVCB: DNSSVCB{Priority: 1, Params: []DNSSVCBParam{
+				{Key: DNSSVCBParamALPN, Value: []byte("\x02h2")},
+				{Key: DNSSVCBParamECH, Value: []byte{1, 2, 3}},
+				{Key: 65000, Value: []byte("x")},
+			}},
+		}},
+	}
+	buf := gopacket.NewSerializeBuffer()
+	if err := gopacket.SerializeLayers(buf, gopacket.SerializeOptions{FixLengths: true}, d); err != nil {
+		t.Fatal(err)
+	}
+	var got DNS
+	if err := got.DecodeFromBytes(buf.Bytes(), gopacket.NilDecodeFeedback); err != nil {
+		t.Fatal(err)
+	}
+	if s := got.Answers[0].SVCB.String(); s != `1 . alpn=h2 ech=AQID key65000="x"` {
+		t.Errorf("HTTPS %s", s)
+	}
+	if got.Answers[0].DataLength != uint16(recSize(&d.Answers[0])) {
+		t.Errorf("data length %d, want %d", got.Answers[0].DataLength, recSize(&d.Answers[0]))
+	}
+}
+
+func TestDNSRecordsSerializeTooLong(t *testing.T) {
+	long := make([]byte, 256)
+	for i, rr := range []DNSResourceRecord{
+		{Type: DNSTypeNSEC3, NSEC3: DNSNSEC3{Salt: long}},
+		{Type: DNSTypeNSEC3, NSEC3: DNSNSEC3{NextHashedOwner: long}},
+		{Type: DNSTypeCAA, CAA: DNSCAA{Tag: long}},
+		{Type: DNSTypeNAPTR, NAPTR: DNSNAPTR{Regexp: long}},
+		{Type: DNSTypeSVCB, SVCB: DNSSVCB{Priority: 1, Params: []DNSSVCBParam{{Key: DNSSVCBParamALPN, Value: make([]byte, 1<<16)}}}},
+	} {
+		rr.Name, rr.Class = []byte("example.com"), DNSClassIN
+		d := &DNS{QR: true, Answers: []DNSResourceRecord{rr}}
+		buf := gopacket.NewSerializeBuffer()
+		if err := gopacket.SerializeLayers(buf, gopacket.SerializeOptions{FixLengths: true}, d); err == nil {
+			t.Errorf("%v record %d: no error", rr.Type, i)
+		}
+	}
+
+	// 255 bytes fit.
+	d := &DNS{QR: true, Answers: []DNSResourceRecord{{Name: []byte("example.com"), Type: DNSTypeCAA, Class: DNSClassIN, CAA: DNSCAA{Tag: long[:255]}}}}
+	buf := gopacket.NewSerializeBuffer()
+	if err := gopacket.SerializeLayers(buf, gopacket.SerializeOptions{FixLengths: true}, d); err != nil {
+		t.Fatal(err)
+	}
+	var got DNS
+	if err := got.DecodeFromBytes(buf.Bytes(), gopacket.NilDecodeFeedback); err != nil || len(got.Answers[0].CAA.Tag) != 255 {
+		t.Errorf("CAA %+v, %v", got.Answers, err)
+	}
+}
+
+// dnsMessage builds DNS messages whose names may be compressed, as resolvers
+// send them.
+type dnsMessage struct {
+	b     []byte
+	rdata int // start of the open record's data
+}
+
+func newDNSMessage(flags uint16, counts ...uint16) *dnsMessage {
+	m := &dnsMessage{}
+	m.u16(0x4d2e).u16(flags)
+	for _, c := range counts {
+		m.u16(c)
+	}
+	return m
+}
+
+func (m *dnsMessage) u16(v uint16) *dnsMessage {
+	m.b = append(m.b, byte(v>>8), byte(v))
+	return m
+}
+
+func (m *dnsMessage) u32(v uint32) *dnsMessage { return m.u16(uint16(v >> 16)).u16(uint16(v)) }
+
+func (m *dnsMessage) raw(b ...byte) *dnsMessage { m.b = append(m.b, b...); return m }
+
+// name writes the labels of a name, ended by a pointer to offset if it is
+// positive and by the root label otherwise.
+func (m *dnsMessage) name(offset int, labels ...string) *dnsMessage {
+	for _, l := range labels {
+		m.b = append(m.b, byte(len(l)))
+		m.b = append(m.b, l...)
+	}
+	if offset > 0 {
+		return m.u16(0xc000 | uint16(offset))
+	}
+	return m.raw(0)
+}
+
+// rr starts a record whose owner name is already written, which end
+// closes by setting its data length.
+func (m *dnsMessage) rr(typ DNSType, ttl uint32) *dnsMessage {
+	m.u16(uint16(typ)).u16(uint16(DNSClassIN)).u32(ttl).u16(0)
+	m.rdata = len(m.b)
+	return m
+}
+
+func (m *dnsMessage) end() *dnsMessage {
+	binary.BigEndian.PutUint16(m.b[m.rdata-2:], uint16(len(m.b)-m.rdata))
+	return m
+}
+
+// rrsig writes the data of an ECDSA P-256 signature by example.com.
+func (m *dnsMessage) rrsig(covered DNSType, labels uint8) *dnsMessage {
+	m.u16(uint16(covered)).raw(byte(DNSSECAlgorithmECDSAP256SHA256), labels).u32(3600)
+	m.u32(1561939200).u32(1559347200).u16(12345)
+	// The signer's name is never compressed (RFC 4034 section 3.1.7).
+	m.name(0, "example", "com")
+	return m.raw(bytes.Repeat([]byte{0x5a}, 64)...)
+}
+
+// testDNSCompressedResponses are whole responses of a validating resolver,
+// with compressed names.
+var testDNSCompressedResponses = []struct {
+	name  string
+	data  func() []byte
+	check func(t *testing.T, d *DNS)
+}{
+	{
+		name: "CNAME and A with RRSIGs",
+		data: func() []byte {
+			m := newDNSMessage(0x81a0, 1, 4, 0, 1)
+			m.name(0, "www", "example", "com").u16(uint16(DNSTypeA)).u16(uint16(DNSClassIN)) // 12
+			m.name(12).rr(DNSTypeCNAME, 3600)
+			cname := len(m.b)
+			m.name(16).end()
+			m.name(12).rr(DNSTypeRRSIG, 3600).rrsig(DNSTypeCNAME, 3).end()
+			m.name(cname).rr(DNSTypeA, 86400).raw(93, 184, 216, 34).end()
+			m.name(cname).rr(DNSTypeRRSIG, 86400).rrsig(DNSTypeA, 2).end()
+			// OPT with DO set.
+			return m.raw(0).u16(uint16(DNSTypeOPT)).u16(1232).u32(0x8000).u16(0).b
+		},
+		check: func(t *testing.T, d *DNS) {
+			if !d.QR || !d.RD || !d.RA || len(d.Answers) != 4 || len(d.Additionals) != 1 {
+				t.Fatalf("response %+v", d)
+			}
+			a := d.Answers
+			if string(a[0].Name) != "www.example.com" || string(a[0].CNAME) != "example.com" {
+				t.Errorf("CNAME %s %s", a[0].Name, a[0].CNAME)
+			}
+			if string(a[1].Name) != "www.example.com" || a[1].RRSIG.TypeCovered != DNSTypeCNAME || string(a[1].RRSIG.SignerName) != "example.com" {
+				t.Errorf("RRSIG of CNAME %s %+v", a[1].Name, a[1].RRSIG)
+			}
+			if string(a[2].Name) != "example.com" || !a[2].IP.Equal(net.IP{93, 184, 216, 34}) {
+				t.Errorf("A %s %v", a[2].Name, a[2].IP)
+			}
+			if s := a[3].RRSIG; string(a[3].Name) != "example.com" || s.TypeCovered != DNSTypeA || s.Labels != 2 || s.KeyTag != 12345 || len(s.Signature) != 64 {
+				t.Errorf("RRSIG of A %s %+v", a[3].Name, s)
+			}
+			if e, ok := d.EDNS(); !ok || !e.DO || e.UDPSize != 1232 {
+				t.Errorf("EDNS %+v", e)
+			}
+		},
+	},
+	{
+		name: "NXDOMAIN with SOA and NSEC",
+		data: func() []byte {
+			m := newDNSMessage(0x81a3, 1, 0, 4, 0)
+			m.name(0, "nope", "example", "com").u16(uint16(DNSTypeA)).u16(uint16(DNSClassIN)) // 12
+			m.name(17).rr(DNSTypeSOA, 3600)
+			m.name(17, "ns1").name(17, "hostmaster").u32(2019040101).u32(7200).u32(3600).u32(1209600).u32(3600).end()
+			m.name(17).rr(DNSTypeRRSIG, 3600).rrsig(DNSTypeSOA, 2).end()
+			m.name(17).rr(DNSTypeNSEC, 3600)
+			// The next domain is never compressed (RFC 4034 section 4.1.1).
+			m.name(0, "www", "example", "com").raw(0, 7, 0x62, 0x01, 0x80, 0x08, 0x00, 0x03, 0x80).end()
+			m.name(17).rr(DNSTypeRRSIG, 3600).rrsig(DNSTypeNSEC, 2).end()
+			return m.b
+		},
+		check: func(t *testing.T, d *DNS) {
+			if d.ResponseCode != DNSResponseCodeNXDomain || len(d.Authorities) != 4 {
+				t.Fatalf("response %+v", d)
+			}
+			a := d.Authorities
+			if soa := a[0].SOA; string(a[0].Name) != "example.com" || string(soa.MName) != "ns1.example.com" || string(soa.RName) != "hostmaster.example.com" || soa.Serial != 2019040101 || soa.Minimum != 3600 {
+				t.Errorf("SOA %s %+v", a[0].Name, soa)
+			}
+			want := []DNSType{DNSTypeA, DNSTypeNS, DNSTypeSOA, DNSTypeMX, DNSTypeTXT, DNSTypeAAAA, DNSTypeRRSIG, DNSTypeNSEC, DNSTypeDNSKEY}
+			if n := a[2].NSEC; string(a[2].Name) != "example.com" || string(n.NextDomain) != "www.example.com" || !reflect.DeepEqual(n.Types, want) {
+				t.Errorf("NSEC %s %+v", a[2].Name, n)
+			}
+			if a[3].RRSIG.TypeCovered != DNSTypeNSEC {
+				t.Errorf("RRSIG of NSEC %+v", a[3].RRSIG)
+			}
+		},
+	},
+	{
+		name: "HTTPS and its RRSIG",
+		data: func() []byte {
+			m := newDNSMessage(0x81a0, 1, 2, 0, 0)
+			m.name(0, "example", "com").u16(uint16(DNSTypeHTTPS)).u16(uint16(DNSClassIN)) // 12
+			m.name(12).rr(DNSTypeHTTPS, 300)
+			// The target is never compressed (RFC 9460 section 2.2).
+			m.u16(1).name(0)
+			m.u16(uint16(DNSSVCBParamALPN)).u16(6).raw(2, 'h', '2', 2, 'h', '3')
+			m.u16(uint16(DNSSVCBParamIPv4Hint)).u16(8).raw(192, 0, 2, 1, 192, 0, 2, 2)
+			m.u16(uint16(DNSSVCBParamIPv6Hint)).u16(16).raw(net.ParseIP("2001:db8::1")...).end()
+			m.name(12).rr(DNSTypeRRSIG, 300).rrsig(DNSTypeHTTPS, 2).end()
+			return m.b
+		},
+		check: func(t *testing.T, d *DNS) {
+			if len(d.Answers) != 2 {
+				t.Fatalf("answers %+v", d.Answers)
+			}
+			s := d.Answers[0].SVCB
+			if string(d.Answers[0].Name) != "example.com" || s.Priority != 1 || len(s.Target) != 0 {
+				t.Errorf("HTTPS %s %+v", d.Answers[0].Name, s)
+			}
+			if got := s.String(); got != "1 . alpn=h2,h3 ipv4hint=192.0.2.1,192.0.2.2 ipv6hint=2001:db8::1" {
+				t.Errorf("HTTPS %s", got)
+			}
+			if string(d.Answers[1].Name) != "example.com" || d.Answers[1].RRSIG.TypeCovered != DNSTypeHTTPS {
+				t.Errorf("RRSIG of HTTPS %+v", d.Answers[1].RRSIG)
+			}
+		},
+	},
+}
+
+// TestDNSCompressedResponses decodes responses with compressed names,
+// serializes them, which doesn't compress names, and checks that decoding
+// that gives the same records.
+func TestDNSCompressedResponses(t *testing.T) {
+	for _, test := range testDNSCompressedResponses {
+		t.Run(test.name, func(t *testing.T) {
+			var d DNS
+			if err := d.DecodeFromBytes(test.data(), gopacket.NilDecodeFeedback); err != nil {
+				t.Fatal(err)
+			}
+			test.check(t, &d)
+
+			buf := gopacket.NewSerializeBuffer()
+			if err := gopacket.SerializeLayers(buf, gopacket.SerializeOptions{FixLengths: true}, &d); err != nil {
+				t.Fatal(err)
+			}
+			var got DNS
+			if err := got.DecodeFromBytes(buf.Bytes(), gopacket.NilDecodeFeedback); err != nil {
+				t.Fatal(err)
+			}
+			test.check(t, &got)
+			for _, rrs := range [][]DNSResourceRecord{d.Answers, d.Authorities, d.Additionals, got.Answers, got.Authorities, got.Additionals} {
+				for i := range rrs {
+					// The data of records holding names was compressed.
+					rrs[i].Data, rrs[i].DataLength = nil, 0
+				}
+			}
+			for _, c := range []struct {
+				name      string
+				got, want interface{}
+			}{
+				{"questions", got.Questions, d.Questions},
+				{"answers", got.Answers, d.Answers},
+				{"authorities", got.Authorities, d.Authorities},
+				{"additionals", got.Additionals, d.Additionals},
+			} {
+				if !reflect.DeepEqual(c.got, c.want) {
+					t.Errorf("%s after a round trip:\n%+v\nwant\n%+v", c.name, c.got, c.want)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,240 @@
+// Copyright 2019 The GoPacket Authors. All rights reserved.
+//
+// Use of this source code is governed by a BSD-style license
+// that can be found in the LICENSE file in the root of the source
+// tree.
+
+package layers
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/google/gopacket"
+)
+
+// HTTPHeader is a header field of an HTTP message, as it was sent.
+type HTTPHeader struct {
+	Name, Value string
+}
+
+// HTTP is an HTTP/1.0 or HTTP/1.1 request or response head: its start line
+// and header fields.  Whatever follows the head, usually the start of the
+// body, is the payload.
+//
+// HTTP is only decoded from TCP packets of ports 80 and 8080 when
+// gopacket.DecodeOptions.DecodeStreamsAsDatagrams is set, and only from
+// packets which start a message.  Messages split across packets need
+// reassembly, as in the reassembly/httpstream package.
+type HTTP struct {
+	BaseLayer
+
+	// IsResponse tells whether the message is a response.
+	IsResponse bool
+	// Method and RequestURI are the method and target of requests.
+	Method     string
+	RequestURI string
+	// StatusCode and Reason are the status of responses.
+	StatusCode int
+	Reason     string
+	// Version is the protocol version, such as "HTTP/1.1".
+	Version string
+	// Headers holds the header fields in order.  Obsolete line folding is
+	// replaced by a space.
+	Headers []HTTPHeader
+}
+
+// LayerType returns LayerTypeHTTP.
+func (h *HTTP) LayerType() gopacket.LayerType { return LayerTypeHTTP }
+
+// CanDecode returns LayerTypeHTTP.
+func (h *HTTP) CanDecode() gopacket.LayerClass { return LayerTypeHTTP }
+
+// NextLayerType returns gopacket.LayerTypePayload.
+func (h *HTTP) NextLayerType() gopacket.LayerType { return gopacket.LayerTypePayload }
+
+// Payload returns the data following the head.
+func (h *HTTP) Payload() []byte { return h.BaseLayer.Payload }
+
+// Header returns the value of the first header field with the name, which
+// is case insensitive, or "" if there is none.
+func (h *HTTP) Header(name string) string {
+	for _, f := range h.Headers {
+		if strings.EqualFold(f.Name, name) {
+			return f.Value
+		}
+	}
+	return ""
+}
+
+// Values returns the values of the header fields with the name, which is
+// case insensitive.
+func (h *HTTP) Values(name string) []string {
+	var values []string
+	for _, f := range h.Headers {
+		if strings.EqualFold(f.Name, name) {
+			values = append(values, f.Value)
+		}
+	}
+	return values
+}
+
+// HasToken tells whether a comma separated header field, such as
+// Connection or Transfer-Encoding, lists the token, which is case
+// insensitive.
+func (h *HTTP) HasToken(name, token string) bool {
+	for _, v := range h.Values(name) {
+		for _, t := range strings.Split(v, ",") {
+			if strings.EqualFold(strings.TrimSpace(t), token) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// isHTTP tells whether the port is one HTTP is decoded from.
+func (a TCPPort) isHTTP() bool {
+	return a == 80 || a == 8080
+}
+
+func decodeHTTP(data []byte, p gopacket.PacketBuilder) error {
+	// Packets in the middle of a message, such as the rest of a body, are
+	// left as payload.
+	if !httpStartsMessage(data) {
+		return p.NextDecoder(gopacket.LayerTypePayload)
+	}
+	h := &HTTP{}
+	err := h.DecodeFromBytes(data, p)
+	if err != nil {
+		return err
+	}
+	p.AddLayer(h)
+	p.SetApplicationLayer(h)
+	return p.NextDecoder(gopacket.LayerTypePayload)
+}
+
+// httpStartsMessage tells whether data starts with what looks like the
+// start line of a request or response.
+func httpStartsMessage(data []byte) bool {
+	data = httpSkipEmptyLines(data)
+	i := bytes.IndexByte(data, '\n')
+	if i < 0 {
+		return false
+	}
+	var h HTTP
+	return h.decodeStartLine(bytes.TrimSuffix(data[:i], []byte{'\r'})) == nil
+}
+
+// httpSkipEmptyLines skips the few empty lines which may precede a request.
+func httpSkipEmptyLines(data []byte) []byte {
+	for i := 0; i < 4 && len(data) > 0 && (data[0] == '\r' || data[0] == '\n'); i++ {
+		data = data[1:]
+	}
+	return data
+}
+
+// DecodeFromBytes decodes the slice into the HTTP struct.  A head which
+// isn't complete is decoded as far as it goes, and reported as truncated.
+func (h *HTTP) DecodeFromBytes(data []byte, df gopacket.DecodeFeedback) error {
+	*h = HTTP{}
+	rest := httpSkipEmptyLines(data)
+	first := true
+	for {
+		i := bytes.IndexByte(rest, '\n')
+		if i < 0 {
+			if first && len(rest) > 0 {
+				// A partial start line can't be checked.
+				df.SetTruncated()
+				return errors.New("HTTP start line truncated")
+			}
+			h.BaseLayer = BaseLayer{Contents: data}
+			df.SetTruncated()
+			return nil
+		}
+		line := bytes.TrimSuffix(rest[:i], []byte{'\r'})
+		rest = rest[i+1:]
+		switch {
+		case first:
+			if err := h.decodeStartLine(line); err != nil {
+				return err
+			}
+			first = false
+		case len(line) == 0:
+			n := len(data) - len(rest)
+			h.BaseLayer = BaseLayer{Contents: data[:n], Payload: data[n:]}
+			return nil
+		case line[0] == ' ' || line[0] == '\t':
+			if len(h.Headers) == 0 {
+				return errors.New("HTTP header continuation without a header")
+			}
+			f := &h.Headers[len(h.Headers)-1]
+			f.Value = strings.TrimSpace(f.Value + " " + strings.TrimSpace(string(line)))
+		default:
+			colon := bytes.IndexByte(line, ':')
+			if colon <= 0 || !httpIsToken(line[:colon]) {
+				return fmt.Errorf("invalid HTTP header line %q", line)
+			}
+			h.Headers = append(h.Headers, HTTPHeader{
+				Name:  string(line[:colon]),
+				Value: strings.TrimSpace(string(line[colon+1:])),
+			})
+		}
+	}
+}
+
+func (h *HTTP) decodeStartLine(line []byte) error {
+	parts := strings.SplitN(string(line), " ", 3)
+	if httpIsVersion(parts[0]) {
+		// HTTP/1.1 200 OK
+		if len(parts) < 2 || len(parts[1]) != 3 {
+			return fmt.Errorf("invalid HTTP status line %q", line)
+		}
+		code, err := strconv.Atoi(parts[1])
+		if err != nil || code < 100 {
+			return fmt.Errorf("invalid HTTP status line %q", line)
+		}
+		h.IsResponse = true
+		h.Version = parts[0]
+		h.StatusCode = code
+		if len(parts) == 3 {
+			h.Reason = parts[2]
+		}
+		return nil
+	}
+	// GET / HTTP/1.1
+	if len(parts) != 3 || !httpIsToken([]byte(parts[0])) || parts[1] == "" ||
+		strings.ContainsAny(parts[1], " \t") || !httpIsVersion(parts[2]) {
+		return fmt.Errorf("invalid HTTP request line %q", line)
+	}
+	h.Method = parts[0]
+	h.RequestURI = parts[1]
+	h.Version = parts[2]
+	return nil
+}
+
+// httpIsVersion tells whether s is HTTP/x.y.
+func httpIsVersion(s string) bool {
+	return len(s) == 8 && strings.HasPrefix(s, "HTTP/") &&
+		s[5] >= '0' && s[5] <= '9' && s[6] == '.' && s[7] >= '0' && s[7] <= '9'
+}
+
+// httpIsToken tells whether b is a token of RFC 7230, as methods and header
+// names are.
+func httpIsToken(b []byte) bool {
+	if len(b) == 0 {
+		return false
+	}
+	for _, c := range b {
+		switch {
+		case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c >= '0' && c <= '9':
+		case strings.IndexByte("!#$%&'*+-.^_`|~", c) >= 0:
+		default:
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,159 @@
+// Copyright 2019 The GoPacket Authors. All rights reserved.
+//
+// Use of this source code is governed by a BSD-style license
+// that can be found in the LICENSE file in the root of the source
+// tree.
+
+package layers
+
+import (
+	"net"
+	"reflect"
+	"testing"
+
+	"github.com/google/gopacket"
+)
+
+func testHTTPPacket(t *testing.T, src, dst TCPPort, payload string) []byte {
+	ip := &IPv4{
+		Version:  4,
+		TTL:      64,
+		Protocol: IPProtocolTCP,
+		SrcIP:    net.IP{10, 0, 0, 1},
+		DstIP:    net.IP{10, 0, 0, 2},
+	}
+	tcp := &TCP{SrcPort: src, DstPort: dst, Seq: 1, ACK: true, PSH: true, Window: 1024}
+	tcp.SetNetworkLayerForChecksum(ip)
+	buf := gopacket.NewSerializeBuffer()
+	err := gopacket.SerializeLayers(buf, gopacket.SerializeOptions{FixLengths: true, ComputeChecksums: true},
+		&Ethernet{
+			SrcMAC:       net.HardwareAddr{0, 1, 2, 3, 4, 5},
+			DstMAC:       net.HardwareAddr{0, 1, 2, 3, 4, 6},
+			EthernetType: EthernetTypeIPv4,
+		},
+		ip, tcp, gopacket.Payload(payload))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestHTTPRequest(t *testing.T) {
+	data := testHTTPPacket(t, 40000, 80, "GET /index.html?q=1 HTTP/1.1\r\n"+
+		"Host: example.com\r\n"+
+		"Accept-Encoding: gzip,\r\n deflate\r\n"+
+		"X-Empty:\r\n"+
+		"\r\n")
+	p := gopacket.NewPacket(data, LinkTypeEthernet, gopacket.DecodeStreamsAsDatagrams)
+	if p.ErrorLayer() != nil {
+		t.Fatal(p.ErrorLayer().Error())
+	}
+	checkLayers(p, []gopacket.LayerType{LayerTypeEthernet, LayerTypeIPv4, LayerTypeTCP, LayerTypeHTTP}, t)
+	h := p.Layer(LayerTypeHTTP).(*HTTP)
+	want := &HTTP{
+		BaseLayer:  BaseLayer{Contents: h.Contents, Payload: []byte{}},
+		Method:     "GET",
+		RequestURI: "/index.html?q=1",
+		Version:    "HTTP/1.1",
+		Headers: []HTTPHeader{
+			{"Host", "example.com"},
+			{"Accept-Encoding", "gzip, deflate"},
+			{"X-Empty", ""},
+		},
+	}
+	if !reflect.DeepEqual(h, want) {
+		t.Errorf("decoded %#v\nwant %#v", h, want)
+	}
+	if h.Header("host") != "example.com" || h.Header("Cookie") != "" {
+		t.Errorf("header lookup failed")
+	}
+	if !h.HasToken("accept-encoding", "DEFLATE") || h.HasToken("Accept-Encoding", "br") {
+		t.Errorf("token lookup failed")
+	}
+
+	// Without DecodeStreamsAsDatagrams, TCP carries a payload.
+	p = gopacket.NewPacket(data, LinkTypeEthernet, gopacket.Default)
+	checkLayers(p, []gopacket.LayerType{LayerTypeEthernet, LayerTypeIPv4, LayerTypeTCP, gopacket.LayerTypePayload}, t)
+}
+
+func TestHTTPResponse(t *testing.T) {
+	data := testHTTPPacket(t, 8080, 40000, "HTTP/1.0 404 Not Found\r\n"+
+		"Content-Length: 9\r\n"+
+		"Set-Cookie: a=1\r\n"+
+		"Set-Cookie: b=2\r\n"+
+		"\r\n"+
+		"not found")
+	p := gopacket.NewPacket(data, LinkTypeEthernet, gopacket.DecodeStreamsAsDatagrams)
+	if p.ErrorLayer() != nil {
+		t.Fatal(p.ErrorLayer().Error())
+	}
+	checkLayers(p, []gopacket.LayerType{LayerTypeEthernet, LayerTypeIPv4, LayerTypeTCP, LayerTypeHTTP, gopacket.LayerTypePayload}, t)
+	h := p.Layer(LayerTypeHTTP).(*HTTP)
+	if !h.IsResponse || h.StatusCode != 404 || h.Reason != "Not Found" || h.Version != "HTTP/1.0" {
+		t.Errorf("status line %s %d %q", h.Version, h.StatusCode, h.Reason)
+	}
+	if got := h.Values("set-cookie"); !reflect.DeepEqual(got, []string{"a=1", "b=2"}) {
+		t.Errorf("Set-Cookie %q", got)
+	}
+	if string(h.Payload()) != "not found" {
+		t.Errorf("payload %q", h.Payload())
+	}
+	if p.ApplicationLayer() != h {
+		t.Errorf("application layer %v", p.ApplicationLayer())
+	}
+}
+
+func TestHTTPLeadingEmptyLines(t *testing.T) {
+	// Stray line ends after a previous request still start a message.
+	data := testHTTPPacket(t, 40000, 80, "\r\nGET / HTTP/1.1\r\n\r\n")
+	p := gopacket.NewPacket(data, LinkTypeEthernet, gopacket.DecodeStreamsAsDatagrams)
+	if p.ErrorLayer() != nil {
+		t.Fatal(p.ErrorLayer().Error())
+	}
+	checkLayers(p, []gopacket.LayerType{LayerTypeEthernet, LayerTypeIPv4, LayerTypeTCP, LayerTypeHTTP}, t)
+	if h := p.Layer(LayerTypeHTTP).(*HTTP); h.Method != "GET" || h.RequestURI != "/" {
+		t.Errorf("request line %q %q", h.Method, h.RequestURI)
+	}
+}
+
+func TestHTTPContinuation(t *testing.T) {
+	// The middle of a body isn't HTTP.
+	data := testHTTPPacket(t, 80, 40000, "<html><body>\r\n</body></html>\r\n")
+	p := gopacket.NewPacket(data, LinkTypeEthernet, gopacket.DecodeStreamsAsDatagrams)
+	if p.ErrorLayer() != nil {
+		t.Fatal(p.ErrorLayer().Error())
+	}
+	checkLayers(p, []gopacket.LayerType{LayerTypeEthernet, LayerTypeIPv4, LayerTypeTCP, gopacket.LayerTypePayload}, t)
+}
+
+func TestHTTPDecodeFromBytes(t *testing.T) {
+	var h HTTP
+	// A head split across packets is truncated.
+	if err := h.DecodeFromBytes([]byte("HTTP/1.1 200 OK\r\nServer: x\r\n"), gopacket.NilDecodeFeedback); err != nil {
+		t.Error(err)
+	}
+	if h.StatusCode != 200 || h.Header("Server") != "x" || h.BaseLayer.Payload != nil {
+		t.Errorf("truncated head %#v", h)
+	}
+	// Status lines may lack a reason, and bare line feeds are tolerated.
+	if err := h.DecodeFromBytes([]byte("\r\nHTTP/1.1 204\n\n"), gopacket.NilDecodeFeedback); err != nil {
+		t.Error(err)
+	}
+	if h.StatusCode != 204 || h.Reason != "" || len(h.Headers) != 0 {
+		t.Errorf("head %#v", h)
+	}
+	for _, bad := range []string{
+		"GET /\r\n\r\n",
+		"GET / HTTP/1.1 extra\r\n\r\n",
+		"G(T / HTTP/1.1\r\n\r\n",
+		"HTTP/1.1 2000 OK\r\n\r\n",
+		"HTTP/1.1 200 OK\r\n continued\r\n\r\n",
+		"HTTP/1.1 200 OK\r\nNo colon\r\n\r\n",
+		"HTTP/1.1 200 OK\r\nBad name: x\r\n\r\n",
+		"HTTP/1.1 2",
+	} {
+		if err := h.DecodeFromBytes([]byte(bad), gopacket.NilDecodeFeedback); err == nil {
+			t.Errorf("no error for %q", bad)
+		}
+	}
+}
@@ -208,10 +208,11 @@ func CreateICMPv4TypeCode(typ uint8, code uint8) ICMPv4TypeCode {
 // ICMPv4 is the layer for IPv4 ICMP packet data.
 type ICMPv4 struct {
 	BaseLayer
-	TypeCode ICMPv4TypeCode
-	Checksum uint16
-	Id       uint16
-	Seq      uint16
+	TypeCode       ICMPv4TypeCode
+	Checksum       uint16
+	ChecksumStatus gopacket.ChecksumStatus
+	Id             uint16
+	Seq            uint16
 }
 
 // LayerType returns LayerTypeICMPv4.
@@ -227,10 +228,19 @@ func (i *ICMPv4) DecodeFromBytes(data []byte, df gopacket.DecodeFeedback) error
 	i.Checksum = binary.BigEndian.Uint16(data[2:4])
 	i.Id = binary.BigEndian.Uint16(data[4:6])
 	i.Seq = binary.BigEndian.Uint16(data[6:8])
+	i.ChecksumStatus = gopacket.ChecksumUnverified
 	i.BaseLayer = BaseLayer{data[:8], data[8:]}
 	return nil
 }
 
+// VerifyChecksum implements gopacket.ChecksumVerifier.  The ICMPv4 checksum
+// covers the whole ICMP message, so network is ignored.
+func (i *ICMPv4) VerifyChecksum(network gopacket.NetworkLayer) gopacket.ChecksumStatus {
+	csum := uint32(^tcpipChecksum(i.Contents, 0))
+	i.ChecksumStatus = checksumStatus(tcpipChecksum(i.Payload, csum) == 0, i.Checksum)
+	return i.ChecksumStatus
+}
+
 // SerializeTo writes the serialized form of this layer into the
 // SerializationBuffer, implementing gopacket.SerializableLayer.
 // See the docs for gopacket.SerializableLayer for more info.
@@ -174,8 +174,9 @@ func CreateICMPv6TypeCode(typ uint8, code uint8) ICMPv6TypeCode {
 // ICMPv6 is the layer for IPv6 ICMP packet data
 type ICMPv6 struct {
 	BaseLayer
-	TypeCode ICMPv6TypeCode
-	Checksum uint16
+	TypeCode       ICMPv6TypeCode
+	Checksum       uint16
+	ChecksumStatus gopacket.ChecksumStatus
 	// TypeBytes is deprecated and always nil. See the different ICMPv6 message types
 	// instead (e.g. ICMPv6TypeRouterSolicitation).
 	TypeBytes []byte
@@ -193,10 +194,22 @@ func (i *ICMPv6) DecodeFromBytes(data []byte, df gopacket.DecodeFeedback) error
 	}
 	i.TypeCode = CreateICMPv6TypeCode(data[0], data[1])
 	i.Checksum = binary.BigEndian.Uint16(data[2:4])
+	i.ChecksumStatus = gopacket.ChecksumUnverified
 	i.BaseLayer = BaseLayer{data[:4], data[4:]}
 	return nil
 }
 
+// VerifyChecksum implements gopacket.ChecksumVerifier, checking the ICMPv6
+// checksum over the pseudo-header of network, which must be an *IPv6.
+func (i *ICMPv6) VerifyChecksum(network gopacket.NetworkLayer) gopacket.ChecksumStatus {
+	if _, ok := network.(*IPv6); ok {
+		i.ChecksumStatus = verifyTCPIPChecksum(network, IPProtocolICMPv6, len(i.Contents)+len(i.Payload), i.Checksum, i.Contents, i.Payload)
+	} else {
+		i.ChecksumStatus = gopacket.ChecksumUnverified
+	}
+	return i.ChecksumStatus
+}
+
 // SerializeTo writes the serialized form of this layer into the
 // SerializationBuffer, implementing gopacket.SerializableLayer.
 // See the docs for gopacket.SerializableLayer for more info.
@@ -80,6 +80,7 @@ type IGMP struct {
 	Type                    IGMPType
 	MaxResponseTime         time.Duration
 	Checksum                uint16
+	ChecksumStatus          gopacket.ChecksumStatus
 	GroupAddress            net.IP
 	SupressRouterProcessing bool
 	RobustnessValue         uint8
@@ -105,7 +106,8 @@ type IGMPv1or2 struct {
 	Type            IGMPType      // IGMP message type
 	MaxResponseTime time.Duration // meaningful only in Membership Query messages
 	Checksum        uint16        // 16-bit checksum of entire ip payload
-	GroupAddress    net.IP        // either 0 or an IP multicast address
+	ChecksumStatus  gopacket.ChecksumStatus
+	GroupAddress    net.IP // either 0 or an IP multicast address
 	Version         uint8
 }
 
@@ -268,11 +270,20 @@ func (i *IGMPv1or2) DecodeFromBytes(data []byte, df gopacket.DecodeFeedback) err
 	i.Type = IGMPType(data[0])
 	i.MaxResponseTime = igmpTimeDecode(data[1])
 	i.Checksum = binary.BigEndian.Uint16(data[2:4])
+	i.ChecksumStatus = gopacket.ChecksumUnverified
 	i.GroupAddress = net.IP(data[4:8])
+	i.BaseLayer = BaseLayer{Contents: data}
 
 	return nil
 }
 
+// VerifyChecksum implements gopacket.ChecksumVerifier.  The IGMP checksum
+// covers the whole IGMP message, so network is ignored.
+func (i *IGMPv1or2) VerifyChecksum(network gopacket.NetworkLayer) gopacket.ChecksumStatus {
+	i.ChecksumStatus = checksumStatus(tcpipChecksum(i.Contents, 0) == 0, i.Checksum)
+	return i.ChecksumStatus
+}
+
 func (i *IGMPv1or2) NextLayerType() gopacket.LayerType {
 	return gopacket.LayerTypeZero
 }
@@ -289,6 +300,8 @@ func (i *IGMP) DecodeFromBytes(data []byte, df gopacket.DecodeFeedback) error {
 
 	// common IGMP header values between versions 1..3 of IGMP specification..
 	i.Type = IGMPType(data[0])
+	i.ChecksumStatus = gopacket.ChecksumUnverified
+	i.BaseLayer = BaseLayer{Contents: data}
 
 	switch i.Type {
 	case IGMPMembershipQuery:
@@ -302,6 +315,13 @@ func (i *IGMP) DecodeFromBytes(data []byte, df gopacket.DecodeFeedback) error {
 	return nil
 }
 
+// VerifyChecksum implements gopacket.ChecksumVerifier.  The IGMP checksum
+// covers the whole IGMP message, so network is ignored.
+func (i *IGMP) VerifyChecksum(network gopacket.NetworkLayer) gopacket.ChecksumStatus {
+	i.ChecksumStatus = checksumStatus(tcpipChecksum(i.Contents, 0) == 0, i.Checksum)
+	return i.ChecksumStatus
+}
+
 // CanDecode returns the set of layer types that this DecodingLayer can decode.
 func (i *IGMP) CanDecode() gopacket.LayerClass {
 	return LayerTypeIGMP
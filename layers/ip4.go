@@ -42,20 +42,21 @@ func (f IPv4Flag) String() string {
 // IPv4 is the header of an IP packet.
 type IPv4 struct {
 	BaseLayer
-	Version    uint8
-	IHL        uint8
-	TOS        uint8
-	Length     uint16
-	Id         uint16
-	Flags      IPv4Flag
-	FragOffset uint16
-	TTL        uint8
-	Protocol   IPProtocol
-	Checksum   uint16
-	SrcIP      net.IP
-	DstIP      net.IP
-	Options    []IPv4Option
-	Padding    []byte
+	Version        uint8
+	IHL            uint8
+	TOS            uint8
+	Length         uint16
+	Id             uint16
+	Flags          IPv4Flag
+	FragOffset     uint16
+	TTL            uint8
+	Protocol       IPProtocol
+	Checksum       uint16
+	ChecksumStatus gopacket.ChecksumStatus
+	SrcIP          net.IP
+	DstIP          net.IP
+	Options        []IPv4Option
+	Padding        []byte
 }
 
 // LayerType returns LayerTypeIPv4
@@ -178,6 +179,13 @@ func checksum(bytes []byte) uint16 {
 	return ^uint16(csum)
 }
 
+// VerifyChecksum implements gopacket.ChecksumVerifier.  The IPv4 checksum
+// covers only the IPv4 header, so network is ignored.
+func (ip *IPv4) VerifyChecksum(network gopacket.NetworkLayer) gopacket.ChecksumStatus {
+	ip.ChecksumStatus = checksumStatus(tcpipChecksum(ip.Contents, 0) == 0, ip.Checksum)
+	return ip.ChecksumStatus
+}
+
 func (ip *IPv4) flagsfrags() (ff uint16) {
 	ff |= uint16(ip.Flags) << 13
 	ff |= ip.FragOffset
@@ -202,6 +210,7 @@ func (ip *IPv4) DecodeFromBytes(data []byte, df gopacket.DecodeFeedback) error {
 	ip.TTL = data[8]
 	ip.Protocol = IPProtocol(data[9])
 	ip.Checksum = binary.BigEndian.Uint16(data[10:12])
+	ip.ChecksumStatus = gopacket.ChecksumUnverified
 	ip.SrcIP = data[12:16]
 	ip.DstIP = data[16:20]
 	ip.Options = ip.Options[:0]
@@ -50,6 +50,19 @@ func (ipv6 *IPv6) NetworkFlow() gopacket.Flow {
 	return gopacket.NewFlow(EndpointIPv6, ipv6.SrcIP, ipv6.DstIP)
 }
 
+// FinalNetworkFlow returns the network flow towards the final destination
+// of the packet given its routing header, rather than towards the current
+// hop in DstIP.  It is the same as NetworkFlow if routing is nil or doesn't
+// name a final destination.
+func (ipv6 *IPv6) FinalNetworkFlow(routing *IPv6Routing) gopacket.Flow {
+	if routing != nil {
+		if dst := routing.FinalDestination(); dst != nil {
+			return gopacket.NewFlow(EndpointIPv6, ipv6.SrcIP, dst)
+		}
+	}
+	return ipv6.NetworkFlow()
+}
+
 // Search for Jumbo Payload TLV in IPv6HopByHop and return (length, true) if found
 func getIPv6HopByHopJumboLength(hopopts *IPv6HopByHop) (uint32, bool, error) {
 	var tlv *IPv6HopByHopOption
@@ -268,6 +281,13 @@ func (ipv6 *IPv6) DecodeFromBytes(data []byte, df gopacket.DecodeFeedback) error
 	}
 
 	pEnd := int(ipv6.Length)
+	if ipv6.HopByHop != nil {
+		// Length counts the hop-by-hop header, which the payload doesn't.
+		pEnd -= ipv6.hbh.ActualLength
+	}
+	if pEnd < 0 {
+		return fmt.Errorf("IPv6 length %d shorter than its hop-by-hop header", ipv6.Length)
+	}
 	if pEnd > len(ipv6.Payload) {
 		df.SetTruncated()
 		pEnd = len(ipv6.Payload)
@@ -535,48 +555,320 @@ func (o *IPv6HopByHopOption) SetJumboLength(len uint32) {
 	o.OptionAlignment = [2]uint8{4, 2}
 }
 
+// IPv6 routing header types, see
+// https://www.iana.org/assignments/ipv6-parameters/ipv6-parameters.xhtml#ipv6-parameters-3
+const (
+	IPv6RoutingTypeSource         uint8 = 0 // deprecated by RFC 5095
+	IPv6RoutingTypeMobileIPv6     uint8 = 2 // RFC 6275
+	IPv6RoutingTypeSegmentRouting uint8 = 4 // RFC 8754
+)
+
 // IPv6Routing is the IPv6 routing extension.
 type IPv6Routing struct {
 	ipv6ExtensionBase
 	RoutingType  uint8
 	SegmentsLeft uint8
 	// This segment is supposed to be zero according to RFC2460, the second set of
-	// 4 bytes in the extension.
+	// 4 bytes in the extension.  Set only if RoutingType is 0 or 2.
 	Reserved []byte
 	// SourceRoutingIPs is the set of IPv6 addresses requested for source routing,
 	// set only if RoutingType == 0.
 	SourceRoutingIPs []net.IP
+	// HomeAddress is the home address of the mobile node, set only if
+	// RoutingType == 2.
+	HomeAddress net.IP
+	// The segment routing header fields, set only if RoutingType == 4.
+	// Segments is in header order, which is the reverse of the path:
+	// Segments[0] is the final segment and Segments[LastEntry] the first.
+	LastEntry uint8
+	Flags     uint8
+	Tag       uint16
+	Segments  []net.IP
+	TLVs      []IPv6SRHTLV
+	// Data is the type-specific data following the segments left field
+	// for routing types not decoded by this layer.
+	Data []byte
 }
 
 // LayerType returns LayerTypeIPv6Routing.
 func (i *IPv6Routing) LayerType() gopacket.LayerType { return LayerTypeIPv6Routing }
 
-func decodeIPv6Routing(data []byte, p gopacket.PacketBuilder) error {
-	base, err := decodeIPv6ExtensionBase(data, p)
+// CanDecode implementation according to gopacket.DecodingLayer
+func (i *IPv6Routing) CanDecode() gopacket.LayerClass {
+	return LayerTypeIPv6Routing
+}
+
+// NextLayerType implementation according to gopacket.DecodingLayer
+func (i *IPv6Routing) NextLayerType() gopacket.LayerType {
+	return i.NextHeader.LayerType()
+}
+
+// FinalDestination returns the address the packet is finally destined to
+// according to the routing header: the last source routing address for
+// type 0, the home address for type 2 and the first entry of the segment
+// list for type 4.  It returns nil for other types or an empty list, and for
+// types 0 and 2 once SegmentsLeft is 0: the header has been processed, and
+// the destination address is the final one.
+func (i *IPv6Routing) FinalDestination() net.IP {
+	switch i.RoutingType {
+	case IPv6RoutingTypeSource:
+		if i.SegmentsLeft > 0 && len(i.SourceRoutingIPs) > 0 {
+			return i.SourceRoutingIPs[len(i.SourceRoutingIPs)-1]
+		}
+	case IPv6RoutingTypeMobileIPv6:
+		if i.SegmentsLeft > 0 {
+			return i.HomeAddress
+		}
+	case IPv6RoutingTypeSegmentRouting:
+		if len(i.Segments) > 0 {
+			return i.Segments[0]
+		}
+	}
+	return nil
+}
+
+// TLV returns the first segment routing header TLV of the given type, or nil
+// if there is none.
+func (i *IPv6Routing) TLV(t IPv6SRHTLVType) *IPv6SRHTLV {
+	for j := range i.TLVs {
+		if i.TLVs[j].Type == t {
+			return &i.TLVs[j]
+		}
+	}
+	return nil
+}
+
+// DecodeFromBytes implementation according to gopacket.DecodingLayer
+func (i *IPv6Routing) DecodeFromBytes(data []byte, df gopacket.DecodeFeedback) error {
+	var err error
+	i.ipv6ExtensionBase, err = decodeIPv6ExtensionBase(data, df)
 	if err != nil {
 		return err
 	}
-	i := &IPv6Routing{
-		ipv6ExtensionBase: base,
-		RoutingType:       data[2],
-		SegmentsLeft:      data[3],
-		Reserved:          data[4:8],
-	}
+	i.RoutingType = data[2]
+	i.SegmentsLeft = data[3]
+	i.Reserved = nil
+	i.SourceRoutingIPs = i.SourceRoutingIPs[:0]
+	i.HomeAddress = nil
+	i.LastEntry, i.Flags, i.Tag = 0, 0, 0
+	i.Segments = i.Segments[:0]
+	i.TLVs = i.TLVs[:0]
+	i.Data = nil
 	switch i.RoutingType {
-	case 0: // Source routing
+	case IPv6RoutingTypeSource:
 		if (i.ActualLength-8)%16 != 0 {
 			return fmt.Errorf("Invalid IPv6 source routing, length of type 0 packet %d", i.ActualLength)
 		}
+		i.Reserved = data[4:8]
 		for d := i.Contents[8:]; len(d) >= 16; d = d[16:] {
 			i.SourceRoutingIPs = append(i.SourceRoutingIPs, net.IP(d[:16]))
 		}
+	case IPv6RoutingTypeMobileIPv6:
+		if i.ActualLength != 24 {
+			return fmt.Errorf("Invalid IPv6 type 2 routing header length %d", i.ActualLength)
+		}
+		i.Reserved = data[4:8]
+		i.HomeAddress = net.IP(data[8:24])
+	case IPv6RoutingTypeSegmentRouting:
+		i.LastEntry = data[4]
+		i.Flags = data[5]
+		i.Tag = binary.BigEndian.Uint16(data[6:8])
+		end := 8 + (int(i.LastEntry)+1)*16
+		if end > i.ActualLength {
+			return fmt.Errorf("Invalid IPv6 segment routing header, %d segments in %d bytes", int(i.LastEntry)+1, i.ActualLength)
+		}
+		for d := i.Contents[8:end]; len(d) >= 16; d = d[16:] {
+			i.Segments = append(i.Segments, net.IP(d[:16]))
+		}
+		for d := i.Contents[end:]; len(d) > 0; {
+			t := IPv6SRHTLV{Type: IPv6SRHTLVType(d[0])}
+			if t.Type == IPv6SRHTLVTypePad1 {
+				i.TLVs = append(i.TLVs, t)
+				d = d[1:]
+				continue
+			}
+			if len(d) < 2 || len(d) < 2+int(d[1]) {
+				return fmt.Errorf("Invalid IPv6 segment routing header, TLV %v exceeds the header", t.Type)
+			}
+			t.Length = d[1]
+			t.Value = d[2 : 2+int(t.Length)]
+			i.TLVs = append(i.TLVs, t)
+			d = d[2+int(t.Length):]
+		}
 	default:
-		return fmt.Errorf("Unknown IPv6 routing header type %d", i.RoutingType)
+		i.Data = i.Contents[4:]
 	}
+	return nil
+}
+
+func decodeIPv6Routing(data []byte, p gopacket.PacketBuilder) error {
+	i := &IPv6Routing{}
+	err := i.DecodeFromBytes(data, p)
 	p.AddLayer(i)
+	if err != nil {
+		return err
+	}
 	return p.NextDecoder(i.NextHeader)
 }
 
+// SerializeTo writes the serialized form of this layer into the
+// SerializationBuffer, implementing gopacket.SerializableLayer.
+// See the docs for gopacket.SerializableLayer for more info.
+//
+// With FixLengths, the segment routing header's last entry is set from
+// Segments and its TLVs are padded to a multiple of 8 bytes.
+func (i *IPv6Routing) SerializeTo(b gopacket.SerializeBuffer, opts gopacket.SerializeOptions) error {
+	var body []byte
+	switch i.RoutingType {
+	case IPv6RoutingTypeSource:
+		body = i.reserved()
+		for _, ip := range i.SourceRoutingIPs {
+			if err := checkIPv6Address(ip); err != nil {
+				return fmt.Errorf("invalid source routing address %v: %v", ip, err)
+			}
+			body = append(body, ip.To16()...)
+		}
+	case IPv6RoutingTypeMobileIPv6:
+		if err := checkIPv6Address(i.HomeAddress); err != nil {
+			return fmt.Errorf("invalid home address %v: %v", i.HomeAddress, err)
+		}
+		body = append(i.reserved(), i.HomeAddress...)
+	case IPv6RoutingTypeSegmentRouting:
+		if opts.FixLengths {
+			if len(i.Segments) == 0 || len(i.Segments) > 256 {
+				return fmt.Errorf("invalid number of segments %d", len(i.Segments))
+			}
+			i.LastEntry = uint8(len(i.Segments) - 1)
+		}
+		body = []byte{i.LastEntry, i.Flags, 0, 0}
+		binary.BigEndian.PutUint16(body[2:], i.Tag)
+		for _, ip := range i.Segments {
+			if err := checkIPv6Address(ip); err != nil {
+				return fmt.Errorf("invalid segment %v: %v", ip, err)
+			}
+			body = append(body, ip...)
+		}
+		for j := range i.TLVs {
+			body = i.TLVs[j].appendTo(body, opts.FixLengths)
+		}
+		if pad := (len(body) + 4) % 8; pad != 0 && opts.FixLengths {
+			body = appendIPv6SRHPadding(body, 8-pad)
+		}
+	default:
+		body = i.Data
+	}
+	length := len(body) + 4
+	if length%8 != 0 {
+		return errors.New("IPv6Routing actual length must be multiple of 8")
+	}
+	bytes, err := b.PrependBytes(length)
+	if err != nil {
+		return err
+	}
+	if opts.FixLengths {
+		i.HeaderLength = uint8((length / 8) - 1)
+	}
+	bytes[0] = uint8(i.NextHeader)
+	bytes[1] = i.HeaderLength
+	bytes[2] = i.RoutingType
+	bytes[3] = i.SegmentsLeft
+	copy(bytes[4:], body)
+	return nil
+}
+
+func (i *IPv6Routing) reserved() []byte {
+	r := make([]byte, 4)
+	copy(r, i.Reserved)
+	return r
+}
+
+// IPv6SRHTLVType is the type of a segment routing header TLV.
+type IPv6SRHTLVType uint8
+
+// Segment routing header TLV types, as defined in RFC 8754.
+const (
+	IPv6SRHTLVTypePad1 IPv6SRHTLVType = 0
+	IPv6SRHTLVTypePadN IPv6SRHTLVType = 4
+	IPv6SRHTLVTypeHMAC IPv6SRHTLVType = 5
+)
+
+func (t IPv6SRHTLVType) String() string {
+	switch t {
+	case IPv6SRHTLVTypePad1:
+		return "Pad1"
+	case IPv6SRHTLVTypePadN:
+		return "PadN"
+	case IPv6SRHTLVTypeHMAC:
+		return "HMAC"
+	default:
+		return fmt.Sprintf("Unknown(%d)", uint8(t))
+	}
+}
+
+// IPv6SRHTLV is a TLV of the segment routing header.  A Pad1 TLV is a
+// single byte without length and value.
+type IPv6SRHTLV struct {
+	Type   IPv6SRHTLVType
+	Length uint8
+	Value  []byte
+}
+
+func (t *IPv6SRHTLV) appendTo(b []byte, fixLengths bool) []byte {
+	if t.Type == IPv6SRHTLVTypePad1 {
+		return append(b, 0)
+	}
+	if fixLengths {
+		t.Length = uint8(len(t.Value))
+	}
+	b = append(b, uint8(t.Type), t.Length)
+	return append(b, t.Value...)
+}
+
+func appendIPv6SRHPadding(b []byte, n int) []byte {
+	if n == 1 {
+		return append(b, uint8(IPv6SRHTLVTypePad1))
+	}
+	b = append(b, uint8(IPv6SRHTLVTypePadN), uint8(n-2))
+	return append(b, make([]byte, n-2)...)
+}
+
+// IPv6SRHHMAC is the value of the segment routing header HMAC TLV, see
+// RFC 8754 section 2.1.2.
+type IPv6SRHHMAC struct {
+	// DisableDAVerification is the D flag, set when the destination address
+	// verification is disabled because of a reduced segment list.
+	DisableDAVerification bool
+	KeyID                 uint32
+	HMAC                  []byte
+}
+
+// DecodeFromBytes decodes the value of an HMAC TLV.
+func (h *IPv6SRHHMAC) DecodeFromBytes(data []byte) error {
+	if len(data) < 6 {
+		return fmt.Errorf("SRH HMAC TLV length %d too short", len(data))
+	}
+	h.DisableDAVerification = data[0]&0x80 != 0
+	h.KeyID = binary.BigEndian.Uint32(data[2:6])
+	h.HMAC = data[6:]
+	return nil
+}
+
+// Encode returns the value of an HMAC TLV.
+func (h *IPv6SRHHMAC) Encode() []byte {
+	b := make([]byte, 6, 6+len(h.HMAC))
+	if h.DisableDAVerification {
+		b[0] = 0x80
+	}
+	binary.BigEndian.PutUint32(b[2:], h.KeyID)
+	return append(b, h.HMAC...)
+}
+
+// TLV returns h as a segment routing header TLV.
+func (h *IPv6SRHHMAC) TLV() IPv6SRHTLV {
+	v := h.Encode()
+	return IPv6SRHTLV{Type: IPv6SRHTLVTypeHMAC, Length: uint8(len(v)), Value: v}
+}
+
 // IPv6Fragment is the IPv6 fragment header, used for packet
 // fragmentation/defragmentation.
 type IPv6Fragment struct {
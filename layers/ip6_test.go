@@ -98,6 +98,22 @@ var testPacketIPv6HopByHop0 = []byte{
 	0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x02, 0x3b, 0x00, 0x01, 0x04, 0x00, 0x00, 0x00, 0x00,
 }
 
+func TestIPv6HopByHopLength(t *testing.T) {
+	// The IPv6 length counts the hop-by-hop header.
+	p := gopacket.NewPacket(testPacketIPv6HopByHop0, LinkTypeRaw, gopacket.Default)
+	if p.ErrorLayer() != nil || p.Metadata().Truncated {
+		t.Errorf("packet %v truncated %v", p, p.Metadata().Truncated)
+	}
+
+	// Which it can't be shorter than.
+	data := append([]byte(nil), testPacketIPv6HopByHop0...)
+	data[5] = 4
+	p = gopacket.NewPacket(data, LinkTypeRaw, gopacket.Default)
+	if p.ErrorLayer() == nil {
+		t.Errorf("no error decoding a length of 4: %v", p)
+	}
+}
+
 func TestPacketIPv6HopByHop0Serialize(t *testing.T) {
 	var serialize = make([]gopacket.SerializableLayer, 0, 2)
 	var err error
@@ -428,3 +444,170 @@ func TestIPv6JumbogramDecode(t *testing.T) {
 		t.Error("No Payload layer type found in packet")
 	}
 }
+
+// testPacketIPv6SRH is an IPv6 packet from 2001:db8::1 to the active segment
+// 2001:db8::b with a segment routing header listing 2001:db8::c,
+// 2001:db8::b and 2001:db8::a, an HMAC TLV, an unknown TLV and padding.
+var testPacketIPv6SRH = []byte{
+	0x60, 0x00, 0x00, 0x00, 0x00, 0x50, 0x2b, 0x40, 0x20, 0x01, 0x0d, 0xb8, 0x00, 0x00, 0x00, 0x00,
+	0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x01, 0x20, 0x01, 0x0d, 0xb8, 0x00, 0x00, 0x00, 0x00,
+	0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x0b,
+	// next header, length, type, segments left, last entry, flags, tag
+	0x3b, 0x09, 0x04, 0x01, 0x02, 0x00, 0x00, 0x07,
+	0x20, 0x01, 0x0d, 0xb8, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x0c,
+	0x20, 0x01, 0x0d, 0xb8, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x0b,
+	0x20, 0x01, 0x0d, 0xb8, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x0a,
+	// HMAC with the D flag, key ID 1
+	0x05, 0x0e, 0x80, 0x00, 0x00, 0x00, 0x00, 0x01, 0x01, 0x02, 0x03, 0x04, 0x05, 0x06, 0x07, 0x08,
+	// unknown TLV, PadN
+	0x80, 0x01, 0xff, 0x04, 0x03, 0x00, 0x00, 0x00,
+}
+
+func TestPacketIPv6SRHSerialize(t *testing.T) {
+	ip6 := &IPv6{
+		Version:    6,
+		NextHeader: IPProtocolIPv6Routing,
+		HopLimit:   64,
+		SrcIP:      net.ParseIP("2001:db8::1"),
+		DstIP:      net.ParseIP("2001:db8::b"),
+	}
+	hmac := IPv6SRHHMAC{DisableDAVerification: true, KeyID: 1, HMAC: []byte{1, 2, 3, 4, 5, 6, 7, 8}}
+	srh := &IPv6Routing{
+		RoutingType:  IPv6RoutingTypeSegmentRouting,
+		SegmentsLeft: 1,
+		Tag:          7,
+		Segments:     []net.IP{net.ParseIP("2001:db8::c"), net.ParseIP("2001:db8::b"), net.ParseIP("2001:db8::a")},
+		TLVs:         []IPv6SRHTLV{hmac.TLV(), {Type: 0x80, Value: []byte{0xff}}},
+	}
+	srh.NextHeader = IPProtocolNoNextHeader
+	buf := gopacket.NewSerializeBuffer()
+	if err := gopacket.SerializeLayers(buf, gopacket.SerializeOptions{FixLengths: true}, ip6, srh); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := buf.Bytes(), testPacketIPv6SRH; !bytes.Equal(got, want) {
+		t.Errorf("IPv6Routing serialize failed:\ngot:\n%#v\n\nwant:\n%#v\n\n", got, want)
+	}
+	if srh.LastEntry != 2 || srh.HeaderLength != 9 {
+		t.Errorf("last entry %d, header length %d", srh.LastEntry, srh.HeaderLength)
+	}
+}
+
+func TestPacketIPv6SRHDecode(t *testing.T) {
+	p := gopacket.NewPacket(testPacketIPv6SRH, LinkTypeRaw, gopacket.Default)
+	if p.ErrorLayer() != nil {
+		t.Fatal("Failed to decode packet:", p.ErrorLayer().Error())
+	}
+	checkLayers(p, []gopacket.LayerType{LayerTypeIPv6, LayerTypeIPv6Routing}, t)
+	srh := p.Layer(LayerTypeIPv6Routing).(*IPv6Routing)
+	if srh.RoutingType != IPv6RoutingTypeSegmentRouting || srh.SegmentsLeft != 1 || srh.LastEntry != 2 || srh.Tag != 7 || len(srh.Segments) != 3 {
+		t.Fatalf("segment routing header %#v", srh)
+	}
+	if !srh.Segments[2].Equal(net.ParseIP("2001:db8::a")) || !srh.FinalDestination().Equal(net.ParseIP("2001:db8::c")) {
+		t.Errorf("segments %v", srh.Segments)
+	}
+	wantTypes := []IPv6SRHTLVType{IPv6SRHTLVTypeHMAC, 0x80, IPv6SRHTLVTypePadN}
+	if len(srh.TLVs) != len(wantTypes) {
+		t.Fatalf("TLVs %v", srh.TLVs)
+	}
+	for i, tlv := range srh.TLVs {
+		if tlv.Type != wantTypes[i] {
+			t.Errorf("TLV %d type %v, want %v", i, tlv.Type, wantTypes[i])
+		}
+	}
+	var hmac IPv6SRHHMAC
+	if err := hmac.DecodeFromBytes(srh.TLV(IPv6SRHTLVTypeHMAC).Value); err != nil {
+		t.Fatal(err)
+	}
+	if !hmac.DisableDAVerification || hmac.KeyID != 1 || !bytes.Equal(hmac.HMAC, []byte{1, 2, 3, 4, 5, 6, 7, 8}) {
+		t.Errorf("HMAC %+v", hmac)
+	}
+
+	ip6 := p.Layer(LayerTypeIPv6).(*IPv6)
+	if got := p.NetworkLayer().NetworkFlow().String(); got != "2001:db8::1->2001:db8::b" {
+		t.Errorf("network flow %s", got)
+	}
+	if got := ip6.FinalNetworkFlow(srh).String(); got != "2001:db8::1->2001:db8::c" {
+		t.Errorf("final network flow %s", got)
+	}
+	if got := ip6.FinalNetworkFlow(nil).String(); got != "2001:db8::1->2001:db8::b" {
+		t.Errorf("final network flow without routing header %s", got)
+	}
+}
+
+func TestPacketIPv6RoutingMalformed(t *testing.T) {
+	for _, c := range []struct {
+		name   string
+		header []byte
+	}{
+		// Three segments in a 24 byte header.
+		{"segments", append([]byte{0x3b, 0x02, 0x04, 0x00, 0x02, 0x00, 0x00, 0x00}, make([]byte, 16)...)},
+		// A TLV of 8 bytes of value in the last 8 bytes.
+		{"TLV", append(append([]byte{0x3b, 0x03, 0x04, 0x00, 0x00, 0x00, 0x00, 0x00}, make([]byte, 16)...), 0x80, 0x08, 0, 0, 0, 0, 0, 0)},
+		// A type 2 header must hold exactly one address.
+		{"home address", append([]byte{0x3b, 0x03, 0x02, 0x01, 0x00, 0x00, 0x00, 0x00}, make([]byte, 24)...)},
+	} {
+		var r IPv6Routing
+		if err := r.DecodeFromBytes(c.header, gopacket.NilDecodeFeedback); err == nil {
+			t.Errorf("%s: no error decoding %x", c.name, c.header)
+		}
+	}
+}
+
+func TestPacketIPv6RoutingMobileIPv6(t *testing.T) {
+	home := net.ParseIP("2001:db8::100")
+	r := &IPv6Routing{RoutingType: IPv6RoutingTypeMobileIPv6, SegmentsLeft: 1, HomeAddress: home}
+	r.NextHeader = IPProtocolNoNextHeader
+	buf := gopacket.NewSerializeBuffer()
+	if err := r.SerializeTo(buf, gopacket.SerializeOptions{FixLengths: true}); err != nil {
+		t.Fatal(err)
+	}
+	want := append([]byte{0x3b, 0x02, 0x02, 0x01, 0, 0, 0, 0}, home...)
+	if !bytes.Equal(buf.Bytes(), want) {
+		t.Errorf("got %x, want %x", buf.Bytes(), want)
+	}
+	var got IPv6Routing
+	if err := got.DecodeFromBytes(want, gopacket.NilDecodeFeedback); err != nil {
+		t.Fatal(err)
+	}
+	if !got.HomeAddress.Equal(home) || !got.FinalDestination().Equal(home) || got.SegmentsLeft != 1 {
+		t.Errorf("decoded %#v", got)
+	}
+}
+
+func TestIPv6RoutingProcessed(t *testing.T) {
+	// Once types 0 and 2 are processed, DstIP is the final destination.
+	ip6 := &IPv6{SrcIP: net.ParseIP("2001:db8::1"), DstIP: net.ParseIP("2001:db8::100")}
+	for _, r := range []*IPv6Routing{
+		{RoutingType: IPv6RoutingTypeSource, SourceRoutingIPs: []net.IP{net.ParseIP("2001:db8::100")}},
+		{RoutingType: IPv6RoutingTypeMobileIPv6, HomeAddress: net.ParseIP("2001:db8::100")},
+	} {
+		if dst := r.FinalDestination(); dst != nil {
+			t.Errorf("type %d: final destination %v", r.RoutingType, dst)
+		}
+		if got := ip6.FinalNetworkFlow(r).String(); got != "2001:db8::1->2001:db8::100" {
+			t.Errorf("type %d: final flow %s", r.RoutingType, got)
+		}
+		r.SegmentsLeft = 1
+		if dst := r.FinalDestination(); !dst.Equal(net.ParseIP("2001:db8::100")) {
+			t.Errorf("type %d: final destination %v with a segment left", r.RoutingType, dst)
+		}
+	}
+}
+
+func TestPacketIPv6RoutingUnknown(t *testing.T) {
+	data := []byte{0x3b, 0x00, 0x03, 0x00, 0x01, 0x02, 0x03, 0x04}
+	var r IPv6Routing
+	if err := r.DecodeFromBytes(data, gopacket.NilDecodeFeedback); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(r.Data, data[4:]) || r.FinalDestination() != nil {
+		t.Errorf("decoded %#v", r)
+	}
+	buf := gopacket.NewSerializeBuffer()
+	if err := r.SerializeTo(buf, gopacket.SerializeOptions{FixLengths: true}); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(buf.Bytes(), data) {
+		t.Errorf("got %x, want %x", buf.Bytes(), data)
+	}
+}
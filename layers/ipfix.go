@@ -0,0 +1,1366 @@
+// Copyright 2019 The GoPacket Authors. All rights reserved.
+//
+// Use of this source code is governed by a BSD-style license
+// that can be found in the LICENSE file in the root of the source
+// tree.
+
+package layers
+
+import (
+	"container/list"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/google/gopacket"
+)
+
+// NetFlow v9 (RFC 3954) and IPFIX (RFC 7011) messages are a header followed
+// by sets of templates or of data records.  Templates describe the fields of
+// the data records, so data records can only be decoded with their
+// templates, which exporters only send now and then.
+//
+// The NetFlowV9 and IPFIX layers are stateless: they decode the data records
+// of a message using the templates in the same message, and leave others
+// undecoded.  IPFIXDecoder keeps the templates each exporter sent in earlier
+// messages, and decodes the records that need them.
+
+// Set IDs of template sets.  Data sets have the ID of their template, which
+// is at least IPFIXMinDataSetID.
+const (
+	NetFlowV9TemplateSetID        uint16 = 0
+	NetFlowV9OptionsTemplateSetID uint16 = 1
+	IPFIXTemplateSetID            uint16 = 2
+	IPFIXOptionsTemplateSetID     uint16 = 3
+	IPFIXMinDataSetID             uint16 = 256
+)
+
+// IPFIXVariableLength is the length of variable-length fields in IPFIX
+// templates.
+const IPFIXVariableLength = 0xffff
+
+const (
+	netFlowV9HeaderLength = 20
+	ipfixHeaderLength     = 16
+	ipfixEnterpriseBit    = 0x8000
+)
+
+// IPFIXFieldSpecifier describes a field of a template: the information
+// element it holds, and its length.
+type IPFIXFieldSpecifier struct {
+	ID IPFIXElementID
+	// Length is the length of the field in bytes, or IPFIXVariableLength.
+	Length uint16
+	// EnterpriseNumber is the IANA private enterprise number defining
+	// enterprise-specific elements, and 0 for IANA elements.
+	EnterpriseNumber uint32
+}
+
+// Element returns the registered information element the field holds.
+func (f IPFIXFieldSpecifier) Element() (IPFIXElement, bool) {
+	return LookupIPFIXElement(f.EnterpriseNumber, f.ID)
+}
+
+// IPFIXTemplate is a template or options template.
+type IPFIXTemplate struct {
+	ID uint16
+	// ScopeFieldCount is the number of scope fields at the start of Fields,
+	// which is non-zero for options templates.  In NetFlow v9, the IDs of
+	// scope fields are scope types, such as 1 for the system, rather than
+	// information elements.
+	ScopeFieldCount uint16
+	Fields          []IPFIXFieldSpecifier
+}
+
+// minRecordLength returns the least length of a data record.
+func (t *IPFIXTemplate) minRecordLength() int {
+	n := 0
+	for _, f := range t.Fields {
+		if f.Length == IPFIXVariableLength {
+			n++
+		} else {
+			n += int(f.Length)
+		}
+	}
+	return n
+}
+
+// IPFIXField is a field of a data record.
+type IPFIXField struct {
+	IPFIXFieldSpecifier
+	Scope bool
+	Data  []byte
+	// Value is Data decoded according to the type of its information
+	// element: a uint64, int64, float64, bool, net.HardwareAddr, string,
+	// time.Time or net.IP.  Fields of unknown elements, or whose length
+	// doesn't suit their type, are left as []byte.
+	Value interface{}
+}
+
+// IPFIXRecord is a data record.
+type IPFIXRecord struct {
+	TemplateID uint16
+	Fields     []IPFIXField
+}
+
+// Get returns the first field holding the IANA information element id.
+func (r *IPFIXRecord) Get(id IPFIXElementID) (IPFIXField, bool) {
+	for _, f := range r.Fields {
+		if f.ID == id && f.EnterpriseNumber == 0 {
+			return f, true
+		}
+	}
+	return IPFIXField{}, false
+}
+
+// SetValue sets the Data of a field from a value of its element's type, as
+// returned in Value, or from an integer of any Go type, a float32, []byte,
+// or time.Time for any date-time type.  Integers and times are encoded in
+// the Length of the field; variable-length fields take integers in 8 bytes.
+func (f *IPFIXField) SetValue(v interface{}) error {
+	t := IPFIXTypeOctetArray
+	if e, ok := f.Element(); ok {
+		t = e.Type
+	}
+	data, err := encodeIPFIXValue(t, f.Length, v)
+	if err != nil {
+		return fmt.Errorf("IPFIX element %v: %v", f.ID, err)
+	}
+	f.Data = data
+	f.Value = decodeIPFIXValue(t, data)
+	return nil
+}
+
+func encodeIPFIXValue(t IPFIXDataType, length uint16, v interface{}) ([]byte, error) {
+	n := int(length)
+	variable := length == IPFIXVariableLength
+	var u uint64
+	switch v := v.(type) {
+	case uint8:
+		u = uint64(v)
+	case uint16:
+		u = uint64(v)
+	case uint32:
+		u = uint64(v)
+	case uint64:
+		u = v
+	case uint:
+		u = uint64(v)
+	case int8:
+		u = uint64(v)
+	case int16:
+		u = uint64(v)
+	case int32:
+		u = uint64(v)
+	case int64:
+		u = uint64(v)
+	case int:
+		u = uint64(v)
+	case float32:
+		return encodeIPFIXValue(t, length, float64(v))
+	case float64:
+		switch {
+		case n == 4:
+			b := make([]byte, 4)
+			binary.BigEndian.PutUint32(b, math.Float32bits(float32(v)))
+			return b, nil
+		case n == 8 || variable:
+			b := make([]byte, 8)
+			binary.BigEndian.PutUint64(b, math.Float64bits(v))
+			return b, nil
+		}
+		return nil, fmt.Errorf("float in %d bytes", n)
+	case bool:
+		b := []byte{2}
+		if v {
+			b[0] = 1
+		}
+		return encodeIPFIXValue(t, length, b)
+	case net.IP:
+		if ip4 := v.To4(); ip4 != nil && (n == 4 || variable && t == IPFIXTypeIPv4Address) {
+			v = ip4
+		} else {
+			v = v.To16()
+		}
+		return encodeIPFIXValue(t, length, []byte(v))
+	case net.HardwareAddr:
+		return encodeIPFIXValue(t, length, []byte(v))
+	case string:
+		return encodeIPFIXValue(t, length, []byte(v))
+	case []byte:
+		if variable {
+			return append([]byte(nil), v...), nil
+		}
+		if len(v) > n {
+			return nil, fmt.Errorf("%d bytes in %d", len(v), n)
+		}
+		// Strings and octet arrays are padded with zeros.
+		b := make([]byte, n)
+		copy(b, v)
+		return b, nil
+	case time.Time:
+		switch t {
+		case IPFIXTypeDateTimeSeconds:
+			u = uint64(v.Unix())
+		case IPFIXTypeDateTimeMilliseconds:
+			u = uint64(v.UnixNano() / 1e6)
+		case IPFIXTypeDateTimeMicroseconds, IPFIXTypeDateTimeNanoseconds:
+			frac := (uint64(v.Nanosecond())<<32 + 5e8) / 1e9
+			if t == IPFIXTypeDateTimeMicroseconds {
+				frac &^= 0x7ff
+			}
+			u = uint64(v.Unix()+ntpEpochOffset)<<32 | frac
+		default:
+			return nil, fmt.Errorf("time for type %d", t)
+		}
+	default:
+		return nil, fmt.Errorf("unsupported value type %T", v)
+	}
+	if variable {
+		n = 8
+	}
+	if n > 8 {
+		return nil, fmt.Errorf("integer in %d bytes", n)
+	}
+	if n < 8 && int64(u) >= 0 && u>>uint(8*n) != 0 {
+		return nil, fmt.Errorf("%d overflows %d bytes", u, n)
+	}
+	b := make([]byte, n)
+	for i := n - 1; i >= 0; i-- {
+		b[i] = byte(u)
+		u >>= 8
+	}
+	return b, nil
+}
+
+// IPFIXSet is a set of templates, options templates or data records, also
+// known as a FlowSet in NetFlow v9.
+type IPFIXSet struct {
+	ID uint16
+	// Templates holds the templates of template sets.  Templates without
+	// fields withdraw earlier templates.
+	Templates []IPFIXTemplate
+	// Data holds the contents of data sets, and Records holds their records
+	// if their template is known.
+	Data    []byte
+	Records []IPFIXRecord
+}
+
+// NetFlowV9 is a Cisco NetFlow version 9 export packet.
+type NetFlowV9 struct {
+	BaseLayer
+	Version uint16
+	// Count is the number of templates and records in the packet.
+	Count          uint16
+	SysUptime      uint32
+	UnixSecs       uint32
+	SequenceNumber uint32
+	SourceID       uint32
+	FlowSets       []IPFIXSet
+}
+
+// LayerType returns LayerTypeNetFlowV9.
+func (n *NetFlowV9) LayerType() gopacket.LayerType { return LayerTypeNetFlowV9 }
+
+// CanDecode returns LayerTypeNetFlowV9.
+func (n *NetFlowV9) CanDecode() gopacket.LayerClass { return LayerTypeNetFlowV9 }
+
+// NextLayerType returns gopacket.LayerTypeZero.
+func (n *NetFlowV9) NextLayerType() gopacket.LayerType { return gopacket.LayerTypeZero }
+
+// Payload returns nil.
+func (n *NetFlowV9) Payload() []byte { return nil }
+
+// DecodeFromBytes decodes the given bytes into this layer, decoding data
+// records whose templates are in the same packet.
+func (n *NetFlowV9) DecodeFromBytes(data []byte, df gopacket.DecodeFeedback) error {
+	return n.decode(data, df, nil)
+}
+
+func (n *NetFlowV9) decode(data []byte, df gopacket.DecodeFeedback, templates func(domain uint32) ipfixTemplates) error {
+	if len(data) < netFlowV9HeaderLength {
+		df.SetTruncated()
+		return errors.New("NetFlow v9 packet too short")
+	}
+	n.Version = binary.BigEndian.Uint16(data[0:2])
+	if n.Version != 9 {
+		return fmt.Errorf("NetFlow v9 packet has version %d", n.Version)
+	}
+	n.Count = binary.BigEndian.Uint16(data[2:4])
+	n.SysUptime = binary.BigEndian.Uint32(data[4:8])
+	n.UnixSecs = binary.BigEndian.Uint32(data[8:12])
+	n.SequenceNumber = binary.BigEndian.Uint32(data[12:16])
+	n.SourceID = binary.BigEndian.Uint32(data[16:20])
+	n.BaseLayer = BaseLayer{Contents: data}
+	var t ipfixTemplates
+	if templates != nil {
+		t = templates(n.SourceID)
+	}
+	var err error
+	n.FlowSets, err = decodeIPFIXSets(data[netFlowV9HeaderLength:], true, t, df)
+	return err
+}
+
+func decodeNetFlowV9(data []byte, p gopacket.PacketBuilder) error {
+	n := &NetFlowV9{}
+	if err := n.DecodeFromBytes(data, p); err != nil {
+		return err
+	}
+	p.AddLayer(n)
+	p.SetApplicationLayer(n)
+	return nil
+}
+
+// IPFIX is an IPFIX message, as defined by RFC 7011.
+type IPFIX struct {
+	BaseLayer
+	Version uint16
+	// Length is the length of the message, including the header.
+	Length uint16
+	// ExportTime is the time the message was sent, in seconds since the
+	// Unix epoch.
+	ExportTime          uint32
+	SequenceNumber      uint32
+	ObservationDomainID uint32
+	Sets                []IPFIXSet
+}
+
+// LayerType returns LayerTypeIPFIX.
+func (ix *IPFIX) LayerType() gopacket.LayerType { return LayerTypeIPFIX }
+
+// CanDecode returns LayerTypeIPFIX.
+func (ix *IPFIX) CanDecode() gopacket.LayerClass { return LayerTypeIPFIX }
+
+// NextLayerType returns gopacket.LayerTypeZero.
+func (ix *IPFIX) NextLayerType() gopacket.LayerType { return gopacket.LayerTypeZero }
+
+// Payload returns nil.
+func (ix *IPFIX) Payload() []byte { return nil }
+
+// DecodeFromBytes decodes the given bytes into this layer, decoding data
+// records whose templates are in the same message.
+func (ix *IPFIX) DecodeFromBytes(data []byte, df gopacket.DecodeFeedback) error {
+	return ix.decode(data, df, nil)
+}
+
+func (ix *IPFIX) decode(data []byte, df gopacket.DecodeFeedback, templates func(domain uint32) ipfixTemplates) error {
+	if len(data) < ipfixHeaderLength {
+		df.SetTruncated()
+		return errors.New("IPFIX message too short")
+	}
+	ix.Version = binary.BigEndian.Uint16(data[0:2])
+	if ix.Version != 10 {
+		return fmt.Errorf("IPFIX message has version %d", ix.Version)
+	}
+	ix.Length = binary.BigEndian.Uint16(data[2:4])
+	ix.ExportTime = binary.BigEndian.Uint32(data[4:8])
+	ix.SequenceNumber = binary.BigEndian.Uint32(data[8:12])
+	ix.ObservationDomainID = binary.BigEndian.Uint32(data[12:16])
+	if int(ix.Length) < ipfixHeaderLength {
+		return fmt.Errorf("invalid IPFIX message length %d", ix.Length)
+	}
+	if int(ix.Length) > len(data) {
+		df.SetTruncated()
+		return fmt.Errorf("IPFIX message of %d bytes truncated to %d", ix.Length, len(data))
+	}
+	data = data[:ix.Length]
+	ix.BaseLayer = BaseLayer{Contents: data}
+	var t ipfixTemplates
+	if templates != nil {
+		t = templates(ix.ObservationDomainID)
+	}
+	var err error
+	ix.Sets, err = decodeIPFIXSets(data[ipfixHeaderLength:], false, t, df)
+	return err
+}
+
+func decodeIPFIX(data []byte, p gopacket.PacketBuilder) error {
+	ix := &IPFIX{}
+	if err := ix.DecodeFromBytes(data, p); err != nil {
+		return err
+	}
+	p.AddLayer(ix)
+	p.SetApplicationLayer(ix)
+	return nil
+}
+
+// ipfixTemplates holds the templates of an observation domain, by ID.
+type ipfixTemplates map[uint16]*IPFIXTemplate
+
+// decodeIPFIXSets decodes sets, defining templates in and taking templates
+// from t.  If t is nil, only the templates in data are used.
+func decodeIPFIXSets(data []byte, v9 bool, t ipfixTemplates, df gopacket.DecodeFeedback) ([]IPFIXSet, error) {
+	templateID, optionsID := IPFIXTemplateSetID, IPFIXOptionsTemplateSetID
+	if v9 {
+		templateID, optionsID = NetFlowV9TemplateSetID, NetFlowV9OptionsTemplateSetID
+	}
+	var sets []IPFIXSet
+	for len(data) > 0 {
+		if len(data) < 4 {
+			df.SetTruncated()
+			return sets, errors.New("IPFIX set header truncated")
+		}
+		set := IPFIXSet{ID: binary.BigEndian.Uint16(data[0:2])}
+		length := int(binary.BigEndian.Uint16(data[2:4]))
+		if length < 4 {
+			return sets, fmt.Errorf("invalid IPFIX set length %d", length)
+		}
+		if length > len(data) {
+			df.SetTruncated()
+			return sets, fmt.Errorf("IPFIX set of %d bytes truncated to %d", length, len(data))
+		}
+		body := data[4:length]
+		data = data[length:]
+
+		var err error
+		switch {
+		case set.ID == templateID || set.ID == optionsID:
+			if set.Templates, err = decodeIPFIXTemplates(body, v9, set.ID == optionsID); err != nil {
+				return sets, err
+			}
+			if t == nil {
+				t = ipfixTemplates{}
+			}
+			for i := range set.Templates {
+				t.define(&set.Templates[i], set.ID)
+			}
+		case set.ID >= IPFIXMinDataSetID:
+			set.Data = body
+			if tmpl := t[set.ID]; tmpl != nil {
+				if set.Records, err = decodeIPFIXRecords(tmpl, body, v9); err != nil {
+					return sets, err
+				}
+			}
+		}
+		sets = append(sets, set)
+	}
+	return sets, nil
+}
+
+// define adds a template, or withdraws templates if it has no fields.
+func (t ipfixTemplates) define(tmpl *IPFIXTemplate, setID uint16) {
+	if len(tmpl.Fields) > 0 {
+		copied := *tmpl
+		t[tmpl.ID] = &copied
+		return
+	}
+	if tmpl.ID != setID {
+		delete(t, tmpl.ID)
+		return
+	}
+	// Withdraw all templates (or all options templates) of the domain.
+	options := setID == IPFIXOptionsTemplateSetID
+	for id, old := range t {
+		if (old.ScopeFieldCount > 0) == options {
+			delete(t, id)
+		}
+	}
+}
+
+func decodeIPFIXTemplates(data []byte, v9, options bool) ([]IPFIXTemplate, error) {
+	var templates []IPFIXTemplate
+	for {
+		headerLength := 4
+		if options {
+			headerLength = 6
+		}
+		if len(data) < headerLength {
+			// The rest is padding.
+			return templates, nil
+		}
+		t := IPFIXTemplate{ID: binary.BigEndian.Uint16(data[0:2])}
+		var count, scopeCount int
+		switch {
+		case v9 && options:
+			// Scope and option lengths are in bytes.
+			scopeCount = int(binary.BigEndian.Uint16(data[2:4])) / 4
+			count = scopeCount + int(binary.BigEndian.Uint16(data[4:6]))/4
+		case options:
+			count = int(binary.BigEndian.Uint16(data[2:4]))
+			scopeCount = int(binary.BigEndian.Uint16(data[4:6]))
+		default:
+			count = int(binary.BigEndian.Uint16(data[2:4]))
+		}
+		if v9 && t.ID < IPFIXMinDataSetID {
+			// NetFlow v9 pads template sets with zeros.
+			return templates, nil
+		}
+		if count == 0 && !v9 {
+			// A template withdrawal, which has no scope count.  The set
+			// ID withdraws all the templates of the set's kind.
+			setID := IPFIXTemplateSetID
+			if options {
+				setID = IPFIXOptionsTemplateSetID
+			}
+			if t.ID < IPFIXMinDataSetID && t.ID != setID {
+				return templates, fmt.Errorf("invalid IPFIX template withdrawal ID %d", t.ID)
+			}
+			templates = append(templates, t)
+			data = data[4:]
+			continue
+		}
+		if t.ID < IPFIXMinDataSetID {
+			return templates, fmt.Errorf("invalid IPFIX template ID %d", t.ID)
+		}
+		if options && (scopeCount == 0 || scopeCount > count) {
+			return templates, fmt.Errorf("IPFIX options template %d has %d scope fields of %d", t.ID, scopeCount, count)
+		}
+		t.ScopeFieldCount = uint16(scopeCount)
+		data = data[headerLength:]
+		t.Fields = make([]IPFIXFieldSpecifier, count)
+		for i := range t.Fields {
+			if len(data) < 4 {
+				return templates, fmt.Errorf("IPFIX template %d truncated", t.ID)
+			}
+			f := &t.Fields[i]
+			f.ID = IPFIXElementID(binary.BigEndian.Uint16(data[0:2]))
+			f.Length = binary.BigEndian.Uint16(data[2:4])
+			data = data[4:]
+			if !v9 && f.ID&ipfixEnterpriseBit != 0 {
+				if len(data) < 4 {
+					return templates, fmt.Errorf("IPFIX template %d truncated", t.ID)
+				}
+				f.ID &^= ipfixEnterpriseBit
+				f.EnterpriseNumber = binary.BigEndian.Uint32(data[0:4])
+				data = data[4:]
+			}
+		}
+		templates = append(templates, t)
+	}
+}
+
+func decodeIPFIXRecords(t *IPFIXTemplate, data []byte, v9 bool) ([]IPFIXRecord, error) {
+	min := t.minRecordLength()
+	if min == 0 {
+		return nil, nil
+	}
+	var records []IPFIXRecord
+	// Anything shorter than a record is padding.
+	for len(data) >= min {
+		r := IPFIXRecord{TemplateID: t.ID, Fields: make([]IPFIXField, len(t.Fields))}
+		for i, spec := range t.Fields {
+			n := int(spec.Length)
+			if spec.Length == IPFIXVariableLength {
+				if len(data) < 1 {
+					return records, fmt.Errorf("IPFIX record of template %d truncated", t.ID)
+				}
+				n, data = int(data[0]), data[1:]
+				if n == 255 {
+					if len(data) < 2 {
+						return records, fmt.Errorf("IPFIX record of template %d truncated", t.ID)
+					}
+					n, data = int(binary.BigEndian.Uint16(data[0:2])), data[2:]
+				}
+			}
+			if len(data) < n {
+				return records, fmt.Errorf("IPFIX record of template %d truncated", t.ID)
+			}
+			f := &r.Fields[i]
+			f.IPFIXFieldSpecifier = spec
+			f.Scope = i < int(t.ScopeFieldCount)
+			f.Data = data[:n]
+			if v9 && f.Scope {
+				f.Value = decodeIPFIXValue(IPFIXTypeUnsigned, f.Data)
+			} else if e, ok := spec.Element(); ok {
+				f.Value = decodeIPFIXValue(e.Type, f.Data)
+			} else {
+				f.Value = f.Data
+			}
+			data = data[n:]
+		}
+		records = append(records, r)
+	}
+	return records, nil
+}
+
+// SerializeTo writes the serialized form of this layer into the
+// SerializationBuffer, implementing gopacket.SerializableLayer.  Data sets
+// with Records are written from the Data of their fields, and others from
+// their Data.
+// See the docs for gopacket.SerializableLayer for more info.
+func (n *NetFlowV9) SerializeTo(b gopacket.SerializeBuffer, opts gopacket.SerializeOptions) error {
+	length := netFlowV9HeaderLength
+	for i := range n.FlowSets {
+		length += ipfixSetLength(&n.FlowSets[i], true)
+	}
+	bytes, err := b.PrependBytes(length)
+	if err != nil {
+		return err
+	}
+	if opts.FixLengths {
+		n.Version = 9
+		n.Count = 0
+		for _, s := range n.FlowSets {
+			n.Count += uint16(len(s.Templates) + len(s.Records))
+		}
+	}
+	binary.BigEndian.PutUint16(bytes[0:2], n.Version)
+	binary.BigEndian.PutUint16(bytes[2:4], n.Count)
+	binary.BigEndian.PutUint32(bytes[4:8], n.SysUptime)
+	binary.BigEndian.PutUint32(bytes[8:12], n.UnixSecs)
+	binary.BigEndian.PutUint32(bytes[12:16], n.SequenceNumber)
+	binary.BigEndian.PutUint32(bytes[16:20], n.SourceID)
+	return serializeIPFIXSets(bytes[netFlowV9HeaderLength:], n.FlowSets, true)
+}
+
+// SerializeTo writes the serialized form of this layer into the
+// SerializationBuffer, implementing gopacket.SerializableLayer.  Data sets
+// with Records are written from the Data of their fields, and others from
+// their Data.
+// See the docs for gopacket.SerializableLayer for more info.
+func (ix *IPFIX) SerializeTo(b gopacket.SerializeBuffer, opts gopacket.SerializeOptions) error {
+	length := ipfixHeaderLength
+	for i := range ix.Sets {
+		length += ipfixSetLength(&ix.Sets[i], false)
+	}
+	if length > 0xffff {
+		return fmt.Errorf("IPFIX message of %d bytes too long", length)
+	}
+	bytes, err := b.PrependBytes(length)
+	if err != nil {
+		return err
+	}
+	if opts.FixLengths {
+		ix.Version = 10
+		ix.Length = uint16(length)
+	}
+	binary.BigEndian.PutUint16(bytes[0:2], ix.Version)
+	binary.BigEndian.PutUint16(bytes[2:4], ix.Length)
+	binary.BigEndian.PutUint32(bytes[4:8], ix.ExportTime)
+	binary.BigEndian.PutUint32(bytes[8:12], ix.SequenceNumber)
+	binary.BigEndian.PutUint32(bytes[12:16], ix.ObservationDomainID)
+	return serializeIPFIXSets(bytes[ipfixHeaderLength:], ix.Sets, false)
+}
+
+func ipfixSetLength(s *IPFIXSet, v9 bool) int {
+	n := 4
+	switch {
+	case len(s.Templates) > 0:
+		for _, t := range s.Templates {
+			n += 4
+			if t.ScopeFieldCount > 0 || s.ID == NetFlowV9OptionsTemplateSetID || s.ID == IPFIXOptionsTemplateSetID {
+				n += 2
+			}
+			for _, f := range t.Fields {
+				n += 4
+				if f.EnterpriseNumber != 0 {
+					n += 4
+				}
+			}
+		}
+	case s.Records != nil:
+		for _, r := range s.Records {
+			for _, f := range r.Fields {
+				n += ipfixFieldLength(f.Length, len(f.Data))
+			}
+		}
+	default:
+		n += len(s.Data)
+	}
+	return n
+}
+
+// ipfixFieldLength returns the encoded length of a field of the given
+// template length holding n bytes.
+func ipfixFieldLength(length uint16, n int) int {
+	switch {
+	case length != IPFIXVariableLength:
+		return int(length)
+	case n < 255:
+		return 1 + n
+	default:
+		return 3 + n
+	}
+}
+
+func serializeIPFIXSets(bytes []byte, sets []IPFIXSet, v9 bool) error {
+	for i := range sets {
+		s := &sets[i]
+		length := ipfixSetLength(s, v9)
+		if length > 0xffff {
+			return fmt.Errorf("IPFIX set of %d bytes too long", length)
+		}
+		binary.BigEndian.PutUint16(bytes[0:2], s.ID)
+		binary.BigEndian.PutUint16(bytes[2:4], uint16(length))
+		b := bytes[4:length]
+		bytes = bytes[length:]
+		switch {
+		case len(s.Templates) > 0:
+			options := s.ID == NetFlowV9OptionsTemplateSetID || s.ID == IPFIXOptionsTemplateSetID
+			for _, t := range s.Templates {
+				binary.BigEndian.PutUint16(b[0:2], t.ID)
+				switch {
+				case v9 && options:
+					binary.BigEndian.PutUint16(b[2:4], t.ScopeFieldCount*4)
+					binary.BigEndian.PutUint16(b[4:6], uint16(len(t.Fields)-int(t.ScopeFieldCount))*4)
+					b = b[6:]
+				case options || t.ScopeFieldCount > 0:
+					binary.BigEndian.PutUint16(b[2:4], uint16(len(t.Fields)))
+					binary.BigEndian.PutUint16(b[4:6], t.ScopeFieldCount)
+					b = b[6:]
+				default:
+					binary.BigEndian.PutUint16(b[2:4], uint16(len(t.Fields)))
+					b = b[4:]
+				}
+				for _, f := range t.Fields {
+					if f.EnterpriseNumber != 0 {
+						if v9 {
+							return fmt.Errorf("NetFlow v9 has no enterprise element %d/%d", f.EnterpriseNumber, f.ID)
+						}
+						binary.BigEndian.PutUint16(b[0:2], uint16(f.ID)|ipfixEnterpriseBit)
+						binary.BigEndian.PutUint16(b[2:4], f.Length)
+						binary.BigEndian.PutUint32(b[4:8], f.EnterpriseNumber)
+						b = b[8:]
+						continue
+					}
+					binary.BigEndian.PutUint16(b[0:2], uint16(f.ID))
+					binary.BigEndian.PutUint16(b[2:4], f.Length)
+					b = b[4:]
+				}
+			}
+		case s.Records != nil:
+			for _, r := range s.Records {
+				for _, f := range r.Fields {
+					if f.Length == IPFIXVariableLength {
+						if len(f.Data) < 255 {
+							b[0] = byte(len(f.Data))
+							b = b[1:]
+						} else if len(f.Data) <= 0xffff {
+							b[0] = 255
+							binary.BigEndian.PutUint16(b[1:3], uint16(len(f.Data)))
+							b = b[3:]
+						} else {
+							return fmt.Errorf("IPFIX field of %d bytes too long", len(f.Data))
+						}
+					} else if len(f.Data) != int(f.Length) {
+						return fmt.Errorf("IPFIX field %v has %d bytes, not %d", f.ID, len(f.Data), f.Length)
+					}
+					b = b[copy(b, f.Data):]
+				}
+			}
+		default:
+			copy(b, s.Data)
+		}
+	}
+	return nil
+}
+
+// IPFIXDecoder decodes NetFlow v9 and IPFIX messages using the templates
+// received earlier from the same exporter and observation domain (source ID
+// in NetFlow v9).  It is safe for concurrent use, and its zero value is
+// ready to use.
+//
+//	decoder := layers.NewIPFIXDecoder()
+//	for packet := range packetSource.Packets() {
+//		if err := decoder.DecodePacket(packet); err != nil {
+//			...
+//		}
+//		if ix, ok := packet.Layer(layers.LayerTypeIPFIX).(*layers.IPFIX); ok {
+//			... ix.Sets have all the records whose templates are known ...
+//		}
+//	}
+type IPFIXDecoder struct {
+	// MaxDomains bounds the number of observation domains whose templates
+	// are kept; those least recently used are forgotten first.  If zero,
+	// 1024 are kept.
+	MaxDomains int
+
+	mu      sync.Mutex
+	domains map[ipfixDomain]*list.Element
+	// lru holds the *ipfixDomainTemplates of domains, most recently used
+	// first.  Its zero value is an empty list.
+	lru list.List
+}
+
+// ipfixDomain is the scope of template IDs.
+type ipfixDomain struct {
+	exporter string
+	version  uint16
+	domain   uint32
+}
+
+// ipfixDefaultMaxDomains is the MaxDomains of decoders which don't set it.
+const ipfixDefaultMaxDomains = 1024
+
+type ipfixDomainTemplates struct {
+	key       ipfixDomain
+	templates ipfixTemplates
+}
+
+// NewIPFIXDecoder returns a decoder with no templates.
+func NewIPFIXDecoder() *IPFIXDecoder {
+	return &IPFIXDecoder{}
+}
+
+func (d *IPFIXDecoder) templates(exporter net.IP, version uint16) func(uint32) ipfixTemplates {
+	return func(domain uint32) ipfixTemplates {
+		key := ipfixDomain{string(exporter.To16()), version, domain}
+		if e := d.domains[key]; e != nil {
+			d.lru.MoveToFront(e)
+			return e.Value.(*ipfixDomainTemplates).templates
+		}
+		max := d.MaxDomains
+		if max <= 0 {
+			max = ipfixDefaultMaxDomains
+		}
+		for d.lru.Len() >= max {
+			oldest := d.lru.Remove(d.lru.Back()).(*ipfixDomainTemplates)
+			delete(d.domains, oldest.key)
+		}
+		if d.domains == nil {
+			d.domains = make(map[ipfixDomain]*list.Element)
+		}
+		t := &ipfixDomainTemplates{key, ipfixTemplates{}}
+		d.domains[key] = d.lru.PushFront(t)
+		return t.templates
+	}
+}
+
+// DecodeIPFIX decodes an IPFIX message sent by exporter into ix.
+func (d *IPFIXDecoder) DecodeIPFIX(exporter net.IP, data []byte, ix *IPFIX) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return ix.decode(data, gopacket.NilDecodeFeedback, d.templates(exporter, 10))
+}
+
+// DecodeNetFlowV9 decodes a NetFlow v9 packet sent by exporter into n.
+func (d *IPFIXDecoder) DecodeNetFlowV9(exporter net.IP, data []byte, n *NetFlowV9) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return n.decode(data, gopacket.NilDecodeFeedback, d.templates(exporter, 9))
+}
+
+// DecodePacket decodes the NetFlowV9 or IPFIX layer of p again with
+// DecodeNetFlowV9 or DecodeIPFIX, taking the exporter from the source
+// address of p.  Packets without those layers are ignored.
+func (d *IPFIXDecoder) DecodePacket(p gopacket.Packet) error {
+	network := p.NetworkLayer()
+	if network == nil {
+		return nil
+	}
+	exporter := net.IP(network.NetworkFlow().Src().Raw())
+	switch l := p.ApplicationLayer().(type) {
+	case *IPFIX:
+		return d.DecodeIPFIX(exporter, l.Contents, l)
+	case *NetFlowV9:
+		return d.DecodeNetFlowV9(exporter, l.Contents, l)
+	}
+	return nil
+}
+
+// Template returns the template with the given ID which exporter defined
+// for an observation domain, or nil.
+func (d *IPFIXDecoder) Template(exporter net.IP, version uint16, domain uint32, id uint16) *IPFIXTemplate {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	e := d.domains[ipfixDomain{string(exporter.To16()), version, domain}]
+	if e == nil {
+		return nil
+	}
+	if t := e.Value.(*ipfixDomainTemplates).templates[id]; t != nil {
+		copied := *t
+		return &copied
+	}
+	return nil
+}
+
+// IPFIXDataType is the abstract data type of an information element, as
+// defined in RFC 7011 section 6.
+type IPFIXDataType uint8
+
+// The IPFIX data types.  Unsigned and signed integers of all sizes, which
+// may be sent in fewer bytes than their size, are decoded alike.
+const (
+	IPFIXTypeOctetArray IPFIXDataType = iota
+	IPFIXTypeUnsigned
+	IPFIXTypeSigned
+	IPFIXTypeFloat
+	IPFIXTypeBoolean
+	IPFIXTypeMACAddress
+	IPFIXTypeString
+	IPFIXTypeDateTimeSeconds
+	IPFIXTypeDateTimeMilliseconds
+	IPFIXTypeDateTimeMicroseconds
+	IPFIXTypeDateTimeNanoseconds
+	IPFIXTypeIPv4Address
+	IPFIXTypeIPv6Address
+)
+
+func decodeIPFIXValue(t IPFIXDataType, b []byte) interface{} {
+	switch t {
+	case IPFIXTypeUnsigned, IPFIXTypeSigned:
+		if len(b) == 0 || len(b) > 8 {
+			break
+		}
+		var u uint64
+		for _, c := range b {
+			u = u<<8 | uint64(c)
+		}
+		if t == IPFIXTypeUnsigned {
+			return u
+		}
+		shift := uint(64 - 8*len(b))
+		return int64(u<<shift) >> shift
+	case IPFIXTypeFloat:
+		switch len(b) {
+		case 4:
+			return float64(math.Float32frombits(binary.BigEndian.Uint32(b)))
+		case 8:
+			return math.Float64frombits(binary.BigEndian.Uint64(b))
+		}
+	case IPFIXTypeBoolean:
+		// RFC 7011 encodes true as 1 and false as 2.
+		if len(b) == 1 && (b[0] == 1 || b[0] == 2) {
+			return b[0] == 1
+		}
+	case IPFIXTypeMACAddress:
+		if len(b) == 6 {
+			return net.HardwareAddr(b)
+		}
+	case IPFIXTypeString:
+		return string(b)
+	case IPFIXTypeDateTimeSeconds:
+		if len(b) == 4 {
+			return time.Unix(int64(binary.BigEndian.Uint32(b)), 0)
+		}
+	case IPFIXTypeDateTimeMilliseconds:
+		if len(b) == 8 {
+			ms := int64(binary.BigEndian.Uint64(b))
+			return time.Unix(ms/1000, ms%1000*1e6)
+		}
+	case IPFIXTypeDateTimeMicroseconds, IPFIXTypeDateTimeNanoseconds:
+		// NTP timestamps.
+		if len(b) == 8 {
+			sec := int64(binary.BigEndian.Uint32(b[0:4])) - ntpEpochOffset
+			frac := uint64(binary.BigEndian.Uint32(b[4:8]))
+			if t == IPFIXTypeDateTimeMicroseconds {
+				frac &^= 0x7ff
+			}
+			nsec := int64((frac*1e9 + 1<<31) >> 32)
+			if t == IPFIXTypeDateTimeMicroseconds {
+				nsec = (nsec + 500) / 1000 * 1000
+			}
+			return time.Unix(sec, nsec)
+		}
+	case IPFIXTypeIPv4Address:
+		if len(b) == 4 {
+			return net.IP(b)
+		}
+	case IPFIXTypeIPv6Address:
+		if len(b) == 16 {
+			return net.IP(b)
+		}
+	}
+	return b
+}
+
+// ntpEpochOffset is the number of seconds from 1900, the NTP epoch, to 1970.
+const ntpEpochOffset = 2208988800
+
+// IPFIXElementID identifies an information element, either an IANA element
+// (which NetFlow v9 field types share) or one defined by an enterprise.
+type IPFIXElementID uint16
+
+// Common IANA information elements, from RFC 5102 and the IANA IPFIX
+// registry.
+const (
+	IPFIXOctetDeltaCount                  IPFIXElementID = 1
+	IPFIXPacketDeltaCount                 IPFIXElementID = 2
+	IPFIXDeltaFlowCount                   IPFIXElementID = 3
+	IPFIXProtocolIdentifier               IPFIXElementID = 4
+	IPFIXIPClassOfService                 IPFIXElementID = 5
+	IPFIXTCPControlBits                   IPFIXElementID = 6
+	IPFIXSourceTransportPort              IPFIXElementID = 7
+	IPFIXSourceIPv4Address                IPFIXElementID = 8
+	IPFIXSourceIPv4PrefixLength           IPFIXElementID = 9
+	IPFIXIngressInterface                 IPFIXElementID = 10
+	IPFIXDestinationTransportPort         IPFIXElementID = 11
+	IPFIXDestinationIPv4Address           IPFIXElementID = 12
+	IPFIXDestinationIPv4PrefixLength      IPFIXElementID = 13
+	IPFIXEgressInterface                  IPFIXElementID = 14
+	IPFIXIPNextHopIPv4Address             IPFIXElementID = 15
+	IPFIXBGPSourceASNumber                IPFIXElementID = 16
+	IPFIXBGPDestinationASNumber           IPFIXElementID = 17
+	IPFIXBGPNextHopIPv4Address            IPFIXElementID = 18
+	IPFIXPostMCastPacketDeltaCount        IPFIXElementID = 19
+	IPFIXPostMCastOctetDeltaCount         IPFIXElementID = 20
+	IPFIXFlowEndSysUpTime                 IPFIXElementID = 21
+	IPFIXFlowStartSysUpTime               IPFIXElementID = 22
+	IPFIXPostOctetDeltaCount              IPFIXElementID = 23
+	IPFIXPostPacketDeltaCount             IPFIXElementID = 24
+	IPFIXMinimumIPTotalLength             IPFIXElementID = 25
+	IPFIXMaximumIPTotalLength             IPFIXElementID = 26
+	IPFIXSourceIPv6Address                IPFIXElementID = 27
+	IPFIXDestinationIPv6Address           IPFIXElementID = 28
+	IPFIXSourceIPv6PrefixLength           IPFIXElementID = 29
+	IPFIXDestinationIPv6PrefixLength      IPFIXElementID = 30
+	IPFIXFlowLabelIPv6                    IPFIXElementID = 31
+	IPFIXICMPTypeCodeIPv4                 IPFIXElementID = 32
+	IPFIXIGMPType                         IPFIXElementID = 33
+	IPFIXSamplingInterval                 IPFIXElementID = 34
+	IPFIXSamplingAlgorithm                IPFIXElementID = 35
+	IPFIXFlowActiveTimeout                IPFIXElementID = 36
+	IPFIXFlowIdleTimeout                  IPFIXElementID = 37
+	IPFIXEngineType                       IPFIXElementID = 38
+	IPFIXEngineID                         IPFIXElementID = 39
+	IPFIXExportedOctetTotalCount          IPFIXElementID = 40
+	IPFIXExportedMessageTotalCount        IPFIXElementID = 41
+	IPFIXExportedFlowRecordTotalCount     IPFIXElementID = 42
+	IPFIXSourceIPv4Prefix                 IPFIXElementID = 44
+	IPFIXDestinationIPv4Prefix            IPFIXElementID = 45
+	IPFIXMPLSTopLabelType                 IPFIXElementID = 46
+	IPFIXMPLSTopLabelIPv4Address          IPFIXElementID = 47
+	IPFIXMinimumTTL                       IPFIXElementID = 52
+	IPFIXMaximumTTL                       IPFIXElementID = 53
+	IPFIXFragmentIdentification           IPFIXElementID = 54
+	IPFIXPostIPClassOfService             IPFIXElementID = 55
+	IPFIXSourceMacAddress                 IPFIXElementID = 56
+	IPFIXPostDestinationMacAddress        IPFIXElementID = 57
+	IPFIXVlanID                           IPFIXElementID = 58
+	IPFIXPostVlanID                       IPFIXElementID = 59
+	IPFIXIPVersion                        IPFIXElementID = 60
+	IPFIXFlowDirection                    IPFIXElementID = 61
+	IPFIXIPNextHopIPv6Address             IPFIXElementID = 62
+	IPFIXBGPNextHopIPv6Address            IPFIXElementID = 63
+	IPFIXIPv6ExtensionHeaders             IPFIXElementID = 64
+	IPFIXMPLSTopLabelStackSection         IPFIXElementID = 70
+	IPFIXDestinationMacAddress            IPFIXElementID = 80
+	IPFIXPostSourceMacAddress             IPFIXElementID = 81
+	IPFIXInterfaceName                    IPFIXElementID = 82
+	IPFIXInterfaceDescription             IPFIXElementID = 83
+	IPFIXOctetTotalCount                  IPFIXElementID = 85
+	IPFIXPacketTotalCount                 IPFIXElementID = 86
+	IPFIXFragmentOffset                   IPFIXElementID = 88
+	IPFIXForwardingStatus                 IPFIXElementID = 89
+	IPFIXApplicationDescription           IPFIXElementID = 94
+	IPFIXApplicationID                    IPFIXElementID = 95
+	IPFIXApplicationName                  IPFIXElementID = 96
+	IPFIXExporterIPv4Address              IPFIXElementID = 130
+	IPFIXExporterIPv6Address              IPFIXElementID = 131
+	IPFIXDroppedOctetDeltaCount           IPFIXElementID = 132
+	IPFIXDroppedPacketDeltaCount          IPFIXElementID = 133
+	IPFIXDroppedOctetTotalCount           IPFIXElementID = 134
+	IPFIXDroppedPacketTotalCount          IPFIXElementID = 135
+	IPFIXFlowEndReason                    IPFIXElementID = 136
+	IPFIXCommonPropertiesID               IPFIXElementID = 137
+	IPFIXObservationPointID               IPFIXElementID = 138
+	IPFIXICMPTypeCodeIPv6                 IPFIXElementID = 139
+	IPFIXMPLSTopLabelIPv6Address          IPFIXElementID = 140
+	IPFIXLineCardID                       IPFIXElementID = 141
+	IPFIXPortID                           IPFIXElementID = 142
+	IPFIXMeteringProcessID                IPFIXElementID = 143
+	IPFIXExportingProcessID               IPFIXElementID = 144
+	IPFIXTemplateID                       IPFIXElementID = 145
+	IPFIXWLANChannelID                    IPFIXElementID = 146
+	IPFIXWLANSSID                         IPFIXElementID = 147
+	IPFIXFlowID                           IPFIXElementID = 148
+	IPFIXObservationDomainID              IPFIXElementID = 149
+	IPFIXFlowStartSeconds                 IPFIXElementID = 150
+	IPFIXFlowEndSeconds                   IPFIXElementID = 151
+	IPFIXFlowStartMilliseconds            IPFIXElementID = 152
+	IPFIXFlowEndMilliseconds              IPFIXElementID = 153
+	IPFIXFlowStartMicroseconds            IPFIXElementID = 154
+	IPFIXFlowEndMicroseconds              IPFIXElementID = 155
+	IPFIXFlowStartNanoseconds             IPFIXElementID = 156
+	IPFIXFlowEndNanoseconds               IPFIXElementID = 157
+	IPFIXFlowStartDeltaMicroseconds       IPFIXElementID = 158
+	IPFIXFlowEndDeltaMicroseconds         IPFIXElementID = 159
+	IPFIXSystemInitTimeMilliseconds       IPFIXElementID = 160
+	IPFIXFlowDurationMilliseconds         IPFIXElementID = 161
+	IPFIXFlowDurationMicroseconds         IPFIXElementID = 162
+	IPFIXObservedFlowTotalCount           IPFIXElementID = 163
+	IPFIXIgnoredPacketTotalCount          IPFIXElementID = 164
+	IPFIXIgnoredOctetTotalCount           IPFIXElementID = 165
+	IPFIXNotSentFlowTotalCount            IPFIXElementID = 166
+	IPFIXNotSentPacketTotalCount          IPFIXElementID = 167
+	IPFIXNotSentOctetTotalCount           IPFIXElementID = 168
+	IPFIXDestinationIPv6Prefix            IPFIXElementID = 169
+	IPFIXSourceIPv6Prefix                 IPFIXElementID = 170
+	IPFIXICMPTypeIPv4                     IPFIXElementID = 176
+	IPFIXICMPCodeIPv4                     IPFIXElementID = 177
+	IPFIXICMPTypeIPv6                     IPFIXElementID = 178
+	IPFIXICMPCodeIPv6                     IPFIXElementID = 179
+	IPFIXUDPSourcePort                    IPFIXElementID = 180
+	IPFIXUDPDestinationPort               IPFIXElementID = 181
+	IPFIXTCPSourcePort                    IPFIXElementID = 182
+	IPFIXTCPDestinationPort               IPFIXElementID = 183
+	IPFIXTCPSequenceNumber                IPFIXElementID = 184
+	IPFIXTCPAcknowledgementNumber         IPFIXElementID = 185
+	IPFIXTCPWindowSize                    IPFIXElementID = 186
+	IPFIXTCPUrgentPointer                 IPFIXElementID = 187
+	IPFIXTCPHeaderLength                  IPFIXElementID = 188
+	IPFIXIPHeaderLength                   IPFIXElementID = 189
+	IPFIXTotalLengthIPv4                  IPFIXElementID = 190
+	IPFIXPayloadLengthIPv6                IPFIXElementID = 191
+	IPFIXIPTTL                            IPFIXElementID = 192
+	IPFIXNextHeaderIPv6                   IPFIXElementID = 193
+	IPFIXIPDiffServCodePoint              IPFIXElementID = 195
+	IPFIXIPPrecedence                     IPFIXElementID = 196
+	IPFIXFragmentFlags                    IPFIXElementID = 197
+	IPFIXOctetDeltaSumOfSquares           IPFIXElementID = 198
+	IPFIXOctetTotalSumOfSquares           IPFIXElementID = 199
+	IPFIXMPLSTopLabelTTL                  IPFIXElementID = 200
+	IPFIXIPPayloadLength                  IPFIXElementID = 204
+	IPFIXUDPMessageLength                 IPFIXElementID = 205
+	IPFIXIsMulticast                      IPFIXElementID = 206
+	IPFIXIPv4IHL                          IPFIXElementID = 207
+	IPFIXIPv4Options                      IPFIXElementID = 208
+	IPFIXTCPOptions                       IPFIXElementID = 209
+	IPFIXPaddingOctets                    IPFIXElementID = 210
+	IPFIXCollectorIPv4Address             IPFIXElementID = 211
+	IPFIXCollectorIPv6Address             IPFIXElementID = 212
+	IPFIXExportInterface                  IPFIXElementID = 213
+	IPFIXExportProtocolVersion            IPFIXElementID = 214
+	IPFIXExportTransportProtocol          IPFIXElementID = 215
+	IPFIXCollectorTransportPort           IPFIXElementID = 216
+	IPFIXExporterTransportPort            IPFIXElementID = 217
+	IPFIXTCPSynTotalCount                 IPFIXElementID = 218
+	IPFIXTCPFinTotalCount                 IPFIXElementID = 219
+	IPFIXTCPRstTotalCount                 IPFIXElementID = 220
+	IPFIXTCPPshTotalCount                 IPFIXElementID = 221
+	IPFIXTCPAckTotalCount                 IPFIXElementID = 222
+	IPFIXTCPUrgTotalCount                 IPFIXElementID = 223
+	IPFIXIPTotalLength                    IPFIXElementID = 224
+	IPFIXPostNATSourceIPv4Address         IPFIXElementID = 225
+	IPFIXPostNATDestinationIPv4Address    IPFIXElementID = 226
+	IPFIXPostNAPTSourceTransportPort      IPFIXElementID = 227
+	IPFIXPostNAPTDestinationTransportPort IPFIXElementID = 228
+	IPFIXFirewallEvent                    IPFIXElementID = 233
+	IPFIXIngressVRFID                     IPFIXElementID = 234
+	IPFIXEgressVRFID                      IPFIXElementID = 235
+	IPFIXBiflowDirection                  IPFIXElementID = 239
+	IPFIXDot1qVlanID                      IPFIXElementID = 243
+	IPFIXDot1qPriority                    IPFIXElementID = 244
+	IPFIXObservationTimeSeconds           IPFIXElementID = 322
+	IPFIXObservationTimeMilliseconds      IPFIXElementID = 323
+	IPFIXObservationTimeMicroseconds      IPFIXElementID = 324
+	IPFIXObservationTimeNanoseconds       IPFIXElementID = 325
+	IPFIXSelectorAlgorithm                IPFIXElementID = 304
+	IPFIXSamplingPacketInterval           IPFIXElementID = 305
+	IPFIXSamplingPacketSpace              IPFIXElementID = 306
+)
+
+// IPFIXElement describes an information element.
+type IPFIXElement struct {
+	EnterpriseNumber uint32
+	ID               IPFIXElementID
+	Name             string
+	Type             IPFIXDataType
+}
+
+type ipfixElementKey struct {
+	enterprise uint32
+	id         IPFIXElementID
+}
+
+var ipfixElements = map[ipfixElementKey]IPFIXElement{}
+
+// RegisterIPFIXElement registers an information element, so that fields
+// holding it are decoded with its type.  Use it for enterprise-specific
+// elements, whose fields are otherwise left as bytes.
+func RegisterIPFIXElement(e IPFIXElement) {
+	ipfixElements[ipfixElementKey{e.EnterpriseNumber, e.ID}] = e
+}
+
+// LookupIPFIXElement returns a registered information element.
+func LookupIPFIXElement(enterprise uint32, id IPFIXElementID) (IPFIXElement, bool) {
+	e, ok := ipfixElements[ipfixElementKey{enterprise, id}]
+	return e, ok
+}
+
+// String returns the name of an IANA information element.
+func (id IPFIXElementID) String() string {
+	if e, ok := LookupIPFIXElement(0, id); ok {
+		return e.Name
+	}
+	return fmt.Sprintf("IPFIXElementID(%d)", uint16(id))
+}
+
+func init() {
+	for _, e := range []struct {
+		id   IPFIXElementID
+		name string
+		typ  IPFIXDataType
+	}{
+		{IPFIXOctetDeltaCount, "octetDeltaCount", IPFIXTypeUnsigned},
+		{IPFIXPacketDeltaCount, "packetDeltaCount", IPFIXTypeUnsigned},
+		{IPFIXDeltaFlowCount, "deltaFlowCount", IPFIXTypeUnsigned},
+		{IPFIXProtocolIdentifier, "protocolIdentifier", IPFIXTypeUnsigned},
+		{IPFIXIPClassOfService, "ipClassOfService", IPFIXTypeUnsigned},
+		{IPFIXTCPControlBits, "tcpControlBits", IPFIXTypeUnsigned},
+		{IPFIXSourceTransportPort, "sourceTransportPort", IPFIXTypeUnsigned},
+		{IPFIXSourceIPv4Address, "sourceIPv4Address", IPFIXTypeIPv4Address},
+		{IPFIXSourceIPv4PrefixLength, "sourceIPv4PrefixLength", IPFIXTypeUnsigned},
+		{IPFIXIngressInterface, "ingressInterface", IPFIXTypeUnsigned},
+		{IPFIXDestinationTransportPort, "destinationTransportPort", IPFIXTypeUnsigned},
+		{IPFIXDestinationIPv4Address, "destinationIPv4Address", IPFIXTypeIPv4Address},
+		{IPFIXDestinationIPv4PrefixLength, "destinationIPv4PrefixLength", IPFIXTypeUnsigned},
+		{IPFIXEgressInterface, "egressInterface", IPFIXTypeUnsigned},
+		{IPFIXIPNextHopIPv4Address, "ipNextHopIPv4Address", IPFIXTypeIPv4Address},
+		{IPFIXBGPSourceASNumber, "bgpSourceAsNumber", IPFIXTypeUnsigned},
+		{IPFIXBGPDestinationASNumber, "bgpDestinationAsNumber", IPFIXTypeUnsigned},
+		{IPFIXBGPNextHopIPv4Address, "bgpNextHopIPv4Address", IPFIXTypeIPv4Address},
+		{IPFIXPostMCastPacketDeltaCount, "postMCastPacketDeltaCount", IPFIXTypeUnsigned},
+		{IPFIXPostMCastOctetDeltaCount, "postMCastOctetDeltaCount", IPFIXTypeUnsigned},
+		{IPFIXFlowEndSysUpTime, "flowEndSysUpTime", IPFIXTypeUnsigned},
+		{IPFIXFlowStartSysUpTime, "flowStartSysUpTime", IPFIXTypeUnsigned},
+		{IPFIXPostOctetDeltaCount, "postOctetDeltaCount", IPFIXTypeUnsigned},
+		{IPFIXPostPacketDeltaCount, "postPacketDeltaCount", IPFIXTypeUnsigned},
+		{IPFIXMinimumIPTotalLength, "minimumIpTotalLength", IPFIXTypeUnsigned},
+		{IPFIXMaximumIPTotalLength, "maximumIpTotalLength", IPFIXTypeUnsigned},
+		{IPFIXSourceIPv6Address, "sourceIPv6Address", IPFIXTypeIPv6Address},
+		{IPFIXDestinationIPv6Address, "destinationIPv6Address", IPFIXTypeIPv6Address},
+		{IPFIXSourceIPv6PrefixLength, "sourceIPv6PrefixLength", IPFIXTypeUnsigned},
+		{IPFIXDestinationIPv6PrefixLength, "destinationIPv6PrefixLength", IPFIXTypeUnsigned},
+		{IPFIXFlowLabelIPv6, "flowLabelIPv6", IPFIXTypeUnsigned},
+		{IPFIXICMPTypeCodeIPv4, "icmpTypeCodeIPv4", IPFIXTypeUnsigned},
+		{IPFIXIGMPType, "igmpType", IPFIXTypeUnsigned},
+		{IPFIXSamplingInterval, "samplingInterval", IPFIXTypeUnsigned},
+		{IPFIXSamplingAlgorithm, "samplingAlgorithm", IPFIXTypeUnsigned},
+		{IPFIXFlowActiveTimeout, "flowActiveTimeout", IPFIXTypeUnsigned},
+		{IPFIXFlowIdleTimeout, "flowIdleTimeout", IPFIXTypeUnsigned},
+		{IPFIXEngineType, "engineType", IPFIXTypeUnsigned},
+		{IPFIXEngineID, "engineId", IPFIXTypeUnsigned},
+		{IPFIXExportedOctetTotalCount, "exportedOctetTotalCount", IPFIXTypeUnsigned},
+		{IPFIXExportedMessageTotalCount, "exportedMessageTotalCount", IPFIXTypeUnsigned},
+		{IPFIXExportedFlowRecordTotalCount, "exportedFlowRecordTotalCount", IPFIXTypeUnsigned},
+		{IPFIXSourceIPv4Prefix, "sourceIPv4Prefix", IPFIXTypeIPv4Address},
+		{IPFIXDestinationIPv4Prefix, "destinationIPv4Prefix", IPFIXTypeIPv4Address},
+		{IPFIXMPLSTopLabelType, "mplsTopLabelType", IPFIXTypeUnsigned},
+		{IPFIXMPLSTopLabelIPv4Address, "mplsTopLabelIPv4Address", IPFIXTypeIPv4Address},
+		{IPFIXMinimumTTL, "minimumTTL", IPFIXTypeUnsigned},
+		{IPFIXMaximumTTL, "maximumTTL", IPFIXTypeUnsigned},
+		{IPFIXFragmentIdentification, "fragmentIdentification", IPFIXTypeUnsigned},
+		{IPFIXPostIPClassOfService, "postIpClassOfService", IPFIXTypeUnsigned},
+		{IPFIXSourceMacAddress, "sourceMacAddress", IPFIXTypeMACAddress},
+		{IPFIXPostDestinationMacAddress, "postDestinationMacAddress", IPFIXTypeMACAddress},
+		{IPFIXVlanID, "vlanId", IPFIXTypeUnsigned},
+		{IPFIXPostVlanID, "postVlanId", IPFIXTypeUnsigned},
+		{IPFIXIPVersion, "ipVersion", IPFIXTypeUnsigned},
+		{IPFIXFlowDirection, "flowDirection", IPFIXTypeUnsigned},
+		{IPFIXIPNextHopIPv6Address, "ipNextHopIPv6Address", IPFIXTypeIPv6Address},
+		{IPFIXBGPNextHopIPv6Address, "bgpNextHopIPv6Address", IPFIXTypeIPv6Address},
+		{IPFIXIPv6ExtensionHeaders, "ipv6ExtensionHeaders", IPFIXTypeUnsigned},
+		{IPFIXMPLSTopLabelStackSection, "mplsTopLabelStackSection", IPFIXTypeOctetArray},
+		{IPFIXDestinationMacAddress, "destinationMacAddress", IPFIXTypeMACAddress},
+		{IPFIXPostSourceMacAddress, "postSourceMacAddress", IPFIXTypeMACAddress},
+		{IPFIXInterfaceName, "interfaceName", IPFIXTypeString},
+		{IPFIXInterfaceDescription, "interfaceDescription", IPFIXTypeString},
+		{IPFIXOctetTotalCount, "octetTotalCount", IPFIXTypeUnsigned},
+		{IPFIXPacketTotalCount, "packetTotalCount", IPFIXTypeUnsigned},
+		{IPFIXFragmentOffset, "fragmentOffset", IPFIXTypeUnsigned},
+		{IPFIXForwardingStatus, "forwardingStatus", IPFIXTypeUnsigned},
+		{IPFIXApplicationDescription, "applicationDescription", IPFIXTypeString},
+		{IPFIXApplicationID, "applicationId", IPFIXTypeOctetArray},
+		{IPFIXApplicationName, "applicationName", IPFIXTypeString},
+		{IPFIXExporterIPv4Address, "exporterIPv4Address", IPFIXTypeIPv4Address},
+		{IPFIXExporterIPv6Address, "exporterIPv6Address", IPFIXTypeIPv6Address},
+		{IPFIXDroppedOctetDeltaCount, "droppedOctetDeltaCount", IPFIXTypeUnsigned},
+		{IPFIXDroppedPacketDeltaCount, "droppedPacketDeltaCount", IPFIXTypeUnsigned},
+		{IPFIXDroppedOctetTotalCount, "droppedOctetTotalCount", IPFIXTypeUnsigned},
+		{IPFIXDroppedPacketTotalCount, "droppedPacketTotalCount", IPFIXTypeUnsigned},
+		{IPFIXFlowEndReason, "flowEndReason", IPFIXTypeUnsigned},
+		{IPFIXCommonPropertiesID, "commonPropertiesId", IPFIXTypeUnsigned},
+		{IPFIXObservationPointID, "observationPointId", IPFIXTypeUnsigned},
+		{IPFIXICMPTypeCodeIPv6, "icmpTypeCodeIPv6", IPFIXTypeUnsigned},
+		{IPFIXMPLSTopLabelIPv6Address, "mplsTopLabelIPv6Address", IPFIXTypeIPv6Address},
+		{IPFIXLineCardID, "lineCardId", IPFIXTypeUnsigned},
+		{IPFIXPortID, "portId", IPFIXTypeUnsigned},
+		{IPFIXMeteringProcessID, "meteringProcessId", IPFIXTypeUnsigned},
+		{IPFIXExportingProcessID, "exportingProcessId", IPFIXTypeUnsigned},
+		{IPFIXTemplateID, "templateId", IPFIXTypeUnsigned},
+		{IPFIXWLANChannelID, "wlanChannelId", IPFIXTypeUnsigned},
+		{IPFIXWLANSSID, "wlanSSID", IPFIXTypeString},
+		{IPFIXFlowID, "flowId", IPFIXTypeUnsigned},
+		{IPFIXObservationDomainID, "observationDomainId", IPFIXTypeUnsigned},
+		{IPFIXFlowStartSeconds, "flowStartSeconds", IPFIXTypeDateTimeSeconds},
+		{IPFIXFlowEndSeconds, "flowEndSeconds", IPFIXTypeDateTimeSeconds},
+		{IPFIXFlowStartMilliseconds, "flowStartMilliseconds", IPFIXTypeDateTimeMilliseconds},
+		{IPFIXFlowEndMilliseconds, "flowEndMilliseconds", IPFIXTypeDateTimeMilliseconds},
+		{IPFIXFlowStartMicroseconds, "flowStartMicroseconds", IPFIXTypeDateTimeMicroseconds},
+		{IPFIXFlowEndMicroseconds, "flowEndMicroseconds", IPFIXTypeDateTimeMicroseconds},
+		{IPFIXFlowStartNanoseconds, "flowStartNanoseconds", IPFIXTypeDateTimeNanoseconds},
+		{IPFIXFlowEndNanoseconds, "flowEndNanoseconds", IPFIXTypeDateTimeNanoseconds},
+		{IPFIXFlowStartDeltaMicroseconds, "flowStartDeltaMicroseconds", IPFIXTypeUnsigned},
+		{IPFIXFlowEndDeltaMicroseconds, "flowEndDeltaMicroseconds", IPFIXTypeUnsigned},
+		{IPFIXSystemInitTimeMilliseconds, "systemInitTimeMilliseconds", IPFIXTypeDateTimeMilliseconds},
+		{IPFIXFlowDurationMilliseconds, "flowDurationMilliseconds", IPFIXTypeUnsigned},
+		{IPFIXFlowDurationMicroseconds, "flowDurationMicroseconds", IPFIXTypeUnsigned},
+		{IPFIXObservedFlowTotalCount, "observedFlowTotalCount", IPFIXTypeUnsigned},
+		{IPFIXIgnoredPacketTotalCount, "ignoredPacketTotalCount", IPFIXTypeUnsigned},
+		{IPFIXIgnoredOctetTotalCount, "ignoredOctetTotalCount", IPFIXTypeUnsigned},
+		{IPFIXNotSentFlowTotalCount, "notSentFlowTotalCount", IPFIXTypeUnsigned},
+		{IPFIXNotSentPacketTotalCount, "notSentPacketTotalCount", IPFIXTypeUnsigned},
+		{IPFIXNotSentOctetTotalCount, "notSentOctetTotalCount", IPFIXTypeUnsigned},
+		{IPFIXDestinationIPv6Prefix, "destinationIPv6Prefix", IPFIXTypeIPv6Address},
+		{IPFIXSourceIPv6Prefix, "sourceIPv6Prefix", IPFIXTypeIPv6Address},
+		{IPFIXICMPTypeIPv4, "icmpTypeIPv4", IPFIXTypeUnsigned},
+		{IPFIXICMPCodeIPv4, "icmpCodeIPv4", IPFIXTypeUnsigned},
+		{IPFIXICMPTypeIPv6, "icmpTypeIPv6", IPFIXTypeUnsigned},
+		{IPFIXICMPCodeIPv6, "icmpCodeIPv6", IPFIXTypeUnsigned},
+		{IPFIXUDPSourcePort, "udpSourcePort", IPFIXTypeUnsigned},
+		{IPFIXUDPDestinationPort, "udpDestinationPort", IPFIXTypeUnsigned},
+		{IPFIXTCPSourcePort, "tcpSourcePort", IPFIXTypeUnsigned},
+		{IPFIXTCPDestinationPort, "tcpDestinationPort", IPFIXTypeUnsigned},
+		{IPFIXTCPSequenceNumber, "tcpSequenceNumber", IPFIXTypeUnsigned},
+		{IPFIXTCPAcknowledgementNumber, "tcpAcknowledgementNumber", IPFIXTypeUnsigned},
+		{IPFIXTCPWindowSize, "tcpWindowSize", IPFIXTypeUnsigned},
+		{IPFIXTCPUrgentPointer, "tcpUrgentPointer", IPFIXTypeUnsigned},
+		{IPFIXTCPHeaderLength, "tcpHeaderLength", IPFIXTypeUnsigned},
+		{IPFIXIPHeaderLength, "ipHeaderLength", IPFIXTypeUnsigned},
+		{IPFIXTotalLengthIPv4, "totalLengthIPv4", IPFIXTypeUnsigned},
+		{IPFIXPayloadLengthIPv6, "payloadLengthIPv6", IPFIXTypeUnsigned},
+		{IPFIXIPTTL, "ipTTL", IPFIXTypeUnsigned},
+		{IPFIXNextHeaderIPv6, "nextHeaderIPv6", IPFIXTypeUnsigned},
+		{IPFIXIPDiffServCodePoint, "ipDiffServCodePoint", IPFIXTypeUnsigned},
+		{IPFIXIPPrecedence, "ipPrecedence", IPFIXTypeUnsigned},
+		{IPFIXFragmentFlags, "fragmentFlags", IPFIXTypeUnsigned},
+		{IPFIXOctetDeltaSumOfSquares, "octetDeltaSumOfSquares", IPFIXTypeUnsigned},
+		{IPFIXOctetTotalSumOfSquares, "octetTotalSumOfSquares", IPFIXTypeUnsigned},
+		{IPFIXMPLSTopLabelTTL, "mplsTopLabelTTL", IPFIXTypeUnsigned},
+		{IPFIXIPPayloadLength, "ipPayloadLength", IPFIXTypeUnsigned},
+		{IPFIXUDPMessageLength, "udpMessageLength", IPFIXTypeUnsigned},
+		{IPFIXIsMulticast, "isMulticast", IPFIXTypeUnsigned},
+		{IPFIXIPv4IHL, "ipv4IHL", IPFIXTypeUnsigned},
+		{IPFIXIPv4Options, "ipv4Options", IPFIXTypeUnsigned},
+		{IPFIXTCPOptions, "tcpOptions", IPFIXTypeUnsigned},
+		{IPFIXPaddingOctets, "paddingOctets", IPFIXTypeOctetArray},
+		{IPFIXCollectorIPv4Address, "collectorIPv4Address", IPFIXTypeIPv4Address},
+		{IPFIXCollectorIPv6Address, "collectorIPv6Address", IPFIXTypeIPv6Address},
+		{IPFIXExportInterface, "exportInterface", IPFIXTypeUnsigned},
+		{IPFIXExportProtocolVersion, "exportProtocolVersion", IPFIXTypeUnsigned},
+		{IPFIXExportTransportProtocol, "exportTransportProtocol", IPFIXTypeUnsigned},
+		{IPFIXCollectorTransportPort, "collectorTransportPort", IPFIXTypeUnsigned},
+		{IPFIXExporterTransportPort, "exporterTransportPort", IPFIXTypeUnsigned},
+		{IPFIXTCPSynTotalCount, "tcpSynTotalCount", IPFIXTypeUnsigned},
+		{IPFIXTCPFinTotalCount, "tcpFinTotalCount", IPFIXTypeUnsigned},
+		{IPFIXTCPRstTotalCount, "tcpRstTotalCount", IPFIXTypeUnsigned},
+		{IPFIXTCPPshTotalCount, "tcpPshTotalCount", IPFIXTypeUnsigned},
+		{IPFIXTCPAckTotalCount, "tcpAckTotalCount", IPFIXTypeUnsigned},
+		{IPFIXTCPUrgTotalCount, "tcpUrgTotalCount", IPFIXTypeUnsigned},
+		{IPFIXIPTotalLength, "ipTotalLength", IPFIXTypeUnsigned},
+		{IPFIXPostNATSourceIPv4Address, "postNATSourceIPv4Address", IPFIXTypeIPv4Address},
+		{IPFIXPostNATDestinationIPv4Address, "postNATDestinationIPv4Address", IPFIXTypeIPv4Address},
+		{IPFIXPostNAPTSourceTransportPort, "postNAPTSourceTransportPort", IPFIXTypeUnsigned},
+		{IPFIXPostNAPTDestinationTransportPort, "postNAPTDestinationTransportPort", IPFIXTypeUnsigned},
+		{IPFIXFirewallEvent, "firewallEvent", IPFIXTypeUnsigned},
+		{IPFIXIngressVRFID, "ingressVRFID", IPFIXTypeUnsigned},
+		{IPFIXEgressVRFID, "egressVRFID", IPFIXTypeUnsigned},
+		{IPFIXBiflowDirection, "biflowDirection", IPFIXTypeUnsigned},
+		{IPFIXDot1qVlanID, "dot1qVlanId", IPFIXTypeUnsigned},
+		{IPFIXDot1qPriority, "dot1qPriority", IPFIXTypeUnsigned},
+		{IPFIXSelectorAlgorithm, "selectorAlgorithm", IPFIXTypeUnsigned},
+		{IPFIXSamplingPacketInterval, "samplingPacketInterval", IPFIXTypeUnsigned},
+		{IPFIXSamplingPacketSpace, "samplingPacketSpace", IPFIXTypeUnsigned},
+		{IPFIXObservationTimeSeconds, "observationTimeSeconds", IPFIXTypeDateTimeSeconds},
+		{IPFIXObservationTimeMilliseconds, "observationTimeMilliseconds", IPFIXTypeDateTimeMilliseconds},
+		{IPFIXObservationTimeMicroseconds, "observationTimeMicroseconds", IPFIXTypeDateTimeMicroseconds},
+		{IPFIXObservationTimeNanoseconds, "observationTimeNanoseconds", IPFIXTypeDateTimeNanoseconds},
+	} {
+		RegisterIPFIXElement(IPFIXElement{ID: e.id, Name: e.name, Type: e.typ})
+	}
+}
@@ -0,0 +1,423 @@
+// Copyright 2019 The GoPacket Authors. All rights reserved.
+//
+// Use of this source code is governed by a BSD-style license
+// that can be found in the LICENSE file in the root of the source
+// tree.
+
+package layers
+
+import (
+	"bytes"
+	"encoding/binary"
+	"net"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/google/gopacket"
+)
+
+// ipfixMessage builds NetFlow v9 and IPFIX messages for tests.
+type ipfixMessage struct {
+	b   []byte
+	set int // start of the open set
+}
+
+func (m *ipfixMessage) u8(v uint8) *ipfixMessage { m.b = append(m.b, v); return m }
+func (m *ipfixMessage) u16(v uint16) *ipfixMessage {
+	m.b = append(m.b, byte(v>>8), byte(v))
+	return m
+}
+func (m *ipfixMessage) u32(v uint32) *ipfixMessage {
+	m.b = append(m.b, byte(v>>24), byte(v>>16), byte(v>>8), byte(v))
+	return m
+}
+func (m *ipfixMessage) u64(v uint64) *ipfixMessage  { return m.u32(uint32(v >> 32)).u32(uint32(v)) }
+func (m *ipfixMessage) raw(b ...byte) *ipfixMessage { m.b = append(m.b, b...); return m }
+
+func newIPFIXMessage(domain uint32) *ipfixMessage {
+	m := &ipfixMessage{}
+	return m.u16(10).u16(0).u32(1500000000).u32(7).u32(domain)
+}
+
+func newNetFlowV9Message(sourceID uint32) *ipfixMessage {
+	m := &ipfixMessage{}
+	return m.u16(9).u16(0).u32(60000).u32(1500000000).u32(7).u32(sourceID)
+}
+
+// open starts a set, which end closes by setting its length.
+func (m *ipfixMessage) open(id uint16) *ipfixMessage {
+	m.set = len(m.b)
+	return m.u16(id).u16(0)
+}
+
+func (m *ipfixMessage) end() *ipfixMessage {
+	binary.BigEndian.PutUint16(m.b[m.set+2:], uint16(len(m.b)-m.set))
+	return m
+}
+
+func (m *ipfixMessage) bytes() []byte {
+	if m.b[1] == 10 {
+		binary.BigEndian.PutUint16(m.b[2:], uint16(len(m.b)))
+	}
+	return m.b
+}
+
+// addFlowTemplate adds IPFIX template 256 and returns the message.
+func (m *ipfixMessage) addFlowTemplate() *ipfixMessage {
+	return m.open(IPFIXTemplateSetID).
+		u16(256).u16(7).
+		u16(uint16(IPFIXSourceIPv4Address)).u16(4).
+		u16(uint16(IPFIXDestinationIPv4Address)).u16(4).
+		u16(uint16(IPFIXOctetDeltaCount)).u16(8).
+		u16(uint16(IPFIXProtocolIdentifier)).u16(1).
+		u16(uint16(IPFIXFlowStartMilliseconds)).u16(8).
+		u16(uint16(IPFIXInterfaceName)).u16(IPFIXVariableLength).
+		u16(3 | ipfixEnterpriseBit).u16(2).u32(32473).
+		end()
+}
+
+func (m *ipfixMessage) addFlowRecords() *ipfixMessage {
+	m.open(256).
+		raw(10, 0, 0, 1).raw(10, 0, 0, 2).u64(1234).u8(6).u64(1500000000123).
+		u8(4).raw([]byte("eth0")...).u16(0xbeef).
+		raw(10, 0, 0, 3).raw(10, 0, 0, 4).u64(99).u8(17).u64(1500000000456).
+		u8(255).u16(3).raw([]byte("lo0")...).u16(0xcafe)
+	// Padding, shorter than a record.
+	return m.raw(0, 0, 0).end()
+}
+
+func checkFlowRecords(t *testing.T, set IPFIXSet) {
+	t.Helper()
+	if set.ID != 256 || len(set.Records) != 2 {
+		t.Fatalf("got set %d with %d records", set.ID, len(set.Records))
+	}
+	r := set.Records[0]
+	if f, ok := r.Get(IPFIXSourceIPv4Address); !ok || !f.Value.(net.IP).Equal(net.IP{10, 0, 0, 1}) {
+		t.Errorf("source address %v", f.Value)
+	}
+	if f, _ := r.Get(IPFIXOctetDeltaCount); f.Value != uint64(1234) {
+		t.Errorf("octets %#v", f.Value)
+	}
+	if f, _ := r.Get(IPFIXProtocolIdentifier); f.Value != uint64(6) {
+		t.Errorf("protocol %#v", f.Value)
+	}
+	if f, _ := r.Get(IPFIXFlowStartMilliseconds); !f.Value.(time.Time).Equal(time.Unix(1500000000, 123e6)) {
+		t.Errorf("start %v", f.Value)
+	}
+	if f, _ := r.Get(IPFIXInterfaceName); f.Value != "eth0" {
+		t.Errorf("interface %#v", f.Value)
+	}
+	if f, _ := set.Records[1].Get(IPFIXInterfaceName); f.Value != "lo0" {
+		t.Errorf("long-form variable-length interface %#v", f.Value)
+	}
+	e := r.Fields[6]
+	if e.EnterpriseNumber != 32473 || e.ID != 3 || !bytes.Equal(e.Value.([]byte), []byte{0xbe, 0xef}) {
+		t.Errorf("enterprise field %+v", e)
+	}
+	if _, ok := r.Get(3); ok {
+		t.Error("Get found an enterprise element")
+	}
+}
+
+func TestIPFIX(t *testing.T) {
+	data := newIPFIXMessage(42).addFlowTemplate().addFlowRecords().bytes()
+	var ix IPFIX
+	if err := ix.DecodeFromBytes(data, gopacket.NilDecodeFeedback); err != nil {
+		t.Fatal(err)
+	}
+	if ix.Length != uint16(len(data)) || ix.ObservationDomainID != 42 || ix.SequenceNumber != 7 || len(ix.Sets) != 2 {
+		t.Fatalf("got %+v", ix)
+	}
+	if tmpl := ix.Sets[0].Templates; len(tmpl) != 1 || tmpl[0].ID != 256 || len(tmpl[0].Fields) != 7 {
+		t.Fatalf("got templates %+v", tmpl)
+	}
+	checkFlowRecords(t, ix.Sets[1])
+}
+
+func TestIPFIXDecoder(t *testing.T) {
+	templates := newIPFIXMessage(42).addFlowTemplate().bytes()
+	records := newIPFIXMessage(42).addFlowRecords().bytes()
+	exporter := net.IP{192, 0, 2, 1}
+
+	// Without the template, the stateless layer can't decode the records.
+	var ix IPFIX
+	if err := ix.DecodeFromBytes(records, gopacket.NilDecodeFeedback); err != nil {
+		t.Fatal(err)
+	}
+	if len(ix.Sets) != 1 || ix.Sets[0].Records != nil || len(ix.Sets[0].Data) == 0 {
+		t.Fatalf("got sets %+v", ix.Sets)
+	}
+
+	d := NewIPFIXDecoder()
+	if err := d.DecodeIPFIX(exporter, templates, &ix); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.DecodeIPFIX(exporter, records, &ix); err != nil {
+		t.Fatal(err)
+	}
+	checkFlowRecords(t, ix.Sets[0])
+	if d.Template(exporter, 10, 42, 256) == nil {
+		t.Error("template not cached")
+	}
+
+	// Templates are scoped to the exporter and observation domain.
+	if err := d.DecodeIPFIX(net.IP{192, 0, 2, 2}, records, &ix); err != nil {
+		t.Fatal(err)
+	}
+	if ix.Sets[0].Records != nil {
+		t.Error("used the template of another exporter")
+	}
+	other := newIPFIXMessage(43).addFlowRecords().bytes()
+	if err := d.DecodeIPFIX(exporter, other, &ix); err != nil {
+		t.Fatal(err)
+	}
+	if ix.Sets[0].Records != nil {
+		t.Error("used the template of another observation domain")
+	}
+
+	withdraw := newIPFIXMessage(42).open(IPFIXTemplateSetID).u16(256).u16(0).end().bytes()
+	if err := d.DecodeIPFIX(exporter, withdraw, &ix); err != nil {
+		t.Fatal(err)
+	}
+	if d.Template(exporter, 10, 42, 256) != nil {
+		t.Error("template not withdrawn")
+	}
+}
+
+func TestIPFIXDecodePacket(t *testing.T) {
+	d := NewIPFIXDecoder()
+	for i, msg := range [][]byte{
+		newIPFIXMessage(1).addFlowTemplate().bytes(),
+		newIPFIXMessage(1).addFlowRecords().bytes(),
+	} {
+		ip := &IPv4{Version: 4, TTL: 64, Protocol: IPProtocolUDP, SrcIP: net.IP{192, 0, 2, 1}, DstIP: net.IP{192, 0, 2, 2}}
+		udp := &UDP{SrcPort: 50000, DstPort: 4739}
+		udp.SetNetworkLayerForChecksum(ip)
+		buf := gopacket.NewSerializeBuffer()
+		opts := gopacket.SerializeOptions{FixLengths: true, ComputeChecksums: true}
+		if err := gopacket.SerializeLayers(buf, opts, ip, udp, gopacket.Payload(msg)); err != nil {
+			t.Fatal(err)
+		}
+		p := gopacket.NewPacket(buf.Bytes(), LayerTypeIPv4, gopacket.Default)
+		ix, ok := p.ApplicationLayer().(*IPFIX)
+		if !ok {
+			t.Fatalf("packet %d: got layers %v", i, p.Layers())
+		}
+		if err := d.DecodePacket(p); err != nil {
+			t.Fatal(err)
+		}
+		if i == 1 {
+			checkFlowRecords(t, ix.Sets[0])
+		}
+	}
+}
+
+func TestIPFIXOptionsTemplate(t *testing.T) {
+	data := newIPFIXMessage(5).
+		open(IPFIXOptionsTemplateSetID).
+		u16(300).u16(2).u16(1).
+		u16(uint16(IPFIXObservationDomainID)).u16(4).
+		u16(uint16(IPFIXExportedMessageTotalCount)).u16(8).
+		u16(0). // padding
+		end().
+		open(300).u32(5).u64(1000).end().
+		bytes()
+	var ix IPFIX
+	if err := ix.DecodeFromBytes(data, gopacket.NilDecodeFeedback); err != nil {
+		t.Fatal(err)
+	}
+	if tmpl := ix.Sets[0].Templates; len(tmpl) != 1 || tmpl[0].ScopeFieldCount != 1 || len(tmpl[0].Fields) != 2 {
+		t.Fatalf("got options templates %+v", tmpl)
+	}
+	r := ix.Sets[1].Records
+	if len(r) != 1 || !r[0].Fields[0].Scope || r[0].Fields[1].Scope {
+		t.Fatalf("got records %+v", r)
+	}
+	if f, _ := r[0].Get(IPFIXExportedMessageTotalCount); f.Value != uint64(1000) {
+		t.Errorf("got %#v", f.Value)
+	}
+}
+
+func TestIPFIXEnterpriseElement(t *testing.T) {
+	RegisterIPFIXElement(IPFIXElement{EnterpriseNumber: 64999, ID: 7, Name: "testElement", Type: IPFIXTypeSigned})
+	data := newIPFIXMessage(0).
+		open(IPFIXTemplateSetID).u16(400).u16(1).u16(7 | ipfixEnterpriseBit).u16(2).u32(64999).end().
+		open(400).u16(0xfffe).end().
+		bytes()
+	var ix IPFIX
+	if err := ix.DecodeFromBytes(data, gopacket.NilDecodeFeedback); err != nil {
+		t.Fatal(err)
+	}
+	f := ix.Sets[1].Records[0].Fields[0]
+	if f.Value != int64(-2) {
+		t.Errorf("got %#v", f.Value)
+	}
+	if e, ok := f.Element(); !ok || e.Name != "testElement" {
+		t.Errorf("got element %+v", e)
+	}
+}
+
+func TestNetFlowV9(t *testing.T) {
+	data := newNetFlowV9Message(3).
+		open(NetFlowV9TemplateSetID).
+		u16(260).u16(3).
+		u16(uint16(IPFIXSourceIPv6Address)).u16(16).
+		u16(uint16(IPFIXIngressInterface)).u16(2).
+		u16(uint16(IPFIXPacketDeltaCount)).u16(4).
+		end().
+		open(NetFlowV9OptionsTemplateSetID).
+		u16(261).u16(4).u16(8).
+		u16(1).u16(4). // scope: system
+		u16(uint16(IPFIXSamplingInterval)).u16(4).
+		u16(uint16(IPFIXSamplingAlgorithm)).u16(1).
+		u16(0). // padding
+		end().
+		open(260).raw(net.ParseIP("2001:db8::1")...).u16(3).u32(17).end().
+		open(261).u32(0x0a000001).u32(100).u8(2).raw(0, 0, 0).end().
+		bytes()
+	var n NetFlowV9
+	if err := n.DecodeFromBytes(data, gopacket.NilDecodeFeedback); err != nil {
+		t.Fatal(err)
+	}
+	if n.SourceID != 3 || n.SysUptime != 60000 || len(n.FlowSets) != 4 {
+		t.Fatalf("got %+v", n)
+	}
+	r := n.FlowSets[2].Records
+	if len(r) != 1 {
+		t.Fatalf("got records %+v", n.FlowSets[2])
+	}
+	if f, _ := r[0].Get(IPFIXSourceIPv6Address); !f.Value.(net.IP).Equal(net.ParseIP("2001:db8::1")) {
+		t.Errorf("got source %v", f.Value)
+	}
+	if f, _ := r[0].Get(IPFIXPacketDeltaCount); f.Value != uint64(17) {
+		t.Errorf("got packets %#v", f.Value)
+	}
+	o := n.FlowSets[3].Records
+	if len(o) != 1 || !o[0].Fields[0].Scope || o[0].Fields[0].Value != uint64(0x0a000001) {
+		t.Fatalf("got options records %+v", o)
+	}
+	if f, _ := o[0].Get(IPFIXSamplingInterval); f.Value != uint64(100) {
+		t.Errorf("got sampling interval %#v", f.Value)
+	}
+
+	// Templates are scoped to the exporter and source ID.
+	d := NewIPFIXDecoder()
+	exporter := net.ParseIP("2001:db8::ff")
+	if err := d.DecodeNetFlowV9(exporter, data, &n); err != nil {
+		t.Fatal(err)
+	}
+	if d.Template(exporter, 9, 3, 261) == nil || d.Template(exporter, 10, 3, 261) != nil {
+		t.Error("NetFlow v9 template not cached by version")
+	}
+}
+
+func TestNetFlowV5(t *testing.T) {
+	n := &NetFlowV5{
+		SysUptime: 100000, UnixSecs: 1500000000, FlowSequence: 9, EngineID: 1,
+		SamplingMode: 1, SamplingInterval: 100,
+		Records: []NetFlowV5Record{{
+			SrcAddr: net.IP{10, 0, 0, 1}, DstAddr: net.IP{10, 0, 0, 2}, NextHop: net.IP{10, 0, 0, 254},
+			InputIf: 1, OutputIf: 2, Packets: 10, Octets: 1000, First: 90000, Last: 99000,
+			SrcPort: 1234, DstPort: 80, TCPFlags: 0x1b, Protocol: IPProtocolTCP, SrcAS: 64512, DstMask: 24,
+		}},
+	}
+	ip := &IPv4{Version: 4, TTL: 64, Protocol: IPProtocolUDP, SrcIP: net.IP{192, 0, 2, 1}, DstIP: net.IP{192, 0, 2, 2}}
+	udp := &UDP{SrcPort: 50000, DstPort: 2055}
+	udp.SetNetworkLayerForChecksum(ip)
+	buf := gopacket.NewSerializeBuffer()
+	opts := gopacket.SerializeOptions{FixLengths: true, ComputeChecksums: true}
+	if err := gopacket.SerializeLayers(buf, opts, ip, udp, n); err != nil {
+		t.Fatal(err)
+	}
+	p := gopacket.NewPacket(buf.Bytes(), LayerTypeIPv4, gopacket.Default)
+	got, ok := p.Layer(LayerTypeNetFlowV5).(*NetFlowV5)
+	if !ok {
+		t.Fatalf("got layers %v", p.Layers())
+	}
+	if got.Count != 1 || got.SamplingMode != 1 || got.SamplingInterval != 100 || got.FlowSequence != 9 {
+		t.Errorf("got header %+v", got)
+	}
+	r := got.Records[0]
+	want := n.Records[0]
+	if !r.SrcAddr.Equal(want.SrcAddr) || !r.NextHop.Equal(want.NextHop) {
+		t.Errorf("got addresses %v %v", r.SrcAddr, r.NextHop)
+	}
+	r.SrcAddr, r.DstAddr, r.NextHop = want.SrcAddr, want.DstAddr, want.NextHop
+	if !reflect.DeepEqual(r, want) {
+		t.Errorf("got record %+v, want %+v", r, want)
+	}
+	if start := got.UptimeTime(r.First); !start.Equal(time.Unix(1500000000-10, 0)) {
+		t.Errorf("got start %v", start)
+	}
+}
+
+func TestIPFIXTruncated(t *testing.T) {
+	data := newIPFIXMessage(1).addFlowTemplate().addFlowRecords().bytes()
+	for _, n := range []int{10, 40, len(data) - 5} {
+		var ix IPFIX
+		if err := ix.DecodeFromBytes(data[:n], gopacket.NilDecodeFeedback); err == nil {
+			t.Errorf("no error decoding %d of %d bytes", n, len(data))
+		}
+	}
+	// A set length shorter than the data it holds.
+	bad := append([]byte(nil), data...)
+	binary.BigEndian.PutUint16(bad[ipfixHeaderLength+2:], 2)
+	var ix IPFIX
+	if err := ix.DecodeFromBytes(bad, gopacket.NilDecodeFeedback); err == nil {
+		t.Error("no error for an invalid set length")
+	}
+}
+
+func TestIPFIXInvalidTemplateIDs(t *testing.T) {
+	for _, data := range [][]byte{
+		// A template can't have a set ID.
+		newIPFIXMessage(1).open(IPFIXTemplateSetID).u16(IPFIXOptionsTemplateSetID).u16(1).u16(uint16(IPFIXOctetDeltaCount)).u16(8).end().bytes(),
+		newIPFIXMessage(1).open(IPFIXTemplateSetID).u16(IPFIXTemplateSetID).u16(1).u16(uint16(IPFIXOctetDeltaCount)).u16(8).end().bytes(),
+		// Only the ID of the set withdraws all its templates.
+		newIPFIXMessage(1).open(IPFIXTemplateSetID).u16(IPFIXOptionsTemplateSetID).u16(0).end().bytes(),
+		newIPFIXMessage(1).open(IPFIXTemplateSetID).u16(5).u16(0).end().bytes(),
+	} {
+		var ix IPFIX
+		if err := ix.DecodeFromBytes(data, gopacket.NilDecodeFeedback); err == nil {
+			t.Errorf("no error decoding % x", data)
+		}
+	}
+	withdraw := newIPFIXMessage(1).open(IPFIXTemplateSetID).u16(IPFIXTemplateSetID).u16(0).end().bytes()
+	var ix IPFIX
+	if err := ix.DecodeFromBytes(withdraw, gopacket.NilDecodeFeedback); err != nil {
+		t.Errorf("withdrawal of all templates: %v", err)
+	}
+}
+
+func TestIPFIXDecoderMaxDomains(t *testing.T) {
+	exporter := net.IP{192, 0, 2, 1}
+	d := &IPFIXDecoder{MaxDomains: 2}
+	var ix IPFIX
+	for _, domain := range []uint32{1, 2, 1, 3} {
+		if err := d.DecodeIPFIX(exporter, newIPFIXMessage(domain).addFlowTemplate().bytes(), &ix); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// Domain 2 was the least recently used.
+	for domain, want := range map[uint32]bool{1: true, 2: false, 3: true} {
+		if got := d.Template(exporter, 10, domain, 256) != nil; got != want {
+			t.Errorf("domain %d: template kept %v, want %v", domain, got, want)
+		}
+	}
+}
+
+func TestIPFIXDecoderZeroValue(t *testing.T) {
+	var d IPFIXDecoder
+	exporter := net.IP{192, 0, 2, 1}
+	if tmpl := d.Template(exporter, 10, 1, 256); tmpl != nil {
+		t.Errorf("template %+v before any message", tmpl)
+	}
+	var ix IPFIX
+	if err := d.DecodeIPFIX(exporter, newIPFIXMessage(1).addFlowTemplate().bytes(), &ix); err != nil {
+		t.Fatal(err)
+	}
+	if d.Template(exporter, 10, 1, 256) == nil {
+		t.Error("template not kept")
+	}
+}
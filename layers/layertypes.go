@@ -144,6 +144,15 @@ var (
 	LayerTypeTLS                          = gopacket.RegisterLayerType(140, gopacket.LayerTypeMetadata{Name: "TLS", Decoder: gopacket.DecodeFunc(decodeTLS)})
 	LayerTypeModbusTCP                    = gopacket.RegisterLayerType(141, gopacket.LayerTypeMetadata{Name: "ModbusTCP", Decoder: gopacket.DecodeFunc(decodeModbusTCP)})
 	LayerTypeRMCP                         = gopacket.RegisterLayerType(142, gopacket.LayerTypeMetadata{Name: "RMCP", Decoder: gopacket.DecodeFunc(decodeRMCP)})
+	LayerTypeNetFlowV5                    = gopacket.RegisterLayerType(143, gopacket.LayerTypeMetadata{Name: "NetFlowV5", Decoder: gopacket.DecodeFunc(decodeNetFlow)})
+	LayerTypeNetFlowV9                    = gopacket.RegisterLayerType(144, gopacket.LayerTypeMetadata{Name: "NetFlowV9", Decoder: gopacket.DecodeFunc(decodeNetFlow)})
+	LayerTypeIPFIX                        = gopacket.RegisterLayerType(145, gopacket.LayerTypeMetadata{Name: "IPFIX", Decoder: gopacket.DecodeFunc(decodeNetFlow)})
+	LayerTypeHTTP                         = gopacket.RegisterLayerType(146, gopacket.LayerTypeMetadata{Name: "HTTP", Decoder: gopacket.DecodeFunc(decodeHTTP)})
+	LayerTypeQUIC                         = gopacket.RegisterLayerType(147, gopacket.LayerTypeMetadata{Name: "QUIC", Decoder: gopacket.DecodeFunc(decodeQUIC)})
+	LayerTypeMDNS                         = gopacket.RegisterLayerType(148, gopacket.LayerTypeMetadata{Name: "MDNS", Decoder: gopacket.DecodeFunc(decodeMDNS)})
+	LayerTypeNBNS                         = gopacket.RegisterLayerType(149, gopacket.LayerTypeMetadata{Name: "NBNS", Decoder: gopacket.DecodeFunc(decodeNBNS)})
+	LayerTypeNBDS                         = gopacket.RegisterLayerType(150, gopacket.LayerTypeMetadata{Name: "NBDS", Decoder: gopacket.DecodeFunc(decodeNBDS)})
+	LayerTypeBGP                          = gopacket.RegisterLayerType(151, gopacket.LayerTypeMetadata{Name: "BGP", Decoder: gopacket.DecodeFunc(decodeBGP)})
 )
 
 var (
@@ -0,0 +1,211 @@
+// Copyright 2019 The GoPacket Authors. All rights reserved.
+//
+// Use of this source code is governed by a BSD-style license
+// that can be found in the LICENSE file in the root of the source
+// tree.
+
+package layers
+
+import (
+	"bytes"
+	"net"
+	"strings"
+
+	"github.com/google/gopacket"
+)
+
+// mdnsClassTopBit is the bit of mDNS classes which is the unicast-response
+// bit of questions, and the cache-flush bit of records.
+const mdnsClassTopBit = 0x8000
+
+func mdnsClassBit(set bool) uint16 {
+	if set {
+		return mdnsClassTopBit
+	}
+	return 0
+}
+
+// splitMDNSClasses splits the top bit off the classes of the questions and
+// records, but those of OPT records, which are UDP payload sizes.
+func (d *DNS) splitMDNSClasses() {
+	for i := range d.Questions {
+		q := &d.Questions[i]
+		q.UnicastResponse = q.Class&mdnsClassTopBit != 0
+		q.Class &^= mdnsClassTopBit
+	}
+	for _, records := range [][]DNSResourceRecord{d.Answers, d.Authorities, d.Additionals} {
+		for i := range records {
+			rr := &records[i]
+			if rr.Type == DNSTypeOPT {
+				continue
+			}
+			rr.CacheFlush = rr.Class&mdnsClassTopBit != 0
+			rr.Class &^= mdnsClassTopBit
+		}
+	}
+}
+
+// MDNS decodes mDNS messages, of LayerTypeMDNS, with a DecodingLayerParser:
+// it is a DNS layer whose MDNS field DecodeFromBytes sets.
+type MDNS struct {
+	DNS
+}
+
+// CanDecode implements gopacket.DecodingLayer.
+func (m *MDNS) CanDecode() gopacket.LayerClass {
+	return LayerTypeMDNS
+}
+
+// DecodeFromBytes decodes the slice into the DNS layer, with MDNS set.
+func (m *MDNS) DecodeFromBytes(data []byte, df gopacket.DecodeFeedback) error {
+	return m.decode(data, df, true)
+}
+
+// decodeMDNS decodes an mDNS message into a DNS layer with MDNS set, which
+// is of LayerTypeDNS like other DNS messages.
+func decodeMDNS(data []byte, p gopacket.PacketBuilder) error {
+	d := &DNS{}
+	err := d.decode(data, p, true)
+	if err != nil {
+		return err
+	}
+	p.AddLayer(d)
+	p.SetApplicationLayer(d)
+	return nil
+}
+
+// DNSSDServicesName is the name, in a domain, of the PTR records which
+// enumerate the service types of the domain, see RFC 6763 section 9.
+const DNSSDServicesName = "_services._dns-sd._udp"
+
+// SplitDNSSDName splits the name of a service instance of DNS-Based
+// Service Discovery, <Instance>.<Service>.<Domain>, such as "Printer
+// 2._ipp._tcp.local", into its parts.  The service is the last pair of
+// labels of an application and a protocol, _tcp or _udp.  The instance is
+// empty for names of service types and subtypes, such as "_ipp._tcp.local"
+// and "_color._sub._ipp._tcp.local", and for DNSSDServicesName.  It returns
+// false if the name has no service.
+func SplitDNSSDName(name []byte) (instance, service, domain string, ok bool) {
+	s := string(name)
+	lower := strings.ToLower(s)
+	proto := strings.LastIndex(lower, "._tcp")
+	if i := strings.LastIndex(lower, "._udp"); i > proto {
+		proto = i
+	}
+	end := proto + len("._tcp")
+	if proto < 0 || (end < len(s) && s[end] != '.') {
+		return "", "", "", false
+	}
+	app := strings.LastIndexByte(s[:proto], '.') + 1
+	if app == proto || s[app] != '_' {
+		return "", "", "", false
+	}
+	if app > 0 {
+		instance = s[:app-1]
+		if strings.HasSuffix(strings.ToLower(instance), "._sub") || strings.EqualFold(s[:end], DNSSDServicesName) {
+			instance = ""
+		}
+	}
+	if end < len(s) {
+		domain = s[end+1:]
+	}
+	return instance, s[app:end], domain, true
+}
+
+// DNSSDAttributes returns the key/value attributes of the strings of a
+// DNS-SD TXT record, see RFC 6763 section 6.  Keys are lowercased, and
+// the values of keys without one, which are boolean attributes, are nil.
+// Only the first attribute of a key is kept.
+func DNSSDAttributes(txts [][]byte) map[string][]byte {
+	attrs := make(map[string][]byte)
+	for _, txt := range txts {
+		key, value := txt, []byte(nil)
+		if i := bytes.IndexByte(txt, '='); i >= 0 {
+			key, value = txt[:i], txt[i+1:]
+		}
+		if len(key) == 0 {
+			continue
+		}
+		k := strings.ToLower(string(key))
+		if _, ok := attrs[k]; !ok {
+			attrs[k] = value
+		}
+	}
+	return attrs
+}
+
+// DNSSDInstance is a service instance of DNS-Based Service Discovery, see
+// RFC 6763, gathered from the records of a message.
+type DNSSDInstance struct {
+	// Name is the full name of the instance, such as "Printer
+	// 2._ipp._tcp.local", of which Instance, Service and Domain are the
+	// parts.
+	Name                      []byte `gopacket:"text"`
+	Instance, Service, Domain string
+
+	// Target, Port, Priority and Weight are from the SRV record of the
+	// instance, if the message has one.
+	Target           []byte `gopacket:"text"`
+	Port             uint16
+	Priority, Weight uint16
+	// TXTs are the strings of the TXT record of the instance, if the
+	// message has one.  See DNSSDAttributes.
+	TXTs [][]byte `gopacket:"text"`
+	// IPs are the addresses of the A and AAAA records of the target.
+	IPs []net.IP
+}
+
+// DNSSDInstances returns the service instances which the message's
+// records, of any section, name as the targets of PTR records, or as the
+// owners of SRV and TXT records, in the order they are first named.
+func (d *DNS) DNSSDInstances() []DNSSDInstance {
+	var instances []DNSSDInstance
+	index := make(map[string]int)
+	instance := func(name []byte) *DNSSDInstance {
+		key := strings.ToLower(string(name))
+		if i, ok := index[key]; ok {
+			return &instances[i]
+		}
+		inst, service, domain, ok := SplitDNSSDName(name)
+		if !ok || inst == "" {
+			return nil
+		}
+		index[key] = len(instances)
+		instances = append(instances, DNSSDInstance{Name: name, Instance: inst, Service: service, Domain: domain})
+		return &instances[len(instances)-1]
+	}
+
+	sections := [][]DNSResourceRecord{d.Answers, d.Authorities, d.Additionals}
+	for _, records := range sections {
+		for i := range records {
+			rr := &records[i]
+			switch rr.Type {
+			case DNSTypePTR:
+				instance(rr.PTR)
+			case DNSTypeSRV:
+				if s := instance(rr.Name); s != nil {
+					s.Target = rr.SRV.Name
+					s.Port, s.Priority, s.Weight = rr.SRV.Port, rr.SRV.Priority, rr.SRV.Weight
+				}
+			case DNSTypeTXT:
+				if s := instance(rr.Name); s != nil {
+					s.TXTs = rr.TXTs
+				}
+			}
+		}
+	}
+	for _, records := range sections {
+		for i := range records {
+			rr := &records[i]
+			if rr.Type != DNSTypeA && rr.Type != DNSTypeAAAA {
+				continue
+			}
+			for j := range instances {
+				if s := &instances[j]; bytes.EqualFold(s.Target, rr.Name) {
+					s.IPs = append(s.IPs, rr.IP)
+				}
+			}
+		}
+	}
+	return instances
+}
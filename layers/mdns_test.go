@@ -0,0 +1,183 @@
+// Copyright 2019 The GoPacket Authors. All rights reserved.
+//
+// Use of this source code is governed by a BSD-style license
+// that can be found in the LICENSE file in the root of the source
+// tree.
+
+package layers
+
+import (
+	"net"
+	"reflect"
+	"testing"
+
+	"github.com/google/gopacket"
+)
+
+// testMDNSPacket returns an Ethernet frame of the DNS message sent to the
+// mDNS group from port 5353.
+func testMDNSPacket(t *testing.T, d *DNS) []byte {
+	eth := &Ethernet{
+		SrcMAC:       net.HardwareAddr{0x00, 0x11, 0x22, 0x33, 0x44, 0x55},
+		DstMAC:       net.HardwareAddr{0x01, 0x00, 0x5e, 0x00, 0x00, 0xfb},
+		EthernetType: EthernetTypeIPv4,
+	}
+	ip := &IPv4{Version: 4, TTL: 255, Protocol: IPProtocolUDP, SrcIP: net.IP{192, 168, 1, 20}, DstIP: net.IP{224, 0, 0, 251}}
+	udp := &UDP{SrcPort: 5353, DstPort: 5353}
+	udp.SetNetworkLayerForChecksum(ip)
+	buf := gopacket.NewSerializeBuffer()
+	opts := gopacket.SerializeOptions{FixLengths: true, ComputeChecksums: true}
+	if err := gopacket.SerializeLayers(buf, opts, eth, ip, udp, d); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestMDNSQuery(t *testing.T) {
+	// A QU question, as a host asks when it starts up.
+	data := testMDNSPacket(t, &DNS{
+		Questions: []DNSQuestion{{Name: []byte("_ipp._tcp.local"), Type: DNSTypePTR, Class: DNSClassIN, UnicastResponse: true}},
+	})
+	if b := data[len(data)-2:]; b[0] != 0x80 || b[1] != 0x01 {
+		t.Errorf("question class %x", b)
+	}
+	p := gopacket.NewPacket(data, LinkTypeEthernet, gopacket.Default)
+	if p.ErrorLayer() != nil {
+		t.Fatal(p.ErrorLayer().Error())
+	}
+	checkLayers(p, []gopacket.LayerType{LayerTypeEthernet, LayerTypeIPv4, LayerTypeUDP, LayerTypeDNS}, t)
+	d, ok := p.Layer(LayerTypeDNS).(*DNS)
+	if !ok || !d.MDNS {
+		t.Fatalf("mDNS layer %v", p.Layer(LayerTypeDNS))
+	}
+	if q := d.Questions[0]; q.Class != DNSClassIN || !q.UnicastResponse {
+		t.Errorf("question %+v", q)
+	}
+
+	// Unicast DNS keeps the class as sent.
+	var dns DNS
+	if err := dns.DecodeFromBytes(d.Contents, gopacket.NilDecodeFeedback); err != nil {
+		t.Fatal(err)
+	}
+	if q := dns.Questions[0]; q.Class != 0x8001 || q.UnicastResponse {
+		t.Errorf("DNS question %+v", q)
+	}
+	if lt := UDPPort(5355).LayerType(); lt != LayerTypeDNS {
+		t.Errorf("LLMNR port decodes as %v", lt)
+	}
+}
+
+func TestMDNSDecodingLayerParser(t *testing.T) {
+	data := testMDNSPacket(t, &DNS{
+		Questions: []DNSQuestion{{Name: []byte("_ipp._tcp.local"), Type: DNSTypePTR, Class: DNSClassIN, UnicastResponse: true}},
+	})
+	var (
+		eth  Ethernet
+		ip   IPv4
+		udp  UDP
+		dns  DNS
+		mdns MDNS
+	)
+	parser := gopacket.NewDecodingLayerParser(LayerTypeEthernet, &eth, &ip, &udp, &dns, &mdns)
+	var decoded []gopacket.LayerType
+	if err := parser.DecodeLayers(data, &decoded); err != nil {
+		t.Fatal(err)
+	}
+	if want := []gopacket.LayerType{LayerTypeEthernet, LayerTypeIPv4, LayerTypeUDP, LayerTypeMDNS}; !reflect.DeepEqual(decoded, want) {
+		t.Errorf("decoded %v, want %v", decoded, want)
+	}
+	if q := mdns.Questions[0]; !mdns.MDNS || q.Class != DNSClassIN || !q.UnicastResponse {
+		t.Errorf("mDNS question %+v", q)
+	}
+
+	// DNS decodes other messages without MDNS, whatever it was set to.
+	dns.MDNS = true
+	udp.SrcPort, udp.DstPort = 53000, 53
+	buf := gopacket.NewSerializeBuffer()
+	if err := gopacket.SerializeLayers(buf, gopacket.SerializeOptions{FixLengths: true}, &eth, &ip, &udp, &mdns.DNS); err != nil {
+		t.Fatal(err)
+	}
+	if err := parser.DecodeLayers(buf.Bytes(), &decoded); err != nil {
+		t.Fatal(err)
+	}
+	if want := []gopacket.LayerType{LayerTypeEthernet, LayerTypeIPv4, LayerTypeUDP, LayerTypeDNS}; !reflect.DeepEqual(decoded, want) {
+		t.Errorf("decoded %v, want %v", decoded, want)
+	}
+	if q := dns.Questions[0]; dns.MDNS || q.Class != 0x8001 || q.UnicastResponse {
+		t.Errorf("DNS question %+v", q)
+	}
+}
+
+func TestMDNSServiceResponse(t *testing.T) {
+	instance := []byte("Office Printer._ipp._tcp.local")
+	d := &DNS{
+		QR: true, AA: true,
+		Answers: []DNSResourceRecord{
+			{Name: []byte("_ipp._tcp.local"), Type: DNSTypePTR, Class: DNSClassIN, TTL: 4500, PTR: instance},
+		},
+		Additionals: []DNSResourceRecord{
+			{Name: instance, Type: DNSTypeSRV, Class: DNSClassIN, TTL: 120, CacheFlush: true,
+				SRV: DNSSRV{Port: 631, Name: []byte("printer.local")}},
+			{Name: instance, Type: DNSTypeTXT, Class: DNSClassIN, TTL: 4500, CacheFlush: true,
+				TXTs: [][]byte{[]byte("txtvers=1"), []byte("rp=ipp/print"), []byte("Color=T"), []byte("duplex"), []byte("color=F")}},
+			{Name: []byte("printer.local"), Type: DNSTypeA, Class: DNSClassIN, TTL: 120, CacheFlush: true, IP: net.IP{192, 168, 1, 20}},
+			{Name: []byte("other.local"), Type: DNSTypeA, Class: DNSClassIN, TTL: 120, CacheFlush: true, IP: net.IP{192, 168, 1, 21}},
+			{Name: []byte("Printer.local"), Type: DNSTypeAAAA, Class: DNSClassIN, TTL: 120, CacheFlush: true, IP: net.ParseIP("fe80::1")},
+		},
+	}
+	p := gopacket.NewPacket(testMDNSPacket(t, d), LinkTypeEthernet, gopacket.Default)
+	if p.ErrorLayer() != nil {
+		t.Fatal(p.ErrorLayer().Error())
+	}
+	got := p.Layer(LayerTypeDNS).(*DNS)
+	if rr := got.Answers[0]; rr.CacheFlush || rr.Class != DNSClassIN {
+		t.Errorf("shared record %v, cache flush %v", rr.Class, rr.CacheFlush)
+	}
+	for _, rr := range got.Additionals {
+		if !rr.CacheFlush || rr.Class != DNSClassIN {
+			t.Errorf("unique record %s %v, cache flush %v", rr.Name, rr.Class, rr.CacheFlush)
+		}
+	}
+
+	instances := got.DNSSDInstances()
+	if len(instances) != 1 {
+		t.Fatalf("instances %+v", instances)
+	}
+	s := instances[0]
+	if s.Instance != "Office Printer" || s.Service != "_ipp._tcp" || s.Domain != "local" ||
+		string(s.Target) != "printer.local" || s.Port != 631 || len(s.TXTs) != 5 {
+		t.Errorf("instance %+v", s)
+	}
+	if want := []net.IP{{192, 168, 1, 20}, net.ParseIP("fe80::1")}; !reflect.DeepEqual(s.IPs, want) {
+		t.Errorf("IPs %v, want %v", s.IPs, want)
+	}
+	attrs := DNSSDAttributes(s.TXTs)
+	want := map[string][]byte{"txtvers": []byte("1"), "rp": []byte("ipp/print"), "color": []byte("T"), "duplex": nil}
+	if !reflect.DeepEqual(attrs, want) {
+		t.Errorf("attributes %q, want %q", attrs, want)
+	}
+}
+
+func TestSplitDNSSDName(t *testing.T) {
+	for _, test := range []struct {
+		name                      string
+		instance, service, domain string
+		ok                        bool
+	}{
+		{"Office Printer._ipp._tcp.local", "Office Printer", "_ipp._tcp", "local", true},
+		{"Dr. Who's Mac._airplay._TCP.example.com", "Dr. Who's Mac", "_airplay._TCP", "example.com", true},
+		{"_ipp._tcp.local", "", "_ipp._tcp", "local", true},
+		{"_color._sub._ipp._tcp.local", "", "_ipp._tcp", "local", true},
+		{"_services._dns-sd._udp.local", "", "_dns-sd._udp", "local", true},
+		{"x._sip._udp", "x", "_sip._udp", "", true},
+		{"www.example.com", "", "", "", false},
+		{"a.ipp._tcp.local", "", "", "", false},
+		{"_tcp.local", "", "", "", false},
+		{"a._ipp._tcpx.local", "", "", "", false},
+	} {
+		instance, service, domain, ok := SplitDNSSDName([]byte(test.name))
+		if instance != test.instance || service != test.service || domain != test.domain || ok != test.ok {
+			t.Errorf("%q split as %q, %q, %q, %v", test.name, instance, service, domain, ok)
+		}
+	}
+}
@@ -0,0 +1,223 @@
+// Copyright 2019 The GoPacket Authors. All rights reserved.
+//
+// Use of this source code is governed by a BSD-style license
+// that can be found in the LICENSE file in the root of the source
+// tree.
+
+package layers
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+
+	"github.com/google/gopacket"
+)
+
+// NBDSMsgType is the type of a NetBIOS Datagram Service message.
+type NBDSMsgType uint8
+
+// NBDSMsgType known values.
+const (
+	NBDSMsgTypeDirectUnique          NBDSMsgType = 0x10
+	NBDSMsgTypeDirectGroup           NBDSMsgType = 0x11
+	NBDSMsgTypeBroadcast             NBDSMsgType = 0x12
+	NBDSMsgTypeError                 NBDSMsgType = 0x13
+	NBDSMsgTypeQueryRequest          NBDSMsgType = 0x14
+	NBDSMsgTypePositiveQueryResponse NBDSMsgType = 0x15
+	NBDSMsgTypeNegativeQueryResponse NBDSMsgType = 0x16
+)
+
+func (t NBDSMsgType) String() string {
+	switch t {
+	case NBDSMsgTypeDirectUnique:
+		return "Direct Unique Datagram"
+	case NBDSMsgTypeDirectGroup:
+		return "Direct Group Datagram"
+	case NBDSMsgTypeBroadcast:
+		return "Broadcast Datagram"
+	case NBDSMsgTypeError:
+		return "Datagram Error"
+	case NBDSMsgTypeQueryRequest:
+		return "Datagram Query Request"
+	case NBDSMsgTypePositiveQueryResponse:
+		return "Datagram Positive Query Response"
+	case NBDSMsgTypeNegativeQueryResponse:
+		return "Datagram Negative Query Response"
+	}
+	return "Unknown"
+}
+
+// hasData reports whether messages of the type carry user data.
+func (t NBDSMsgType) hasData() bool {
+	return t >= NBDSMsgTypeDirectUnique && t <= NBDSMsgTypeBroadcast
+}
+
+// NBDSErrorCode is the error of a Datagram Error message.
+type NBDSErrorCode uint8
+
+// NBDSErrorCode known values.
+const (
+	NBDSErrorDestinationNameNotPresent NBDSErrorCode = 0x82
+	NBDSErrorInvalidSourceName         NBDSErrorCode = 0x83
+	NBDSErrorInvalidDestinationName    NBDSErrorCode = 0x84
+)
+
+func (c NBDSErrorCode) String() string {
+	switch c {
+	case NBDSErrorDestinationNameNotPresent:
+		return "Destination Name Not Present"
+	case NBDSErrorInvalidSourceName:
+		return "Invalid Source Name Format"
+	case NBDSErrorInvalidDestinationName:
+		return "Invalid Destination Name Format"
+	}
+	return "Unknown"
+}
+
+// NBDS is a NetBIOS Datagram Service message, see RFC 1002 section 4.4.
+// The user data of datagrams, such as the SMB mailslot messages of
+// browser announcements, is its payload.
+type NBDS struct {
+	BaseLayer
+
+	MsgType NBDSMsgType
+	// SourceNodeType is the type of the sending node, and First and More
+	// are set for the first fragment of a datagram, and for fragments
+	// followed by more.
+	SourceNodeType NBNSOwnerType
+	First, More    bool
+	ID             uint16
+	SourceIP       net.IP
+	SourcePort     uint16
+
+	// Length and PacketOffset are those of datagrams, whose Length counts
+	// the bytes of their names and user data.  Datagrams have a
+	// SourceName, which other messages don't, and all but Datagram Error
+	// messages have a DestinationName.
+	Length          uint16
+	PacketOffset    uint16
+	SourceName      NBName
+	DestinationName NBName
+
+	ErrorCode NBDSErrorCode
+}
+
+// LayerType returns LayerTypeNBDS.
+func (n *NBDS) LayerType() gopacket.LayerType { return LayerTypeNBDS }
+
+// CanDecode returns LayerTypeNBDS.
+func (n *NBDS) CanDecode() gopacket.LayerClass { return LayerTypeNBDS }
+
+// NextLayerType returns gopacket.LayerTypePayload.
+func (n *NBDS) NextLayerType() gopacket.LayerType { return gopacket.LayerTypePayload }
+
+func decodeNBDS(data []byte, p gopacket.PacketBuilder) error {
+	n := &NBDS{}
+	if err := n.DecodeFromBytes(data, p); err != nil {
+		return err
+	}
+	p.AddLayer(n)
+	return p.NextDecoder(n.NextLayerType())
+}
+
+// DecodeFromBytes decodes the slice into the NBDS struct.
+func (n *NBDS) DecodeFromBytes(data []byte, df gopacket.DecodeFeedback) error {
+	if len(data) < 10 {
+		df.SetTruncated()
+		return errors.New("NBDS message too short")
+	}
+	n.MsgType = NBDSMsgType(data[0])
+	n.SourceNodeType = NBNSOwnerType(data[1] >> 2 & 3)
+	n.First = data[1]&0x02 != 0
+	n.More = data[1]&0x01 != 0
+	n.ID = binary.BigEndian.Uint16(data[2:])
+	n.SourceIP = net.IP(data[4:8])
+	n.SourcePort = binary.BigEndian.Uint16(data[8:])
+	n.Length, n.PacketOffset, n.ErrorCode = 0, 0, 0
+	n.SourceName, n.DestinationName = NBName{}, NBName{}
+
+	var buffer []byte
+	offset := 10
+	switch {
+	case n.MsgType.hasData():
+		if len(data) < 14 {
+			df.SetTruncated()
+			return errors.New("NBDS datagram header truncated")
+		}
+		n.Length = binary.BigEndian.Uint16(data[10:])
+		n.PacketOffset = binary.BigEndian.Uint16(data[12:])
+		end := 14 + int(n.Length)
+		if end > len(data) {
+			df.SetTruncated()
+			end = len(data)
+		}
+		var err error
+		if n.SourceName, offset, err = decodeNBName(data[:end], 14, &buffer); err != nil {
+			return err
+		}
+		if n.DestinationName, offset, err = decodeNBName(data[:end], offset, &buffer); err != nil {
+			return err
+		}
+		n.BaseLayer = BaseLayer{Contents: data[:offset], Payload: data[offset:end]}
+		return nil
+	case n.MsgType == NBDSMsgTypeError:
+		if len(data) < 11 {
+			df.SetTruncated()
+			return errors.New("NBDS error message truncated")
+		}
+		n.ErrorCode = NBDSErrorCode(data[10])
+		offset = 11
+	case n.MsgType >= NBDSMsgTypeQueryRequest && n.MsgType <= NBDSMsgTypeNegativeQueryResponse:
+		var err error
+		if n.DestinationName, offset, err = decodeNBName(data, 10, &buffer); err != nil {
+			return err
+		}
+	default:
+		return fmt.Errorf("unknown NBDS message type %#x", uint8(n.MsgType))
+	}
+	n.BaseLayer = BaseLayer{Contents: data[:offset], Payload: data[offset:]}
+	return nil
+}
+
+// SerializeTo writes the serialized form of this layer into the
+// SerializationBuffer, implementing gopacket.SerializableLayer.  With
+// FixLengths, the Length of datagrams is set from their names and the
+// payload, which must already be in the buffer.
+func (n *NBDS) SerializeTo(b gopacket.SerializeBuffer, opts gopacket.SerializeOptions) error {
+	size := 10
+	switch {
+	case n.MsgType.hasData():
+		size += 4 + n.SourceName.encodedLen() + n.DestinationName.encodedLen()
+	case n.MsgType == NBDSMsgTypeError:
+		size++
+	default:
+		size += n.DestinationName.encodedLen()
+	}
+	payloadLen := len(b.Bytes())
+	data, err := b.PrependBytes(size)
+	if err != nil {
+		return err
+	}
+	data[0] = byte(n.MsgType)
+	data[1] = byte(n.SourceNodeType&3)<<2 | byte(b2i(n.First)<<1|b2i(n.More))
+	binary.BigEndian.PutUint16(data[2:], n.ID)
+	copy(data[4:8], n.SourceIP.To4())
+	binary.BigEndian.PutUint16(data[8:], n.SourcePort)
+	switch {
+	case n.MsgType.hasData():
+		if opts.FixLengths {
+			n.Length = uint16(size - 14 + payloadLen)
+		}
+		binary.BigEndian.PutUint16(data[10:], n.Length)
+		binary.BigEndian.PutUint16(data[12:], n.PacketOffset)
+		off := 14 + n.SourceName.encode(data[14:])
+		n.DestinationName.encode(data[off:])
+	case n.MsgType == NBDSMsgTypeError:
+		data[10] = byte(n.ErrorCode)
+	default:
+		n.DestinationName.encode(data[10:])
+	}
+	return nil
+}
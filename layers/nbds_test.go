@@ -0,0 +1,90 @@
+// Copyright 2019 The GoPacket Authors. All rights reserved.
+//
+// Use of this source code is governed by a BSD-style license
+// that can be found in the LICENSE file in the root of the source
+// tree.
+
+package layers
+
+import (
+	"bytes"
+	"net"
+	"testing"
+
+	"github.com/google/gopacket"
+)
+
+func TestNBDSDatagram(t *testing.T) {
+	// A browser host announcement, of which only the start of the SMB
+	// mailslot message is kept.
+	mailslot := []byte("\xffSMB%\x00\x00\x00\x00")
+	n := &NBDS{
+		MsgType:         NBDSMsgTypeDirectGroup,
+		SourceNodeType:  NBNSOwnerB,
+		First:           true,
+		ID:              0x8a3c,
+		SourceIP:        net.IP{192, 168, 1, 30},
+		SourcePort:      138,
+		SourceName:      NBName{Name: "WIN10", Suffix: 0x00},
+		DestinationName: NBName{Name: "WORKGROUP", Suffix: 0x1d},
+	}
+	buf := gopacket.NewSerializeBuffer()
+	if err := gopacket.SerializeLayers(buf, gopacket.SerializeOptions{FixLengths: true}, n, gopacket.Payload(mailslot)); err != nil {
+		t.Fatal(err)
+	}
+	data := buf.Bytes()
+	if n.Length != 2*34+uint16(len(mailslot)) || !bytes.Equal(data[:4], []byte{0x11, 0x02, 0x8a, 0x3c}) {
+		t.Errorf("length %d, header %x", n.Length, data[:14])
+	}
+
+	p := gopacket.NewPacket(data, LayerTypeNBDS, gopacket.Default)
+	if p.ErrorLayer() != nil {
+		t.Fatal(p.ErrorLayer().Error())
+	}
+	checkLayers(p, []gopacket.LayerType{LayerTypeNBDS, gopacket.LayerTypePayload}, t)
+	got := p.Layer(LayerTypeNBDS).(*NBDS)
+	if got.MsgType != NBDSMsgTypeDirectGroup || !got.First || got.More || got.SourceNodeType != NBNSOwnerB ||
+		!got.SourceIP.Equal(n.SourceIP) || got.SourcePort != 138 || got.Length != n.Length ||
+		got.SourceName.String() != "WIN10<00>" || got.DestinationName.String() != "WORKGROUP<1d>" {
+		t.Errorf("datagram %+v", got)
+	}
+	if !bytes.Equal(got.Payload, mailslot) {
+		t.Errorf("user data %q", got.Payload)
+	}
+	if lt := UDPPort(138).LayerType(); lt != LayerTypeNBDS {
+		t.Errorf("port 138 decodes as %v", lt)
+	}
+}
+
+func TestNBDSMessages(t *testing.T) {
+	for _, n := range []*NBDS{
+		{MsgType: NBDSMsgTypeError, ID: 1, SourceIP: net.IP{10, 0, 0, 1}, SourcePort: 138, ErrorCode: NBDSErrorDestinationNameNotPresent},
+		{MsgType: NBDSMsgTypeQueryRequest, ID: 2, SourceIP: net.IP{10, 0, 0, 1}, SourcePort: 138,
+			DestinationName: NBName{Name: "SERVER", Suffix: 0x20, Scope: []byte("corp.example")}},
+	} {
+		buf := gopacket.NewSerializeBuffer()
+		if err := gopacket.SerializeLayers(buf, gopacket.SerializeOptions{FixLengths: true}, n); err != nil {
+			t.Fatal(err)
+		}
+		var got NBDS
+		if err := got.DecodeFromBytes(buf.Bytes(), gopacket.NilDecodeFeedback); err != nil {
+			t.Fatalf("%v: %v", n.MsgType, err)
+		}
+		if got.MsgType != n.MsgType || got.ID != n.ID || got.ErrorCode != n.ErrorCode ||
+			got.DestinationName.String() != n.DestinationName.String() || len(got.Payload) != 0 {
+			t.Errorf("%v decoded as %+v", n.MsgType, got)
+		}
+	}
+
+	var got NBDS
+	for _, data := range [][]byte{
+		{0x11, 0x02, 0, 1, 10, 0, 0, 1, 0},
+		{0x11, 0x02, 0, 1, 10, 0, 0, 1, 0, 138, 0, 10},
+		{0x13, 0x02, 0, 1, 10, 0, 0, 1, 0, 138},
+		{0x20, 0x02, 0, 1, 10, 0, 0, 1, 0, 138, 0},
+	} {
+		if err := got.DecodeFromBytes(data, gopacket.NilDecodeFeedback); err == nil {
+			t.Errorf("%x decoded", data)
+		}
+	}
+}
@@ -0,0 +1,558 @@
+// Copyright 2019 The GoPacket Authors. All rights reserved.
+//
+// Use of this source code is governed by a BSD-style license
+// that can be found in the LICENSE file in the root of the source
+// tree.
+
+package layers
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+
+	"github.com/google/gopacket"
+)
+
+// NBName is a NetBIOS name, as the NetBIOS Name and Datagram Services
+// encode them, see RFC 1001 section 14 and RFC 1002 section 4.1.
+type NBName struct {
+	// Name is the name, without the padding of its 15 bytes.
+	Name string
+	// Suffix is the 16th byte of the name, which tells the service of its
+	// owner, such as 0x00 for workstations and 0x20 for file servers.
+	Suffix byte
+	// Scope is the NetBIOS scope, which is usually empty.
+	Scope []byte `gopacket:"text"`
+}
+
+// NBNameWildcard is the name "*", which NBSTAT queries ask for.
+var NBNameWildcard = NBName{Name: "*"}
+
+func (n NBName) String() string {
+	s := fmt.Sprintf("%s<%02x>", n.Name, n.Suffix)
+	if len(n.Scope) > 0 {
+		s += "." + string(n.Scope)
+	}
+	return s
+}
+
+// nbNameBytes returns the 16 bytes of the name, padded with spaces, or
+// with zeros for the wildcard name.
+func (n NBName) nbNameBytes() []byte {
+	b := make([]byte, 16)
+	pad := byte(' ')
+	if n.Name == "*" {
+		pad = 0
+	}
+	for i := range b[:15] {
+		b[i] = pad
+	}
+	copy(b[:15], n.Name)
+	b[15] = n.Suffix
+	return b
+}
+
+func nbNameFromBytes(b []byte) NBName {
+	name := string(b[:15])
+	if name[0] == '*' {
+		name = strings.TrimRight(name, "\x00")
+	}
+	return NBName{Name: strings.TrimRight(name, " "), Suffix: b[15]}
+}
+
+// encodedLen returns the size of the encoded name.
+func (n NBName) encodedLen() int {
+	l := 1 + 32 + 1
+	if len(n.Scope) > 0 {
+		l += len(n.Scope) + 1
+	}
+	return l
+}
+
+// encode writes the name with the first-level encoding, which splits each
+// of its 16 bytes into two letters of 'A' to 'P'.
+func (n NBName) encode(data []byte) int {
+	data[0] = 32
+	for i, c := range n.nbNameBytes() {
+		data[1+2*i] = 'A' + c>>4
+		data[2+2*i] = 'A' + c&0xf
+	}
+	if len(n.Scope) == 0 {
+		data[33] = 0
+		return 34
+	}
+	return encodeName(n.Scope, data, 33)
+}
+
+var errNBNameEncoding = errors.New("NetBIOS name not first-level encoded")
+
+// decodeNBName decodes the name at offset of data, which can be compressed
+// as DNS names are, and returns the offset following it.
+func decodeNBName(data []byte, offset int, buffer *[]byte) (NBName, int, error) {
+	name, next, err := decodeName(data, offset, buffer, 1)
+	if err != nil {
+		return NBName{}, 0, err
+	}
+	if len(name) < 32 || (len(name) > 32 && name[32] != '.') {
+		return NBName{}, 0, errNBNameEncoding
+	}
+	var b [16]byte
+	for i := range b {
+		hi, lo := name[2*i]-'A', name[2*i+1]-'A'
+		if hi > 0xf || lo > 0xf {
+			return NBName{}, 0, errNBNameEncoding
+		}
+		b[i] = hi<<4 | lo
+	}
+	n := nbNameFromBytes(b[:])
+	if len(name) > 32 {
+		n.Scope = name[33:]
+	}
+	return n, next, nil
+}
+
+// NBNSOpCode is the operation of a NetBIOS Name Service packet.
+type NBNSOpCode uint8
+
+// NBNSOpCode known values.
+const (
+	NBNSOpCodeQuery        NBNSOpCode = 0
+	NBNSOpCodeRegistration NBNSOpCode = 5
+	NBNSOpCodeRelease      NBNSOpCode = 6
+	NBNSOpCodeWACK         NBNSOpCode = 7
+	NBNSOpCodeRefresh      NBNSOpCode = 8
+	NBNSOpCodeMultiHomed   NBNSOpCode = 15
+)
+
+func (o NBNSOpCode) String() string {
+	switch o {
+	case NBNSOpCodeQuery:
+		return "Query"
+	case NBNSOpCodeRegistration:
+		return "Registration"
+	case NBNSOpCodeRelease:
+		return "Release"
+	case NBNSOpCodeWACK:
+		return "WACK"
+	case NBNSOpCodeRefresh:
+		return "Refresh"
+	case NBNSOpCodeMultiHomed:
+		return "Multi-Homed Registration"
+	}
+	return "Unknown"
+}
+
+// NBNSResponseCode is the result of a NetBIOS Name Service response.
+type NBNSResponseCode uint8
+
+// NBNSResponseCode known values.
+const (
+	NBNSResponseCodeNoErr    NBNSResponseCode = 0
+	NBNSResponseCodeFormErr  NBNSResponseCode = 1
+	NBNSResponseCodeServFail NBNSResponseCode = 2
+	NBNSResponseCodeNameErr  NBNSResponseCode = 3
+	NBNSResponseCodeNotImp   NBNSResponseCode = 4
+	NBNSResponseCodeRefused  NBNSResponseCode = 5
+	NBNSResponseCodeActive   NBNSResponseCode = 6
+	NBNSResponseCodeConflict NBNSResponseCode = 7
+)
+
+func (c NBNSResponseCode) String() string {
+	switch c {
+	case NBNSResponseCodeNoErr:
+		return "No Error"
+	case NBNSResponseCodeFormErr:
+		return "Format Error"
+	case NBNSResponseCodeServFail:
+		return "Server Failure"
+	case NBNSResponseCodeNameErr:
+		return "Name Error"
+	case NBNSResponseCodeNotImp:
+		return "Not Implemented"
+	case NBNSResponseCodeRefused:
+		return "Refused"
+	case NBNSResponseCodeActive:
+		return "Name Active"
+	case NBNSResponseCodeConflict:
+		return "Name in Conflict"
+	}
+	return "Unknown"
+}
+
+// NBNSType is the type of a NetBIOS Name Service question or record.
+type NBNSType uint16
+
+// NBNSType known values.
+const (
+	NBNSTypeA      NBNSType = 0x0001
+	NBNSTypeNS     NBNSType = 0x0002
+	NBNSTypeNULL   NBNSType = 0x000a
+	NBNSTypeNB     NBNSType = 0x0020
+	NBNSTypeNBSTAT NBNSType = 0x0021
+)
+
+func (t NBNSType) String() string {
+	switch t {
+	case NBNSTypeA:
+		return "A"
+	case NBNSTypeNS:
+		return "NS"
+	case NBNSTypeNULL:
+		return "NULL"
+	case NBNSTypeNB:
+		return "NB"
+	case NBNSTypeNBSTAT:
+		return "NBSTAT"
+	}
+	return "Unknown"
+}
+
+// NBNSOwnerType is the type of the node owning a name: a B, P, M or H
+// node, as it resolves names by broadcast or with a name server.
+type NBNSOwnerType uint8
+
+// NBNSOwnerType known values.
+const (
+	NBNSOwnerB NBNSOwnerType = 0
+	NBNSOwnerP NBNSOwnerType = 1
+	NBNSOwnerM NBNSOwnerType = 2
+	NBNSOwnerH NBNSOwnerType = 3
+)
+
+func (t NBNSOwnerType) String() string {
+	return string("BPMH"[t&3]) + "-node"
+}
+
+// NBNSNameFlags are the flags of a name of an NBSTAT record.
+type NBNSNameFlags uint16
+
+// NBNSNameFlags known values.  The owner type is in NBNSNameOwnerMask.
+const (
+	NBNSNameGroup      NBNSNameFlags = 0x8000
+	NBNSNameOwnerMask  NBNSNameFlags = 0x6000
+	NBNSNameDeregister NBNSNameFlags = 0x1000
+	NBNSNameConflict   NBNSNameFlags = 0x0800
+	NBNSNameActive     NBNSNameFlags = 0x0400
+	NBNSNamePermanent  NBNSNameFlags = 0x0200
+)
+
+// OwnerType returns the owner type of the flags.
+func (f NBNSNameFlags) OwnerType() NBNSOwnerType {
+	return NBNSOwnerType(f & NBNSNameOwnerMask >> 13)
+}
+
+// NBNSAddress is an address entry of an NB record.
+type NBNSAddress struct {
+	Group     bool
+	OwnerType NBNSOwnerType
+	IP        net.IP
+}
+
+// NBNSNodeName is a name of the node of an NBSTAT record.
+type NBNSNodeName struct {
+	Name  NBName
+	Flags NBNSNameFlags
+}
+
+// NBNSQuestion is a question of a NetBIOS Name Service packet.
+type NBNSQuestion struct {
+	Name  NBName
+	Type  NBNSType
+	Class DNSClass
+}
+
+// NBNSResourceRecord is a resource record of a NetBIOS Name Service
+// packet.  Records of types other than NB, NBSTAT, A and NS, and NB
+// records of WACK responses, are only kept in Data, which is what is
+// serialized for them.
+type NBNSResourceRecord struct {
+	Name  NBName
+	Type  NBNSType
+	Class DNSClass
+	TTL   uint32
+
+	DataLength uint16
+	Data       []byte
+
+	// Addresses are the entries of NB records.
+	Addresses []NBNSAddress
+	// NodeNames, UnitID and Statistics are the node status of NBSTAT
+	// records, whose Statistics follow the unit ID.
+	NodeNames  []NBNSNodeName
+	UnitID     net.HardwareAddr
+	Statistics []byte
+	// IP is the address of A records.
+	IP net.IP
+	// NS is the name server name of NS records.
+	NS []byte `gopacket:"text"`
+}
+
+// NBNS is a NetBIOS Name Service packet, see RFC 1002 section 4.2.  Its
+// header is that of DNS, with the broadcast flag B.
+type NBNS struct {
+	BaseLayer
+
+	ID           uint16
+	QR           bool
+	OpCode       NBNSOpCode
+	AA, TC       bool
+	RD, RA       bool
+	B            bool // Broadcast
+	ResponseCode NBNSResponseCode
+
+	QDCount, ANCount, NSCount, ARCount uint16
+
+	Questions   []NBNSQuestion
+	Answers     []NBNSResourceRecord
+	Authorities []NBNSResourceRecord
+	Additionals []NBNSResourceRecord
+
+	buffer []byte
+}
+
+// LayerType returns LayerTypeNBNS.
+func (n *NBNS) LayerType() gopacket.LayerType { return LayerTypeNBNS }
+
+// CanDecode returns LayerTypeNBNS.
+func (n *NBNS) CanDecode() gopacket.LayerClass { return LayerTypeNBNS }
+
+// NextLayerType returns gopacket.LayerTypePayload.
+func (n *NBNS) NextLayerType() gopacket.LayerType { return gopacket.LayerTypePayload }
+
+// Payload returns nil.
+func (n *NBNS) Payload() []byte { return nil }
+
+func decodeNBNS(data []byte, p gopacket.PacketBuilder) error {
+	n := &NBNS{}
+	if err := n.DecodeFromBytes(data, p); err != nil {
+		return err
+	}
+	p.AddLayer(n)
+	p.SetApplicationLayer(n)
+	return nil
+}
+
+// DecodeFromBytes decodes the slice into the NBNS struct.
+func (n *NBNS) DecodeFromBytes(data []byte, df gopacket.DecodeFeedback) error {
+	n.buffer = n.buffer[:0]
+	if len(data) < 12 {
+		df.SetTruncated()
+		return errors.New("NBNS packet too short")
+	}
+	n.BaseLayer = BaseLayer{Contents: data}
+	n.ID = binary.BigEndian.Uint16(data)
+	n.QR = data[2]&0x80 != 0
+	n.OpCode = NBNSOpCode(data[2] >> 3 & 0xf)
+	n.AA = data[2]&0x04 != 0
+	n.TC = data[2]&0x02 != 0
+	n.RD = data[2]&0x01 != 0
+	n.RA = data[3]&0x80 != 0
+	n.B = data[3]&0x10 != 0
+	n.ResponseCode = NBNSResponseCode(data[3] & 0xf)
+	n.QDCount = binary.BigEndian.Uint16(data[4:])
+	n.ANCount = binary.BigEndian.Uint16(data[6:])
+	n.NSCount = binary.BigEndian.Uint16(data[8:])
+	n.ARCount = binary.BigEndian.Uint16(data[10:])
+
+	n.Questions = n.Questions[:0]
+	offset := 12
+	for i := 0; i < int(n.QDCount); i++ {
+		var q NBNSQuestion
+		name, next, err := decodeNBName(data, offset, &n.buffer)
+		if err != nil {
+			return err
+		}
+		if next+4 > len(data) {
+			df.SetTruncated()
+			return errors.New("NBNS question truncated")
+		}
+		q.Name = name
+		q.Type = NBNSType(binary.BigEndian.Uint16(data[next:]))
+		q.Class = DNSClass(binary.BigEndian.Uint16(data[next+2:]))
+		n.Questions = append(n.Questions, q)
+		offset = next + 4
+	}
+	var err error
+	for _, s := range []struct {
+		records *[]NBNSResourceRecord
+		count   uint16
+	}{{&n.Answers, n.ANCount}, {&n.Authorities, n.NSCount}, {&n.Additionals, n.ARCount}} {
+		*s.records = (*s.records)[:0]
+		for i := 0; i < int(s.count); i++ {
+			*s.records = append(*s.records, NBNSResourceRecord{})
+			if offset, err = (*s.records)[i].decode(data, offset, n.OpCode, &n.buffer); err != nil {
+				*s.records = (*s.records)[:i]
+				df.SetTruncated()
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (rr *NBNSResourceRecord) decode(data []byte, offset int, op NBNSOpCode, buffer *[]byte) (int, error) {
+	name, next, err := decodeNBName(data, offset, buffer)
+	if err != nil {
+		return 0, err
+	}
+	if next+10 > len(data) {
+		return 0, errors.New("NBNS resource record truncated")
+	}
+	rr.Name = name
+	rr.Type = NBNSType(binary.BigEndian.Uint16(data[next:]))
+	rr.Class = DNSClass(binary.BigEndian.Uint16(data[next+2:]))
+	rr.TTL = binary.BigEndian.Uint32(data[next+4:])
+	rr.DataLength = binary.BigEndian.Uint16(data[next+8:])
+	start, end := next+10, next+10+int(rr.DataLength)
+	if end > len(data) {
+		return 0, errors.New("NBNS resource record data truncated")
+	}
+	rr.Data = data[start:end]
+
+	switch {
+	case rr.Type == NBNSTypeNB && op != NBNSOpCodeWACK:
+		if len(rr.Data)%6 != 0 {
+			return 0, fmt.Errorf("NB record data of invalid length %d", len(rr.Data))
+		}
+		for b := rr.Data; len(b) > 0; b = b[6:] {
+			flags := binary.BigEndian.Uint16(b)
+			rr.Addresses = append(rr.Addresses, NBNSAddress{
+				Group:     flags&0x8000 != 0,
+				OwnerType: NBNSOwnerType(flags >> 13 & 3),
+				IP:        net.IP(b[2:6]),
+			})
+		}
+	case rr.Type == NBNSTypeNBSTAT:
+		if len(rr.Data) < 1 || len(rr.Data) < 1+18*int(rr.Data[0])+6 {
+			return 0, errors.New("NBSTAT record data truncated")
+		}
+		b := rr.Data[1:]
+		for i := 0; i < int(rr.Data[0]); i++ {
+			rr.NodeNames = append(rr.NodeNames, NBNSNodeName{
+				Name:  nbNameFromBytes(b[:16]),
+				Flags: NBNSNameFlags(binary.BigEndian.Uint16(b[16:])),
+			})
+			b = b[18:]
+		}
+		rr.UnitID = net.HardwareAddr(b[:6])
+		rr.Statistics = b[6:]
+	case rr.Type == NBNSTypeA:
+		if len(rr.Data) != 4 {
+			return 0, fmt.Errorf("A record data of invalid length %d", len(rr.Data))
+		}
+		rr.IP = net.IP(rr.Data)
+	case rr.Type == NBNSTypeNS:
+		ns, _, err := decodeName(data[:end], start, buffer, 1)
+		if err != nil {
+			return 0, err
+		}
+		rr.NS = ns
+	}
+	return end, nil
+}
+
+// dataLen returns the size of the record's data, as it is serialized.
+func (rr *NBNSResourceRecord) dataLen(op NBNSOpCode) int {
+	switch {
+	case rr.Type == NBNSTypeNB && op != NBNSOpCodeWACK:
+		return 6 * len(rr.Addresses)
+	case rr.Type == NBNSTypeNBSTAT:
+		return 1 + 18*len(rr.NodeNames) + 6 + len(rr.Statistics)
+	case rr.Type == NBNSTypeA:
+		return 4
+	case rr.Type == NBNSTypeNS:
+		return dnsNameSize(rr.NS)
+	}
+	return len(rr.Data)
+}
+
+func (rr *NBNSResourceRecord) encode(data []byte, op NBNSOpCode, opts gopacket.SerializeOptions) int {
+	off := rr.Name.encode(data)
+	dataLen := rr.dataLen(op)
+	if opts.FixLengths {
+		rr.DataLength = uint16(dataLen)
+	}
+	binary.BigEndian.PutUint16(data[off:], uint16(rr.Type))
+	binary.BigEndian.PutUint16(data[off+2:], uint16(rr.Class))
+	binary.BigEndian.PutUint32(data[off+4:], rr.TTL)
+	binary.BigEndian.PutUint16(data[off+8:], rr.DataLength)
+	b := data[off+10 : off+10+dataLen]
+	switch {
+	case rr.Type == NBNSTypeNB && op != NBNSOpCodeWACK:
+		for i, a := range rr.Addresses {
+			flags := uint16(a.OwnerType&3) << 13
+			if a.Group {
+				flags |= 0x8000
+			}
+			binary.BigEndian.PutUint16(b[6*i:], flags)
+			copy(b[6*i+2:6*i+6], a.IP.To4())
+		}
+	case rr.Type == NBNSTypeNBSTAT:
+		b[0] = byte(len(rr.NodeNames))
+		o := 1
+		for _, nn := range rr.NodeNames {
+			copy(b[o:], nn.Name.nbNameBytes())
+			binary.BigEndian.PutUint16(b[o+16:], uint16(nn.Flags))
+			o += 18
+		}
+		copy(b[o:o+6], rr.UnitID)
+		copy(b[o+6:], rr.Statistics)
+	case rr.Type == NBNSTypeA:
+		copy(b, rr.IP.To4())
+	case rr.Type == NBNSTypeNS:
+		encodeName(rr.NS, b, 0)
+	default:
+		copy(b, rr.Data)
+	}
+	return off + 10 + dataLen
+}
+
+// SerializeTo writes the serialized form of this layer into the
+// SerializationBuffer, implementing gopacket.SerializableLayer.  Names are
+// written uncompressed.
+func (n *NBNS) SerializeTo(b gopacket.SerializeBuffer, opts gopacket.SerializeOptions) error {
+	size := 12
+	for _, q := range n.Questions {
+		size += q.Name.encodedLen() + 4
+	}
+	sections := [][]NBNSResourceRecord{n.Answers, n.Authorities, n.Additionals}
+	for _, records := range sections {
+		for i := range records {
+			size += records[i].Name.encodedLen() + 10 + records[i].dataLen(n.OpCode)
+		}
+	}
+	data, err := b.PrependBytes(size)
+	if err != nil {
+		return err
+	}
+	binary.BigEndian.PutUint16(data, n.ID)
+	data[2] = byte(b2i(n.QR)<<7 | int(n.OpCode&0xf)<<3 | b2i(n.AA)<<2 | b2i(n.TC)<<1 | b2i(n.RD))
+	data[3] = byte(b2i(n.RA)<<7 | b2i(n.B)<<4 | int(n.ResponseCode&0xf))
+	if opts.FixLengths {
+		n.QDCount = uint16(len(n.Questions))
+		n.ANCount = uint16(len(n.Answers))
+		n.NSCount = uint16(len(n.Authorities))
+		n.ARCount = uint16(len(n.Additionals))
+	}
+	binary.BigEndian.PutUint16(data[4:], n.QDCount)
+	binary.BigEndian.PutUint16(data[6:], n.ANCount)
+	binary.BigEndian.PutUint16(data[8:], n.NSCount)
+	binary.BigEndian.PutUint16(data[10:], n.ARCount)
+
+	off := 12
+	for _, q := range n.Questions {
+		off += q.Name.encode(data[off:])
+		binary.BigEndian.PutUint16(data[off:], uint16(q.Type))
+		binary.BigEndian.PutUint16(data[off+2:], uint16(q.Class))
+		off += 4
+	}
+	for _, records := range sections {
+		for i := range records {
+			off += records[i].encode(data[off:], n.OpCode, opts)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,129 @@
+// Copyright 2019 The GoPacket Authors. All rights reserved.
+//
+// Use of this source code is governed by a BSD-style license
+// that can be found in the LICENSE file in the root of the source
+// tree.
+
+package layers
+
+import (
+	"bytes"
+	"net"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/google/gopacket"
+)
+
+// The name FRED<20> is encoded as in the example of RFC 1001 section 14.1.
+var testNBNSQuery = "8a01011000010000000000002045474643454645454341434143414341434143414341434143414341434143410000200001"
+
+func TestNBNSQuery(t *testing.T) {
+	data := testHex(t, testNBNSQuery)
+	var n NBNS
+	if err := n.DecodeFromBytes(data, gopacket.NilDecodeFeedback); err != nil {
+		t.Fatal(err)
+	}
+	if n.ID != 0x8a01 || n.QR || n.OpCode != NBNSOpCodeQuery || !n.RD || !n.B || len(n.Questions) != 1 {
+		t.Fatalf("query %+v", n)
+	}
+	if q := n.Questions[0]; q.Name.Name != "FRED" || q.Name.Suffix != 0x20 || q.Type != NBNSTypeNB || q.Class != DNSClassIN {
+		t.Errorf("question %+v", q)
+	}
+	if s := n.Questions[0].Name.String(); s != "FRED<20>" {
+		t.Errorf("name %q", s)
+	}
+
+	buf := gopacket.NewSerializeBuffer()
+	if err := gopacket.SerializeLayers(buf, gopacket.SerializeOptions{FixLengths: true}, &n); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(buf.Bytes(), data) {
+		t.Errorf("serialized\n%x, want\n%x", buf.Bytes(), data)
+	}
+	if lt := UDPPort(137).LayerType(); lt != LayerTypeNBNS {
+		t.Errorf("port 137 decodes as %v", lt)
+	}
+}
+
+func TestNBNSCompressedResponse(t *testing.T) {
+	// The answer's name points to that of the question.
+	data := testHex(t, "8a01850000010001000000002045474643454645454341434143414341434143414341434143414341434143410000200001"+
+		"c00c00200001000493e000066000c0a8010a")
+	var n NBNS
+	if err := n.DecodeFromBytes(data, gopacket.NilDecodeFeedback); err != nil {
+		t.Fatal(err)
+	}
+	if !n.QR || !n.AA || !n.RD || n.B || n.ResponseCode != NBNSResponseCodeNoErr || len(n.Answers) != 1 {
+		t.Fatalf("response %+v", n)
+	}
+	rr := n.Answers[0]
+	if rr.Name.Name != "FRED" || rr.Type != NBNSTypeNB || rr.TTL != 300000 {
+		t.Errorf("record %+v", rr)
+	}
+	want := []NBNSAddress{{OwnerType: NBNSOwnerH, IP: net.IP{192, 168, 1, 10}}}
+	if !reflect.DeepEqual(rr.Addresses, want) {
+		t.Errorf("addresses %+v", rr.Addresses)
+	}
+}
+
+func TestNBNSNodeStatus(t *testing.T) {
+	query := &NBNS{ID: 1, Questions: []NBNSQuestion{{Name: NBNameWildcard, Type: NBNSTypeNBSTAT, Class: DNSClassIN}}}
+	buf := gopacket.NewSerializeBuffer()
+	if err := gopacket.SerializeLayers(buf, gopacket.SerializeOptions{FixLengths: true}, query); err != nil {
+		t.Fatal(err)
+	}
+	if name := string(buf.Bytes()[13:45]); name != "CK"+strings.Repeat("A", 30) {
+		t.Errorf("wildcard name %s", name)
+	}
+
+	response := &NBNS{
+		ID: 1, QR: true, AA: true,
+		Answers: []NBNSResourceRecord{{
+			Name: NBNameWildcard, Type: NBNSTypeNBSTAT, Class: DNSClassIN,
+			NodeNames: []NBNSNodeName{
+				{Name: NBName{Name: "WIN10"}, Flags: NBNSNameActive},
+				{Name: NBName{Name: "WORKGROUP"}, Flags: NBNSNameActive | NBNSNameGroup},
+				{Name: NBName{Name: "WIN10", Suffix: 0x20}, Flags: NBNSNameActive},
+			},
+			UnitID:     net.HardwareAddr{0x00, 0x0c, 0x29, 0x12, 0x34, 0x56},
+			Statistics: make([]byte, 40),
+		}},
+	}
+	buf = gopacket.NewSerializeBuffer()
+	if err := gopacket.SerializeLayers(buf, gopacket.SerializeOptions{FixLengths: true}, response); err != nil {
+		t.Fatal(err)
+	}
+	p := gopacket.NewPacket(buf.Bytes(), LayerTypeNBNS, gopacket.Default)
+	if p.ErrorLayer() != nil {
+		t.Fatal(p.ErrorLayer().Error())
+	}
+	rr := p.Layer(LayerTypeNBNS).(*NBNS).Answers[0]
+	if rr.DataLength != 1+3*18+46 || !reflect.DeepEqual(rr.NodeNames, response.Answers[0].NodeNames) ||
+		!bytes.Equal(rr.UnitID, response.Answers[0].UnitID) || len(rr.Statistics) != 40 {
+		t.Errorf("node status %+v", rr)
+	}
+	if g := rr.NodeNames[1].Flags; g&NBNSNameGroup == 0 || g.OwnerType() != NBNSOwnerB {
+		t.Errorf("group name flags %#x", g)
+	}
+}
+
+func TestNBNSMalformed(t *testing.T) {
+	query := testHex(t, testNBNSQuery)
+	for _, test := range []struct {
+		name string
+		data []byte
+	}{
+		{"header", query[:11]},
+		{"question", query[:len(query)-2]},
+		{"letter", bytes.Replace(query, []byte("EGFC"), []byte("EGFZ"), 1)},
+		{"short name", append(append([]byte{}, query[:12]...), 2, 'A', 'A', 0, 0, 0x20, 0, 1)},
+		{"NB data", append(append(testHex(t, "000085000000000100000000"), query[12:46]...), testHex(t, "002000010000000000050000000000")...)},
+	} {
+		var n NBNS
+		if err := n.DecodeFromBytes(test.data, gopacket.NilDecodeFeedback); err == nil {
+			t.Errorf("%s: decoded %+v", test.name, n)
+		}
+	}
+}
@@ -0,0 +1,222 @@
+// Copyright 2019 The GoPacket Authors. All rights reserved.
+//
+// Use of this source code is governed by a BSD-style license
+// that can be found in the LICENSE file in the root of the source
+// tree.
+
+package layers
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"time"
+
+	"github.com/google/gopacket"
+)
+
+// NetFlow v5 has fixed-size headers and records.
+const (
+	netFlowV5HeaderLength = 24
+	netFlowV5RecordLength = 48
+)
+
+// NetFlowV5 is a Cisco NetFlow version 5 export packet, a header followed by
+// fixed-format flow records.
+type NetFlowV5 struct {
+	BaseLayer
+	Version uint16
+	Count   uint16
+	// SysUptime is the exporter's uptime in milliseconds when the packet
+	// was sent, the time base of the records' First and Last.
+	SysUptime        uint32
+	UnixSecs         uint32
+	UnixNsecs        uint32
+	FlowSequence     uint32
+	EngineType       uint8
+	EngineID         uint8
+	SamplingMode     uint8  // the top 2 bits of the sampling field
+	SamplingInterval uint16 // the low 14 bits of the sampling field
+	Records          []NetFlowV5Record
+}
+
+// NetFlowV5Record is a flow record of a NetFlowV5 packet.
+type NetFlowV5Record struct {
+	SrcAddr, DstAddr, NextHop net.IP
+	// InputIf and OutputIf are SNMP interface indexes.
+	InputIf, OutputIf uint16
+	Packets           uint32
+	Octets            uint32
+	// First and Last are the exporter's uptime in milliseconds at the first
+	// and last packets of the flow.
+	First, Last      uint32
+	SrcPort, DstPort uint16
+	TCPFlags         uint8
+	Protocol         IPProtocol
+	ToS              uint8
+	SrcAS, DstAS     uint16
+	SrcMask, DstMask uint8
+}
+
+// LayerType returns LayerTypeNetFlowV5.
+func (n *NetFlowV5) LayerType() gopacket.LayerType { return LayerTypeNetFlowV5 }
+
+// CanDecode returns LayerTypeNetFlowV5.
+func (n *NetFlowV5) CanDecode() gopacket.LayerClass { return LayerTypeNetFlowV5 }
+
+// NextLayerType returns gopacket.LayerTypeZero; NetFlow packets carry no
+// payload.
+func (n *NetFlowV5) NextLayerType() gopacket.LayerType { return gopacket.LayerTypeZero }
+
+// Payload returns nil.
+func (n *NetFlowV5) Payload() []byte { return nil }
+
+// ExportTime returns the time the packet was sent.
+func (n *NetFlowV5) ExportTime() time.Time {
+	return time.Unix(int64(n.UnixSecs), int64(n.UnixNsecs))
+}
+
+// UptimeTime converts a time in milliseconds of exporter uptime, such as a
+// record's First or Last, to wall clock time.
+func (n *NetFlowV5) UptimeTime(uptime uint32) time.Time {
+	return n.ExportTime().Add(time.Duration(int64(uptime)-int64(n.SysUptime)) * time.Millisecond)
+}
+
+// DecodeFromBytes decodes the given bytes into this layer.
+func (n *NetFlowV5) DecodeFromBytes(data []byte, df gopacket.DecodeFeedback) error {
+	if len(data) < netFlowV5HeaderLength {
+		df.SetTruncated()
+		return errors.New("NetFlow v5 packet too short")
+	}
+	n.Version = binary.BigEndian.Uint16(data[0:2])
+	if n.Version != 5 {
+		return fmt.Errorf("NetFlow v5 packet has version %d", n.Version)
+	}
+	n.Count = binary.BigEndian.Uint16(data[2:4])
+	n.SysUptime = binary.BigEndian.Uint32(data[4:8])
+	n.UnixSecs = binary.BigEndian.Uint32(data[8:12])
+	n.UnixNsecs = binary.BigEndian.Uint32(data[12:16])
+	n.FlowSequence = binary.BigEndian.Uint32(data[16:20])
+	n.EngineType = data[20]
+	n.EngineID = data[21]
+	sampling := binary.BigEndian.Uint16(data[22:24])
+	n.SamplingMode = uint8(sampling >> 14)
+	n.SamplingInterval = sampling & 0x3fff
+
+	length := netFlowV5HeaderLength + int(n.Count)*netFlowV5RecordLength
+	if len(data) < length {
+		df.SetTruncated()
+		return fmt.Errorf("NetFlow v5 packet with %d records too short: %d bytes", n.Count, len(data))
+	}
+	n.Records = n.Records[:0]
+	for off := netFlowV5HeaderLength; off < length; off += netFlowV5RecordLength {
+		r := data[off : off+netFlowV5RecordLength]
+		n.Records = append(n.Records, NetFlowV5Record{
+			SrcAddr:  net.IP(r[0:4]),
+			DstAddr:  net.IP(r[4:8]),
+			NextHop:  net.IP(r[8:12]),
+			InputIf:  binary.BigEndian.Uint16(r[12:14]),
+			OutputIf: binary.BigEndian.Uint16(r[14:16]),
+			Packets:  binary.BigEndian.Uint32(r[16:20]),
+			Octets:   binary.BigEndian.Uint32(r[20:24]),
+			First:    binary.BigEndian.Uint32(r[24:28]),
+			Last:     binary.BigEndian.Uint32(r[28:32]),
+			SrcPort:  binary.BigEndian.Uint16(r[32:34]),
+			DstPort:  binary.BigEndian.Uint16(r[34:36]),
+			TCPFlags: r[37],
+			Protocol: IPProtocol(r[38]),
+			ToS:      r[39],
+			SrcAS:    binary.BigEndian.Uint16(r[40:42]),
+			DstAS:    binary.BigEndian.Uint16(r[42:44]),
+			SrcMask:  r[44],
+			DstMask:  r[45],
+		})
+	}
+	n.BaseLayer = BaseLayer{Contents: data[:length]}
+	return nil
+}
+
+// SerializeTo writes the serialized form of this layer into the
+// SerializationBuffer, implementing gopacket.SerializableLayer.
+// See the docs for gopacket.SerializableLayer for more info.
+func (n *NetFlowV5) SerializeTo(b gopacket.SerializeBuffer, opts gopacket.SerializeOptions) error {
+	if opts.FixLengths {
+		n.Version = 5
+		n.Count = uint16(len(n.Records))
+	}
+	bytes, err := b.PrependBytes(netFlowV5HeaderLength + len(n.Records)*netFlowV5RecordLength)
+	if err != nil {
+		return err
+	}
+	binary.BigEndian.PutUint16(bytes[0:2], n.Version)
+	binary.BigEndian.PutUint16(bytes[2:4], n.Count)
+	binary.BigEndian.PutUint32(bytes[4:8], n.SysUptime)
+	binary.BigEndian.PutUint32(bytes[8:12], n.UnixSecs)
+	binary.BigEndian.PutUint32(bytes[12:16], n.UnixNsecs)
+	binary.BigEndian.PutUint32(bytes[16:20], n.FlowSequence)
+	bytes[20] = n.EngineType
+	bytes[21] = n.EngineID
+	binary.BigEndian.PutUint16(bytes[22:24], uint16(n.SamplingMode)<<14|n.SamplingInterval&0x3fff)
+	for i, rec := range n.Records {
+		r := bytes[netFlowV5HeaderLength+i*netFlowV5RecordLength:][:netFlowV5RecordLength]
+		for j, ip := range []net.IP{rec.SrcAddr, rec.DstAddr, rec.NextHop} {
+			ip4 := ip.To4()
+			if ip4 == nil {
+				ip4 = net.IPv4zero.To4()
+				if ip != nil {
+					return fmt.Errorf("invalid NetFlow v5 IPv4 address %v", ip)
+				}
+			}
+			copy(r[j*4:], ip4)
+		}
+		binary.BigEndian.PutUint16(r[12:14], rec.InputIf)
+		binary.BigEndian.PutUint16(r[14:16], rec.OutputIf)
+		binary.BigEndian.PutUint32(r[16:20], rec.Packets)
+		binary.BigEndian.PutUint32(r[20:24], rec.Octets)
+		binary.BigEndian.PutUint32(r[24:28], rec.First)
+		binary.BigEndian.PutUint32(r[28:32], rec.Last)
+		binary.BigEndian.PutUint16(r[32:34], rec.SrcPort)
+		binary.BigEndian.PutUint16(r[34:36], rec.DstPort)
+		r[36] = 0
+		r[37] = rec.TCPFlags
+		r[38] = uint8(rec.Protocol)
+		r[39] = rec.ToS
+		binary.BigEndian.PutUint16(r[40:42], rec.SrcAS)
+		binary.BigEndian.PutUint16(r[42:44], rec.DstAS)
+		r[44] = rec.SrcMask
+		r[45] = rec.DstMask
+		r[46], r[47] = 0, 0
+	}
+	return nil
+}
+
+func decodeNetFlowV5(data []byte, p gopacket.PacketBuilder) error {
+	n := &NetFlowV5{}
+	if err := n.DecodeFromBytes(data, p); err != nil {
+		return err
+	}
+	p.AddLayer(n)
+	p.SetApplicationLayer(n)
+	return nil
+}
+
+// decodeNetFlow decodes NetFlow v5, NetFlow v9 or IPFIX by the version at the
+// start of every message, since exporters use the same ports for all of
+// them.
+func decodeNetFlow(data []byte, p gopacket.PacketBuilder) error {
+	if len(data) < 2 {
+		p.SetTruncated()
+		return errors.New("NetFlow packet too short")
+	}
+	switch version := binary.BigEndian.Uint16(data[0:2]); version {
+	case 5:
+		return decodeNetFlowV5(data, p)
+	case 9:
+		return decodeNetFlowV9(data, p)
+	case 10:
+		return decodeIPFIX(data, p)
+	default:
+		return fmt.Errorf("unsupported NetFlow version %d", version)
+	}
+}
@@ -61,6 +61,7 @@ func (a TCPPort) LayerType() gopacket.LayerType {
 
 var tcpPortLayerType = [65536]gopacket.LayerType{
 	53:   LayerTypeDNS,
+	179:  LayerTypeBGP,
 	443:  LayerTypeTLS,       // https
 	502:  LayerTypeModbusTCP, // modbustcp
 	636:  LayerTypeTLS,       // ldaps
@@ -116,6 +117,15 @@ var udpPortLayerType = [65536]gopacket.LayerType{
 	3784: LayerTypeBFD,
 	2152: LayerTypeGTPv1U,
 	623:  LayerTypeRMCP,
+	2055: LayerTypeNetFlowV9,
+	9995: LayerTypeNetFlowV9,
+	9996: LayerTypeNetFlowV9,
+	4739: LayerTypeIPFIX,
+	443:  LayerTypeQUIC,
+	5353: LayerTypeMDNS,
+	5355: LayerTypeDNS, // llmnr
+	137:  LayerTypeNBNS,
+	138:  LayerTypeNBDS,
 }
 
 // RegisterUDPPortLayerType creates a new mapping between a UDPPort
@@ -0,0 +1,445 @@
+// Copyright 2019 The GoPacket Authors. All rights reserved.
+//
+// Use of this source code is governed by a BSD-style license
+// that can be found in the LICENSE file in the root of the source
+// tree.
+
+package layers
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+
+	"github.com/google/gopacket"
+)
+
+// QUICVersion is the version of a QUIC long header packet.
+type QUICVersion uint32
+
+// QUICVersion known values.
+const (
+	// QUICVersionNegotiation is the version of Version Negotiation packets.
+	QUICVersionNegotiation QUICVersion = 0
+	QUICVersion1           QUICVersion = 0x00000001
+	QUICVersion2           QUICVersion = 0x6b3343cf
+)
+
+func (v QUICVersion) String() string {
+	switch v {
+	case QUICVersionNegotiation:
+		return "Version Negotiation"
+	case QUICVersion1:
+		return "QUICv1"
+	case QUICVersion2:
+		return "QUICv2"
+	}
+	return fmt.Sprintf("0x%08x", uint32(v))
+}
+
+// QUICPacketType is the type of a QUIC packet.
+type QUICPacketType uint8
+
+// QUICPacketType known values.
+const (
+	QUICPacketInitial QUICPacketType = iota
+	QUICPacket0RTT
+	QUICPacketHandshake
+	QUICPacketRetry
+	QUICPacketVersionNegotiation
+	// QUICPacket1RTT is the type of short header packets.
+	QUICPacket1RTT
+	// QUICPacketUnknown is the type of long header packets of unknown
+	// versions, whose fields after the connection IDs are unknown.
+	QUICPacketUnknown
+)
+
+func (t QUICPacketType) String() string {
+	switch t {
+	case QUICPacketInitial:
+		return "Initial"
+	case QUICPacket0RTT:
+		return "0-RTT"
+	case QUICPacketHandshake:
+		return "Handshake"
+	case QUICPacketRetry:
+		return "Retry"
+	case QUICPacketVersionNegotiation:
+		return "Version Negotiation"
+	case QUICPacket1RTT:
+		return "1-RTT"
+	}
+	return "Unknown"
+}
+
+// quicLongTypes maps the type bits of long headers to packet types, by
+// version.  QUIC version 2 rotates the values of version 1.
+var quicLongTypes = map[QUICVersion][4]QUICPacketType{
+	QUICVersion1: {QUICPacketInitial, QUICPacket0RTT, QUICPacketHandshake, QUICPacketRetry},
+	QUICVersion2: {QUICPacketRetry, QUICPacketInitial, QUICPacket0RTT, QUICPacketHandshake},
+}
+
+// QUICPacket is a QUIC packet of a datagram.  The first byte and packet
+// number of Initial, 0-RTT, Handshake and 1-RTT packets are protected, and
+// their payload encrypted: PacketNumber, Plaintext and Frames are only set
+// once a packet is decrypted, as DecryptInitial does.
+type QUICPacket struct {
+	Type QUICPacketType
+	// Version is zero for short header packets.
+	Version QUICVersion
+	// DstConnID and SrcConnID are the connection IDs of long header
+	// packets.  The length of the Destination Connection ID of short header
+	// packets isn't sent, so it is left in Protected.
+	DstConnID, SrcConnID []byte
+	// SupportedVersions are those of a Version Negotiation packet.
+	SupportedVersions []QUICVersion
+	// Token is the token of an Initial or Retry packet.
+	Token []byte
+	// RetryIntegrityTag is the integrity tag of a Retry packet.
+	RetryIntegrityTag []byte
+	// Length is the length of the packet number and payload of Initial,
+	// 0-RTT and Handshake packets.
+	Length uint64
+	// Header is the header as sent, up to the packet number.
+	Header []byte
+	// Protected is the packet number and payload as sent.
+	Protected []byte
+
+	// PacketNumber is the packet number as sent, truncated to its length.
+	PacketNumber uint64
+	Plaintext    []byte
+	Frames       []QUICFrame
+}
+
+// String describes the packet by its header.
+func (p QUICPacket) String() string {
+	switch p.Type {
+	case QUICPacket1RTT:
+		// Short headers have no version.
+		return fmt.Sprintf("1-RTT, %d protected bytes", len(p.Protected))
+	case QUICPacketVersionNegotiation:
+		return fmt.Sprintf("Version Negotiation, DCID %x, SCID %x, versions %v", p.DstConnID, p.SrcConnID, p.SupportedVersions)
+	}
+	s := fmt.Sprintf("%v %v, DCID %x, SCID %x", p.Version, p.Type, p.DstConnID, p.SrcConnID)
+	if len(p.Frames) > 0 {
+		s += fmt.Sprintf(", %d frames", len(p.Frames))
+	}
+	return s
+}
+
+// QUIC is a UDP datagram of QUIC packets, specified by RFC 9000 and RFC
+// 9369 for QUIC version 2.  A datagram can hold several long header
+// packets, coalesced, which a short header packet can end.
+type QUIC struct {
+	BaseLayer
+	Packets []QUICPacket
+}
+
+// LayerType returns LayerTypeQUIC.
+func (q *QUIC) LayerType() gopacket.LayerType { return LayerTypeQUIC }
+
+// CanDecode implements gopacket.DecodingLayer.
+func (q *QUIC) CanDecode() gopacket.LayerClass { return LayerTypeQUIC }
+
+// NextLayerType implements gopacket.DecodingLayer.
+func (q *QUIC) NextLayerType() gopacket.LayerType { return gopacket.LayerTypeZero }
+
+// Payload returns nil, the payloads of QUIC packets are encrypted.
+func (q *QUIC) Payload() []byte { return nil }
+
+// quicMinShortPacket is the length of the shortest short header packet
+// which can be sent: header protection samples 16 bytes, starting 4 bytes
+// after the first byte's, see RFC 9001 section 5.4.2.
+const quicMinShortPacket = 1 + 4 + 16
+
+func decodeQUIC(data []byte, p gopacket.PacketBuilder) error {
+	// UDP port 443 carries other protocols too, such as DTLS, which are
+	// left as payload.  So are datagrams too short for a 1-RTT packet,
+	// which anything with the fixed bit set would otherwise decode as.
+	q := &QUIC{}
+	if err := q.DecodeFromBytes(data, gopacket.NilDecodeFeedback); err != nil {
+		return p.NextDecoder(gopacket.LayerTypePayload)
+	}
+	if len(q.Packets) == 1 && q.Packets[0].Type == QUICPacket1RTT && len(data) < quicMinShortPacket {
+		return p.NextDecoder(gopacket.LayerTypePayload)
+	}
+	p.AddLayer(q)
+	p.SetApplicationLayer(q)
+	return nil
+}
+
+// DecodeFromBytes decodes the packets of a datagram.  Zero bytes which
+// pad the datagram after its packets are ignored.
+func (q *QUIC) DecodeFromBytes(data []byte, df gopacket.DecodeFeedback) error {
+	q.BaseLayer = BaseLayer{Contents: data}
+	q.Packets = q.Packets[:0]
+	for len(data) > 0 {
+		if data[0]&0xc0 == 0 {
+			if len(q.Packets) > 0 && allZero(data) {
+				break
+			}
+			return errors.New("QUIC packet without the fixed bit")
+		}
+		var p QUICPacket
+		n, err := p.decode(data)
+		if err != nil {
+			if err == errQUICTruncated {
+				df.SetTruncated()
+			}
+			return err
+		}
+		q.Packets = append(q.Packets, p)
+		data = data[n:]
+	}
+	if len(q.Packets) == 0 {
+		return errors.New("QUIC datagram without packets")
+	}
+	return nil
+}
+
+func allZero(data []byte) bool {
+	for _, b := range data {
+		if b != 0 {
+			return false
+		}
+	}
+	return true
+}
+
+var errQUICTruncated = errors.New("QUIC packet truncated")
+
+// decode decodes the packet at the start of data, and returns its length.
+func (p *QUICPacket) decode(data []byte) (int, error) {
+	if data[0]&0x80 == 0 {
+		p.Type = QUICPacket1RTT
+		p.Header, p.Protected = data[:1], data[1:]
+		return len(data), nil
+	}
+	if len(data) < 7 {
+		return 0, errQUICTruncated
+	}
+	p.Version = QUICVersion(binary.BigEndian.Uint32(data[1:5]))
+	off := 5
+	for _, id := range []*[]byte{&p.DstConnID, &p.SrcConnID} {
+		if off >= len(data) || off+1+int(data[off]) > len(data) {
+			return 0, errQUICTruncated
+		}
+		*id = data[off+1 : off+1+int(data[off])]
+		off += 1 + int(data[off])
+	}
+	if p.Version == QUICVersionNegotiation {
+		p.Type = QUICPacketVersionNegotiation
+		if (len(data)-off)%4 != 0 {
+			return 0, errors.New("QUIC Version Negotiation packet with a partial version")
+		}
+		for ; off < len(data); off += 4 {
+			p.SupportedVersions = append(p.SupportedVersions, QUICVersion(binary.BigEndian.Uint32(data[off:])))
+		}
+		p.Header = data
+		return len(data), nil
+	}
+	types, ok := quicLongTypes[p.Version]
+	if !ok {
+		p.Type = QUICPacketUnknown
+		p.Header, p.Protected = data[:off], data[off:]
+		return len(data), nil
+	}
+	p.Type = types[data[0]>>4&3]
+
+	switch p.Type {
+	case QUICPacketRetry:
+		if len(data)-off < 16 {
+			return 0, errQUICTruncated
+		}
+		p.Token = data[off : len(data)-16]
+		p.RetryIntegrityTag = data[len(data)-16:]
+		p.Header = data
+		return len(data), nil
+	case QUICPacketInitial:
+		length, n := quicVarint(data[off:])
+		if n == 0 || uint64(len(data)-off-n) < length {
+			return 0, errQUICTruncated
+		}
+		off += n
+		p.Token = data[off : off+int(length)]
+		off += int(length)
+	}
+	length, n := quicVarint(data[off:])
+	if n == 0 {
+		return 0, errQUICTruncated
+	}
+	off += n
+	if uint64(len(data)-off) < length {
+		return 0, errQUICTruncated
+	}
+	p.Length = length
+	p.Header = data[:off]
+	p.Protected = data[off : off+int(length)]
+	return off + int(length), nil
+}
+
+// quicVarint decodes the variable-length integer at the start of data, and
+// returns it with its length, or a zero length if data is too short.
+func quicVarint(data []byte) (uint64, int) {
+	if len(data) == 0 {
+		return 0, 0
+	}
+	n := 1 << (data[0] >> 6)
+	if len(data) < n {
+		return 0, 0
+	}
+	v := uint64(data[0] & 0x3f)
+	for _, b := range data[1:n] {
+		v = v<<8 | uint64(b)
+	}
+	return v, n
+}
+
+// QUICFrameType is the type of a QUIC frame.
+type QUICFrameType uint64
+
+// QUICFrameType values of the frames which Initial and Handshake packets
+// carry.
+const (
+	QUICFramePadding         QUICFrameType = 0x00
+	QUICFramePing            QUICFrameType = 0x01
+	QUICFrameACK             QUICFrameType = 0x02
+	QUICFrameACKECN          QUICFrameType = 0x03
+	QUICFrameCrypto          QUICFrameType = 0x06
+	QUICFrameConnectionClose QUICFrameType = 0x1c
+	// QUICFrameApplicationClose is a CONNECTION_CLOSE frame of the
+	// application.
+	QUICFrameApplicationClose QUICFrameType = 0x1d
+)
+
+func (t QUICFrameType) String() string {
+	switch t {
+	case QUICFramePadding:
+		return "PADDING"
+	case QUICFramePing:
+		return "PING"
+	case QUICFrameACK, QUICFrameACKECN:
+		return "ACK"
+	case QUICFrameCrypto:
+		return "CRYPTO"
+	case QUICFrameConnectionClose, QUICFrameApplicationClose:
+		return "CONNECTION_CLOSE"
+	}
+	return fmt.Sprintf("0x%x", uint64(t))
+}
+
+// QUICACKRange is a range of acknowledged packet numbers.
+type QUICACKRange struct {
+	Smallest, Largest uint64
+}
+
+// QUICFrame is a frame of a decrypted QUIC packet.  The fields after Type
+// are set according to it.
+type QUICFrame struct {
+	Type QUICFrameType
+	// PaddingLength is the number of consecutive PADDING frames, which
+	// make one QUICFrame.
+	PaddingLength int
+	// ACKDelay and ACKRanges are those of an ACK frame, whose ranges go
+	// from the largest acknowledged packet number down.
+	ACKDelay  uint64
+	ACKRanges []QUICACKRange
+	// ECT0, ECT1 and ECNCE are the ECN counts of an ACK frame of type
+	// QUICFrameACKECN.
+	ECT0, ECT1, ECNCE uint64
+	// Offset and Data are those of a CRYPTO frame.
+	Offset uint64
+	Data   []byte
+	// ErrorCode, FrameType and Reason are those of a CONNECTION_CLOSE
+	// frame.  FrameType is only sent in QUIC layer ones.
+	ErrorCode uint64
+	FrameType QUICFrameType
+	Reason    string
+}
+
+// DecodeQUICFrames decodes the frames of the plaintext of a QUIC packet.
+// Only the frames which Initial and Handshake packets carry are supported:
+// an unknown frame type, whose length isn't known, stops decoding with an
+// error.
+func DecodeQUICFrames(data []byte) ([]QUICFrame, error) {
+	var frames []QUICFrame
+	r := quicReader(data)
+	for len(r) > 0 {
+		var typ uint64
+		if !r.varint(&typ) {
+			return frames, errors.New("QUIC frame truncated")
+		}
+		f := QUICFrame{Type: QUICFrameType(typ)}
+		ok := true
+		switch f.Type {
+		case QUICFramePadding:
+			f.PaddingLength = 1
+			for len(r) > 0 && r[0] == 0 {
+				r = r[1:]
+				f.PaddingLength++
+			}
+		case QUICFramePing:
+		case QUICFrameACK, QUICFrameACKECN:
+			var largest, count, first uint64
+			ok = r.varint(&largest) && r.varint(&f.ACKDelay) && r.varint(&count) && r.varint(&first) && first <= largest
+			if !ok {
+				break
+			}
+			ack := QUICACKRange{Smallest: largest - first, Largest: largest}
+			f.ACKRanges = append(f.ACKRanges, ack)
+			for i := uint64(0); i < count && ok; i++ {
+				var gap, length uint64
+				ok = r.varint(&gap) && r.varint(&length) && gap+length+2 <= ack.Smallest
+				if ok {
+					ack.Largest = ack.Smallest - gap - 2
+					ack.Smallest = ack.Largest - length
+					f.ACKRanges = append(f.ACKRanges, ack)
+				}
+			}
+			if ok && f.Type == QUICFrameACKECN {
+				ok = r.varint(&f.ECT0) && r.varint(&f.ECT1) && r.varint(&f.ECNCE)
+			}
+		case QUICFrameCrypto:
+			ok = r.varint(&f.Offset) && r.vector(&f.Data)
+		case QUICFrameConnectionClose, QUICFrameApplicationClose:
+			var reason []byte
+			ok = r.varint(&f.ErrorCode)
+			if ok && f.Type == QUICFrameConnectionClose {
+				var ft uint64
+				ok = r.varint(&ft)
+				f.FrameType = QUICFrameType(ft)
+			}
+			if ok = ok && r.vector(&reason); ok {
+				f.Reason = string(reason)
+			}
+		default:
+			return frames, fmt.Errorf("unsupported QUIC frame type %v", f.Type)
+		}
+		if !ok {
+			return frames, fmt.Errorf("QUIC %v frame malformed", f.Type)
+		}
+		frames = append(frames, f)
+	}
+	return frames, nil
+}
+
+// quicReader reads the fields of QUIC frames.
+type quicReader []byte
+
+func (r *quicReader) varint(v *uint64) bool {
+	var n int
+	*v, n = quicVarint(*r)
+	*r = (*r)[n:]
+	return n > 0
+}
+
+// vector reads data preceded by its length.
+func (r *quicReader) vector(v *[]byte) bool {
+	var n uint64
+	if !r.varint(&n) || uint64(len(*r)) < n {
+		return false
+	}
+	*v = (*r)[:n]
+	*r = (*r)[n:]
+	return true
+}
@@ -0,0 +1,254 @@
+// Copyright 2019 The GoPacket Authors. All rights reserved.
+//
+// Use of this source code is governed by a BSD-style license
+// that can be found in the LICENSE file in the root of the source
+// tree.
+
+package layers
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+)
+
+// quicInitialSalts are the salts of the Initial secrets, by version.
+var quicInitialSalts = map[QUICVersion][]byte{
+	QUICVersion1: {
+		0x38, 0x76, 0x2c, 0xf7, 0xf5, 0x59, 0x34, 0xb3, 0x4d, 0x17,
+		0x9a, 0xe6, 0xa4, 0xc8, 0x0c, 0xad, 0xcc, 0xbb, 0x7f, 0x0a,
+	},
+	QUICVersion2: {
+		0x0d, 0xed, 0xe3, 0xde, 0xf7, 0x00, 0xa6, 0xdb, 0x81, 0x93,
+		0x81, 0xbe, 0x6e, 0x26, 0x9d, 0xcb, 0xf9, 0xbd, 0x2e, 0xd9,
+	},
+}
+
+// QUICInitialKeys are the keys which protect the Initial packets sent by
+// the client or the server of a QUIC connection.  Anyone can derive them,
+// from the Destination Connection ID of the client's first Initial packet.
+type QUICInitialKeys struct {
+	Version QUICVersion
+	// Key, IV and HP are the AEAD_AES_128_GCM key and IV, and the header
+	// protection key.
+	Key, IV, HP []byte
+
+	aead cipher.AEAD
+	hp   cipher.Block
+}
+
+// NewQUICInitialKeys derives the keys of the Initial packets of a version
+// sent by the client, or by the server if server is set, from the
+// Destination Connection ID of the client's first Initial packet, or of
+// its first one after a Retry.
+func NewQUICInitialKeys(version QUICVersion, clientDstConnID []byte, server bool) (*QUICInitialKeys, error) {
+	salt, ok := quicInitialSalts[version]
+	if !ok {
+		return nil, fmt.Errorf("unsupported QUIC version %v", version)
+	}
+	mac := hmac.New(sha256.New, salt)
+	mac.Write(clientDstConnID)
+	label := "client in"
+	if server {
+		label = "server in"
+	}
+	secret := quicExpandLabel(mac.Sum(nil), label, sha256.Size)
+
+	prefix := "quic "
+	if version == QUICVersion2 {
+		prefix = "quicv2 "
+	}
+	k := &QUICInitialKeys{
+		Version: version,
+		Key:     quicExpandLabel(secret, prefix+"key", 16),
+		IV:      quicExpandLabel(secret, prefix+"iv", 12),
+		HP:      quicExpandLabel(secret, prefix+"hp", 16),
+	}
+	block, err := aes.NewCipher(k.Key)
+	if err != nil {
+		return nil, err
+	}
+	if k.aead, err = cipher.NewGCM(block); err != nil {
+		return nil, err
+	}
+	if k.hp, err = aes.NewCipher(k.HP); err != nil {
+		return nil, err
+	}
+	return k, nil
+}
+
+// quicExpandLabel is HKDF-Expand-Label of TLS 1.3 with SHA-256 and an
+// empty context.
+func quicExpandLabel(secret []byte, label string, n int) []byte {
+	label = "tls13 " + label
+	info := []byte{byte(n >> 8), byte(n), byte(len(label))}
+	info = append(info, label...)
+	info = append(info, 0)
+
+	mac := hmac.New(sha256.New, secret)
+	var out, t []byte
+	for i := byte(1); len(out) < n; i++ {
+		mac.Reset()
+		mac.Write(t)
+		mac.Write(info)
+		mac.Write([]byte{i})
+		t = mac.Sum(nil)
+		out = append(out, t...)
+	}
+	return out[:n]
+}
+
+// headerMask returns the header protection mask of the sample of a packet.
+func (k *QUICInitialKeys) headerMask(sample []byte) []byte {
+	mask := make([]byte, aes.BlockSize)
+	k.hp.Encrypt(mask, sample)
+	return mask
+}
+
+// Decrypt removes the header protection of an Initial packet and decrypts
+// it, setting its PacketNumber, Plaintext and Frames.  The packet's data is
+// left as it was.  It returns an error if the packet doesn't authenticate
+// with the keys, or if its frames are malformed, in which case the frames
+// before the malformed one are set.
+func (k *QUICInitialKeys) Decrypt(p *QUICPacket) error {
+	if p.Type != QUICPacketInitial || p.Version != k.Version {
+		return fmt.Errorf("not a %v Initial packet", k.Version)
+	}
+	// The sample follows the longest packet number.
+	if len(p.Protected) < 4+aes.BlockSize {
+		return errors.New("QUIC packet too short to sample")
+	}
+	mask := k.headerMask(p.Protected[4 : 4+aes.BlockSize])
+	header := append([]byte(nil), p.Header...)
+	header[0] ^= mask[0] & 0x0f
+	pnLen := int(header[0]&3) + 1
+	var pn uint64
+	for i := 0; i < pnLen; i++ {
+		b := p.Protected[i] ^ mask[1+i]
+		header = append(header, b)
+		pn = pn<<8 | uint64(b)
+	}
+
+	nonce := append([]byte(nil), k.IV...)
+	for i := 0; i < 8; i++ {
+		nonce[len(nonce)-1-i] ^= byte(pn >> (8 * uint(i)))
+	}
+	plaintext, err := k.aead.Open(nil, nonce, p.Protected[pnLen:], header)
+	if err != nil {
+		return err
+	}
+	p.PacketNumber = pn
+	p.Plaintext = plaintext
+	p.Frames, err = DecodeQUICFrames(plaintext)
+	return err
+}
+
+// DecryptInitial decrypts the client Initial packets of the datagram, with
+// keys derived from their own Destination Connection ID, as those of the
+// client's first flight are.  Packets which don't authenticate, as the
+// server's don't, are left encrypted.  It returns the number of packets
+// decrypted.
+func (q *QUIC) DecryptInitial() int {
+	n := 0
+	for i := range q.Packets {
+		p := &q.Packets[i]
+		if p.Type != QUICPacketInitial || p.Plaintext != nil {
+			continue
+		}
+		k, err := NewQUICInitialKeys(p.Version, p.DstConnID, false)
+		if err != nil {
+			continue
+		}
+		// A packet with malformed frames is still decrypted.
+		k.Decrypt(p)
+		if p.Plaintext != nil {
+			n++
+		}
+	}
+	return n
+}
+
+// ClientHello decrypts the client Initial packets of the datagram, and
+// returns the ClientHello which their CRYPTO frames carry, or nil if the
+// datagram doesn't hold all of it.  Use a QUICCryptoReassembler for
+// ClientHellos which span several datagrams.
+func (q *QUIC) ClientHello() *TLSClientHello {
+	q.DecryptInitial()
+	var r QUICCryptoReassembler
+	for _, p := range q.Packets {
+		if p.Type != QUICPacketInitial {
+			continue
+		}
+		for i := range p.Frames {
+			msgs, err := r.Add(&p.Frames[i])
+			if err != nil {
+				return nil
+			}
+			for _, m := range msgs {
+				if m.ClientHello != nil {
+					return m.ClientHello
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// QUICCryptoReassembler reassembles the CRYPTO frames of one encryption
+// level and direction of a QUIC connection, which can come out of order,
+// and decodes the TLS handshake messages they carry.  The zero value is
+// ready to use.
+type QUICCryptoReassembler struct {
+	// Handshake decodes the messages of the reassembled data.
+	Handshake TLSHandshakeReassembler
+
+	// offset is that of the next data to reassemble, and pending holds the
+	// frames of data after it.
+	offset  uint64
+	pending map[uint64][]byte
+}
+
+// Add takes a frame, and decodes the handshake messages which the data up
+// to the first gap completes.  Frames other than CRYPTO frames are ignored.
+func (r *QUICCryptoReassembler) Add(f *QUICFrame) ([]TLSHandshakeMessage, error) {
+	if f.Type != QUICFrameCrypto {
+		return nil, nil
+	}
+	if f.Offset > r.offset {
+		if r.pending == nil {
+			r.pending = make(map[uint64][]byte)
+		}
+		if len(f.Data) > len(r.pending[f.Offset]) {
+			r.pending[f.Offset] = append([]byte(nil), f.Data...)
+		}
+		return nil, nil
+	}
+	var messages []TLSHandshakeMessage
+	data := f.Data
+	offset := f.Offset
+	for {
+		if end := offset + uint64(len(data)); end > r.offset {
+			msgs, err := r.Handshake.Add(data[r.offset-offset:])
+			messages = append(messages, msgs...)
+			if err != nil {
+				return messages, err
+			}
+			r.offset = end
+		}
+		// Continue with a pending frame which the data reached.
+		found := false
+		for o, d := range r.pending {
+			if o <= r.offset {
+				delete(r.pending, o)
+				offset, data, found = o, d, true
+				break
+			}
+		}
+		if !found {
+			return messages, nil
+		}
+	}
+}
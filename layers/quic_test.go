@@ -0,0 +1,320 @@
+// Copyright 2019 The GoPacket Authors. All rights reserved.
+//
+// Use of this source code is governed by a BSD-style license
+// that can be found in the LICENSE file in the root of the source
+// tree.
+
+package layers
+
+import (
+	"bytes"
+	"encoding/hex"
+	"net"
+	"reflect"
+	"testing"
+
+	"github.com/google/gopacket"
+)
+
+func testHex(t *testing.T, s string) []byte {
+	b, err := hex.DecodeString(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+// The keys of RFC 9001 appendix A.1 and RFC 9369 appendix A.1.
+func TestQUICInitialKeys(t *testing.T) {
+	dcid := testHex(t, "8394c8f03e515708")
+	for _, test := range []struct {
+		version     QUICVersion
+		server      bool
+		key, iv, hp string
+	}{
+		{QUICVersion1, false, "1f369613dd76d5467730efcbe3b1a22d", "fa044b2f42a3fd3b46fb255c", "9f50449e04a0e810283a1e9933adedd2"},
+		{QUICVersion1, true, "cf3a5331653c364c88f0f379b6067e37", "0ac1493ca1905853b0bba03e", "c206b8d9b9f0f37644430b490eeaa314"},
+		{QUICVersion2, false, "8b1a0bc121284290a29e0971b5cd045d", "91f73e2351d8fa91660e909f", "45b95e15235d6f45a6b19cbcb0294ba9"},
+		{QUICVersion2, true, "82db637861d55e1d011f19ea71d5d2a7", "dd13c276499c0249d3310652", "edf6d05c83121201b436e16877593c3a"},
+	} {
+		k, err := NewQUICInitialKeys(test.version, dcid, test.server)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if hex.EncodeToString(k.Key) != test.key || hex.EncodeToString(k.IV) != test.iv || hex.EncodeToString(k.HP) != test.hp {
+			t.Errorf("%v server %v: key %x, iv %x, hp %x", test.version, test.server, k.Key, k.IV, k.HP)
+		}
+	}
+
+	// The header protection sample of RFC 9001 appendix A.2.
+	k, _ := NewQUICInitialKeys(QUICVersion1, dcid, false)
+	if mask := k.headerMask(testHex(t, "d1b1c98dd7689fb8ec11d242b123dc9b")); hex.EncodeToString(mask[:5]) != "437b9aec36" {
+		t.Errorf("mask %x", mask)
+	}
+	if _, err := NewQUICInitialKeys(0xff00001d, dcid, false); err == nil {
+		t.Error("keys of an unknown version")
+	}
+}
+
+// testQUICInitial returns an Initial packet with a 2-byte packet number,
+// protected with the client keys of dcid.
+func testQUICInitial(t *testing.T, version QUICVersion, dcid []byte, pn uint16, plaintext []byte) []byte {
+	k, err := NewQUICInitialKeys(version, dcid, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	typ := byte(0)
+	if version == QUICVersion2 {
+		typ = 1
+	}
+	header := []byte{0xc0 | typ<<4 | 1, byte(version >> 24), byte(version >> 16), byte(version >> 8), byte(version)}
+	header = append(header, byte(len(dcid)))
+	header = append(header, dcid...)
+	header = append(header, 3, 's', 'r', 'c', 0)
+	length := 2 + len(plaintext) + 16
+	header = append(header, 0x40|byte(length>>8), byte(length), byte(pn>>8), byte(pn))
+	pnOffset := len(header) - 2
+
+	nonce := append([]byte(nil), k.IV...)
+	nonce[10] ^= byte(pn >> 8)
+	nonce[11] ^= byte(pn)
+	packet := k.aead.Seal(header, nonce, plaintext, header)
+	mask := k.headerMask(packet[pnOffset+4 : pnOffset+4+16])
+	packet[0] ^= mask[0] & 0x0f
+	packet[pnOffset] ^= mask[1]
+	packet[pnOffset+1] ^= mask[2]
+	return packet
+}
+
+// testQUICCrypto returns a CRYPTO frame.
+func testQUICCrypto(offset int, data []byte) []byte {
+	f := []byte{byte(QUICFrameCrypto), 0x40 | byte(offset>>8), byte(offset), 0x40 | byte(len(data)>>8), byte(len(data))}
+	return append(f, data...)
+}
+
+func testQUICClientHello(t *testing.T) []byte {
+	sni := []byte{0, 14, 0, 0, 11}
+	sni = append(sni, "example.com"...)
+	m := TLSHandshakeMessage{
+		Type: TLSHandshakeClientHello,
+		ClientHello: &TLSClientHello{
+			Version:            0x0303,
+			Random:             make([]byte, 32),
+			CipherSuites:       []TLSCipherSuite{0x1301, 0x1302, 0x1303},
+			CompressionMethods: []uint8{0},
+			Extensions: []TLSExtension{
+				{Type: TLSExtensionServerName, Data: sni},
+				{Type: TLSExtensionALPN, Data: []byte{0, 3, 2, 'h', '3'}},
+				{Type: TLSExtensionSupportedVersions, Data: []byte{2, 3, 4}},
+				{Type: 0x39, Data: make([]byte, 400)}, // quic_transport_parameters
+			},
+		},
+	}
+	b, err := m.serialize(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+func testQUICDatagram(t *testing.T, payload []byte) gopacket.Packet {
+	buf := gopacket.NewSerializeBuffer()
+	ip := &IPv4{Version: 4, TTL: 64, Protocol: IPProtocolUDP, SrcIP: net.IP{10, 0, 0, 1}, DstIP: net.IP{10, 0, 0, 2}}
+	udp := &UDP{SrcPort: 50000, DstPort: 443}
+	udp.SetNetworkLayerForChecksum(ip)
+	err := gopacket.SerializeLayers(buf, gopacket.SerializeOptions{FixLengths: true, ComputeChecksums: true},
+		ip, udp, gopacket.Payload(payload))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return gopacket.NewPacket(buf.Bytes(), LayerTypeIPv4, gopacket.Default)
+}
+
+func TestQUICClientHello(t *testing.T) {
+	for _, version := range []QUICVersion{QUICVersion1, QUICVersion2} {
+		dcid := []byte{1, 2, 3, 4, 5, 6, 7, 8}
+		ch := testQUICClientHello(t)
+		// The CRYPTO frames come out of order, across two coalesced
+		// packets, and the datagram is padded.
+		first := append(testQUICCrypto(300, ch[300:]), 0, 0, 0, byte(QUICFramePing))
+		second := append(testQUICCrypto(0, ch[:300]), make([]byte, 20)...)
+		datagram := testQUICInitial(t, version, dcid, 0, first)
+		datagram = append(datagram, testQUICInitial(t, version, dcid, 1, second)...)
+		datagram = append(datagram, make([]byte, 100)...)
+
+		p := testQUICDatagram(t, datagram)
+		if p.ErrorLayer() != nil {
+			t.Fatal(p.ErrorLayer().Error())
+		}
+		q, ok := p.Layer(LayerTypeQUIC).(*QUIC)
+		if !ok {
+			t.Fatalf("%v: no QUIC layer: %v", version, p)
+		}
+		if len(q.Packets) != 2 || q.Packets[0].Type != QUICPacketInitial || q.Packets[0].Version != version ||
+			!bytes.Equal(q.Packets[1].DstConnID, dcid) || string(q.Packets[1].SrcConnID) != "src" {
+			t.Fatalf("%v: packets %+v", version, q.Packets)
+		}
+		hello := q.ClientHello()
+		if hello == nil {
+			t.Fatalf("%v: no ClientHello", version)
+		}
+		if hello.ServerName != "example.com" || !reflect.DeepEqual(hello.ALPN, []string{"h3"}) {
+			t.Errorf("%v: ClientHello %+v", version, hello)
+		}
+		if got := hello.JA4(TLSJA4QUIC)[:10]; got != "q13d0304h3" {
+			t.Errorf("%v: JA4 %s", version, got)
+		}
+
+		p0 := q.Packets[0]
+		if p0.PacketNumber != 0 || q.Packets[1].PacketNumber != 1 || len(p0.Frames) != 3 {
+			t.Fatalf("%v: first packet %+v", version, p0)
+		}
+		if f := p0.Frames[1]; f.Type != QUICFramePadding || f.PaddingLength != 3 {
+			t.Errorf("%v: padding %+v", version, f)
+		}
+		if p0.Frames[2].Type != QUICFramePing {
+			t.Errorf("%v: frames %+v", version, p0.Frames)
+		}
+	}
+}
+
+func TestQUICDecryptInitial(t *testing.T) {
+	datagram := testQUICInitial(t, QUICVersion1, []byte("client"), 7, make([]byte, 40))
+	q := &QUIC{}
+	if err := q.DecodeFromBytes(datagram, gopacket.NilDecodeFeedback); err != nil {
+		t.Fatal(err)
+	}
+	// Packets only authenticate with the keys of their sender and
+	// connection ID.
+	for _, server := range []bool{true, false} {
+		k, _ := NewQUICInitialKeys(QUICVersion1, []byte("other"), server)
+		if err := k.Decrypt(&q.Packets[0]); err == nil || q.Packets[0].Plaintext != nil {
+			t.Errorf("decrypted with the keys of server %v", server)
+		}
+	}
+	if n := q.DecryptInitial(); n != 1 {
+		t.Fatalf("%d packets decrypted", n)
+	}
+	p := q.Packets[0]
+	if p.PacketNumber != 7 || len(p.Frames) != 1 || p.Frames[0].PaddingLength != 40 || q.ClientHello() != nil {
+		t.Errorf("packet %+v", p)
+	}
+}
+
+func TestQUICCryptoReassembler(t *testing.T) {
+	ch := testQUICClientHello(t)
+	var r QUICCryptoReassembler
+	for _, f := range []QUICFrame{
+		{Type: QUICFrameCrypto, Offset: 200, Data: ch[200:300]},
+		{Type: QUICFramePing},
+		{Type: QUICFrameCrypto, Offset: 250, Data: ch[250:]},
+		{Type: QUICFrameCrypto, Offset: 0, Data: ch[:100]},
+		{Type: QUICFrameCrypto, Offset: 50, Data: ch[50:100]},
+	} {
+		if msgs, err := r.Add(&f); len(msgs) != 0 || err != nil {
+			t.Fatalf("early messages %v, %v", msgs, err)
+		}
+	}
+	msgs, err := r.Add(&QUICFrame{Type: QUICFrameCrypto, Offset: 90, Data: ch[90:210]})
+	if err != nil || len(msgs) != 1 || msgs[0].ClientHello == nil || msgs[0].ClientHello.ServerName != "example.com" {
+		t.Errorf("messages %v, %v", msgs, err)
+	}
+}
+
+func TestQUICHeaders(t *testing.T) {
+	q := &QUIC{}
+	// A Version Negotiation packet.
+	vn := []byte{0x80, 0, 0, 0, 0, 1, 'a', 2, 'b', 'c', 0, 0, 0, 1, 0x6b, 0x33, 0x43, 0xcf}
+	if err := q.DecodeFromBytes(vn, gopacket.NilDecodeFeedback); err != nil {
+		t.Fatal(err)
+	}
+	if p := q.Packets[0]; p.Type != QUICPacketVersionNegotiation || string(p.DstConnID) != "a" || string(p.SrcConnID) != "bc" ||
+		!reflect.DeepEqual(p.SupportedVersions, []QUICVersion{QUICVersion1, QUICVersion2}) {
+		t.Errorf("version negotiation %+v", p)
+	}
+
+	// A v2 Retry packet.
+	retry := []byte{0xc0, 0x6b, 0x33, 0x43, 0xcf, 0, 1, 'x', 't', 'o', 'k'}
+	retry = append(retry, bytes.Repeat([]byte{0xaa}, 16)...)
+	if err := q.DecodeFromBytes(retry, gopacket.NilDecodeFeedback); err != nil {
+		t.Fatal(err)
+	}
+	if p := q.Packets[0]; p.Type != QUICPacketRetry || string(p.Token) != "tok" || len(p.RetryIntegrityTag) != 16 {
+		t.Errorf("retry %+v", p)
+	}
+
+	// A Handshake packet coalesced with a short header packet.
+	data := []byte{0xe0, 0, 0, 0, 1, 0, 0, 3, 1, 2, 3, 0x41, 2, 3, 4}
+	if err := q.DecodeFromBytes(data, gopacket.NilDecodeFeedback); err != nil {
+		t.Fatal(err)
+	}
+	if len(q.Packets) != 2 || q.Packets[0].Type != QUICPacketHandshake || q.Packets[0].Length != 3 ||
+		q.Packets[1].Type != QUICPacket1RTT || !bytes.Equal(q.Packets[1].Protected, []byte{2, 3, 4}) {
+		t.Errorf("packets %+v", q.Packets)
+	}
+
+	// An unknown version.
+	if err := q.DecodeFromBytes([]byte{0xc0, 0xff, 0, 0, 0x1d, 0, 0, 9}, gopacket.NilDecodeFeedback); err != nil || q.Packets[0].Type != QUICPacketUnknown {
+		t.Errorf("unknown version %+v, %v", q.Packets, err)
+	}
+
+	for _, data := range [][]byte{
+		{0xc0, 0, 0, 0, 1, 0, 0, 0x44},
+		{0xc0, 0, 0, 0, 1, 5, 1},
+		{0x00, 1, 2},
+		{0x80, 0, 0, 0, 0, 0, 0, 1, 2},
+	} {
+		if err := q.DecodeFromBytes(data, gopacket.NilDecodeFeedback); err == nil {
+			t.Errorf("%x: no error", data)
+		}
+	}
+}
+
+func TestQUICNotQUIC(t *testing.T) {
+	for _, payload := range [][]byte{
+		// A DTLS 1.2 handshake record.
+		{0x16, 0xfe, 0xfd, 0, 0, 0, 0, 0, 0, 0, 0, 0, 3, 1, 0, 0},
+		[]byte("hello"),
+	} {
+		p := testQUICDatagram(t, payload)
+		if p.ErrorLayer() != nil || p.Layer(LayerTypeQUIC) != nil || p.ApplicationLayer() == nil ||
+			p.ApplicationLayer().LayerType() != gopacket.LayerTypePayload {
+			t.Errorf("%q: %v", payload, p)
+		}
+	}
+
+	// A 1-RTT packet long enough to be sent is QUIC.
+	p := testQUICDatagram(t, append([]byte{0x41}, make([]byte, 24)...))
+	q, ok := p.Layer(LayerTypeQUIC).(*QUIC)
+	if !ok {
+		t.Fatalf("no QUIC layer: %v", p)
+	}
+	if got := q.Packets[0].String(); got != "1-RTT, 24 protected bytes" {
+		t.Errorf("1-RTT packet %q", got)
+	}
+}
+
+func TestDecodeQUICFrames(t *testing.T) {
+	data := []byte{
+		// An ACK of 10-8, 5 and 2-1, with ECN counts.
+		0x03, 10, 0x40, 0x20, 2, 2, 1, 0, 1, 1, 7, 8, 9,
+		// A CONNECTION_CLOSE frame.
+		0x1c, 0x0a, 0x06, 3, 'b', 'a', 'd',
+	}
+	frames, err := DecodeQUICFrames(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []QUICFrame{
+		{Type: QUICFrameACKECN, ACKDelay: 0x20, ACKRanges: []QUICACKRange{{8, 10}, {5, 5}, {1, 2}}, ECT0: 7, ECT1: 8, ECNCE: 9},
+		{Type: QUICFrameConnectionClose, ErrorCode: 10, FrameType: QUICFrameCrypto, Reason: "bad"},
+	}
+	if !reflect.DeepEqual(frames, want) {
+		t.Errorf("frames %+v, want %+v", frames, want)
+	}
+	for _, data := range [][]byte{{0x02, 1, 0, 0, 2}, {0x06, 0, 5, 1}, {0x08, 0}} {
+		if _, err := DecodeQUICFrames(data); err == nil {
+			t.Errorf("%x: no error", data)
+		}
+	}
+}
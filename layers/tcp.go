@@ -26,6 +26,7 @@ type TCP struct {
 	FIN, SYN, RST, PSH, ACK, URG, ECE, CWR, NS bool
 	Window                                     uint16
 	Checksum                                   uint16
+	ChecksumStatus                             gopacket.ChecksumStatus
 	Urgent                                     uint16
 	sPort, dPort                               []byte
 	Options                                    []TCPOption
@@ -54,6 +55,11 @@ const (
 	TCPOptionKindCCEcho                          = 13 // obsolete
 	TCPOptionKindAltChecksum                     = 14 // len = 3, obsolete
 	TCPOptionKindAltChecksumData                 = 15 // len = n, obsolete
+	TCPOptionKindTCPAO                           = 29 // len = n, RFC 5925
+	TCPOptionKindMPTCP                           = 30 // len = n, RFC 8684
+	TCPOptionKindFastOpen                        = 34 // len = 2 or 6-18, RFC 7413
+	TCPOptionKindExperiment1                     = 253
+	TCPOptionKindExperiment2                     = 254
 )
 
 func (k TCPOptionKind) String() string {
@@ -90,6 +96,16 @@ func (k TCPOptionKind) String() string {
 		return "AltChecksum"
 	case TCPOptionKindAltChecksumData:
 		return "AltChecksumData"
+	case TCPOptionKindTCPAO:
+		return "TCPAO"
+	case TCPOptionKindMPTCP:
+		return "MPTCP"
+	case TCPOptionKindFastOpen:
+		return "FastOpen"
+	case TCPOptionKindExperiment1:
+		return "Experiment1"
+	case TCPOptionKindExperiment2:
+		return "Experiment2"
 	default:
 		return fmt.Sprintf("Unknown(%d)", k)
 	}
@@ -108,18 +124,18 @@ func (t TCPOption) String() string {
 	}
 	switch t.OptionType {
 	case TCPOptionKindMSS:
-		return fmt.Sprintf("TCPOption(%s:%v%s)",
-			t.OptionType,
-			binary.BigEndian.Uint16(t.OptionData),
-			hd)
+		if mss, err := t.MSS(); err == nil {
+			return fmt.Sprintf("TCPOption(%s:%v%s)", t.OptionType, mss, hd)
+		}
 
 	case TCPOptionKindTimestamps:
-		if len(t.OptionData) == 8 {
-			return fmt.Sprintf("TCPOption(%s:%v/%v%s)",
-				t.OptionType,
-				binary.BigEndian.Uint32(t.OptionData[:4]),
-				binary.BigEndian.Uint32(t.OptionData[4:8]),
-				hd)
+		if val, echo, err := t.Timestamps(); err == nil {
+			return fmt.Sprintf("TCPOption(%s:%v/%v%s)", t.OptionType, val, echo, hd)
+		}
+
+	case TCPOptionKindMPTCP:
+		if st, err := t.MPTCPSubtype(); err == nil {
+			return fmt.Sprintf("TCPOption(%s:%v%s)", t.OptionType, st, hd)
 		}
 	}
 	return fmt.Sprintf("TCPOption(%s:%s)", t.OptionType, hd)
@@ -147,6 +163,9 @@ func (t *TCP) SerializeTo(b gopacket.SerializeBuffer, opts gopacket.SerializeOpt
 		}
 		t.DataOffset = uint8((len(t.Padding) + optionLength + 20) / 4)
 	}
+	if optionLength+len(t.Padding) > 40 {
+		return fmt.Errorf("TCP options length %d exceeds 40 bytes", optionLength+len(t.Padding))
+	}
 	bytes, err := b.PrependBytes(20 + optionLength + len(t.Padding))
 	if err != nil {
 		return err
@@ -192,6 +211,14 @@ func (t *TCP) ComputeChecksum() (uint16, error) {
 	return t.computeChecksum(append(t.Contents, t.Payload...), IPProtocolTCP)
 }
 
+// VerifyChecksum implements gopacket.ChecksumVerifier, checking the TCP
+// checksum over the pseudo-header of network, which must be an *IPv4 or
+// *IPv6.
+func (t *TCP) VerifyChecksum(network gopacket.NetworkLayer) gopacket.ChecksumStatus {
+	t.ChecksumStatus = verifyTCPIPChecksum(network, IPProtocolTCP, len(t.Contents)+len(t.Payload), t.Checksum, t.Contents, t.Payload)
+	return t.ChecksumStatus
+}
+
 func (t *TCP) flagsAndOffset() uint16 {
 	f := uint16(t.DataOffset) << 12
 	if t.FIN {
@@ -247,6 +274,7 @@ func (tcp *TCP) DecodeFromBytes(data []byte, df gopacket.DecodeFeedback) error {
 	tcp.NS = data[12]&0x01 != 0
 	tcp.Window = binary.BigEndian.Uint16(data[14:16])
 	tcp.Checksum = binary.BigEndian.Uint16(data[16:18])
+	tcp.ChecksumStatus = gopacket.ChecksumUnverified
 	tcp.Urgent = binary.BigEndian.Uint16(data[18:20])
 	if tcp.Options == nil {
 		// Pre-allocate to avoid allocating a slice.
@@ -318,7 +346,13 @@ func decodeTCP(data []byte, p gopacket.PacketBuilder) error {
 		return err
 	}
 	if p.DecodeOptions().DecodeStreamsAsDatagrams {
-		return p.NextDecoder(tcp.NextLayerType())
+		lt := tcp.NextLayerType()
+		// HTTP isn't in the port table, so that DecodingLayerParsers keep
+		// decoding it as payload.
+		if lt == gopacket.LayerTypePayload && (tcp.SrcPort.isHTTP() || tcp.DstPort.isHTTP()) {
+			lt = LayerTypeHTTP
+		}
+		return p.NextDecoder(lt)
 	} else {
 		return p.NextDecoder(gopacket.LayerTypePayload)
 	}
@@ -0,0 +1,678 @@
+// Copyright 2019 The GoPacket Authors. All rights reserved.
+//
+// Use of this source code is governed by a BSD-style license
+// that can be found in the LICENSE file in the root of the source
+// tree.
+
+package layers
+
+import (
+	"encoding/binary"
+	"fmt"
+	"net"
+)
+
+// tcpFastOpenExperimentID is the experiment identifier of TCP Fast Open
+// cookies sent in the experimental option 254 before kind 34 was assigned.
+const tcpFastOpenExperimentID = 0xf989
+
+// newTCPOption returns an option of the given kind and data with its length
+// set.
+func newTCPOption(kind TCPOptionKind, data []byte) TCPOption {
+	return TCPOption{OptionType: kind, OptionLength: uint8(len(data) + 2), OptionData: data}
+}
+
+// check returns an error if t isn't of the given kind or if its data length
+// is not valid.
+func (t TCPOption) check(kind TCPOptionKind, valid bool) error {
+	if t.OptionType != kind {
+		return fmt.Errorf("TCP option is %v, not %v", t.OptionType, kind)
+	}
+	if !valid {
+		return fmt.Errorf("Invalid TCP %v option length %d", t.OptionType, len(t.OptionData)+2)
+	}
+	return nil
+}
+
+// Option returns the first option of the given kind, or nil if there is
+// none.
+func (t *TCP) Option(kind TCPOptionKind) *TCPOption {
+	for i := range t.Options {
+		if t.Options[i].OptionType == kind {
+			return &t.Options[i]
+		}
+	}
+	return nil
+}
+
+// NewTCPOptionMSS returns a maximum segment size option.
+func NewTCPOptionMSS(mss uint16) TCPOption {
+	data := make([]byte, 2)
+	binary.BigEndian.PutUint16(data, mss)
+	return newTCPOption(TCPOptionKindMSS, data)
+}
+
+// MSS returns the maximum segment size of an MSS option.
+func (t TCPOption) MSS() (uint16, error) {
+	if err := t.check(TCPOptionKindMSS, len(t.OptionData) == 2); err != nil {
+		return 0, err
+	}
+	return binary.BigEndian.Uint16(t.OptionData), nil
+}
+
+// NewTCPOptionWindowScale returns a window scale option.
+func NewTCPOptionWindowScale(shift uint8) TCPOption {
+	return newTCPOption(TCPOptionKindWindowScale, []byte{shift})
+}
+
+// WindowScale returns the shift count of a window scale option.
+func (t TCPOption) WindowScale() (uint8, error) {
+	if err := t.check(TCPOptionKindWindowScale, len(t.OptionData) == 1); err != nil {
+		return 0, err
+	}
+	return t.OptionData[0], nil
+}
+
+// NewTCPOptionSACKPermitted returns a SACK-permitted option.
+func NewTCPOptionSACKPermitted() TCPOption {
+	return newTCPOption(TCPOptionKindSACKPermitted, nil)
+}
+
+// TCPSACKBlock is a block of data received out of order, reported by a SACK
+// option.  Left is the first sequence number of the block and Right the
+// sequence number just after it.
+type TCPSACKBlock struct {
+	Left, Right uint32
+}
+
+// NewTCPOptionSACK returns a SACK option reporting the given blocks.
+func NewTCPOptionSACK(blocks ...TCPSACKBlock) TCPOption {
+	data := make([]byte, 8*len(blocks))
+	for i, b := range blocks {
+		binary.BigEndian.PutUint32(data[8*i:], b.Left)
+		binary.BigEndian.PutUint32(data[8*i+4:], b.Right)
+	}
+	return newTCPOption(TCPOptionKindSACK, data)
+}
+
+// SACKBlocks returns the blocks of a SACK option.
+func (t TCPOption) SACKBlocks() ([]TCPSACKBlock, error) {
+	n := len(t.OptionData)
+	if err := t.check(TCPOptionKindSACK, n > 0 && n%8 == 0); err != nil {
+		return nil, err
+	}
+	blocks := make([]TCPSACKBlock, 0, n/8)
+	for d := t.OptionData; len(d) > 0; d = d[8:] {
+		blocks = append(blocks, TCPSACKBlock{binary.BigEndian.Uint32(d), binary.BigEndian.Uint32(d[4:])})
+	}
+	return blocks, nil
+}
+
+// NewTCPOptionTimestamps returns a timestamps option.
+func NewTCPOptionTimestamps(val, echo uint32) TCPOption {
+	data := make([]byte, 8)
+	binary.BigEndian.PutUint32(data, val)
+	binary.BigEndian.PutUint32(data[4:], echo)
+	return newTCPOption(TCPOptionKindTimestamps, data)
+}
+
+// Timestamps returns the timestamp value and echo reply of a timestamps
+// option.
+func (t TCPOption) Timestamps() (val, echo uint32, err error) {
+	if err = t.check(TCPOptionKindTimestamps, len(t.OptionData) == 8); err != nil {
+		return
+	}
+	return binary.BigEndian.Uint32(t.OptionData), binary.BigEndian.Uint32(t.OptionData[4:]), nil
+}
+
+// NewTCPOptionFastOpen returns a TCP Fast Open option carrying cookie, or
+// requesting one if cookie is empty.
+func NewTCPOptionFastOpen(cookie []byte) TCPOption {
+	return newTCPOption(TCPOptionKindFastOpen, cookie)
+}
+
+// FastOpenCookie returns the cookie of a TCP Fast Open option, which is
+// empty for a cookie request.  Both the assigned kind 34 and the
+// experimental option 254 with the experiment ID 0xf989 are understood.
+func (t TCPOption) FastOpenCookie() ([]byte, error) {
+	data := t.OptionData
+	if t.OptionType == TCPOptionKindExperiment2 && len(data) >= 2 && binary.BigEndian.Uint16(data) == tcpFastOpenExperimentID {
+		data = data[2:]
+	} else if t.OptionType != TCPOptionKindFastOpen {
+		return nil, t.check(TCPOptionKindFastOpen, false)
+	}
+	n := len(data)
+	if n != 0 && (n < 4 || n > 16 || n%2 != 0) {
+		return nil, fmt.Errorf("Invalid TCP Fast Open cookie length %d", n)
+	}
+	return data, nil
+}
+
+// TCPAuthOption is the TCP Authentication Option (TCP-AO), see RFC 5925.
+type TCPAuthOption struct {
+	KeyID      uint8
+	RNextKeyID uint8
+	MAC        []byte
+}
+
+// DecodeFromBytes decodes the data of a TCP-AO option.
+func (a *TCPAuthOption) DecodeFromBytes(data []byte) error {
+	if len(data) < 2 {
+		return fmt.Errorf("Invalid TCP-AO option length %d", len(data)+2)
+	}
+	a.KeyID = data[0]
+	a.RNextKeyID = data[1]
+	a.MAC = data[2:]
+	return nil
+}
+
+// Encode returns the data of a TCP-AO option.
+func (a *TCPAuthOption) Encode() []byte {
+	return append([]byte{a.KeyID, a.RNextKeyID}, a.MAC...)
+}
+
+// Option returns a as a TCP option.
+func (a *TCPAuthOption) Option() TCPOption {
+	return newTCPOption(TCPOptionKindTCPAO, a.Encode())
+}
+
+// TCPMPTCPSubtype is the subtype of a Multipath TCP option.
+type TCPMPTCPSubtype uint8
+
+// Multipath TCP option subtypes, as defined in RFC 8684.
+const (
+	TCPMPTCPSubtypeCapable    TCPMPTCPSubtype = 0
+	TCPMPTCPSubtypeJoin       TCPMPTCPSubtype = 1
+	TCPMPTCPSubtypeDSS        TCPMPTCPSubtype = 2
+	TCPMPTCPSubtypeAddAddr    TCPMPTCPSubtype = 3
+	TCPMPTCPSubtypeRemoveAddr TCPMPTCPSubtype = 4
+	TCPMPTCPSubtypePrio       TCPMPTCPSubtype = 5
+	TCPMPTCPSubtypeFail       TCPMPTCPSubtype = 6
+	TCPMPTCPSubtypeFastClose  TCPMPTCPSubtype = 7
+	TCPMPTCPSubtypeTCPRST     TCPMPTCPSubtype = 8
+)
+
+func (s TCPMPTCPSubtype) String() string {
+	switch s {
+	case TCPMPTCPSubtypeCapable:
+		return "MP_CAPABLE"
+	case TCPMPTCPSubtypeJoin:
+		return "MP_JOIN"
+	case TCPMPTCPSubtypeDSS:
+		return "DSS"
+	case TCPMPTCPSubtypeAddAddr:
+		return "ADD_ADDR"
+	case TCPMPTCPSubtypeRemoveAddr:
+		return "REMOVE_ADDR"
+	case TCPMPTCPSubtypePrio:
+		return "MP_PRIO"
+	case TCPMPTCPSubtypeFail:
+		return "MP_FAIL"
+	case TCPMPTCPSubtypeFastClose:
+		return "MP_FASTCLOSE"
+	case TCPMPTCPSubtypeTCPRST:
+		return "MP_TCPRST"
+	default:
+		return fmt.Sprintf("Unknown(%d)", uint8(s))
+	}
+}
+
+// MPTCPSubtype returns the subtype of a Multipath TCP option, whose data is
+// then decoded by the type of that subtype.
+func (t TCPOption) MPTCPSubtype() (TCPMPTCPSubtype, error) {
+	if err := t.check(TCPOptionKindMPTCP, len(t.OptionData) > 0); err != nil {
+		return 0, err
+	}
+	return TCPMPTCPSubtype(t.OptionData[0] >> 4), nil
+}
+
+// checkMPTCP returns an error if data isn't of the given subtype or if its
+// length is not valid.
+func checkMPTCP(data []byte, subtype TCPMPTCPSubtype, valid bool) error {
+	if len(data) > 0 && TCPMPTCPSubtype(data[0]>>4) != subtype {
+		return fmt.Errorf("MPTCP option is %v, not %v", TCPMPTCPSubtype(data[0]>>4), subtype)
+	}
+	if len(data) == 0 || !valid {
+		return fmt.Errorf("Invalid MPTCP %v option length %d", subtype, len(data)+2)
+	}
+	return nil
+}
+
+// MP_CAPABLE flags.
+const (
+	TCPMPCapableFlagChecksum        = 0x80 // A: checksums are required
+	TCPMPCapableFlagExtensibility   = 0x40 // B
+	TCPMPCapableFlagNoSourceAddress = 0x20 // C: no subflows to the source address
+	TCPMPCapableFlagHMACSHA256      = 0x01 // H
+)
+
+// TCPMPCapable is the MP_CAPABLE option.  The keys, data-level length and
+// checksum are present depending on the segment of the handshake the option
+// is sent in, and each of them is only encoded if the ones before it are.
+type TCPMPCapable struct {
+	Version        uint8
+	Flags          uint8
+	HasSenderKey   bool
+	SenderKey      uint64
+	HasReceiverKey bool
+	ReceiverKey    uint64
+	HasDataLength  bool
+	DataLength     uint16
+	HasChecksum    bool
+	Checksum       uint16
+}
+
+// DecodeFromBytes decodes the data of an MP_CAPABLE option.
+func (c *TCPMPCapable) DecodeFromBytes(data []byte) error {
+	n := len(data)
+	if err := checkMPTCP(data, TCPMPTCPSubtypeCapable, n == 2 || n == 10 || n == 18 || n == 20 || n == 22); err != nil {
+		return err
+	}
+	*c = TCPMPCapable{Version: data[0] & 0x0f, Flags: data[1]}
+	if c.HasSenderKey = n >= 10; c.HasSenderKey {
+		c.SenderKey = binary.BigEndian.Uint64(data[2:])
+	}
+	if c.HasReceiverKey = n >= 18; c.HasReceiverKey {
+		c.ReceiverKey = binary.BigEndian.Uint64(data[10:])
+	}
+	if c.HasDataLength = n >= 20; c.HasDataLength {
+		c.DataLength = binary.BigEndian.Uint16(data[18:])
+	}
+	if c.HasChecksum = n == 22; c.HasChecksum {
+		c.Checksum = binary.BigEndian.Uint16(data[20:])
+	}
+	return nil
+}
+
+// Encode returns the data of an MP_CAPABLE option.
+func (c *TCPMPCapable) Encode() []byte {
+	data := make([]byte, 22)
+	data[0] = uint8(TCPMPTCPSubtypeCapable)<<4 | c.Version&0x0f
+	data[1] = c.Flags
+	binary.BigEndian.PutUint64(data[2:], c.SenderKey)
+	binary.BigEndian.PutUint64(data[10:], c.ReceiverKey)
+	binary.BigEndian.PutUint16(data[18:], c.DataLength)
+	binary.BigEndian.PutUint16(data[20:], c.Checksum)
+	switch {
+	case !c.HasSenderKey:
+		return data[:2]
+	case !c.HasReceiverKey:
+		return data[:10]
+	case !c.HasDataLength:
+		return data[:18]
+	case !c.HasChecksum:
+		return data[:20]
+	}
+	return data
+}
+
+// Option returns c as a TCP option.
+func (c *TCPMPCapable) Option() TCPOption {
+	return newTCPOption(TCPOptionKindMPTCP, c.Encode())
+}
+
+// TCPMPJoin is the MP_JOIN option.  Its form depends on the segment of the
+// subflow handshake: the SYN carries the receiver's token and the sender's
+// random number, the SYN/ACK an 8 byte truncated HMAC and the random number,
+// and the third ACK the full 20 byte HMAC.
+type TCPMPJoin struct {
+	Backup        bool
+	AddressID     uint8
+	ReceiverToken uint32
+	SenderRandom  uint32
+	HMAC          []byte
+}
+
+// DecodeFromBytes decodes the data of an MP_JOIN option.
+func (j *TCPMPJoin) DecodeFromBytes(data []byte) error {
+	n := len(data)
+	if err := checkMPTCP(data, TCPMPTCPSubtypeJoin, n == 10 || n == 14 || n == 22); err != nil {
+		return err
+	}
+	*j = TCPMPJoin{}
+	switch n {
+	case 10:
+		j.Backup = data[0]&0x01 != 0
+		j.AddressID = data[1]
+		j.ReceiverToken = binary.BigEndian.Uint32(data[2:])
+		j.SenderRandom = binary.BigEndian.Uint32(data[6:])
+	case 14:
+		j.Backup = data[0]&0x01 != 0
+		j.AddressID = data[1]
+		j.HMAC = data[2:10]
+		j.SenderRandom = binary.BigEndian.Uint32(data[10:])
+	case 22:
+		j.HMAC = data[2:]
+	}
+	return nil
+}
+
+// Encode returns the data of an MP_JOIN option, in the form of the third ACK
+// if HMAC has 20 bytes, of the SYN/ACK if it has 8 bytes, and of the SYN
+// otherwise.
+func (j *TCPMPJoin) Encode() []byte {
+	first := uint8(TCPMPTCPSubtypeJoin) << 4
+	if j.Backup {
+		first |= 0x01
+	}
+	switch len(j.HMAC) {
+	case 20:
+		return append([]byte{uint8(TCPMPTCPSubtypeJoin) << 4, 0}, j.HMAC...)
+	case 8:
+		data := append([]byte{first, j.AddressID}, j.HMAC...)
+		return appendUint32(data, j.SenderRandom)
+	}
+	data := make([]byte, 10)
+	data[0], data[1] = first, j.AddressID
+	binary.BigEndian.PutUint32(data[2:], j.ReceiverToken)
+	binary.BigEndian.PutUint32(data[6:], j.SenderRandom)
+	return data
+}
+
+// Option returns j as a TCP option.
+func (j *TCPMPJoin) Option() TCPOption {
+	return newTCPOption(TCPOptionKindMPTCP, j.Encode())
+}
+
+// DSS flags.
+const (
+	tcpMPDSSFlagDataFIN   = 0x10 // F
+	tcpMPDSSFlagDSN64     = 0x08 // m
+	tcpMPDSSFlagMapping   = 0x04 // M
+	tcpMPDSSFlagDataACK64 = 0x02 // a
+	tcpMPDSSFlagDataACK   = 0x01 // A
+)
+
+// TCPMPDataSequenceMapping maps a range of subflow sequence numbers to the
+// data sequence space of a Multipath TCP connection.
+type TCPMPDataSequenceMapping struct {
+	// DSN is the data sequence number, sent as 8 bytes if DSN64 is set and
+	// as its low 4 bytes otherwise.
+	DSN   uint64
+	DSN64 bool
+	// SubflowSeq is relative to the initial sequence number of the subflow.
+	SubflowSeq  uint32
+	DataLength  uint16
+	HasChecksum bool
+	Checksum    uint16
+}
+
+// TCPMPDSS is the Data Sequence Signal option, carrying a data-level
+// acknowledgement and/or a data sequence mapping.
+type TCPMPDSS struct {
+	DataFIN    bool
+	HasDataACK bool
+	// DataACK is sent as 8 bytes if DataACK64 is set and as its low 4 bytes
+	// otherwise.
+	DataACK   uint64
+	DataACK64 bool
+	// Mapping is nil if the option carries no mapping.
+	Mapping *TCPMPDataSequenceMapping
+}
+
+// DecodeFromBytes decodes the data of a DSS option.
+func (d *TCPMPDSS) DecodeFromBytes(data []byte) error {
+	if err := checkMPTCP(data, TCPMPTCPSubtypeDSS, len(data) >= 2); err != nil {
+		return err
+	}
+	flags := data[1]
+	*d = TCPMPDSS{DataFIN: flags&tcpMPDSSFlagDataFIN != 0}
+	rest := data[2:]
+	invalid := fmt.Errorf("Invalid MPTCP DSS option length %d for flags %#x", len(data)+2, flags)
+	if d.HasDataACK = flags&tcpMPDSSFlagDataACK != 0; d.HasDataACK {
+		if d.DataACK64 = flags&tcpMPDSSFlagDataACK64 != 0; d.DataACK64 {
+			if len(rest) < 8 {
+				return invalid
+			}
+			d.DataACK, rest = binary.BigEndian.Uint64(rest), rest[8:]
+		} else {
+			if len(rest) < 4 {
+				return invalid
+			}
+			d.DataACK, rest = uint64(binary.BigEndian.Uint32(rest)), rest[4:]
+		}
+	}
+	if flags&tcpMPDSSFlagMapping != 0 {
+		m := &TCPMPDataSequenceMapping{DSN64: flags&tcpMPDSSFlagDSN64 != 0}
+		n := 10
+		if m.DSN64 {
+			n = 14
+		}
+		if len(rest) != n && len(rest) != n+2 {
+			return invalid
+		}
+		if m.DSN64 {
+			m.DSN, rest = binary.BigEndian.Uint64(rest), rest[8:]
+		} else {
+			m.DSN, rest = uint64(binary.BigEndian.Uint32(rest)), rest[4:]
+		}
+		m.SubflowSeq = binary.BigEndian.Uint32(rest)
+		m.DataLength = binary.BigEndian.Uint16(rest[4:])
+		if m.HasChecksum = len(rest) == 8; m.HasChecksum {
+			m.Checksum = binary.BigEndian.Uint16(rest[6:])
+		}
+		d.Mapping, rest = m, nil
+	}
+	if len(rest) != 0 {
+		return invalid
+	}
+	return nil
+}
+
+// Encode returns the data of a DSS option.
+func (d *TCPMPDSS) Encode() []byte {
+	data := []byte{uint8(TCPMPTCPSubtypeDSS) << 4, 0}
+	if d.DataFIN {
+		data[1] |= tcpMPDSSFlagDataFIN
+	}
+	if d.HasDataACK {
+		data[1] |= tcpMPDSSFlagDataACK
+		if d.DataACK64 {
+			data[1] |= tcpMPDSSFlagDataACK64
+			data = appendUint64(data, d.DataACK)
+		} else {
+			data = appendUint32(data, uint32(d.DataACK))
+		}
+	}
+	if m := d.Mapping; m != nil {
+		data[1] |= tcpMPDSSFlagMapping
+		if m.DSN64 {
+			data[1] |= tcpMPDSSFlagDSN64
+			data = appendUint64(data, m.DSN)
+		} else {
+			data = appendUint32(data, uint32(m.DSN))
+		}
+		data = appendUint32(data, m.SubflowSeq)
+		data = append(data, uint8(m.DataLength>>8), uint8(m.DataLength))
+		if m.HasChecksum {
+			data = append(data, uint8(m.Checksum>>8), uint8(m.Checksum))
+		}
+	}
+	return data
+}
+
+// Option returns d as a TCP option.
+func (d *TCPMPDSS) Option() TCPOption {
+	return newTCPOption(TCPOptionKindMPTCP, d.Encode())
+}
+
+func appendUint64(b []byte, v uint64) []byte {
+	return appendUint32(appendUint32(b, uint32(v>>32)), uint32(v))
+}
+
+// TCPMPAddAddr is the ADD_ADDR option advertising an additional address of
+// the sender.  Port is zero if the option carries no port.  The truncated
+// HMAC is 8 bytes, present unless Echo is set.
+type TCPMPAddAddr struct {
+	Echo      bool
+	AddressID uint8
+	Address   net.IP
+	Port      uint16
+	HMAC      []byte
+}
+
+// DecodeFromBytes decodes the data of an ADD_ADDR option.
+func (a *TCPMPAddAddr) DecodeFromBytes(data []byte) error {
+	if err := checkMPTCP(data, TCPMPTCPSubtypeAddAddr, len(data) >= 2); err != nil {
+		return err
+	}
+	*a = TCPMPAddAddr{Echo: data[0]&0x01 != 0, AddressID: data[1]}
+	invalid := fmt.Errorf("Invalid MPTCP ADD_ADDR option length %d", len(data)+2)
+	rest := data[2:]
+	if !a.Echo {
+		if len(rest) < 8 {
+			return invalid
+		}
+		a.HMAC, rest = rest[len(rest)-8:], rest[:len(rest)-8]
+	}
+	switch len(rest) {
+	case 4, 6:
+		a.Address = net.IP(rest[:4])
+	case 16, 18:
+		a.Address = net.IP(rest[:16])
+	default:
+		return invalid
+	}
+	if len(rest)%4 == 2 {
+		a.Port = binary.BigEndian.Uint16(rest[len(rest)-2:])
+	}
+	return nil
+}
+
+// Encode returns the data of an ADD_ADDR option.
+func (a *TCPMPAddAddr) Encode() []byte {
+	data := []byte{uint8(TCPMPTCPSubtypeAddAddr) << 4, a.AddressID}
+	if a.Echo {
+		data[0] |= 0x01
+	}
+	if ip4 := a.Address.To4(); ip4 != nil {
+		data = append(data, ip4...)
+	} else {
+		data = append(data, a.Address.To16()...)
+	}
+	if a.Port != 0 {
+		data = append(data, uint8(a.Port>>8), uint8(a.Port))
+	}
+	if !a.Echo {
+		hmac := make([]byte, 8)
+		copy(hmac, a.HMAC)
+		data = append(data, hmac...)
+	}
+	return data
+}
+
+// Option returns a as a TCP option.
+func (a *TCPMPAddAddr) Option() TCPOption {
+	return newTCPOption(TCPOptionKindMPTCP, a.Encode())
+}
+
+// TCPMPRemoveAddr is the REMOVE_ADDR option withdrawing addresses.
+type TCPMPRemoveAddr struct {
+	AddressIDs []uint8
+}
+
+// DecodeFromBytes decodes the data of a REMOVE_ADDR option.
+func (r *TCPMPRemoveAddr) DecodeFromBytes(data []byte) error {
+	if err := checkMPTCP(data, TCPMPTCPSubtypeRemoveAddr, len(data) >= 2); err != nil {
+		return err
+	}
+	r.AddressIDs = data[1:]
+	return nil
+}
+
+// Encode returns the data of a REMOVE_ADDR option.
+func (r *TCPMPRemoveAddr) Encode() []byte {
+	return append([]byte{uint8(TCPMPTCPSubtypeRemoveAddr) << 4}, r.AddressIDs...)
+}
+
+// Option returns r as a TCP option.
+func (r *TCPMPRemoveAddr) Option() TCPOption {
+	return newTCPOption(TCPOptionKindMPTCP, r.Encode())
+}
+
+// TCPMPPrio is the MP_PRIO option changing the backup priority of a
+// subflow.  The address ID was only sent by version 0 of the protocol.
+type TCPMPPrio struct {
+	Backup       bool
+	HasAddressID bool
+	AddressID    uint8
+}
+
+// DecodeFromBytes decodes the data of an MP_PRIO option.
+func (p *TCPMPPrio) DecodeFromBytes(data []byte) error {
+	if err := checkMPTCP(data, TCPMPTCPSubtypePrio, len(data) == 1 || len(data) == 2); err != nil {
+		return err
+	}
+	*p = TCPMPPrio{Backup: data[0]&0x01 != 0}
+	if p.HasAddressID = len(data) == 2; p.HasAddressID {
+		p.AddressID = data[1]
+	}
+	return nil
+}
+
+// Encode returns the data of an MP_PRIO option.
+func (p *TCPMPPrio) Encode() []byte {
+	data := []byte{uint8(TCPMPTCPSubtypePrio) << 4}
+	if p.Backup {
+		data[0] |= 0x01
+	}
+	if p.HasAddressID {
+		data = append(data, p.AddressID)
+	}
+	return data
+}
+
+// Option returns p as a TCP option.
+func (p *TCPMPPrio) Option() TCPOption {
+	return newTCPOption(TCPOptionKindMPTCP, p.Encode())
+}
+
+// TCPMPFail is the MP_FAIL option reporting a checksum failure at a data
+// sequence number.
+type TCPMPFail struct {
+	DSN uint64
+}
+
+// DecodeFromBytes decodes the data of an MP_FAIL option.
+func (f *TCPMPFail) DecodeFromBytes(data []byte) error {
+	if err := checkMPTCP(data, TCPMPTCPSubtypeFail, len(data) == 10); err != nil {
+		return err
+	}
+	f.DSN = binary.BigEndian.Uint64(data[2:])
+	return nil
+}
+
+// Encode returns the data of an MP_FAIL option.
+func (f *TCPMPFail) Encode() []byte {
+	return appendUint64([]byte{uint8(TCPMPTCPSubtypeFail) << 4, 0}, f.DSN)
+}
+
+// Option returns f as a TCP option.
+func (f *TCPMPFail) Option() TCPOption {
+	return newTCPOption(TCPOptionKindMPTCP, f.Encode())
+}
+
+// TCPMPFastClose is the MP_FASTCLOSE option abruptly closing a Multipath TCP
+// connection.
+type TCPMPFastClose struct {
+	ReceiverKey uint64
+}
+
+// DecodeFromBytes decodes the data of an MP_FASTCLOSE option.
+func (f *TCPMPFastClose) DecodeFromBytes(data []byte) error {
+	if err := checkMPTCP(data, TCPMPTCPSubtypeFastClose, len(data) == 10); err != nil {
+		return err
+	}
+	f.ReceiverKey = binary.BigEndian.Uint64(data[2:])
+	return nil
+}
+
+// Encode returns the data of an MP_FASTCLOSE option.
+func (f *TCPMPFastClose) Encode() []byte {
+	return appendUint64([]byte{uint8(TCPMPTCPSubtypeFastClose) << 4, 0}, f.ReceiverKey)
+}
+
+// Option returns f as a TCP option.
+func (f *TCPMPFastClose) Option() TCPOption {
+	return newTCPOption(TCPOptionKindMPTCP, f.Encode())
+}
@@ -7,6 +7,10 @@
 package layers
 
 import (
+	"bytes"
+	"net"
+	"reflect"
+	"strings"
 	"testing"
 
 	"github.com/google/gopacket"
@@ -58,3 +62,158 @@ func TestTCPSerializePadding(t *testing.T) {
 		t.Errorf("TCP data of len %d not padding to 32 bit boundary", len(buf.Bytes()))
 	}
 }
+
+func TestTCPOptionAccessors(t *testing.T) {
+	sack := []TCPSACKBlock{{1000, 2000}, {3000, 4000}}
+	cookie := []byte{1, 2, 3, 4, 5, 6, 7, 8}
+	tcp := &TCP{SYN: true, Options: []TCPOption{
+		NewTCPOptionMSS(1460),
+		NewTCPOptionSACKPermitted(),
+		NewTCPOptionTimestamps(7, 3),
+		NewTCPOptionWindowScale(7),
+		NewTCPOptionSACK(sack...),
+	}}
+	buf := gopacket.NewSerializeBuffer()
+	if err := gopacket.SerializeLayers(buf, gopacket.SerializeOptions{FixLengths: true}, tcp); err != nil {
+		t.Fatal(err)
+	}
+	var got TCP
+	if err := got.DecodeFromBytes(buf.Bytes(), gopacket.NilDecodeFeedback); err != nil {
+		t.Fatal(err)
+	}
+	if mss, err := got.Option(TCPOptionKindMSS).MSS(); mss != 1460 || err != nil {
+		t.Errorf("MSS %d, %v", mss, err)
+	}
+	if shift, err := got.Option(TCPOptionKindWindowScale).WindowScale(); shift != 7 || err != nil {
+		t.Errorf("window scale %d, %v", shift, err)
+	}
+	if got.Option(TCPOptionKindSACKPermitted) == nil {
+		t.Error("no SACK permitted option")
+	}
+	if blocks, err := got.Option(TCPOptionKindSACK).SACKBlocks(); !reflect.DeepEqual(blocks, sack) || err != nil {
+		t.Errorf("SACK blocks %v, %v", blocks, err)
+	}
+	if val, echo, err := got.Option(TCPOptionKindTimestamps).Timestamps(); val != 7 || echo != 3 || err != nil {
+		t.Errorf("timestamps %d/%d, %v", val, echo, err)
+	}
+	if c, err := NewTCPOptionFastOpen(cookie).FastOpenCookie(); !bytes.Equal(c, cookie) || err != nil {
+		t.Errorf("fast open cookie %x, %v", c, err)
+	}
+	if got.Option(TCPOptionKindMPTCP) != nil {
+		t.Error("unexpected MPTCP option")
+	}
+
+	// The experimental fast open option.
+	exp := TCPOption{OptionType: TCPOptionKindExperiment2, OptionLength: 8, OptionData: []byte{0xf9, 0x89, 1, 2, 3, 4}}
+	if c, err := exp.FastOpenCookie(); !bytes.Equal(c, cookie[:4]) || err != nil {
+		t.Errorf("experimental fast open cookie %x, %v", c, err)
+	}
+	auth := TCPAuthOption{KeyID: 1, RNextKeyID: 2, MAC: cookie}
+	var gotAuth TCPAuthOption
+	if err := gotAuth.DecodeFromBytes(auth.Option().OptionData); err != nil || !reflect.DeepEqual(gotAuth, auth) {
+		t.Errorf("TCP-AO %+v, %v", gotAuth, err)
+	}
+}
+
+func TestTCPOptionMalformed(t *testing.T) {
+	short := TCPOption{OptionType: TCPOptionKindMSS, OptionLength: 3, OptionData: []byte{5}}
+	if _, err := short.MSS(); err == nil {
+		t.Error("no error for a short MSS")
+	}
+	if s := short.String(); s != "TCPOption(MSS: 0x05)" {
+		t.Errorf("short MSS string %s", s)
+	}
+	if _, err := NewTCPOptionMSS(1460).WindowScale(); err == nil {
+		t.Error("no error for the window scale of an MSS option")
+	}
+	sack := NewTCPOptionSACK(TCPSACKBlock{1, 2})
+	sack.OptionData = sack.OptionData[:7]
+	if _, err := sack.SACKBlocks(); err == nil {
+		t.Error("no error for a truncated SACK block")
+	}
+	tcp := &TCP{Options: []TCPOption{NewTCPOptionSACK(make([]TCPSACKBlock, 5)...)}}
+	if err := tcp.SerializeTo(gopacket.NewSerializeBuffer(), gopacket.SerializeOptions{FixLengths: true}); err == nil {
+		t.Error("no error serializing 42 bytes of options")
+	}
+	if _, _, err := (TCPOption{OptionType: TCPOptionKindTimestamps, OptionData: make([]byte, 6)}).Timestamps(); err == nil {
+		t.Error("no error for short timestamps")
+	}
+	if _, err := NewTCPOptionFastOpen([]byte{1, 2, 3}).FastOpenCookie(); err == nil {
+		t.Error("no error for a 3 byte fast open cookie")
+	}
+	if _, err := (TCPOption{OptionType: TCPOptionKindExperiment2, OptionData: []byte{0, 1, 2, 3}}).FastOpenCookie(); err == nil {
+		t.Error("no error for another experiment")
+	}
+}
+
+// mptcpDecoder is implemented by the types of MPTCP options.
+type mptcpDecoder interface {
+	DecodeFromBytes([]byte) error
+	Option() TCPOption
+}
+
+func TestTCPMPTCPOptions(t *testing.T) {
+	for _, c := range []struct {
+		opt     mptcpDecoder
+		subtype TCPMPTCPSubtype
+		wire    string
+	}{
+		{&TCPMPCapable{Version: 1, Flags: TCPMPCapableFlagChecksum | TCPMPCapableFlagHMACSHA256}, TCPMPTCPSubtypeCapable, "1e04 0181"},
+		{&TCPMPCapable{Version: 1, Flags: TCPMPCapableFlagHMACSHA256, HasSenderKey: true, SenderKey: 0x0102030405060708, HasReceiverKey: true, ReceiverKey: 0x1112131415161718, HasDataLength: true, DataLength: 100, HasChecksum: true, Checksum: 0xabcd},
+			TCPMPTCPSubtypeCapable, "1e18 0101 0102030405060708 1112131415161718 0064 abcd"},
+		{&TCPMPJoin{Backup: true, AddressID: 2, ReceiverToken: 0xaabbccdd, SenderRandom: 0x11223344}, TCPMPTCPSubtypeJoin, "1e0c 1102 aabbccdd 11223344"},
+		{&TCPMPJoin{AddressID: 2, HMAC: []byte{1, 2, 3, 4, 5, 6, 7, 8}, SenderRandom: 0x11223344}, TCPMPTCPSubtypeJoin, "1e10 1002 0102030405060708 11223344"},
+		{&TCPMPJoin{HMAC: make([]byte, 20)}, TCPMPTCPSubtypeJoin, "1e18 1000 0000000000000000000000000000000000000000"},
+		{&TCPMPDSS{HasDataACK: true, DataACK: 0x01020304}, TCPMPTCPSubtypeDSS, "1e08 2001 01020304"},
+		{&TCPMPDSS{DataFIN: true, HasDataACK: true, DataACK: 0x0102030405060708, DataACK64: true,
+			Mapping: &TCPMPDataSequenceMapping{DSN: 0x1112131415161718, DSN64: true, SubflowSeq: 1, DataLength: 1400, HasChecksum: true, Checksum: 0xbeef}},
+			TCPMPTCPSubtypeDSS, "1e1c 201f 0102030405060708 1112131415161718 00000001 0578 beef"},
+		{&TCPMPDSS{Mapping: &TCPMPDataSequenceMapping{DSN: 0x11121314, SubflowSeq: 1, DataLength: 1400}}, TCPMPTCPSubtypeDSS, "1e0e 2004 11121314 00000001 0578"},
+		{&TCPMPAddAddr{AddressID: 3, Address: net.IP{192, 0, 2, 1}, Port: 8080, HMAC: []byte{1, 2, 3, 4, 5, 6, 7, 8}}, TCPMPTCPSubtypeAddAddr, "1e12 3003 c0000201 1f90 0102030405060708"},
+		{&TCPMPAddAddr{Echo: true, AddressID: 4, Address: net.ParseIP("2001:db8::1")}, TCPMPTCPSubtypeAddAddr, "1e14 3104 20010db8000000000000000000000001"},
+		{&TCPMPRemoveAddr{AddressIDs: []uint8{3, 4}}, TCPMPTCPSubtypeRemoveAddr, "1e05 40 0304"},
+		{&TCPMPPrio{Backup: true}, TCPMPTCPSubtypePrio, "1e03 51"},
+		{&TCPMPFail{DSN: 42}, TCPMPTCPSubtypeFail, "1e0c 6000 000000000000002a"},
+		{&TCPMPFastClose{ReceiverKey: 0x1112131415161718}, TCPMPTCPSubtypeFastClose, "1e0c 7000 1112131415161718"},
+	} {
+		opt := c.opt.Option()
+		wire := testHex(t, strings.Replace(c.wire, " ", "", -1))
+		if got := append([]byte{byte(opt.OptionType), opt.OptionLength}, opt.OptionData...); !bytes.Equal(got, wire) {
+			t.Errorf("%#v encoded to %x, want %x", c.opt, got, wire)
+		}
+		if st, err := opt.MPTCPSubtype(); st != c.subtype || err != nil {
+			t.Errorf("%#v subtype %v, %v", c.opt, st, err)
+		}
+		got := reflect.New(reflect.TypeOf(c.opt).Elem()).Interface().(mptcpDecoder)
+		if err := got.DecodeFromBytes(wire[2:]); err != nil {
+			t.Errorf("decoding %x: %v", wire, err)
+		} else if !reflect.DeepEqual(got, c.opt) {
+			t.Errorf("decoded %x to %#v, want %#v", wire, got, c.opt)
+		}
+	}
+}
+
+func TestTCPMPTCPMalformed(t *testing.T) {
+	for _, c := range []struct {
+		opt  mptcpDecoder
+		data string
+	}{
+		{&TCPMPCapable{}, "0101 01020304"},
+		{&TCPMPCapable{}, "1101"},
+		{&TCPMPJoin{}, "1002 aabbccdd"},
+		{&TCPMPDSS{}, "20"},
+		{&TCPMPDSS{}, "2003 01020304"},
+		{&TCPMPDSS{}, "2004 11121314 00000001 05"},
+		{&TCPMPDSS{}, "2001 01020304 00"},
+		{&TCPMPAddAddr{}, "3003 c0000201"},
+		{&TCPMPAddAddr{}, "3103 c00002"},
+		{&TCPMPRemoveAddr{}, "40"},
+		{&TCPMPPrio{}, "510203"},
+		{&TCPMPFail{}, "6000 0000"},
+		{&TCPMPFastClose{}, "7000 11121314151617"},
+	} {
+		if err := c.opt.DecodeFromBytes(testHex(t, strings.Replace(c.data, " ", "", -1))); err == nil {
+			t.Errorf("no error decoding %s as %T", c.data, c.opt)
+		}
+	}
+}
@@ -102,3 +102,44 @@ func (i *tcpipchecksum) SetNetworkLayerForChecksum(l gopacket.NetworkLayer) erro
 	}
 	return nil
 }
+
+// checksumStatus returns the status of a checksum that has been verified,
+// with ok reporting whether it matched.
+func checksumStatus(ok bool, stored uint16) gopacket.ChecksumStatus {
+	switch {
+	case ok:
+		return gopacket.ChecksumGood
+	case stored == 0:
+		return gopacket.ChecksumZero
+	}
+	return gopacket.ChecksumBad
+}
+
+// verifyTCPIPChecksum verifies a checksum computed the TCP/UDP way: over a
+// pseudo-header taken from network, followed by the given chunks of data with
+// the stored checksum still in place.  length is the upper-layer length used
+// in the pseudo-header.  All chunks but the last must have an even length.
+func verifyTCPIPChecksum(network gopacket.NetworkLayer, headerProtocol IPProtocol, length int, stored uint16, data ...[]byte) gopacket.ChecksumStatus {
+	var pseudoheader tcpipPseudoHeader
+	switch v := network.(type) {
+	case *IPv4:
+		pseudoheader = v
+	case *IPv6:
+		pseudoheader = v
+	default:
+		return gopacket.ChecksumUnverified
+	}
+	csum, err := pseudoheader.pseudoheaderChecksum()
+	if err != nil {
+		return gopacket.ChecksumUnverified
+	}
+	csum += uint32(headerProtocol)
+	csum += uint32(length) & 0xffff
+	csum += uint32(length) >> 16
+	for _, d := range data {
+		// tcpipChecksum returns the complemented, folded sum; undo the
+		// complement to keep summing.
+		csum = uint32(^tcpipChecksum(d, csum))
+	}
+	return checksumStatus(csum == 0xffff, stored)
+}
@@ -7,9 +7,13 @@
 package layers
 
 import (
-	"github.com/google/gopacket"
+	"encoding/binary"
+	"fmt"
 	"net"
+	"reflect"
 	"testing"
+
+	"github.com/google/gopacket"
 )
 
 const (
@@ -183,3 +187,261 @@ func TestIPv6JumbogramUDPChecksum(t *testing.T) {
 		t.Errorf("Bad checksum:\ngot:\n%#v\n\nwant:\n%#v\n\n", got, want)
 	}
 }
+
+func checkChecksumStatus(t *testing.T, desc string, p gopacket.Packet, want map[gopacket.LayerType]gopacket.ChecksumStatus) {
+	for typ, status := range want {
+		l := p.Layer(typ)
+		if l == nil {
+			t.Errorf("%s: no %v layer", desc, typ)
+			continue
+		}
+		got := reflect.ValueOf(l).Elem().FieldByName("ChecksumStatus").Interface()
+		if got != status {
+			t.Errorf("%s: %v checksum status, got %v want %v", desc, typ, got, status)
+		}
+	}
+}
+
+func TestVerifyChecksums(t *testing.T) {
+	opts := gopacket.DecodeOptions{VerifyChecksums: true}
+	for _, test := range []struct {
+		desc string
+		data []byte
+		want map[gopacket.LayerType]gopacket.ChecksumStatus
+	}{
+		{"TCP", testSimpleTCPPacket, map[gopacket.LayerType]gopacket.ChecksumStatus{
+			LayerTypeIPv4: gopacket.ChecksumGood,
+			LayerTypeTCP:  gopacket.ChecksumGood,
+		}},
+		{"ICMPv4", testICMP, map[gopacket.LayerType]gopacket.ChecksumStatus{
+			LayerTypeIPv4:   gopacket.ChecksumGood,
+			LayerTypeICMPv4: gopacket.ChecksumGood,
+		}},
+		{"ICMPv6", testICMP6, map[gopacket.LayerType]gopacket.ChecksumStatus{
+			LayerTypeICMPv6: gopacket.ChecksumGood,
+		}},
+		{"IGMPv2", igmpv2MembershipQueryPacket, map[gopacket.LayerType]gopacket.ChecksumStatus{
+			LayerTypeIPv4: gopacket.ChecksumGood,
+			LayerTypeIGMP: gopacket.ChecksumGood,
+		}},
+		{"IGMPv3", igmp3v3MembershipQueryPacket, map[gopacket.LayerType]gopacket.ChecksumStatus{
+			LayerTypeIPv4: gopacket.ChecksumGood,
+			LayerTypeIGMP: gopacket.ChecksumGood,
+		}},
+	} {
+		p := gopacket.NewPacket(test.data, LinkTypeEthernet, opts)
+		if p.ErrorLayer() != nil {
+			t.Fatalf("%s: failed to decode packet: %v", test.desc, p.ErrorLayer().Error())
+		}
+		checkChecksumStatus(t, test.desc, p, test.want)
+
+		// Without VerifyChecksums nothing is checked.
+		p = gopacket.NewPacket(test.data, LinkTypeEthernet, gopacket.Default)
+		for typ := range test.want {
+			test.want[typ] = gopacket.ChecksumUnverified
+		}
+		checkChecksumStatus(t, test.desc+" unverified", p, test.want)
+	}
+}
+
+func TestVerifyChecksumsBad(t *testing.T) {
+	data := append([]byte(nil), testSimpleTCPPacket...)
+	// Corrupt the last byte of the TCP payload.
+	data[len(data)-1] ^= 0xff
+	p := gopacket.NewPacket(data, LinkTypeEthernet, gopacket.DecodeOptions{VerifyChecksums: true})
+	if p.ErrorLayer() != nil {
+		t.Fatal("Bad checksum caused a decode failure:", p.ErrorLayer().Error())
+	}
+	checkChecksumStatus(t, "corrupt payload", p, map[gopacket.LayerType]gopacket.ChecksumStatus{
+		LayerTypeIPv4: gopacket.ChecksumGood,
+		LayerTypeTCP:  gopacket.ChecksumBad,
+	})
+
+	// Zero out both checksums, as if they had been offloaded.
+	data = append(data[:0], testSimpleTCPPacket...)
+	data[14+10], data[14+11] = 0, 0
+	data[14+20+16], data[14+20+17] = 0, 0
+	p = gopacket.NewPacket(data, LinkTypeEthernet, gopacket.DecodeOptions{VerifyChecksums: true})
+	checkChecksumStatus(t, "zero checksums", p, map[gopacket.LayerType]gopacket.ChecksumStatus{
+		LayerTypeIPv4: gopacket.ChecksumZero,
+		LayerTypeTCP:  gopacket.ChecksumZero,
+	})
+}
+
+func TestVerifyChecksumsSerialized(t *testing.T) {
+	ip6 := createIPv6ChecksumTestLayer()
+	ip6.NextHeader = IPProtocolUDP
+	udp := createUDPChecksumTestLayer()
+	udp.SetNetworkLayerForChecksum(ip6)
+	buf := gopacket.NewSerializeBuffer()
+	opts := gopacket.SerializeOptions{FixLengths: true, ComputeChecksums: true}
+	if err := gopacket.SerializeLayers(buf, opts, ip6, udp, gopacket.Payload([]byte{1, 2, 3})); err != nil {
+		t.Fatal(err)
+	}
+
+	var (
+		dip6    IPv6
+		dudp    UDP
+		payload gopacket.Payload
+		decoded []gopacket.LayerType
+	)
+	parser := gopacket.NewDecodingLayerParser(LayerTypeIPv6, &dip6, &dudp, &payload)
+	parser.VerifyChecksums = true
+	if err := parser.DecodeLayers(buf.Bytes(), &decoded); err != nil {
+		t.Fatal(err)
+	}
+	if dudp.ChecksumStatus != gopacket.ChecksumGood {
+		t.Errorf("UDP checksum status, got %v want %v", dudp.ChecksumStatus, gopacket.ChecksumGood)
+	}
+
+	data := buf.Bytes()
+	data[len(data)-1]++
+	if err := parser.DecodeLayers(data, &decoded); err != nil {
+		t.Fatal(err)
+	}
+	if dudp.ChecksumStatus != gopacket.ChecksumBad {
+		t.Errorf("UDP checksum status, got %v want %v", dudp.ChecksumStatus, gopacket.ChecksumBad)
+	}
+}
+
+func TestVerifySCTPChecksum(t *testing.T) {
+	ip4 := createIPv4ChecksumTestLayer()
+	ip4.Protocol = IPProtocolSCTP
+	sctp := &SCTP{SrcPort: 1234, DstPort: 5678, VerificationTag: 0xdeadbeef}
+	buf := gopacket.NewSerializeBuffer()
+	opts := gopacket.SerializeOptions{FixLengths: true, ComputeChecksums: true}
+	if err := gopacket.SerializeLayers(buf, opts, ip4, sctp, gopacket.Payload([]byte{1, 2, 3, 4})); err != nil {
+		t.Fatal(err)
+	}
+
+	var (
+		dip4    IPv4
+		dsctp   SCTP
+		payload gopacket.Payload
+		decoded []gopacket.LayerType
+	)
+	parser := gopacket.NewDecodingLayerParser(LayerTypeIPv4, &dip4, &dsctp, &payload)
+	parser.VerifyChecksums = true
+	if err := parser.DecodeLayers(buf.Bytes(), &decoded); err != nil {
+		t.Fatal(err)
+	}
+	if dip4.ChecksumStatus != gopacket.ChecksumGood {
+		t.Errorf("IPv4 checksum status, got %v want %v", dip4.ChecksumStatus, gopacket.ChecksumGood)
+	}
+	if dsctp.ChecksumStatus != gopacket.ChecksumGood {
+		t.Errorf("SCTP checksum status, got %v want %v", dsctp.ChecksumStatus, gopacket.ChecksumGood)
+	}
+
+	data := buf.Bytes()
+	data[len(data)-1]++
+	if err := parser.DecodeLayers(data, &decoded); err != nil {
+		t.Fatal(err)
+	}
+	if dsctp.ChecksumStatus != gopacket.ChecksumBad {
+		t.Errorf("SCTP checksum status, got %v want %v", dsctp.ChecksumStatus, gopacket.ChecksumBad)
+	}
+}
+
+func TestVerifySCTPChecksumTruncated(t *testing.T) {
+	ip4 := createIPv4ChecksumTestLayer()
+	ip4.Protocol = IPProtocolSCTP
+	buf := gopacket.NewSerializeBuffer()
+	opts := gopacket.SerializeOptions{FixLengths: true, ComputeChecksums: true}
+	if err := gopacket.SerializeLayers(buf, opts, ip4, gopacket.Payload([]byte{1, 2, 3})); err != nil {
+		t.Fatal(err)
+	}
+	p := gopacket.NewPacket(buf.Bytes(), LayerTypeIPv4, gopacket.DecodeOptions{VerifyChecksums: true, SkipDecodeRecovery: true})
+	if p.ErrorLayer() == nil {
+		t.Error("truncated SCTP header decoded")
+	}
+	sctp, ok := p.Layer(LayerTypeSCTP).(*SCTP)
+	if !ok || sctp.ChecksumStatus != gopacket.ChecksumUnverified {
+		t.Errorf("SCTP layer %v", sctp)
+	}
+}
+
+// udpLiteTestPacket returns an IP packet holding a UDP-Lite datagram whose
+// checksum covers its first coverage bytes, or all of it if coverage is 0.
+func udpLiteTestPacket(t *testing.T, network gopacket.SerializableLayer, coverage uint16) []byte {
+	datagram := []byte{0x04, 0xd2, 0x16, 0x2e, byte(coverage >> 8), byte(coverage), 0, 0}
+	datagram = append(datagram, []byte("checked, then not checked")...)
+	var csum uint32
+	var err error
+	switch ip := network.(type) {
+	case *IPv4:
+		ip.Protocol = IPProtocolUDPLite
+		csum, err = ip.pseudoheaderChecksum()
+	case *IPv6:
+		ip.NextHeader = IPProtocolUDPLite
+		csum, err = ip.pseudoheaderChecksum()
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	csum += uint32(IPProtocolUDPLite) + uint32(len(datagram))
+	covered := datagram
+	if coverage != 0 {
+		covered = datagram[:coverage]
+	}
+	binary.BigEndian.PutUint16(datagram[6:], tcpipChecksum(covered, csum))
+	buf := gopacket.NewSerializeBuffer()
+	opts := gopacket.SerializeOptions{FixLengths: true, ComputeChecksums: true}
+	if err := gopacket.SerializeLayers(buf, opts, network, gopacket.Payload(datagram)); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestVerifyUDPLiteChecksum(t *testing.T) {
+	opts := gopacket.DecodeOptions{VerifyChecksums: true}
+	for _, test := range []struct {
+		desc     string
+		first    gopacket.LayerType
+		network  func() gopacket.SerializableLayer
+		coverage uint16
+	}{
+		{"IPv4 full coverage", LayerTypeIPv4, func() gopacket.SerializableLayer { return createIPv4ChecksumTestLayer() }, 0},
+		{"IPv4 partial coverage", LayerTypeIPv4, func() gopacket.SerializableLayer { return createIPv4ChecksumTestLayer() }, 15},
+		{"IPv6 full coverage", LayerTypeIPv6, func() gopacket.SerializableLayer { return createIPv6ChecksumTestLayer() }, 0},
+		{"IPv6 partial coverage", LayerTypeIPv6, func() gopacket.SerializableLayer { return createIPv6ChecksumTestLayer() }, 8},
+	} {
+		data := udpLiteTestPacket(t, test.network(), test.coverage)
+		p := gopacket.NewPacket(data, test.first, opts)
+		if p.ErrorLayer() != nil {
+			t.Fatalf("%s: failed to decode packet: %v", test.desc, p.ErrorLayer().Error())
+		}
+		checkChecksumStatus(t, test.desc, p, map[gopacket.LayerType]gopacket.ChecksumStatus{
+			LayerTypeUDPLite: gopacket.ChecksumGood,
+		})
+
+		// Corrupting the last byte only matters if the checksum covers it.
+		data[len(data)-1] ^= 0xff
+		want := gopacket.ChecksumBad
+		if test.coverage != 0 {
+			want = gopacket.ChecksumGood
+		}
+		p = gopacket.NewPacket(data, test.first, opts)
+		checkChecksumStatus(t, test.desc+" uncovered byte corrupt", p, map[gopacket.LayerType]gopacket.ChecksumStatus{
+			LayerTypeUDPLite: want,
+		})
+
+		// A covered byte, like one of the source port, always matters.
+		data = udpLiteTestPacket(t, test.network(), test.coverage)
+		data[len(data)-len("checked, then not checked")-8] ^= 0xff
+		p = gopacket.NewPacket(data, test.first, opts)
+		checkChecksumStatus(t, test.desc+" covered byte corrupt", p, map[gopacket.LayerType]gopacket.ChecksumStatus{
+			LayerTypeUDPLite: gopacket.ChecksumBad,
+		})
+	}
+
+	// A coverage shorter than the header or longer than the datagram is
+	// invalid, whatever the checksum.
+	for _, coverage := range []uint16{5, 100} {
+		data := udpLiteTestPacket(t, createIPv4ChecksumTestLayer(), 0)
+		binary.BigEndian.PutUint16(data[20+4:], coverage)
+		p := gopacket.NewPacket(data, LayerTypeIPv4, opts)
+		checkChecksumStatus(t, fmt.Sprintf("coverage %d", coverage), p, map[gopacket.LayerType]gopacket.ChecksumStatus{
+			LayerTypeUDPLite: gopacket.ChecksumBad,
+		})
+	}
+}
@@ -9,6 +9,7 @@ package layers
 import (
 	"encoding/binary"
 	"errors"
+	"fmt"
 
 	"github.com/google/gopacket"
 )
@@ -89,6 +90,12 @@ type TLS struct {
 	Handshake        []TLSHandshakeRecord
 	AppData          []TLSAppDataRecord
 	Alert            []TLSAlertRecord
+
+	// RecordOrder holds the content type of each record in the order they
+	// were decoded, which SerializeTo follows to interleave the records of
+	// the slices above.  If it is empty, the records are serialized in the
+	// order of the slices.
+	RecordOrder []TLSType
 }
 
 // TLSRecordHeader contains all the information that each TLS Record types should have
@@ -123,11 +130,13 @@ func (t *TLS) DecodeFromBytes(data []byte, df gopacket.DecodeFeedback) error {
 	t.Handshake = t.Handshake[:0]
 	t.AppData = t.AppData[:0]
 	t.Alert = t.Alert[:0]
+	t.RecordOrder = t.RecordOrder[:0]
 
-	return t.decodeTLSRecords(data, df)
+	var hr TLSHandshakeReassembler
+	return t.decodeTLSRecords(data, &hr, df)
 }
 
-func (t *TLS) decodeTLSRecords(data []byte, df gopacket.DecodeFeedback) error {
+func (t *TLS) decodeTLSRecords(data []byte, hr *TLSHandshakeReassembler, df gopacket.DecodeFeedback) error {
 	if len(data) < 5 {
 		df.SetTruncated()
 		return errors.New("TLS record too short")
@@ -163,6 +172,8 @@ func (t *TLS) decodeTLSRecords(data []byte, df gopacket.DecodeFeedback) error {
 			return e
 		}
 		t.ChangeCipherSpec = append(t.ChangeCipherSpec, r)
+		// Later handshake records are encrypted.
+		hr.encrypted = true
 	case TLSAlert:
 		var r TLSAlertRecord
 		e := r.decodeFromBytes(h, data[hl:tl], df)
@@ -172,7 +183,7 @@ func (t *TLS) decodeTLSRecords(data []byte, df gopacket.DecodeFeedback) error {
 		t.Alert = append(t.Alert, r)
 	case TLSHandshake:
 		var r TLSHandshakeRecord
-		e := r.decodeFromBytes(h, data[hl:tl], df)
+		e := r.decodeFromBytes(h, data[hl:tl], hr, df)
 		if e != nil {
 			return e
 		}
@@ -186,10 +197,12 @@ func (t *TLS) decodeTLSRecords(data []byte, df gopacket.DecodeFeedback) error {
 		t.AppData = append(t.AppData, r)
 	}
 
+	t.RecordOrder = append(t.RecordOrder, h.ContentType)
+
 	if len(data) == tl {
 		return nil
 	}
-	return t.decodeTLSRecords(data[tl:len(data)], df)
+	return t.decodeTLSRecords(data[tl:len(data)], hr, df)
 }
 
 // CanDecode implements gopacket.DecodingLayer.
@@ -206,3 +219,88 @@ func (t *TLS) NextLayerType() gopacket.LayerType {
 func (t *TLS) Payload() []byte {
 	return nil
 }
+
+// SerializeTo writes the serialized form of this layer into the
+// SerializationBuffer, implementing gopacket.SerializableLayer.
+// See the docs for gopacket.SerializableLayer for more info.
+func (t *TLS) SerializeTo(b gopacket.SerializeBuffer, opts gopacket.SerializeOptions) error {
+	order := t.RecordOrder
+	if len(order) == 0 {
+		for range t.ChangeCipherSpec {
+			order = append(order, TLSChangeCipherSpec)
+		}
+		for range t.Handshake {
+			order = append(order, TLSHandshake)
+		}
+		for range t.AppData {
+			order = append(order, TLSApplicationData)
+		}
+		for range t.Alert {
+			order = append(order, TLSAlert)
+		}
+	}
+
+	var data []byte
+	var ccs, hs, ad, al int
+	for _, typ := range order {
+		var err error
+		switch typ {
+		case TLSChangeCipherSpec:
+			if ccs == len(t.ChangeCipherSpec) {
+				return errors.New("TLS RecordOrder has too many ChangeCipherSpec records")
+			}
+			data, err = t.ChangeCipherSpec[ccs].serialize(data, opts)
+			ccs++
+		case TLSHandshake:
+			if hs == len(t.Handshake) {
+				return errors.New("TLS RecordOrder has too many Handshake records")
+			}
+			data, err = t.Handshake[hs].serialize(data, opts)
+			hs++
+		case TLSApplicationData:
+			if ad == len(t.AppData) {
+				return errors.New("TLS RecordOrder has too many Application Data records")
+			}
+			data, err = t.AppData[ad].serialize(data, opts)
+			ad++
+		case TLSAlert:
+			if al == len(t.Alert) {
+				return errors.New("TLS RecordOrder has too many Alert records")
+			}
+			data, err = t.Alert[al].serialize(data, opts)
+			al++
+		default:
+			return fmt.Errorf("TLS RecordOrder has unknown type %d", typ)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	if ccs != len(t.ChangeCipherSpec) || hs != len(t.Handshake) || ad != len(t.AppData) || al != len(t.Alert) {
+		return errors.New("TLS RecordOrder is missing records")
+	}
+
+	bytes, err := b.PrependBytes(len(data))
+	if err != nil {
+		return err
+	}
+	copy(bytes, data)
+	return nil
+}
+
+// serialize appends the header and the fragment of a record to data,
+// setting the content type if it is zero and the length if
+// opts.FixLengths is set.
+func (h *TLSRecordHeader) serialize(data []byte, typ TLSType, fragment []byte, opts gopacket.SerializeOptions) ([]byte, error) {
+	if h.ContentType == 0 {
+		h.ContentType = typ
+	}
+	if opts.FixLengths {
+		if len(fragment) > 0xffff {
+			return data, fmt.Errorf("TLS record fragment of %d bytes", len(fragment))
+		}
+		h.Length = uint16(len(fragment))
+	}
+	data = append(data, byte(h.ContentType), byte(h.Version>>8), byte(h.Version), byte(h.Length>>8), byte(h.Length))
+	return append(data, fragment...), nil
+}
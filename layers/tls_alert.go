@@ -94,6 +94,14 @@ func (t *TLSAlertRecord) decodeFromBytes(h TLSRecordHeader, data []byte, df gopa
 	return nil
 }
 
+func (t *TLSAlertRecord) serialize(data []byte, opts gopacket.SerializeOptions) ([]byte, error) {
+	fragment := t.EncryptedMsg
+	if fragment == nil {
+		fragment = []byte{byte(t.Level), byte(t.Description)}
+	}
+	return t.TLSRecordHeader.serialize(data, TLSAlert, fragment, opts)
+}
+
 // Strings shows the TLS alert level nicely formatted
 func (al TLSAlertLevel) String() string {
 	switch al {
@@ -32,3 +32,7 @@ func (t *TLSAppDataRecord) decodeFromBytes(h TLSRecordHeader, data []byte, df go
 	t.Payload = data
 	return nil
 }
+
+func (t *TLSAppDataRecord) serialize(data []byte, opts gopacket.SerializeOptions) ([]byte, error) {
+	return t.TLSRecordHeader.serialize(data, TLSApplicationData, t.Payload, opts)
+}
@@ -53,6 +53,10 @@ func (t *TLSChangeCipherSpecRecord) decodeFromBytes(h TLSRecordHeader, data []by
 	return nil
 }
 
+func (t *TLSChangeCipherSpecRecord) serialize(data []byte, opts gopacket.SerializeOptions) ([]byte, error) {
+	return t.TLSRecordHeader.serialize(data, TLSChangeCipherSpec, []byte{byte(t.Message)}, opts)
+}
+
 // String shows the message value nicely formatted
 func (ccs TLSchangeCipherSpec) String() string {
 	switch ccs {
@@ -0,0 +1,321 @@
+// Copyright 2019 The GoPacket Authors. All rights reserved.
+//
+// Use of this source code is governed by a BSD-style license
+// that can be found in the LICENSE file in the root of the source
+// tree.
+
+package layers
+
+import (
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// ClientHello returns the first ClientHello decoded from the handshake
+// records of the layer, or nil.
+func (t *TLS) ClientHello() *TLSClientHello {
+	for _, r := range t.Handshake {
+		for _, m := range r.Messages {
+			if m.ClientHello != nil {
+				return m.ClientHello
+			}
+		}
+	}
+	return nil
+}
+
+// ServerHello returns the first ServerHello decoded from the handshake
+// records of the layer, or nil.
+func (t *TLS) ServerHello() *TLSServerHello {
+	for _, r := range t.Handshake {
+		for _, m := range r.Messages {
+			if m.ServerHello != nil {
+				return m.ServerHello
+			}
+		}
+	}
+	return nil
+}
+
+// DecodeTLSClientHello decodes the first ClientHello of data, which holds
+// TLS records such as the payload of a TCP segment or reassembled stream
+// data.  A ClientHello fragmented across records is reassembled.
+func DecodeTLSClientHello(data []byte) (*TLSClientHello, error) {
+	m, err := decodeTLSHello(data, TLSHandshakeClientHello)
+	if err != nil {
+		return nil, err
+	}
+	return m.ClientHello, nil
+}
+
+// DecodeTLSServerHello decodes the first ServerHello of data, which holds
+// TLS records such as the payload of a TCP segment or reassembled stream
+// data.
+func DecodeTLSServerHello(data []byte) (*TLSServerHello, error) {
+	m, err := decodeTLSHello(data, TLSHandshakeServerHello)
+	if err != nil {
+		return nil, err
+	}
+	return m.ServerHello, nil
+}
+
+func decodeTLSHello(data []byte, typ TLSHandshakeType) (*TLSHandshakeMessage, error) {
+	var r TLSHandshakeReassembler
+	for len(data) >= 5 {
+		length := int(binary.BigEndian.Uint16(data[3:5]))
+		if len(data) < 5+length {
+			break
+		}
+		if TLSType(data[0]) == TLSHandshake {
+			messages, err := r.Add(data[5 : 5+length])
+			if err != nil {
+				return nil, err
+			}
+			for i := range messages {
+				if messages[i].Type == typ {
+					return &messages[i], nil
+				}
+			}
+		}
+		data = data[5+length:]
+	}
+	return nil, fmt.Errorf("no complete TLS %v", typ)
+}
+
+// JA3 returns the JA3 fingerprint string of the ClientHello: its version,
+// cipher suites, extensions, supported groups and point formats in decimal,
+// without GREASE values.
+func (ch *TLSClientHello) JA3() string {
+	extensions := make([]uint16, len(ch.Extensions))
+	for i, e := range ch.Extensions {
+		extensions[i] = uint16(e.Type)
+	}
+	groups := make([]uint16, len(ch.SupportedGroups))
+	for i, g := range ch.SupportedGroups {
+		groups[i] = uint16(g)
+	}
+	formats := make([]uint16, len(ch.ECPointFormats))
+	for i, f := range ch.ECPointFormats {
+		formats[i] = uint16(f)
+	}
+	return strings.Join([]string{
+		strconv.Itoa(int(ch.Version)),
+		ja3List(tlsCipherSuiteValues(ch.CipherSuites)),
+		ja3List(extensions),
+		ja3List(groups),
+		ja3List(formats),
+	}, ",")
+}
+
+// JA3Hash returns the JA3 fingerprint, the MD5 of JA3 in hex.
+func (ch *TLSClientHello) JA3Hash() string {
+	sum := md5.Sum([]byte(ch.JA3()))
+	return hex.EncodeToString(sum[:])
+}
+
+// JA3S returns the JA3S fingerprint string of the ServerHello: its version,
+// cipher suite and extensions in decimal.
+func (sh *TLSServerHello) JA3S() string {
+	extensions := make([]uint16, len(sh.Extensions))
+	for i, e := range sh.Extensions {
+		extensions[i] = uint16(e.Type)
+	}
+	return strings.Join([]string{
+		strconv.Itoa(int(sh.Version)),
+		strconv.Itoa(int(sh.CipherSuite)),
+		ja3List(extensions),
+	}, ",")
+}
+
+// JA3SHash returns the JA3S fingerprint, the MD5 of JA3S in hex.
+func (sh *TLSServerHello) JA3SHash() string {
+	sum := md5.Sum([]byte(sh.JA3S()))
+	return hex.EncodeToString(sum[:])
+}
+
+func ja3List(values []uint16) string {
+	var b strings.Builder
+	for _, v := range values {
+		if TLSIsGREASE(v) {
+			continue
+		}
+		if b.Len() > 0 {
+			b.WriteByte('-')
+		}
+		b.WriteString(strconv.Itoa(int(v)))
+	}
+	return b.String()
+}
+
+func tlsCipherSuiteValues(suites []TLSCipherSuite) []uint16 {
+	values := make([]uint16, len(suites))
+	for i, cs := range suites {
+		values[i] = uint16(cs)
+	}
+	return values
+}
+
+// TLSJA4Protocol is the transport of a JA4 or JA4S fingerprint.
+type TLSJA4Protocol byte
+
+// TLSJA4Protocol known values.
+const (
+	TLSJA4TCP  TLSJA4Protocol = 't'
+	TLSJA4QUIC TLSJA4Protocol = 'q'
+	TLSJA4DTLS TLSJA4Protocol = 'd'
+)
+
+// JA4 returns the JA4 fingerprint of the ClientHello sent over protocol.
+func (ch *TLSClientHello) JA4(protocol TLSJA4Protocol) string {
+	a, b, c := ch.ja4(protocol)
+	return a + "_" + ja4Hash(b) + "_" + ja4Hash(c)
+}
+
+// JA4Raw returns the JA4_r fingerprint of the ClientHello, which shows the
+// sorted cipher suites, extensions and signature algorithms that JA4
+// hashes.
+func (ch *TLSClientHello) JA4Raw(protocol TLSJA4Protocol) string {
+	a, b, c := ch.ja4(protocol)
+	return a + "_" + b + "_" + c
+}
+
+func (ch *TLSClientHello) ja4(protocol TLSJA4Protocol) (a, b, c string) {
+	suites := ja4Values(tlsCipherSuiteValues(ch.CipherSuites))
+	var extensions []uint16
+	sni := byte('i')
+	count := 0
+	for _, e := range ch.Extensions {
+		if TLSIsGREASE(uint16(e.Type)) {
+			continue
+		}
+		count++
+		switch e.Type {
+		case TLSExtensionServerName:
+			sni = 'd'
+		case TLSExtensionALPN:
+		default:
+			extensions = append(extensions, uint16(e.Type))
+		}
+	}
+	var alpn string
+	if len(ch.ALPN) > 0 {
+		alpn = ch.ALPN[0]
+	}
+	a = fmt.Sprintf("%c%s%c%02d%02d%s", protocol, ja4Version(ch.MaxVersion()), sni,
+		ja4Count(len(suites)), ja4Count(count), ja4ALPN(alpn))
+
+	sort.Strings(suites)
+	b = strings.Join(suites, ",")
+
+	exts := ja4Values(extensions)
+	sort.Strings(exts)
+	c = strings.Join(exts, ",")
+	algorithms := make([]uint16, len(ch.SignatureAlgorithms))
+	for i, ss := range ch.SignatureAlgorithms {
+		algorithms[i] = uint16(ss)
+	}
+	if algs := ja4Values(algorithms); len(algs) > 0 {
+		c += "_" + strings.Join(algs, ",")
+	}
+	return a, b, c
+}
+
+// JA4S returns the JA4S fingerprint of the ServerHello sent over protocol.
+func (sh *TLSServerHello) JA4S(protocol TLSJA4Protocol) string {
+	a, b, c := sh.ja4s(protocol)
+	return a + "_" + b + "_" + ja4Hash(c)
+}
+
+// JA4SRaw returns the JA4S_r fingerprint of the ServerHello, which shows
+// the extensions that JA4S hashes.
+func (sh *TLSServerHello) JA4SRaw(protocol TLSJA4Protocol) string {
+	a, b, c := sh.ja4s(protocol)
+	return a + "_" + b + "_" + c
+}
+
+func (sh *TLSServerHello) ja4s(protocol TLSJA4Protocol) (a, b, c string) {
+	extensions := make([]uint16, len(sh.Extensions))
+	for i, e := range sh.Extensions {
+		extensions[i] = uint16(e.Type)
+	}
+	exts := ja4Values(extensions)
+	a = fmt.Sprintf("%c%s%02d%s", protocol, ja4Version(sh.SelectedVersion()),
+		ja4Count(len(exts)), ja4ALPN(sh.ALPN))
+	return a, fmt.Sprintf("%04x", uint16(sh.CipherSuite)), strings.Join(exts, ",")
+}
+
+// ja4Values formats values in hex, without GREASE values.
+func ja4Values(values []uint16) []string {
+	var s []string
+	for _, v := range values {
+		if !TLSIsGREASE(v) {
+			s = append(s, fmt.Sprintf("%04x", v))
+		}
+	}
+	return s
+}
+
+// ja4Hash returns the first 12 hex digits of the SHA-256 of s, or zeros if
+// s is empty.
+func ja4Hash(s string) string {
+	if s == "" {
+		return "000000000000"
+	}
+	sum := sha256.Sum256([]byte(s))
+	return hex.EncodeToString(sum[:6])
+}
+
+func ja4Count(n int) int {
+	if n > 99 {
+		return 99
+	}
+	return n
+}
+
+func ja4Version(v TLSVersion) string {
+	switch v {
+	case 0x0304:
+		return "13"
+	case 0x0303:
+		return "12"
+	case 0x0302:
+		return "11"
+	case 0x0301:
+		return "10"
+	case 0x0300:
+		return "s3"
+	case 0x0002:
+		return "s2"
+	case 0xfeff:
+		return "d1"
+	case 0xfefd:
+		return "d2"
+	case 0xfefc:
+		return "d3"
+	}
+	return "00"
+}
+
+// ja4ALPN returns the first and last characters of the ALPN protocol, or of
+// its hex form if they aren't alphanumeric.
+func ja4ALPN(alpn string) string {
+	if alpn == "" {
+		return "00"
+	}
+	first, last := alpn[0], alpn[len(alpn)-1]
+	if !ja4Alphanumeric(first) || !ja4Alphanumeric(last) {
+		h := hex.EncodeToString([]byte(alpn))
+		first, last = h[0], h[len(h)-1]
+	}
+	return string([]byte{first, last})
+}
+
+func ja4Alphanumeric(c byte) bool {
+	return c >= '0' && c <= '9' || c >= 'A' && c <= 'Z' || c >= 'a' && c <= 'z'
+}
@@ -0,0 +1,165 @@
+// Copyright 2019 The GoPacket Authors. All rights reserved.
+//
+// Use of this source code is governed by a BSD-style license
+// that can be found in the LICENSE file in the root of the source
+// tree.
+
+package layers
+
+import (
+	"testing"
+)
+
+func testTLSExtensions(types ...TLSExtensionType) []TLSExtension {
+	extensions := make([]TLSExtension, len(types))
+	for i, t := range types {
+		extensions[i].Type = t
+	}
+	return extensions
+}
+
+// The example of the JA3 README, with GREASE values added.
+func TestJA3Reference(t *testing.T) {
+	ch := &TLSClientHello{
+		Version:         769,
+		CipherSuites:    []TLSCipherSuite{0x2a2a, 47, 53, 5, 10, 49161, 49162, 49171, 49172, 50, 56, 19, 4},
+		Extensions:      testTLSExtensions(0xdada, 0, 10, 11),
+		SupportedGroups: []TLSNamedGroup{0x4a4a, 23, 24, 25},
+		ECPointFormats:  []uint8{0},
+	}
+	if got, want := ch.JA3(), "769,47-53-5-10-49161-49162-49171-49172-50-56-19-4,0-10-11,23-24-25,0"; got != want {
+		t.Errorf("JA3 %q, want %q", got, want)
+	}
+	if got, want := ch.JA3Hash(), "ada70206e40642a3e4461f35503241d5"; got != want {
+		t.Errorf("JA3 hash %s, want %s", got, want)
+	}
+}
+
+// The Chrome example of the JA4 specification, with GREASE values added.
+func TestJA4Reference(t *testing.T) {
+	ch := &TLSClientHello{
+		Version: 0x0303,
+		CipherSuites: []TLSCipherSuite{
+			0x8a8a, 0x1301, 0x1302, 0x1303, 0xc02b, 0xc02f, 0xc02c, 0xc030, 0xcca9,
+			0xcca8, 0xc013, 0xc014, 0x009c, 0x009d, 0x002f, 0x0035,
+		},
+		Extensions: testTLSExtensions(0x3a3a, 0x0000, 0x0017, 0xff01, 0x000a, 0x000b, 0x0023,
+			0x0010, 0x0005, 0x000d, 0x0012, 0x0033, 0x002d, 0x002b, 0x001b, 0x4469, 0xbaba, 0x0015),
+		ALPN:              []string{"h2", "http/1.1"},
+		SupportedVersions: []TLSVersion{0x5a5a, 0x0304, 0x0303},
+		SignatureAlgorithms: []TLSSignatureScheme{
+			0x0403, 0x0804, 0x0401, 0x0503, 0x0805, 0x0501, 0x0806, 0x0601,
+		},
+	}
+	if got, want := ch.JA4(TLSJA4TCP), "t13d1516h2_8daaf6152771_e5627efa2ab1"; got != want {
+		t.Errorf("JA4 %s, want %s", got, want)
+	}
+	want := "t13d1516h2_002f,0035,009c,009d,1301,1302,1303,c013,c014,c02b,c02c,c02f,c030,cca8,cca9_" +
+		"0005,000a,000b,000d,0012,0015,0017,001b,0023,002b,002d,0033,4469,ff01_" +
+		"0403,0804,0401,0503,0805,0501,0806,0601"
+	if got := ch.JA4Raw(TLSJA4TCP); got != want {
+		t.Errorf("JA4_r %s, want %s", got, want)
+	}
+	if got := ch.JA4(TLSJA4QUIC); got != "q13d1516h2_8daaf6152771_e5627efa2ab1" {
+		t.Errorf("QUIC JA4 %s", got)
+	}
+}
+
+// The TLS 1.3 example of the JA4S specification.
+func TestJA4SReference(t *testing.T) {
+	sh := &TLSServerHello{
+		Version:          0x0303,
+		CipherSuite:      0x1301,
+		Extensions:       testTLSExtensions(TLSExtensionSupportedVersions, TLSExtensionKeyShare),
+		SupportedVersion: 0x0304,
+	}
+	if got, want := sh.JA4S(TLSJA4TCP), "t130200_1301_a56c5b993250"; got != want {
+		t.Errorf("JA4S %s, want %s", got, want)
+	}
+	if got, want := sh.JA4SRaw(TLSJA4TCP), "t130200_1301_002b,0033"; got != want {
+		t.Errorf("JA4S_r %s, want %s", got, want)
+	}
+}
+
+func TestJA4Empty(t *testing.T) {
+	ch := &TLSClientHello{Version: 0x0300}
+	if got, want := ch.JA4(TLSJA4TCP), "ts3i000000_000000000000_000000000000"; got != want {
+		t.Errorf("JA4 %s, want %s", got, want)
+	}
+	ch = &TLSClientHello{SupportedVersions: []TLSVersion{0xfeff, 0xfefc, 0xfefd}}
+	if got := ch.JA4(TLSJA4DTLS)[:3]; got != "dd3" {
+		t.Errorf("DTLS JA4 version %s", got)
+	}
+}
+
+func TestJA4ALPN(t *testing.T) {
+	for alpn, want := range map[string]string{
+		"":         "00",
+		"h2":       "h2",
+		"h":        "hh",
+		"http/1.1": "h1",
+		"\xab":     "ab",
+		"h2\x01":   "61",
+	} {
+		if got := ja4ALPN(alpn); got != want {
+			t.Errorf("ALPN %q: got %s, want %s", alpn, got, want)
+		}
+	}
+}
+
+func TestTLSFingerprintDecoded(t *testing.T) {
+	tl := decodeTestTLS(t, testClientHello[54:])
+	ch, err := DecodeTLSClientHello(testClientHello[54:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tl.ClientHello().JA3() != ch.JA3() {
+		t.Errorf("JA3 of layer %s, of bytes %s", tl.ClientHello().JA3(), ch.JA3())
+	}
+	want := "769,49172-49162-57-56-136-135-49167-49157-53-132-49171-49161-51-50-154-153-69-68-" +
+		"49166-49156-47-150-65-49169-49159-49164-49154-5-4-49170-49160-22-19-49165-49155-10-" +
+		"21-18-9-20-17-8-6-3-255,11-10-35-15,14-13-25-11-12-24-9-10-22-23-8-6-7-20-21-4-5-" +
+		"18-19-1-2-3-15-16-17,0-1-2"
+	if got := ch.JA3(); got != want {
+		t.Errorf("JA3 %s, want %s", got, want)
+	}
+	if got, want := ch.JA4(TLSJA4TCP)[:10], "t10i450400"; got != want {
+		t.Errorf("JA4 %s, want prefix %s", got, want)
+	}
+
+	sh, err := DecodeTLSServerHello(testServerHello)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := sh.JA3S(), "769,47,65281-35-15"; got != want {
+		t.Errorf("JA3S %s, want %s", got, want)
+	}
+	if got, want := sh.JA4SRaw(TLSJA4TCP), "t100300_002f_ff01,0023,000f"; got != want {
+		t.Errorf("JA4S_r %s, want %s", got, want)
+	}
+	if tl := decodeTestTLS(t, testServerHello); tl.ServerHello().JA3S() != sh.JA3S() {
+		t.Errorf("JA3S of layer %s", tl.ServerHello().JA3S())
+	}
+}
+
+func TestDecodeTLSClientHelloFragmented(t *testing.T) {
+	fragment := testClientHello[59:]
+	var data []byte
+	data = append(data, tlsTestRecord(TLSHandshake, fragment[:3])...)
+	data = append(data, tlsTestRecord(TLSHandshake, fragment[3:100])...)
+	data = append(data, tlsTestRecord(TLSHandshake, fragment[100:])...)
+	ch, err := DecodeTLSClientHello(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want, _ := DecodeTLSClientHello(testClientHello[54:]); ch.JA3Hash() != want.JA3Hash() {
+		t.Errorf("JA3 %s, want %s", ch.JA3(), want.JA3())
+	}
+
+	if _, err := DecodeTLSClientHello(data[:len(data)-1]); err == nil {
+		t.Error("no error for truncated ClientHello")
+	}
+	if _, err := DecodeTLSServerHello(data); err == nil {
+		t.Error("no error for missing ServerHello")
+	}
+}
@@ -7,22 +7,997 @@
 package layers
 
 import (
+	"bytes"
+	"crypto/x509"
+	"errors"
+	"fmt"
+
 	"github.com/google/gopacket"
 )
 
+// TLSHandshakeType is the type of a handshake message.
+type TLSHandshakeType uint8
+
+// TLSHandshakeType known values.
+const (
+	TLSHandshakeHelloRequest        TLSHandshakeType = 0
+	TLSHandshakeClientHello         TLSHandshakeType = 1
+	TLSHandshakeServerHello         TLSHandshakeType = 2
+	TLSHandshakeNewSessionTicket    TLSHandshakeType = 4
+	TLSHandshakeEndOfEarlyData      TLSHandshakeType = 5
+	TLSHandshakeEncryptedExtensions TLSHandshakeType = 8
+	TLSHandshakeCertificate         TLSHandshakeType = 11
+	TLSHandshakeServerKeyExchange   TLSHandshakeType = 12
+	TLSHandshakeCertificateRequest  TLSHandshakeType = 13
+	TLSHandshakeServerHelloDone     TLSHandshakeType = 14
+	TLSHandshakeCertificateVerify   TLSHandshakeType = 15
+	TLSHandshakeClientKeyExchange   TLSHandshakeType = 16
+	TLSHandshakeFinished            TLSHandshakeType = 20
+	TLSHandshakeCertificateStatus   TLSHandshakeType = 22
+	TLSHandshakeKeyUpdate           TLSHandshakeType = 24
+	TLSHandshakeMessageHash         TLSHandshakeType = 254
+)
+
+var tlsHandshakeTypeNames = map[TLSHandshakeType]string{
+	TLSHandshakeHelloRequest:        "Hello Request",
+	TLSHandshakeClientHello:         "Client Hello",
+	TLSHandshakeServerHello:         "Server Hello",
+	TLSHandshakeNewSessionTicket:    "New Session Ticket",
+	TLSHandshakeEndOfEarlyData:      "End Of Early Data",
+	TLSHandshakeEncryptedExtensions: "Encrypted Extensions",
+	TLSHandshakeCertificate:         "Certificate",
+	TLSHandshakeServerKeyExchange:   "Server Key Exchange",
+	TLSHandshakeCertificateRequest:  "Certificate Request",
+	TLSHandshakeServerHelloDone:     "Server Hello Done",
+	TLSHandshakeCertificateVerify:   "Certificate Verify",
+	TLSHandshakeClientKeyExchange:   "Client Key Exchange",
+	TLSHandshakeFinished:            "Finished",
+	TLSHandshakeCertificateStatus:   "Certificate Status",
+	TLSHandshakeKeyUpdate:           "Key Update",
+	TLSHandshakeMessageHash:         "Message Hash",
+}
+
+// String shows the handshake type nicely formatted
+func (ht TLSHandshakeType) String() string {
+	if name, ok := tlsHandshakeTypeNames[ht]; ok {
+		return name
+	}
+	return "Unknown"
+}
+
+// tlsMaxHandshakeLength bounds the length of handshake messages, above which
+// a record is taken to be encrypted rather than the start of a message.
+const tlsMaxHandshakeLength = 1 << 17
+
 // TLSHandshakeRecord defines the structure of a Handshare Record
 type TLSHandshakeRecord struct {
 	TLSRecordHeader
+
+	// Fragment is the content of the record, which holds handshake
+	// messages or parts of them.
+	Fragment []byte
+	// Messages holds the handshake messages which end in this record.  A
+	// message fragmented across records is decoded with the record holding
+	// its end, if that is in the same TLS layer.  Use a
+	// TLSHandshakeReassembler for messages fragmented across layers.
+	Messages []TLSHandshakeMessage
+	// Encrypted is set for records which can't be decoded, such as the
+	// encrypted Finished message following a ChangeCipherSpec.
+	Encrypted bool
+	// Err is the error decoding a malformed message of the record, before
+	// which Messages stops.  The record is kept, and the error is also the
+	// packet's error layer.
+	Err error
 }
 
-// DecodeFromBytes decodes the slice into the TLS struct.
-func (t *TLSHandshakeRecord) decodeFromBytes(h TLSRecordHeader, data []byte, df gopacket.DecodeFeedback) error {
+// decodeFromBytes decodes the slice into the record, decoding the messages
+// it completes with r.
+func (t *TLSHandshakeRecord) decodeFromBytes(h TLSRecordHeader, data []byte, r *TLSHandshakeReassembler, df gopacket.DecodeFeedback) error {
 	// TLS Record Header
 	t.ContentType = h.ContentType
 	t.Version = h.Version
 	t.Length = h.Length
 
-	// TODO
+	t.Fragment = data
+	if r.encrypted || !r.plausible(data) {
+		t.Encrypted = true
+		return nil
+	}
+	t.Messages, t.Err = r.Add(data)
+	if p, ok := df.(gopacket.PacketBuilder); ok && t.Err != nil {
+		p.SetErrorLayer(&tlsHandshakeError{data: data, err: t.Err})
+	}
+	return nil
+}
+
+// tlsHandshakeError is the error layer of a packet whose TLS layer has a
+// malformed handshake message.
+type tlsHandshakeError struct {
+	data []byte
+	err  error
+}
+
+func (e *tlsHandshakeError) LayerType() gopacket.LayerType { return gopacket.LayerTypeDecodeFailure }
+func (e *tlsHandshakeError) LayerContents() []byte         { return e.data }
+func (e *tlsHandshakeError) LayerPayload() []byte          { return nil }
+func (e *tlsHandshakeError) Error() error                  { return e.err }
+
+// serialize appends the record to data.  Its Fragment is used if set,
+// and otherwise its Messages are serialized, so that decoded records keep
+// their exact contents and messages fragmented across records.  Set
+// Fragment to nil to serialize changed Messages.
+func (t *TLSHandshakeRecord) serialize(data []byte, opts gopacket.SerializeOptions) ([]byte, error) {
+	fragment := t.Fragment
+	if fragment == nil {
+		for i := range t.Messages {
+			var err error
+			if fragment, err = t.Messages[i].serialize(fragment); err != nil {
+				return data, err
+			}
+		}
+	}
+	return t.TLSRecordHeader.serialize(data, TLSHandshake, fragment, opts)
+}
+
+// TLSHandshakeReassembler decodes handshake messages from the contents of
+// consecutive handshake records of one direction of a connection,
+// reassembling messages fragmented across records.  It follows the
+// negotiated version and cipher suite, which the format of some messages
+// depends on.  The zero value is ready to use.
+type TLSHandshakeReassembler struct {
+	// Version is the negotiated version, once a ServerHello has been
+	// decoded.
+	Version TLSVersion
+	// CipherSuite is the cipher suite chosen by the ServerHello.
+	CipherSuite TLSCipherSuite
+
+	buf []byte
+	// encrypted is set after a ChangeCipherSpec.
+	encrypted bool
+}
+
+// Add decodes the handshake messages which fragment completes.  It returns
+// an error, along with the messages before it, if a message is malformed
+// or longer than a handshake message can plausibly be.
+func (r *TLSHandshakeReassembler) Add(fragment []byte) ([]TLSHandshakeMessage, error) {
+	data := fragment
+	if len(r.buf) > 0 {
+		// Messages keep referring to data, so the buffer isn't reused.
+		data = append(r.buf, fragment...)
+		r.buf = nil
+	}
+	var messages []TLSHandshakeMessage
+	for len(data) >= 4 {
+		length := int(data[1])<<16 | int(data[2])<<8 | int(data[3])
+		if length >= tlsMaxHandshakeLength {
+			return messages, fmt.Errorf("TLS %v of %d bytes", TLSHandshakeType(data[0]), length)
+		}
+		if len(data) < 4+length {
+			break
+		}
+		var m TLSHandshakeMessage
+		if err := m.decode(data[:4+length], r); err != nil {
+			return messages, err
+		}
+		messages = append(messages, m)
+		data = data[4+length:]
+	}
+	if len(data) > 0 {
+		r.buf = append([]byte(nil), data...)
+	}
+	return messages, nil
+}
+
+// Pending returns the number of bytes of an incomplete message waiting for
+// the next fragment.
+func (r *TLSHandshakeReassembler) Pending() int {
+	return len(r.buf)
+}
+
+// plausible returns whether data could continue or start handshake
+// messages.
+func (r *TLSHandshakeReassembler) plausible(data []byte) bool {
+	if len(r.buf) > 0 || len(data) < 4 {
+		return true
+	}
+	length := int(data[1])<<16 | int(data[2])<<8 | int(data[3])
+	_, known := tlsHandshakeTypeNames[TLSHandshakeType(data[0])]
+	return known && length < tlsMaxHandshakeLength
+}
+
+// TLSHandshakeMessage is a handshake message.  The field matching its Type
+// is set for the messages decoded beyond their header.
+type TLSHandshakeMessage struct {
+	Type TLSHandshakeType
+	// Data is the body of the message, after its type and length.
+	Data []byte
+
+	ClientHello       *TLSClientHello
+	ServerHello       *TLSServerHello
+	Certificate       *TLSCertificate
+	ServerKeyExchange *TLSServerKeyExchange
+	NewSessionTicket  *TLSNewSessionTicket
+	Finished          *TLSFinished
+}
+
+func (m *TLSHandshakeMessage) decode(data []byte, r *TLSHandshakeReassembler) error {
+	m.Type = TLSHandshakeType(data[0])
+	m.Data = data[4:]
+	var err error
+	switch m.Type {
+	case TLSHandshakeClientHello:
+		m.ClientHello = &TLSClientHello{}
+		err = m.ClientHello.decode(m.Data)
+	case TLSHandshakeServerHello:
+		m.ServerHello = &TLSServerHello{}
+		if err = m.ServerHello.decode(m.Data); err == nil {
+			r.Version = m.ServerHello.SelectedVersion()
+			r.CipherSuite = m.ServerHello.CipherSuite
+		}
+	case TLSHandshakeCertificate:
+		m.Certificate = &TLSCertificate{}
+		err = m.Certificate.decode(m.Data, r.Version)
+	case TLSHandshakeServerKeyExchange:
+		m.ServerKeyExchange = &TLSServerKeyExchange{}
+		err = m.ServerKeyExchange.decode(m.Data, r.Version)
+	case TLSHandshakeNewSessionTicket:
+		m.NewSessionTicket = &TLSNewSessionTicket{}
+		err = m.NewSessionTicket.decode(m.Data, r.Version)
+	case TLSHandshakeFinished:
+		m.Finished = &TLSFinished{VerifyData: m.Data}
+	}
+	if err != nil {
+		return fmt.Errorf("TLS %v: %v", m.Type, err)
+	}
+	return nil
+}
+
+// serialize appends the message to data.  The body is serialized from the
+// field matching Type if it is set, and is Data otherwise.  The fields of
+// hellos which are decoded from their extensions aren't serialized; change
+// Extensions instead.
+func (m *TLSHandshakeMessage) serialize(data []byte) ([]byte, error) {
+	var body []byte
+	switch {
+	case m.Type == TLSHandshakeClientHello && m.ClientHello != nil:
+		body = m.ClientHello.serialize()
+	case m.Type == TLSHandshakeServerHello && m.ServerHello != nil:
+		body = m.ServerHello.serialize()
+	case m.Type == TLSHandshakeCertificate && m.Certificate != nil:
+		body = m.Certificate.serialize()
+	case m.Type == TLSHandshakeServerKeyExchange && m.ServerKeyExchange != nil:
+		body = m.ServerKeyExchange.serialize()
+	case m.Type == TLSHandshakeNewSessionTicket && m.NewSessionTicket != nil:
+		body = m.NewSessionTicket.serialize()
+	case m.Type == TLSHandshakeFinished && m.Finished != nil:
+		body = m.Finished.VerifyData
+	default:
+		body = m.Data
+	}
+	if len(body) >= 1<<24 {
+		return data, fmt.Errorf("TLS %v of %d bytes", m.Type, len(body))
+	}
+	data = append(data, byte(m.Type))
+	return tlsAppendVec24(data, body), nil
+}
+
+// TLSCipherSuite is a cipher suite identifier.
+type TLSCipherSuite uint16
+
+var tlsCipherSuiteNames = map[TLSCipherSuite]string{
+	0x0004: "TLS_RSA_WITH_RC4_128_MD5",
+	0x0005: "TLS_RSA_WITH_RC4_128_SHA",
+	0x000a: "TLS_RSA_WITH_3DES_EDE_CBC_SHA",
+	0x002f: "TLS_RSA_WITH_AES_128_CBC_SHA",
+	0x0033: "TLS_DHE_RSA_WITH_AES_128_CBC_SHA",
+	0x0035: "TLS_RSA_WITH_AES_256_CBC_SHA",
+	0x0039: "TLS_DHE_RSA_WITH_AES_256_CBC_SHA",
+	0x003c: "TLS_RSA_WITH_AES_128_CBC_SHA256",
+	0x003d: "TLS_RSA_WITH_AES_256_CBC_SHA256",
+	0x009c: "TLS_RSA_WITH_AES_128_GCM_SHA256",
+	0x009d: "TLS_RSA_WITH_AES_256_GCM_SHA384",
+	0x009e: "TLS_DHE_RSA_WITH_AES_128_GCM_SHA256",
+	0x009f: "TLS_DHE_RSA_WITH_AES_256_GCM_SHA384",
+	0x00ff: "TLS_EMPTY_RENEGOTIATION_INFO_SCSV",
+	0x1301: "TLS_AES_128_GCM_SHA256",
+	0x1302: "TLS_AES_256_GCM_SHA384",
+	0x1303: "TLS_CHACHA20_POLY1305_SHA256",
+	0x5600: "TLS_FALLBACK_SCSV",
+	0xc009: "TLS_ECDHE_ECDSA_WITH_AES_128_CBC_SHA",
+	0xc00a: "TLS_ECDHE_ECDSA_WITH_AES_256_CBC_SHA",
+	0xc011: "TLS_ECDHE_RSA_WITH_RC4_128_SHA",
+	0xc012: "TLS_ECDHE_RSA_WITH_3DES_EDE_CBC_SHA",
+	0xc013: "TLS_ECDHE_RSA_WITH_AES_128_CBC_SHA",
+	0xc014: "TLS_ECDHE_RSA_WITH_AES_256_CBC_SHA",
+	0xc023: "TLS_ECDHE_ECDSA_WITH_AES_128_CBC_SHA256",
+	0xc027: "TLS_ECDHE_RSA_WITH_AES_128_CBC_SHA256",
+	0xc02b: "TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256",
+	0xc02c: "TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384",
+	0xc02f: "TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256",
+	0xc030: "TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384",
+	0xcca8: "TLS_ECDHE_RSA_WITH_CHACHA20_POLY1305_SHA256",
+	0xcca9: "TLS_ECDHE_ECDSA_WITH_CHACHA20_POLY1305_SHA256",
+}
+
+// String returns the IANA name of the cipher suite.
+func (cs TLSCipherSuite) String() string {
+	if name, ok := tlsCipherSuiteNames[cs]; ok {
+		return name
+	}
+	if TLSIsGREASE(uint16(cs)) {
+		return "GREASE"
+	}
+	return fmt.Sprintf("0x%04x", uint16(cs))
+}
+
+// TLSNamedGroup is a named group (elliptic curve or finite field group) of
+// the supported_groups and key_share extensions.
+type TLSNamedGroup uint16
+
+var tlsNamedGroupNames = map[TLSNamedGroup]string{
+	23:     "secp256r1",
+	24:     "secp384r1",
+	25:     "secp521r1",
+	29:     "x25519",
+	30:     "x448",
+	256:    "ffdhe2048",
+	257:    "ffdhe3072",
+	258:    "ffdhe4096",
+	0x11ec: "X25519MLKEM768",
+}
+
+func (g TLSNamedGroup) String() string {
+	if name, ok := tlsNamedGroupNames[g]; ok {
+		return name
+	}
+	if TLSIsGREASE(uint16(g)) {
+		return "GREASE"
+	}
+	return fmt.Sprintf("0x%04x", uint16(g))
+}
+
+// TLSSignatureScheme is a signature algorithm of the signature_algorithms
+// extension, and of signatures.
+type TLSSignatureScheme uint16
+
+var tlsSignatureSchemeNames = map[TLSSignatureScheme]string{
+	0x0201: "rsa_pkcs1_sha1",
+	0x0203: "ecdsa_sha1",
+	0x0401: "rsa_pkcs1_sha256",
+	0x0403: "ecdsa_secp256r1_sha256",
+	0x0501: "rsa_pkcs1_sha384",
+	0x0503: "ecdsa_secp384r1_sha384",
+	0x0601: "rsa_pkcs1_sha512",
+	0x0603: "ecdsa_secp521r1_sha512",
+	0x0804: "rsa_pss_rsae_sha256",
+	0x0805: "rsa_pss_rsae_sha384",
+	0x0806: "rsa_pss_rsae_sha512",
+	0x0807: "ed25519",
+	0x0808: "ed448",
+	0x0809: "rsa_pss_pss_sha256",
+	0x080a: "rsa_pss_pss_sha384",
+	0x080b: "rsa_pss_pss_sha512",
+}
+
+func (ss TLSSignatureScheme) String() string {
+	if name, ok := tlsSignatureSchemeNames[ss]; ok {
+		return name
+	}
+	if TLSIsGREASE(uint16(ss)) {
+		return "GREASE"
+	}
+	return fmt.Sprintf("0x%04x", uint16(ss))
+}
+
+// TLSExtensionType is the type of a hello extension.
+type TLSExtensionType uint16
+
+// TLSExtensionType known values.
+const (
+	TLSExtensionServerName           TLSExtensionType = 0
+	TLSExtensionStatusRequest        TLSExtensionType = 5
+	TLSExtensionSupportedGroups      TLSExtensionType = 10
+	TLSExtensionECPointFormats       TLSExtensionType = 11
+	TLSExtensionSignatureAlgorithms  TLSExtensionType = 13
+	TLSExtensionALPN                 TLSExtensionType = 16
+	TLSExtensionSCT                  TLSExtensionType = 18
+	TLSExtensionPadding              TLSExtensionType = 21
+	TLSExtensionEncryptThenMAC       TLSExtensionType = 22
+	TLSExtensionExtendedMasterSecret TLSExtensionType = 23
+	TLSExtensionCompressCertificate  TLSExtensionType = 27
+	TLSExtensionSessionTicket        TLSExtensionType = 35
+	TLSExtensionPreSharedKey         TLSExtensionType = 41
+	TLSExtensionEarlyData            TLSExtensionType = 42
+	TLSExtensionSupportedVersions    TLSExtensionType = 43
+	TLSExtensionCookie               TLSExtensionType = 44
+	TLSExtensionPSKKeyExchangeModes  TLSExtensionType = 45
+	TLSExtensionSignatureAlgsCert    TLSExtensionType = 50
+	TLSExtensionKeyShare             TLSExtensionType = 51
+	TLSExtensionRenegotiationInfo    TLSExtensionType = 0xff01
+)
+
+var tlsExtensionTypeNames = map[TLSExtensionType]string{
+	TLSExtensionServerName:           "server_name",
+	TLSExtensionStatusRequest:        "status_request",
+	TLSExtensionSupportedGroups:      "supported_groups",
+	TLSExtensionECPointFormats:       "ec_point_formats",
+	TLSExtensionSignatureAlgorithms:  "signature_algorithms",
+	TLSExtensionALPN:                 "application_layer_protocol_negotiation",
+	TLSExtensionSCT:                  "signed_certificate_timestamp",
+	TLSExtensionPadding:              "padding",
+	TLSExtensionEncryptThenMAC:       "encrypt_then_mac",
+	TLSExtensionExtendedMasterSecret: "extended_master_secret",
+	TLSExtensionCompressCertificate:  "compress_certificate",
+	TLSExtensionSessionTicket:        "session_ticket",
+	TLSExtensionPreSharedKey:         "pre_shared_key",
+	TLSExtensionEarlyData:            "early_data",
+	TLSExtensionSupportedVersions:    "supported_versions",
+	TLSExtensionCookie:               "cookie",
+	TLSExtensionPSKKeyExchangeModes:  "psk_key_exchange_modes",
+	TLSExtensionSignatureAlgsCert:    "signature_algorithms_cert",
+	TLSExtensionKeyShare:             "key_share",
+	TLSExtensionRenegotiationInfo:    "renegotiation_info",
+}
+
+func (et TLSExtensionType) String() string {
+	if name, ok := tlsExtensionTypeNames[et]; ok {
+		return name
+	}
+	if TLSIsGREASE(uint16(et)) {
+		return "GREASE"
+	}
+	return fmt.Sprintf("%d", uint16(et))
+}
+
+// TLSIsGREASE returns whether v is one of the GREASE values of RFC 8701,
+// which clients send among cipher suites, extensions, groups, signature
+// algorithms and versions to keep servers tolerant of unknown values.
+// Fingerprints ignore them.
+func TLSIsGREASE(v uint16) bool {
+	return v&0x0f0f == 0x0a0a && v>>8 == v&0xff
+}
+
+// TLSExtension is a hello extension.
+type TLSExtension struct {
+	Type TLSExtensionType
+	Data []byte
+}
+
+// TLSKeyShare is an entry of the key_share extension.
+type TLSKeyShare struct {
+	Group       TLSNamedGroup
+	KeyExchange []byte
+}
+
+// TLSClientHello is a ClientHello message.  The fields following
+// Extensions are decoded from the extensions, and keep any GREASE values.
+type TLSClientHello struct {
+	// Version is the legacy_version, which is TLS 1.2 for TLS 1.3 clients.
+	Version            TLSVersion
+	Random             []byte
+	SessionID          []byte
+	CipherSuites       []TLSCipherSuite
+	CompressionMethods []uint8
+	Extensions         []TLSExtension
+
+	ServerName          string
+	ALPN                []string
+	SupportedVersions   []TLSVersion
+	SupportedGroups     []TLSNamedGroup
+	ECPointFormats      []uint8
+	SignatureAlgorithms []TLSSignatureScheme
+	KeyShares           []TLSKeyShare
+}
+
+// MaxVersion returns the highest version the client supports, ignoring
+// GREASE values.
+func (ch *TLSClientHello) MaxVersion() TLSVersion {
+	if len(ch.SupportedVersions) == 0 {
+		return ch.Version
+	}
+	var max TLSVersion
+	for _, v := range ch.SupportedVersions {
+		if !TLSIsGREASE(uint16(v)) && (max == 0 || tlsVersionNewer(v, max)) {
+			max = v
+		}
+	}
+	return max
+}
+
+// tlsVersionNewer returns whether a is a later version than b.  DTLS
+// versions count down from 0xfeff.
+func tlsVersionNewer(a, b TLSVersion) bool {
+	if a >= 0xfe00 && b >= 0xfe00 {
+		return a < b
+	}
+	return a > b
+}
+
+func (ch *TLSClientHello) decode(data []byte) error {
+	s := tlsBytes(data)
+	var suites []byte
+	if !s.u16((*uint16)(&ch.Version)) || !s.bytes(32, &ch.Random) || !s.vec8(&ch.SessionID) ||
+		!s.vec16(&suites) || len(suites)%2 != 0 || !s.vec8(&ch.CompressionMethods) {
+		return errors.New("malformed message")
+	}
+	ch.CipherSuites = make([]TLSCipherSuite, len(suites)/2)
+	for i := range ch.CipherSuites {
+		ch.CipherSuites[i] = TLSCipherSuite(suites[2*i])<<8 | TLSCipherSuite(suites[2*i+1])
+	}
+	var err error
+	if ch.Extensions, err = decodeTLSExtensions(s); err != nil {
+		return err
+	}
+	for _, e := range ch.Extensions {
+		d := tlsBytes(e.Data)
+		ok := true
+		switch e.Type {
+		case TLSExtensionServerName:
+			var list []byte
+			ok = d.vec16(&list)
+			l := tlsBytes(list)
+			for ok && len(l) > 0 {
+				var typ uint8
+				var name []byte
+				ok = l.u8(&typ) && l.vec16(&name)
+				if typ == 0 && ch.ServerName == "" {
+					ch.ServerName = string(name)
+				}
+			}
+		case TLSExtensionALPN:
+			var list []byte
+			ok = d.vec16(&list)
+			l := tlsBytes(list)
+			for ok && len(l) > 0 {
+				var proto []byte
+				if ok = l.vec8(&proto); ok {
+					ch.ALPN = append(ch.ALPN, string(proto))
+				}
+			}
+		case TLSExtensionSupportedVersions:
+			var list []uint16
+			ok = d.vec8u16(&list)
+			for _, v := range list {
+				ch.SupportedVersions = append(ch.SupportedVersions, TLSVersion(v))
+			}
+		case TLSExtensionSupportedGroups:
+			var list []uint16
+			ok = d.vec16u16(&list)
+			for _, v := range list {
+				ch.SupportedGroups = append(ch.SupportedGroups, TLSNamedGroup(v))
+			}
+		case TLSExtensionECPointFormats:
+			ok = d.vec8(&ch.ECPointFormats)
+		case TLSExtensionSignatureAlgorithms:
+			var list []uint16
+			ok = d.vec16u16(&list)
+			for _, v := range list {
+				ch.SignatureAlgorithms = append(ch.SignatureAlgorithms, TLSSignatureScheme(v))
+			}
+		case TLSExtensionKeyShare:
+			var list []byte
+			ok = d.vec16(&list)
+			l := tlsBytes(list)
+			for ok && len(l) > 0 {
+				var ks TLSKeyShare
+				if ok = l.u16((*uint16)(&ks.Group)) && l.vec16(&ks.KeyExchange); ok {
+					ch.KeyShares = append(ch.KeyShares, ks)
+				}
+			}
+		}
+		if !ok {
+			return fmt.Errorf("malformed extension %d", e.Type)
+		}
+	}
+	return nil
+}
+
+func (ch *TLSClientHello) serialize() []byte {
+	data := []byte{byte(ch.Version >> 8), byte(ch.Version)}
+	data = append(data, ch.Random...)
+	data = tlsAppendVec8(data, ch.SessionID)
+	data = tlsAppendU16(data, uint16(2*len(ch.CipherSuites)))
+	for _, cs := range ch.CipherSuites {
+		data = tlsAppendU16(data, uint16(cs))
+	}
+	data = tlsAppendVec8(data, ch.CompressionMethods)
+	return tlsAppendExtensions(data, ch.Extensions)
+}
+
+// tlsHelloRetryRequestRandom is the Random of ServerHellos which are
+// HelloRetryRequests, the SHA-256 of "HelloRetryRequest".
+var tlsHelloRetryRequestRandom = []byte{
+	0xcf, 0x21, 0xad, 0x74, 0xe5, 0x9a, 0x61, 0x11, 0xbe, 0x1d, 0x8c, 0x02, 0x1e, 0x65, 0xb8, 0x91,
+	0xc2, 0xa2, 0x11, 0x16, 0x7a, 0xbb, 0x8c, 0x5e, 0x07, 0x9e, 0x09, 0xe2, 0xc8, 0xa8, 0x33, 0x9c,
+}
+
+// TLSServerHello is a ServerHello or HelloRetryRequest message.  The fields
+// following Extensions are decoded from the extensions.
+type TLSServerHello struct {
+	// Version is the legacy_version, which is TLS 1.2 for TLS 1.3 servers.
+	Version           TLSVersion
+	Random            []byte
+	SessionID         []byte
+	CipherSuite       TLSCipherSuite
+	CompressionMethod uint8
+	Extensions        []TLSExtension
+	// HelloRetryRequest is set for TLS 1.3 HelloRetryRequests, which ask
+	// the client for a key share of KeyShare.Group.
+	HelloRetryRequest bool
+
+	SupportedVersion TLSVersion
+	ALPN             string
+	KeyShare         TLSKeyShare
+}
+
+// SelectedVersion returns the version chosen by the server.
+func (sh *TLSServerHello) SelectedVersion() TLSVersion {
+	if sh.SupportedVersion != 0 {
+		return sh.SupportedVersion
+	}
+	return sh.Version
+}
+
+func (sh *TLSServerHello) decode(data []byte) error {
+	s := tlsBytes(data)
+	if !s.u16((*uint16)(&sh.Version)) || !s.bytes(32, &sh.Random) || !s.vec8(&sh.SessionID) ||
+		!s.u16((*uint16)(&sh.CipherSuite)) || !s.u8(&sh.CompressionMethod) {
+		return errors.New("malformed message")
+	}
+	sh.HelloRetryRequest = bytes.Equal(sh.Random, tlsHelloRetryRequestRandom)
+	var err error
+	if sh.Extensions, err = decodeTLSExtensions(s); err != nil {
+		return err
+	}
+	for _, e := range sh.Extensions {
+		d := tlsBytes(e.Data)
+		ok := true
+		switch e.Type {
+		case TLSExtensionSupportedVersions:
+			ok = d.u16((*uint16)(&sh.SupportedVersion))
+		case TLSExtensionALPN:
+			var list, proto []byte
+			ok = d.vec16(&list)
+			l := tlsBytes(list)
+			if ok = ok && l.vec8(&proto); ok {
+				sh.ALPN = string(proto)
+			}
+		case TLSExtensionKeyShare:
+			ok = d.u16((*uint16)(&sh.KeyShare.Group))
+			if ok && !sh.HelloRetryRequest {
+				ok = d.vec16(&sh.KeyShare.KeyExchange)
+			}
+		}
+		if !ok {
+			return fmt.Errorf("malformed extension %d", e.Type)
+		}
+	}
+	return nil
+}
+
+func (sh *TLSServerHello) serialize() []byte {
+	data := []byte{byte(sh.Version >> 8), byte(sh.Version)}
+	data = append(data, sh.Random...)
+	data = tlsAppendVec8(data, sh.SessionID)
+	data = tlsAppendU16(data, uint16(sh.CipherSuite))
+	data = append(data, sh.CompressionMethod)
+	return tlsAppendExtensions(data, sh.Extensions)
+}
+
+func decodeTLSExtensions(s tlsBytes) ([]TLSExtension, error) {
+	if len(s) == 0 {
+		// Hellos without extensions may omit their length.
+		return nil, nil
+	}
+	var list []byte
+	if !s.vec16(&list) || len(s) != 0 {
+		return nil, errors.New("malformed extensions")
+	}
+	return decodeTLSExtensionList(list)
+}
+
+func decodeTLSExtensionList(l tlsBytes) ([]TLSExtension, error) {
+	// An empty list is kept apart from an absent one, for serialization.
+	extensions := []TLSExtension{}
+	for len(l) > 0 {
+		var e TLSExtension
+		if !l.u16((*uint16)(&e.Type)) || !l.vec16(&e.Data) {
+			return extensions, errors.New("malformed extensions")
+		}
+		extensions = append(extensions, e)
+	}
+	return extensions, nil
+}
+
+// tlsAppendExtensions appends a list of extensions, unless it is nil.
+func tlsAppendExtensions(data []byte, extensions []TLSExtension) []byte {
+	if extensions == nil {
+		return data
+	}
+	return tlsAppendVec16(data, tlsAppendExtensionList(nil, extensions))
+}
+
+func tlsAppendExtensionList(data []byte, extensions []TLSExtension) []byte {
+	for _, e := range extensions {
+		data = tlsAppendU16(data, uint16(e.Type))
+		data = tlsAppendVec16(data, e.Data)
+	}
+	return data
+}
+
+// TLSCertificate is a Certificate message.
+type TLSCertificate struct {
+	// RequestContext is the certificate_request_context of TLS 1.3.  It is
+	// non-nil for TLS 1.3 messages, which are serialized with it and
+	// Extensions.
+	RequestContext []byte
+	// Raw holds the DER certificates of the chain, leaf first.
+	Raw [][]byte
+	// Certificates holds Raw parsed, with nil for certificates which
+	// crypto/x509 can't parse.
+	Certificates []*x509.Certificate
+	// Extensions holds the extensions of each certificate in TLS 1.3.
+	Extensions [][]TLSExtension
+}
+
+func (c *TLSCertificate) decode(data []byte, version TLSVersion) error {
+	s := tlsBytes(data)
+	tls13 := version == 0x0304
+	if tls13 && !s.vec8(&c.RequestContext) {
+		return errors.New("malformed message")
+	}
+	var list []byte
+	if !s.vec24(&list) {
+		return errors.New("malformed message")
+	}
+	l := tlsBytes(list)
+	for len(l) > 0 {
+		var cert []byte
+		if !l.vec24(&cert) {
+			return errors.New("malformed certificate list")
+		}
+		if tls13 {
+			var exts []byte
+			if !l.vec16(&exts) {
+				return errors.New("malformed certificate list")
+			}
+			e, err := decodeTLSExtensionList(exts)
+			if err != nil {
+				return err
+			}
+			c.Extensions = append(c.Extensions, e)
+		}
+		c.Raw = append(c.Raw, cert)
+		parsed, err := x509.ParseCertificate(cert)
+		if err != nil {
+			parsed = nil
+		}
+		c.Certificates = append(c.Certificates, parsed)
+	}
+	return nil
+}
+
+func (c *TLSCertificate) serialize() []byte {
+	tls13 := c.RequestContext != nil
+	var data, list []byte
+	if tls13 {
+		data = tlsAppendVec8(data, c.RequestContext)
+	}
+	for i, cert := range c.Raw {
+		list = tlsAppendVec24(list, cert)
+		if tls13 {
+			var exts []TLSExtension
+			if i < len(c.Extensions) {
+				exts = c.Extensions[i]
+			}
+			list = tlsAppendVec16(list, tlsAppendExtensionList(nil, exts))
+		}
+	}
+	return tlsAppendVec24(data, list)
+}
 
+// TLSServerKeyExchange is a ServerKeyExchange message of an ephemeral
+// Diffie-Hellman key exchange, with either a named curve or explicit
+// finite field parameters.
+type TLSServerKeyExchange struct {
+	// CurveType is 3 for named curves, and 0 for finite field
+	// Diffie-Hellman.
+	CurveType uint8
+	Group     TLSNamedGroup
+	// P and G are the finite field parameters.
+	P, G []byte
+	// PublicKey is the server's ephemeral public key.
+	PublicKey []byte
+	// SignatureAlgorithm is set in TLS 1.2, which sends it.
+	SignatureAlgorithm TLSSignatureScheme
+	Signature          []byte
+}
+
+func (k *TLSServerKeyExchange) decode(data []byte, version TLSVersion) error {
+	s := tlsBytes(data)
+	if len(data) > 0 && data[0] == 3 {
+		if !s.u8(&k.CurveType) || !s.u16((*uint16)(&k.Group)) || !s.vec8(&k.PublicKey) {
+			return errors.New("malformed message")
+		}
+	} else if !s.vec16(&k.P) || !s.vec16(&k.G) || !s.vec16(&k.PublicKey) {
+		return errors.New("malformed message")
+	}
+	if len(s) == 0 {
+		// Anonymous key exchanges aren't signed.
+		return nil
+	}
+	if version >= 0x0303 && !s.u16((*uint16)(&k.SignatureAlgorithm)) {
+		return errors.New("malformed signature")
+	}
+	if !s.vec16(&k.Signature) || len(s) != 0 {
+		return errors.New("malformed signature")
+	}
 	return nil
 }
+
+func (k *TLSServerKeyExchange) serialize() []byte {
+	var data []byte
+	if k.CurveType == 3 {
+		data = append(data, k.CurveType)
+		data = tlsAppendU16(data, uint16(k.Group))
+		data = tlsAppendVec8(data, k.PublicKey)
+	} else {
+		data = tlsAppendVec16(data, k.P)
+		data = tlsAppendVec16(data, k.G)
+		data = tlsAppendVec16(data, k.PublicKey)
+	}
+	if k.Signature == nil {
+		return data
+	}
+	if k.SignatureAlgorithm != 0 {
+		data = tlsAppendU16(data, uint16(k.SignatureAlgorithm))
+	}
+	return tlsAppendVec16(data, k.Signature)
+}
+
+// TLSNewSessionTicket is a NewSessionTicket message.
+type TLSNewSessionTicket struct {
+	// Lifetime is the ticket lifetime in seconds.
+	Lifetime uint32
+	// AgeAdd, Nonce and Extensions are sent in TLS 1.3.  Messages are
+	// serialized in the TLS 1.3 format if Nonce is non-nil.
+	AgeAdd     uint32
+	Nonce      []byte
+	Ticket     []byte
+	Extensions []TLSExtension
+}
+
+func (n *TLSNewSessionTicket) decode(data []byte, version TLSVersion) error {
+	s := tlsBytes(data)
+	if !s.u32(&n.Lifetime) {
+		return errors.New("malformed message")
+	}
+	if version != 0x0304 {
+		if !s.vec16(&n.Ticket) {
+			return errors.New("malformed message")
+		}
+		return nil
+	}
+	if !s.u32(&n.AgeAdd) || !s.vec8(&n.Nonce) || !s.vec16(&n.Ticket) {
+		return errors.New("malformed message")
+	}
+	var err error
+	n.Extensions, err = decodeTLSExtensions(s)
+	return err
+}
+
+func (n *TLSNewSessionTicket) serialize() []byte {
+	data := tlsAppendU32(nil, n.Lifetime)
+	if n.Nonce == nil {
+		return tlsAppendVec16(data, n.Ticket)
+	}
+	data = tlsAppendU32(data, n.AgeAdd)
+	data = tlsAppendVec8(data, n.Nonce)
+	data = tlsAppendVec16(data, n.Ticket)
+	return tlsAppendVec16(data, tlsAppendExtensionList(nil, n.Extensions))
+}
+
+// TLSFinished is a Finished message.
+type TLSFinished struct {
+	VerifyData []byte
+}
+
+// tlsBytes reads the big-endian integers and length-prefixed vectors of TLS
+// messages.  Its methods return false if it is too short.
+type tlsBytes []byte
+
+func (s *tlsBytes) u8(v *uint8) bool {
+	if len(*s) < 1 {
+		return false
+	}
+	*v = (*s)[0]
+	*s = (*s)[1:]
+	return true
+}
+
+func (s *tlsBytes) u16(v *uint16) bool {
+	if len(*s) < 2 {
+		return false
+	}
+	*v = uint16((*s)[0])<<8 | uint16((*s)[1])
+	*s = (*s)[2:]
+	return true
+}
+
+func (s *tlsBytes) u32(v *uint32) bool {
+	if len(*s) < 4 {
+		return false
+	}
+	*v = uint32((*s)[0])<<24 | uint32((*s)[1])<<16 | uint32((*s)[2])<<8 | uint32((*s)[3])
+	*s = (*s)[4:]
+	return true
+}
+
+func (s *tlsBytes) bytes(n int, v *[]byte) bool {
+	if len(*s) < n {
+		return false
+	}
+	*v = (*s)[:n:n]
+	*s = (*s)[n:]
+	return true
+}
+
+func (s *tlsBytes) vec8(v *[]byte) bool {
+	var n uint8
+	return s.u8(&n) && s.bytes(int(n), v)
+}
+
+func (s *tlsBytes) vec16(v *[]byte) bool {
+	var n uint16
+	return s.u16(&n) && s.bytes(int(n), v)
+}
+
+func (s *tlsBytes) vec24(v *[]byte) bool {
+	if len(*s) < 3 {
+		return false
+	}
+	n := int((*s)[0])<<16 | int((*s)[1])<<8 | int((*s)[2])
+	*s = (*s)[3:]
+	return s.bytes(n, v)
+}
+
+func (s *tlsBytes) vec8u16(v *[]uint16) bool {
+	var b []byte
+	return s.vec8(&b) && tlsUint16s(b, v)
+}
+
+func (s *tlsBytes) vec16u16(v *[]uint16) bool {
+	var b []byte
+	return s.vec16(&b) && tlsUint16s(b, v)
+}
+
+func tlsUint16s(b []byte, v *[]uint16) bool {
+	if len(b)%2 != 0 {
+		return false
+	}
+	*v = make([]uint16, len(b)/2)
+	for i := range *v {
+		(*v)[i] = uint16(b[2*i])<<8 | uint16(b[2*i+1])
+	}
+	return true
+}
+
+func tlsAppendU16(data []byte, v uint16) []byte {
+	return append(data, byte(v>>8), byte(v))
+}
+
+func tlsAppendU32(data []byte, v uint32) []byte {
+	return append(data, byte(v>>24), byte(v>>16), byte(v>>8), byte(v))
+}
+
+func tlsAppendVec8(data, v []byte) []byte {
+	return append(append(data, byte(len(v))), v...)
+}
+
+func tlsAppendVec16(data, v []byte) []byte {
+	return append(tlsAppendU16(data, uint16(len(v))), v...)
+}
+
+func tlsAppendVec24(data, v []byte) []byte {
+	return append(append(data, byte(len(v)>>16), byte(len(v)>>8), byte(len(v))), v...)
+}
@@ -0,0 +1,423 @@
+// Copyright 2019 The GoPacket Authors. All rights reserved.
+//
+// Use of this source code is governed by a BSD-style license
+// that can be found in the LICENSE file in the root of the source
+// tree.
+
+package layers
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"math/big"
+	"net"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/gopacket"
+)
+
+// tlsRecordingConn records what is written to a connection.
+type tlsRecordingConn struct {
+	net.Conn
+	written bytes.Buffer
+}
+
+func (c *tlsRecordingConn) Write(b []byte) (int, error) {
+	c.written.Write(b)
+	return c.Conn.Write(b)
+}
+
+func testTLSCertificate(t *testing.T) tls.Certificate {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "example.com"},
+		DNSNames:     []string{"example.com"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}
+}
+
+// testTLSHandshake runs a handshake between crypto/tls peers, returning the
+// bytes sent by the client and the server.
+func testTLSHandshake(t *testing.T, version uint16) (client, server []byte, cert tls.Certificate) {
+	cert = testTLSCertificate(t)
+	c, s := net.Pipe()
+	cr := &tlsRecordingConn{Conn: c}
+	sr := &tlsRecordingConn{Conn: s}
+	cc := tls.Client(cr, &tls.Config{
+		ServerName:         "example.com",
+		NextProtos:         []string{"h2", "http/1.1"},
+		InsecureSkipVerify: true,
+		ClientSessionCache: tls.NewLRUClientSessionCache(1),
+		MinVersion:         version,
+		MaxVersion:         version,
+		CurvePreferences:   []tls.CurveID{tls.X25519},
+	})
+	sc := tls.Server(sr, &tls.Config{
+		Certificates:           []tls.Certificate{cert},
+		NextProtos:             []string{"h2"},
+		MinVersion:             version,
+		MaxVersion:             version,
+		CurvePreferences:       []tls.CurveID{tls.X25519},
+		SessionTicketsDisabled: version == tls.VersionTLS13,
+	})
+	errc := make(chan error, 1)
+	go func() {
+		errc <- sc.Handshake()
+	}()
+	if err := cc.Handshake(); err != nil {
+		t.Fatal("client:", err)
+	}
+	if err := <-errc; err != nil {
+		t.Fatal("server:", err)
+	}
+	// Closing the tls.Conns would block sending close_notify alerts.
+	c.Close()
+	s.Close()
+	return cr.written.Bytes(), sr.written.Bytes(), cert
+}
+
+func decodeTestTLS(t *testing.T, data []byte) *TLS {
+	var tl TLS
+	if err := tl.DecodeFromBytes(data, gopacket.NilDecodeFeedback); err != nil {
+		t.Fatal(err)
+	}
+	return &tl
+}
+
+// testTLSMessages returns the handshake messages of the records, and the
+// number of encrypted records.
+func testTLSMessages(records []TLSHandshakeRecord) (messages []TLSHandshakeMessage, encrypted int) {
+	for _, r := range records {
+		messages = append(messages, r.Messages...)
+		if r.Encrypted {
+			encrypted++
+		}
+	}
+	return
+}
+
+func testTLSMessageTypes(messages []TLSHandshakeMessage) []TLSHandshakeType {
+	var types []TLSHandshakeType
+	for _, m := range messages {
+		types = append(types, m.Type)
+	}
+	return types
+}
+
+func TestTLSHandshake12(t *testing.T) {
+	client, server, cert := testTLSHandshake(t, tls.VersionTLS12)
+
+	messages, encrypted := testTLSMessages(decodeTestTLS(t, client).Handshake)
+	want := []TLSHandshakeType{TLSHandshakeClientHello, TLSHandshakeClientKeyExchange}
+	if got := testTLSMessageTypes(messages); !reflect.DeepEqual(got, want) || encrypted != 1 {
+		t.Fatalf("client messages %v, %d encrypted, want %v, 1 encrypted", got, encrypted, want)
+	}
+	ch := messages[0].ClientHello
+	if ch.ServerName != "example.com" {
+		t.Errorf("server name %q", ch.ServerName)
+	}
+	if !reflect.DeepEqual(ch.ALPN, []string{"h2", "http/1.1"}) {
+		t.Errorf("ALPN %q", ch.ALPN)
+	}
+	if !reflect.DeepEqual(ch.SupportedGroups, []TLSNamedGroup{29}) {
+		t.Errorf("supported groups %v", ch.SupportedGroups)
+	}
+	if len(ch.SignatureAlgorithms) == 0 || len(ch.CipherSuites) == 0 {
+		t.Errorf("signature algorithms %v, cipher suites %v", ch.SignatureAlgorithms, ch.CipherSuites)
+	}
+	if ch.MaxVersion() != 0x0303 {
+		t.Errorf("max version %v", ch.MaxVersion())
+	}
+
+	messages, encrypted = testTLSMessages(decodeTestTLS(t, server).Handshake)
+	want = []TLSHandshakeType{
+		TLSHandshakeServerHello,
+		TLSHandshakeCertificate,
+		TLSHandshakeServerKeyExchange,
+		TLSHandshakeServerHelloDone,
+		TLSHandshakeNewSessionTicket,
+	}
+	if got := testTLSMessageTypes(messages); !reflect.DeepEqual(got, want) || encrypted != 1 {
+		t.Fatalf("server messages %v, %d encrypted, want %v, 1 encrypted", got, encrypted, want)
+	}
+	sh := messages[0].ServerHello
+	if sh.SelectedVersion() != 0x0303 || sh.ALPN != "h2" || sh.HelloRetryRequest {
+		t.Errorf("server hello %+v", sh)
+	}
+	c := messages[1].Certificate
+	if len(c.Raw) != 1 || !bytes.Equal(c.Raw[0], cert.Certificate[0]) {
+		t.Fatalf("certificates %x", c.Raw)
+	}
+	if c.Certificates[0] == nil || c.Certificates[0].Subject.CommonName != "example.com" {
+		t.Errorf("parsed certificate %v", c.Certificates[0])
+	}
+	ske := messages[2].ServerKeyExchange
+	if ske.CurveType != 3 || ske.Group != 29 || len(ske.PublicKey) != 32 ||
+		ske.SignatureAlgorithm != 0x0403 || len(ske.Signature) == 0 {
+		t.Errorf("server key exchange %+v", ske)
+	}
+	if len(messages[4].NewSessionTicket.Ticket) == 0 {
+		t.Error("empty session ticket")
+	}
+}
+
+func TestTLSHandshake13(t *testing.T) {
+	client, server, _ := testTLSHandshake(t, tls.VersionTLS13)
+
+	tl := decodeTestTLS(t, client)
+	ch := tl.Handshake[0].Messages[0].ClientHello
+	if ch.Version != 0x0303 || ch.MaxVersion() != 0x0304 {
+		t.Errorf("client version %v, max %v", ch.Version, ch.MaxVersion())
+	}
+	if len(ch.KeyShares) != 1 || ch.KeyShares[0].Group != 29 || len(ch.KeyShares[0].KeyExchange) != 32 {
+		t.Errorf("key shares %+v", ch.KeyShares)
+	}
+
+	// Everything after the ServerHello is encrypted application data.
+	tl = decodeTestTLS(t, server)
+	if len(tl.Handshake) != 1 || len(tl.Handshake[0].Messages) != 1 || len(tl.AppData) == 0 {
+		t.Fatalf("handshake %+v, %d application data records", tl.Handshake, len(tl.AppData))
+	}
+	sh := tl.Handshake[0].Messages[0].ServerHello
+	if sh.Version != 0x0303 || sh.SelectedVersion() != 0x0304 || sh.CipherSuite>>8 != 0x13 {
+		t.Errorf("server hello version %v, selected %v, cipher suite %v", sh.Version, sh.SelectedVersion(), sh.CipherSuite)
+	}
+	if sh.KeyShare.Group != 29 || len(sh.KeyShare.KeyExchange) != 32 {
+		t.Errorf("key share %+v", sh.KeyShare)
+	}
+
+	var r TLSHandshakeReassembler
+	if _, err := r.Add(tl.Handshake[0].Fragment); err != nil {
+		t.Fatal(err)
+	}
+	if r.Version != 0x0304 || r.CipherSuite != sh.CipherSuite {
+		t.Errorf("reassembler version %v, cipher suite %v", r.Version, r.CipherSuite)
+	}
+}
+
+func tlsTestRecord(typ TLSType, fragment []byte) []byte {
+	return append([]byte{byte(typ), 0x03, 0x03, byte(len(fragment) >> 8), byte(len(fragment))}, fragment...)
+}
+
+func TestTLSHelloRetryRequest(t *testing.T) {
+	body := []byte{0x03, 0x03}
+	body = append(body, tlsHelloRetryRequestRandom...)
+	body = append(body,
+		0x00,       // session id
+		0x13, 0x01, // cipher suite
+		0x00,       // compression method
+		0x00, 0x0c, // extensions
+		0x00, 0x2b, 0x00, 0x02, 0x03, 0x04, // supported_versions
+		0x00, 0x33, 0x00, 0x02, 0x00, 0x17, // key_share
+	)
+	msg := append([]byte{byte(TLSHandshakeServerHello), 0, 0, byte(len(body))}, body...)
+
+	tl := decodeTestTLS(t, tlsTestRecord(TLSHandshake, msg))
+	sh := tl.Handshake[0].Messages[0].ServerHello
+	if !sh.HelloRetryRequest || sh.SelectedVersion() != 0x0304 || sh.KeyShare.Group != 23 ||
+		sh.KeyShare.KeyExchange != nil || sh.CipherSuite.String() != "TLS_AES_128_GCM_SHA256" {
+		t.Errorf("hello retry request %+v", sh)
+	}
+}
+
+func TestTLSHandshakeFragmented(t *testing.T) {
+	_, server, _ := testTLSHandshake(t, tls.VersionTLS12)
+	whole := decodeTestTLS(t, server)
+	want, _ := testTLSMessages(whole.Handshake)
+
+	// Split the plaintext handshake messages into small records.
+	var plain []byte
+	for _, r := range whole.Handshake {
+		if !r.Encrypted {
+			plain = append(plain, r.Fragment...)
+		}
+	}
+	var data []byte
+	for rest := plain; len(rest) > 0; {
+		n := 50
+		if n > len(rest) {
+			n = len(rest)
+		}
+		data = append(data, tlsTestRecord(TLSHandshake, rest[:n])...)
+		rest = rest[n:]
+	}
+	got, encrypted := testTLSMessages(decodeTestTLS(t, data).Handshake)
+	if !reflect.DeepEqual(got, want) || encrypted != 0 {
+		t.Errorf("fragmented messages %v, %d encrypted, want %v", testTLSMessageTypes(got), encrypted, testTLSMessageTypes(want))
+	}
+
+	// Feed the messages a byte at a time, as across TCP segments.
+	var r TLSHandshakeReassembler
+	got = nil
+	for i := range plain {
+		m, err := r.Add(plain[i : i+1])
+		if err != nil {
+			t.Fatal(err)
+		}
+		got = append(got, m...)
+		if len(m) == 0 && r.Pending() == 0 && i != len(plain)-1 {
+			t.Fatalf("nothing pending at %d", i)
+		}
+	}
+	if !reflect.DeepEqual(got, want) || r.Pending() != 0 {
+		t.Errorf("reassembled messages %v, want %v", testTLSMessageTypes(got), testTLSMessageTypes(want))
+	}
+	if r.Version != 0x0303 {
+		t.Errorf("reassembler version %v", r.Version)
+	}
+}
+
+func TestTLSNewSessionTicket(t *testing.T) {
+	tl := decodeTestTLS(t, testNewSessionTicket)
+	if len(tl.Handshake) != 2 || len(tl.Handshake[0].Messages) != 1 || !tl.Handshake[1].Encrypted {
+		t.Fatalf("handshake %+v", tl.Handshake)
+	}
+	nst := tl.Handshake[0].Messages[0].NewSessionTicket
+	if nst.Lifetime != 7200 || !bytes.Equal(nst.Ticket, testNewSessionTicket[15:175]) {
+		t.Errorf("new session ticket %+v", nst)
+	}
+}
+
+func TestTLSServerCertificate(t *testing.T) {
+	tl := decodeTestTLS(t, testServerHello)
+	messages, _ := testTLSMessages(tl.Handshake)
+	c := messages[1].Certificate
+	if len(c.Raw) != 1 || c.Certificates[0] == nil {
+		t.Fatalf("certificate %+v", c)
+	}
+	if cn := c.Certificates[0].Subject.CommonName; cn != "SSLeay demo server" {
+		t.Errorf("subject %q", cn)
+	}
+}
+
+func TestTLSHandshakeMalformed(t *testing.T) {
+	// A ClientHello whose cipher suites run past the end of the message.
+	msg := []byte{byte(TLSHandshakeClientHello), 0, 0, 37, 0x03, 0x03}
+	msg = append(msg, make([]byte, 32)...)
+	msg = append(msg, 0x00, 0x00, 0x10)
+	data := append(tlsTestRecord(TLSHandshake, msg), tlsTestRecord(TLSAlert, []byte{2, 40})...)
+
+	// The records are kept, with the error.
+	var tl TLS
+	if err := tl.DecodeFromBytes(data, gopacket.NilDecodeFeedback); err != nil {
+		t.Fatal(err)
+	}
+	if len(tl.Handshake) != 1 || tl.Handshake[0].Err == nil || len(tl.Handshake[0].Messages) != 0 || len(tl.Alert) != 1 {
+		t.Errorf("handshake %+v, alerts %+v", tl.Handshake, tl.Alert)
+	}
+	p := gopacket.NewPacket(data, LayerTypeTLS, gopacket.Default)
+	if p.Layer(LayerTypeTLS) == nil || p.ErrorLayer() == nil || !strings.Contains(p.ErrorLayer().Error().Error(), "Client Hello") {
+		t.Errorf("packet %v", p)
+	}
+
+	// Each message's length is checked, not only that of the first.
+	var r TLSHandshakeReassembler
+	finished := append([]byte{byte(TLSHandshakeFinished), 0, 0, 12}, make([]byte, 12)...)
+	messages, err := r.Add(append(finished, byte(TLSHandshakeCertificate), 0x02, 0, 0))
+	if err == nil || len(messages) != 1 || r.Pending() != 0 {
+		t.Errorf("messages %v, error %v, %d bytes pending", testTLSMessageTypes(messages), err, r.Pending())
+	}
+}
+
+func TestTLSGREASE(t *testing.T) {
+	for _, v := range []uint16{0x0a0a, 0x1a1a, 0xfafa} {
+		if !TLSIsGREASE(v) || TLSCipherSuite(v).String() != "GREASE" || TLSExtensionType(v).String() != "GREASE" {
+			t.Errorf("%#04x isn't GREASE", v)
+		}
+	}
+	for _, v := range []uint16{0x0a1a, 0x0b0b, 0x1301, 0x0000} {
+		if TLSIsGREASE(v) {
+			t.Errorf("%#04x is GREASE", v)
+		}
+	}
+	ch := TLSClientHello{Version: 0x0303, SupportedVersions: []TLSVersion{0x7a7a, 0x0304, 0x0303}}
+	if ch.MaxVersion() != 0x0304 {
+		t.Errorf("max version %v", ch.MaxVersion())
+	}
+}
+
+func TestTLSSerializeHandshake(t *testing.T) {
+	for _, version := range []uint16{tls.VersionTLS12, tls.VersionTLS13} {
+		client, server, _ := testTLSHandshake(t, version)
+		for _, data := range [][]byte{client, server} {
+			tl := decodeTestTLS(t, data)
+			for i := range tl.Handshake {
+				if r := &tl.Handshake[i]; !r.Encrypted {
+					r.Fragment = nil
+					r.Length = 0
+				}
+			}
+			buf := gopacket.NewSerializeBuffer()
+			if err := tl.SerializeTo(buf, gopacket.SerializeOptions{FixLengths: true}); err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(buf.Bytes(), data) {
+				t.Errorf("version %x: serialized:\n%x\nwant:\n%x", version, buf.Bytes(), data)
+			}
+		}
+	}
+}
+
+func TestTLSSerializeMessages13(t *testing.T) {
+	cert := testTLSCertificate(t)
+	messages := []TLSHandshakeMessage{
+		{
+			Type: TLSHandshakeCertificate,
+			Certificate: &TLSCertificate{
+				RequestContext: []byte{},
+				Raw:            cert.Certificate,
+				Extensions:     [][]TLSExtension{{{Type: TLSExtensionStatusRequest, Data: []byte{1, 2}}}},
+			},
+		},
+		{
+			Type: TLSHandshakeNewSessionTicket,
+			NewSessionTicket: &TLSNewSessionTicket{
+				Lifetime:   7200,
+				AgeAdd:     0x01020304,
+				Nonce:      []byte{0},
+				Ticket:     []byte("ticket"),
+				Extensions: []TLSExtension{{Type: TLSExtensionEarlyData, Data: []byte{0, 0, 0x40, 0}}},
+			},
+		},
+	}
+	var data []byte
+	for i := range messages {
+		var err error
+		if data, err = messages[i].serialize(data); err != nil {
+			t.Fatal(err)
+		}
+	}
+	r := TLSHandshakeReassembler{Version: 0x0304}
+	got, err := r.Add(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 2 || got[0].Certificate == nil || got[1].NewSessionTicket == nil {
+		t.Fatalf("decoded %+v", got)
+	}
+	c, want := got[0].Certificate, messages[0].Certificate
+	if !reflect.DeepEqual(c.RequestContext, want.RequestContext) || !reflect.DeepEqual(c.Raw, want.Raw) || !reflect.DeepEqual(c.Extensions, want.Extensions) {
+		t.Errorf("certificate %+v, want %+v", c, want)
+	}
+	if !reflect.DeepEqual(got[1].NewSessionTicket, messages[1].NewSessionTicket) {
+		t.Errorf("ticket %+v, want %+v", got[1].NewSessionTicket, messages[1].NewSessionTicket)
+	}
+}
@@ -38,15 +38,47 @@ var testClientHelloDecoded = &TLS{
 	ChangeCipherSpec: nil,
 	Handshake: []TLSHandshakeRecord{
 		{
-			TLSRecordHeader{
+			TLSRecordHeader: TLSRecordHeader{
 				ContentType: 22,
 				Version:     0x0301,
 				Length:      209,
 			},
+			Fragment: testClientHello[59:],
+			Messages: []TLSHandshakeMessage{
+				{
+					Type: TLSHandshakeClientHello,
+					Data: testClientHello[63:],
+					ClientHello: &TLSClientHello{
+						Version:   0x0301,
+						Random:    testClientHello[65:97],
+						SessionID: []byte{},
+						CipherSuites: []TLSCipherSuite{
+							0xc014, 0xc00a, 0x0039, 0x0038, 0x0088, 0x0087, 0xc00f, 0xc005, 0x0035,
+							0x0084, 0xc013, 0xc009, 0x0033, 0x0032, 0x009a, 0x0099, 0x0045, 0x0044,
+							0xc00e, 0xc004, 0x002f, 0x0096, 0x0041, 0xc011, 0xc007, 0xc00c, 0xc002,
+							0x0005, 0x0004, 0xc012, 0xc008, 0x0016, 0x0013, 0xc00d, 0xc003, 0x000a,
+							0x0015, 0x0012, 0x0009, 0x0014, 0x0011, 0x0008, 0x0006, 0x0003, 0x00ff,
+						},
+						CompressionMethods: []uint8{1, 0},
+						Extensions: []TLSExtension{
+							{Type: TLSExtensionECPointFormats, Data: testClientHello[199:203]},
+							{Type: TLSExtensionSupportedGroups, Data: testClientHello[207:259]},
+							{Type: TLSExtensionSessionTicket, Data: []byte{}},
+							{Type: 15, Data: testClientHello[267:268]},
+						},
+						SupportedGroups: []TLSNamedGroup{
+							14, 13, 25, 11, 12, 24, 9, 10, 22, 23, 8, 6, 7,
+							20, 21, 4, 5, 18, 19, 1, 2, 3, 15, 16, 17,
+						},
+						ECPointFormats: []uint8{0, 1, 2},
+					},
+				},
+			},
 		},
 	},
-	AppData: nil,
-	Alert:   nil,
+	AppData:     nil,
+	Alert:       nil,
+	RecordOrder: []TLSType{TLSHandshake},
 }
 
 // Packet 6 - Server Hello, Certificate, Server Hello Done
@@ -112,22 +144,32 @@ var testClientKeyExchangeDecoded = &TLS{
 	},
 	Handshake: []TLSHandshakeRecord{
 		{
-			TLSRecordHeader{
+			TLSRecordHeader: TLSRecordHeader{
 				ContentType: 22,
 				Version:     0x0301,
 				Length:      70,
 			},
+			Fragment: testClientKeyExchange[5:75],
+			Messages: []TLSHandshakeMessage{
+				{
+					Type: TLSHandshakeClientKeyExchange,
+					Data: testClientKeyExchange[9:75],
+				},
+			},
 		},
 		{
-			TLSRecordHeader{
+			TLSRecordHeader: TLSRecordHeader{
 				ContentType: 22,
 				Version:     0x0301,
 				Length:      48,
 			},
+			Fragment:  testClientKeyExchange[86:],
+			Encrypted: true,
 		},
 	},
-	AppData: nil,
-	Alert:   nil,
+	AppData:     nil,
+	Alert:       nil,
+	RecordOrder: []TLSType{TLSHandshake, TLSChangeCipherSpec, TLSHandshake},
 }
 
 // Packet 9 - New Session Ticket, Change Cipher Spec, Encryption Handshake Message
@@ -182,7 +224,8 @@ var testDoubleAppDataDecoded = &TLS{
 			testDoubleAppData[42 : 42+32],
 		},
 	},
-	Alert: nil,
+	Alert:       nil,
+	RecordOrder: []TLSType{TLSApplicationData, TLSApplicationData},
 }
 
 var testAlertEncrypted = []byte{
@@ -210,6 +253,7 @@ var testAlertEncryptedDecoded = &TLS{
 			testAlertEncrypted[5:],
 		},
 	},
+	RecordOrder: []TLSType{TLSAlert},
 }
 
 // Malformed TLS records
@@ -337,3 +381,106 @@ func TestParseTLSAlertEncrypted(t *testing.T) {
 		t.Error("No TLS layer type found in packet")
 	}
 }
+
+func serializeTestTLS(t *testing.T, tl *TLS, opts gopacket.SerializeOptions) []byte {
+	buf := gopacket.NewSerializeBuffer()
+	if err := tl.SerializeTo(buf, opts); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestSerializeTLS(t *testing.T) {
+	for _, data := range [][]byte{
+		testClientHello[54:],
+		testServerHello,
+		testClientKeyExchange,
+		testNewSessionTicket,
+		testDoubleAppData,
+		testAlertEncrypted,
+	} {
+		var tl TLS
+		if err := tl.DecodeFromBytes(data, gopacket.NilDecodeFeedback); err != nil {
+			t.Fatal(err)
+		}
+		if got := serializeTestTLS(t, &tl, gopacket.SerializeOptions{}); !reflect.DeepEqual(got, data) {
+			t.Errorf("serialized:\n%x\nwant:\n%x", got, data)
+		}
+
+		// Lengths are recomputed and handshake messages reserialized.
+		for i := range tl.ChangeCipherSpec {
+			tl.ChangeCipherSpec[i].Length = 0
+		}
+		for i := range tl.Handshake {
+			tl.Handshake[i].Length = 0
+			if len(tl.Handshake[i].Messages) > 0 {
+				tl.Handshake[i].Fragment = nil
+			}
+		}
+		for i := range tl.AppData {
+			tl.AppData[i].Length = 0
+		}
+		for i := range tl.Alert {
+			tl.Alert[i].Length = 0
+		}
+		got := serializeTestTLS(t, &tl, gopacket.SerializeOptions{FixLengths: true})
+		if !reflect.DeepEqual(got, data) {
+			t.Errorf("serialized with fixed lengths:\n%x\nwant:\n%x", got, data)
+		}
+
+		var again TLS
+		if err := again.DecodeFromBytes(got, gopacket.NilDecodeFeedback); err != nil {
+			t.Fatal(err)
+		}
+		var want TLS
+		want.DecodeFromBytes(data, gopacket.NilDecodeFeedback)
+		if !reflect.DeepEqual(&again, &want) {
+			t.Errorf("decoded serialization:\n%#v\nwant:\n%#v", &again, &want)
+		}
+	}
+}
+
+func TestSerializeTLSRecordOrder(t *testing.T) {
+	tl := &TLS{
+		ChangeCipherSpec: []TLSChangeCipherSpecRecord{
+			{TLSRecordHeader{Version: 0x0303}, TLSChangecipherspecMessage},
+		},
+		Handshake: []TLSHandshakeRecord{
+			{
+				TLSRecordHeader: TLSRecordHeader{Version: 0x0303},
+				Messages: []TLSHandshakeMessage{
+					{Type: TLSHandshakeServerHelloDone},
+				},
+			},
+		},
+		Alert: []TLSAlertRecord{
+			{TLSRecordHeader: TLSRecordHeader{Version: 0x0303}, Level: TLSAlertFatal, Description: TLSAlertDecodeError},
+		},
+	}
+	opts := gopacket.SerializeOptions{FixLengths: true}
+	want := []byte{
+		0x14, 0x03, 0x03, 0x00, 0x01, 0x01,
+		0x16, 0x03, 0x03, 0x00, 0x04, 0x0e, 0x00, 0x00, 0x00,
+		0x15, 0x03, 0x03, 0x00, 0x02, 0x02, 0x32,
+	}
+	if got := serializeTestTLS(t, tl, opts); !reflect.DeepEqual(got, want) {
+		t.Errorf("serialized:\n%x\nwant:\n%x", got, want)
+	}
+
+	tl.RecordOrder = []TLSType{TLSAlert, TLSHandshake, TLSChangeCipherSpec}
+	want = append(append(append([]byte(nil), want[15:]...), want[6:15]...), want[:6]...)
+	if got := serializeTestTLS(t, tl, opts); !reflect.DeepEqual(got, want) {
+		t.Errorf("serialized in order:\n%x\nwant:\n%x", got, want)
+	}
+
+	for _, order := range [][]TLSType{
+		{TLSAlert, TLSHandshake},
+		{TLSAlert, TLSHandshake, TLSChangeCipherSpec, TLSAlert},
+		{TLSAlert, TLSHandshake, TLSUnknown},
+	} {
+		tl.RecordOrder = order
+		if err := tl.SerializeTo(gopacket.NewSerializeBuffer(), opts); err == nil {
+			t.Errorf("no error for record order %v", order)
+		}
+	}
+}
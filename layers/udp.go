@@ -20,6 +20,7 @@ type UDP struct {
 	SrcPort, DstPort UDPPort
 	Length           uint16
 	Checksum         uint16
+	ChecksumStatus   gopacket.ChecksumStatus
 	sPort, dPort     []byte
 	tcpipchecksum
 }
@@ -38,6 +39,7 @@ func (udp *UDP) DecodeFromBytes(data []byte, df gopacket.DecodeFeedback) error {
 	udp.dPort = data[2:4]
 	udp.Length = binary.BigEndian.Uint16(data[4:6])
 	udp.Checksum = binary.BigEndian.Uint16(data[6:8])
+	udp.ChecksumStatus = gopacket.ChecksumUnverified
 	udp.BaseLayer = BaseLayer{Contents: data[:8]}
 	switch {
 	case udp.Length >= 8:
@@ -95,6 +97,18 @@ func (u *UDP) SerializeTo(b gopacket.SerializeBuffer, opts gopacket.SerializeOpt
 	return nil
 }
 
+// VerifyChecksum implements gopacket.ChecksumVerifier, checking the UDP
+// checksum over the pseudo-header of network, which must be an *IPv4 or
+// *IPv6.  A zero checksum over IPv4 means no checksum was computed.
+func (u *UDP) VerifyChecksum(network gopacket.NetworkLayer) gopacket.ChecksumStatus {
+	if _, ok := network.(*IPv4); ok && u.Checksum == 0 {
+		u.ChecksumStatus = gopacket.ChecksumZero
+	} else {
+		u.ChecksumStatus = verifyTCPIPChecksum(network, IPProtocolUDP, len(u.Contents)+len(u.Payload), u.Checksum, u.Contents, u.Payload)
+	}
+	return u.ChecksumStatus
+}
+
 func (u *UDP) CanDecode() gopacket.LayerClass {
 	return LayerTypeUDP
 }
@@ -18,6 +18,7 @@ type UDPLite struct {
 	SrcPort, DstPort UDPLitePort
 	ChecksumCoverage uint16
 	Checksum         uint16
+	ChecksumStatus   gopacket.ChecksumStatus
 	sPort, dPort     []byte
 }
 
@@ -39,6 +40,25 @@ func decodeUDPLite(data []byte, p gopacket.PacketBuilder) error {
 	return p.NextDecoder(gopacket.LayerTypePayload)
 }
 
+// VerifyChecksum implements gopacket.ChecksumVerifier, checking the UDP-Lite
+// checksum over the pseudo-header of network, which must be an *IPv4 or
+// *IPv6, and the first ChecksumCoverage bytes of the packet.
+func (u *UDPLite) VerifyChecksum(network gopacket.NetworkLayer) gopacket.ChecksumStatus {
+	length := len(u.Contents) + len(u.Payload)
+	coverage := int(u.ChecksumCoverage)
+	switch {
+	case coverage == 0:
+		// The checksum covers the entire packet.
+		coverage = length
+	case coverage < 8 || coverage > length:
+		// Such packets must be discarded (rfc 3828 section 3.1).
+		u.ChecksumStatus = gopacket.ChecksumBad
+		return u.ChecksumStatus
+	}
+	u.ChecksumStatus = verifyTCPIPChecksum(network, IPProtocolUDPLite, length, u.Checksum, u.Contents, u.Payload[:coverage-len(u.Contents)])
+	return u.ChecksumStatus
+}
+
 func (u *UDPLite) TransportFlow() gopacket.Flow {
 	return gopacket.NewFlow(EndpointUDPLitePort, u.sPort, u.dPort)
 }
@@ -17,6 +17,7 @@ import (
 	"reflect"
 	"runtime/debug"
 	"strings"
+	"sync"
 	"syscall"
 	"time"
 )
@@ -99,6 +100,19 @@ type Packet interface {
 	Metadata() *PacketMetadata
 }
 
+// Releaser is implemented by the packets of this package, read by a
+// PacketSource or created by NewPacket.  Release returns the packet's data
+// to the BufferPool it was borrowed from, for packets read by a PacketSource
+// with a Pool.  Neither the packet nor its layers or data may be used
+// afterwards.  For other packets, Release does nothing.
+//
+//	if r, ok := packet.(gopacket.Releaser); ok {
+//		r.Release()
+//	}
+type Releaser interface {
+	Release()
+}
+
 // packet contains all the information we need to fulfill the Packet interface,
 // and its two "subclasses" (yes, no such thing in Go, bear with me),
 // eagerPacket and lazyPacket, provide eager and lazy decoding logic around the
@@ -113,6 +127,9 @@ type packet struct {
 	layers []Layer
 	// last is the last layer added to the packet
 	last Layer
+	// lastNetwork is the last network layer added to the packet, used for
+	// checksum verification
+	lastNetwork NetworkLayer
 	// metadata is the PacketMetadata for this packet
 	metadata PacketMetadata
 
@@ -124,6 +141,10 @@ type packet struct {
 	transport   TransportLayer
 	application ApplicationLayer
 	failure     ErrorLayer
+
+	// pool is the BufferPool data was borrowed from, if any
+	pool     *BufferPool
+	released bool
 }
 
 func (p *packet) SetTruncated() {
@@ -163,6 +184,18 @@ func (p *packet) SetErrorLayer(l ErrorLayer) {
 func (p *packet) AddLayer(l Layer) {
 	p.layers = append(p.layers, l)
 	p.last = l
+	if p.decodeOptions.VerifyChecksums {
+		p.verifyChecksum(l)
+	}
+}
+
+func (p *packet) verifyChecksum(l Layer) {
+	if cv, ok := l.(ChecksumVerifier); ok && !p.metadata.Truncated {
+		cv.VerifyChecksum(p.lastNetwork)
+	}
+	if n, ok := l.(NetworkLayer); ok {
+		p.lastNetwork = n
+	}
 }
 
 func (p *packet) DumpPacketData() {
@@ -178,6 +211,25 @@ func (p *packet) Data() []byte {
 	return p.data
 }
 
+func (p *packet) Release() {
+	if p.pool == nil {
+		return
+	}
+	if p.released {
+		if poolDebug {
+			panic("gopacket: packet released twice")
+		}
+		return
+	}
+	p.released = true
+	p.pool.Put(p.data)
+}
+
+// borrow records that p's data was borrowed from pool.
+func (p *packet) borrow(pool *BufferPool) {
+	p.pool = pool
+}
+
 func (p *packet) DecodeOptions() *DecodeOptions {
 	return &p.decodeOptions
 }
@@ -491,6 +543,9 @@ func (p *eagerPacket) LayerClass(lc LayerClass) Layer {
 }
 func (p *eagerPacket) String() string { return p.packetString() }
 func (p *eagerPacket) Dump() string   { return p.packetDump() }
+func (p *eagerPacket) MarshalJSON() ([]byte, error) {
+	return MarshalPacketJSON(p)
+}
 
 // lazyPacket does lazy decoding on its packet data.  On construction it does
 // no initial decoding.  For each function call, it decodes only as many layers
@@ -603,6 +658,9 @@ func (p *lazyPacket) LayerClass(lc LayerClass) Layer {
 }
 func (p *lazyPacket) String() string { p.Layers(); return p.packetString() }
 func (p *lazyPacket) Dump() string   { p.Layers(); return p.packetDump() }
+func (p *lazyPacket) MarshalJSON() ([]byte, error) {
+	return MarshalPacketJSON(p)
+}
 
 // DecodeOptions tells gopacket how to decode a packet.
 type DecodeOptions struct {
@@ -628,6 +686,12 @@ type DecodeOptions struct {
 	// This is disabled by default because the reassembly package drives the decoding
 	// of TCP payload data after reassembly.
 	DecodeStreamsAsDatagrams bool
+	// VerifyChecksums checks the checksum of every decoded layer that
+	// implements ChecksumVerifier, using the closest preceding network layer
+	// for pseudo-headers.  The result is stored in each layer; a bad checksum
+	// does not stop decoding and doesn't add an ErrorLayer.  Checksums are not
+	// verified once the packet is known to be truncated.
+	VerifyChecksums bool
 }
 
 // Default decoding provides the safest (but slowest) method for decoding
@@ -784,7 +848,27 @@ type PacketSource struct {
 	// of packet data.  This can/should be changed by the user to reflect the
 	// way packets should be decoded.
 	DecodeOptions
-	c chan Packet
+	// Workers is the number of goroutines Packets decodes packets on.  If it
+	// is 0 or 1, packets are decoded on the goroutine reading them, as
+	// NextPacket does; otherwise reading, decoding and delivery run in
+	// parallel, and packets are still delivered in capture order.
+	Workers int
+	// FlowAffinity relaxes the ordering Packets keeps when Workers is greater
+	// than 1: all packets of a flow (in either direction) are decoded by the
+	// same worker, as chosen by the FastHash of their network and transport
+	// flows, so each flow's packets are delivered in capture order but
+	// packets of different flows may be reordered.  This saves the cost of
+	// restoring the global order, for consumers which only need per-flow
+	// order, like reassembly.
+	FlowAffinity bool
+	// Pool, if set, saves allocating and copying the data of each packet.
+	// The data of packets from a ZeroCopyPacketDataSource is copied into
+	// buffers borrowed from Pool, which go back to it when the packet is
+	// released through Releaser; packets which aren't released are simply
+	// garbage collected.  The data of packets from other sources, which
+	// is already freshly allocated, is decoded without copying it again.
+	Pool *BufferPool
+	c    chan Packet
 }
 
 // NewPacketSource creates a packet data source.
@@ -798,15 +882,74 @@ func NewPacketSource(source PacketDataSource, decoder Decoder) *PacketSource {
 // NextPacket returns the next decoded packet from the PacketSource.  On error,
 // it returns a nil packet and a non-nil error.
 func (p *PacketSource) NextPacket() (Packet, error) {
-	data, ci, err := p.source.ReadPacketData()
+	data, ci, err := p.readPacketData()
 	if err != nil {
 		return nil, err
 	}
-	packet := NewPacket(data, p.decoder, p.DecodeOptions)
+	return p.newPacket(data, ci, p.DecodeOptions), nil
+}
+
+// readPacketData reads the next packet's data from the source.  With a Pool,
+// zero-copy sources are read into a buffer borrowed from the pool.
+func (p *PacketSource) readPacketData() ([]byte, CaptureInfo, error) {
+	if p.Pool == nil {
+		return p.source.ReadPacketData()
+	}
+	zc, ok := p.source.(ZeroCopyPacketDataSource)
+	if !ok {
+		return p.source.ReadPacketData()
+	}
+	data, ci, err := zc.ZeroCopyReadPacketData()
+	if err != nil {
+		return nil, ci, err
+	}
+	buf := p.Pool.Get(len(data))
+	copy(buf, data)
+	return buf, ci, nil
+}
+
+func (p *PacketSource) newPacket(data []byte, ci CaptureInfo, opts DecodeOptions) Packet {
+	if p.Pool != nil {
+		// data is ours, either borrowed from the pool or freshly allocated by
+		// the source, so there's no need for NewPacket to copy it.
+		opts.NoCopy = true
+	}
+	packet := NewPacket(data, p.decoder, opts)
+	if p.Pool != nil {
+		if _, ok := p.source.(ZeroCopyPacketDataSource); ok {
+			packet.(interface{ borrow(*BufferPool) }).borrow(p.Pool)
+		}
+	}
 	m := packet.Metadata()
 	m.CaptureInfo = ci
 	m.Truncated = m.Truncated || ci.CaptureLength < ci.Length
-	return packet, nil
+	return packet
+}
+
+// retry returns true if reading should be retried after err, sleeping first
+// if it might help.  It returns false for errors which end the source.
+func retry(err error) bool {
+	// Immediately retry for temporary network errors
+	if nerr, ok := err.(net.Error); ok && nerr.Temporary() {
+		return true
+	}
+
+	// Immediately retry for EAGAIN
+	if err == syscall.EAGAIN {
+		return true
+	}
+
+	// Immediately break for known unrecoverable errors
+	if err == io.EOF || err == io.ErrUnexpectedEOF ||
+		err == io.ErrNoProgress || err == io.ErrClosedPipe || err == io.ErrShortBuffer ||
+		err == syscall.EBADF ||
+		strings.Contains(err.Error(), "use of closed file") {
+		return false
+	}
+
+	// Sleep briefly and try again
+	time.Sleep(time.Millisecond * time.Duration(5))
+	return true
 }
 
 // packetsToChannel reads in all packets from the packet source and sends them
@@ -820,28 +963,118 @@ func (p *PacketSource) packetsToChannel() {
 			p.c <- packet
 			continue
 		}
+		if !retry(err) {
+			break
+		}
+	}
+}
 
-		// Immediately retry for temporary network errors
-		if nerr, ok := err.(net.Error); ok && nerr.Temporary() {
-			continue
+// decodeJob is a packet handed to a decoding worker.  If packet is set, it
+// has been partially decoded by the reader to find its flow.
+type decodeJob struct {
+	data   []byte
+	ci     CaptureInfo
+	packet Packet
+}
+
+// workerQueueSize is the number of packets queued for each worker.
+const workerQueueSize = 128
+
+// parallelPacketsToChannel is packetsToChannel for Workers > 1.  The reader
+// hands packets to the workers in turn, and a collector takes the decoded
+// packets from the workers in the same turn, so capture order is kept
+// without sequence numbers.  With FlowAffinity, the reader instead hands
+// packets to workers by flow, and the workers deliver them directly.
+func (p *PacketSource) parallelPacketsToChannel() {
+	n := p.Workers
+	ins := make([]chan decodeJob, n)
+	outs := make([]chan Packet, n)
+	var wg sync.WaitGroup
+	wg.Add(n)
+	for i := range ins {
+		ins[i] = make(chan decodeJob, workerQueueSize)
+		out := p.c
+		if !p.FlowAffinity {
+			outs[i] = make(chan Packet, workerQueueSize)
+			out = outs[i]
 		}
+		go p.decodeWorker(ins[i], out, &wg)
+	}
+	if p.FlowAffinity {
+		go func() {
+			wg.Wait()
+			close(p.c)
+		}()
+	} else {
+		go func() {
+			defer close(p.c)
+			for i := 0; ; i = (i + 1) % n {
+				packet, ok := <-outs[i]
+				if !ok {
+					return
+				}
+				p.c <- packet
+			}
+		}()
+	}
 
-		// Immediately retry for EAGAIN
-		if err == syscall.EAGAIN {
+	defer func() {
+		for _, in := range ins {
+			close(in)
+		}
+	}()
+	lazy := p.DecodeOptions
+	lazy.Lazy = true
+	for i := 0; ; {
+		data, ci, err := p.readPacketData()
+		if err != nil {
+			if !retry(err) {
+				return
+			}
 			continue
 		}
+		job := decodeJob{data: data, ci: ci}
+		if p.FlowAffinity {
+			// Decoding as far as the transport layer is much cheaper than a
+			// full decode, and its work isn't lost: the worker finishes it.
+			job.packet = p.newPacket(data, ci, lazy)
+			i = int(packetFlowHash(job.packet) % uint64(n))
+		}
+		ins[i] <- job
+		if !p.FlowAffinity {
+			i = (i + 1) % n
+		}
+	}
+}
 
-		// Immediately break for known unrecoverable errors
-		if err == io.EOF || err == io.ErrUnexpectedEOF ||
-			err == io.ErrNoProgress || err == io.ErrClosedPipe || err == io.ErrShortBuffer ||
-			err == syscall.EBADF ||
-			strings.Contains(err.Error(), "use of closed file") {
-			break
+func (p *PacketSource) decodeWorker(in <-chan decodeJob, out chan<- Packet, wg *sync.WaitGroup) {
+	defer wg.Done()
+	if out != p.c {
+		defer close(out)
+	}
+	for job := range in {
+		packet := job.packet
+		if packet == nil {
+			packet = p.newPacket(job.data, job.ci, p.DecodeOptions)
+		} else if !p.Lazy {
+			// Finish decoding, so the packet is safe for concurrent use.
+			packet.Layers()
 		}
+		out <- packet
+	}
+}
 
-		// Sleep briefly and try again
-		time.Sleep(time.Millisecond * time.Duration(5))
+// packetFlowHash returns a hash which is the same for all packets of a flow,
+// in both directions.
+func packetFlowHash(packet Packet) uint64 {
+	var h uint64
+	if network := packet.NetworkLayer(); network != nil {
+		h = network.NetworkFlow().FastHash()
+	}
+	if transport := packet.TransportLayer(); transport != nil {
+		h = h*fnvPrime ^ transport.TransportFlow().FastHash()
 	}
+	return h
 }
 
 // Packets returns a channel of packets, allowing easy iterating over
@@ -855,10 +1088,22 @@ func (p *PacketSource) packetsToChannel() {
 //  }
 //
 // If called more than once, returns the same channel.
+//
+// Set Workers to decode packets in parallel, which helps when decoding is the
+// bottleneck, such as when reading a capture file:
+//
+//  packetSource.Workers = runtime.NumCPU()
+//  for packet := range packetSource.Packets() {
+//    handlePacket(packet)  // Still called in capture order.
+//  }
 func (p *PacketSource) Packets() chan Packet {
 	if p.c == nil {
 		p.c = make(chan Packet, 1000)
-		go p.packetsToChannel()
+		if p.Workers > 1 {
+			go p.parallelPacketsToChannel()
+		} else {
+			go p.packetsToChannel()
+		}
 	}
 	return p.c
 }
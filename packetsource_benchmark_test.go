@@ -0,0 +1,34 @@
+// Copyright 2019 The GoPacket Authors. All rights reserved.
+//
+// Use of this source code is governed by a BSD-style license
+// that can be found in the LICENSE file in the root of the source
+// tree.
+
+package gopacket_test
+
+import (
+	"testing"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+)
+
+// The PacketSource benchmarks decode DNS packets, for which decoding dominates
+// reading; compare them with -cpu to see how the workers scale.
+func benchmarkPacketSource(b *testing.B, workers int, flowAffinity bool) {
+	data := readPcapData(b, "pcap/test_dns.pcap")
+	source := gopacket.NewPacketSource(&memorySource{data: data, n: b.N}, layers.LayerTypeEthernet)
+	source.Workers = workers
+	source.FlowAffinity = flowAffinity
+	b.ReportAllocs()
+	b.ResetTimer()
+	for range source.Packets() {
+	}
+}
+
+func BenchmarkPacketSource(b *testing.B)              { benchmarkPacketSource(b, 0, false) }
+func BenchmarkPacketSourceWorkers2(b *testing.B)      { benchmarkPacketSource(b, 2, false) }
+func BenchmarkPacketSourceWorkers4(b *testing.B)      { benchmarkPacketSource(b, 4, false) }
+func BenchmarkPacketSourceWorkers8(b *testing.B)      { benchmarkPacketSource(b, 8, false) }
+func BenchmarkPacketSourceFlowAffinity4(b *testing.B) { benchmarkPacketSource(b, 4, true) }
+func BenchmarkPacketSourceFlowAffinity8(b *testing.B) { benchmarkPacketSource(b, 8, true) }
@@ -0,0 +1,117 @@
+// Copyright 2019 The GoPacket Authors. All rights reserved.
+//
+// Use of this source code is governed by a BSD-style license
+// that can be found in the LICENSE file in the root of the source
+// tree.
+
+package gopacket_test
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+	"github.com/google/gopacket/pcapgo"
+)
+
+// memorySource replays packets from memory n times, numbering them in their
+// timestamps.
+type memorySource struct {
+	data [][]byte
+	i, n int
+}
+
+func (s *memorySource) ReadPacketData() ([]byte, gopacket.CaptureInfo, error) {
+	if s.i == s.n {
+		return nil, gopacket.CaptureInfo{}, io.EOF
+	}
+	data := s.data[s.i%len(s.data)]
+	ci := gopacket.CaptureInfo{
+		Timestamp:     time.Unix(0, int64(s.i)),
+		CaptureLength: len(data),
+		Length:        len(data),
+	}
+	s.i++
+	return data, ci, nil
+}
+
+func readPcapData(t testing.TB, filenames ...string) [][]byte {
+	var packets [][]byte
+	for _, filename := range filenames {
+		f, err := os.Open(filename)
+		if err != nil {
+			t.Fatal(err)
+		}
+		r, err := pcapgo.NewReader(f)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for {
+			data, _, err := r.ReadPacketData()
+			if err == io.EOF {
+				break
+			} else if err != nil {
+				t.Fatal(err)
+			}
+			packets = append(packets, data)
+		}
+		f.Close()
+	}
+	return packets
+}
+
+func TestPacketSourceWorkers(t *testing.T) {
+	data := readPcapData(t, "pcap/test_dns.pcap", "pcap/test_ethernet.pcap")
+	const n = 5000
+	for _, workers := range []int{0, 1, 2, 3, 8} {
+		source := gopacket.NewPacketSource(&memorySource{data: data, n: n}, layers.LayerTypeEthernet)
+		source.Workers = workers
+		i := 0
+		for packet := range source.Packets() {
+			if got := packet.Metadata().Timestamp.UnixNano(); got != int64(i) {
+				t.Fatalf("workers %d: got packet %d, want %d", workers, got, i)
+			}
+			if packet.ApplicationLayer() == nil && packet.Layer(layers.LayerTypeTCP) == nil {
+				t.Fatalf("workers %d: packet %d not decoded", workers, i)
+			}
+			i++
+		}
+		if i != n {
+			t.Errorf("workers %d: got %d packets, want %d", workers, i, n)
+		}
+	}
+}
+
+func TestPacketSourceFlowAffinity(t *testing.T) {
+	data := readPcapData(t, "pcap/test_dns.pcap", "pcap/test_ethernet.pcap")
+	const n = 5000
+	source := gopacket.NewPacketSource(&memorySource{data: data, n: n}, layers.LayerTypeEthernet)
+	source.Workers = 4
+	source.FlowAffinity = true
+	last := map[string]int64{}
+	count := 0
+	for packet := range source.Packets() {
+		// Flows in both directions must stay in order relative to each other.
+		net, transport := packet.NetworkLayer().NetworkFlow(), packet.TransportLayer().TransportFlow()
+		if net.Src().LessThan(net.Dst()) {
+			net, transport = net.Reverse(), transport.Reverse()
+		}
+		key := fmt.Sprint(net, transport)
+		i := packet.Metadata().Timestamp.UnixNano()
+		if prev, ok := last[key]; ok && i <= prev {
+			t.Fatalf("flow %s: packet %d after %d", key, i, prev)
+		}
+		last[key] = i
+		if len(packet.Layers()) < 3 {
+			t.Fatalf("packet %d not decoded", i)
+		}
+		count++
+	}
+	if count != n {
+		t.Errorf("got %d packets, want %d", count, n)
+	}
+}
@@ -155,6 +155,7 @@ func (l *DecodingLayerParser) DecodeLayers(data []byte, decoded *[]LayerType) (e
 	}
 	typ := l.first
 	*decoded = (*decoded)[:0] // Truncated decoded layers.
+	var network NetworkLayer
 	for len(data) > 0 {
 		decoder, ok := l.decoders[typ]
 		if !ok {
@@ -166,6 +167,14 @@ func (l *DecodingLayerParser) DecodeLayers(data []byte, decoded *[]LayerType) (e
 			return err
 		}
 		*decoded = append(*decoded, typ)
+		if l.VerifyChecksums {
+			if cv, ok := decoder.(ChecksumVerifier); ok && !l.Truncated {
+				cv.VerifyChecksum(network)
+			}
+			if n, ok := decoder.(NetworkLayer); ok {
+				network = n
+			}
+		}
 		typ = decoder.NextLayerType()
 		data = decoder.LayerPayload()
 	}
@@ -204,4 +213,8 @@ type DecodingLayerParserOptions struct {
 	// sure that all expected layers have been parsed (by checking the decoded
 	// slice).
 	IgnoreUnsupported bool
+	// VerifyChecksums checks the checksum of every decoded layer that
+	// implements ChecksumVerifier, as DecodeOptions.VerifyChecksums does for
+	// packets.  Results are stored in the decoding layers themselves.
+	VerifyChecksums bool
 }
@@ -0,0 +1,209 @@
+// Copyright 2019 The GoPacket Authors. All rights reserved.
+//
+// Use of this source code is governed by a BSD-style license
+// that can be found in the LICENSE file in the root of the source
+// tree.
+
+package pcapfilter
+
+import (
+	"errors"
+	"sync"
+	"sync/atomic"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+	"golang.org/x/net/bpf"
+)
+
+// BPF is a filter program run by the pure-Go virtual machine in
+// golang.org/x/net/bpf.  It is the cgo-free counterpart of pcap.BPF, and is
+// safe for concurrent use.
+type BPF struct {
+	orig string
+	vm   *bpf.VM
+	// lengthVM, set for programs loading the packet length, runs them on
+	// the captured data prefixed with the length on the wire, which has to
+	// come from CaptureInfo.Length rather than the length of the data.
+	lengthVM *bpf.VM
+	bufs     sync.Pool
+}
+
+// lengthPrefix is the size of the wire length lengthVM's data starts with.
+const lengthPrefix = 4
+
+// NewBPF compiles expr with Compile into a new filter program.
+func NewBPF(linkType layers.LinkType, captureLength int, expr string) (*BPF, error) {
+	insns, err := CompileInstructions(linkType, captureLength, expr)
+	if err != nil {
+		return nil, err
+	}
+	b, err := newBPF(insns)
+	if err != nil {
+		return nil, err
+	}
+	b.orig = expr
+	return b, nil
+}
+
+// NewBPFInstructionFilter creates a filter running the given program, which
+// may come from Compile, pcap.CompileBPFFilter (after conversion) or be
+// hand-assembled.
+func NewBPFInstructionFilter(raw []bpf.RawInstruction) (*BPF, error) {
+	insns, ok := bpf.Disassemble(raw)
+	if !ok {
+		return nil, errors.New("BPF program contains instructions the Go VM does not support")
+	}
+	b, err := newBPF(insns)
+	if err != nil {
+		return nil, err
+	}
+	b.orig = "BPF Instruction Filter"
+	return b, nil
+}
+
+func newBPF(insns []bpf.Instruction) (*BPF, error) {
+	vm, err := bpf.NewVM(insns)
+	if err != nil {
+		return nil, err
+	}
+	b := &BPF{vm: vm}
+	if lengthInsns, ok := prefixLength(insns); ok {
+		if b.lengthVM, err = bpf.NewVM(lengthInsns); err != nil {
+			return nil, err
+		}
+	}
+	return b, nil
+}
+
+// prefixLength rewrites a program to run on data prefixed with its length,
+// shifting its packet loads past the prefix.  ok is false if the program
+// doesn't load the length.
+func prefixLength(insns []bpf.Instruction) (out []bpf.Instruction, ok bool) {
+	out = make([]bpf.Instruction, len(insns))
+	for i, insn := range insns {
+		switch insn := insn.(type) {
+		case bpf.LoadExtension:
+			if insn.Num == bpf.ExtLen {
+				out[i], ok = bpf.LoadAbsolute{Off: 0, Size: lengthPrefix}, true
+				continue
+			}
+		case bpf.LoadAbsolute:
+			insn.Off += lengthPrefix
+			out[i] = insn
+			continue
+		case bpf.LoadIndirect:
+			insn.Off += lengthPrefix
+			out[i] = insn
+			continue
+		case bpf.LoadMemShift:
+			insn.Off += lengthPrefix
+			out[i] = insn
+			continue
+		}
+		out[i] = insn
+	}
+	return out, ok
+}
+
+// String returns the original string this BPF filter was compiled from.
+func (b *BPF) String() string {
+	return b.orig
+}
+
+// Matches returns true if the given packet data matches this filter.  As
+// with libpcap, "len" refers to ci.Length, the length of the packet on the
+// wire, while loads beyond the captured data reject the packet.
+func (b *BPF) Matches(ci gopacket.CaptureInfo, data []byte) bool {
+	if b.lengthVM == nil || ci.Length == len(data) {
+		n, err := b.vm.Run(data)
+		return err == nil && n > 0
+	}
+	buf, _ := b.bufs.Get().(*[]byte)
+	if buf == nil {
+		buf = new([]byte)
+	}
+	length := uint32(ci.Length)
+	*buf = append(append((*buf)[:0], byte(length>>24), byte(length>>16), byte(length>>8), byte(length)), data...)
+	n, err := b.lengthVM.Run(*buf)
+	b.bufs.Put(buf)
+	return err == nil && n > 0
+}
+
+// FilterStats counts the packets a FilteredSource has read.
+type FilterStats struct {
+	Matched, Dropped uint64
+}
+
+// FilteredSource is a gopacket.PacketDataSource which only returns the
+// packets of another source that match a BPF filter, giving the semantics of
+// "tcpdump -r file expr" without cgo:
+//
+//	r, err := pcapgo.NewReader(f)
+//	...
+//	filter, err := pcapfilter.NewBPF(r.LinkType(), int(r.Snaplen()), "tcp port 80")
+//	...
+//	source := gopacket.NewPacketSource(pcapfilter.NewFilteredSource(r, filter), r.LinkType())
+//
+// If the underlying source implements gopacket.ZeroCopyPacketDataSource, so
+// does FilteredSource's ZeroCopyReadPacketData; otherwise it falls back to
+// ReadPacketData.
+type FilteredSource struct {
+	matched, dropped uint64 // accessed atomically, so first for alignment
+	src              gopacket.PacketDataSource
+	filter           *BPF
+}
+
+// NewFilteredSource returns a source reading from src which drops packets not
+// matching filter.
+func NewFilteredSource(src gopacket.PacketDataSource, filter *BPF) *FilteredSource {
+	return &FilteredSource{src: src, filter: filter}
+}
+
+// ReadPacketData returns the next matching packet, implementing
+// gopacket.PacketDataSource.
+func (s *FilteredSource) ReadPacketData() (data []byte, ci gopacket.CaptureInfo, err error) {
+	for {
+		if data, ci, err = s.src.ReadPacketData(); err != nil {
+			return
+		}
+		if s.match(ci, data) {
+			return
+		}
+	}
+}
+
+// ZeroCopyReadPacketData returns the next matching packet, implementing
+// gopacket.ZeroCopyPacketDataSource.
+func (s *FilteredSource) ZeroCopyReadPacketData() (data []byte, ci gopacket.CaptureInfo, err error) {
+	zc, ok := s.src.(gopacket.ZeroCopyPacketDataSource)
+	if !ok {
+		return s.ReadPacketData()
+	}
+	for {
+		if data, ci, err = zc.ZeroCopyReadPacketData(); err != nil {
+			return
+		}
+		if s.match(ci, data) {
+			return
+		}
+	}
+}
+
+func (s *FilteredSource) match(ci gopacket.CaptureInfo, data []byte) bool {
+	if s.filter.Matches(ci, data) {
+		atomic.AddUint64(&s.matched, 1)
+		return true
+	}
+	atomic.AddUint64(&s.dropped, 1)
+	return false
+}
+
+// Stats returns the number of packets matched and dropped so far.  It may be
+// called concurrently with reads.
+func (s *FilteredSource) Stats() FilterStats {
+	return FilterStats{
+		Matched: atomic.LoadUint64(&s.matched),
+		Dropped: atomic.LoadUint64(&s.dropped),
+	}
+}
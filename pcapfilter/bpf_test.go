@@ -0,0 +1,151 @@
+// Copyright 2019 The GoPacket Authors. All rights reserved.
+//
+// Use of this source code is governed by a BSD-style license
+// that can be found in the LICENSE file in the root of the source
+// tree.
+
+package pcapfilter
+
+import (
+	"io"
+	"net"
+	"os"
+	"testing"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+	"github.com/google/gopacket/pcapgo"
+)
+
+func openTestPcap(t *testing.T, filename string) (*pcapgo.Reader, func()) {
+	f, err := os.Open(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r, err := pcapgo.NewReader(f)
+	if err != nil {
+		f.Close()
+		t.Fatal(err)
+	}
+	return r, func() { f.Close() }
+}
+
+func TestFilteredSource(t *testing.T) {
+	for _, test := range []struct {
+		filename, expr   string
+		matched, dropped uint64
+		zeroCopy         bool
+	}{
+		{"../pcap/test_dns.pcap", "udp port 53", 10, 0, false},
+		{"../pcap/test_dns.pcap", "tcp", 0, 10, true},
+		{"../pcap/test_ethernet.pcap", "tcp[tcpflags] & tcp-syn != 0", 2, 8, false},
+		{"../pcap/test_ethernet.pcap", "greater 100", 2, 8, true},
+		{"../pcap/test_loopback.pcap", "ip6 and tcp port 8080", 24, 0, false},
+	} {
+		r, closer := openTestPcap(t, test.filename)
+		filter, err := NewBPF(r.LinkType(), int(r.Snaplen()), test.expr)
+		if err != nil {
+			t.Fatalf("%q: %v", test.expr, err)
+		}
+		source := NewFilteredSource(r, filter)
+		var n uint64
+		for {
+			var data []byte
+			var ci gopacket.CaptureInfo
+			if test.zeroCopy {
+				data, ci, err = source.ZeroCopyReadPacketData()
+			} else {
+				data, ci, err = source.ReadPacketData()
+			}
+			if err == io.EOF {
+				break
+			} else if err != nil {
+				t.Fatal(err)
+			}
+			if !filter.Matches(ci, data) {
+				t.Errorf("%q: returned non-matching packet", test.expr)
+			}
+			n++
+		}
+		closer()
+		want := FilterStats{Matched: test.matched, Dropped: test.dropped}
+		if got := source.Stats(); got != want || n != test.matched {
+			t.Errorf("%s %q: got %d packets, stats %+v, want %+v", test.filename, test.expr, n, got, want)
+		}
+	}
+}
+
+func TestBPFMatchesLength(t *testing.T) {
+	filter, err := NewBPF(layers.LinkTypeEthernet, 0, "greater 1000 and ip")
+	if err != nil {
+		t.Fatal(err)
+	}
+	data := make([]byte, 64)
+	data[12], data[13] = 0x08, 0x00
+	for _, test := range []struct {
+		length int
+		want   bool
+	}{
+		{64, false},
+		{1500, true},
+		{999, false},
+		{1500, true},
+		{1000, true},
+	} {
+		ci := gopacket.CaptureInfo{CaptureLength: len(data), Length: test.length}
+		if got := filter.Matches(ci, data); got != test.want {
+			t.Errorf("length %d: got %v, want %v", test.length, got, test.want)
+		}
+	}
+	// Loads beyond the captured data reject the packet.
+	if filter, err = NewBPF(layers.LinkTypeEthernet, 0, "ip[100] = 0"); err != nil {
+		t.Fatal(err)
+	}
+	if filter.Matches(gopacket.CaptureInfo{CaptureLength: len(data), Length: 1500}, data) {
+		t.Error("load beyond captured data matched")
+	}
+}
+
+func TestBPFMatchesLengthIndirect(t *testing.T) {
+	// The length of truncated packets is told apart from their data, which
+	// loads relative to the IP header still read.
+	filter, err := NewBPF(layers.LinkTypeEthernet, 0, "len >= 100 and tcp dst port 80 and tcp[13] & 2 != 0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ip := &layers.IPv4{Version: 4, IHL: 6, TTL: 64, Protocol: layers.IPProtocolTCP,
+		SrcIP: net.IP{10, 0, 0, 1}, DstIP: net.IP{10, 0, 0, 2}, Options: []layers.IPv4Option{{OptionType: 1}, {OptionType: 1}, {OptionType: 1}, {OptionType: 0}}}
+	tcp := &layers.TCP{SrcPort: 40000, DstPort: 80, SYN: true}
+	tcp.SetNetworkLayerForChecksum(ip)
+	data := serializeTestPacket(t, "syn", &layers.Ethernet{SrcMAC: make(net.HardwareAddr, 6), DstMAC: make(net.HardwareAddr, 6), EthernetType: layers.EthernetTypeIPv4}, ip, tcp,
+		gopacket.Payload(make([]byte, 200))).data[:80]
+	for length := 80; length < 300; length += 7 {
+		ci := gopacket.CaptureInfo{CaptureLength: len(data), Length: length}
+		if got, want := filter.Matches(ci, data), length >= 100; got != want {
+			t.Errorf("length %d: got %v, want %v", length, got, want)
+		}
+	}
+}
+
+func TestNewBPFInstructionFilter(t *testing.T) {
+	raw, err := Compile(layers.LinkTypeEthernet, 65535, "udp")
+	if err != nil {
+		t.Fatal(err)
+	}
+	filter, err := NewBPFInstructionFilter(raw)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if filter.String() != "BPF Instruction Filter" {
+		t.Errorf("unexpected String() %q", filter.String())
+	}
+	r, closer := openTestPcap(t, "../pcap/test_dns.pcap")
+	defer closer()
+	data, ci, err := r.ReadPacketData()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !filter.Matches(ci, data) {
+		t.Error("DNS packet did not match udp")
+	}
+}
@@ -0,0 +1,350 @@
+// Copyright 2019 The GoPacket Authors. All rights reserved.
+//
+// Use of this source code is governed by a BSD-style license
+// that can be found in the LICENSE file in the root of the source
+// tree.
+
+package pcapfilter
+
+import (
+	"github.com/google/gopacket/layers"
+	"golang.org/x/net/bpf"
+)
+
+// node is a boolean filter expression.  It is one of andNode, orNode,
+// notNode, constNode, *testNode or *chainNode.
+type node interface{}
+
+type andNode struct{ a, b node }
+type orNode struct{ a, b node }
+type notNode struct{ n node }
+type constNode bool
+
+// testNode is a single comparison: insns are run to load a value into the A
+// register, which is then compared with k (or with X, if useX is set).
+type testNode struct {
+	insns []bpf.Instruction
+	cond  bpf.JumpTest
+	k     uint32
+	useX  bool
+}
+
+// maxProtochainHeaders is how many headers a chainNode skips at most.
+// libpcap loops until it runs out of headers it knows, which needs a
+// backward jump; BPF only allows forward ones, so the loop is unrolled.
+const maxProtochainHeaders = 8
+
+// chainNode matches IPv4 or IPv6 packets reaching protocol proto after
+// skipping the extension headers in front of it: authentication headers,
+// and for IPv6 also hop-by-hop, routing, fragment and destination options
+// headers.  nl is the offset of the IP header.
+type chainNode struct {
+	ip6   bool
+	nl    uint32
+	proto uint32
+}
+
+// test returns a testNode comparing the value loaded by insns with k.
+func test(cond bpf.JumpTest, k uint32, insns ...bpf.Instruction) node {
+	return &testNode{insns: insns, cond: cond, k: k}
+}
+
+// and, or and not build boolean expressions, folding constants away.
+
+func and(a, b node) node {
+	if c, ok := a.(constNode); ok {
+		if c {
+			return b
+		}
+		return a
+	}
+	if c, ok := b.(constNode); ok {
+		if c {
+			return a
+		}
+		return b
+	}
+	return andNode{a, b}
+}
+
+func or(a, b node) node {
+	if c, ok := a.(constNode); ok {
+		if c {
+			return a
+		}
+		return b
+	}
+	if c, ok := b.(constNode); ok {
+		if c {
+			return b
+		}
+		return a
+	}
+	return orNode{a, b}
+}
+
+func not(n node) node {
+	switch v := n.(type) {
+	case constNode:
+		return !v
+	case notNode:
+		return v.n
+	}
+	return notNode{n}
+}
+
+// anyOf returns the disjunction of nodes, or false if there are none.
+func anyOf(nodes ...node) node {
+	var n node = constNode(false)
+	for _, m := range nodes {
+		n = or(n, m)
+	}
+	return n
+}
+
+// allOf returns the conjunction of nodes, or true if there are none.
+func allOf(nodes ...node) node {
+	var n node = constNode(true)
+	for _, m := range nodes {
+		n = and(n, m)
+	}
+	return n
+}
+
+type label int
+
+type itemKind int
+
+const (
+	itemInsn  itemKind = iota // a non-jump instruction
+	itemLabel                 // the definition of a label
+	itemJump                  // an unconditional jump
+	itemCJump                 // a conditional jump
+)
+
+// item is an entry in a program whose jump targets haven't been resolved yet.
+type item struct {
+	kind  itemKind
+	insn  bpf.Instruction
+	label label // the label defined, or the target of an unconditional jump
+	// Conditional jumps only.
+	cond bpf.JumpTest
+	k    uint32
+	useX bool
+	t, f label
+}
+
+// codegen turns a node into a BPF program.
+type codegen struct {
+	items  []item
+	labels int
+}
+
+func (g *codegen) newLabel() label {
+	g.labels++
+	return label(g.labels)
+}
+
+func (g *codegen) define(l label) {
+	g.items = append(g.items, item{kind: itemLabel, label: l})
+}
+
+func (g *codegen) jump(l label) {
+	g.items = append(g.items, item{kind: itemJump, label: l})
+}
+
+func (g *codegen) emit(n node, t, f label) {
+	switch v := n.(type) {
+	case constNode:
+		if v {
+			g.jump(t)
+		} else {
+			g.jump(f)
+		}
+	case *testNode:
+		for _, insn := range v.insns {
+			g.items = append(g.items, item{kind: itemInsn, insn: insn})
+		}
+		if t == f {
+			g.jump(t)
+			return
+		}
+		g.items = append(g.items, item{kind: itemCJump, cond: v.cond, k: v.k, useX: v.useX, t: t, f: f})
+	case andNode:
+		mid := g.newLabel()
+		g.emit(v.a, mid, f)
+		g.define(mid)
+		g.emit(v.b, t, f)
+	case orNode:
+		mid := g.newLabel()
+		g.emit(v.a, t, mid)
+		g.define(mid)
+		g.emit(v.b, t, f)
+	case notNode:
+		g.emit(v.n, f, t)
+	case *chainNode:
+		g.emitChain(v, t, f)
+	default:
+		panic("pcapfilter: unknown node type")
+	}
+}
+
+func (g *codegen) insns(insns ...bpf.Instruction) {
+	for _, insn := range insns {
+		g.items = append(g.items, item{kind: itemInsn, insn: insn})
+	}
+}
+
+// jumpIfEqual jumps to t if A equals k, and falls through otherwise.
+func (g *codegen) jumpIfEqual(k uint32, t label) {
+	f := g.newLabel()
+	g.items = append(g.items, item{kind: itemCJump, cond: bpf.JumpEqual, k: k, t: t, f: f})
+	g.define(f)
+}
+
+// emitChain walks the headers of an IP packet with the protocol of the next
+// header in A and its offset in X, using scratch memory slots 0 and 1.
+func (g *codegen) emitChain(v *chainNode, t, f label) {
+	if v.ip6 {
+		g.insns(bpf.LoadAbsolute{Off: v.nl + 6, Size: 1}, bpf.LoadConstant{Dst: bpf.RegX, Val: v.nl + 40})
+	} else {
+		g.insns(bpf.LoadMemShift{Off: v.nl}, bpf.TXA{}, bpf.ALUOpConstant{Op: bpf.ALUOpAdd, Val: v.nl}, bpf.TAX{},
+			bpf.LoadAbsolute{Off: v.nl + 9, Size: 1})
+	}
+	for i := 0; i < maxProtochainHeaders; i++ {
+		ext, ah, next := g.newLabel(), g.newLabel(), g.newLabel()
+		g.jumpIfEqual(v.proto, t)
+		g.jumpIfEqual(uint32(layers.IPProtocolAH), ah)
+		if v.ip6 {
+			for _, p := range []layers.IPProtocol{layers.IPProtocolIPv6HopByHop, layers.IPProtocolIPv6Routing,
+				layers.IPProtocolIPv6Fragment, layers.IPProtocolIPv6Destination} {
+				g.jumpIfEqual(uint32(p), ext)
+			}
+		}
+		g.jump(f)
+		if v.ip6 {
+			// The length of IPv6 extension headers is in 8 octet units, not
+			// counting the first 8 octets.
+			g.define(ext)
+			g.insns(bpf.LoadIndirect{Off: 1, Size: 1},
+				bpf.ALUOpConstant{Op: bpf.ALUOpAdd, Val: 1}, bpf.ALUOpConstant{Op: bpf.ALUOpShiftLeft, Val: 3})
+			g.jump(next)
+		}
+		// Authentication header lengths are in 4 octet units, less 2.
+		g.define(ah)
+		g.insns(bpf.LoadIndirect{Off: 1, Size: 1},
+			bpf.ALUOpConstant{Op: bpf.ALUOpAdd, Val: 2}, bpf.ALUOpConstant{Op: bpf.ALUOpShiftLeft, Val: 2})
+		// Move X past the header, whose first byte is the protocol of the
+		// header after it.
+		g.define(next)
+		g.insns(bpf.StoreScratch{Src: bpf.RegA, N: 0},
+			bpf.LoadIndirect{Off: 0, Size: 1}, bpf.StoreScratch{Src: bpf.RegA, N: 1},
+			bpf.LoadScratch{Dst: bpf.RegA, N: 0}, bpf.ALUOpX{Op: bpf.ALUOpAdd}, bpf.TAX{},
+			bpf.LoadScratch{Dst: bpf.RegA, N: 1})
+	}
+	g.items = append(g.items, item{kind: itemCJump, cond: bpf.JumpEqual, k: v.proto, t: t, f: f})
+}
+
+// removeNullJumps drops unconditional jumps to the instruction that follows
+// them anyway.
+func (g *codegen) removeNullJumps() {
+	out := g.items[:0]
+	for i, it := range g.items {
+		if it.kind == itemJump {
+			null := false
+			for _, next := range g.items[i+1:] {
+				if next.kind != itemLabel {
+					break
+				}
+				if next.label == it.label {
+					null = true
+					break
+				}
+			}
+			if null {
+				continue
+			}
+		}
+		out = append(out, it)
+	}
+	g.items = out
+}
+
+// positions returns the instruction index of every label.
+func (g *codegen) positions() map[label]int {
+	pos := make(map[label]int)
+	n := 0
+	for _, it := range g.items {
+		if it.kind == itemLabel {
+			pos[it.label] = n
+		} else {
+			n++
+		}
+	}
+	return pos
+}
+
+// addTrampolines makes every conditional jump fit in the 8 bits BPF allows
+// for it, by routing long jumps through an unconditional jump placed right
+// after the conditional one.  It returns false if nothing needed changing.
+func (g *codegen) addTrampolines() bool {
+	pos := g.positions()
+	n := 0
+	for i, it := range g.items {
+		if it.kind == itemLabel {
+			continue
+		}
+		if it.kind == itemCJump {
+			for _, target := range []*label{&g.items[i].t, &g.items[i].f} {
+				if pos[*target]-n-1 <= 255 {
+					continue
+				}
+				tramp := g.newLabel()
+				rest := append([]item{{kind: itemLabel, label: tramp}, {kind: itemJump, label: *target}}, g.items[i+1:]...)
+				*target = tramp
+				g.items = append(g.items[:i+1], rest...)
+				return true
+			}
+		}
+		n++
+	}
+	return false
+}
+
+// assemble generates a program for n, which accepts packets by returning
+// accept and rejects them by returning 0.
+func assemble(n node, accept uint32) ([]bpf.Instruction, error) {
+	g := &codegen{}
+	t, f := g.newLabel(), g.newLabel()
+	g.emit(n, t, f)
+	g.define(t)
+	g.items = append(g.items, item{kind: itemInsn, insn: bpf.RetConstant{Val: accept}})
+	g.define(f)
+	g.items = append(g.items, item{kind: itemInsn, insn: bpf.RetConstant{Val: 0}})
+	g.removeNullJumps()
+	for g.addTrampolines() {
+	}
+
+	pos := g.positions()
+	var prog []bpf.Instruction
+	for _, it := range g.items {
+		n := len(prog)
+		switch it.kind {
+		case itemInsn:
+			prog = append(prog, it.insn)
+		case itemJump:
+			prog = append(prog, bpf.Jump{Skip: uint32(pos[it.label] - n - 1)})
+		case itemCJump:
+			st, sf := uint8(pos[it.t]-n-1), uint8(pos[it.f]-n-1)
+			if it.useX {
+				prog = append(prog, bpf.JumpIfX{Cond: it.cond, SkipTrue: st, SkipFalse: sf})
+			} else {
+				prog = append(prog, bpf.JumpIf{Cond: it.cond, Val: it.k, SkipTrue: st, SkipFalse: sf})
+			}
+		}
+	}
+	if len(prog) > bpfMaxInstructions {
+		return nil, errTooLong
+	}
+	return prog, nil
+}
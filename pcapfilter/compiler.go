@@ -0,0 +1,785 @@
+// Copyright 2019 The GoPacket Authors. All rights reserved.
+//
+// Use of this source code is governed by a BSD-style license
+// that can be found in the LICENSE file in the root of the source
+// tree.
+
+package pcapfilter
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+
+	"github.com/google/gopacket/layers"
+	"golang.org/x/net/bpf"
+)
+
+const (
+	bpfMaxInstructions = 4096
+	bpfScratchSlots    = 16
+)
+
+var (
+	errTooLong    = errors.New("filter program too long")
+	errTooComplex = errors.New("arithmetic expression too complex")
+)
+
+// arith is an arithmetic expression.  It is one of constExpr, lenExpr,
+// loadExpr, binExpr or negExpr.
+type arith interface{}
+
+type constExpr uint32
+type lenExpr struct{}
+type negExpr struct{ a arith }
+
+type binExpr struct {
+	op   bpf.ALUOp
+	a, b arith
+}
+
+// loadExpr loads size bytes at offset base+idx, or at X+base+idx if msh is
+// set, in which case X is first loaded with the length of the IPv4 header at
+// offset nl.  It only applies to packets matching guard.
+type loadExpr struct {
+	guard node
+	msh   bool
+	nl    uint32
+	base  uint32
+	idx   arith
+	size  int
+}
+
+// binary returns a op b, folding constants.
+func binary(op bpf.ALUOp, a, b arith) (arith, error) {
+	kb, bConst := b.(constExpr)
+	if bConst && kb == 0 && (op == bpf.ALUOpDiv || op == bpf.ALUOpMod) {
+		return nil, errors.New("division by zero")
+	}
+	ka, aConst := a.(constExpr)
+	if !aConst || !bConst {
+		return binExpr{op, a, b}, nil
+	}
+	switch op {
+	case bpf.ALUOpAdd:
+		return ka + kb, nil
+	case bpf.ALUOpSub:
+		return ka - kb, nil
+	case bpf.ALUOpMul:
+		return ka * kb, nil
+	case bpf.ALUOpDiv:
+		return ka / kb, nil
+	case bpf.ALUOpMod:
+		return ka % kb, nil
+	case bpf.ALUOpOr:
+		return ka | kb, nil
+	case bpf.ALUOpAnd:
+		return ka & kb, nil
+	case bpf.ALUOpXor:
+		return ka ^ kb, nil
+	case bpf.ALUOpShiftLeft:
+		return ka << kb, nil
+	case bpf.ALUOpShiftRight:
+		return ka >> kb, nil
+	}
+	return binExpr{op, a, b}, nil
+}
+
+// compiler holds the link-layer state which primitives are compiled
+// against.  lt and nl move forward by 4 bytes for every "vlan" primitive, so
+// that primitives after it look inside the VLAN tag, as in libpcap.
+type compiler struct {
+	linkType layers.LinkType
+	lt       uint32 // offset of the EtherType, for Ethernet and Linux SLL
+	nl       uint32 // offset of the network layer header
+}
+
+func newCompiler(linkType layers.LinkType) (*compiler, error) {
+	c := &compiler{linkType: linkType}
+	switch linkType {
+	case layers.LinkTypeEthernet:
+		c.lt, c.nl = 12, 14
+	case layers.LinkTypeLinuxSLL:
+		c.lt, c.nl = 14, 16
+	case layers.LinkTypeNull, layers.LinkTypeLoop:
+		c.nl = 4
+	case layers.LinkTypeRaw, layers.LinkTypeIPv4, layers.LinkTypeIPv6:
+	default:
+		return nil, fmt.Errorf("unsupported link type %v", linkType)
+	}
+	return c, nil
+}
+
+// bsdAFs are the address family values BSD loopback headers use for IPv6 on
+// different systems.
+var bsdAFs = []uint32{10, 24, 28, 30}
+
+// etherType matches packets whose network layer protocol is et.
+func (c *compiler) etherType(et layers.EthernetType) node {
+	switch c.linkType {
+	case layers.LinkTypeEthernet, layers.LinkTypeLinuxSLL:
+		return test(bpf.JumpEqual, uint32(et), bpf.LoadAbsolute{Off: c.lt, Size: 2})
+	case layers.LinkTypeRaw:
+		version := func(v uint32) node {
+			return test(bpf.JumpEqual, v<<4, bpf.LoadAbsolute{Off: 0, Size: 1}, bpf.ALUOpConstant{Op: bpf.ALUOpAnd, Val: 0xf0})
+		}
+		switch et {
+		case layers.EthernetTypeIPv4:
+			return version(4)
+		case layers.EthernetTypeIPv6:
+			return version(6)
+		}
+	case layers.LinkTypeIPv4:
+		return constNode(et == layers.EthernetTypeIPv4)
+	case layers.LinkTypeIPv6:
+		return constNode(et == layers.EthernetTypeIPv6)
+	case layers.LinkTypeNull, layers.LinkTypeLoop:
+		// The address family is in host byte order for DLT_NULL and network
+		// byte order for DLT_LOOP, so accept either.
+		var afs []uint32
+		switch et {
+		case layers.EthernetTypeIPv4:
+			afs = []uint32{2}
+		case layers.EthernetTypeIPv6:
+			afs = bsdAFs
+		}
+		var nodes []node
+		for _, af := range afs {
+			nodes = append(nodes,
+				test(bpf.JumpEqual, af, bpf.LoadAbsolute{Off: 0, Size: 4}),
+				test(bpf.JumpEqual, af<<24, bpf.LoadAbsolute{Off: 0, Size: 4}))
+		}
+		return anyOf(nodes...)
+	}
+	return constNode(false)
+}
+
+func (c *compiler) loadByte(off uint32) bpf.Instruction {
+	return bpf.LoadAbsolute{Off: off, Size: 1}
+}
+
+// ipProto matches IPv4 packets carrying protocol proto.
+func (c *compiler) ipProto(proto layers.IPProtocol) node {
+	return and(c.etherType(layers.EthernetTypeIPv4), test(bpf.JumpEqual, uint32(proto), c.loadByte(c.nl+9)))
+}
+
+// ip6Proto matches IPv6 packets whose next header is proto, directly or
+// after a fragment header.
+func (c *compiler) ip6Proto(proto layers.IPProtocol) node {
+	direct := test(bpf.JumpEqual, uint32(proto), c.loadByte(c.nl+6))
+	frag := and(
+		test(bpf.JumpEqual, uint32(layers.IPProtocolIPv6Fragment), c.loadByte(c.nl+6)),
+		test(bpf.JumpEqual, uint32(proto), c.loadByte(c.nl+40)))
+	return and(c.etherType(layers.EthernetTypeIPv6), or(direct, frag))
+}
+
+// notFragment matches IPv4 packets which are unfragmented or the first
+// fragment, so that transport headers can be read.
+func (c *compiler) notFragment() node {
+	return test(bpf.JumpBitsNotSet, 0x1fff, bpf.LoadAbsolute{Off: c.nl + 6, Size: 2})
+}
+
+var transportProtos = map[string]layers.IPProtocol{
+	"tcp": layers.IPProtocolTCP, "udp": layers.IPProtocolUDP, "sctp": layers.IPProtocolSCTP,
+	"icmp": layers.IPProtocolICMPv4, "igmp": layers.IPProtocolIGMP, "icmp6": layers.IPProtocolICMPv6,
+}
+
+var etherTypeNames = map[string]layers.EthernetType{
+	"ip": layers.EthernetTypeIPv4, "ip6": layers.EthernetTypeIPv6,
+	"arp": layers.EthernetTypeARP, "rarp": 0x8035,
+	"atalk": 0x809b, "aarp": 0x80f3, "ipx": 0x8137,
+	"decnet": 0x6003, "lat": 0x6004, "sca": 0x6007, "moprc": 0x6002, "mopdl": 0x6001,
+}
+
+var ipProtoNames = map[string]layers.IPProtocol{
+	"icmp": layers.IPProtocolICMPv4, "igmp": layers.IPProtocolIGMP, "tcp": layers.IPProtocolTCP,
+	"udp": layers.IPProtocolUDP, "sctp": layers.IPProtocolSCTP, "icmp6": layers.IPProtocolICMPv6,
+	"gre": layers.IPProtocolGRE, "esp": layers.IPProtocolESP, "ah": layers.IPProtocolAH,
+	"ospf": 89, "pim": 103, "vrrp": 112,
+}
+
+// protoAbbrev compiles a protocol on its own, as in "tcp" or "ip6".
+func (c *compiler) protoAbbrev(proto string) (node, error) {
+	switch proto {
+	case "ip", "ip6", "arp", "rarp":
+		return c.etherType(etherTypeNames[proto]), nil
+	case "tcp", "udp", "sctp":
+		p := transportProtos[proto]
+		return or(c.ipProto(p), c.ip6Proto(p)), nil
+	case "icmp", "igmp":
+		return c.ipProto(transportProtos[proto]), nil
+	case "icmp6":
+		return c.ip6Proto(layers.IPProtocolICMPv6), nil
+	}
+	return nil, fmt.Errorf("%q is not a protocol", proto)
+}
+
+// protoPrimitive compiles "[ether|ip|ip6] proto id".
+func (c *compiler) protoPrimitive(qproto, id string) (node, error) {
+	n, isNum := parseNumber(id)
+	switch qproto {
+	case "ether", "link":
+		if !isNum {
+			et, ok := etherTypeNames[id]
+			if !ok {
+				return nil, fmt.Errorf("unknown ether proto %q", id)
+			}
+			n = uint32(et)
+		}
+		if n > 0xffff {
+			return nil, fmt.Errorf("ether proto %d out of range", n)
+		}
+		return c.etherType(layers.EthernetType(n)), nil
+	case "", "ip", "ip6":
+		p, err := lookupIPProto(id)
+		if err != nil {
+			return nil, err
+		}
+		switch qproto {
+		case "ip":
+			return c.ipProto(p), nil
+		case "ip6":
+			return c.ip6Proto(p), nil
+		}
+		return or(c.ipProto(p), c.ip6Proto(p)), nil
+	}
+	return nil, fmt.Errorf("proto not valid for %q", qproto)
+}
+
+// lookupIPProto returns the IP protocol for a number or name.
+func lookupIPProto(id string) (layers.IPProtocol, error) {
+	n, isNum := parseNumber(id)
+	if !isNum {
+		p, ok := ipProtoNames[id]
+		if !ok {
+			return 0, fmt.Errorf("unknown ip proto %q", id)
+		}
+		n = uint32(p)
+	}
+	if n > 0xff {
+		return 0, fmt.Errorf("ip proto %d out of range", n)
+	}
+	return layers.IPProtocol(n), nil
+}
+
+// protochain compiles "[ip|ip6] protochain id".
+func (c *compiler) protochain(qproto, id string) (node, error) {
+	p, err := lookupIPProto(id)
+	if err != nil {
+		return nil, err
+	}
+	v4 := and(c.etherType(layers.EthernetTypeIPv4), &chainNode{nl: c.nl, proto: uint32(p)})
+	v6 := and(c.etherType(layers.EthernetTypeIPv6), &chainNode{ip6: true, nl: c.nl, proto: uint32(p)})
+	switch qproto {
+	case "ip":
+		return v4, nil
+	case "ip6":
+		return v6, nil
+	case "":
+		return or(v4, v6), nil
+	}
+	return nil, fmt.Errorf("protochain not valid for %q", qproto)
+}
+
+// castPrimitive compiles "[ether|ip|ip6] broadcast|multicast".
+func (c *compiler) castPrimitive(proto, cast string) (node, error) {
+	switch proto {
+	case "", "ether", "link":
+		if c.linkType != layers.LinkTypeEthernet {
+			return nil, fmt.Errorf("%s not supported on link type %v", cast, c.linkType)
+		}
+		if cast == "broadcast" {
+			return and(
+				test(bpf.JumpEqual, 0xffffffff, bpf.LoadAbsolute{Off: 0, Size: 4}),
+				test(bpf.JumpEqual, 0xffff, bpf.LoadAbsolute{Off: 4, Size: 2})), nil
+		}
+		return test(bpf.JumpBitsSet, 1, c.loadByte(0)), nil
+	case "ip":
+		if cast == "broadcast" {
+			return nil, errors.New("ip broadcast requires a netmask, which is not supported")
+		}
+		return and(c.etherType(layers.EthernetTypeIPv4), test(bpf.JumpGreaterOrEqual, 224, c.loadByte(c.nl+16))), nil
+	case "ip6":
+		if cast == "multicast" {
+			return and(c.etherType(layers.EthernetTypeIPv6), test(bpf.JumpEqual, 0xff, c.loadByte(c.nl+24))), nil
+		}
+	}
+	return nil, fmt.Errorf("%s not valid for %q", cast, proto)
+}
+
+// vlan compiles "vlan [id]", then moves the link offsets past the tag.
+func (c *compiler) vlan(id int) (node, error) {
+	if c.linkType != layers.LinkTypeEthernet {
+		return nil, fmt.Errorf("vlan not supported on link type %v", c.linkType)
+	}
+	var n node = anyOf(
+		test(bpf.JumpEqual, uint32(layers.EthernetTypeDot1Q), bpf.LoadAbsolute{Off: c.lt, Size: 2}),
+		test(bpf.JumpEqual, uint32(layers.EthernetTypeQinQ), bpf.LoadAbsolute{Off: c.lt, Size: 2}),
+		test(bpf.JumpEqual, 0x9100, bpf.LoadAbsolute{Off: c.lt, Size: 2}))
+	if id >= 0 {
+		n = and(n, test(bpf.JumpEqual, uint32(id),
+			bpf.LoadAbsolute{Off: c.lt + 2, Size: 2}, bpf.ALUOpConstant{Op: bpf.ALUOpAnd, Val: 0xfff}))
+	}
+	c.lt += 4
+	c.nl += 4
+	return n, nil
+}
+
+// direction combines tests of the source and destination fields according
+// to dir.
+func direction(dir string, src, dst node) node {
+	switch dir {
+	case "src":
+		return src
+	case "dst":
+		return dst
+	case "src and dst":
+		return and(src, dst)
+	}
+	return or(src, dst)
+}
+
+// primitive compiles a primitive with an identifier, as in "src net
+// 10.0.0.0/8" or "tcp port http".
+func (c *compiler) primitive(q qualifiers, id string) (node, error) {
+	switch q.typ {
+	case "", "host":
+		return c.host(q, id)
+	case "net":
+		return c.net(q, id)
+	case "port", "portrange":
+		return c.port(q, id)
+	}
+	return nil, fmt.Errorf("unexpected %q", q.typ)
+}
+
+func (c *compiler) host(q qualifiers, id string) (node, error) {
+	if mac, err := net.ParseMAC(id); err == nil && len(mac) == 6 && !strings.Contains(id, "::") {
+		if q.proto != "" && q.proto != "ether" && q.proto != "link" {
+			return nil, fmt.Errorf("ethernet address %q used with %q", id, q.proto)
+		}
+		return c.etherHost(q.dir, mac)
+	}
+	if q.proto == "ether" || q.proto == "link" {
+		return nil, fmt.Errorf("%q is not an ethernet address", id)
+	}
+	if n, ok := parseNumber(id); ok {
+		return c.ipNet(q, n, 0xffffffff)
+	}
+	ip := net.ParseIP(id)
+	if ip == nil {
+		return nil, fmt.Errorf("%q is not an address; host names are not resolved", id)
+	}
+	if ip4 := ip.To4(); ip4 != nil && !strings.Contains(id, ":") {
+		return c.ipNet(q, be32(ip4), 0xffffffff)
+	}
+	return c.ip6Net(q, ip.To16(), net.CIDRMask(128, 128))
+}
+
+func (c *compiler) net(q qualifiers, id string) (node, error) {
+	if parts := strings.SplitN(id, " mask ", 2); len(parts) == 2 {
+		addr, _, ok := parseIPv4Prefix(parts[0])
+		mask := net.ParseIP(parts[1]).To4()
+		if !ok || mask == nil {
+			return nil, fmt.Errorf("invalid network %q", id)
+		}
+		return c.ipNet(q, addr, be32(mask))
+	}
+	if strings.Contains(id, ":") {
+		if !strings.Contains(id, "/") {
+			id += "/128"
+		}
+		ip, ipnet, err := net.ParseCIDR(id)
+		if err != nil {
+			return nil, fmt.Errorf("invalid network %q", id)
+		}
+		if !ip.Equal(ipnet.IP) {
+			return nil, fmt.Errorf("non-network bits set in %q", id)
+		}
+		return c.ip6Net(q, ipnet.IP.To16(), ipnet.Mask)
+	}
+	addrPart, bits := id, -1
+	if i := strings.IndexByte(id, '/'); i >= 0 {
+		n, ok := parseNumber(id[i+1:])
+		if !ok || n > 32 {
+			return nil, fmt.Errorf("invalid network %q", id)
+		}
+		addrPart, bits = id[:i], int(n)
+	}
+	addr, mask, ok := parseIPv4Prefix(addrPart)
+	if !ok {
+		return nil, fmt.Errorf("invalid network %q", id)
+	}
+	if bits >= 0 {
+		mask = be32(net.CIDRMask(bits, 32))
+	}
+	if addr&^mask != 0 {
+		return nil, fmt.Errorf("non-network bits set in %q", id)
+	}
+	return c.ipNet(q, addr, mask)
+}
+
+// parseIPv4Prefix parses a possibly abbreviated IPv4 network like "10.1",
+// returning the address and the mask covering the octets given.
+func parseIPv4Prefix(s string) (addr, mask uint32, ok bool) {
+	parts := strings.Split(s, ".")
+	if len(parts) > 4 {
+		return 0, 0, false
+	}
+	for _, part := range parts {
+		n, ok := parseNumber(part)
+		if !ok || n > 0xff {
+			return 0, 0, false
+		}
+		addr = addr<<8 | n
+		mask = mask<<8 | 0xff
+	}
+	shift := uint(8 * (4 - len(parts)))
+	if len(parts) == 4 {
+		return addr, mask, true
+	}
+	// Abbreviated networks are left-aligned: "10.1" is 10.1.0.0/16.
+	return addr << shift, mask << shift, true
+}
+
+func be32(b []byte) uint32 {
+	return uint32(b[0])<<24 | uint32(b[1])<<16 | uint32(b[2])<<8 | uint32(b[3])
+}
+
+// maskedTest compares the masked 32-bit word at off with addr.
+func maskedTest(off, addr, mask uint32) node {
+	if mask == 0 {
+		return constNode(true)
+	}
+	insns := []bpf.Instruction{bpf.LoadAbsolute{Off: off, Size: 4}}
+	if mask != 0xffffffff {
+		insns = append(insns, bpf.ALUOpConstant{Op: bpf.ALUOpAnd, Val: mask})
+	}
+	return test(bpf.JumpEqual, addr, insns...)
+}
+
+// ipNet matches IPv4 (and, unless qualified, ARP and RARP) packets with an
+// address in addr/mask.
+func (c *compiler) ipNet(q qualifiers, addr, mask uint32) (node, error) {
+	ip := and(c.etherType(layers.EthernetTypeIPv4),
+		direction(q.dir, maskedTest(c.nl+12, addr, mask), maskedTest(c.nl+16, addr, mask)))
+	arp := func(et layers.EthernetType) node {
+		return and(c.etherType(et),
+			direction(q.dir, maskedTest(c.nl+14, addr, mask), maskedTest(c.nl+24, addr, mask)))
+	}
+	switch q.proto {
+	case "":
+		return anyOf(ip, arp(layers.EthernetTypeARP), arp(etherTypeNames["rarp"])), nil
+	case "ip":
+		return ip, nil
+	case "arp", "rarp":
+		return arp(etherTypeNames[q.proto]), nil
+	case "tcp", "udp", "sctp", "icmp", "igmp":
+		return and(ip, c.ipProto(transportProtos[q.proto])), nil
+	}
+	return nil, fmt.Errorf("IPv4 address used with %q", q.proto)
+}
+
+// ip6Net matches IPv6 packets with an address in addr/mask.
+func (c *compiler) ip6Net(q qualifiers, addr net.IP, mask net.IPMask) (node, error) {
+	match := func(off uint32) node {
+		var nodes []node
+		for i := uint32(0); i < 4; i++ {
+			nodes = append(nodes, maskedTest(off+4*i, be32(addr[4*i:]), be32(mask[4*i:])))
+		}
+		return allOf(nodes...)
+	}
+	ip6 := and(c.etherType(layers.EthernetTypeIPv6), direction(q.dir, match(c.nl+8), match(c.nl+24)))
+	switch q.proto {
+	case "", "ip6":
+		return ip6, nil
+	case "tcp", "udp", "sctp", "icmp6":
+		return and(ip6, c.ip6Proto(transportProtos[q.proto])), nil
+	}
+	return nil, fmt.Errorf("IPv6 address used with %q", q.proto)
+}
+
+// etherHost matches Ethernet frames to or from mac.
+func (c *compiler) etherHost(dir string, mac net.HardwareAddr) (node, error) {
+	if c.linkType != layers.LinkTypeEthernet {
+		return nil, fmt.Errorf("ethernet addresses not supported on link type %v", c.linkType)
+	}
+	match := func(off uint32) node {
+		return and(
+			test(bpf.JumpEqual, be32(mac), bpf.LoadAbsolute{Off: off, Size: 4}),
+			test(bpf.JumpEqual, uint32(mac[4])<<8|uint32(mac[5]), bpf.LoadAbsolute{Off: off + 4, Size: 2}))
+	}
+	return direction(dir, match(6), match(0)), nil
+}
+
+var portNames = make(map[string]map[string]uint32)
+
+func init() {
+	add := func(proto, name string, port uint32) {
+		if portNames[proto] == nil {
+			portNames[proto] = make(map[string]uint32)
+		}
+		// Several ports may share a name; use the lowest.
+		if p, ok := portNames[proto][name]; !ok || port < p {
+			portNames[proto][name] = port
+		}
+	}
+	for port, name := range layers.TCPPortNames {
+		add("tcp", name, uint32(port))
+	}
+	for port, name := range layers.UDPPortNames {
+		add("udp", name, uint32(port))
+	}
+	for port, name := range layers.SCTPPortNames {
+		add("sctp", name, uint32(port))
+	}
+}
+
+// lookupPort returns the port for a number or service name.
+func lookupPort(proto, s string) (uint32, error) {
+	if n, ok := parseNumber(s); ok {
+		if n > 0xffff {
+			return 0, fmt.Errorf("port %d out of range", n)
+		}
+		return n, nil
+	}
+	protos := []string{proto}
+	if proto == "" || proto == "ip" || proto == "ip6" {
+		protos = []string{"tcp", "udp", "sctp"}
+	}
+	for _, p := range protos {
+		if n, ok := portNames[p][s]; ok {
+			return n, nil
+		}
+	}
+	return 0, fmt.Errorf("unknown port %q", s)
+}
+
+// port compiles port and portrange primitives.
+func (c *compiler) port(q qualifiers, id string) (node, error) {
+	var protos []layers.IPProtocol
+	v4, v6 := true, true
+	switch q.proto {
+	case "", "ip", "ip6":
+		protos = []layers.IPProtocol{layers.IPProtocolTCP, layers.IPProtocolUDP, layers.IPProtocolSCTP}
+		v4, v6 = q.proto != "ip6", q.proto != "ip"
+	case "tcp", "udp", "sctp":
+		protos = []layers.IPProtocol{transportProtos[q.proto]}
+	default:
+		return nil, fmt.Errorf("port not valid for %q", q.proto)
+	}
+	var lo, hi uint32
+	var err error
+	if q.typ == "port" || q.typ == "" {
+		if lo, err = lookupPort(q.proto, id); err != nil {
+			return nil, err
+		}
+		hi = lo
+	} else {
+		i := strings.IndexByte(id, '-')
+		if i < 0 {
+			return nil, fmt.Errorf("invalid port range %q", id)
+		}
+		if lo, err = lookupPort(q.proto, id[:i]); err != nil {
+			return nil, err
+		}
+		if hi, err = lookupPort(q.proto, id[i+1:]); err != nil {
+			return nil, err
+		}
+		if lo > hi {
+			lo, hi = hi, lo
+		}
+	}
+	inRange := func(insns ...bpf.Instruction) node {
+		if lo == hi {
+			return test(bpf.JumpEqual, lo, insns...)
+		}
+		return and(test(bpf.JumpGreaterOrEqual, lo, insns...), test(bpf.JumpLessOrEqual, hi, insns...))
+	}
+
+	var n node = constNode(false)
+	if v4 {
+		var ps []node
+		for _, p := range protos {
+			ps = append(ps, test(bpf.JumpEqual, uint32(p), c.loadByte(c.nl+9)))
+		}
+		srcPort := inRange(bpf.LoadMemShift{Off: c.nl}, bpf.LoadIndirect{Off: c.nl, Size: 2})
+		dstPort := inRange(bpf.LoadMemShift{Off: c.nl}, bpf.LoadIndirect{Off: c.nl + 2, Size: 2})
+		n = or(n, allOf(c.etherType(layers.EthernetTypeIPv4), anyOf(ps...), c.notFragment(),
+			direction(q.dir, srcPort, dstPort)))
+	}
+	if v6 {
+		var ps []node
+		for _, p := range protos {
+			ps = append(ps, test(bpf.JumpEqual, uint32(p), c.loadByte(c.nl+6)))
+		}
+		srcPort := inRange(bpf.LoadAbsolute{Off: c.nl + 40, Size: 2})
+		dstPort := inRange(bpf.LoadAbsolute{Off: c.nl + 42, Size: 2})
+		n = or(n, allOf(c.etherType(layers.EthernetTypeIPv6), anyOf(ps...),
+			direction(q.dir, srcPort, dstPort)))
+	}
+	return n, nil
+}
+
+// load builds "proto[idx:size]".
+func (c *compiler) load(proto string, idx arith, size int) (arith, error) {
+	l := loadExpr{idx: idx, size: size, nl: c.nl, guard: constNode(true)}
+	switch proto {
+	case "ether", "link":
+		// Offsets are from the start of the link-layer header.
+	case "ip", "ip6", "arp", "rarp":
+		l.guard = c.etherType(etherTypeNames[proto])
+		l.base = c.nl
+	case "tcp", "udp", "sctp", "icmp", "igmp":
+		l.guard = allOf(c.etherType(layers.EthernetTypeIPv4),
+			test(bpf.JumpEqual, uint32(transportProtos[proto]), c.loadByte(c.nl+9)), c.notFragment())
+		l.msh = true
+		l.base = c.nl
+	case "icmp6":
+		l.guard = and(c.etherType(layers.EthernetTypeIPv6),
+			test(bpf.JumpEqual, uint32(layers.IPProtocolICMPv6), c.loadByte(c.nl+6)))
+		l.base = c.nl + 40
+	default:
+		return nil, fmt.Errorf("%q not valid in an expression", proto)
+	}
+	return l, nil
+}
+
+// guards returns the conditions under which the loads in e are valid.
+func guards(e arith, into []node) []node {
+	switch v := e.(type) {
+	case loadExpr:
+		return guards(v.idx, append(into, v.guard))
+	case binExpr:
+		return guards(v.b, guards(v.a, into))
+	case negExpr:
+		return guards(v.a, into)
+	}
+	return into
+}
+
+// code generates instructions leaving the value of e in A, using scratch
+// memory from slot depth upwards.
+func code(e arith, depth int) ([]bpf.Instruction, error) {
+	if depth >= bpfScratchSlots {
+		return nil, errTooComplex
+	}
+	switch v := e.(type) {
+	case constExpr:
+		return []bpf.Instruction{bpf.LoadConstant{Dst: bpf.RegA, Val: uint32(v)}}, nil
+	case lenExpr:
+		return []bpf.Instruction{bpf.LoadExtension{Num: bpf.ExtLen}}, nil
+	case negExpr:
+		insns, err := code(v.a, depth)
+		if err != nil {
+			return nil, err
+		}
+		return append(insns, bpf.TAX{}, bpf.LoadConstant{Dst: bpf.RegA, Val: 0}, bpf.ALUOpX{Op: bpf.ALUOpSub}), nil
+	case binExpr:
+		if k, ok := v.b.(constExpr); ok {
+			insns, err := code(v.a, depth)
+			if err != nil {
+				return nil, err
+			}
+			return append(insns, bpf.ALUOpConstant{Op: v.op, Val: uint32(k)}), nil
+		}
+		insns, err := code(v.b, depth)
+		if err != nil {
+			return nil, err
+		}
+		insns = append(insns, bpf.StoreScratch{Src: bpf.RegA, N: depth})
+		a, err := code(v.a, depth+1)
+		if err != nil {
+			return nil, err
+		}
+		insns = append(insns, a...)
+		return append(insns, bpf.LoadScratch{Dst: bpf.RegX, N: depth}, bpf.ALUOpX{Op: v.op}), nil
+	case loadExpr:
+		if k, ok := v.idx.(constExpr); ok {
+			if v.msh {
+				return []bpf.Instruction{bpf.LoadMemShift{Off: v.nl}, bpf.LoadIndirect{Off: v.base + uint32(k), Size: v.size}}, nil
+			}
+			return []bpf.Instruction{bpf.LoadAbsolute{Off: v.base + uint32(k), Size: v.size}}, nil
+		}
+		insns, err := code(v.idx, depth)
+		if err != nil {
+			return nil, err
+		}
+		if v.msh {
+			insns = append(insns,
+				bpf.StoreScratch{Src: bpf.RegA, N: depth},
+				bpf.LoadMemShift{Off: v.nl},
+				bpf.LoadScratch{Dst: bpf.RegA, N: depth},
+				bpf.ALUOpX{Op: bpf.ALUOpAdd})
+		}
+		return append(insns, bpf.TAX{}, bpf.LoadIndirect{Off: v.base, Size: v.size}), nil
+	}
+	panic("pcapfilter: unknown expression type")
+}
+
+// swapped gives the test equivalent to cond with its operands swapped.
+var swapped = map[bpf.JumpTest]bpf.JumpTest{
+	bpf.JumpEqual: bpf.JumpEqual, bpf.JumpNotEqual: bpf.JumpNotEqual,
+	bpf.JumpGreaterThan: bpf.JumpLessThan, bpf.JumpLessThan: bpf.JumpGreaterThan,
+	bpf.JumpGreaterOrEqual: bpf.JumpLessOrEqual, bpf.JumpLessOrEqual: bpf.JumpGreaterOrEqual,
+}
+
+// relation compiles "a cond b".  As in libpcap, it is false for packets the
+// loads in a and b don't apply to.
+func (c *compiler) relation(cond bpf.JumpTest, a, b arith) (node, error) {
+	ka, aConst := a.(constExpr)
+	kb, bConst := b.(constExpr)
+	if aConst && bConst {
+		switch cond {
+		case bpf.JumpEqual:
+			return constNode(ka == kb), nil
+		case bpf.JumpNotEqual:
+			return constNode(ka != kb), nil
+		case bpf.JumpGreaterThan:
+			return constNode(ka > kb), nil
+		case bpf.JumpLessThan:
+			return constNode(ka < kb), nil
+		case bpf.JumpGreaterOrEqual:
+			return constNode(ka >= kb), nil
+		case bpf.JumpLessOrEqual:
+			return constNode(ka <= kb), nil
+		}
+	}
+	if aConst {
+		a, b, kb, bConst, cond = b, a, ka, true, swapped[cond]
+	}
+	g := allOf(guards(b, guards(a, nil))...)
+	if bConst && kb == 0 && (cond == bpf.JumpEqual || cond == bpf.JumpNotEqual) {
+		// Test bits directly for "a & k != 0", as libpcap does.
+		if e, ok := a.(binExpr); ok && e.op == bpf.ALUOpAnd {
+			if k, ok := e.b.(constExpr); ok {
+				insns, err := code(e.a, 0)
+				if err != nil {
+					return nil, err
+				}
+				cond = map[bpf.JumpTest]bpf.JumpTest{bpf.JumpEqual: bpf.JumpBitsNotSet, bpf.JumpNotEqual: bpf.JumpBitsSet}[cond]
+				return and(g, &testNode{insns: insns, cond: cond, k: uint32(k)}), nil
+			}
+		}
+	}
+	if bConst {
+		insns, err := code(a, 0)
+		if err != nil {
+			return nil, err
+		}
+		return and(g, &testNode{insns: insns, cond: cond, k: uint32(kb)}), nil
+	}
+	insns, err := code(b, 0)
+	if err != nil {
+		return nil, err
+	}
+	insns = append(insns, bpf.StoreScratch{Src: bpf.RegA, N: 0})
+	ainsns, err := code(a, 1)
+	if err != nil {
+		return nil, err
+	}
+	insns = append(insns, ainsns...)
+	insns = append(insns, bpf.LoadScratch{Dst: bpf.RegX, N: 0})
+	return and(g, &testNode{insns: insns, cond: cond, useX: true}), nil
+}
@@ -0,0 +1,127 @@
+// Copyright 2019 The GoPacket Authors. All rights reserved.
+//
+// Use of this source code is governed by a BSD-style license
+// that can be found in the LICENSE file in the root of the source
+// tree.
+
+package pcapfilter
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+type tokenKind int
+
+const (
+	tokEOF tokenKind = iota
+	// tokWord is an identifier, keyword, number or address.  libpcap treats
+	// all of these as one lexical class too, which is why "len-1" is a single
+	// word.
+	tokWord
+	tokOp
+)
+
+type token struct {
+	kind tokenKind
+	text string
+	pos  int
+}
+
+func (t token) String() string {
+	if t.kind == tokEOF {
+		return "end of expression"
+	}
+	return strconv.Quote(t.text)
+}
+
+// isWordByte returns true for bytes which may appear in a word.  ':' is only
+// part of a word (MAC or IPv6 address) outside of brackets, where it
+// separates offset and size instead.
+func isWordByte(c byte, inBrackets bool) bool {
+	switch {
+	case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c >= '0' && c <= '9':
+		return true
+	case c == '-' || c == '.' || c == '_':
+		return true
+	case c == ':':
+		return !inBrackets
+	}
+	return false
+}
+
+// startsWord returns true if s begins with a word: words start with a letter,
+// digit or underscore, except for IPv6 addresses like "::1".
+func startsWord(s string, inBrackets bool) bool {
+	switch c := s[0]; c {
+	case '-', '.':
+		return false
+	case ':':
+		return !inBrackets && strings.HasPrefix(s, "::")
+	default:
+		return isWordByte(c, inBrackets)
+	}
+}
+
+var twoCharOps = []string{"&&", "||", "==", "!=", ">=", "<=", "<<", ">>"}
+
+// lex splits a filter expression into tokens.
+func lex(expr string) ([]token, error) {
+	var toks []token
+	brackets := 0
+	for i := 0; i < len(expr); {
+		c := expr[i]
+		switch {
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r':
+			i++
+			continue
+		case c == '\\':
+			// Escaped keywords, as in "ip proto \tcp", are plain words.
+			i++
+			continue
+		case startsWord(expr[i:], brackets > 0):
+			start := i
+			for i < len(expr) && isWordByte(expr[i], brackets > 0) {
+				i++
+			}
+			toks = append(toks, token{tokWord, expr[start:i], start})
+			continue
+		}
+		op := ""
+		for _, o := range twoCharOps {
+			if strings.HasPrefix(expr[i:], o) {
+				op = o
+				break
+			}
+		}
+		if op == "" {
+			if !strings.ContainsRune("()[]:!<>=+-*/%&|^", rune(c)) {
+				return nil, fmt.Errorf("unexpected character %q at offset %d", c, i)
+			}
+			op = expr[i : i+1]
+		}
+		switch op {
+		case "[":
+			brackets++
+		case "]":
+			brackets--
+		}
+		toks = append(toks, token{tokOp, op, i})
+		i += len(op)
+	}
+	return append(toks, token{tokEOF, "", len(expr)}), nil
+}
+
+// parseNumber parses a libpcap number: decimal, hex with a 0x prefix, or
+// octal with a leading 0.
+func parseNumber(s string) (uint32, bool) {
+	if s == "" || s[0] < '0' || s[0] > '9' {
+		return 0, false
+	}
+	n, err := strconv.ParseUint(s, 0, 32)
+	if err != nil {
+		return 0, false
+	}
+	return uint32(n), true
+}
@@ -0,0 +1,461 @@
+// Copyright 2019 The GoPacket Authors. All rights reserved.
+//
+// Use of this source code is governed by a BSD-style license
+// that can be found in the LICENSE file in the root of the source
+// tree.
+
+package pcapfilter
+
+import (
+	"fmt"
+
+	"golang.org/x/net/bpf"
+)
+
+// qualifiers are the proto, dir and type qualifiers of a primitive, as in
+// "tcp src port".  Empty strings mean no qualifier was given.
+type qualifiers struct {
+	proto, dir, typ string
+}
+
+var protoQualifiers = map[string]bool{
+	"ether": true, "link": true, "ip": true, "ip6": true, "arp": true, "rarp": true,
+	"tcp": true, "udp": true, "sctp": true, "icmp": true, "icmp6": true, "igmp": true,
+}
+
+var typeQualifiers = map[string]bool{
+	"host": true, "net": true, "port": true, "portrange": true, "proto": true, "protochain": true,
+	"gateway": true,
+}
+
+// keywords can't be used as identifiers.
+var keywords = map[string]bool{
+	"and": true, "or": true, "not": true, "src": true, "dst": true, "mask": true,
+	"less": true, "greater": true, "len": true, "vlan": true, "broadcast": true, "multicast": true,
+}
+
+func isKeyword(s string) bool {
+	return keywords[s] || protoQualifiers[s] || typeQualifiers[s]
+}
+
+// namedConstants are the constants libpcap predefines for use in relations,
+// such as "tcp[tcpflags] & tcp-syn != 0".
+var namedConstants = map[string]uint32{
+	"icmptype": 0, "icmpcode": 1,
+	"icmp-echoreply": 0, "icmp-unreach": 3, "icmp-sourcequench": 4, "icmp-redirect": 5,
+	"icmp-echo": 8, "icmp-routeradvert": 9, "icmp-routersolicit": 10, "icmp-timxceed": 11,
+	"icmp-paramprob": 12, "icmp-tstamp": 13, "icmp-tstampreply": 14, "icmp-ireq": 15,
+	"icmp-ireqreply": 16, "icmp-maskreq": 17, "icmp-maskreply": 18,
+	"icmp6type": 0, "icmp6code": 1,
+	"icmp6-echo": 128, "icmp6-echoreply": 129, "icmp6-multicastlistenerquery": 130,
+	"icmp6-multicastlistenerreportv1": 131, "icmp6-multicastlistenerdone": 132,
+	"icmp6-routersolicit": 133, "icmp6-routeradvert": 134, "icmp6-neighborsolicit": 135,
+	"icmp6-neighboradvert": 136, "icmp6-redirect": 137,
+	"tcpflags": 13, "tcp-fin": 0x01, "tcp-syn": 0x02, "tcp-rst": 0x04, "tcp-push": 0x08,
+	"tcp-ack": 0x10, "tcp-urg": 0x20, "tcp-ece": 0x40, "tcp-cwr": 0x80,
+}
+
+// parser is a recursive descent parser for filter expressions, which builds
+// nodes through its compiler as it goes.
+type parser struct {
+	c       *compiler
+	toks    []token
+	pos     int
+	last    qualifiers // qualifiers of the last primitive, for "host a or b"
+	hasLast bool
+}
+
+func (p *parser) peek() token { return p.toks[p.pos] }
+
+func (p *parser) peekAt(n int) token {
+	if p.pos+n >= len(p.toks) {
+		return p.toks[len(p.toks)-1]
+	}
+	return p.toks[p.pos+n]
+}
+
+func (p *parser) next() token {
+	t := p.toks[p.pos]
+	if t.kind != tokEOF {
+		p.pos++
+	}
+	return t
+}
+
+func (p *parser) isWord(s string) bool {
+	t := p.peek()
+	return t.kind == tokWord && t.text == s
+}
+
+func (p *parser) isOp(s string) bool {
+	t := p.peek()
+	return t.kind == tokOp && t.text == s
+}
+
+// isID returns true if the next token is an identifier: an address, number
+// or name which isn't a keyword.
+func (p *parser) isID() bool {
+	t := p.peek()
+	return t.kind == tokWord && !isKeyword(t.text)
+}
+
+func (p *parser) expectOp(s string) error {
+	if !p.isOp(s) {
+		return p.errorf("expected %q", s)
+	}
+	p.next()
+	return nil
+}
+
+func (p *parser) errorf(format string, args ...interface{}) error {
+	t := p.peek()
+	return fmt.Errorf("%s near %v at offset %d", fmt.Sprintf(format, args...), t, t.pos)
+}
+
+// parserState is what parseUnary needs to restore when it backtracks.
+type parserState struct {
+	pos     int
+	last    qualifiers
+	hasLast bool
+	lt, nl  uint32
+}
+
+func (p *parser) save() parserState {
+	return parserState{p.pos, p.last, p.hasLast, p.c.lt, p.c.nl}
+}
+
+func (p *parser) restore(s parserState) {
+	p.pos, p.last, p.hasLast, p.c.lt, p.c.nl = s.pos, s.last, s.hasLast, s.lt, s.nl
+}
+
+// parseExpr parses a sequence of unary expressions joined by "and" and "or",
+// which have equal precedence and associate to the left.
+func (p *parser) parseExpr() (node, error) {
+	n, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		var isAnd bool
+		switch {
+		case p.isWord("and") || p.isOp("&&"):
+			isAnd = true
+		case p.isWord("or") || p.isOp("||"):
+		default:
+			return n, nil
+		}
+		p.next()
+		m, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		if isAnd {
+			n = and(n, m)
+		} else {
+			n = or(n, m)
+		}
+	}
+}
+
+func (p *parser) parseUnary() (node, error) {
+	switch {
+	case p.isWord("not") || p.isOp("!"):
+		p.next()
+		n, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		return not(n), nil
+	case p.isOp("("):
+		// Parentheses group either a boolean expression or, in a relation
+		// like "(tcp[0] + 1) > 2", an arithmetic one.
+		s := p.save()
+		p.next()
+		n, err := p.parseExpr()
+		if err == nil {
+			if err = p.expectOp(")"); err == nil {
+				return n, nil
+			}
+		}
+		p.restore(s)
+		if n, rerr := p.parseRelation(); rerr == nil {
+			return n, nil
+		}
+		return nil, err
+	case p.relationAhead():
+		return p.parseRelation()
+	}
+	return p.parsePrimitive()
+}
+
+var relationalOps = map[string]bpf.JumpTest{
+	">": bpf.JumpGreaterThan, "<": bpf.JumpLessThan,
+	">=": bpf.JumpGreaterOrEqual, "<=": bpf.JumpLessOrEqual,
+	"=": bpf.JumpEqual, "==": bpf.JumpEqual, "!=": bpf.JumpNotEqual,
+}
+
+// relationAhead returns true if the next tokens start an arithmetic
+// expression rather than a primitive.
+func (p *parser) relationAhead() bool {
+	t := p.peek()
+	if t.kind == tokOp {
+		return t.text == "-"
+	}
+	if t.kind != tokWord {
+		return false
+	}
+	next := p.peekAt(1)
+	if _, ok := namedConstants[t.text]; ok || t.text == "len" {
+		return true
+	}
+	if protoQualifiers[t.text] {
+		return next.kind == tokOp && next.text == "["
+	}
+	if _, ok := parseNumber(t.text); ok {
+		// A number followed by an operator starts a relation; otherwise it
+		// is an identifier, as in "port 80 or 443".
+		if next.kind != tokOp {
+			return false
+		}
+		switch next.text {
+		case ")", "&&", "||", "!":
+			return false
+		}
+		return true
+	}
+	return false
+}
+
+// parseRelation parses "arith relop arith".
+func (p *parser) parseRelation() (node, error) {
+	a, err := p.parseArith(0)
+	if err != nil {
+		return nil, err
+	}
+	t := p.peek()
+	cond, ok := relationalOps[t.text]
+	if t.kind != tokOp || !ok {
+		return nil, p.errorf("expected relational operator")
+	}
+	p.next()
+	b, err := p.parseArith(0)
+	if err != nil {
+		return nil, err
+	}
+	return p.c.relation(cond, a, b)
+}
+
+type binaryOp struct {
+	prec int
+	op   bpf.ALUOp
+}
+
+// binaryOps follow libpcap's precedence, from loosest to tightest binding.
+var binaryOps = map[string]binaryOp{
+	"|": {1, bpf.ALUOpOr}, "^": {1, bpf.ALUOpXor},
+	"&":  {2, bpf.ALUOpAnd},
+	"<<": {3, bpf.ALUOpShiftLeft}, ">>": {3, bpf.ALUOpShiftRight},
+	"+": {4, bpf.ALUOpAdd}, "-": {4, bpf.ALUOpSub},
+	"*": {5, bpf.ALUOpMul}, "/": {5, bpf.ALUOpDiv}, "%": {5, bpf.ALUOpMod},
+}
+
+// parseArith parses an arithmetic expression whose binary operators bind at
+// least as tightly as minPrec.
+func (p *parser) parseArith(minPrec int) (arith, error) {
+	a, err := p.parseArithUnary()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		t := p.peek()
+		op, ok := binaryOps[t.text]
+		if t.kind != tokOp || !ok || op.prec < minPrec {
+			return a, nil
+		}
+		p.next()
+		b, err := p.parseArith(op.prec + 1)
+		if err != nil {
+			return nil, err
+		}
+		if a, err = binary(op.op, a, b); err != nil {
+			return nil, err
+		}
+	}
+}
+
+func (p *parser) parseArithUnary() (arith, error) {
+	t := p.peek()
+	if t.kind == tokOp {
+		switch t.text {
+		case "-":
+			p.next()
+			a, err := p.parseArithUnary()
+			if err != nil {
+				return nil, err
+			}
+			if k, ok := a.(constExpr); ok {
+				return -k, nil
+			}
+			return negExpr{a}, nil
+		case "(":
+			p.next()
+			a, err := p.parseArith(0)
+			if err != nil {
+				return nil, err
+			}
+			return a, p.expectOp(")")
+		}
+		return nil, p.errorf("expected arithmetic expression")
+	}
+	if t.kind != tokWord {
+		return nil, p.errorf("expected arithmetic expression")
+	}
+	if n, ok := parseNumber(t.text); ok {
+		p.next()
+		return constExpr(n), nil
+	}
+	if k, ok := namedConstants[t.text]; ok {
+		p.next()
+		return constExpr(k), nil
+	}
+	if t.text == "len" {
+		p.next()
+		return lenExpr{}, nil
+	}
+	if protoQualifiers[t.text] {
+		p.next()
+		if err := p.expectOp("["); err != nil {
+			return nil, err
+		}
+		idx, err := p.parseArith(0)
+		if err != nil {
+			return nil, err
+		}
+		size := 1
+		if p.isOp(":") {
+			p.next()
+			n, ok := parseNumber(p.peek().text)
+			if !ok || (n != 1 && n != 2 && n != 4) {
+				return nil, p.errorf("data size must be 1, 2 or 4")
+			}
+			p.next()
+			size = int(n)
+		}
+		if err := p.expectOp("]"); err != nil {
+			return nil, err
+		}
+		return p.c.load(t.text, idx, size)
+	}
+	return nil, p.errorf("expected arithmetic expression")
+}
+
+// parsePrimitive parses a primitive such as "src host 10.0.0.1", "tcp",
+// "ether proto arp", "vlan 100" or "less 64".
+func (p *parser) parsePrimitive() (node, error) {
+	t := p.peek()
+	if t.kind != tokWord {
+		return nil, p.errorf("expected primitive")
+	}
+	switch t.text {
+	case "less", "greater":
+		p.next()
+		n, ok := parseNumber(p.peek().text)
+		if !ok {
+			return nil, p.errorf("expected length")
+		}
+		p.next()
+		if t.text == "less" {
+			return test(bpf.JumpLessOrEqual, n, bpf.LoadExtension{Num: bpf.ExtLen}), nil
+		}
+		return test(bpf.JumpGreaterOrEqual, n, bpf.LoadExtension{Num: bpf.ExtLen}), nil
+	case "vlan":
+		p.next()
+		id := -1
+		if n, ok := parseNumber(p.peek().text); ok && p.peek().kind == tokWord {
+			if n > 0xfff {
+				return nil, p.errorf("VLAN ID %d out of range", n)
+			}
+			p.next()
+			id = int(n)
+		}
+		return p.c.vlan(id)
+	case "broadcast", "multicast":
+		p.next()
+		return p.c.castPrimitive("", t.text)
+	}
+
+	var q qualifiers
+	explicit := false
+	if protoQualifiers[t.text] {
+		q.proto = t.text
+		explicit = true
+		p.next()
+		if p.isWord("broadcast") || p.isWord("multicast") {
+			return p.c.castPrimitive(q.proto, p.next().text)
+		}
+	}
+	if p.isWord("src") || p.isWord("dst") {
+		q.dir = p.next().text
+		explicit = true
+		// "src or dst" and "src and dst" are directions too.
+		if (p.isWord("or") || p.isWord("and")) && p.peekAt(1).kind == tokWord {
+			if other := p.peekAt(1).text; (other == "src" || other == "dst") && other != q.dir {
+				q.dir = "src " + p.next().text + " dst"
+				p.next()
+			}
+		}
+	}
+	if t := p.peek(); t.kind == tokWord && typeQualifiers[t.text] {
+		q.typ = t.text
+		explicit = true
+		p.next()
+	}
+	switch {
+	case q.typ == "gateway":
+		return nil, p.errorf("gateway requires name resolution, which is not supported")
+	case q.typ == "proto" || q.typ == "protochain":
+		if q.dir != "" {
+			return nil, p.errorf("direction not allowed with %s", q.typ)
+		}
+		if !p.isID() && !protoQualifiers[p.peek().text] {
+			return nil, p.errorf("expected protocol")
+		}
+		if q.typ == "protochain" {
+			return p.c.protochain(q.proto, p.next().text)
+		}
+		return p.c.protoPrimitive(q.proto, p.next().text)
+	case explicit && !p.isID():
+		if q.dir == "" && q.typ == "" {
+			return p.c.protoAbbrev(q.proto)
+		}
+		return nil, p.errorf("expected identifier")
+	case !explicit:
+		if !p.isID() {
+			return nil, p.errorf("expected primitive")
+		}
+		if p.hasLast {
+			q = p.last
+		}
+	}
+	id := p.next().text
+	if q.typ == "net" && p.isOp("/") {
+		// "/" is an operator to the lexer, as in "net 10.0.0.0/8".
+		p.next()
+		if _, ok := parseNumber(p.peek().text); !ok || p.peek().kind != tokWord {
+			return nil, p.errorf("expected prefix length")
+		}
+		id += "/" + p.next().text
+	} else if q.typ == "net" && p.isWord("mask") {
+		p.next()
+		if !p.isID() {
+			return nil, p.errorf("expected netmask")
+		}
+		id += " mask " + p.next().text
+	}
+	n, err := p.c.primitive(q, id)
+	if err != nil {
+		return nil, fmt.Errorf("%v at offset %d", err, t.pos)
+	}
+	p.last, p.hasLast = q, true
+	return n, nil
+}
@@ -0,0 +1,115 @@
+// Copyright 2019 The GoPacket Authors. All rights reserved.
+//
+// Use of this source code is governed by a BSD-style license
+// that can be found in the LICENSE file in the root of the source
+// tree.
+
+/*
+Package pcapfilter compiles pcap filter expressions, as used by tcpdump, into
+BPF programs without requiring cgo or C libpcap.
+
+Its output can be handed to anything accepting golang.org/x/net/bpf
+instructions, such as pcapgo.EthernetHandle.SetBPF or afpacket.TPacket.SetBPF,
+so that filtering happens in the kernel:
+
+	insns, err := pcapfilter.Compile(layers.LinkTypeEthernet, 65536, "tcp port 80")
+	if err != nil {
+		...
+	}
+	if err := handle.SetBPF(insns); err != nil {
+		...
+	}
+
+Offline captures can be filtered without the kernel too: NewBPF runs a
+compiled program in the pure-Go VM from golang.org/x/net/bpf, and
+FilteredSource applies it to any gopacket.PacketDataSource, such as a
+pcapgo.Reader, counting the packets it matches and drops.
+
+# Supported Syntax
+
+The grammar follows pcap-filter(7).  Primitives are combined with and (&&),
+or (||) and not (!), where and and or have equal precedence and associate to
+the left, and parentheses group.  Supported primitives are:
+
+	[src|dst|src or dst|src and dst] host ADDR      (IPv4, IPv6 or ethernet)
+	[src|dst|...] net NET[/LEN] | net NET mask MASK
+	[src|dst|...] port PORT | portrange LO-HI       (numbers or service names)
+	ether|ip|ip6|proto PROTO                         (as in "ip proto tcp")
+	[ip|ip6] protochain PROTO
+	ip|ip6|arp|rarp|tcp|udp|sctp|icmp|icmp6|igmp
+	[ether|ip|ip6] broadcast|multicast
+	vlan [ID]
+	less LEN | greater LEN
+
+Primitives may be qualified by a protocol, as in "tcp src port 80" or "arp
+host 10.0.0.1", and an identifier on its own reuses the qualifiers of the
+primitive before it, so "host 10.0.0.1 or 10.0.0.2" matches either host.
+
+Relations compare arithmetic expressions made of numbers, len, packet
+accesses like tcp[13] or ip[2:2], the named constants libpcap defines
+(tcpflags, tcp-syn, icmptype, icmp-echo, ...) and the operators + - * / % &
+| ^ << >>, as in "tcp[tcpflags] & (tcp-syn|tcp-ack) != 0".  As in libpcap,
+transport layer accesses are only valid for unfragmented IPv4 packets.
+
+Like libpcap, "vlan" moves the offsets used by the primitives after it past
+the VLAN tag, so "vlan 100 and ip" matches IPv4 packets in VLAN 100.
+
+"protochain" skips at most 8 extension headers, since BPF programs can't loop
+the way libpcap's does.
+
+Host names are not resolved, "gateway" isn't supported, and "ip broadcast" is
+rejected since it needs the netmask of a network interface.
+
+# Link Types
+
+Compile supports layers.LinkTypeEthernet, LinkTypeLinuxSLL, LinkTypeNull,
+LinkTypeLoop, LinkTypeRaw, LinkTypeIPv4 and LinkTypeIPv6.
+*/
+package pcapfilter
+
+import (
+	"github.com/google/gopacket/layers"
+	"golang.org/x/net/bpf"
+)
+
+// defaultSnaplen is used when Compile is given a non-positive capture length.
+const defaultSnaplen = 262144
+
+// Compile compiles a filter expression for packets of the given link type,
+// returning a program which accepts matching packets by returning
+// captureLength and rejects others by returning 0.  An empty expression
+// matches every packet.
+func Compile(linkType layers.LinkType, captureLength int, expr string) ([]bpf.RawInstruction, error) {
+	insns, err := CompileInstructions(linkType, captureLength, expr)
+	if err != nil {
+		return nil, err
+	}
+	return bpf.Assemble(insns)
+}
+
+// CompileInstructions is like Compile, but returns the program as
+// instructions, suitable for bpf.NewVM.
+func CompileInstructions(linkType layers.LinkType, captureLength int, expr string) ([]bpf.Instruction, error) {
+	if captureLength <= 0 {
+		captureLength = defaultSnaplen
+	}
+	c, err := newCompiler(linkType)
+	if err != nil {
+		return nil, err
+	}
+	toks, err := lex(expr)
+	if err != nil {
+		return nil, err
+	}
+	p := &parser{c: c, toks: toks}
+	var n node = constNode(true)
+	if p.peek().kind != tokEOF {
+		if n, err = p.parseExpr(); err != nil {
+			return nil, err
+		}
+		if p.peek().kind != tokEOF {
+			return nil, p.errorf("syntax error")
+		}
+	}
+	return assemble(n, uint32(captureLength))
+}
@@ -0,0 +1,901 @@
+// Copyright 2019 The GoPacket Authors. All rights reserved.
+//
+// Use of this source code is governed by a BSD-style license
+// that can be found in the LICENSE file in the root of the source
+// tree.
+
+package pcapfilter
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"reflect"
+	"testing"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+	"github.com/google/gopacket/pcapgo"
+	"golang.org/x/net/bpf"
+)
+
+// Programs generated by libpcap with "tcpdump -dd -y EN10MB", which Compile
+// reproduces exactly.  The tcp[tcpflags] ones are from the pcap package's
+// tests, the IPv6 networks from the tcpdump listings in
+// github.com/packetcap/go-pcap's filter tests.
+var libpcapPrograms = []struct {
+	expr    string
+	snaplen int
+	prog    []bpf.RawInstruction
+}{
+	{"ip", 262144, []bpf.RawInstruction{
+		{Op: 0x28, Jt: 0, Jf: 0, K: 0x0000000c},
+		{Op: 0x15, Jt: 0, Jf: 1, K: 0x00000800},
+		{Op: 0x6, Jt: 0, Jf: 0, K: 0x00040000},
+		{Op: 0x6, Jt: 0, Jf: 0, K: 0x00000000},
+	}},
+	{"not arp", 262144, []bpf.RawInstruction{
+		{Op: 0x28, Jt: 0, Jf: 0, K: 0x0000000c},
+		{Op: 0x15, Jt: 1, Jf: 0, K: 0x00000806},
+		{Op: 0x6, Jt: 0, Jf: 0, K: 0x00040000},
+		{Op: 0x6, Jt: 0, Jf: 0, K: 0x00000000},
+	}},
+	{"tcp[13] & 2 != 0", 262144, []bpf.RawInstruction{
+		{Op: 0x28, Jt: 0, Jf: 0, K: 0x0000000c},
+		{Op: 0x15, Jt: 0, Jf: 8, K: 0x00000800},
+		{Op: 0x30, Jt: 0, Jf: 0, K: 0x00000017},
+		{Op: 0x15, Jt: 0, Jf: 6, K: 0x00000006},
+		{Op: 0x28, Jt: 0, Jf: 0, K: 0x00000014},
+		{Op: 0x45, Jt: 4, Jf: 0, K: 0x00001fff},
+		{Op: 0xb1, Jt: 0, Jf: 0, K: 0x0000000e},
+		{Op: 0x50, Jt: 0, Jf: 0, K: 0x0000001b},
+		{Op: 0x45, Jt: 0, Jf: 1, K: 0x00000002},
+		{Op: 0x6, Jt: 0, Jf: 0, K: 0x00040000},
+		{Op: 0x6, Jt: 0, Jf: 0, K: 0x00000000},
+	}},
+	{"tcp[tcpflags] & (tcp-syn|tcp-ack) == (tcp-syn|tcp-ack)", 65535, []bpf.RawInstruction{
+		{Op: 0x28, Jt: 0, Jf: 0, K: 0x0000000c},
+		{Op: 0x15, Jt: 0, Jf: 9, K: 0x00000800},
+		{Op: 0x30, Jt: 0, Jf: 0, K: 0x00000017},
+		{Op: 0x15, Jt: 0, Jf: 7, K: 0x00000006},
+		{Op: 0x28, Jt: 0, Jf: 0, K: 0x00000014},
+		{Op: 0x45, Jt: 5, Jf: 0, K: 0x00001fff},
+		{Op: 0xb1, Jt: 0, Jf: 0, K: 0x0000000e},
+		{Op: 0x50, Jt: 0, Jf: 0, K: 0x0000001b},
+		{Op: 0x54, Jt: 0, Jf: 0, K: 0x00000012},
+		{Op: 0x15, Jt: 0, Jf: 1, K: 0x00000012},
+		{Op: 0x6, Jt: 0, Jf: 0, K: 0x0000ffff},
+		{Op: 0x6, Jt: 0, Jf: 0, K: 0x00000000},
+	}},
+	{"tcp[tcpflags] & (tcp-syn|tcp-ack) == tcp-ack", 65535, []bpf.RawInstruction{
+		{Op: 0x28, Jt: 0, Jf: 0, K: 0x0000000c},
+		{Op: 0x15, Jt: 0, Jf: 9, K: 0x00000800},
+		{Op: 0x30, Jt: 0, Jf: 0, K: 0x00000017},
+		{Op: 0x15, Jt: 0, Jf: 7, K: 0x00000006},
+		{Op: 0x28, Jt: 0, Jf: 0, K: 0x00000014},
+		{Op: 0x45, Jt: 5, Jf: 0, K: 0x00001fff},
+		{Op: 0xb1, Jt: 0, Jf: 0, K: 0x0000000e},
+		{Op: 0x50, Jt: 0, Jf: 0, K: 0x0000001b},
+		{Op: 0x54, Jt: 0, Jf: 0, K: 0x00000012},
+		{Op: 0x15, Jt: 0, Jf: 1, K: 0x00000010},
+		{Op: 0x6, Jt: 0, Jf: 0, K: 0x0000ffff},
+		{Op: 0x6, Jt: 0, Jf: 0, K: 0x00000000},
+	}},
+	{"net 2a00:1450:4001:824::/62", 262144, []bpf.RawInstruction{
+		{Op: 0x28, Jt: 0, Jf: 0, K: 0x0000000c},
+		{Op: 0x15, Jt: 0, Jf: 11, K: 0x000086dd},
+		{Op: 0x20, Jt: 0, Jf: 0, K: 0x00000016},
+		{Op: 0x15, Jt: 0, Jf: 3, K: 0x2a001450},
+		{Op: 0x20, Jt: 0, Jf: 0, K: 0x0000001a},
+		{Op: 0x54, Jt: 0, Jf: 0, K: 0xfffffffc},
+		{Op: 0x15, Jt: 5, Jf: 0, K: 0x40010824},
+		{Op: 0x20, Jt: 0, Jf: 0, K: 0x00000026},
+		{Op: 0x15, Jt: 0, Jf: 4, K: 0x2a001450},
+		{Op: 0x20, Jt: 0, Jf: 0, K: 0x0000002a},
+		{Op: 0x54, Jt: 0, Jf: 0, K: 0xfffffffc},
+		{Op: 0x15, Jt: 0, Jf: 1, K: 0x40010824},
+		{Op: 0x6, Jt: 0, Jf: 0, K: 0x00040000},
+		{Op: 0x6, Jt: 0, Jf: 0, K: 0x00000000},
+	}},
+	{"src net 2a00:1450:4001:824::/62", 262144, []bpf.RawInstruction{
+		{Op: 0x28, Jt: 0, Jf: 0, K: 0x0000000c},
+		{Op: 0x15, Jt: 0, Jf: 6, K: 0x000086dd},
+		{Op: 0x20, Jt: 0, Jf: 0, K: 0x00000016},
+		{Op: 0x15, Jt: 0, Jf: 4, K: 0x2a001450},
+		{Op: 0x20, Jt: 0, Jf: 0, K: 0x0000001a},
+		{Op: 0x54, Jt: 0, Jf: 0, K: 0xfffffffc},
+		{Op: 0x15, Jt: 0, Jf: 1, K: 0x40010824},
+		{Op: 0x6, Jt: 0, Jf: 0, K: 0x00040000},
+		{Op: 0x6, Jt: 0, Jf: 0, K: 0x00000000},
+	}},
+	{"dst net 2a00:1450:4001:824::/62", 262144, []bpf.RawInstruction{
+		{Op: 0x28, Jt: 0, Jf: 0, K: 0x0000000c},
+		{Op: 0x15, Jt: 0, Jf: 6, K: 0x000086dd},
+		{Op: 0x20, Jt: 0, Jf: 0, K: 0x00000026},
+		{Op: 0x15, Jt: 0, Jf: 4, K: 0x2a001450},
+		{Op: 0x20, Jt: 0, Jf: 0, K: 0x0000002a},
+		{Op: 0x54, Jt: 0, Jf: 0, K: 0xfffffffc},
+		{Op: 0x15, Jt: 0, Jf: 1, K: 0x40010824},
+		{Op: 0x6, Jt: 0, Jf: 0, K: 0x00040000},
+		{Op: 0x6, Jt: 0, Jf: 0, K: 0x00000000},
+	}},
+	{"src and dst net 2a00:1450:4001:824::/62", 262144, []bpf.RawInstruction{
+		{Op: 0x28, Jt: 0, Jf: 0, K: 0x0000000c},
+		{Op: 0x15, Jt: 0, Jf: 11, K: 0x000086dd},
+		{Op: 0x20, Jt: 0, Jf: 0, K: 0x00000016},
+		{Op: 0x15, Jt: 0, Jf: 9, K: 0x2a001450},
+		{Op: 0x20, Jt: 0, Jf: 0, K: 0x0000001a},
+		{Op: 0x54, Jt: 0, Jf: 0, K: 0xfffffffc},
+		{Op: 0x15, Jt: 0, Jf: 6, K: 0x40010824},
+		{Op: 0x20, Jt: 0, Jf: 0, K: 0x00000026},
+		{Op: 0x15, Jt: 0, Jf: 4, K: 0x2a001450},
+		{Op: 0x20, Jt: 0, Jf: 0, K: 0x0000002a},
+		{Op: 0x54, Jt: 0, Jf: 0, K: 0xfffffffc},
+		{Op: 0x15, Jt: 0, Jf: 1, K: 0x40010824},
+		{Op: 0x6, Jt: 0, Jf: 0, K: 0x00040000},
+		{Op: 0x6, Jt: 0, Jf: 0, K: 0x00000000},
+	}},
+}
+
+// libpcapOptimized are programs libpcap's optimizer has rearranged, so that
+// Compile only matches their behavior.  They are also from go-pcap's tcpdump
+// listings, except for "(ip or ip6) and udp", which is from the pcap
+// package's tests.
+var libpcapOptimized = []struct {
+	expr string
+	prog []bpf.RawInstruction
+}{
+	{"port 22", []bpf.RawInstruction{
+		{Op: 0x28, Jt: 0, Jf: 0, K: 0x0000000c},
+		{Op: 0x15, Jt: 0, Jf: 8, K: 0x000086dd},
+		{Op: 0x30, Jt: 0, Jf: 0, K: 0x00000014},
+		{Op: 0x15, Jt: 2, Jf: 0, K: 0x00000084},
+		{Op: 0x15, Jt: 1, Jf: 0, K: 0x00000006},
+		{Op: 0x15, Jt: 0, Jf: 17, K: 0x00000011},
+		{Op: 0x28, Jt: 0, Jf: 0, K: 0x00000036},
+		{Op: 0x15, Jt: 14, Jf: 0, K: 0x00000016},
+		{Op: 0x28, Jt: 0, Jf: 0, K: 0x00000038},
+		{Op: 0x15, Jt: 12, Jf: 13, K: 0x00000016},
+		{Op: 0x15, Jt: 0, Jf: 12, K: 0x00000800},
+		{Op: 0x30, Jt: 0, Jf: 0, K: 0x00000017},
+		{Op: 0x15, Jt: 2, Jf: 0, K: 0x00000084},
+		{Op: 0x15, Jt: 1, Jf: 0, K: 0x00000006},
+		{Op: 0x15, Jt: 0, Jf: 8, K: 0x00000011},
+		{Op: 0x28, Jt: 0, Jf: 0, K: 0x00000014},
+		{Op: 0x45, Jt: 6, Jf: 0, K: 0x00001fff},
+		{Op: 0xb1, Jt: 0, Jf: 0, K: 0x0000000e},
+		{Op: 0x48, Jt: 0, Jf: 0, K: 0x0000000e},
+		{Op: 0x15, Jt: 2, Jf: 0, K: 0x00000016},
+		{Op: 0x48, Jt: 0, Jf: 0, K: 0x00000010},
+		{Op: 0x15, Jt: 0, Jf: 1, K: 0x00000016},
+		{Op: 0x6, Jt: 0, Jf: 0, K: 0x00040000},
+		{Op: 0x6, Jt: 0, Jf: 0, K: 0x00000000},
+	}},
+	{"udp port 23", []bpf.RawInstruction{
+		{Op: 0x28, Jt: 0, Jf: 0, K: 0x0000000c},
+		{Op: 0x15, Jt: 0, Jf: 6, K: 0x000086dd},
+		{Op: 0x30, Jt: 0, Jf: 0, K: 0x00000014},
+		{Op: 0x15, Jt: 0, Jf: 15, K: 0x00000011},
+		{Op: 0x28, Jt: 0, Jf: 0, K: 0x00000036},
+		{Op: 0x15, Jt: 12, Jf: 0, K: 0x00000017},
+		{Op: 0x28, Jt: 0, Jf: 0, K: 0x00000038},
+		{Op: 0x15, Jt: 10, Jf: 11, K: 0x00000017},
+		{Op: 0x15, Jt: 0, Jf: 10, K: 0x00000800},
+		{Op: 0x30, Jt: 0, Jf: 0, K: 0x00000017},
+		{Op: 0x15, Jt: 0, Jf: 8, K: 0x00000011},
+		{Op: 0x28, Jt: 0, Jf: 0, K: 0x00000014},
+		{Op: 0x45, Jt: 6, Jf: 0, K: 0x00001fff},
+		{Op: 0xb1, Jt: 0, Jf: 0, K: 0x0000000e},
+		{Op: 0x48, Jt: 0, Jf: 0, K: 0x0000000e},
+		{Op: 0x15, Jt: 2, Jf: 0, K: 0x00000017},
+		{Op: 0x48, Jt: 0, Jf: 0, K: 0x00000010},
+		{Op: 0x15, Jt: 0, Jf: 1, K: 0x00000017},
+		{Op: 0x6, Jt: 0, Jf: 0, K: 0x00040000},
+		{Op: 0x6, Jt: 0, Jf: 0, K: 0x00000000},
+	}},
+	{"udp and port 23", []bpf.RawInstruction{
+		{Op: 0x28, Jt: 0, Jf: 0, K: 0x0000000c},
+		{Op: 0x15, Jt: 0, Jf: 6, K: 0x000086dd},
+		{Op: 0x30, Jt: 0, Jf: 0, K: 0x00000014},
+		{Op: 0x15, Jt: 0, Jf: 15, K: 0x00000011},
+		{Op: 0x28, Jt: 0, Jf: 0, K: 0x00000036},
+		{Op: 0x15, Jt: 12, Jf: 0, K: 0x00000017},
+		{Op: 0x28, Jt: 0, Jf: 0, K: 0x00000038},
+		{Op: 0x15, Jt: 10, Jf: 11, K: 0x00000017},
+		{Op: 0x15, Jt: 0, Jf: 10, K: 0x00000800},
+		{Op: 0x30, Jt: 0, Jf: 0, K: 0x00000017},
+		{Op: 0x15, Jt: 0, Jf: 8, K: 0x00000011},
+		{Op: 0x28, Jt: 0, Jf: 0, K: 0x00000014},
+		{Op: 0x45, Jt: 6, Jf: 0, K: 0x00001fff},
+		{Op: 0xb1, Jt: 0, Jf: 0, K: 0x0000000e},
+		{Op: 0x48, Jt: 0, Jf: 0, K: 0x0000000e},
+		{Op: 0x15, Jt: 2, Jf: 0, K: 0x00000017},
+		{Op: 0x48, Jt: 0, Jf: 0, K: 0x00000010},
+		{Op: 0x15, Jt: 0, Jf: 1, K: 0x00000017},
+		{Op: 0x6, Jt: 0, Jf: 0, K: 0x00040000},
+		{Op: 0x6, Jt: 0, Jf: 0, K: 0x00000000},
+	}},
+	{"udp and (port 53 or port 67)", []bpf.RawInstruction{
+		{Op: 0x28, Jt: 0, Jf: 0, K: 0x0000000c},
+		{Op: 0x15, Jt: 0, Jf: 7, K: 0x000086dd},
+		{Op: 0x30, Jt: 0, Jf: 0, K: 0x00000014},
+		{Op: 0x15, Jt: 0, Jf: 18, K: 0x00000011},
+		{Op: 0x28, Jt: 0, Jf: 0, K: 0x00000036},
+		{Op: 0x15, Jt: 15, Jf: 0, K: 0x00000035},
+		{Op: 0x15, Jt: 14, Jf: 0, K: 0x00000043},
+		{Op: 0x28, Jt: 0, Jf: 0, K: 0x00000038},
+		{Op: 0x15, Jt: 12, Jf: 11, K: 0x00000035},
+		{Op: 0x15, Jt: 0, Jf: 12, K: 0x00000800},
+		{Op: 0x30, Jt: 0, Jf: 0, K: 0x00000017},
+		{Op: 0x15, Jt: 0, Jf: 10, K: 0x00000011},
+		{Op: 0x28, Jt: 0, Jf: 0, K: 0x00000014},
+		{Op: 0x45, Jt: 8, Jf: 0, K: 0x00001fff},
+		{Op: 0xb1, Jt: 0, Jf: 0, K: 0x0000000e},
+		{Op: 0x48, Jt: 0, Jf: 0, K: 0x0000000e},
+		{Op: 0x15, Jt: 4, Jf: 0, K: 0x00000035},
+		{Op: 0x15, Jt: 3, Jf: 0, K: 0x00000043},
+		{Op: 0x48, Jt: 0, Jf: 0, K: 0x00000010},
+		{Op: 0x15, Jt: 1, Jf: 0, K: 0x00000035},
+		{Op: 0x15, Jt: 0, Jf: 1, K: 0x00000043},
+		{Op: 0x6, Jt: 0, Jf: 0, K: 0x00040000},
+		{Op: 0x6, Jt: 0, Jf: 0, K: 0x00000000},
+	}},
+	{"tcp dst port ftp or ftp-data or domain", []bpf.RawInstruction{
+		{Op: 0x28, Jt: 0, Jf: 0, K: 0x0000000c},
+		{Op: 0x15, Jt: 0, Jf: 4, K: 0x000086dd},
+		{Op: 0x30, Jt: 0, Jf: 0, K: 0x00000014},
+		{Op: 0x15, Jt: 0, Jf: 13, K: 0x00000006},
+		{Op: 0x28, Jt: 0, Jf: 0, K: 0x00000038},
+		{Op: 0x15, Jt: 10, Jf: 8, K: 0x00000015},
+		{Op: 0x15, Jt: 0, Jf: 10, K: 0x00000800},
+		{Op: 0x30, Jt: 0, Jf: 0, K: 0x00000017},
+		{Op: 0x15, Jt: 0, Jf: 8, K: 0x00000006},
+		{Op: 0x28, Jt: 0, Jf: 0, K: 0x00000014},
+		{Op: 0x45, Jt: 6, Jf: 0, K: 0x00001fff},
+		{Op: 0xb1, Jt: 0, Jf: 0, K: 0x0000000e},
+		{Op: 0x48, Jt: 0, Jf: 0, K: 0x00000010},
+		{Op: 0x15, Jt: 2, Jf: 0, K: 0x00000015},
+		{Op: 0x15, Jt: 1, Jf: 0, K: 0x00000014},
+		{Op: 0x15, Jt: 0, Jf: 1, K: 0x00000035},
+		{Op: 0x6, Jt: 0, Jf: 0, K: 0x00040000},
+		{Op: 0x6, Jt: 0, Jf: 0, K: 0x00000000},
+	}},
+	{"net 192.168.0.0/24", []bpf.RawInstruction{
+		{Op: 0x28, Jt: 0, Jf: 0, K: 0x0000000c},
+		{Op: 0x15, Jt: 0, Jf: 6, K: 0x00000800},
+		{Op: 0x20, Jt: 0, Jf: 0, K: 0x0000001a},
+		{Op: 0x54, Jt: 0, Jf: 0, K: 0xffffff00},
+		{Op: 0x15, Jt: 11, Jf: 0, K: 0xc0a80000},
+		{Op: 0x20, Jt: 0, Jf: 0, K: 0x0000001e},
+		{Op: 0x54, Jt: 0, Jf: 0, K: 0xffffff00},
+		{Op: 0x15, Jt: 8, Jf: 9, K: 0xc0a80000},
+		{Op: 0x15, Jt: 1, Jf: 0, K: 0x00000806},
+		{Op: 0x15, Jt: 0, Jf: 7, K: 0x00008035},
+		{Op: 0x20, Jt: 0, Jf: 0, K: 0x0000001c},
+		{Op: 0x54, Jt: 0, Jf: 0, K: 0xffffff00},
+		{Op: 0x15, Jt: 3, Jf: 0, K: 0xc0a80000},
+		{Op: 0x20, Jt: 0, Jf: 0, K: 0x00000026},
+		{Op: 0x54, Jt: 0, Jf: 0, K: 0xffffff00},
+		{Op: 0x15, Jt: 0, Jf: 1, K: 0xc0a80000},
+		{Op: 0x6, Jt: 0, Jf: 0, K: 0x00040000},
+		{Op: 0x6, Jt: 0, Jf: 0, K: 0x00000000},
+	}},
+	{"src net 192.168.0.0/24", []bpf.RawInstruction{
+		{Op: 0x28, Jt: 0, Jf: 0, K: 0x0000000c},
+		{Op: 0x15, Jt: 0, Jf: 3, K: 0x00000800},
+		{Op: 0x20, Jt: 0, Jf: 0, K: 0x0000001a},
+		{Op: 0x54, Jt: 0, Jf: 0, K: 0xffffff00},
+		{Op: 0x15, Jt: 5, Jf: 6, K: 0xc0a80000},
+		{Op: 0x15, Jt: 1, Jf: 0, K: 0x00000806},
+		{Op: 0x15, Jt: 0, Jf: 4, K: 0x00008035},
+		{Op: 0x20, Jt: 0, Jf: 0, K: 0x0000001c},
+		{Op: 0x54, Jt: 0, Jf: 0, K: 0xffffff00},
+		{Op: 0x15, Jt: 0, Jf: 1, K: 0xc0a80000},
+		{Op: 0x6, Jt: 0, Jf: 0, K: 0x00040000},
+		{Op: 0x6, Jt: 0, Jf: 0, K: 0x00000000},
+	}},
+	{"dst net 192.168.0.0/24", []bpf.RawInstruction{
+		{Op: 0x28, Jt: 0, Jf: 0, K: 0x0000000c},
+		{Op: 0x15, Jt: 0, Jf: 3, K: 0x00000800},
+		{Op: 0x20, Jt: 0, Jf: 0, K: 0x0000001e},
+		{Op: 0x54, Jt: 0, Jf: 0, K: 0xffffff00},
+		{Op: 0x15, Jt: 5, Jf: 6, K: 0xc0a80000},
+		{Op: 0x15, Jt: 1, Jf: 0, K: 0x00000806},
+		{Op: 0x15, Jt: 0, Jf: 4, K: 0x00008035},
+		{Op: 0x20, Jt: 0, Jf: 0, K: 0x00000026},
+		{Op: 0x54, Jt: 0, Jf: 0, K: 0xffffff00},
+		{Op: 0x15, Jt: 0, Jf: 1, K: 0xc0a80000},
+		{Op: 0x6, Jt: 0, Jf: 0, K: 0x00040000},
+		{Op: 0x6, Jt: 0, Jf: 0, K: 0x00000000},
+	}},
+	{"src or dst net 192.168.0.0/24", []bpf.RawInstruction{
+		{Op: 0x28, Jt: 0, Jf: 0, K: 0x0000000c},
+		{Op: 0x15, Jt: 0, Jf: 6, K: 0x00000800},
+		{Op: 0x20, Jt: 0, Jf: 0, K: 0x0000001a},
+		{Op: 0x54, Jt: 0, Jf: 0, K: 0xffffff00},
+		{Op: 0x15, Jt: 11, Jf: 0, K: 0xc0a80000},
+		{Op: 0x20, Jt: 0, Jf: 0, K: 0x0000001e},
+		{Op: 0x54, Jt: 0, Jf: 0, K: 0xffffff00},
+		{Op: 0x15, Jt: 8, Jf: 9, K: 0xc0a80000},
+		{Op: 0x15, Jt: 1, Jf: 0, K: 0x00000806},
+		{Op: 0x15, Jt: 0, Jf: 7, K: 0x00008035},
+		{Op: 0x20, Jt: 0, Jf: 0, K: 0x0000001c},
+		{Op: 0x54, Jt: 0, Jf: 0, K: 0xffffff00},
+		{Op: 0x15, Jt: 3, Jf: 0, K: 0xc0a80000},
+		{Op: 0x20, Jt: 0, Jf: 0, K: 0x00000026},
+		{Op: 0x54, Jt: 0, Jf: 0, K: 0xffffff00},
+		{Op: 0x15, Jt: 0, Jf: 1, K: 0xc0a80000},
+		{Op: 0x6, Jt: 0, Jf: 0, K: 0x00040000},
+		{Op: 0x6, Jt: 0, Jf: 0, K: 0x00000000},
+	}},
+	{"src and dst net 192.168.0.0/24", []bpf.RawInstruction{
+		{Op: 0x28, Jt: 0, Jf: 0, K: 0x0000000c},
+		{Op: 0x15, Jt: 0, Jf: 6, K: 0x00000800},
+		{Op: 0x20, Jt: 0, Jf: 0, K: 0x0000001a},
+		{Op: 0x54, Jt: 0, Jf: 0, K: 0xffffff00},
+		{Op: 0x15, Jt: 0, Jf: 12, K: 0xc0a80000},
+		{Op: 0x20, Jt: 0, Jf: 0, K: 0x0000001e},
+		{Op: 0x54, Jt: 0, Jf: 0, K: 0xffffff00},
+		{Op: 0x15, Jt: 8, Jf: 9, K: 0xc0a80000},
+		{Op: 0x15, Jt: 1, Jf: 0, K: 0x00000806},
+		{Op: 0x15, Jt: 0, Jf: 7, K: 0x00008035},
+		{Op: 0x20, Jt: 0, Jf: 0, K: 0x0000001c},
+		{Op: 0x54, Jt: 0, Jf: 0, K: 0xffffff00},
+		{Op: 0x15, Jt: 0, Jf: 4, K: 0xc0a80000},
+		{Op: 0x20, Jt: 0, Jf: 0, K: 0x00000026},
+		{Op: 0x54, Jt: 0, Jf: 0, K: 0xffffff00},
+		{Op: 0x15, Jt: 0, Jf: 1, K: 0xc0a80000},
+		{Op: 0x6, Jt: 0, Jf: 0, K: 0x00040000},
+		{Op: 0x6, Jt: 0, Jf: 0, K: 0x00000000},
+	}},
+	{"udp", []bpf.RawInstruction{
+		{Op: 0x28, Jt: 0, Jf: 0, K: 0x0000000c},
+		{Op: 0x15, Jt: 0, Jf: 5, K: 0x000086dd},
+		{Op: 0x30, Jt: 0, Jf: 0, K: 0x00000014},
+		{Op: 0x15, Jt: 6, Jf: 0, K: 0x00000011},
+		{Op: 0x15, Jt: 0, Jf: 6, K: 0x0000002c},
+		{Op: 0x30, Jt: 0, Jf: 0, K: 0x00000036},
+		{Op: 0x15, Jt: 3, Jf: 4, K: 0x00000011},
+		{Op: 0x15, Jt: 0, Jf: 3, K: 0x00000800},
+		{Op: 0x30, Jt: 0, Jf: 0, K: 0x00000017},
+		{Op: 0x15, Jt: 0, Jf: 1, K: 0x00000011},
+		{Op: 0x6, Jt: 0, Jf: 0, K: 0x00040000},
+		{Op: 0x6, Jt: 0, Jf: 0, K: 0x00000000},
+	}},
+	{"(ip or ip6) and udp", []bpf.RawInstruction{
+		{Op: 0x28, Jt: 0, Jf: 0, K: 0x0000000c},
+		{Op: 0x15, Jt: 0, Jf: 2, K: 0x00000800},
+		{Op: 0x30, Jt: 0, Jf: 0, K: 0x00000017},
+		{Op: 0x15, Jt: 6, Jf: 7, K: 0x00000011},
+		{Op: 0x15, Jt: 0, Jf: 6, K: 0x000086dd},
+		{Op: 0x30, Jt: 0, Jf: 0, K: 0x00000014},
+		{Op: 0x15, Jt: 3, Jf: 0, K: 0x00000011},
+		{Op: 0x15, Jt: 0, Jf: 3, K: 0x0000002c},
+		{Op: 0x30, Jt: 0, Jf: 0, K: 0x00000036},
+		{Op: 0x15, Jt: 0, Jf: 1, K: 0x00000011},
+		{Op: 0x6, Jt: 0, Jf: 0, K: 0x0000ffff},
+		{Op: 0x6, Jt: 0, Jf: 0, K: 0x00000000},
+	}},
+}
+
+func TestCompileMatchesLibpcap(t *testing.T) {
+	for _, test := range libpcapPrograms {
+		prog, err := Compile(layers.LinkTypeEthernet, test.snaplen, test.expr)
+		if err != nil {
+			t.Errorf("%q: %v", test.expr, err)
+			continue
+		}
+		if !reflect.DeepEqual(prog, test.prog) {
+			t.Errorf("%q:\ngot  %v\nwant %v", test.expr, prog, test.prog)
+		}
+	}
+}
+
+type testPacket struct {
+	name     string
+	linkType layers.LinkType
+	data     []byte
+}
+
+func readTestPackets(t *testing.T, filename string) []testPacket {
+	f, err := os.Open(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	r, err := pcapgo.NewReader(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var pkts []testPacket
+	for {
+		data, _, err := r.ReadPacketData()
+		if err != nil {
+			break
+		}
+		pkts = append(pkts, testPacket{filename, r.LinkType(), data})
+	}
+	return pkts
+}
+
+func serializeTestPacket(t *testing.T, name string, ls ...gopacket.SerializableLayer) testPacket {
+	buf := gopacket.NewSerializeBuffer()
+	if err := gopacket.SerializeLayers(buf, gopacket.SerializeOptions{FixLengths: true, ComputeChecksums: true}, ls...); err != nil {
+		t.Fatalf("%s: %v", name, err)
+	}
+	return testPacket{name, layers.LinkTypeEthernet, buf.Bytes()}
+}
+
+// syntheticPackets covers protocols missing from the test pcaps.
+func syntheticPackets(t *testing.T) []testPacket {
+	mac1 := net.HardwareAddr{0x00, 0x11, 0x22, 0x33, 0x44, 0x55}
+	mac2 := net.HardwareAddr{0x66, 0x77, 0x88, 0x99, 0xaa, 0xbb}
+	eth := func(et layers.EthernetType) *layers.Ethernet {
+		return &layers.Ethernet{SrcMAC: mac1, DstMAC: mac2, EthernetType: et}
+	}
+	ip4 := func(proto layers.IPProtocol) *layers.IPv4 {
+		return &layers.IPv4{Version: 4, IHL: 5, TTL: 64, Protocol: proto,
+			SrcIP: net.IP{10, 1, 2, 3}, DstIP: net.IP{192, 168, 7, 9}}
+	}
+	ip6 := &layers.IPv6{Version: 6, HopLimit: 64, NextHeader: layers.IPProtocolUDP,
+		SrcIP: net.ParseIP("fe80::1"), DstIP: net.ParseIP("2001:db8::53")}
+	udp := func(src, dst layers.UDPPort, network gopacket.NetworkLayer) *layers.UDP {
+		u := &layers.UDP{SrcPort: src, DstPort: dst}
+		u.SetNetworkLayerForChecksum(network)
+		return u
+	}
+	payload := gopacket.Payload{1, 2, 3, 4}
+
+	udp4 := ip4(layers.IPProtocolUDP)
+	vlan4 := ip4(layers.IPProtocolUDP)
+	withOptions := ip4(layers.IPProtocolUDP)
+	withOptions.Options = []layers.IPv4Option{{OptionType: 1}, {OptionType: 1}, {OptionType: 1}, {OptionType: 0}}
+	frag := ip4(layers.IPProtocolUDP)
+	frag.FragOffset = 100
+	icmp := ip4(layers.IPProtocolICMPv4)
+	bcast := eth(layers.EthernetTypeARP)
+	bcast.DstMAC = layers.EthernetBroadcast
+
+	return []testPacket{
+		serializeTestPacket(t, "udp4", eth(layers.EthernetTypeIPv4), udp4, udp(1234, 2000, udp4), payload),
+		serializeTestPacket(t, "udp4 with options", eth(layers.EthernetTypeIPv4), withOptions, udp(53, 4321, withOptions), payload),
+		serializeTestPacket(t, "udp4 fragment", eth(layers.EthernetTypeIPv4), frag, udp(53, 53, frag), payload),
+		serializeTestPacket(t, "udp6", eth(layers.EthernetTypeIPv6), ip6, udp(5353, 53, ip6), payload),
+		serializeTestPacket(t, "vlan udp4", eth(layers.EthernetTypeDot1Q),
+			&layers.Dot1Q{VLANIdentifier: 100, Type: layers.EthernetTypeIPv4}, vlan4, udp(80, 80, vlan4), payload),
+		serializeTestPacket(t, "icmp echo", eth(layers.EthernetTypeIPv4), icmp,
+			&layers.ICMPv4{TypeCode: layers.CreateICMPv4TypeCode(layers.ICMPv4TypeEchoRequest, 0)}, payload),
+		serializeTestPacket(t, "arp", bcast, &layers.ARP{
+			AddrType: layers.LinkTypeEthernet, Protocol: layers.EthernetTypeIPv4,
+			HwAddressSize: 6, ProtAddressSize: 4, Operation: layers.ARPRequest,
+			SourceHwAddress: mac1, SourceProtAddress: []byte{10, 1, 2, 3},
+			DstHwAddress: make([]byte, 6), DstProtAddress: []byte{10, 1, 2, 254}}),
+	}
+}
+
+func ipv4(p gopacket.Packet) *layers.IPv4 {
+	ip, _ := p.Layer(layers.LayerTypeIPv4).(*layers.IPv4)
+	return ip
+}
+
+func ipv6(p gopacket.Packet) *layers.IPv6 {
+	ip, _ := p.Layer(layers.LayerTypeIPv6).(*layers.IPv6)
+	return ip
+}
+
+func ports(p gopacket.Packet) (src, dst int, ok bool) {
+	if ip := ipv4(p); ip != nil && ip.FragOffset != 0 {
+		return 0, 0, false
+	}
+	switch l := p.TransportLayer().(type) {
+	case *layers.TCP:
+		return int(l.SrcPort), int(l.DstPort), true
+	case *layers.UDP:
+		return int(l.SrcPort), int(l.DstPort), true
+	}
+	return 0, 0, false
+}
+
+func hasAddr(p gopacket.Packet, addr string) bool {
+	want := net.ParseIP(addr)
+	if ip := ipv4(p); ip != nil {
+		return ip.SrcIP.Equal(want) || ip.DstIP.Equal(want)
+	}
+	if ip := ipv6(p); ip != nil {
+		return ip.SrcIP.Equal(want) || ip.DstIP.Equal(want)
+	}
+	if arp, ok := p.Layer(layers.LayerTypeARP).(*layers.ARP); ok {
+		return net.IP(arp.SourceProtAddress).Equal(want) || net.IP(arp.DstProtAddress).Equal(want)
+	}
+	return false
+}
+
+// oracles describe, in terms of decoded packets, what libpcap matches for
+// each expression.
+var oracles = []struct {
+	expr  string
+	match func(p gopacket.Packet) bool
+}{
+	{"", func(p gopacket.Packet) bool { return true }},
+	{"ip", func(p gopacket.Packet) bool { return ipv4(p) != nil && p.Layer(layers.LayerTypeDot1Q) == nil }},
+	{"ip6", func(p gopacket.Packet) bool { return ipv6(p) != nil }},
+	{"arp", func(p gopacket.Packet) bool { return p.Layer(layers.LayerTypeARP) != nil }},
+	{"not arp", func(p gopacket.Packet) bool { return p.Layer(layers.LayerTypeARP) == nil }},
+	{"tcp", func(p gopacket.Packet) bool { return p.Layer(layers.LayerTypeTCP) != nil }},
+	{"udp", func(p gopacket.Packet) bool {
+		return (ipv4(p) != nil && ipv4(p).Protocol == layers.IPProtocolUDP || p.Layer(layers.LayerTypeUDP) != nil) &&
+			p.Layer(layers.LayerTypeDot1Q) == nil
+	}},
+	{"icmp", func(p gopacket.Packet) bool { return p.Layer(layers.LayerTypeICMPv4) != nil }},
+	{"icmp[icmptype] == icmp-echo", func(p gopacket.Packet) bool {
+		icmp, ok := p.Layer(layers.LayerTypeICMPv4).(*layers.ICMPv4)
+		return ok && icmp.TypeCode.Type() == layers.ICMPv4TypeEchoRequest
+	}},
+	{"udp port 53", func(p gopacket.Packet) bool {
+		src, dst, ok := ports(p)
+		return ok && p.Layer(layers.LayerTypeDot1Q) == nil && p.Layer(layers.LayerTypeUDP) != nil && (src == 53 || dst == 53)
+	}},
+	{"src port domain", func(p gopacket.Packet) bool {
+		src, _, ok := ports(p)
+		return ok && p.Layer(layers.LayerTypeDot1Q) == nil && src == 53
+	}},
+	{"port 80 or 1234", func(p gopacket.Packet) bool {
+		src, dst, ok := ports(p)
+		return ok && p.Layer(layers.LayerTypeDot1Q) == nil && (src == 80 || dst == 80 || src == 1234 || dst == 1234)
+	}},
+	{"tcp portrange 1-1023", func(p gopacket.Packet) bool {
+		src, dst, ok := ports(p)
+		return ok && p.Layer(layers.LayerTypeTCP) != nil && (src < 1024 || dst < 1024)
+	}},
+	{"tcp[tcpflags] & (tcp-syn|tcp-fin) != 0", func(p gopacket.Packet) bool {
+		tcp, ok := p.Layer(layers.LayerTypeTCP).(*layers.TCP)
+		return ok && ipv4(p) != nil && (tcp.SYN || tcp.FIN)
+	}},
+	{"tcp[13] & 2 != 0 and tcp[13] & 16 == 0", func(p gopacket.Packet) bool {
+		tcp, ok := p.Layer(layers.LayerTypeTCP).(*layers.TCP)
+		return ok && ipv4(p) != nil && tcp.SYN && !tcp.ACK
+	}},
+	{"ip[2:2] - ((ip[0] & 0xf) << 2) - ((tcp[12] & 0xf0) >> 2) > 0", func(p gopacket.Packet) bool {
+		tcp, ok := p.Layer(layers.LayerTypeTCP).(*layers.TCP)
+		return ok && ipv4(p) != nil && len(tcp.Payload) > 0
+	}},
+	{"udp[8:4] = 0x01020304", func(p gopacket.Packet) bool {
+		udp, ok := p.Layer(layers.LayerTypeUDP).(*layers.UDP)
+		return ok && ipv4(p) != nil && ipv4(p).FragOffset == 0 && p.Layer(layers.LayerTypeDot1Q) == nil &&
+			reflect.DeepEqual(udp.Payload, []byte{1, 2, 3, 4})
+	}},
+	{"host 10.1.2.3", func(p gopacket.Packet) bool { return p.Layer(layers.LayerTypeDot1Q) == nil && hasAddr(p, "10.1.2.3") }},
+	{"host 172.16.0.1 or 10.1.2.254", func(p gopacket.Packet) bool {
+		return hasAddr(p, "172.16.0.1") || hasAddr(p, "10.1.2.254")
+	}},
+	{"dst net 192.168.0.0/16", func(p gopacket.Packet) bool {
+		ip := ipv4(p)
+		return ip != nil && p.Layer(layers.LayerTypeDot1Q) == nil && ip.DstIP[0] == 192 && ip.DstIP[1] == 168
+	}},
+	{"net 10", func(p gopacket.Packet) bool {
+		if p.Layer(layers.LayerTypeDot1Q) != nil {
+			return false
+		}
+		if ip := ipv4(p); ip != nil {
+			return ip.SrcIP[0] == 10 || ip.DstIP[0] == 10
+		}
+		return p.Layer(layers.LayerTypeARP) != nil
+	}},
+	{"net fe80::/10", func(p gopacket.Packet) bool {
+		ip := ipv6(p)
+		return ip != nil && (ip.SrcIP[0] == 0xfe && ip.SrcIP[1]&0xc0 == 0x80 || ip.DstIP[0] == 0xfe && ip.DstIP[1]&0xc0 == 0x80)
+	}},
+	{"ip6 and tcp dst port 8000", func(p gopacket.Packet) bool {
+		tcp, ok := p.Layer(layers.LayerTypeTCP).(*layers.TCP)
+		return ok && ipv6(p) != nil && tcp.DstPort == 8000
+	}},
+	{"ether src 00:11:22:33:44:55", func(p gopacket.Packet) bool {
+		eth, ok := p.Layer(layers.LayerTypeEthernet).(*layers.Ethernet)
+		return ok && eth.SrcMAC.String() == "00:11:22:33:44:55"
+	}},
+	{"ether broadcast", func(p gopacket.Packet) bool {
+		eth, ok := p.Layer(layers.LayerTypeEthernet).(*layers.Ethernet)
+		return ok && eth.DstMAC.String() == "ff:ff:ff:ff:ff:ff"
+	}},
+	{"vlan 100 and udp port 80", func(p gopacket.Packet) bool {
+		vlan, ok := p.Layer(layers.LayerTypeDot1Q).(*layers.Dot1Q)
+		return ok && vlan.VLANIdentifier == 100 && p.Layer(layers.LayerTypeUDP) != nil
+	}},
+	{"vlan 101", func(p gopacket.Packet) bool { return false }},
+	{"less 80", func(p gopacket.Packet) bool { return len(p.Data()) <= 80 }},
+	{"greater 1000 and not ip", func(p gopacket.Packet) bool { return len(p.Data()) >= 1000 && ipv4(p) == nil }},
+	{"len >= 100 && (tcp || udp)", func(p gopacket.Packet) bool {
+		return len(p.Data()) >= 100 && p.TransportLayer() != nil
+	}},
+	{"(ip or ip6) and not (tcp or udp)", func(p gopacket.Packet) bool {
+		return p.Layer(layers.LayerTypeICMPv4) != nil
+	}},
+	{"ip proto \\udp or ip6 proto 6", func(p gopacket.Packet) bool {
+		if ip := ipv4(p); ip != nil && p.Layer(layers.LayerTypeDot1Q) == nil {
+			return ip.Protocol == layers.IPProtocolUDP
+		}
+		return ipv6(p) != nil && p.Layer(layers.LayerTypeTCP) != nil
+	}},
+}
+
+// ethernetOnly expressions don't compile for other link types.
+var ethernetOnly = map[string]bool{
+	"ether src 00:11:22:33:44:55": true,
+	"ether broadcast":             true,
+	"vlan 100 and udp port 80":    true,
+	"vlan 101":                    true,
+}
+
+func TestCompileMatchesDecoding(t *testing.T) {
+	var pkts []testPacket
+	for _, filename := range []string{"../pcap/test_dns.pcap", "../pcap/test_ethernet.pcap", "../pcap/test_loopback.pcap"} {
+		pkts = append(pkts, readTestPackets(t, filename)...)
+	}
+	pkts = append(pkts, syntheticPackets(t)...)
+
+	for _, o := range oracles {
+		vms := make(map[layers.LinkType]*bpf.VM)
+		for i, pkt := range pkts {
+			if ethernetOnly[o.expr] && pkt.linkType != layers.LinkTypeEthernet {
+				continue
+			}
+			vm := vms[pkt.linkType]
+			if vm == nil {
+				insns, err := CompileInstructions(pkt.linkType, 65535, o.expr)
+				if err != nil {
+					t.Fatalf("%q: %v", o.expr, err)
+				}
+				if vm, err = bpf.NewVM(insns); err != nil {
+					t.Fatalf("%q: %v", o.expr, err)
+				}
+				vms[pkt.linkType] = vm
+			}
+			n, err := vm.Run(pkt.data)
+			if err != nil {
+				t.Fatalf("%q: %v", o.expr, err)
+			}
+			p := gopacket.NewPacket(pkt.data, pkt.linkType, gopacket.Default)
+			if got, want := n != 0, o.match(p); got != want {
+				t.Errorf("%q: %s packet %d: got match %v, want %v", o.expr, pkt.name, i, got, want)
+			}
+		}
+	}
+}
+
+// runProgram runs a raw program over data, reporting whether it matched.
+func runProgram(t *testing.T, expr string, prog []bpf.RawInstruction, data []byte) bool {
+	insns, ok := bpf.Disassemble(prog)
+	if !ok {
+		t.Fatalf("%q: can't disassemble %v", expr, prog)
+	}
+	vm, err := bpf.NewVM(insns)
+	if err != nil {
+		t.Fatalf("%q: %v", expr, err)
+	}
+	n, err := vm.Run(data)
+	if err != nil {
+		t.Fatalf("%q: %v", expr, err)
+	}
+	return n != 0
+}
+
+func TestCompileBehavesLikeLibpcap(t *testing.T) {
+	var pkts []testPacket
+	for _, filename := range []string{"../pcap/test_dns.pcap", "../pcap/test_ethernet.pcap"} {
+		pkts = append(pkts, readTestPackets(t, filename)...)
+	}
+	pkts = append(pkts, syntheticPackets(t)...)
+	eth := &layers.Ethernet{SrcMAC: net.HardwareAddr{0, 1, 2, 3, 4, 5}, DstMAC: net.HardwareAddr{0, 1, 2, 3, 4, 6}}
+	for _, p := range []struct {
+		src, dst string
+		tcp      bool
+		sport    int
+		dport    int
+	}{
+		{"192.168.0.5", "192.168.0.9", true, 40000, 22},
+		{"192.168.0.5", "10.0.0.1", true, 22, 40000},
+		{"10.0.0.1", "192.168.0.200", false, 40000, 23},
+		{"192.168.1.1", "10.0.0.1", false, 67, 68},
+		{"10.0.0.1", "10.0.0.2", true, 40000, 20},
+		{"2001:db8::1", "2001:db8::2", true, 40000, 22},
+		{"2001:db8::1", "2001:db8::2", false, 23, 40000},
+		{"2001:db8::1", "2001:db8::2", false, 40000, 53},
+	} {
+		var network gopacket.NetworkLayer
+		var ip gopacket.SerializableLayer
+		proto := layers.IPProtocolUDP
+		if p.tcp {
+			proto = layers.IPProtocolTCP
+		}
+		if src := net.ParseIP(p.src); src.To4() != nil {
+			eth.EthernetType = layers.EthernetTypeIPv4
+			ip4 := &layers.IPv4{Version: 4, TTL: 64, Protocol: proto, SrcIP: src.To4(), DstIP: net.ParseIP(p.dst).To4()}
+			network, ip = ip4, ip4
+		} else {
+			eth.EthernetType = layers.EthernetTypeIPv6
+			ip6 := &layers.IPv6{Version: 6, HopLimit: 64, NextHeader: proto, SrcIP: src, DstIP: net.ParseIP(p.dst)}
+			network, ip = ip6, ip6
+		}
+		name := fmt.Sprintf("%s:%d > %s:%d", p.src, p.sport, p.dst, p.dport)
+		if p.tcp {
+			tcp := &layers.TCP{SrcPort: layers.TCPPort(p.sport), DstPort: layers.TCPPort(p.dport), SYN: true, Window: 1024}
+			tcp.SetNetworkLayerForChecksum(network)
+			pkts = append(pkts, serializeTestPacket(t, name, eth, ip, tcp))
+		} else {
+			udp := &layers.UDP{SrcPort: layers.UDPPort(p.sport), DstPort: layers.UDPPort(p.dport)}
+			udp.SetNetworkLayerForChecksum(network)
+			pkts = append(pkts, serializeTestPacket(t, name, eth, ip, udp, gopacket.Payload{1, 2, 3, 4}))
+		}
+	}
+
+	for _, test := range libpcapOptimized {
+		prog, err := Compile(layers.LinkTypeEthernet, 262144, test.expr)
+		if err != nil {
+			t.Errorf("%q: %v", test.expr, err)
+			continue
+		}
+		matched := false
+		for i, pkt := range pkts {
+			if pkt.linkType != layers.LinkTypeEthernet {
+				continue
+			}
+			got, want := runProgram(t, test.expr, prog, pkt.data), runProgram(t, test.expr, test.prog, pkt.data)
+			if got != want {
+				t.Errorf("%q: %s packet %d: got match %v, want %v", test.expr, pkt.name, i, got, want)
+			}
+			matched = matched || want
+		}
+		if !matched {
+			t.Errorf("%q: no test packet matches", test.expr)
+		}
+	}
+}
+
+func TestCompileProtochain(t *testing.T) {
+	pkts := readTestPackets(t, "../pcap/test_ipv6_extensions.pcap")
+	ip := &layers.IPv4{Version: 4, TTL: 64, Protocol: layers.IPProtocolAH,
+		SrcIP: net.IP{10, 1, 2, 3}, DstIP: net.IP{10, 1, 2, 4}}
+	udp := &layers.UDP{SrcPort: 1234, DstPort: 53}
+	udp.SetNetworkLayerForChecksum(ip)
+	// An authentication header with a 12 byte ICV, so 24 bytes long.
+	ah := gopacket.Payload{17, 4, 0, 0, 0, 0, 0, 1, 0, 0, 0, 1, 1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12}
+	pkts = append(pkts, serializeTestPacket(t, "ah udp4",
+		&layers.Ethernet{SrcMAC: net.HardwareAddr{0, 1, 2, 3, 4, 5}, DstMAC: net.HardwareAddr{0, 1, 2, 3, 4, 6}, EthernetType: layers.EthernetTypeIPv4},
+		ip, ah, udp, gopacket.Payload{1, 2, 3, 4}))
+	pkts = append(pkts, syntheticPackets(t)[0])
+
+	// The packets are IPv6 with hop-by-hop, destination options and routing
+	// headers before TCP, IPv6 with a hop-by-hop header before UDP, IPv4
+	// with an authentication header before UDP, and plain IPv4 UDP.
+	for _, test := range []struct {
+		expr string
+		want []bool
+	}{
+		{"ip6 protochain 6", []bool{true, false, false, false}},
+		{"ip6 protochain udp", []bool{false, true, false, false}},
+		{"ip6 protochain 43", []bool{true, false, false, false}},
+		{"ip protochain udp", []bool{false, false, true, true}},
+		{"protochain udp", []bool{false, true, true, true}},
+		{"protochain ah", []bool{false, false, true, false}},
+		{"protochain 59", []bool{false, false, false, false}},
+		{"ip6 proto udp", []bool{false, false, false, false}},
+	} {
+		insns, err := CompileInstructions(layers.LinkTypeEthernet, 0, test.expr)
+		if err != nil {
+			t.Errorf("%q: %v", test.expr, err)
+			continue
+		}
+		vm, err := bpf.NewVM(insns)
+		if err != nil {
+			t.Fatalf("%q: %v", test.expr, err)
+		}
+		for i, pkt := range pkts {
+			n, err := vm.Run(pkt.data)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := n != 0; got != test.want[i] {
+				t.Errorf("%q: %s packet %d: got match %v, want %v", test.expr, pkt.name, i, got, test.want[i])
+			}
+		}
+	}
+}
+
+func TestCompileLinkTypes(t *testing.T) {
+	ip4 := []byte{0x45, 0, 0, 20, 0, 0, 0, 0, 64, 6, 0, 0, 10, 0, 0, 1, 10, 0, 0, 2}
+	ip6 := make([]byte, 40)
+	ip6[0], ip6[6] = 0x60, 17
+	for _, test := range []struct {
+		linkType layers.LinkType
+		data     []byte
+		expr     string
+		want     bool
+	}{
+		{layers.LinkTypeRaw, ip4, "ip and tcp", true},
+		{layers.LinkTypeRaw, ip4, "ip6", false},
+		{layers.LinkTypeRaw, ip6, "ip6 and udp", true},
+		{layers.LinkTypeIPv4, ip4, "host 10.0.0.2", true},
+		{layers.LinkTypeIPv6, ip6, "ip", false},
+		{layers.LinkTypeNull, append([]byte{2, 0, 0, 0}, ip4...), "src host 10.0.0.1", true},
+		{layers.LinkTypeLoop, append([]byte{0, 0, 0, 2}, ip4...), "dst host 10.0.0.1", false},
+		{layers.LinkTypeLoop, append([]byte{0, 0, 0, 30}, ip6...), "ip6", true},
+		{layers.LinkTypeLinuxSLL, append(make([]byte, 14), append([]byte{0x08, 0x00}, ip4...)...), "tcp", true},
+	} {
+		insns, err := CompileInstructions(test.linkType, 0, test.expr)
+		if err != nil {
+			t.Errorf("%v %q: %v", test.linkType, test.expr, err)
+			continue
+		}
+		vm, err := bpf.NewVM(insns)
+		if err != nil {
+			t.Fatal(err)
+		}
+		n, err := vm.Run(test.data)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := n != 0; got != test.want {
+			t.Errorf("%v %q: got match %v, want %v", test.linkType, test.expr, got, test.want)
+		}
+		if test.want && n != defaultSnaplen {
+			t.Errorf("%v %q: accepted %d bytes, want %d", test.linkType, test.expr, n, defaultSnaplen)
+		}
+	}
+}
+
+func TestCompileLongProgram(t *testing.T) {
+	// Enough hosts that jumps to the accept and reject returns no longer fit
+	// in 8 bits.
+	expr := "host 10.0.0.0"
+	for i := 1; i < 100; i++ {
+		expr += fmt.Sprintf(" or 10.0.0.%d", i)
+	}
+	insns, err := CompileInstructions(layers.LinkTypeEthernet, 0, expr+" or 10.1.2.3")
+	if err != nil {
+		t.Fatal(err)
+	}
+	vm, err := bpf.NewVM(insns)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pkts := syntheticPackets(t)
+	n, err := vm.Run(pkts[0].data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n == 0 {
+		t.Error("long program rejected matching packet")
+	}
+}
+
+func TestCompileErrors(t *testing.T) {
+	for _, expr := range []string{
+		"host",
+		"tcp port",
+		"host example.com",
+		"port 70000",
+		"net 10.0.0.1/8",
+		"tcp[0:3] = 1",
+		"tcp[0] / 0 = 1",
+		"ip broadcast",
+		"gateway 10.0.0.1",
+		"ip and",
+		"(tcp",
+		"tcp)",
+		"icmp6 port 80",
+		"ip6 host 10.0.0.1",
+		"tcp protochain 6",
+		"src protochain 6",
+		"#",
+	} {
+		if _, err := Compile(layers.LinkTypeEthernet, 0, expr); err == nil {
+			t.Errorf("%q: expected error", expr)
+		}
+	}
+	if _, err := Compile(layers.LinkTypeLinuxSLL, 0, "vlan"); err == nil {
+		t.Error("vlan on Linux SLL: expected error")
+	}
+	if _, err := Compile(layers.LinkTypePPP, 0, "ip"); err == nil {
+		t.Error("PPP: expected unsupported link type error")
+	}
+}
@@ -0,0 +1,197 @@
+// Copyright 2019 The GoPacket Authors. All rights reserved.
+//
+// Use of this source code is governed by a BSD-style license
+// that can be found in the LICENSE file in the root of the source
+// tree.
+
+// Package bgpstream splits the BGP messages of TCP connections reassembled
+// by the reassembly package, and decodes them the way their peers
+// negotiated.
+//
+// How UPDATE messages are encoded depends on capabilities both peers
+// advertise in their OPEN messages: 4-octet AS numbers and ADD-PATH.  A
+// Stream keeps the OPEN message of each direction and decodes the messages
+// after them with the layers.BGPSession they make up.  A StreamFactory
+// plugs into a reassembly.StreamPool:
+//
+//	factory := &bgpstream.StreamFactory{
+//		Message: func(s *bgpstream.Stream, dir reassembly.TCPFlowDirection, ts time.Time, m *layers.BGP) {
+//			if m.Type == layers.BGPTypeUpdate {
+//				fmt.Println(s.Net, s.Transport, m.Update.NLRI)
+//			}
+//		},
+//	}
+//	assembler := reassembly.NewAssembler(reassembly.NewStreamPool(factory))
+package bgpstream
+
+import (
+	"time"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+	"github.com/google/gopacket/reassembly"
+)
+
+// headerLen is the length of the header of BGP messages.
+const headerLen = 19
+
+// StreamFactory is a reassembly.StreamFactory which creates a Stream for
+// each connection.
+type StreamFactory struct {
+	// Message is called with each message of each stream, and the
+	// timestamp of the packet completing it.
+	Message func(s *Stream, dir reassembly.TCPFlowDirection, ts time.Time, m *layers.BGP)
+	// Error, if set, is called with the errors decoding the messages of each
+	// stream.  Malformed messages are skipped, and data which doesn't start
+	// with a message header is skipped up to the next header.
+	Error func(s *Stream, dir reassembly.TCPFlowDirection, err error)
+}
+
+// New implements reassembly.StreamFactory.
+func (f *StreamFactory) New(netFlow, tcpFlow gopacket.Flow, tcp *layers.TCP, ac reassembly.AssemblerContext) reassembly.Stream {
+	return &Stream{Net: netFlow, Transport: tcpFlow, factory: f}
+}
+
+// Stream is a reassembly.Stream which splits the BGP messages of a TCP
+// connection.
+type Stream struct {
+	// Net and Transport are the flows of the connection's first packet.
+	Net, Transport gopacket.Flow
+
+	factory *StreamFactory
+	// buf holds the start of the next message of each direction, and
+	// resync is set for directions whose next message has to be looked
+	// for, after lost data or when the stream was picked up in its middle.
+	buf    [2][]byte
+	resync [2]bool
+	// opens are the OPEN messages of each direction, and sessions the
+	// sessions of the messages they sent.
+	opens    [2]*layers.BGPOpen
+	sessions [2]layers.BGPSession
+}
+
+func dirIndex(dir reassembly.TCPFlowDirection) int {
+	if dir == reassembly.TCPDirClientToServer {
+		return 0
+	}
+	return 1
+}
+
+// Open returns the OPEN message sent in direction dir, or nil if it wasn't
+// seen.
+func (s *Stream) Open(dir reassembly.TCPFlowDirection) *layers.BGPOpen {
+	return s.opens[dirIndex(dir)]
+}
+
+// Session returns the session messages sent in direction dir are decoded
+// with.  Until the OPEN messages of both directions are seen, it is that of
+// 4-octet AS numbers without ADD-PATH.
+func (s *Stream) Session(dir reassembly.TCPFlowDirection) layers.BGPSession {
+	return s.sessions[dirIndex(dir)]
+}
+
+// Accept implements reassembly.Stream, accepting all packets.  Streams
+// whose SYN wasn't seen start at their first packet, from the first
+// message header found.
+func (s *Stream) Accept(tcp *layers.TCP, ci gopacket.CaptureInfo, dir reassembly.TCPFlowDirection, nextSeq reassembly.Sequence, start *bool, ac reassembly.AssemblerContext) bool {
+	if nextSeq == -1 && !tcp.SYN {
+		*start = true
+		s.resync[dirIndex(dir)] = true
+	}
+	return true
+}
+
+// findHeader returns the offset of the first message header in data, or -1
+// if there is none.  Headers are told apart from data by their marker, a
+// valid length and a known type.
+func findHeader(data []byte) int {
+	for i := 0; i+headerLen <= len(data); i++ {
+		if data[i] != 0xff {
+			continue
+		}
+		n, err := layers.BGPMessageLength(data[i:])
+		if err == nil && n > 0 {
+			if t := layers.BGPType(data[i+18]); t >= layers.BGPTypeOpen && t <= layers.BGPTypeRouteRefresh {
+				return i
+			}
+		}
+	}
+	return -1
+}
+
+// ReassembledSG implements reassembly.Stream.
+func (s *Stream) ReassembledSG(sg reassembly.ScatterGather, ac reassembly.AssemblerContext) {
+	dir, _, _, skip := sg.Info()
+	i := dirIndex(dir)
+	if skip != 0 {
+		s.buf[i], s.resync[i] = nil, true
+	}
+	length, _ := sg.Lengths()
+	if length == 0 {
+		return
+	}
+	ci := sg.CaptureInfo(0)
+	if ac != nil {
+		ci = ac.GetCaptureInfo()
+	}
+
+	buf := append(s.buf[i], sg.Fetch(length)...)
+	for {
+		if s.resync[i] {
+			start := findHeader(buf)
+			if start < 0 {
+				// Keep what may be the start of a header.
+				if len(buf) > headerLen-1 {
+					buf = buf[len(buf)-(headerLen-1):]
+				}
+				break
+			}
+			buf, s.resync[i] = buf[start:], false
+		}
+		n, err := layers.BGPMessageLength(buf)
+		if err != nil {
+			s.resync[i] = true
+			s.error(dir, err)
+			continue
+		}
+		if n == 0 || len(buf) < n {
+			break
+		}
+		m := &layers.BGP{Session: s.sessions[i]}
+		if err := m.DecodeFromBytes(append([]byte(nil), buf[:n]...), gopacket.NilDecodeFeedback); err != nil {
+			s.error(dir, err)
+		} else {
+			if m.Type == layers.BGPTypeOpen {
+				s.open(i, &m.Open)
+			}
+			if s.factory.Message != nil {
+				s.factory.Message(s, dir, ci.Timestamp, m)
+			}
+		}
+		buf = buf[n:]
+	}
+	s.buf[i] = append([]byte(nil), buf...)
+}
+
+// open records the OPEN message of direction i, and once both directions'
+// are known, the sessions of both.
+func (s *Stream) open(i int, o *layers.BGPOpen) {
+	s.opens[i] = o
+	if s.opens[1-i] != nil {
+		s.sessions[i] = layers.NewBGPSession(s.opens[i], s.opens[1-i])
+		s.sessions[1-i] = layers.NewBGPSession(s.opens[1-i], s.opens[i])
+	}
+}
+
+func (s *Stream) error(dir reassembly.TCPFlowDirection, err error) {
+	if s.factory.Error != nil {
+		s.factory.Error(s, dir, err)
+	}
+}
+
+// ReassemblyComplete implements reassembly.Stream.  The incomplete messages
+// at the end of the stream are dropped.
+func (s *Stream) ReassemblyComplete(ac reassembly.AssemblerContext) bool {
+	s.buf = [2][]byte{}
+	return true
+}
@@ -0,0 +1,231 @@
+// Copyright 2019 The GoPacket Authors. All rights reserved.
+//
+// Use of this source code is governed by a BSD-style license
+// that can be found in the LICENSE file in the root of the source
+// tree.
+
+package bgpstream
+
+import (
+	"net"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+	"github.com/google/gopacket/reassembly"
+)
+
+var (
+	t0      = time.Date(2019, 4, 1, 12, 0, 0, 0, time.UTC)
+	speaker = net.IP{10, 0, 0, 1}
+	peer    = net.IP{10, 0, 0, 2}
+	ipv4    = layers.BGPAddressFamily{AFI: layers.BGPAFIIPv4, SAFI: layers.BGPSAFIUnicast}
+)
+
+type testContext gopacket.CaptureInfo
+
+func (c *testContext) GetCaptureInfo() gopacket.CaptureInfo {
+	return gopacket.CaptureInfo(*c)
+}
+
+// testConn sends TCP segments of a connection from a speaker to its peer's
+// port 179 to an assembler.
+type testConn struct {
+	a   *reassembly.Assembler
+	seq [2]uint32
+}
+
+func (c *testConn) send(toSpeaker bool, at time.Duration, tcp layers.TCP) {
+	flow := gopacket.NewFlow(layers.EndpointIPv4, speaker, peer)
+	i := 0
+	tcp.SrcPort, tcp.DstPort = 40000, 179
+	if toSpeaker {
+		i = 1
+		flow = flow.Reverse()
+		tcp.SrcPort, tcp.DstPort = 179, 40000
+	}
+	tcp.Seq = c.seq[i]
+	tcp.ACK = !tcp.SYN || i == 1
+	tcp.Ack = c.seq[1-i]
+	c.seq[i] += uint32(len(tcp.Payload))
+	if tcp.SYN || tcp.FIN {
+		c.seq[i]++
+	}
+	// Decoding the segment sets the ports of its transport flow.
+	buf := gopacket.NewSerializeBuffer()
+	if err := gopacket.SerializeLayers(buf, gopacket.SerializeOptions{FixLengths: true}, &tcp, gopacket.Payload(tcp.Payload)); err != nil {
+		panic(err)
+	}
+	var decoded layers.TCP
+	if err := decoded.DecodeFromBytes(buf.Bytes(), gopacket.NilDecodeFeedback); err != nil {
+		panic(err)
+	}
+	c.a.AssembleWithContext(flow, &decoded, &testContext{Timestamp: t0.Add(at)})
+}
+
+func (c *testConn) data(toSpeaker bool, at time.Duration, data []byte) {
+	c.send(toSpeaker, at, layers.TCP{BaseLayer: layers.BaseLayer{Payload: data}})
+}
+
+// serialized returns the serialized messages.
+func serialized(t *testing.T, ms ...*layers.BGP) []byte {
+	var data []byte
+	for _, m := range ms {
+		buf := gopacket.NewSerializeBuffer()
+		if err := m.SerializeTo(buf, gopacket.SerializeOptions{FixLengths: true}); err != nil {
+			t.Fatal(err)
+		}
+		data = append(data, buf.Bytes()...)
+	}
+	return data
+}
+
+type testMessage struct {
+	dir reassembly.TCPFlowDirection
+	ts  time.Time
+	m   *layers.BGP
+}
+
+func newTestConn(f *StreamFactory) *testConn {
+	return &testConn{a: reassembly.NewAssembler(reassembly.NewStreamPool(f)), seq: [2]uint32{1000, 5000}}
+}
+
+func TestStream(t *testing.T) {
+	var msgs []testMessage
+	var errs []error
+	var stream *Stream
+	f := &StreamFactory{
+		Message: func(s *Stream, dir reassembly.TCPFlowDirection, ts time.Time, m *layers.BGP) {
+			stream = s
+			msgs = append(msgs, testMessage{dir, ts, m})
+		},
+		Error: func(s *Stream, dir reassembly.TCPFlowDirection, err error) {
+			errs = append(errs, err)
+		},
+	}
+	c := newTestConn(f)
+	c.send(false, 0, layers.TCP{SYN: true})
+	c.send(true, time.Millisecond, layers.TCP{SYN: true})
+
+	// The speaker supports 4-octet AS numbers and sends path identifiers,
+	// which its peer, an old speaker, can receive.
+	c.data(false, 2*time.Millisecond, serialized(t, &layers.BGP{Type: layers.BGPTypeOpen, Open: layers.BGPOpen{
+		Version: 4, MyAS: 23456, HoldTime: 90, Identifier: speaker,
+		Capabilities: []layers.BGPCapability{
+			{Code: layers.BGPCapabilityFourOctetAS, ASN: 65000},
+			{Code: layers.BGPCapabilityAddPath, AddPath: []layers.BGPAddPathFamily{{BGPAddressFamily: ipv4, Mode: layers.BGPAddPathSend}}},
+		},
+	}}))
+	c.data(true, 3*time.Millisecond, serialized(t,
+		&layers.BGP{Type: layers.BGPTypeOpen, Open: layers.BGPOpen{
+			Version: 4, MyAS: 65001, HoldTime: 90, Identifier: peer,
+			Capabilities: []layers.BGPCapability{
+				{Code: layers.BGPCapabilityAddPath, AddPath: []layers.BGPAddPathFamily{{BGPAddressFamily: ipv4, Mode: layers.BGPAddPathReceive}}},
+			},
+		}},
+		&layers.BGP{Type: layers.BGPTypeKeepalive}))
+
+	// An UPDATE encoded for the session, split across segments.
+	_, prefix, _ := net.ParseCIDR("192.0.2.0/24")
+	update := &layers.BGP{
+		Session: layers.BGPSession{TwoOctetAS: true, AddPath: []layers.BGPAddressFamily{ipv4}},
+		Type:    layers.BGPTypeUpdate,
+		Update: layers.BGPUpdate{
+			PathAttributes: []layers.BGPPathAttribute{
+				{Flags: layers.BGPAttributeTransitive, Type: layers.BGPAttributeOrigin},
+				{Flags: layers.BGPAttributeTransitive, Type: layers.BGPAttributeASPath,
+					ASPath: []layers.BGPASPathSegment{{Type: layers.BGPASSequence, ASNs: []uint32{65000}}}},
+				{Flags: layers.BGPAttributeTransitive, Type: layers.BGPAttributeNextHop, NextHop: speaker},
+			},
+			NLRI: []layers.BGPNLRI{{PathID: 7, Prefix: *prefix}},
+		},
+	}
+	data := serialized(t, &layers.BGP{Type: layers.BGPTypeKeepalive}, update)
+	c.data(false, 4*time.Millisecond, data[:30])
+	c.data(false, 5*time.Millisecond, data[30:])
+
+	// After lost data, the messages of the direction are found again.
+	c.seq[0] += 10
+	c.data(false, 6*time.Millisecond, append([]byte{1, 2, 3}, serialized(t, &layers.BGP{Type: layers.BGPTypeNotification,
+		Notification: layers.BGPNotification{Code: layers.BGPErrorCease, Subcode: 2}})...))
+	c.send(false, 7*time.Millisecond, layers.TCP{FIN: true})
+	c.send(true, 7*time.Millisecond, layers.TCP{FIN: true})
+	c.a.FlushAll()
+
+	if len(errs) != 0 {
+		t.Errorf("errors %v", errs)
+	}
+	var types []layers.BGPType
+	for _, m := range msgs {
+		types = append(types, m.m.Type)
+	}
+	if want := []layers.BGPType{layers.BGPTypeOpen, layers.BGPTypeOpen, layers.BGPTypeKeepalive, layers.BGPTypeKeepalive,
+		layers.BGPTypeUpdate, layers.BGPTypeNotification}; !reflect.DeepEqual(types, want) {
+		t.Fatalf("messages %v, want %v", types, want)
+	}
+	if m := msgs[4]; m.dir != reassembly.TCPDirClientToServer || !m.ts.Equal(t0.Add(5*time.Millisecond)) {
+		t.Errorf("UPDATE sent %v at %v", m.dir, m.ts)
+	}
+	u := msgs[4].m.Update
+	if len(u.NLRI) != 1 || u.NLRI[0].PathID != 7 || u.NLRI[0].Prefix.String() != "192.0.2.0/24" {
+		t.Errorf("NLRI %+v", u.NLRI)
+	}
+	if a := u.Attribute(layers.BGPAttributeASPath); a == nil || !reflect.DeepEqual(a.ASPath[0].ASNs, []uint32{65000}) {
+		t.Errorf("AS_PATH %+v", a)
+	}
+	if o := stream.Open(reassembly.TCPDirServerToClient); o == nil || o.ASN() != 65001 {
+		t.Errorf("peer OPEN %+v", o)
+	}
+	if s := stream.Session(reassembly.TCPDirServerToClient); !s.TwoOctetAS || len(s.AddPath) != 0 {
+		t.Errorf("peer session %+v", s)
+	}
+}
+
+func TestStreamResync(t *testing.T) {
+	var types []layers.BGPType
+	var errs []error
+	f := &StreamFactory{
+		Message: func(s *Stream, dir reassembly.TCPFlowDirection, ts time.Time, m *layers.BGP) {
+			types = append(types, m.Type)
+		},
+		Error: func(s *Stream, dir reassembly.TCPFlowDirection, err error) {
+			errs = append(errs, err)
+		},
+	}
+	keepalive := serialized(t, &layers.BGP{Type: layers.BGPTypeKeepalive})
+
+	// A stream picked up in the middle of a message, with the header of the
+	// next one split across segments.
+	c := newTestConn(f)
+	data := append([]byte("the end of an UPDATE"), keepalive...)
+	c.data(false, 0, data[:25])
+	c.data(false, time.Millisecond, data[25:])
+	c.a.FlushAll()
+	if len(errs) != 0 || !reflect.DeepEqual(types, []layers.BGPType{layers.BGPTypeKeepalive}) {
+		t.Errorf("picked up stream: messages %v, errors %v", types, errs)
+	}
+
+	// Data which isn't a message in a stream whose start was seen.
+	types, errs = nil, nil
+	c = newTestConn(f)
+	c.send(false, 0, layers.TCP{SYN: true})
+	c.data(false, time.Millisecond, append([]byte("not a BGP message at all"), keepalive...))
+	c.a.FlushAll()
+	if len(errs) != 1 || !reflect.DeepEqual(types, []layers.BGPType{layers.BGPTypeKeepalive}) {
+		t.Errorf("bad data: messages %v, errors %v", types, errs)
+	}
+
+	// A malformed message is skipped.
+	types, errs = nil, nil
+	c = newTestConn(f)
+	c.send(false, 0, layers.TCP{SYN: true})
+	bad := append([]byte(nil), keepalive...)
+	bad[17], bad = 20, append(bad, 0)
+	c.data(false, time.Millisecond, append(bad, keepalive...))
+	c.a.FlushAll()
+	if len(errs) != 1 || !reflect.DeepEqual(types, []layers.BGPType{layers.BGPTypeKeepalive}) {
+		t.Errorf("malformed message: messages %v, errors %v", types, errs)
+	}
+}
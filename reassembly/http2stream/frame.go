@@ -0,0 +1,334 @@
+// Copyright 2019 The GoPacket Authors. All rights reserved.
+//
+// Use of this source code is governed by a BSD-style license
+// that can be found in the LICENSE file in the root of the source
+// tree.
+
+package http2stream
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+)
+
+// frameHeaderLen is the length of a frame header.
+const frameHeaderLen = 9
+
+// FrameType is the type of an HTTP/2 frame.
+type FrameType uint8
+
+// The frame types of RFC 7540.
+const (
+	FrameData         FrameType = 0x0
+	FrameHeaders      FrameType = 0x1
+	FramePriority     FrameType = 0x2
+	FrameRSTStream    FrameType = 0x3
+	FrameSettings     FrameType = 0x4
+	FramePushPromise  FrameType = 0x5
+	FramePing         FrameType = 0x6
+	FrameGoAway       FrameType = 0x7
+	FrameWindowUpdate FrameType = 0x8
+	FrameContinuation FrameType = 0x9
+)
+
+func (t FrameType) String() string {
+	switch t {
+	case FrameData:
+		return "DATA"
+	case FrameHeaders:
+		return "HEADERS"
+	case FramePriority:
+		return "PRIORITY"
+	case FrameRSTStream:
+		return "RST_STREAM"
+	case FrameSettings:
+		return "SETTINGS"
+	case FramePushPromise:
+		return "PUSH_PROMISE"
+	case FramePing:
+		return "PING"
+	case FrameGoAway:
+		return "GOAWAY"
+	case FrameWindowUpdate:
+		return "WINDOW_UPDATE"
+	case FrameContinuation:
+		return "CONTINUATION"
+	}
+	return fmt.Sprintf("UNKNOWN_FRAME_TYPE_%d", uint8(t))
+}
+
+// Flags are the flags of a frame, whose meaning depends on its type.
+type Flags uint8
+
+// The frame flags.
+const (
+	// FlagEndStream is set on DATA and HEADERS frames.
+	FlagEndStream Flags = 0x1
+	// FlagAck is set on SETTINGS and PING frames.
+	FlagAck Flags = 0x1
+	// FlagEndHeaders is set on HEADERS, PUSH_PROMISE and CONTINUATION
+	// frames.
+	FlagEndHeaders Flags = 0x4
+	// FlagPadded is set on DATA, HEADERS and PUSH_PROMISE frames.
+	FlagPadded Flags = 0x8
+	// FlagPriority is set on HEADERS frames.
+	FlagPriority Flags = 0x20
+)
+
+// Has tells whether all of v are set.
+func (f Flags) Has(v Flags) bool {
+	return f&v == v
+}
+
+// ErrorCode is the error code of an RST_STREAM or GOAWAY frame.
+type ErrorCode uint32
+
+// The error codes of RFC 7540.
+const (
+	ErrCodeNo                 ErrorCode = 0x0
+	ErrCodeProtocol           ErrorCode = 0x1
+	ErrCodeInternal           ErrorCode = 0x2
+	ErrCodeFlowControl        ErrorCode = 0x3
+	ErrCodeSettingsTimeout    ErrorCode = 0x4
+	ErrCodeStreamClosed       ErrorCode = 0x5
+	ErrCodeFrameSize          ErrorCode = 0x6
+	ErrCodeRefusedStream      ErrorCode = 0x7
+	ErrCodeCancel             ErrorCode = 0x8
+	ErrCodeCompression        ErrorCode = 0x9
+	ErrCodeConnect            ErrorCode = 0xa
+	ErrCodeEnhanceYourCalm    ErrorCode = 0xb
+	ErrCodeInadequateSecurity ErrorCode = 0xc
+	ErrCodeHTTP11Required     ErrorCode = 0xd
+)
+
+var errorCodeNames = map[ErrorCode]string{
+	ErrCodeNo:                 "NO_ERROR",
+	ErrCodeProtocol:           "PROTOCOL_ERROR",
+	ErrCodeInternal:           "INTERNAL_ERROR",
+	ErrCodeFlowControl:        "FLOW_CONTROL_ERROR",
+	ErrCodeSettingsTimeout:    "SETTINGS_TIMEOUT",
+	ErrCodeStreamClosed:       "STREAM_CLOSED",
+	ErrCodeFrameSize:          "FRAME_SIZE_ERROR",
+	ErrCodeRefusedStream:      "REFUSED_STREAM",
+	ErrCodeCancel:             "CANCEL",
+	ErrCodeCompression:        "COMPRESSION_ERROR",
+	ErrCodeConnect:            "CONNECT_ERROR",
+	ErrCodeEnhanceYourCalm:    "ENHANCE_YOUR_CALM",
+	ErrCodeInadequateSecurity: "INADEQUATE_SECURITY",
+	ErrCodeHTTP11Required:     "HTTP_1_1_REQUIRED",
+}
+
+func (e ErrorCode) String() string {
+	if s, ok := errorCodeNames[e]; ok {
+		return s
+	}
+	return fmt.Sprintf("UNKNOWN_ERROR_CODE_%d", uint32(e))
+}
+
+// SettingID is the identifier of a setting.
+type SettingID uint16
+
+// The settings of RFC 7540 and RFC 8441.
+const (
+	SettingHeaderTableSize       SettingID = 0x1
+	SettingEnablePush            SettingID = 0x2
+	SettingMaxConcurrentStreams  SettingID = 0x3
+	SettingInitialWindowSize     SettingID = 0x4
+	SettingMaxFrameSize          SettingID = 0x5
+	SettingMaxHeaderListSize     SettingID = 0x6
+	SettingEnableConnectProtocol SettingID = 0x8
+)
+
+func (s SettingID) String() string {
+	switch s {
+	case SettingHeaderTableSize:
+		return "HEADER_TABLE_SIZE"
+	case SettingEnablePush:
+		return "ENABLE_PUSH"
+	case SettingMaxConcurrentStreams:
+		return "MAX_CONCURRENT_STREAMS"
+	case SettingInitialWindowSize:
+		return "INITIAL_WINDOW_SIZE"
+	case SettingMaxFrameSize:
+		return "MAX_FRAME_SIZE"
+	case SettingMaxHeaderListSize:
+		return "MAX_HEADER_LIST_SIZE"
+	case SettingEnableConnectProtocol:
+		return "ENABLE_CONNECT_PROTOCOL"
+	}
+	return fmt.Sprintf("UNKNOWN_SETTING_%d", uint16(s))
+}
+
+// Setting is a parameter of a SETTINGS frame.
+type Setting struct {
+	ID    SettingID
+	Value uint32
+}
+
+// Priority is the priority of a stream, given by a PRIORITY frame or a
+// HEADERS frame with the PRIORITY flag.
+type Priority struct {
+	StreamDependency uint32
+	Exclusive        bool
+	// Weight is the weight minus one, as sent.
+	Weight uint8
+}
+
+// Frame is an HTTP/2 frame.  The fields after Payload are decoded from it,
+// those which don't apply to the frame's type are zero.
+type Frame struct {
+	Type     FrameType
+	Flags    Flags
+	StreamID uint32
+	// Payload is the frame payload, including any padding.
+	Payload []byte
+
+	// Data is the data of a DATA frame, without padding.
+	Data []byte
+	// HeaderBlock is the header block fragment of a HEADERS, PUSH_PROMISE
+	// or CONTINUATION frame, without padding.
+	HeaderBlock []byte
+	// Priority is set for PRIORITY frames and HEADERS frames with the
+	// PRIORITY flag.
+	Priority *Priority
+	// ErrorCode is that of an RST_STREAM or GOAWAY frame.
+	ErrorCode ErrorCode
+	// Settings are those of a SETTINGS frame.
+	Settings []Setting
+	// PromisedStreamID is that of a PUSH_PROMISE frame.
+	PromisedStreamID uint32
+	// LastStreamID and DebugData are those of a GOAWAY frame.
+	LastStreamID uint32
+	DebugData    []byte
+	// WindowSizeIncrement is that of a WINDOW_UPDATE frame.
+	WindowSizeIncrement uint32
+}
+
+// errShortFrame is returned by ParseFrame for data holding part of a frame.
+var errShortFrame = errors.New("frame too short")
+
+// ParseFrame parses the frame at the start of data, and returns it with its
+// length.  Frames of unknown types are returned with only their header and
+// payload set.  Fields of the frame refer to data.
+func ParseFrame(data []byte) (*Frame, int, error) {
+	if len(data) < frameHeaderLen {
+		return nil, 0, errShortFrame
+	}
+	length := int(data[0])<<16 | int(data[1])<<8 | int(data[2])
+	if len(data) < frameHeaderLen+length {
+		return nil, 0, errShortFrame
+	}
+	f := &Frame{
+		Type:     FrameType(data[3]),
+		Flags:    Flags(data[4]),
+		StreamID: binary.BigEndian.Uint32(data[5:9]) & 0x7fffffff,
+		Payload:  data[frameHeaderLen : frameHeaderLen+length],
+	}
+	n := frameHeaderLen + length
+	if err := f.decodePayload(); err != nil {
+		return nil, n, err
+	}
+	return f, n, nil
+}
+
+func (f *Frame) decodePayload() error {
+	p := f.Payload
+	// streamZero tells whether the frame type belongs to the connection
+	// rather than to a stream.
+	var streamZero bool
+	switch f.Type {
+	case FrameData, FrameHeaders, FramePushPromise:
+		if f.Flags.Has(FlagPadded) {
+			if len(p) < 1 || int(p[0]) > len(p)-1 {
+				return fmt.Errorf("%v frame with invalid padding", f.Type)
+			}
+			p = p[1 : len(p)-int(p[0])]
+		}
+		switch f.Type {
+		case FrameData:
+			f.Data = p
+		case FrameHeaders:
+			if f.Flags.Has(FlagPriority) {
+				if len(p) < 5 {
+					return fmt.Errorf("HEADERS frame of %d bytes too short for priority", len(p))
+				}
+				f.Priority = decodePriority(p)
+				p = p[5:]
+			}
+			f.HeaderBlock = p
+		case FramePushPromise:
+			if len(p) < 4 {
+				return fmt.Errorf("PUSH_PROMISE frame of %d bytes too short", len(p))
+			}
+			f.PromisedStreamID = binary.BigEndian.Uint32(p) & 0x7fffffff
+			f.HeaderBlock = p[4:]
+		}
+	case FrameContinuation:
+		f.HeaderBlock = p
+	case FramePriority:
+		if len(p) != 5 {
+			return fmt.Errorf("PRIORITY frame of %d bytes", len(p))
+		}
+		f.Priority = decodePriority(p)
+	case FrameRSTStream:
+		if len(p) != 4 {
+			return fmt.Errorf("RST_STREAM frame of %d bytes", len(p))
+		}
+		f.ErrorCode = ErrorCode(binary.BigEndian.Uint32(p))
+	case FrameSettings:
+		streamZero = true
+		if len(p)%6 != 0 || f.Flags.Has(FlagAck) && len(p) != 0 {
+			return fmt.Errorf("SETTINGS frame of %d bytes", len(p))
+		}
+		for ; len(p) > 0; p = p[6:] {
+			f.Settings = append(f.Settings, Setting{
+				ID:    SettingID(binary.BigEndian.Uint16(p)),
+				Value: binary.BigEndian.Uint32(p[2:]),
+			})
+		}
+	case FramePing:
+		streamZero = true
+		if len(p) != 8 {
+			return fmt.Errorf("PING frame of %d bytes", len(p))
+		}
+	case FrameGoAway:
+		streamZero = true
+		if len(p) < 8 {
+			return fmt.Errorf("GOAWAY frame of %d bytes too short", len(p))
+		}
+		f.LastStreamID = binary.BigEndian.Uint32(p) & 0x7fffffff
+		f.ErrorCode = ErrorCode(binary.BigEndian.Uint32(p[4:]))
+		f.DebugData = p[8:]
+	case FrameWindowUpdate:
+		if len(p) != 4 {
+			return fmt.Errorf("WINDOW_UPDATE frame of %d bytes", len(p))
+		}
+		f.WindowSizeIncrement = binary.BigEndian.Uint32(p) & 0x7fffffff
+		return nil
+	default:
+		return nil
+	}
+	if streamZero != (f.StreamID == 0) {
+		return fmt.Errorf("%v frame on stream %d", f.Type, f.StreamID)
+	}
+	return nil
+}
+
+func decodePriority(p []byte) *Priority {
+	dep := binary.BigEndian.Uint32(p)
+	return &Priority{
+		StreamDependency: dep & 0x7fffffff,
+		Exclusive:        dep&0x80000000 != 0,
+		Weight:           p[4],
+	}
+}
+
+// AppendFrame appends the encoding of a frame, with its type, flags, stream
+// and payload, to b.  The decoded fields of the frame are ignored.
+func AppendFrame(b []byte, f *Frame) []byte {
+	n := len(f.Payload)
+	b = append(b, byte(n>>16), byte(n>>8), byte(n), byte(f.Type), byte(f.Flags))
+	b = append(b, byte(f.StreamID>>24&0x7f), byte(f.StreamID>>16), byte(f.StreamID>>8), byte(f.StreamID))
+	return append(b, f.Payload...)
+}
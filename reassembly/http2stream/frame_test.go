@@ -0,0 +1,127 @@
+// Copyright 2019 The GoPacket Authors. All rights reserved.
+//
+// Use of this source code is governed by a BSD-style license
+// that can be found in the LICENSE file in the root of the source
+// tree.
+
+package http2stream
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+)
+
+func testFrame(t FrameType, flags Flags, stream uint32, payload ...byte) []byte {
+	return AppendFrame(nil, &Frame{Type: t, Flags: flags, StreamID: stream, Payload: payload})
+}
+
+func TestParseFrame(t *testing.T) {
+	for _, test := range []struct {
+		data []byte
+		want Frame
+	}{
+		{
+			data: testFrame(FrameData, FlagEndStream|FlagPadded, 1, 2, 'h', 'i', 0, 0),
+			want: Frame{Data: []byte("hi")},
+		},
+		{
+			data: testFrame(FrameHeaders, FlagEndHeaders|FlagPriority, 3, 0x80, 0, 0, 1, 15, 0x82),
+			want: Frame{Priority: &Priority{StreamDependency: 1, Exclusive: true, Weight: 15}, HeaderBlock: []byte{0x82}},
+		},
+		{
+			data: testFrame(FramePriority, 0, 3, 0, 0, 0, 1, 255),
+			want: Frame{Priority: &Priority{StreamDependency: 1, Weight: 255}},
+		},
+		{
+			data: testFrame(FrameRSTStream, 0, 3, 0, 0, 0, 8),
+			want: Frame{ErrorCode: ErrCodeCancel},
+		},
+		{
+			data: testFrame(FrameSettings, 0, 0, 0, 1, 0, 0, 0x10, 0, 0, 3, 0, 0, 0, 100),
+			want: Frame{Settings: []Setting{{SettingHeaderTableSize, 4096}, {SettingMaxConcurrentStreams, 100}}},
+		},
+		{
+			data: testFrame(FramePushPromise, FlagEndHeaders, 1, 0, 0, 0, 2, 0x82),
+			want: Frame{PromisedStreamID: 2, HeaderBlock: []byte{0x82}},
+		},
+		{
+			data: testFrame(FramePing, FlagAck, 0, 1, 2, 3, 4, 5, 6, 7, 8),
+		},
+		{
+			data: testFrame(FrameGoAway, 0, 0, 0, 0, 0, 5, 0, 0, 0, 1, 'b', 'y', 'e'),
+			want: Frame{LastStreamID: 5, ErrorCode: ErrCodeProtocol, DebugData: []byte("bye")},
+		},
+		{
+			data: testFrame(FrameWindowUpdate, 0, 0, 0x80, 1, 0, 0),
+			want: Frame{WindowSizeIncrement: 0x10000},
+		},
+		{
+			data: testFrame(FrameContinuation, FlagEndHeaders, 3, 0x84),
+			want: Frame{HeaderBlock: []byte{0x84}},
+		},
+		{
+			data: testFrame(0xf0, 0xff, 7, 1, 2),
+		},
+	} {
+		want := test.want
+		want.Type, want.Flags = FrameType(test.data[3]), Flags(test.data[4])
+		want.StreamID = uint32(test.data[8])
+		want.Payload = test.data[frameHeaderLen:]
+		data := append(test.data, "next"...)
+		f, n, err := ParseFrame(data)
+		if err != nil {
+			t.Errorf("%v: %v", want.Type, err)
+			continue
+		}
+		if n != len(test.data) {
+			t.Errorf("%v: length %d, want %d", want.Type, n, len(test.data))
+		}
+		if !reflect.DeepEqual(*f, want) {
+			t.Errorf("%v: got %+v, want %+v", want.Type, *f, want)
+		}
+		if b := AppendFrame(nil, f); !bytes.Equal(b, test.data) {
+			t.Errorf("%v: encoded %x, want %x", want.Type, b, test.data)
+		}
+	}
+}
+
+func TestParseFrameErrors(t *testing.T) {
+	for _, data := range [][]byte{
+		testFrame(FrameData, FlagPadded, 1, 5, 'a'),
+		testFrame(FrameData, 0, 0, 'a'),
+		testFrame(FrameHeaders, FlagPriority, 1, 0, 0),
+		testFrame(FrameRSTStream, 0, 1, 0, 0, 8),
+		testFrame(FrameSettings, 0, 0, 0, 1, 0),
+		testFrame(FrameSettings, FlagAck, 0, 0, 1, 0, 0, 0, 0),
+		testFrame(FrameSettings, 0, 1),
+		testFrame(FramePing, 0, 0, 1),
+		testFrame(FrameGoAway, 0, 0, 0, 0, 0, 1),
+		testFrame(FramePushPromise, 0, 1, 0, 0),
+	} {
+		if _, _, err := ParseFrame(data); err == nil || err == errShortFrame {
+			t.Errorf("%x: error %v", data, err)
+		}
+	}
+	data := testFrame(FrameData, 0, 1, 'a', 'b')
+	for i := 0; i < len(data); i++ {
+		if _, _, err := ParseFrame(data[:i]); err != errShortFrame {
+			t.Errorf("%d bytes: error %v", i, err)
+		}
+	}
+}
+
+func TestFrameNames(t *testing.T) {
+	if s := FrameWindowUpdate.String(); s != "WINDOW_UPDATE" {
+		t.Error(s)
+	}
+	if s := FrameType(42).String(); s != "UNKNOWN_FRAME_TYPE_42" {
+		t.Error(s)
+	}
+	if s := ErrCodeEnhanceYourCalm.String(); s != "ENHANCE_YOUR_CALM" {
+		t.Error(s)
+	}
+	if s := SettingMaxFrameSize.String(); s != "MAX_FRAME_SIZE" {
+		t.Error(s)
+	}
+}
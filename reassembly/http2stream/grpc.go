@@ -0,0 +1,154 @@
+// Copyright 2019 The GoPacket Authors. All rights reserved.
+//
+// Use of this source code is governed by a BSD-style license
+// that can be found in the LICENSE file in the root of the source
+// tree.
+
+package http2stream
+
+import (
+	"bytes"
+	"compress/gzip"
+	"compress/zlib"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// grpcPrefixLen is the length of the prefix of gRPC messages: a compressed
+// flag and a 32-bit length.
+const grpcPrefixLen = 5
+
+// maxGRPCMessageSize bounds the size of decompressed messages, as gRPC
+// implementations do by default.
+const maxGRPCMessageSize = 4 << 20
+
+// IsGRPC tells whether a message is gRPC, by its content-type.
+func (m *Message) IsGRPC() bool {
+	ct := m.ContentType()
+	return ct == "application/grpc" || strings.HasPrefix(ct, "application/grpc+")
+}
+
+// GRPCMessage is a length-prefixed message of a gRPC call, usually a
+// protocol buffer.
+type GRPCMessage struct {
+	// Compressed tells whether Data is compressed with the grpc-encoding of
+	// its HTTP/2 message.  Data compressed with gzip or deflate is
+	// decompressed, unless it would be over 4 MiB.
+	Compressed bool
+	Data       []byte
+}
+
+// SplitGRPC splits the data of a gRPC request or response into its
+// messages.  It returns the messages it could split along with the first
+// error, of a truncated message or of a compressed one which couldn't be
+// decompressed.
+func SplitGRPC(m *Message) ([]GRPCMessage, error) {
+	var msgs []GRPCMessage
+	var first error
+	encoding := m.Header("grpc-encoding")
+	for data := m.Data; len(data) > 0; {
+		if len(data) < grpcPrefixLen {
+			return msgs, errors.New("truncated gRPC message prefix")
+		}
+		length := binary.BigEndian.Uint32(data[1:grpcPrefixLen])
+		if uint64(len(data)-grpcPrefixLen) < uint64(length) {
+			return msgs, fmt.Errorf("gRPC message of %d bytes truncated to %d", length, len(data)-grpcPrefixLen)
+		}
+		msg := GRPCMessage{
+			Compressed: data[0]&1 != 0,
+			Data:       data[grpcPrefixLen : grpcPrefixLen+length],
+		}
+		data = data[grpcPrefixLen+length:]
+		if msg.Compressed {
+			d, err := grpcDecompress(encoding, msg.Data)
+			switch {
+			case err != nil && first == nil:
+				first = err
+			case err == nil:
+				msg.Compressed, msg.Data = false, d
+			}
+		}
+		msgs = append(msgs, msg)
+	}
+	return msgs, first
+}
+
+func grpcDecompress(encoding string, data []byte) ([]byte, error) {
+	var r io.Reader
+	switch encoding {
+	case "gzip":
+		zr, err := gzip.NewReader(bytes.NewReader(data))
+		if err != nil {
+			return nil, err
+		}
+		r = zr
+	case "deflate":
+		zr, err := zlib.NewReader(bytes.NewReader(data))
+		if err != nil {
+			return nil, err
+		}
+		r = zr
+	default:
+		return nil, fmt.Errorf("unsupported gRPC encoding %q", encoding)
+	}
+	d, err := ioutil.ReadAll(io.LimitReader(r, maxGRPCMessageSize+1))
+	if err == nil && len(d) > maxGRPCMessageSize {
+		err = fmt.Errorf("decompressed gRPC message over %d bytes", maxGRPCMessageSize)
+	}
+	return d, err
+}
+
+// GRPCCall is a gRPC call, made of the messages of an exchange.
+type GRPCCall struct {
+	// Service and Method are those of the request's path,
+	// /Service/Method.
+	Service, Method     string
+	Requests, Responses []GRPCMessage
+	// Status is the grpc-status of the response, or -1 if it has none, and
+	// StatusMessage its grpc-message.
+	Status        int
+	StatusMessage string
+}
+
+// NewGRPCCall returns the gRPC call of an exchange, and the first error of
+// splitting its messages.  The call is nil if the exchange's request isn't
+// gRPC.
+func NewGRPCCall(e *Exchange) (*GRPCCall, error) {
+	req := e.Request
+	if req == nil || !req.IsGRPC() {
+		return nil, errors.New("not a gRPC request")
+	}
+	c := &GRPCCall{Status: -1}
+	path := strings.TrimPrefix(req.Path(), "/")
+	if i := strings.LastIndexByte(path, '/'); i >= 0 {
+		c.Service, c.Method = path[:i], path[i+1:]
+	}
+	var first error
+	c.Requests, first = SplitGRPC(req)
+	if resp := e.Response; resp != nil {
+		var err error
+		if c.Responses, err = SplitGRPC(resp); first == nil {
+			first = err
+		}
+		// Responses without messages may carry the status in their headers.
+		status, msg := resp.Trailer("grpc-status"), resp.Trailer("grpc-message")
+		if resp.Trailers == nil {
+			status, msg = resp.Header("grpc-status"), resp.Header("grpc-message")
+		}
+		if n, err := strconv.Atoi(status); err == nil {
+			c.Status = n
+		}
+		// The message is percent-encoded.
+		if u, err := url.PathUnescape(msg); err == nil {
+			msg = u
+		}
+		c.StatusMessage = msg
+	}
+	return c, first
+}
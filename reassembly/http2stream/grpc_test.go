@@ -0,0 +1,105 @@
+// Copyright 2019 The GoPacket Authors. All rights reserved.
+//
+// Use of this source code is governed by a BSD-style license
+// that can be found in the LICENSE file in the root of the source
+// tree.
+
+package http2stream
+
+import (
+	"bytes"
+	"compress/gzip"
+	"testing"
+
+	"golang.org/x/net/http2/hpack"
+)
+
+// grpcData returns length-prefixed messages, compressed if their flag is
+// set.
+func grpcData(compressed bool, msgs ...string) []byte {
+	var b []byte
+	for _, m := range msgs {
+		data := []byte(m)
+		flag := byte(0)
+		if compressed {
+			var buf bytes.Buffer
+			w := gzip.NewWriter(&buf)
+			w.Write(data)
+			w.Close()
+			data, flag = buf.Bytes(), 1
+		}
+		n := len(data)
+		b = append(b, flag, byte(n>>24), byte(n>>16), byte(n>>8), byte(n))
+		b = append(b, data...)
+	}
+	return b
+}
+
+func TestGRPC(t *testing.T) {
+	p := newTestParser(t)
+	p.start()
+	req := p.headers(c2s, ":method", "POST", ":scheme", "http", ":path", "/helloworld.Greeter/SayHello",
+		"content-type", "application/grpc+proto", "grpc-encoding", "gzip", "te", "trailers")
+	p.send(c2s, testFrame(FrameHeaders, FlagEndHeaders, 1, req...))
+	data := grpcData(true, "\x0a\x05world", "\x0a\x03you")
+	p.send(c2s, testFrame(FrameData, 0, 1, data[:7]...))
+	p.send(c2s, testFrame(FrameData, FlagEndStream, 1, data[7:]...))
+	p.send(s2c, testFrame(FrameHeaders, FlagEndHeaders, 1, p.headers(s2c, ":status", "200", "content-type", "application/grpc")...))
+	p.send(s2c, testFrame(FrameData, 0, 1, grpcData(false, "\x0a\x0bhello world")...))
+	p.send(s2c, testFrame(FrameHeaders, FlagEndHeaders|FlagEndStream, 1, p.headers(s2c, "grpc-status", "0")...))
+
+	// A trailers-only response.
+	req = p.headers(c2s, ":method", "POST", ":path", "/helloworld.Greeter/SayHello", "content-type", "application/grpc")
+	p.send(c2s, testFrame(FrameHeaders, FlagEndHeaders, 3, req...))
+	p.send(c2s, testFrame(FrameData, FlagEndStream, 3, grpcData(false, "")...))
+	p.send(s2c, testFrame(FrameHeaders, FlagEndHeaders|FlagEndStream, 3,
+		p.headers(s2c, ":status", "200", "content-type", "application/grpc", "grpc-status", "5", "grpc-message", "no%20such%20name")...))
+	if len(p.errs) > 0 || len(p.exchanges) != 2 {
+		t.Fatalf("exchanges %q, errors %v", describe(p.exchanges), p.errs)
+	}
+
+	c, err := NewGRPCCall(p.exchanges[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.Service != "helloworld.Greeter" || c.Method != "SayHello" || c.Status != 0 || c.StatusMessage != "" {
+		t.Errorf("call %+v", c)
+	}
+	if len(c.Requests) != 2 || string(c.Requests[0].Data) != "\x0a\x05world" || c.Requests[0].Compressed || string(c.Requests[1].Data) != "\x0a\x03you" {
+		t.Errorf("requests %+v", c.Requests)
+	}
+	if len(c.Responses) != 1 || string(c.Responses[0].Data) != "\x0a\x0bhello world" {
+		t.Errorf("responses %+v", c.Responses)
+	}
+
+	c, err = NewGRPCCall(p.exchanges[1])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(c.Requests) != 1 || len(c.Requests[0].Data) != 0 || len(c.Responses) != 0 || c.Status != 5 || c.StatusMessage != "no such name" {
+		t.Errorf("trailers-only call %+v", c)
+	}
+}
+
+func TestSplitGRPC(t *testing.T) {
+	data := grpcData(false, "one", "two")
+	msgs, err := SplitGRPC(&Message{Data: data[:len(data)-1]})
+	if err == nil || len(msgs) != 1 || string(msgs[0].Data) != "one" {
+		t.Errorf("truncated message: %+v, %v", msgs, err)
+	}
+	// Compressed messages of unknown encodings are left as sent.
+	data = []byte("\x01\x00\x00\x00\x02zz")
+	msgs, err = SplitGRPC(&Message{Data: data})
+	if err == nil || len(msgs) != 1 || !msgs[0].Compressed || string(msgs[0].Data) != "zz" {
+		t.Errorf("compressed message: %+v, %v", msgs, err)
+	}
+	// So are messages which decompress to too much.
+	data = grpcData(true, string(make([]byte, maxGRPCMessageSize+1)))
+	msgs, err = SplitGRPC(&Message{Headers: []hpack.HeaderField{{Name: "grpc-encoding", Value: "gzip"}}, Data: data})
+	if err == nil || len(msgs) != 1 || !msgs[0].Compressed || len(msgs[0].Data) != len(data)-grpcPrefixLen {
+		t.Errorf("large compressed message: %d messages, %v", len(msgs), err)
+	}
+	if c, err := NewGRPCCall(&Exchange{Request: &Message{}}); c != nil || err == nil {
+		t.Errorf("call of a request which isn't gRPC: %v, %v", c, err)
+	}
+}
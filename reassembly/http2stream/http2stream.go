@@ -0,0 +1,121 @@
+// Copyright 2019 The GoPacket Authors. All rights reserved.
+//
+// Use of this source code is governed by a BSD-style license
+// that can be found in the LICENSE file in the root of the source
+// tree.
+
+// Package http2stream parses the HTTP/2 frames of TCP connections
+// reassembled by the reassembly package, such as h2c and gRPC connections,
+// and assembles the request and response of each stream.
+//
+// Connections are recognized by the client's connection preface.  The
+// header blocks of each direction, sent in HEADERS, PUSH_PROMISE and
+// CONTINUATION frames, are decoded with an HPACK dynamic table per
+// direction, and messages are made of their header fields, the data of
+// their DATA frames and their trailers.  A StreamFactory plugs into a
+// reassembly.StreamPool:
+//
+//	factory := &http2stream.StreamFactory{
+//		Exchange: func(s *http2stream.Stream, e *http2stream.Exchange) {
+//			if call, err := http2stream.NewGRPCCall(e); call != nil {
+//				fmt.Println(call.Service, call.Method, len(call.Requests), len(call.Responses), call.Status, err)
+//			}
+//		},
+//	}
+//	assembler := reassembly.NewAssembler(reassembly.NewStreamPool(factory))
+//
+// A Parser can also be fed stream data directly, such as that of an
+// httpstream tunnel after an upgrade to h2c.  TLS connections can be
+// decrypted with the tlsdecrypt package first.
+package http2stream
+
+import (
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+	"github.com/google/gopacket/reassembly"
+)
+
+// StreamFactory is a reassembly.StreamFactory which creates a Stream for
+// each connection.
+type StreamFactory struct {
+	// Exchange is called with each exchange of each stream.
+	Exchange func(s *Stream, e *Exchange)
+	// Frame, if set, is called with each frame of each stream, whose fields
+	// are only valid during the call.
+	Frame func(s *Stream, dir reassembly.TCPFlowDirection, f *Frame)
+	// Error, if set, is called with the error which stops the parsing of a
+	// direction of a stream, as for connections which aren't HTTP/2.
+	Error func(s *Stream, dir reassembly.TCPFlowDirection, err error)
+	// MaxDataSize, if positive, is the number of bytes kept of the data of
+	// each message.
+	MaxDataSize int
+}
+
+// New implements reassembly.StreamFactory.
+func (f *StreamFactory) New(netFlow, tcpFlow gopacket.Flow, tcp *layers.TCP, ac reassembly.AssemblerContext) reassembly.Stream {
+	s := &Stream{
+		Net:       netFlow,
+		Transport: tcpFlow,
+		Parser:    &Parser{MaxDataSize: f.MaxDataSize},
+		factory:   f,
+	}
+	s.Parser.Exchange = func(e *Exchange) {
+		if f.Exchange != nil {
+			f.Exchange(s, e)
+		}
+	}
+	if f.Frame != nil {
+		s.Parser.Frame = func(dir reassembly.TCPFlowDirection, fr *Frame) {
+			f.Frame(s, dir, fr)
+		}
+	}
+	return s
+}
+
+// Stream is a reassembly.Stream which parses an HTTP/2 connection.
+type Stream struct {
+	// Net and Transport are the flows of the connection's first packet.
+	Net, Transport gopacket.Flow
+	Parser         *Parser
+
+	factory *StreamFactory
+}
+
+// Accept implements reassembly.Stream, accepting all packets.  Streams
+// whose SYN wasn't seen start at their first packet, which is parsed if it
+// starts the connection's data.
+func (s *Stream) Accept(tcp *layers.TCP, ci gopacket.CaptureInfo, dir reassembly.TCPFlowDirection, nextSeq reassembly.Sequence, start *bool, ac reassembly.AssemblerContext) bool {
+	if nextSeq == -1 && !tcp.SYN {
+		*start = true
+	}
+	return true
+}
+
+// ReassembledSG implements reassembly.Stream.
+func (s *Stream) ReassembledSG(sg reassembly.ScatterGather, ac reassembly.AssemblerContext) {
+	dir, _, _, skip := sg.Info()
+	if skip != 0 {
+		s.error(dir, s.Parser.Skip(dir, skip))
+	}
+	length, _ := sg.Lengths()
+	if length == 0 {
+		return
+	}
+	ci := sg.CaptureInfo(0)
+	if ac != nil {
+		ci = ac.GetCaptureInfo()
+	}
+	s.error(dir, s.Parser.Parse(dir, sg.Fetch(length), ci.Timestamp))
+}
+
+func (s *Stream) error(dir reassembly.TCPFlowDirection, err error) {
+	if err != nil && s.factory.Error != nil {
+		s.factory.Error(s, dir, err)
+	}
+}
+
+// ReassemblyComplete implements reassembly.Stream.
+func (s *Stream) ReassemblyComplete(ac reassembly.AssemblerContext) bool {
+	s.Parser.Close()
+	return true
+}
@@ -0,0 +1,122 @@
+// Copyright 2019 The GoPacket Authors. All rights reserved.
+//
+// Use of this source code is governed by a BSD-style license
+// that can be found in the LICENSE file in the root of the source
+// tree.
+
+package http2stream
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+	"github.com/google/gopacket/reassembly"
+)
+
+type testContext gopacket.CaptureInfo
+
+func (c *testContext) GetCaptureInfo() gopacket.CaptureInfo {
+	return gopacket.CaptureInfo(*c)
+}
+
+// testConn sends TCP segments of a connection to an assembler, a second
+// apart.
+type testConn struct {
+	a   *reassembly.Assembler
+	now time.Time
+	seq [2]uint32
+}
+
+func (c *testConn) send(dir reassembly.TCPFlowDirection, tcp layers.TCP) {
+	flow := gopacket.NewFlow(layers.EndpointIPv4, net.IP{10, 0, 0, 1}, net.IP{10, 0, 0, 2})
+	i := 0
+	tcp.SrcPort, tcp.DstPort = 40000, 80
+	if dir == s2c {
+		i = 1
+		flow = flow.Reverse()
+		tcp.SrcPort, tcp.DstPort = 80, 40000
+	}
+	tcp.Seq = c.seq[i]
+	tcp.ACK = !tcp.SYN || i == 1
+	tcp.Ack = c.seq[1-i]
+	c.seq[i] += uint32(len(tcp.Payload))
+	if tcp.SYN || tcp.FIN {
+		c.seq[i]++
+	}
+	c.now = c.now.Add(time.Second)
+	c.a.AssembleWithContext(flow, &tcp, &testContext{Timestamp: c.now})
+}
+
+func (c *testConn) data(dir reassembly.TCPFlowDirection, data []byte) {
+	c.send(dir, layers.TCP{BaseLayer: layers.BaseLayer{Payload: data}})
+}
+
+func TestStream(t *testing.T) {
+	var exchanges []*Exchange
+	var errs []error
+	frames := 0
+	f := &StreamFactory{
+		Exchange: func(s *Stream, e *Exchange) {
+			if s.Net.Dst().String() != "10.0.0.2" {
+				t.Errorf("network flow %v", s.Net)
+			}
+			exchanges = append(exchanges, e)
+		},
+		Frame: func(s *Stream, dir reassembly.TCPFlowDirection, f *Frame) {
+			frames++
+		},
+		Error: func(s *Stream, dir reassembly.TCPFlowDirection, err error) {
+			errs = append(errs, err)
+		},
+	}
+	c := &testConn{
+		a:   reassembly.NewAssembler(reassembly.NewStreamPool(f)),
+		now: testTime,
+		seq: [2]uint32{1000, 5000},
+	}
+	p := newTestParser(t)
+	c.send(c2s, layers.TCP{SYN: true})
+	c.send(s2c, layers.TCP{SYN: true})
+	c.data(c2s, []byte(Preface[:10]))
+	c.data(c2s, append([]byte(Preface[10:]), testFrame(FrameSettings, 0, 0)...))
+	c.data(s2c, testFrame(FrameSettings, 0, 0))
+	c.data(c2s, testFrame(FrameHeaders, FlagEndHeaders|FlagEndStream, 1, p.headers(c2s, ":method", "GET", ":path", "/")...))
+	c.data(s2c, testFrame(FrameHeaders, FlagEndHeaders, 1, p.headers(s2c, ":status", "200")...))
+	c.data(s2c, testFrame(FrameData, FlagEndStream, 1, 'o', 'k'))
+	c.send(c2s, layers.TCP{FIN: true})
+	c.send(s2c, layers.TCP{FIN: true})
+	c.a.FlushAll()
+
+	if len(errs) > 0 {
+		t.Errorf("errors %v", errs)
+	}
+	checkExchanges(t, exchanges, "1 GET /  200 ok")
+	if frames != 5 {
+		t.Errorf("%d frames", frames)
+	}
+}
+
+func TestStreamNotHTTP2(t *testing.T) {
+	var errs []error
+	f := &StreamFactory{
+		Error: func(s *Stream, dir reassembly.TCPFlowDirection, err error) {
+			errs = append(errs, err)
+		},
+	}
+	c := &testConn{
+		a:   reassembly.NewAssembler(reassembly.NewStreamPool(f)),
+		now: testTime,
+		seq: [2]uint32{1000, 5000},
+	}
+	c.send(c2s, layers.TCP{SYN: true})
+	c.send(s2c, layers.TCP{SYN: true})
+	c.data(c2s, []byte("GET / HTTP/1.1\r\n\r\n"))
+	c.data(c2s, []byte("GET / HTTP/1.1\r\n\r\n"))
+	c.a.FlushAll()
+	if len(errs) != 1 {
+		t.Errorf("errors %v", errs)
+	}
+}
@@ -0,0 +1,440 @@
+// Copyright 2019 The GoPacket Authors. All rights reserved.
+//
+// Use of this source code is governed by a BSD-style license
+// that can be found in the LICENSE file in the root of the source
+// tree.
+
+package http2stream
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"golang.org/x/net/http2/hpack"
+
+	"github.com/google/gopacket/reassembly"
+)
+
+// Preface is the connection preface sent by HTTP/2 clients.
+const Preface = "PRI * HTTP/2.0\r\n\r\nSM\r\n\r\n"
+
+// defaultHeaderTableSize is the initial size of HPACK dynamic tables.
+const defaultHeaderTableSize = 4096
+
+// Message is the request or response of a stream: its header fields, its
+// data and its trailers.
+type Message struct {
+	StreamID uint32
+	// Dir is the direction the message was sent in.  That of the requests
+	// of pushed streams is the server's, as they come from PUSH_PROMISE
+	// frames.
+	Dir reassembly.TCPFlowDirection
+	// Headers holds the header fields, pseudo-header fields first.
+	Headers []hpack.HeaderField
+	// Data is the data of the DATA frames.
+	Data []byte
+	// Trailers holds the header fields which followed the data.
+	Trailers []hpack.HeaderField
+	// DataSize is the number of bytes of data sent, without padding.
+	DataSize int
+	// Truncated tells whether Data is incomplete, because the stream was
+	// reset, the connection ended early or the data was over the size
+	// limit.
+	Truncated bool
+	// Start and End are the timestamps of the data completing the first
+	// and last frames of the message.
+	Start, End time.Time
+
+	complete bool
+}
+
+// Header returns the value of the first header field with the given name,
+// or "".  Names are lower case in HTTP/2.
+func (m *Message) Header(name string) string {
+	return fieldValue(m.Headers, name)
+}
+
+// Trailer returns the value of the first trailer field with the given
+// name, or "".
+func (m *Message) Trailer(name string) string {
+	return fieldValue(m.Trailers, name)
+}
+
+func fieldValue(fields []hpack.HeaderField, name string) string {
+	for _, f := range fields {
+		if f.Name == name {
+			return f.Value
+		}
+	}
+	return ""
+}
+
+// informational tells whether m is the head of a 1xx response.
+func (m *Message) informational() bool {
+	status := m.Header(":status")
+	return len(status) == 3 && status[0] == '1' && m.Trailers == nil && m.DataSize == 0
+}
+
+// Exchange is the request and response of a stream.
+type Exchange struct {
+	StreamID uint32
+	// Request is nil if the stream's request wasn't seen, as for the
+	// stream of a connection upgraded from HTTP/1.1.
+	Request *Message
+	// Interim holds the informational (1xx) responses which preceded
+	// Response.
+	Interim []*Message
+	// Response is nil if the stream ended before a response.
+	Response *Message
+	// Pushed tells whether the server pushed the stream, with a request
+	// from a PUSH_PROMISE frame.
+	Pushed bool
+	// Reset tells whether the stream was reset, by an RST_STREAM frame
+	// with ResetCode.
+	Reset     bool
+	ResetCode ErrorCode
+}
+
+// Parser parses the frames of both directions of an HTTP/2 connection,
+// decodes their header blocks, and assembles the messages of each stream.
+// The data of both directions must be given in the order it was sent, as
+// reassembly does, from the start of the connection: the client's starts
+// with the connection preface, and the server's with a SETTINGS frame.
+// A direction whose data is lost can't be followed further, since its
+// header compression state is lost with it.
+type Parser struct {
+	// Exchange is called with each exchange once its request and response
+	// are complete, once its stream is reset, or once the connection ends.
+	Exchange func(e *Exchange)
+	// Frame, if set, is called with each frame, whose fields are only
+	// valid during the call.
+	Frame func(dir reassembly.TCPFlowDirection, f *Frame)
+	// MaxDataSize, if positive, is the number of bytes kept of the data of
+	// each message.
+	MaxDataSize int
+
+	halves    [2]half
+	exchanges map[uint32]*Exchange
+	closed    bool
+}
+
+type role int
+
+const (
+	roleUnknown role = iota
+	roleClient
+	roleServer
+)
+
+// half is the state of one direction.
+type half struct {
+	dir    reassembly.TCPFlowDirection
+	role   role
+	buf    []byte
+	ts     time.Time
+	failed bool
+	// started tells whether data of the direction was seen.
+	started bool
+	decoder *hpack.Decoder
+
+	// block is the header block being received in the CONTINUATION frames
+	// following blockFrame.
+	block      []byte
+	blockFrame *Frame
+	blockStart time.Time
+}
+
+func (p *Parser) half(dir reassembly.TCPFlowDirection) *half {
+	i := 0
+	if dir == reassembly.TCPDirServerToClient {
+		i = 1
+	}
+	h := &p.halves[i]
+	h.dir = dir
+	if h.decoder == nil {
+		h.decoder = hpack.NewDecoder(defaultHeaderTableSize, nil)
+	}
+	return h
+}
+
+func (p *Parser) other(h *half) *half {
+	return p.half(h.dir.Reverse())
+}
+
+// Parse takes the next data of a direction, with the timestamp of the
+// packet it arrived in.  It returns an error if the direction isn't HTTP/2
+// or is malformed, after which the direction is ignored.
+func (p *Parser) Parse(dir reassembly.TCPFlowDirection, data []byte, ts time.Time) error {
+	h := p.half(dir)
+	if h.failed || len(data) == 0 {
+		return nil
+	}
+	h.started = true
+	h.ts = ts
+	h.buf = append(h.buf, data...)
+	if h.role == roleUnknown {
+		switch {
+		case len(h.buf) < len(Preface) && bytes.HasPrefix([]byte(Preface), h.buf):
+			return nil
+		case bytes.HasPrefix(h.buf, []byte(Preface)):
+			h.role = roleClient
+			h.buf = h.buf[len(Preface):]
+		case len(h.buf) < frameHeaderLen:
+			return nil
+		case FrameType(h.buf[3]) == FrameSettings && h.buf[5]|h.buf[6]|h.buf[7]|h.buf[8] == 0:
+			h.role = roleServer
+		default:
+			return p.fail(h, errors.New("not an HTTP/2 connection"))
+		}
+	}
+
+	off := 0
+	for {
+		f, n, err := ParseFrame(h.buf[off:])
+		if err == errShortFrame {
+			break
+		}
+		if err != nil {
+			return p.fail(h, err)
+		}
+		off += n
+		if err := p.frame(h, f); err != nil {
+			return p.fail(h, err)
+		}
+	}
+	if rest := h.buf[off:]; len(rest) > 0 {
+		// Keep the start of the next frame, without the frames before it.
+		h.buf = append([]byte(nil), rest...)
+	} else {
+		h.buf = nil
+	}
+	return nil
+}
+
+// fail stops parsing a direction.
+func (p *Parser) fail(h *half, err error) error {
+	h.failed = true
+	h.buf = nil
+	h.block, h.blockFrame = nil, nil
+	return fmt.Errorf("%v: %v", h.dir, err)
+}
+
+// Skip tells the Parser that n bytes of a direction were lost, or an
+// unknown amount if n is negative.  Unless no data of the direction was
+// seen yet, the direction can't be followed further, which Skip returns as
+// an error.
+func (p *Parser) Skip(dir reassembly.TCPFlowDirection, n int) error {
+	h := p.half(dir)
+	if h.failed || !h.started {
+		return nil
+	}
+	if n < 0 {
+		return p.fail(h, errors.New("data lost"))
+	}
+	return p.fail(h, fmt.Errorf("%d bytes lost", n))
+}
+
+// Close tells the Parser that the connection ended.  All remaining
+// exchanges are passed to Exchange, in stream order, with their incomplete
+// messages truncated.
+func (p *Parser) Close() {
+	if p.closed {
+		return
+	}
+	p.closed = true
+	ids := make([]uint32, 0, len(p.exchanges))
+	for id := range p.exchanges {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+	for _, id := range ids {
+		p.emit(p.exchanges[id])
+	}
+}
+
+func (p *Parser) frame(h *half, f *Frame) error {
+	if p.Frame != nil {
+		p.Frame(h.dir, f)
+	}
+	if h.blockFrame != nil && (f.Type != FrameContinuation || f.StreamID != h.blockFrame.StreamID) {
+		return fmt.Errorf("%v frame on stream %d within the header block of stream %d", f.Type, f.StreamID, h.blockFrame.StreamID)
+	}
+	switch f.Type {
+	case FrameHeaders, FramePushPromise:
+		// Keep the frame without the fields which refer to the buffer.
+		h.blockFrame = &Frame{Type: f.Type, Flags: f.Flags, StreamID: f.StreamID, PromisedStreamID: f.PromisedStreamID}
+		h.block = append([]byte(nil), f.HeaderBlock...)
+		h.blockStart = h.ts
+		if f.Flags.Has(FlagEndHeaders) {
+			return p.headerBlock(h)
+		}
+	case FrameContinuation:
+		if h.blockFrame == nil {
+			return fmt.Errorf("CONTINUATION frame on stream %d without a header block", f.StreamID)
+		}
+		h.block = append(h.block, f.HeaderBlock...)
+		if f.Flags.Has(FlagEndHeaders) {
+			return p.headerBlock(h)
+		}
+	case FrameData:
+		p.data(h, f)
+	case FrameRSTStream:
+		if e := p.exchanges[f.StreamID]; e != nil {
+			e.Reset, e.ResetCode = true, f.ErrorCode
+			p.emit(e)
+		}
+	case FrameSettings:
+		for _, s := range f.Settings {
+			if s.ID == SettingHeaderTableSize {
+				// The setting bounds the table of the peer's encoder.
+				p.other(h).decoder.SetAllowedMaxDynamicTableSize(s.Value)
+			}
+		}
+	}
+	return nil
+}
+
+// headerBlock decodes a complete header block.
+func (p *Parser) headerBlock(h *half) error {
+	f := h.blockFrame
+	block := h.block
+	h.blockFrame, h.block = nil, nil
+	fields, err := h.decoder.DecodeFull(block)
+	if err != nil {
+		return fmt.Errorf("header block of stream %d: %v", f.StreamID, err)
+	}
+	if f.Type == FramePushPromise {
+		e := p.exchange(f.PromisedStreamID)
+		e.Pushed = true
+		e.Request = &Message{
+			StreamID: f.PromisedStreamID,
+			Dir:      h.dir,
+			Headers:  fields,
+			Start:    h.blockStart,
+			End:      h.ts,
+			complete: true,
+		}
+		return nil
+	}
+
+	e := p.exchange(f.StreamID)
+	slot := p.slot(h, e)
+	m := *slot
+	switch {
+	case m == nil:
+		m = p.message(h, f.StreamID)
+		m.Headers = fields
+		*slot = m
+	case m.Headers == nil:
+		// Data came first, which a malformed stream could do.
+		m.Headers = fields
+	case m.informational() && slot == &e.Response:
+		e.Interim = append(e.Interim, m)
+		m = p.message(h, f.StreamID)
+		m.Headers = fields
+		*slot = m
+	default:
+		m.Trailers = fields
+	}
+	m.End = h.ts
+	if f.Flags.Has(FlagEndStream) {
+		p.end(e, m)
+	}
+	return nil
+}
+
+func (p *Parser) data(h *half, f *Frame) {
+	// DATA frames don't open streams, those of unknown streams follow a
+	// reset.
+	e := p.exchanges[f.StreamID]
+	if e == nil {
+		return
+	}
+	slot := p.slot(h, e)
+	m := *slot
+	if m == nil {
+		m = p.message(h, f.StreamID)
+		*slot = m
+	}
+	m.DataSize += len(f.Data)
+	d := f.Data
+	if p.MaxDataSize > 0 && len(m.Data)+len(d) > p.MaxDataSize {
+		d = d[:p.MaxDataSize-len(m.Data)]
+		m.Truncated = true
+	}
+	m.Data = append(m.Data, d...)
+	m.End = h.ts
+	if f.Flags.Has(FlagEndStream) {
+		p.end(e, m)
+	}
+}
+
+func (p *Parser) message(h *half, id uint32) *Message {
+	return &Message{StreamID: id, Dir: h.dir, Start: h.blockStart, End: h.ts}
+}
+
+// exchange returns the exchange of a stream, creating it if needed.
+func (p *Parser) exchange(id uint32) *Exchange {
+	if p.exchanges == nil {
+		p.exchanges = make(map[uint32]*Exchange)
+	}
+	e := p.exchanges[id]
+	if e == nil {
+		e = &Exchange{StreamID: id}
+		p.exchanges[id] = e
+	}
+	return e
+}
+
+// slot returns the message of an exchange which a direction sends.  Clients
+// initiate odd streams, and servers push even ones, whose messages are
+// all responses.
+func (p *Parser) slot(h *half, e *Exchange) **Message {
+	if e.StreamID%2 == 1 && h.role == roleClient {
+		return &e.Request
+	}
+	return &e.Response
+}
+
+// end completes a message, and the exchange if both of its messages are.
+func (p *Parser) end(e *Exchange, m *Message) {
+	m.complete = true
+	if e.Request != nil && e.Request.complete && e.Response != nil && e.Response.complete {
+		p.emit(e)
+	}
+}
+
+func (p *Parser) emit(e *Exchange) {
+	delete(p.exchanges, e.StreamID)
+	for _, m := range []*Message{e.Request, e.Response} {
+		if m != nil && !m.complete {
+			m.Truncated = true
+		}
+	}
+	if p.Exchange != nil {
+		p.Exchange(e)
+	}
+}
+
+// Method returns the :method of a request, or "".
+func (m *Message) Method() string { return m.Header(":method") }
+
+// Path returns the :path of a request, or "".
+func (m *Message) Path() string { return m.Header(":path") }
+
+// Status returns the :status of a response, or "".
+func (m *Message) Status() string { return m.Header(":status") }
+
+// ContentType returns the media type of the message's content-type,
+// without parameters.
+func (m *Message) ContentType() string {
+	ct := m.Header("content-type")
+	if i := strings.IndexByte(ct, ';'); i >= 0 {
+		ct = ct[:i]
+	}
+	return strings.ToLower(strings.TrimSpace(ct))
+}
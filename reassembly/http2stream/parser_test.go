@@ -0,0 +1,281 @@
+// Copyright 2019 The GoPacket Authors. All rights reserved.
+//
+// Use of this source code is governed by a BSD-style license
+// that can be found in the LICENSE file in the root of the source
+// tree.
+
+package http2stream
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/net/http2/hpack"
+
+	"github.com/google/gopacket/reassembly"
+)
+
+const (
+	c2s = reassembly.TCPDirClientToServer
+	s2c = reassembly.TCPDirServerToClient
+)
+
+var testTime = time.Date(2018, 1, 1, 0, 0, 0, 0, time.UTC)
+
+// testParser feeds a Parser with the data of each step, a second apart,
+// and returns the exchanges and errors.  It encodes the header blocks of
+// each direction with its own HPACK encoder.
+type testParser struct {
+	Parser
+	t         *testing.T
+	now       time.Time
+	enc       [2]*hpack.Encoder
+	block     [2]bytes.Buffer
+	exchanges []*Exchange
+	frames    []FrameType
+	errs      []error
+}
+
+func newTestParser(t *testing.T) *testParser {
+	p := &testParser{t: t, now: testTime}
+	for i := range p.enc {
+		p.enc[i] = hpack.NewEncoder(&p.block[i])
+	}
+	p.Exchange = func(e *Exchange) {
+		p.exchanges = append(p.exchanges, e)
+	}
+	p.Frame = func(dir reassembly.TCPFlowDirection, f *Frame) {
+		p.frames = append(p.frames, f.Type)
+	}
+	return p
+}
+
+func (p *testParser) send(dir reassembly.TCPFlowDirection, data []byte) {
+	p.now = p.now.Add(time.Second)
+	if err := p.Parse(dir, data, p.now); err != nil {
+		p.errs = append(p.errs, err)
+	}
+}
+
+// headers returns the header block of name and value pairs.
+func (p *testParser) headers(dir reassembly.TCPFlowDirection, fields ...string) []byte {
+	i := 0
+	if dir == s2c {
+		i = 1
+	}
+	for j := 0; j < len(fields); j += 2 {
+		if err := p.enc[i].WriteField(hpack.HeaderField{Name: fields[j], Value: fields[j+1]}); err != nil {
+			p.t.Fatal(err)
+		}
+	}
+	b := append([]byte(nil), p.block[i].Bytes()...)
+	p.block[i].Reset()
+	return b
+}
+
+// start sends the preface and settings of both directions.
+func (p *testParser) start() {
+	p.send(c2s, append([]byte(Preface), testFrame(FrameSettings, 0, 0)...))
+	p.send(s2c, testFrame(FrameSettings, 0, 0, 0, 3, 0, 0, 0, 100))
+	p.send(c2s, testFrame(FrameSettings, FlagAck, 0))
+}
+
+// describe summarizes exchanges as the stream, request method and path,
+// response status, data of both messages and reset code.
+func describe(exchanges []*Exchange) []string {
+	var s []string
+	for _, e := range exchanges {
+		var d []string
+		d = append(d, fmt.Sprint(e.StreamID))
+		if m := e.Request; m != nil {
+			d = append(d, m.Method(), m.Path(), string(m.Data))
+		}
+		if m := e.Response; m != nil {
+			d = append(d, m.Status(), string(m.Data))
+		}
+		if e.Reset {
+			d = append(d, e.ResetCode.String())
+		}
+		s = append(s, strings.Join(d, " "))
+	}
+	return s
+}
+
+func checkExchanges(t *testing.T, exchanges []*Exchange, want ...string) {
+	got := describe(exchanges)
+	if strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("exchanges %q, want %q", got, want)
+	}
+}
+
+func TestParser(t *testing.T) {
+	p := newTestParser(t)
+	p.start()
+	req := p.headers(c2s, ":method", "GET", ":scheme", "http", ":path", "/a", ":authority", "example.com")
+	p.send(c2s, testFrame(FrameHeaders, FlagEndHeaders|FlagEndStream, 1, req...))
+
+	// A request split in CONTINUATION frames, with padded data.
+	req = p.headers(c2s, ":method", "POST", ":scheme", "http", ":path", "/b", ":authority", "example.com", "content-type", "text/plain")
+	p.send(c2s, append(testFrame(FrameHeaders, 0, 3, req[:3]...), testFrame(FrameContinuation, 0, 3, req[3:5]...)...))
+	p.send(c2s, testFrame(FrameContinuation, FlagEndHeaders, 3, req[5:]...))
+	p.send(c2s, testFrame(FrameData, FlagPadded, 3, 3, 'x', 'y', 0, 0, 0))
+	p.send(c2s, testFrame(FrameData, FlagEndStream, 3, 'z'))
+
+	// An informational response, data and trailers.
+	p.send(s2c, testFrame(FrameHeaders, FlagEndHeaders, 1, p.headers(s2c, ":status", "103", "link", "</s.css>")...))
+	p.send(s2c, testFrame(FrameHeaders, FlagEndHeaders, 1, p.headers(s2c, ":status", "200", "content-type", "text/html")...))
+	p.send(s2c, testFrame(FrameData, 0, 1, []byte("hello ")...))
+	p.send(s2c, testFrame(FrameData, 0, 1, []byte("world")...))
+	p.send(s2c, testFrame(FrameHeaders, FlagEndHeaders|FlagEndStream, 1, p.headers(s2c, "x-checksum", "1")...))
+
+	// Stream 3 is reset, and the data in flight ignored.
+	p.send(s2c, testFrame(FrameHeaders, FlagEndHeaders, 3, p.headers(s2c, ":status", "200", "content-type", "text/html")...))
+	p.send(c2s, testFrame(FrameRSTStream, 0, 3, 0, 0, 0, 8))
+	p.send(s2c, testFrame(FrameData, FlagEndStream, 3, 'a'))
+
+	// A request repeating header fields, from the dynamic table.
+	req = p.headers(c2s, ":method", "GET", ":scheme", "http", ":path", "/a", ":authority", "example.com")
+	p.send(c2s, testFrame(FrameHeaders, FlagEndHeaders|FlagEndStream, 5, req...))
+	p.send(c2s, testFrame(FramePing, 0, 0, 1, 2, 3, 4, 5, 6, 7, 8))
+	p.Close()
+
+	if len(p.errs) > 0 {
+		t.Fatalf("errors %v", p.errs)
+	}
+	checkExchanges(t, p.exchanges,
+		"1 GET /a  200 hello world",
+		"3 POST /b xyz 200  CANCEL",
+		"5 GET /a ")
+
+	e := p.exchanges[0]
+	if len(e.Interim) != 1 || e.Interim[0].Header("link") != "</s.css>" {
+		t.Errorf("interim responses %v", e.Interim)
+	}
+	resp := e.Response
+	if resp.Trailer("x-checksum") != "1" || resp.Header("content-type") != "text/html" || resp.DataSize != 11 || resp.Truncated {
+		t.Errorf("response %+v", resp)
+	}
+	if !resp.Start.Equal(testTime.Add(10*time.Second)) || !resp.End.Equal(testTime.Add(13*time.Second)) || resp.Dir != s2c {
+		t.Errorf("response %v %v %v", resp.Start, resp.End, resp.Dir)
+	}
+	if req := p.exchanges[1].Request; req.DataSize != 3 || req.Truncated || req.ContentType() != "text/plain" {
+		t.Errorf("request %+v", req)
+	}
+	if resp := p.exchanges[1].Response; !resp.Truncated {
+		t.Error("reset response not truncated")
+	}
+	if e := p.exchanges[2]; e.Request.Truncated || e.Response != nil {
+		t.Errorf("exchange without a response %+v", e)
+	}
+	if len(p.frames) != 19 || p.frames[18] != FramePing {
+		t.Errorf("frames %v", p.frames)
+	}
+}
+
+func TestParserPush(t *testing.T) {
+	p := newTestParser(t)
+	p.start()
+	p.send(c2s, testFrame(FrameHeaders, FlagEndHeaders|FlagEndStream, 1, p.headers(c2s, ":method", "GET", ":path", "/")...))
+	promise := append([]byte{0, 0, 0, 2}, p.headers(s2c, ":method", "GET", ":path", "/s.css")...)
+	p.send(s2c, testFrame(FramePushPromise, FlagEndHeaders, 1, promise...))
+	p.send(s2c, testFrame(FrameHeaders, FlagEndHeaders, 1, p.headers(s2c, ":status", "200")...))
+	p.send(s2c, testFrame(FrameHeaders, FlagEndHeaders, 2, p.headers(s2c, ":status", "200")...))
+	p.send(s2c, testFrame(FrameData, FlagEndStream, 2, []byte("css")...))
+	p.send(s2c, testFrame(FrameData, FlagEndStream, 1, []byte("html")...))
+	p.Close()
+
+	if len(p.errs) > 0 {
+		t.Fatalf("errors %v", p.errs)
+	}
+	checkExchanges(t, p.exchanges, "2 GET /s.css  200 css", "1 GET /  200 html")
+	if e := p.exchanges[0]; !e.Pushed || e.Request.Dir != s2c {
+		t.Errorf("pushed exchange %+v", e)
+	}
+}
+
+func TestParserHeaderTableSize(t *testing.T) {
+	p := newTestParser(t)
+	p.send(c2s, append([]byte(Preface), testFrame(FrameSettings, 0, 0)...))
+	// The server lets the client use a larger table.
+	p.send(s2c, testFrame(FrameSettings, 0, 0, 0, 1, 0, 0, 0x20, 0))
+	p.enc[0].SetMaxDynamicTableSizeLimit(8192)
+	p.enc[0].SetMaxDynamicTableSize(8192)
+	big := strings.Repeat("v", 5000)
+	for id := uint32(1); id <= 3; id += 2 {
+		req := p.headers(c2s, ":method", "GET", ":path", "/", "x-big", big)
+		p.send(c2s, testFrame(FrameHeaders, FlagEndHeaders|FlagEndStream, id, req...))
+	}
+	p.Close()
+	if len(p.errs) > 0 {
+		t.Fatalf("errors %v", p.errs)
+	}
+	if len(p.exchanges) != 2 || p.exchanges[1].Request.Header("x-big") != big {
+		t.Errorf("exchanges %q", describe(p.exchanges))
+	}
+}
+
+func TestParserSplitData(t *testing.T) {
+	p := newTestParser(t)
+	var data []byte
+	data = append(data, Preface...)
+	data = append(data, testFrame(FrameSettings, 0, 0)...)
+	data = append(data, testFrame(FrameHeaders, FlagEndHeaders, 1, p.headers(c2s, ":method", "PUT", ":path", "/x")...)...)
+	data = append(data, testFrame(FrameData, FlagEndStream, 1, []byte("body")...)...)
+	for i := range data {
+		p.send(c2s, data[i:i+1])
+	}
+	p.send(s2c, testFrame(FrameSettings, 0, 0))
+	p.send(s2c, testFrame(FrameHeaders, FlagEndHeaders|FlagEndStream, 1, p.headers(s2c, ":status", "204")...))
+	if len(p.errs) > 0 {
+		t.Fatalf("errors %v", p.errs)
+	}
+	checkExchanges(t, p.exchanges, "1 PUT /x body 204 ")
+}
+
+func TestParserMaxDataSize(t *testing.T) {
+	p := newTestParser(t)
+	p.MaxDataSize = 3
+	p.start()
+	p.send(c2s, testFrame(FrameHeaders, FlagEndHeaders, 1, p.headers(c2s, ":method", "POST", ":path", "/")...))
+	p.send(c2s, testFrame(FrameData, 0, 1, 'a', 'b'))
+	p.send(c2s, testFrame(FrameData, FlagEndStream, 1, 'c', 'd'))
+	p.Close()
+	if req := p.exchanges[0].Request; string(req.Data) != "abc" || req.DataSize != 4 || !req.Truncated {
+		t.Errorf("request %+v", req)
+	}
+}
+
+func TestParserErrors(t *testing.T) {
+	p := newTestParser(t)
+	p.send(c2s, []byte("GET / HTTP/1.1\r\n\r\n"))
+	p.send(c2s, []byte(Preface))
+	if len(p.errs) != 1 || !strings.Contains(p.errs[0].Error(), "not an HTTP/2 connection") {
+		t.Errorf("errors %v", p.errs)
+	}
+
+	// A frame of another stream within a header block.
+	p = newTestParser(t)
+	p.start()
+	p.send(c2s, testFrame(FrameHeaders, 0, 1, p.headers(c2s, ":method", "GET")...))
+	p.send(c2s, testFrame(FrameData, 0, 3, 'a'))
+	if len(p.errs) != 1 {
+		t.Errorf("errors %v", p.errs)
+	}
+
+	// Lost data stops the direction.
+	p = newTestParser(t)
+	p.start()
+	if err := p.Skip(s2c, 10); err == nil {
+		t.Error("no error for lost data")
+	}
+	p.send(c2s, testFrame(FrameHeaders, FlagEndHeaders|FlagEndStream, 1, p.headers(c2s, ":method", "GET")...))
+	p.send(s2c, testFrame(FrameHeaders, FlagEndHeaders|FlagEndStream, 1, p.headers(s2c, ":status", "200")...))
+	p.Close()
+	if len(p.errs) > 0 {
+		t.Errorf("errors %v", p.errs)
+	}
+	checkExchanges(t, p.exchanges, "1 GET  ")
+}
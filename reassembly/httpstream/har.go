@@ -0,0 +1,375 @@
+// Copyright 2019 The GoPacket Authors. All rights reserved.
+//
+// Use of this source code is governed by a BSD-style license
+// that can be found in the LICENSE file in the root of the source
+// tree.
+
+package httpstream
+
+import (
+	"encoding/base64"
+	"net"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+	"github.com/google/gopacket/reassembly"
+)
+
+// HAR is an HTTP Archive 1.2 document, which browser developer tools can
+// open.  It marshals to the JSON of the format.
+type HAR struct {
+	Log HARLog `json:"log"`
+
+	// streams are those with an entry.
+	streams map[*Stream]bool
+}
+
+// HARLog is the log of a HAR.
+type HARLog struct {
+	Version string     `json:"version"`
+	Creator HARCreator `json:"creator"`
+	// Entries are in the order of their start.
+	Entries []HAREntry `json:"entries"`
+}
+
+// HARCreator is the application which created a HAR.
+type HARCreator struct {
+	Name    string `json:"name"`
+	Version string `json:"version"`
+}
+
+// HAREntry is a HAR entry, holding an HTTP transaction.
+type HAREntry struct {
+	StartedDateTime time.Time   `json:"startedDateTime"`
+	Time            float64     `json:"time"`
+	Request         HARRequest  `json:"request"`
+	Response        HARResponse `json:"response"`
+	Cache           struct{}    `json:"cache"`
+	Timings         HARTimings  `json:"timings"`
+	ServerIPAddress string      `json:"serverIPAddress,omitempty"`
+	// Connection is the client's port.
+	Connection string `json:"connection,omitempty"`
+}
+
+// HARRequest is the request of a HAR entry.
+type HARRequest struct {
+	Method      string         `json:"method"`
+	URL         string         `json:"url"`
+	HTTPVersion string         `json:"httpVersion"`
+	Cookies     []HARCookie    `json:"cookies"`
+	Headers     []HARNameValue `json:"headers"`
+	QueryString []HARNameValue `json:"queryString"`
+	PostData    *HARPostData   `json:"postData,omitempty"`
+	HeadersSize int            `json:"headersSize"`
+	BodySize    int            `json:"bodySize"`
+}
+
+// HARResponse is the response of a HAR entry.  Entries without a response
+// have a zero Status.
+type HARResponse struct {
+	Status      int            `json:"status"`
+	StatusText  string         `json:"statusText"`
+	HTTPVersion string         `json:"httpVersion"`
+	Cookies     []HARCookie    `json:"cookies"`
+	Headers     []HARNameValue `json:"headers"`
+	Content     HARContent     `json:"content"`
+	RedirectURL string         `json:"redirectURL"`
+	HeadersSize int            `json:"headersSize"`
+	BodySize    int            `json:"bodySize"`
+}
+
+// HARNameValue is a header field or query parameter.
+type HARNameValue struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+
+// HARCookie is a cookie of a request or response.
+type HARCookie struct {
+	Name     string     `json:"name"`
+	Value    string     `json:"value"`
+	Path     string     `json:"path,omitempty"`
+	Domain   string     `json:"domain,omitempty"`
+	Expires  *time.Time `json:"expires,omitempty"`
+	HTTPOnly bool       `json:"httpOnly,omitempty"`
+	Secure   bool       `json:"secure,omitempty"`
+}
+
+// HARPostData is the body of a request.  Text is empty if the body isn't
+// UTF-8.
+type HARPostData struct {
+	MimeType string         `json:"mimeType"`
+	Params   []HARNameValue `json:"params"`
+	Text     string         `json:"text"`
+}
+
+// HARContent is the body of a response.  Text is base64 encoded if the
+// body isn't UTF-8.
+type HARContent struct {
+	Size        int    `json:"size"`
+	Compression int    `json:"compression,omitempty"`
+	MimeType    string `json:"mimeType"`
+	Text        string `json:"text,omitempty"`
+	Encoding    string `json:"encoding,omitempty"`
+}
+
+// HARTimings are the phases of a HAR entry, in milliseconds, or -1 for
+// those which don't apply.
+type HARTimings struct {
+	Blocked float64 `json:"blocked"`
+	DNS     float64 `json:"dns"`
+	Connect float64 `json:"connect"`
+	Send    float64 `json:"send"`
+	Wait    float64 `json:"wait"`
+	Receive float64 `json:"receive"`
+	SSL     float64 `json:"ssl"`
+}
+
+// NewHAR returns a HAR without entries.
+func NewHAR() *HAR {
+	return &HAR{
+		Log: HARLog{
+			Version: "1.2",
+			Creator: HARCreator{Name: "gopacket", Version: "1.0"},
+			Entries: []HAREntry{},
+		},
+	}
+}
+
+// ReadHAR reassembles the TCP connections of packets, and returns a HAR of
+// their HTTP transactions.  maxBodySize is the StreamFactory's
+// MaxBodySize, the number of bytes kept of each body if positive, 32 MiB
+// otherwise.
+func ReadHAR(packets <-chan gopacket.Packet, maxBodySize int) *HAR {
+	har := NewHAR()
+	f := &StreamFactory{Transaction: har.Add, MaxBodySize: maxBodySize}
+	a := reassembly.NewAssembler(reassembly.NewStreamPool(f))
+	for p := range packets {
+		tcp, ok := p.TransportLayer().(*layers.TCP)
+		if !ok || p.NetworkLayer() == nil {
+			continue
+		}
+		ci := p.Metadata().CaptureInfo
+		a.AssembleWithContext(p.NetworkLayer().NetworkFlow(), tcp, (*captureContext)(&ci))
+	}
+	a.FlushAll()
+	return har
+}
+
+type captureContext gopacket.CaptureInfo
+
+func (c *captureContext) GetCaptureInfo() gopacket.CaptureInfo {
+	return gopacket.CaptureInfo(*c)
+}
+
+// Add adds an entry for a transaction of a stream.  Transactions whose
+// request wasn't seen are left out.  The first entry of the HAR of a stream
+// whose handshake was seen starts with the SYN, and has the connect time.
+//
+// Add can be a StreamFactory's Transaction function.  It isn't safe for
+// concurrent use.
+func (h *HAR) Add(s *Stream, t *Transaction) {
+	req, resp := t.Request, t.Response
+	if req == nil {
+		return
+	}
+
+	// The server is the destination of the request.
+	netFlow, tcpFlow := s.Net, s.Transport
+	if req.Dir == reassembly.TCPDirServerToClient {
+		netFlow, tcpFlow = netFlow.Reverse(), tcpFlow.Reverse()
+	}
+	server := net.JoinHostPort(netFlow.Dst().String(), tcpFlow.Dst().String())
+
+	e := HAREntry{
+		StartedDateTime: req.Start,
+		Request:         harRequest(req, server),
+		Response:        harResponse(resp),
+		Timings:         HARTimings{Blocked: -1, DNS: -1, Connect: -1, SSL: -1},
+		ServerIPAddress: netFlow.Dst().String(),
+		Connection:      tcpFlow.Src().String(),
+	}
+	if !h.streams[s] && !s.Opened.IsZero() && !s.Established.IsZero() {
+		e.StartedDateTime = s.Opened
+		e.Timings.Connect = harDuration(s.Established.Sub(s.Opened))
+		e.Timings.Blocked = harDuration(req.Start.Sub(s.Established))
+	}
+	if h.streams == nil {
+		h.streams = make(map[*Stream]bool)
+	}
+	h.streams[s] = true
+	e.Timings.Send = harDuration(req.End.Sub(req.Start))
+	if resp != nil {
+		e.Timings.Wait = harDuration(resp.Start.Sub(req.End))
+		e.Timings.Receive = harDuration(resp.End.Sub(resp.Start))
+	}
+	for _, d := range []float64{e.Timings.Blocked, e.Timings.Connect, e.Timings.Send, e.Timings.Wait, e.Timings.Receive} {
+		if d > 0 {
+			e.Time += d
+		}
+	}
+
+	entries := h.Log.Entries
+	i := sort.Search(len(entries), func(i int) bool {
+		return entries[i].StartedDateTime.After(e.StartedDateTime)
+	})
+	entries = append(entries, HAREntry{})
+	copy(entries[i+1:], entries[i:])
+	entries[i] = e
+	h.Log.Entries = entries
+}
+
+// harDuration returns d in milliseconds, or 0 if it is negative.
+func harDuration(d time.Duration) float64 {
+	if d < 0 {
+		return 0
+	}
+	return float64(d) / float64(time.Millisecond)
+}
+
+func harRequest(m *Message, server string) HARRequest {
+	head := m.Head
+	r := HARRequest{
+		Method:      head.Method,
+		URL:         harURL(head, server),
+		HTTPVersion: head.Version,
+		Cookies:     []HARCookie{},
+		Headers:     harHeaders(head),
+		QueryString: []HARNameValue{},
+		HeadersSize: m.HeaderSize,
+		BodySize:    m.BodySize,
+	}
+	for _, c := range (&http.Request{Header: http.Header{"Cookie": head.Values("Cookie")}}).Cookies() {
+		r.Cookies = append(r.Cookies, harCookie(c))
+	}
+	if i := strings.IndexByte(head.RequestURI, '?'); i >= 0 {
+		for _, kv := range strings.Split(head.RequestURI[i+1:], "&") {
+			if kv == "" {
+				continue
+			}
+			r.QueryString = append(r.QueryString, harParam(kv))
+		}
+	}
+	if m.BodySize > 0 {
+		r.PostData = &HARPostData{
+			MimeType: head.Header("Content-Type"),
+			Params:   []HARNameValue{},
+		}
+		// Unlike response content, postData has no encoding to base64
+		// encode binary bodies with, so those are left out.
+		if utf8.Valid(m.Body) {
+			r.PostData.Text = string(m.Body)
+		}
+		if strings.HasPrefix(r.PostData.MimeType, "application/x-www-form-urlencoded") {
+			for _, kv := range strings.Split(string(m.Body), "&") {
+				r.PostData.Params = append(r.PostData.Params, harParam(kv))
+			}
+		}
+	}
+	return r
+}
+
+// harURL returns the absolute URL of a request.
+func harURL(head *layers.HTTP, server string) string {
+	uri := head.RequestURI
+	if strings.Contains(uri, "://") {
+		// The absolute form sent to proxies.
+		return uri
+	}
+	host := head.Header("Host")
+	if host == "" {
+		host = server
+	}
+	switch {
+	case strings.HasPrefix(uri, "/"):
+		return "http://" + host + uri
+	case uri == "*":
+		return "http://" + host
+	}
+	// The authority form of CONNECT.
+	return "http://" + uri
+}
+
+// harParam splits a query parameter.
+func harParam(kv string) HARNameValue {
+	k, v := kv, ""
+	if i := strings.IndexByte(kv, '='); i >= 0 {
+		k, v = kv[:i], kv[i+1:]
+	}
+	if u, err := url.QueryUnescape(k); err == nil {
+		k = u
+	}
+	if u, err := url.QueryUnescape(v); err == nil {
+		v = u
+	}
+	return HARNameValue{Name: k, Value: v}
+}
+
+func harHeaders(head *layers.HTTP) []HARNameValue {
+	headers := []HARNameValue{}
+	for _, f := range head.Headers {
+		headers = append(headers, HARNameValue{Name: f.Name, Value: f.Value})
+	}
+	return headers
+}
+
+func harCookie(c *http.Cookie) HARCookie {
+	hc := HARCookie{
+		Name:     c.Name,
+		Value:    c.Value,
+		Path:     c.Path,
+		Domain:   c.Domain,
+		HTTPOnly: c.HttpOnly,
+		Secure:   c.Secure,
+	}
+	if !c.Expires.IsZero() {
+		expires := c.Expires
+		hc.Expires = &expires
+	}
+	return hc
+}
+
+func harResponse(m *Message) HARResponse {
+	if m == nil {
+		return HARResponse{
+			Cookies:     []HARCookie{},
+			Headers:     []HARNameValue{},
+			HeadersSize: -1,
+			BodySize:    -1,
+		}
+	}
+	head := m.Head
+	r := HARResponse{
+		Status:      head.StatusCode,
+		StatusText:  head.Reason,
+		HTTPVersion: head.Version,
+		Cookies:     []HARCookie{},
+		Headers:     harHeaders(head),
+		Content: HARContent{
+			Size:     len(m.Body),
+			MimeType: head.Header("Content-Type"),
+		},
+		RedirectURL: head.Header("Location"),
+		HeadersSize: m.HeaderSize,
+		BodySize:    m.BodySize,
+	}
+	for _, c := range (&http.Response{Header: http.Header{"Set-Cookie": head.Values("Set-Cookie")}}).Cookies() {
+		r.Cookies = append(r.Cookies, harCookie(c))
+	}
+	if head.Header("Content-Encoding") != "" && m.BodyError == nil {
+		r.Content.Compression = m.BodySize - len(m.Body)
+	}
+	if utf8.Valid(m.Body) {
+		r.Content.Text = string(m.Body)
+	} else {
+		r.Content.Text = base64.StdEncoding.EncodeToString(m.Body)
+		r.Content.Encoding = "base64"
+	}
+	return r
+}
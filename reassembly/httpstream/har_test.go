@@ -0,0 +1,227 @@
+// Copyright 2019 The GoPacket Authors. All rights reserved.
+//
+// Use of this source code is governed by a BSD-style license
+// that can be found in the LICENSE file in the root of the source
+// tree.
+
+package httpstream
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"net"
+	"reflect"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+	"github.com/google/gopacket/reassembly"
+)
+
+// testCapture builds the packets of a connection from 10.0.0.1:40000 to
+// 10.0.0.2:80, a second apart.
+type testCapture struct {
+	t       *testing.T
+	now     time.Time
+	seq     [2]uint32
+	packets []gopacket.Packet
+}
+
+func (c *testCapture) send(dir reassembly.TCPFlowDirection, tcp *layers.TCP, payload string) {
+	ip := &layers.IPv4{
+		Version:  4,
+		TTL:      64,
+		Protocol: layers.IPProtocolTCP,
+		SrcIP:    net.IP{10, 0, 0, 1},
+		DstIP:    net.IP{10, 0, 0, 2},
+	}
+	i := 0
+	tcp.SrcPort, tcp.DstPort = 40000, 80
+	if dir == s2c {
+		i = 1
+		ip.SrcIP, ip.DstIP = ip.DstIP, ip.SrcIP
+		tcp.SrcPort, tcp.DstPort = 80, 40000
+	}
+	tcp.Seq = c.seq[i]
+	tcp.ACK = !tcp.SYN || i == 1
+	tcp.Ack = c.seq[1-i]
+	tcp.Window = 65535
+	c.seq[i] += uint32(len(payload))
+	if tcp.SYN || tcp.FIN {
+		c.seq[i]++
+	}
+	tcp.SetNetworkLayerForChecksum(ip)
+	buf := gopacket.NewSerializeBuffer()
+	err := gopacket.SerializeLayers(buf, gopacket.SerializeOptions{FixLengths: true, ComputeChecksums: true},
+		&layers.Ethernet{
+			SrcMAC:       net.HardwareAddr{0, 1, 2, 3, 4, 5},
+			DstMAC:       net.HardwareAddr{0, 1, 2, 3, 4, 6},
+			EthernetType: layers.EthernetTypeIPv4,
+		},
+		ip, tcp, gopacket.Payload(payload))
+	if err != nil {
+		c.t.Fatal(err)
+	}
+	c.now = c.now.Add(time.Second)
+	p := gopacket.NewPacket(buf.Bytes(), layers.LinkTypeEthernet, gopacket.Default)
+	p.Metadata().CaptureInfo = gopacket.CaptureInfo{Timestamp: c.now, CaptureLength: len(buf.Bytes()), Length: len(buf.Bytes())}
+	c.packets = append(c.packets, p)
+}
+
+func (c *testCapture) har(maxBodySize int) *HAR {
+	packets := make(chan gopacket.Packet, len(c.packets))
+	for _, p := range c.packets {
+		packets <- p
+	}
+	close(packets)
+	return ReadHAR(packets, maxBodySize)
+}
+
+func TestHAR(t *testing.T) {
+	var gz bytes.Buffer
+	w := gzip.NewWriter(&gz)
+	w.Write(bytes.Repeat([]byte("<p>hello</p>"), 50))
+	w.Close()
+
+	c := &testCapture{t: t, now: testTime, seq: [2]uint32{1000, 5000}}
+	c.send(c2s, &layers.TCP{SYN: true}, "")                                // 1s
+	c.send(s2c, &layers.TCP{SYN: true}, "")                                // 2s
+	c.send(c2s, &layers.TCP{}, "")                                         // 3s
+	c.send(c2s, &layers.TCP{}, "GET /index.html?a=1&b=x%20y HTTP/1.1\r\n") // 4s
+	c.send(c2s, &layers.TCP{}, "Host: example.com\r\nCookie: s=1; t=2\r\n\r\n")
+	c.send(s2c, &layers.TCP{}, "HTTP/1.1 200 OK\r\nContent-Type: text/html\r\nContent-Encoding: gzip\r\n"+
+		"Set-Cookie: u=3; Path=/; HttpOnly\r\nContent-Length: "+strconv.Itoa(gz.Len())+"\r\n\r\n") // 6s
+	c.send(s2c, &layers.TCP{}, gz.String()) // 7s
+	c.send(c2s, &layers.TCP{}, "POST /form HTTP/1.1\r\nHost: example.com\r\n"+
+		"Content-Type: application/x-www-form-urlencoded\r\nContent-Length: 7\r\n\r\nq=a+b&r") // 8s
+	c.send(s2c, &layers.TCP{}, "HTTP/1.1 302 Found\r\nLocation: /done\r\nContent-Length: 2\r\n\r\n\xff\xfe") // 9s
+	c.send(c2s, &layers.TCP{}, "GET /lost HTTP/1.1\r\n\r\n")                                                 // 10s
+	c.send(c2s, &layers.TCP{FIN: true}, "")
+	c.send(s2c, &layers.TCP{FIN: true}, "")
+	har := c.har(1 << 20)
+
+	if len(har.Log.Entries) != 3 {
+		t.Fatalf("%d entries", len(har.Log.Entries))
+	}
+	at := func(s int) time.Time { return testTime.Add(time.Duration(s) * time.Second) }
+
+	e := har.Log.Entries[0]
+	if !e.StartedDateTime.Equal(at(1)) {
+		t.Errorf("started %v", e.StartedDateTime)
+	}
+	if want := (HARTimings{Blocked: 1000, DNS: -1, Connect: 2000, Send: 1000, Wait: 1000, Receive: 1000, SSL: -1}); e.Timings != want {
+		t.Errorf("timings %+v, want %+v", e.Timings, want)
+	}
+	if e.Time != 6000 {
+		t.Errorf("time %v", e.Time)
+	}
+	if e.ServerIPAddress != "10.0.0.2" || e.Connection != "40000" {
+		t.Errorf("server %s, connection %s", e.ServerIPAddress, e.Connection)
+	}
+	req := e.Request
+	if req.Method != "GET" || req.URL != "http://example.com/index.html?a=1&b=x%20y" || req.HTTPVersion != "HTTP/1.1" {
+		t.Errorf("request %s %s %s", req.Method, req.URL, req.HTTPVersion)
+	}
+	if want := []HARNameValue{{"a", "1"}, {"b", "x y"}}; !reflect.DeepEqual(req.QueryString, want) {
+		t.Errorf("query string %v", req.QueryString)
+	}
+	if want := []HARCookie{{Name: "s", Value: "1"}, {Name: "t", Value: "2"}}; !reflect.DeepEqual(req.Cookies, want) {
+		t.Errorf("request cookies %v", req.Cookies)
+	}
+	if req.PostData != nil || req.HeadersSize != 77 || req.BodySize != 0 {
+		t.Errorf("request %+v", req)
+	}
+	resp := e.Response
+	if resp.Status != 200 || resp.StatusText != "OK" || resp.BodySize != gz.Len() {
+		t.Errorf("response %+v", resp)
+	}
+	if want := (HARContent{Size: 600, Compression: gz.Len() - 600, MimeType: "text/html", Text: string(bytes.Repeat([]byte("<p>hello</p>"), 50))}); resp.Content != want {
+		t.Errorf("content %+v", resp.Content)
+	}
+	if want := []HARCookie{{Name: "u", Value: "3", Path: "/", HTTPOnly: true}}; !reflect.DeepEqual(resp.Cookies, want) {
+		t.Errorf("response cookies %v", resp.Cookies)
+	}
+
+	e = har.Log.Entries[1]
+	if want := (HARTimings{Blocked: -1, DNS: -1, Connect: -1, Send: 0, Wait: 1000, Receive: 0, SSL: -1}); e.Timings != want || !e.StartedDateTime.Equal(at(8)) {
+		t.Errorf("second entry started %v, timings %+v", e.StartedDateTime, e.Timings)
+	}
+	if pd := e.Request.PostData; pd == nil || pd.Text != "q=a+b&r" || !reflect.DeepEqual(pd.Params, []HARNameValue{{"q", "a b"}, {"r", ""}}) {
+		t.Errorf("post data %+v", pd)
+	}
+	if e.Response.RedirectURL != "/done" || e.Response.Content.Encoding != "base64" || e.Response.Content.Text != "//4=" {
+		t.Errorf("second response %+v", e.Response)
+	}
+
+	// A request without a response.
+	if e = har.Log.Entries[2]; e.Request.URL != "http://10.0.0.2:80/lost" || e.Response.Status != 0 {
+		t.Errorf("third entry %+v", e)
+	}
+
+	b, err := json.Marshal(har)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var decoded map[string]map[string]interface{}
+	if err := json.Unmarshal(b, &decoded); err != nil {
+		t.Fatal(err)
+	}
+	if decoded["log"]["version"] != "1.2" || len(decoded["log"]["entries"].([]interface{})) != 3 {
+		t.Errorf("JSON %s", b)
+	}
+}
+
+func TestHARMaxBodySize(t *testing.T) {
+	c := &testCapture{t: t, now: testTime, seq: [2]uint32{1000, 5000}}
+	c.send(c2s, &layers.TCP{}, "GET / HTTP/1.1\r\n\r\n")
+	c.send(s2c, &layers.TCP{}, "HTTP/1.1 200 OK\r\nContent-Type: text/plain\r\nContent-Length: 10\r\n\r\n0123456789")
+	c.send(c2s, &layers.TCP{FIN: true}, "")
+	har := c.har(4)
+	if len(har.Log.Entries) != 1 {
+		t.Fatalf("%d entries", len(har.Log.Entries))
+	}
+	if resp := har.Log.Entries[0].Response; resp.Content.Text != "0123" || resp.BodySize != 10 {
+		t.Errorf("response %+v", resp)
+	}
+}
+
+func TestHARAdd(t *testing.T) {
+	c := &testCapture{t: t, now: testTime, seq: [2]uint32{1000, 5000}}
+	c.send(c2s, &layers.TCP{SYN: true}, "")
+	c.send(s2c, &layers.TCP{SYN: true}, "")
+	c.send(c2s, &layers.TCP{}, "")
+	c.send(c2s, &layers.TCP{}, "POST /upload HTTP/1.1\r\nContent-Type: application/octet-stream\r\nContent-Length: 3\r\n\r\n\xff\x00\xfe")
+	c.send(s2c, &layers.TCP{}, "HTTP/1.1 204 No Content\r\n\r\n")
+	c.send(c2s, &layers.TCP{FIN: true}, "")
+	c.send(s2c, &layers.TCP{FIN: true}, "")
+
+	// Each HAR has the connect time of the stream's first entry.
+	hars := []*HAR{NewHAR(), NewHAR()}
+	f := &StreamFactory{Transaction: func(s *Stream, t *Transaction) {
+		for _, h := range hars {
+			h.Add(s, t)
+		}
+	}}
+	a := reassembly.NewAssembler(reassembly.NewStreamPool(f))
+	for _, p := range c.packets {
+		ci := p.Metadata().CaptureInfo
+		a.AssembleWithContext(p.NetworkLayer().NetworkFlow(), p.TransportLayer().(*layers.TCP), (*captureContext)(&ci))
+	}
+	a.FlushAll()
+	for i, h := range hars {
+		if len(h.Log.Entries) != 1 {
+			t.Fatalf("HAR %d: %d entries", i, len(h.Log.Entries))
+		}
+		e := h.Log.Entries[0]
+		if e.Timings.Connect != 2000 {
+			t.Errorf("HAR %d: timings %+v", i, e.Timings)
+		}
+		// A binary body has no text.
+		if pd := e.Request.PostData; pd == nil || pd.Text != "" || pd.MimeType != "application/octet-stream" || e.Request.BodySize != 3 {
+			t.Errorf("HAR %d: post data %+v", i, pd)
+		}
+	}
+}
@@ -0,0 +1,133 @@
+// Copyright 2019 The GoPacket Authors. All rights reserved.
+//
+// Use of this source code is governed by a BSD-style license
+// that can be found in the LICENSE file in the root of the source
+// tree.
+
+// Package httpstream parses the HTTP/1.0 and HTTP/1.1 messages of TCP
+// connections reassembled by the reassembly package, and pairs requests
+// with their responses.
+//
+// It removes chunked transfer coding and gzip or deflate content coding
+// from bodies, follows pipelined requests, 100 Continue responses and
+// connections which become tunnels with CONNECT or an upgrade, and picks
+// up connections whose start wasn't captured.  A StreamFactory plugs into
+// a reassembly.StreamPool:
+//
+//	factory := &httpstream.StreamFactory{
+//		Transaction: func(s *httpstream.Stream, t *httpstream.Transaction) {
+//			if t.Request != nil && t.Response != nil {
+//				fmt.Println(t.Request.Head.Method, t.Request.Head.RequestURI, t.Response.Head.StatusCode)
+//			}
+//		},
+//	}
+//	assembler := reassembly.NewAssembler(reassembly.NewStreamPool(factory))
+//
+// A Parser can also be fed stream data directly, and ReadHAR exports the
+// transactions of a capture as an HTTP Archive.
+package httpstream
+
+import (
+	"time"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+	"github.com/google/gopacket/reassembly"
+)
+
+// StreamFactory is a reassembly.StreamFactory which creates a Stream for
+// each connection.
+type StreamFactory struct {
+	// Transaction is called with each transaction of each stream.
+	Transaction func(s *Stream, t *Transaction)
+	// Tunnel, if set, is called with the data of each direction of streams
+	// which have become tunnels.
+	Tunnel func(s *Stream, dir reassembly.TCPFlowDirection, data []byte)
+	// Error, if set, is called with the malformed messages of each stream,
+	// which are skipped.
+	Error func(s *Stream, dir reassembly.TCPFlowDirection, err error)
+	// MaxBodySize, if positive, is the number of bytes kept of each body,
+	// otherwise 32 MiB are.
+	MaxBodySize int
+}
+
+// New implements reassembly.StreamFactory.
+func (f *StreamFactory) New(netFlow, tcpFlow gopacket.Flow, tcp *layers.TCP, ac reassembly.AssemblerContext) reassembly.Stream {
+	s := &Stream{
+		Net:       netFlow,
+		Transport: tcpFlow,
+		Parser:    &Parser{MaxBodySize: f.MaxBodySize},
+		factory:   f,
+	}
+	s.Parser.Transaction = func(t *Transaction) {
+		if f.Transaction != nil {
+			f.Transaction(s, t)
+		}
+	}
+	if f.Tunnel != nil {
+		s.Parser.Tunnel = func(dir reassembly.TCPFlowDirection, data []byte) {
+			f.Tunnel(s, dir, data)
+		}
+	}
+	return s
+}
+
+// Stream is a reassembly.Stream which parses an HTTP connection.
+type Stream struct {
+	// Net and Transport are the flows of the connection's first packet.
+	Net, Transport gopacket.Flow
+	Parser         *Parser
+	// Opened is the timestamp of the connection's SYN, and Established that
+	// of the acknowledgement of its SYN-ACK.  They are zero if the
+	// handshake wasn't seen.
+	Opened, Established time.Time
+
+	factory *StreamFactory
+	synDir  reassembly.TCPFlowDirection
+	synAck  bool
+}
+
+// Accept implements reassembly.Stream, accepting all packets.  Streams
+// whose SYN wasn't seen start at their first packet.
+func (s *Stream) Accept(tcp *layers.TCP, ci gopacket.CaptureInfo, dir reassembly.TCPFlowDirection, nextSeq reassembly.Sequence, start *bool, ac reassembly.AssemblerContext) bool {
+	switch {
+	case tcp.SYN && !tcp.ACK:
+		if s.Opened.IsZero() {
+			s.Opened, s.synDir = ci.Timestamp, dir
+		}
+	case tcp.SYN:
+		s.synAck = !s.Opened.IsZero() && dir != s.synDir
+	case s.synAck && tcp.ACK && dir == s.synDir && s.Established.IsZero():
+		s.Established = ci.Timestamp
+	}
+	if nextSeq == -1 && !tcp.SYN {
+		*start = true
+	}
+	return true
+}
+
+// ReassembledSG implements reassembly.Stream.
+func (s *Stream) ReassembledSG(sg reassembly.ScatterGather, ac reassembly.AssemblerContext) {
+	dir, _, _, skip := sg.Info()
+	if skip != 0 {
+		s.Parser.Skip(dir, skip)
+	}
+	length, _ := sg.Lengths()
+	if length == 0 {
+		return
+	}
+	ci := sg.CaptureInfo(0)
+	if ac != nil {
+		ci = ac.GetCaptureInfo()
+	}
+	err := s.Parser.Parse(dir, sg.Fetch(length), ci.Timestamp)
+	if err != nil && s.factory.Error != nil {
+		s.factory.Error(s, dir, err)
+	}
+}
+
+// ReassemblyComplete implements reassembly.Stream.
+func (s *Stream) ReassemblyComplete(ac reassembly.AssemblerContext) bool {
+	s.Parser.Close()
+	return true
+}
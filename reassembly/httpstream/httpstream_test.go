@@ -0,0 +1,124 @@
+// Copyright 2019 The GoPacket Authors. All rights reserved.
+//
+// Use of this source code is governed by a BSD-style license
+// that can be found in the LICENSE file in the root of the source
+// tree.
+
+package httpstream
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+	"github.com/google/gopacket/reassembly"
+)
+
+type testContext gopacket.CaptureInfo
+
+func (c *testContext) GetCaptureInfo() gopacket.CaptureInfo {
+	return gopacket.CaptureInfo(*c)
+}
+
+// testConn sends TCP segments of a connection to an assembler, a second
+// apart.
+type testConn struct {
+	a   *reassembly.Assembler
+	now time.Time
+	seq [2]uint32
+}
+
+func (c *testConn) send(dir reassembly.TCPFlowDirection, tcp layers.TCP) {
+	flow := gopacket.NewFlow(layers.EndpointIPv4, net.IP{10, 0, 0, 1}, net.IP{10, 0, 0, 2})
+	i := 0
+	tcp.SrcPort, tcp.DstPort = 40000, 80
+	if dir == s2c {
+		i = 1
+		flow = flow.Reverse()
+		tcp.SrcPort, tcp.DstPort = 80, 40000
+	}
+	tcp.Seq = c.seq[i]
+	tcp.ACK = !tcp.SYN || i == 1
+	tcp.Ack = c.seq[1-i]
+	c.seq[i] += uint32(len(tcp.Payload))
+	if tcp.SYN || tcp.FIN {
+		c.seq[i]++
+	}
+	c.now = c.now.Add(time.Second)
+	c.a.AssembleWithContext(flow, &tcp, &testContext{Timestamp: c.now})
+}
+
+func (c *testConn) data(dir reassembly.TCPFlowDirection, data string) {
+	c.send(dir, layers.TCP{BaseLayer: layers.BaseLayer{Payload: []byte(data)}})
+}
+
+func TestStream(t *testing.T) {
+	var transactions []*Transaction
+	var errs []error
+	f := &StreamFactory{
+		Transaction: func(s *Stream, tr *Transaction) {
+			if s.Net.Dst().String() != "10.0.0.2" {
+				t.Errorf("network flow %v", s.Net)
+			}
+			transactions = append(transactions, tr)
+		},
+		Error: func(s *Stream, dir reassembly.TCPFlowDirection, err error) {
+			errs = append(errs, err)
+		},
+	}
+	c := &testConn{
+		a:   reassembly.NewAssembler(reassembly.NewStreamPool(f)),
+		now: testTime,
+		seq: [2]uint32{1000, 5000},
+	}
+	c.send(c2s, layers.TCP{SYN: true})
+	c.send(s2c, layers.TCP{SYN: true})
+	c.data(c2s, "GET /a HTTP/1.1\r\n\r\n")
+	c.data(s2c, "HTTP/1.1 200 OK\r\nContent-Length: 4\r\n\r\nbo")
+	c.data(s2c, "dy")
+	c.data(c2s, "GET /b HTTP/1.1\r\n\r\n")
+	c.data(s2c, "HTTP/1.1 200 OK\r\nContent-Length: 6\r\n\r\nab")
+	// A lost segment truncates the body.
+	c.seq[1] += 2
+	c.data(s2c, "ef")
+	c.send(c2s, layers.TCP{FIN: true})
+	c.send(s2c, layers.TCP{FIN: true})
+	c.a.FlushAll()
+
+	if got, want := describe(transactions), []string{"GET /a 200 body", "GET /b 200 abef"}; len(got) != 2 || got[0] != want[0] || got[1] != want[1] {
+		t.Errorf("transactions %q, want %q", got, want)
+	}
+	if len(errs) > 0 {
+		t.Errorf("errors %v", errs)
+	}
+	if !transactions[1].Response.Truncated {
+		t.Error("response not truncated")
+	}
+	if resp := transactions[0].Response; !resp.Start.Equal(testTime.Add(4*time.Second)) || !resp.End.Equal(testTime.Add(5*time.Second)) {
+		t.Errorf("response times %v %v", resp.Start, resp.End)
+	}
+}
+
+func TestStreamMidStream(t *testing.T) {
+	var transactions []*Transaction
+	f := &StreamFactory{
+		Transaction: func(s *Stream, tr *Transaction) {
+			transactions = append(transactions, tr)
+		},
+	}
+	c := &testConn{
+		a:   reassembly.NewAssembler(reassembly.NewStreamPool(f)),
+		now: testTime,
+		seq: [2]uint32{1000, 5000},
+	}
+	// The capture starts in the middle of a response.
+	c.data(s2c, "of a body")
+	c.data(c2s, "GET /a HTTP/1.1\r\n\r\n")
+	c.data(s2c, "HTTP/1.1 200 OK\r\nContent-Length: 2\r\n\r\nok")
+	c.a.FlushAll()
+	if got := describe(transactions); len(got) != 1 || got[0] != "GET /a 200 ok" {
+		t.Errorf("transactions %q", got)
+	}
+}
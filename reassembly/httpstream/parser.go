@@ -0,0 +1,720 @@
+// Copyright 2019 The GoPacket Authors. All rights reserved.
+//
+// Use of this source code is governed by a BSD-style license
+// that can be found in the LICENSE file in the root of the source
+// tree.
+
+package httpstream
+
+import (
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"compress/zlib"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+	"github.com/google/gopacket/reassembly"
+)
+
+// maxHeadSize bounds the size of message heads.
+const maxHeadSize = 64 << 10
+
+// defaultMaxBodySize bounds the size of bodies, as sent and once their
+// content coding is removed, when the Parser has no MaxBodySize.
+const defaultMaxBodySize = 32 << 20
+
+// maxPending bounds the number of transactions waiting for a response.
+// Past it, the oldest is passed to Transaction without one, as happens
+// when only one direction of a connection is captured.
+const maxPending = 1024
+
+// Message is an HTTP request or response.
+type Message struct {
+	// Head is the start line and header fields.
+	Head *layers.HTTP
+	// Body is the body, with its transfer coding and any gzip or deflate
+	// content coding removed.
+	Body []byte
+	// BodyError is set when the content coding couldn't be removed, or
+	// the decoded content would be over the size limit with no MaxBodySize
+	// set, in which case Body holds the content as it was sent.
+	BodyError error
+	// Trailers holds the trailer fields of a chunked body.
+	Trailers []layers.HTTPHeader
+	// HeaderSize and BodySize are the sizes of the head and body as they
+	// were sent.
+	HeaderSize, BodySize int
+	// Truncated tells whether Body is incomplete, because data was lost,
+	// the connection ended early or the body was over the size limit.
+	Truncated bool
+	// Start and End are the timestamps of the data holding the first and
+	// last bytes of the message.
+	Start, End time.Time
+	// Dir is the direction the message was sent in.
+	Dir reassembly.TCPFlowDirection
+
+	complete bool
+}
+
+// Transaction is a request and its response.
+type Transaction struct {
+	// Request is nil for a response whose request wasn't seen, as happens
+	// when a capture starts in the middle of a connection.
+	Request *Message
+	// Interim holds the informational (1xx) responses, such as 100
+	// Continue, which preceded Response.
+	Interim []*Message
+	// Response is nil if the connection ended before a response, or too
+	// many later requests were waiting for theirs.
+	Response *Message
+	// Tunnel tells whether the connection became a tunnel after the
+	// transaction, following a successful CONNECT or a 101 Switching
+	// Protocols response.
+	Tunnel bool
+}
+
+func (t *Transaction) tunnel() bool {
+	return t.Request != nil && (t.Request.Head.Method == "CONNECT" || t.Request.Head.Header("Upgrade") != "")
+}
+
+// Parser parses the HTTP/1.x messages of both directions of a connection,
+// pairing requests and responses in order.  The data of both directions
+// must be given in the order it was sent, as reassembly does.  A Parser
+// needn't see the start of a connection: it skips data up to the first
+// start line.
+type Parser struct {
+	// Transaction is called with each transaction once its request and
+	// response are complete, or once the connection ends.
+	Transaction func(t *Transaction)
+	// Tunnel, if set, is called with the data of each direction once the
+	// connection has become a tunnel.
+	Tunnel func(dir reassembly.TCPFlowDirection, data []byte)
+	// MaxBodySize, if positive, is the number of bytes kept of each body,
+	// before and after removing its content coding.  Otherwise bodies are
+	// limited to 32 MiB.
+	MaxBodySize int
+
+	halves [2]half
+	// pending holds the transactions waiting for a response, in order.
+	pending []*Transaction
+	tunnel  bool
+	closed  bool
+}
+
+type role int
+
+const (
+	roleUnknown role = iota
+	roleRequests
+	roleResponses
+)
+
+type bodyMode int
+
+const (
+	bodyLength bodyMode = iota
+	bodyChunked
+	bodyClose
+)
+
+type chunkState int
+
+const (
+	chunkSize chunkState = iota
+	chunkData
+	chunkEnd
+	chunkTrailers
+)
+
+// half is the state of one direction.
+type half struct {
+	dir  reassembly.TCPFlowDirection
+	role role
+	buf  []byte
+	// ts is the timestamp of the latest data, and bufStart that of the
+	// first byte of buf, which came before the latest data if it is at
+	// least old bytes in.
+	ts, bufStart time.Time
+	old          int
+	// synced tells whether a message was parsed since the start of the
+	// direction or since data was lost, and resync whether a start line
+	// is being looked for.
+	synced, resync bool
+
+	// msg is the message whose body is being read, and tx its transaction.
+	msg       *Message
+	tx        *Transaction
+	body      []byte
+	mode      bodyMode
+	remaining int64
+	chunk     chunkState
+
+	// hold is set after a request which may make a tunnel, until its
+	// response is known.
+	hold   *Transaction
+	tunnel bool
+}
+
+func (p *Parser) half(dir reassembly.TCPFlowDirection) *half {
+	i := 0
+	if dir == reassembly.TCPDirServerToClient {
+		i = 1
+	}
+	h := &p.halves[i]
+	h.dir = dir
+	return h
+}
+
+func (p *Parser) other(h *half) *half {
+	if h == &p.halves[0] {
+		return &p.halves[1]
+	}
+	return &p.halves[0]
+}
+
+// consume drops n bytes from the start of buf.
+func (h *half) consume(n int) {
+	h.buf = h.buf[n:]
+	if n >= h.old {
+		h.bufStart = h.ts
+	}
+	h.old -= n
+	if h.old < 0 {
+		h.old = 0
+	}
+	if len(h.buf) == 0 {
+		h.buf = nil
+	}
+}
+
+// Parse takes the next data of a direction, with the timestamp of the
+// packet it arrived in.  It returns the first error of malformed messages,
+// which are skipped.
+func (p *Parser) Parse(dir reassembly.TCPFlowDirection, data []byte, ts time.Time) error {
+	h := p.half(dir)
+	if !h.synced && len(h.buf) > 0 {
+		// Out of sync, data which starts a message is more likely to follow
+		// a message than the partial line before it.
+		if i := bytes.IndexByte(data, '\n'); i >= 0 {
+			if _, ok := p.startLine(h, data[:i+1]); ok {
+				h.buf = nil
+			}
+		}
+	}
+	if len(h.buf) == 0 {
+		h.bufStart = ts
+	}
+	h.ts = ts
+	h.old = len(h.buf)
+	h.buf = append(h.buf, data...)
+	return p.run(h)
+}
+
+func (p *Parser) run(h *half) error {
+	var first error
+	for {
+		progress, err := p.step(h)
+		if err != nil && first == nil {
+			first = err
+		}
+		if !progress {
+			return first
+		}
+	}
+}
+
+// Skip tells the Parser that n bytes of a direction were lost, or an
+// unknown amount if n is negative.  The body being read is marked as
+// truncated.  Lost data which can't be skipped over makes the Parser look
+// for the next message.
+func (p *Parser) Skip(dir reassembly.TCPFlowDirection, n int) {
+	h := p.half(dir)
+	if h.msg != nil {
+		h.msg.Truncated = true
+		if n > 0 && len(h.buf) == 0 {
+			switch {
+			case h.mode == bodyClose:
+				h.msg.BodySize += n
+				return
+			case h.mode == bodyLength && int64(n) < h.remaining:
+				h.msg.BodySize += n
+				h.remaining -= int64(n)
+				return
+			}
+		}
+		p.finish(h)
+	}
+	if !h.tunnel {
+		h.buf = nil
+		h.synced = false
+		h.resync = true
+	}
+}
+
+// Close tells the Parser that the connection ended.  Bodies read until
+// the end of the connection are complete, others are truncated, and all
+// remaining transactions are passed to Transaction.
+func (p *Parser) Close() {
+	if p.closed {
+		return
+	}
+	p.closed = true
+	for i := range p.halves {
+		h := &p.halves[i]
+		if h.msg != nil {
+			if h.mode != bodyClose {
+				h.msg.Truncated = true
+			}
+			p.finish(h)
+		}
+	}
+	for _, t := range p.pending {
+		p.emit(t)
+	}
+	p.pending = nil
+}
+
+func (p *Parser) step(h *half) (bool, error) {
+	switch {
+	case h.msg != nil:
+		return p.readBody(h)
+	case p.tunnel && h.hold == nil:
+		h.tunnel = true
+		if len(h.buf) > 0 && p.Tunnel != nil {
+			p.Tunnel(h.dir, h.buf)
+		}
+		h.consume(len(h.buf))
+		return false, nil
+	case h.hold != nil:
+		return false, nil
+	}
+	// Empty lines may precede a message.
+	for len(h.buf) > 0 && (h.buf[0] == '\r' || h.buf[0] == '\n') {
+		h.consume(1)
+	}
+	if len(h.buf) == 0 {
+		return false, nil
+	}
+	if h.resync && !p.findStart(h) {
+		return false, nil
+	}
+
+	i := bytes.IndexByte(h.buf, '\n')
+	if i < 0 {
+		if len(h.buf) > maxHeadSize {
+			return p.fail(h, errors.New("HTTP start line too long"))
+		}
+		return false, nil
+	}
+	if _, ok := p.startLine(h, h.buf[:i+1]); !ok {
+		return p.fail(h, fmt.Errorf("invalid HTTP start line %q", bytes.TrimSpace(h.buf[:i])))
+	}
+	end := headEnd(h.buf)
+	if end < 0 {
+		if len(h.buf) > maxHeadSize {
+			return p.fail(h, errors.New("HTTP head too long"))
+		}
+		return false, nil
+	}
+	head := &layers.HTTP{}
+	if err := head.DecodeFromBytes(h.buf[:end], gopacket.NilDecodeFeedback); err != nil {
+		return p.fail(h, err)
+	}
+	m := &Message{Head: head, HeaderSize: end, Start: h.bufStart, End: h.ts, Dir: h.dir}
+	h.consume(end)
+	h.synced = true
+	if head.IsResponse {
+		h.role = roleResponses
+		return true, p.response(h, m)
+	}
+	h.role = roleRequests
+	return true, p.request(h, m)
+}
+
+// startLine decodes a line, ending in a line feed, which starts a message
+// of the direction.
+func (p *Parser) startLine(h *half, line []byte) (*layers.HTTP, bool) {
+	var head layers.HTTP
+	if len(bytes.TrimSpace(line)) == 0 || head.DecodeFromBytes(line, gopacket.NilDecodeFeedback) != nil {
+		return nil, false
+	}
+	r := h.role
+	if r == roleUnknown {
+		// The other direction may tell.
+		switch p.other(h).role {
+		case roleRequests:
+			r = roleResponses
+		case roleResponses:
+			r = roleRequests
+		}
+	}
+	if r == roleRequests && head.IsResponse || r == roleResponses && !head.IsResponse {
+		return nil, false
+	}
+	return &head, true
+}
+
+// findStart drops the data of buf up to the first start line, returning
+// whether it was found.
+func (p *Parser) findStart(h *half) bool {
+	for i := 0; i < len(h.buf); {
+		j := bytes.IndexByte(h.buf[i:], '\n')
+		if j < 0 {
+			// Keep the partial line, unless it is too long to be a start
+			// line.
+			if len(h.buf)-i > maxHeadSize {
+				i = len(h.buf)
+			}
+			h.consume(i)
+			return false
+		}
+		if _, ok := p.startLine(h, h.buf[i:i+j+1]); ok {
+			h.consume(i)
+			h.resync = false
+			return true
+		}
+		i += j + 1
+	}
+	h.consume(len(h.buf))
+	return false
+}
+
+// headEnd returns the length of the head at the start of b, which ends
+// with an empty line, or -1 if it isn't complete.
+func headEnd(b []byte) int {
+	for i := 0; i < len(b); {
+		j := bytes.IndexByte(b[i:], '\n')
+		if j < 0 {
+			return -1
+		}
+		i += j + 1
+		if i < len(b) && b[i] == '\n' {
+			return i + 1
+		}
+		if i+1 < len(b) && b[i] == '\r' && b[i+1] == '\n' {
+			return i + 2
+		}
+	}
+	return -1
+}
+
+// fail reports a malformed message, and looks for the next one.  Errors
+// before the direction is in sync are expected, and not reported.
+func (p *Parser) fail(h *half, err error) (bool, error) {
+	if h.msg != nil {
+		h.msg.Truncated = true
+		p.finish(h)
+	}
+	synced := h.synced
+	h.synced = false
+	h.resync = true
+	// Skip the line which failed.
+	if i := bytes.IndexByte(h.buf, '\n'); i >= 0 {
+		h.consume(i + 1)
+	} else {
+		h.consume(len(h.buf))
+	}
+	if !synced {
+		err = nil
+	}
+	return true, err
+}
+
+func (p *Parser) request(h *half, m *Message) error {
+	t := &Transaction{Request: m}
+	if len(p.pending) == maxPending {
+		p.emit(p.pending[0])
+		p.pending = p.pending[1:]
+	}
+	p.pending = append(p.pending, t)
+	h.msg, h.tx = m, t
+	return p.startBody(h, false)
+}
+
+func (p *Parser) response(h *half, m *Message) error {
+	var t *Transaction
+	if len(p.pending) > 0 {
+		t = p.pending[0]
+	}
+	code := m.Head.StatusCode
+	if code < 200 && code != 101 {
+		// Informational responses have no body, and precede the final
+		// response.
+		if t == nil {
+			t = &Transaction{}
+			p.pending = append(p.pending, t)
+		}
+		m.complete = true
+		t.Interim = append(t.Interim, m)
+		return nil
+	}
+	if t != nil {
+		p.pending = p.pending[1:]
+	} else {
+		t = &Transaction{}
+	}
+	t.Response = m
+	h.msg, h.tx = m, t
+
+	method := ""
+	if t.Request != nil {
+		method = t.Request.Head.Method
+	}
+	switch {
+	case code == 101 || method == "CONNECT" && code < 300:
+		t.Tunnel = true
+	case method == "HEAD" || code == 204 || code == 304:
+	default:
+		return p.startBody(h, true)
+	}
+	p.finish(h)
+	return nil
+}
+
+// startBody works out how the body of h.msg is delimited, finishing
+// messages without one.
+func (p *Parser) startBody(h *half, response bool) error {
+	head := h.msg.Head
+	h.body = nil
+	switch te := head.Values("Transfer-Encoding"); {
+	case len(te) > 0:
+		codings := strings.Split(te[len(te)-1], ",")
+		if strings.EqualFold(strings.TrimSpace(codings[len(codings)-1]), "chunked") {
+			h.mode, h.chunk = bodyChunked, chunkSize
+			return nil
+		}
+		// A body without chunked as the final coding ends with the
+		// connection.
+		h.mode = bodyClose
+		if !response {
+			p.finish(h)
+			return errors.New("HTTP request with unknown body length")
+		}
+		return nil
+	case head.Header("Content-Length") != "":
+		n, err := strconv.ParseInt(head.Header("Content-Length"), 10, 64)
+		if err != nil || n < 0 {
+			h.msg.Truncated = true
+			p.finish(h)
+			return fmt.Errorf("invalid HTTP Content-Length %q", head.Header("Content-Length"))
+		}
+		h.mode, h.remaining = bodyLength, n
+		if n == 0 {
+			p.finish(h)
+		}
+		return nil
+	case response:
+		h.mode = bodyClose
+		return nil
+	}
+	p.finish(h)
+	return nil
+}
+
+func (p *Parser) readBody(h *half) (bool, error) {
+	m := h.msg
+	switch h.mode {
+	case bodyClose:
+		n := len(h.buf)
+		p.appendBody(h, h.buf)
+		h.consume(n)
+		return false, nil
+	case bodyLength:
+		if p.skipContinue(h) {
+			return true, nil
+		}
+		n := len(h.buf)
+		if int64(n) > h.remaining {
+			n = int(h.remaining)
+		}
+		p.appendBody(h, h.buf[:n])
+		h.consume(n)
+		h.remaining -= int64(n)
+		if h.remaining == 0 {
+			p.finish(h)
+			return true, nil
+		}
+		return false, nil
+	}
+
+	// Chunked
+	if h.chunk == chunkData {
+		n := len(h.buf)
+		if int64(n) > h.remaining {
+			n = int(h.remaining)
+		}
+		p.appendBody(h, h.buf[:n])
+		h.consume(n)
+		h.remaining -= int64(n)
+		if h.remaining > 0 {
+			return false, nil
+		}
+		h.chunk = chunkEnd
+		return true, nil
+	}
+	if h.chunk == chunkSize && p.skipContinue(h) {
+		return true, nil
+	}
+	i := bytes.IndexByte(h.buf, '\n')
+	if i < 0 {
+		if len(h.buf) > maxHeadSize {
+			return p.fail(h, errors.New("HTTP chunk line too long"))
+		}
+		return false, nil
+	}
+	line := bytes.TrimRight(h.buf[:i], "\r")
+	m.BodySize += i + 1
+	h.consume(i + 1)
+	switch h.chunk {
+	case chunkSize:
+		if j := bytes.IndexByte(line, ';'); j >= 0 {
+			line = line[:j]
+		}
+		n, err := strconv.ParseInt(string(bytes.TrimSpace(line)), 16, 64)
+		if err != nil || n < 0 {
+			return p.fail(h, fmt.Errorf("invalid HTTP chunk size %q", line))
+		}
+		h.remaining = n
+		h.chunk = chunkData
+		if n == 0 {
+			h.chunk = chunkTrailers
+		}
+	case chunkEnd:
+		if len(line) != 0 {
+			return p.fail(h, errors.New("HTTP chunk not followed by CRLF"))
+		}
+		h.chunk = chunkSize
+	case chunkTrailers:
+		if len(line) == 0 {
+			p.finish(h)
+			return true, nil
+		}
+		colon := bytes.IndexByte(line, ':')
+		if colon <= 0 {
+			return p.fail(h, fmt.Errorf("invalid HTTP trailer line %q", line))
+		}
+		m.Trailers = append(m.Trailers, layers.HTTPHeader{
+			Name:  string(line[:colon]),
+			Value: string(bytes.TrimSpace(line[colon+1:])),
+		})
+	}
+	return true, nil
+}
+
+// skipContinue handles a request with "Expect: 100-continue" which was
+// answered without 100 Continue: its client may then not send the body,
+// and go on with the next request.
+func (p *Parser) skipContinue(h *half) bool {
+	m, t := h.msg, h.tx
+	if m.Head.IsResponse || m.BodySize != 0 || t.Response == nil || len(t.Interim) > 0 ||
+		!m.Head.HasToken("Expect", "100-continue") {
+		return false
+	}
+	i := bytes.IndexByte(h.buf, '\n')
+	if i < 0 {
+		return false
+	}
+	if _, ok := p.startLine(h, h.buf[:i+1]); !ok {
+		return false
+	}
+	p.finish(h)
+	return true
+}
+
+func (p *Parser) appendBody(h *half, b []byte) {
+	m := h.msg
+	m.BodySize += len(b)
+	if limit := p.maxBodySize(); len(h.body)+len(b) > limit {
+		b = b[:limit-len(h.body)]
+		m.Truncated = true
+	}
+	h.body = append(h.body, b...)
+}
+
+func (p *Parser) maxBodySize() int {
+	if p.MaxBodySize > 0 {
+		return p.MaxBodySize
+	}
+	return defaultMaxBodySize
+}
+
+// finish completes h.msg.
+func (p *Parser) finish(h *half) {
+	m, t := h.msg, h.tx
+	h.msg, h.tx = nil, nil
+	m.End = h.ts
+	m.complete = true
+	m.Body = h.body
+	h.body = nil
+	p.decodeContent(m)
+
+	if !m.Head.IsResponse {
+		if t.tunnel() && t.Response == nil && !p.closed {
+			h.hold = t
+		}
+	} else if other := p.other(h); other.hold == t {
+		// The response tells whether the request's direction goes on
+		// with HTTP.
+		other.hold = nil
+		defer p.run(other)
+	}
+	if t.Tunnel {
+		p.tunnel = true
+	}
+	if t.Response != nil && t.Response.complete && (t.Request == nil || t.Request.complete) {
+		p.emit(t)
+	}
+}
+
+func (p *Parser) emit(t *Transaction) {
+	if p.Transaction != nil {
+		p.Transaction(t)
+	}
+}
+
+// decodeContent removes the content coding of the body.
+func (p *Parser) decodeContent(m *Message) {
+	coding := strings.ToLower(strings.TrimSpace(m.Head.Header("Content-Encoding")))
+	if coding == "" || coding == "identity" || len(m.Body) == 0 {
+		return
+	}
+	var r io.Reader
+	var err error
+	switch coding {
+	case "gzip", "x-gzip":
+		r, err = gzip.NewReader(bytes.NewReader(m.Body))
+	case "deflate":
+		// deflate should be zlib, but is sometimes raw.
+		if r, err = zlib.NewReader(bytes.NewReader(m.Body)); err != nil {
+			r, err = flate.NewReader(bytes.NewReader(m.Body)), nil
+		}
+	default:
+		m.BodyError = fmt.Errorf("unsupported content coding %q", coding)
+		return
+	}
+	if err == nil {
+		limit := p.maxBodySize()
+		var body []byte
+		if body, err = ioutil.ReadAll(io.LimitReader(r, int64(limit)+1)); err == nil {
+			if len(body) <= limit {
+				m.Body = body
+				return
+			}
+			if p.MaxBodySize > 0 {
+				m.Body = body[:limit]
+				m.Truncated = true
+				return
+			}
+			err = fmt.Errorf("decoded body over %d bytes", limit)
+		}
+	}
+	m.BodyError = fmt.Errorf("%s content coding: %v", coding, err)
+}
@@ -0,0 +1,347 @@
+// Copyright 2019 The GoPacket Authors. All rights reserved.
+//
+// Use of this source code is governed by a BSD-style license
+// that can be found in the LICENSE file in the root of the source
+// tree.
+
+package httpstream
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/gopacket/layers"
+	"github.com/google/gopacket/reassembly"
+)
+
+const (
+	c2s = reassembly.TCPDirClientToServer
+	s2c = reassembly.TCPDirServerToClient
+)
+
+var testTime = time.Date(2018, 1, 1, 0, 0, 0, 0, time.UTC)
+
+// testParser feeds a Parser with the data of each step, a second apart,
+// and returns the transactions and errors.
+type testParser struct {
+	Parser
+	t            *testing.T
+	now          time.Time
+	transactions []*Transaction
+	tunnel       [2]string
+	errs         []error
+}
+
+func newTestParser(t *testing.T) *testParser {
+	p := &testParser{t: t, now: testTime}
+	p.Transaction = func(t *Transaction) {
+		p.transactions = append(p.transactions, t)
+	}
+	p.Tunnel = func(dir reassembly.TCPFlowDirection, data []byte) {
+		i := 0
+		if dir == s2c {
+			i = 1
+		}
+		p.tunnel[i] += string(data)
+	}
+	return p
+}
+
+func (p *testParser) send(dir reassembly.TCPFlowDirection, data string) {
+	p.now = p.now.Add(time.Second)
+	if err := p.Parse(dir, []byte(data), p.now); err != nil {
+		p.errs = append(p.errs, err)
+	}
+}
+
+// describe summarizes each transaction as its request line and body,
+// interim status codes, and final status code and body.
+func describe(ts []*Transaction) []string {
+	var out []string
+	for _, t := range ts {
+		s := "-"
+		if t.Request != nil {
+			s = t.Request.Head.Method + " " + t.Request.Head.RequestURI
+			if len(t.Request.Body) > 0 {
+				s += " " + string(t.Request.Body)
+			}
+		}
+		for _, m := range t.Interim {
+			s += fmt.Sprintf(" [%d]", m.Head.StatusCode)
+		}
+		if t.Response != nil {
+			s += fmt.Sprintf(" %d %s", t.Response.Head.StatusCode, t.Response.Body)
+		} else {
+			s += " -"
+		}
+		out = append(out, s)
+	}
+	return out
+}
+
+func (p *testParser) check(want ...string) {
+	p.t.Helper()
+	if got := describe(p.transactions); !reflect.DeepEqual(got, want) {
+		p.t.Errorf("transactions:\n%q\nwant:\n%q", got, want)
+	}
+	if len(p.errs) > 0 {
+		p.t.Errorf("errors %v", p.errs)
+	}
+}
+
+func TestParserSimple(t *testing.T) {
+	p := newTestParser(t)
+	p.send(c2s, "GET /a HTTP/1.1\r\nHost: example.com\r\n\r\n")
+	p.send(s2c, "HTTP/1.1 200 OK\r\nContent-Length: 5\r\n")
+	p.send(s2c, "\r\nhel")
+	p.send(s2c, "lo")
+	p.check("GET /a 200 hello")
+
+	tr := p.transactions[0]
+	req, resp := tr.Request, tr.Response
+	if req.Head.Header("Host") != "example.com" || req.HeaderSize != 38 || req.BodySize != 0 {
+		t.Errorf("request %+v", req)
+	}
+	if resp.HeaderSize != 38 || resp.BodySize != 5 || resp.Truncated {
+		t.Errorf("response %+v", resp)
+	}
+	at := func(s int) time.Time { return testTime.Add(time.Duration(s) * time.Second) }
+	if !req.Start.Equal(at(1)) || !req.End.Equal(at(1)) || !resp.Start.Equal(at(2)) || !resp.End.Equal(at(4)) {
+		t.Errorf("times %v %v %v %v", req.Start, req.End, resp.Start, resp.End)
+	}
+}
+
+func TestParserPipelining(t *testing.T) {
+	p := newTestParser(t)
+	p.send(c2s, "GET /1 HTTP/1.1\r\n\r\n\r\nPOST /2 HTTP/1.1\r\nContent-Length: 3\r\n\r\nabcHEAD /3 HTTP/1.1\r\n\r\n")
+	responses := "HTTP/1.1 200 OK\r\nContent-Length: 1\r\n\r\n1" +
+		"HTTP/1.1 201 Created\r\nContent-Length: 1\r\n\r\n2" +
+		"HTTP/1.1 200 OK\r\nContent-Length: 100\r\n\r\n" +
+		"HTTP/1.1 404 Not Found\r\nContent-Length: 0\r\n\r\n"
+	// A byte at a time.
+	for i := range responses {
+		p.send(s2c, responses[i:i+1])
+	}
+	p.send(c2s, "GET /4 HTTP/1.1\r\n\r\n")
+	p.Close()
+	p.check("GET /1 200 1", "POST /2 abc 201 2", "HEAD /3 200 ", "- 404 ", "GET /4 -")
+}
+
+func TestParserChunkedGzip(t *testing.T) {
+	var gz bytes.Buffer
+	w := gzip.NewWriter(&gz)
+	w.Write([]byte(strings.Repeat("compressed ", 100)))
+	w.Close()
+	body := gz.Bytes()
+
+	p := newTestParser(t)
+	p.send(c2s, "GET / HTTP/1.1\r\n\r\n")
+	p.send(s2c, "HTTP/1.1 200 OK\r\nTransfer-Encoding: gzip, chunked\r\nContent-Encoding: gzip\r\n\r\n")
+	chunks := []string{
+		fmt.Sprintf("%x;ext=1\r\n%s\r\n", 10, body[:10]),
+		fmt.Sprintf("%X\r\n%s\r\n0\r\nX-Trailer: t\r\n\r\n", len(body)-10, body[10:]),
+	}
+	for _, c := range chunks {
+		p.send(s2c, c)
+	}
+	if len(p.transactions) != 1 {
+		t.Fatalf("%d transactions", len(p.transactions))
+	}
+	m := p.transactions[0].Response
+	if string(m.Body) != strings.Repeat("compressed ", 100) || m.BodyError != nil {
+		t.Errorf("body %q, %v", m.Body, m.BodyError)
+	}
+	if !reflect.DeepEqual(m.Trailers, []layers.HTTPHeader{{Name: "X-Trailer", Value: "t"}}) {
+		t.Errorf("trailers %v", m.Trailers)
+	}
+	if want := len(chunks[0]) + len(chunks[1]); m.BodySize != want {
+		t.Errorf("body size %d, want %d", m.BodySize, want)
+	}
+
+	// Unknown and corrupt content codings are kept.
+	p = newTestParser(t)
+	p.send(c2s, "GET / HTTP/1.1\r\n\r\n")
+	p.send(s2c, "HTTP/1.1 200 OK\r\nContent-Encoding: br\r\nContent-Length: 2\r\n\r\nxx")
+	p.send(c2s, "GET / HTTP/1.1\r\n\r\n")
+	p.send(s2c, "HTTP/1.1 200 OK\r\nContent-Encoding: gzip\r\nContent-Length: 2\r\n\r\nxx")
+	p.check("GET / 200 xx", "GET / 200 xx")
+	for _, tr := range p.transactions {
+		if tr.Response.BodyError == nil {
+			t.Error("no body error")
+		}
+	}
+}
+
+func TestParserDecodedSize(t *testing.T) {
+	var gz bytes.Buffer
+	w := gzip.NewWriter(&gz)
+	w.Write(make([]byte, defaultMaxBodySize+1))
+	w.Close()
+	head := fmt.Sprintf("HTTP/1.1 200 OK\r\nContent-Encoding: gzip\r\nContent-Length: %d\r\n\r\n", gz.Len())
+
+	// Without MaxBodySize, a body which decodes to too much is kept as sent.
+	p := newTestParser(t)
+	p.send(c2s, "GET / HTTP/1.1\r\n\r\n")
+	p.send(s2c, head+gz.String())
+	m := p.transactions[0].Response
+	if m.BodyError == nil || m.Truncated || !bytes.Equal(m.Body, gz.Bytes()) {
+		t.Errorf("body of %d bytes, truncated %v, error %v", len(m.Body), m.Truncated, m.BodyError)
+	}
+
+	// The MaxBodySize limit applies to decoded bodies too.
+	p = newTestParser(t)
+	p.MaxBodySize = gz.Len()
+	p.send(c2s, "GET / HTTP/1.1\r\n\r\n")
+	p.send(s2c, head+gz.String())
+	m = p.transactions[0].Response
+	if m.BodyError != nil || !m.Truncated || len(m.Body) != gz.Len() {
+		t.Errorf("body of %d bytes, truncated %v, error %v", len(m.Body), m.Truncated, m.BodyError)
+	}
+}
+
+func TestParserBodySize(t *testing.T) {
+	// Without MaxBodySize, bodies are limited too.
+	p := newTestParser(t)
+	p.send(c2s, "GET / HTTP/1.1\r\n\r\n")
+	p.send(s2c, fmt.Sprintf("HTTP/1.1 200 OK\r\nContent-Length: %d\r\n\r\n", defaultMaxBodySize+1))
+	p.send(s2c, strings.Repeat("x", defaultMaxBodySize+1))
+	m := p.transactions[0].Response
+	if !m.Truncated || len(m.Body) != defaultMaxBodySize || m.BodySize != defaultMaxBodySize+1 {
+		t.Errorf("body of %d bytes, size %d, truncated %v", len(m.Body), m.BodySize, m.Truncated)
+	}
+}
+
+func TestParserPending(t *testing.T) {
+	// Requests whose responses aren't seen don't pile up.
+	p := newTestParser(t)
+	for i := 0; i <= maxPending; i++ {
+		p.send(c2s, fmt.Sprintf("GET /%d HTTP/1.1\r\n\r\n", i))
+	}
+	if len(p.transactions) != 1 || len(p.pending) != maxPending {
+		t.Fatalf("%d transactions, %d pending", len(p.transactions), len(p.pending))
+	}
+	p.send(s2c, "HTTP/1.1 200 OK\r\nContent-Length: 0\r\n\r\n")
+	p.check("GET /0 -", "GET /1 200 ")
+}
+
+func TestParserNoBody(t *testing.T) {
+	p := newTestParser(t)
+	p.send(c2s, "GET /a HTTP/1.1\r\n\r\nGET /b HTTP/1.1\r\n\r\n")
+	p.send(s2c, "HTTP/1.1 204 No Content\r\nContent-Length: 10\r\n\r\n")
+	p.send(s2c, "HTTP/1.1 304 Not Modified\r\nTransfer-Encoding: chunked\r\n\r\n")
+	p.send(c2s, "DELETE /c HTTP/1.1\r\n\r\n")
+	p.send(s2c, "HTTP/1.0 200 OK\r\n\r\nuntil close")
+	p.Close()
+	p.check("GET /a 204 ", "GET /b 304 ", "DELETE /c 200 until close")
+	if p.transactions[2].Response.Truncated {
+		t.Error("body read until close is truncated")
+	}
+}
+
+func TestParserContinue(t *testing.T) {
+	p := newTestParser(t)
+	p.send(c2s, "PUT /a HTTP/1.1\r\nExpect: 100-continue\r\nContent-Length: 4\r\n\r\n")
+	p.send(s2c, "HTTP/1.1 100 Continue\r\n\r\n")
+	p.send(c2s, "data")
+	p.send(s2c, "HTTP/1.1 201 Created\r\nContent-Length: 0\r\n\r\n")
+
+	// Refused without 100 Continue, the client skips the body.
+	p.send(c2s, "PUT /b HTTP/1.1\r\nExpect: 100-continue\r\nContent-Length: 4\r\n\r\n")
+	p.send(s2c, "HTTP/1.1 413 Payload Too Large\r\nContent-Length: 0\r\n\r\n")
+	p.send(c2s, "GET /c HTTP/1.1\r\n\r\n")
+	p.send(s2c, "HTTP/1.1 200 OK\r\nContent-Length: 0\r\n\r\n")
+	p.check("PUT /a data [100] 201 ", "PUT /b 413 ", "GET /c 200 ")
+}
+
+func TestParserConnect(t *testing.T) {
+	p := newTestParser(t)
+	p.send(c2s, "CONNECT example.com:443 HTTP/1.1\r\n\r\n")
+	// The client needn't wait for the response.
+	p.send(c2s, "\x16\x03\x01hello")
+	p.send(s2c, "HTTP/1.1 200 Connection established\r\n\r\n\x16\x03\x03")
+	p.send(s2c, "world")
+	p.send(c2s, "GET / HTTP/1.1\r\n\r\n")
+	p.check("CONNECT example.com:443 200 ")
+	if !p.transactions[0].Tunnel {
+		t.Error("no tunnel")
+	}
+	if p.tunnel[0] != "\x16\x03\x01helloGET / HTTP/1.1\r\n\r\n" || p.tunnel[1] != "\x16\x03\x03world" {
+		t.Errorf("tunnel data %q", p.tunnel)
+	}
+}
+
+func TestParserUpgrade(t *testing.T) {
+	// An upgrade which isn't accepted goes on with HTTP.
+	p := newTestParser(t)
+	p.send(c2s, "GET /a HTTP/1.1\r\nUpgrade: websocket\r\nConnection: Upgrade\r\n\r\n")
+	p.send(c2s, "GET /b HTTP/1.1\r\n\r\n")
+	p.send(s2c, "HTTP/1.1 400 Bad Request\r\nContent-Length: 0\r\n\r\n")
+	p.send(s2c, "HTTP/1.1 200 OK\r\nContent-Length: 0\r\n\r\n")
+	p.check("GET /a 400 ", "GET /b 200 ")
+
+	p = newTestParser(t)
+	p.send(c2s, "GET /a HTTP/1.1\r\nUpgrade: websocket\r\nConnection: Upgrade\r\n\r\n")
+	p.send(s2c, "HTTP/1.1 101 Switching Protocols\r\nUpgrade: websocket\r\n\r\n\x81\x02hi")
+	p.send(c2s, "\x81\x82mask")
+	p.check("GET /a 101 ")
+	if p.tunnel[0] != "\x81\x82mask" || p.tunnel[1] != "\x81\x02hi" {
+		t.Errorf("tunnel data %q", p.tunnel)
+	}
+}
+
+func TestParserMidStream(t *testing.T) {
+	p := newTestParser(t)
+	// Both directions start in the middle of a body.
+	p.send(s2c, "end of a body\r\nHTTP/1.1 is great\r\n")
+	p.send(c2s, "rest of a request body\r\n")
+	p.send(s2c, "\r\nHTTP/1.1 200 OK\r\nContent-Length: 2\r\n\r\nok")
+	p.send(c2s, "GET / HTTP/1.1\r\n\r\n")
+	p.send(s2c, "HTTP/1.1 200 OK\r\nContent-Length: 2\r\n\r\nOK")
+	p.check("- 200 ok", "GET / 200 OK")
+}
+
+func TestParserSkip(t *testing.T) {
+	p := newTestParser(t)
+	p.MaxBodySize = 4
+	p.send(c2s, "GET / HTTP/1.1\r\n\r\n")
+	p.send(s2c, "HTTP/1.1 200 OK\r\nContent-Length: 10\r\n\r\nab")
+	// Lost data within a body is skipped over.
+	p.Skip(s2c, 3)
+	p.send(s2c, "fghij")
+	p.send(c2s, "GET / HTTP/1.1\r\n\r\n")
+	p.send(s2c, "HTTP/1.1 200 OK\r\nTransfer-Encoding: chunked\r\n\r\n4\r\nab")
+	// Otherwise the next message is looked for.
+	p.Skip(s2c, 10)
+	p.send(c2s, "GET / HTTP/1.1\r\n\r\n")
+	p.send(s2c, "xx\r\n0\r\n\r\nHTTP/1.1 500 Oops\r\nContent-Length: 0\r\n\r\n")
+	p.check("GET / 200 abfg", "GET / 200 ab", "GET / 500 ")
+	for i, tr := range p.transactions {
+		if want := i < 2; tr.Response.Truncated != want {
+			t.Errorf("transaction %d truncated %v", i, tr.Response.Truncated)
+		}
+	}
+	if size := p.transactions[0].Response.BodySize; size != 10 {
+		t.Errorf("body size %d", size)
+	}
+}
+
+func TestParserMalformed(t *testing.T) {
+	p := newTestParser(t)
+	p.send(c2s, "GET / HTTP/1.1\r\n\r\n")
+	p.send(s2c, "HTTP/1.1 200 OK\r\nTransfer-Encoding: chunked\r\n\r\nzz\r\n")
+	p.send(c2s, "GET / HTTP/1.1\r\n\r\n")
+	p.send(s2c, "HTTP/1.1 200 OK\r\nContent-Length: 0\r\n\r\n")
+	if len(p.errs) != 1 || !strings.Contains(p.errs[0].Error(), "chunk size") {
+		t.Errorf("errors %v", p.errs)
+	}
+	p.errs = nil
+	p.check("GET / 200 ", "GET / 200 ")
+	if !p.transactions[0].Response.Truncated {
+		t.Error("malformed body not truncated")
+	}
+}
@@ -0,0 +1,303 @@
+// Copyright 2019 The GoPacket Authors. All rights reserved.
+//
+// Use of this source code is governed by a BSD-style license
+// that can be found in the LICENSE file in the root of the source
+// tree.
+
+package tlsdecrypt
+
+import (
+	"crypto"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/sha1"
+	_ "crypto/sha256" // for crypto.SHA256
+	_ "crypto/sha512" // for crypto.SHA384
+	"encoding/binary"
+	"errors"
+	"hash"
+
+	"golang.org/x/crypto/chacha20poly1305"
+
+	"github.com/google/gopacket/layers"
+)
+
+// cipherKind is the record protection of a cipher suite.
+type cipherKind int
+
+const (
+	aesGCM cipherKind = iota
+	chaCha20Poly1305
+	aesCBCSHA
+)
+
+// cipherSuite describes the record protection and PRF hash of a cipher
+// suite.
+type cipherSuite struct {
+	kind   cipherKind
+	keyLen int
+	hash   crypto.Hash
+}
+
+var cipherSuites = map[layers.TLSCipherSuite]cipherSuite{
+	// TLS 1.3
+	0x1301: {aesGCM, 16, crypto.SHA256},
+	0x1302: {aesGCM, 32, crypto.SHA384},
+	0x1303: {chaCha20Poly1305, 32, crypto.SHA256},
+	// TLS 1.2 AES-GCM
+	0x009c: {aesGCM, 16, crypto.SHA256},
+	0x009d: {aesGCM, 32, crypto.SHA384},
+	0x009e: {aesGCM, 16, crypto.SHA256},
+	0x009f: {aesGCM, 32, crypto.SHA384},
+	0xc02b: {aesGCM, 16, crypto.SHA256},
+	0xc02c: {aesGCM, 32, crypto.SHA384},
+	0xc02f: {aesGCM, 16, crypto.SHA256},
+	0xc030: {aesGCM, 32, crypto.SHA384},
+	// TLS 1.2 ChaCha20-Poly1305
+	0xcca8: {chaCha20Poly1305, 32, crypto.SHA256},
+	0xcca9: {chaCha20Poly1305, 32, crypto.SHA256},
+	0xccaa: {chaCha20Poly1305, 32, crypto.SHA256},
+	// TLS 1.2 AES-CBC with HMAC-SHA1
+	0x002f: {aesCBCSHA, 16, crypto.SHA256},
+	0x0033: {aesCBCSHA, 16, crypto.SHA256},
+	0x0035: {aesCBCSHA, 32, crypto.SHA256},
+	0x0039: {aesCBCSHA, 32, crypto.SHA256},
+	0xc009: {aesCBCSHA, 16, crypto.SHA256},
+	0xc00a: {aesCBCSHA, 32, crypto.SHA256},
+	0xc013: {aesCBCSHA, 16, crypto.SHA256},
+	0xc014: {aesCBCSHA, 32, crypto.SHA256},
+}
+
+// recordCipher removes the protection of records.
+type recordCipher interface {
+	// decrypt returns the content type and plaintext of the record with the
+	// header and sequence number.
+	decrypt(header, payload []byte, seq uint64) (layers.TLSType, []byte, error)
+}
+
+var errAuth = errors.New("record authentication failed")
+
+// keys12 derives the TLS 1.2 record cipher of the client or server from the
+// master secret, with the key expansion of RFC 5246 section 6.3.
+func (s cipherSuite) keys12(master, clientRandom, serverRandom []byte, client bool) (recordCipher, error) {
+	macLen, ivLen := 0, 4
+	switch s.kind {
+	case chaCha20Poly1305:
+		ivLen = 12
+	case aesCBCSHA:
+		macLen, ivLen = sha1.Size, 16
+	}
+	seed := append(append([]byte(nil), serverRandom...), clientRandom...)
+	block := prf12(s.hash, master, "key expansion", seed, 2*(macLen+s.keyLen+ivLen))
+	clientMAC, block := block[:macLen], block[macLen:]
+	serverMAC, block := block[:macLen], block[macLen:]
+	clientKey, block := block[:s.keyLen], block[s.keyLen:]
+	serverKey, block := block[:s.keyLen], block[s.keyLen:]
+	clientIV, serverIV := block[:ivLen], block[ivLen:]
+	mac, key, iv := serverMAC, serverKey, serverIV
+	if client {
+		mac, key, iv = clientMAC, clientKey, clientIV
+	}
+
+	switch s.kind {
+	case aesGCM:
+		b, err := aes.NewCipher(key)
+		if err != nil {
+			return nil, err
+		}
+		aead, err := cipher.NewGCM(b)
+		if err != nil {
+			return nil, err
+		}
+		return &aead12{aead: aead, iv: iv, explicitNonce: true}, nil
+	case chaCha20Poly1305:
+		aead, err := chacha20poly1305.New(key)
+		if err != nil {
+			return nil, err
+		}
+		return &aead12{aead: aead, iv: iv}, nil
+	}
+	b, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return &cbc12{block: b, mac: hmac.New(sha1.New, mac)}, nil
+}
+
+// keys13 derives the TLS 1.3 record cipher of a traffic secret, as in RFC
+// 8446 section 7.3.
+func (s cipherSuite) keys13(secret []byte) (recordCipher, error) {
+	key := hkdfExpandLabel(s.hash, secret, "key", s.keyLen)
+	iv := hkdfExpandLabel(s.hash, secret, "iv", 12)
+	if s.kind == chaCha20Poly1305 {
+		aead, err := chacha20poly1305.New(key)
+		if err != nil {
+			return nil, err
+		}
+		return &aead13{aead: aead, iv: iv}, nil
+	}
+	b, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := cipher.NewGCM(b)
+	if err != nil {
+		return nil, err
+	}
+	return &aead13{aead: aead, iv: iv}, nil
+}
+
+// nextSecret13 returns the traffic secret following a KeyUpdate.
+func (s cipherSuite) nextSecret13(secret []byte) []byte {
+	return hkdfExpandLabel(s.hash, secret, "traffic upd", s.hash.Size())
+}
+
+// prf12 is the TLS 1.2 pseudorandom function P_hash.
+func prf12(h crypto.Hash, secret []byte, label string, seed []byte, n int) []byte {
+	labelSeed := append([]byte(label), seed...)
+	mac := hmac.New(h.New, secret)
+	mac.Write(labelSeed)
+	a := mac.Sum(nil)
+	var out []byte
+	for len(out) < n {
+		mac.Reset()
+		mac.Write(a)
+		mac.Write(labelSeed)
+		out = mac.Sum(out)
+		mac.Reset()
+		mac.Write(a)
+		a = mac.Sum(a[:0])
+	}
+	return out[:n]
+}
+
+// hkdfExpandLabel is HKDF-Expand-Label of RFC 8446 with an empty context.
+func hkdfExpandLabel(h crypto.Hash, secret []byte, label string, n int) []byte {
+	label = "tls13 " + label
+	info := []byte{byte(n >> 8), byte(n), byte(len(label))}
+	info = append(info, label...)
+	info = append(info, 0)
+
+	mac := hmac.New(h.New, secret)
+	var out, t []byte
+	for i := byte(1); len(out) < n; i++ {
+		mac.Reset()
+		mac.Write(t)
+		mac.Write(info)
+		mac.Write([]byte{i})
+		t = mac.Sum(nil)
+		out = append(out, t...)
+	}
+	return out[:n]
+}
+
+// aead12 removes TLS 1.2 AEAD protection.  AES-GCM sends an explicit nonce
+// with each record, which follows the implicit IV.  ChaCha20-Poly1305 XORs
+// the sequence number into the IV.
+type aead12 struct {
+	aead          cipher.AEAD
+	iv            []byte
+	explicitNonce bool
+}
+
+func (c *aead12) decrypt(header, payload []byte, seq uint64) (layers.TLSType, []byte, error) {
+	var nonce []byte
+	if c.explicitNonce {
+		if len(payload) < 8 {
+			return 0, nil, errAuth
+		}
+		nonce = append(append([]byte(nil), c.iv...), payload[:8]...)
+		payload = payload[8:]
+	} else {
+		nonce = xorNonce(c.iv, seq)
+	}
+	if len(payload) < c.aead.Overhead() {
+		return 0, nil, errAuth
+	}
+	ad := additionalData12(header, seq, len(payload)-c.aead.Overhead())
+	plaintext, err := c.aead.Open(nil, nonce, payload, ad)
+	if err != nil {
+		return 0, nil, errAuth
+	}
+	return layers.TLSType(header[0]), plaintext, nil
+}
+
+// cbc12 removes TLS 1.1 and 1.2 CBC protection, with MAC-then-encrypt and an
+// explicit IV starting each record.
+type cbc12 struct {
+	block cipher.Block
+	mac   hash.Hash
+}
+
+func (c *cbc12) decrypt(header, payload []byte, seq uint64) (layers.TLSType, []byte, error) {
+	bs := c.block.BlockSize()
+	if len(payload) < 2*bs || len(payload)%bs != 0 {
+		return 0, nil, errAuth
+	}
+	iv, payload := payload[:bs], payload[bs:]
+	plaintext := make([]byte, len(payload))
+	cipher.NewCBCDecrypter(c.block, iv).CryptBlocks(plaintext, payload)
+
+	padding := int(plaintext[len(plaintext)-1]) + 1
+	macLen := c.mac.Size()
+	if padding+macLen > len(plaintext) {
+		return 0, nil, errAuth
+	}
+	for _, b := range plaintext[len(plaintext)-padding:] {
+		if int(b) != padding-1 {
+			return 0, nil, errAuth
+		}
+	}
+	plaintext = plaintext[:len(plaintext)-padding]
+	plaintext, sum := plaintext[:len(plaintext)-macLen], plaintext[len(plaintext)-macLen:]
+
+	c.mac.Reset()
+	c.mac.Write(additionalData12(header, seq, len(plaintext)))
+	c.mac.Write(plaintext)
+	if !hmac.Equal(c.mac.Sum(nil), sum) {
+		return 0, nil, errAuth
+	}
+	return layers.TLSType(header[0]), plaintext, nil
+}
+
+// aead13 removes TLS 1.3 record protection, revealing the inner content
+// type.
+type aead13 struct {
+	aead cipher.AEAD
+	iv   []byte
+}
+
+func (c *aead13) decrypt(header, payload []byte, seq uint64) (layers.TLSType, []byte, error) {
+	plaintext, err := c.aead.Open(nil, xorNonce(c.iv, seq), payload, header)
+	if err != nil {
+		return 0, nil, errAuth
+	}
+	// Strip the padding and the content type.
+	i := len(plaintext) - 1
+	for i >= 0 && plaintext[i] == 0 {
+		i--
+	}
+	if i < 0 {
+		return 0, nil, errors.New("record without content type")
+	}
+	return layers.TLSType(plaintext[i]), plaintext[:i], nil
+}
+
+// additionalData12 is the MAC and AEAD additional data of TLS 1.2 records.
+func additionalData12(header []byte, seq uint64, length int) []byte {
+	ad := make([]byte, 13)
+	binary.BigEndian.PutUint64(ad, seq)
+	copy(ad[8:11], header[:3])
+	binary.BigEndian.PutUint16(ad[11:], uint16(length))
+	return ad
+}
+
+// xorNonce XORs the sequence number into the end of the IV.
+func xorNonce(iv []byte, seq uint64) []byte {
+	nonce := append([]byte(nil), iv...)
+	for i := 0; i < 8; i++ {
+		nonce[len(nonce)-1-i] ^= byte(seq >> (8 * uint(i)))
+	}
+	return nonce
+}
@@ -0,0 +1,125 @@
+// Copyright 2019 The GoPacket Authors. All rights reserved.
+//
+// Use of this source code is governed by a BSD-style license
+// that can be found in the LICENSE file in the root of the source
+// tree.
+
+package tlsdecrypt
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+)
+
+// Key log labels of the secrets used for decryption.
+const (
+	// LabelClientRandom labels the TLS 1.2 master secret.
+	LabelClientRandom = "CLIENT_RANDOM"
+	// The TLS 1.3 handshake and application traffic secrets.
+	LabelClientHandshakeTrafficSecret = "CLIENT_HANDSHAKE_TRAFFIC_SECRET"
+	LabelServerHandshakeTrafficSecret = "SERVER_HANDSHAKE_TRAFFIC_SECRET"
+	LabelClientTrafficSecret0         = "CLIENT_TRAFFIC_SECRET_0"
+	LabelServerTrafficSecret0         = "SERVER_TRAFFIC_SECRET_0"
+)
+
+// KeyLog holds the secrets of an NSS key log file, as written by browsers
+// and crypto/tls when SSLKEYLOGFILE or tls.Config.KeyLogWriter is set.  It
+// is safe for concurrent use, and is an io.Writer so that secrets can be
+// added while connections are being decrypted.
+type KeyLog struct {
+	mu      sync.Mutex
+	secrets map[keyLogKey][]byte
+	partial []byte
+}
+
+type keyLogKey struct {
+	label        string
+	clientRandom string
+}
+
+// NewKeyLog returns an empty key log.
+func NewKeyLog() *KeyLog {
+	return &KeyLog{secrets: make(map[keyLogKey][]byte)}
+}
+
+// ReadKeyLog reads a key log file.
+func ReadKeyLog(r io.Reader) (*KeyLog, error) {
+	k := NewKeyLog()
+	s := bufio.NewScanner(r)
+	for n := 1; s.Scan(); n++ {
+		if err := k.addLine(s.Text()); err != nil {
+			return nil, fmt.Errorf("key log line %d: %v", n, err)
+		}
+	}
+	return k, s.Err()
+}
+
+// Add adds the secret of the connection with the client random.
+func (k *KeyLog) Add(label string, clientRandom, secret []byte) {
+	k.mu.Lock()
+	k.secrets[keyLogKey{label, string(clientRandom)}] = append([]byte(nil), secret...)
+	k.mu.Unlock()
+}
+
+// Secret returns the secret of the connection with the client random, or
+// nil.
+func (k *KeyLog) Secret(label string, clientRandom []byte) []byte {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	return k.secrets[keyLogKey{label, string(clientRandom)}]
+}
+
+// Write adds the lines of key log data.  A partial last line is kept
+// until the rest of it is written.
+func (k *KeyLog) Write(p []byte) (int, error) {
+	k.mu.Lock()
+	data := append(k.partial, p...)
+	k.partial = nil
+	var lines []string
+	for {
+		i := bytes.IndexByte(data, '\n')
+		if i < 0 {
+			break
+		}
+		lines = append(lines, string(data[:i]))
+		data = data[i+1:]
+	}
+	if len(data) > 0 {
+		k.partial = append([]byte(nil), data...)
+	}
+	k.mu.Unlock()
+	for _, line := range lines {
+		if err := k.addLine(line); err != nil {
+			return 0, err
+		}
+	}
+	return len(p), nil
+}
+
+// addLine adds a line of the form "<label> <client random> <secret>", with
+// the random and secret in hex.  Comments and blank lines are skipped.
+func (k *KeyLog) addLine(line string) error {
+	line = strings.TrimSpace(line)
+	if line == "" || line[0] == '#' {
+		return nil
+	}
+	fields := strings.Fields(line)
+	if len(fields) != 3 {
+		return fmt.Errorf("malformed key log line %q", line)
+	}
+	random, err := hex.DecodeString(fields[1])
+	if err != nil {
+		return fmt.Errorf("malformed client random: %v", err)
+	}
+	secret, err := hex.DecodeString(fields[2])
+	if err != nil {
+		return fmt.Errorf("malformed secret: %v", err)
+	}
+	k.Add(fields[0], random, secret)
+	return nil
+}
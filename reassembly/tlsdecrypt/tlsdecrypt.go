@@ -0,0 +1,309 @@
+// Copyright 2019 The GoPacket Authors. All rights reserved.
+//
+// Use of this source code is governed by a BSD-style license
+// that can be found in the LICENSE file in the root of the source
+// tree.
+
+// Package tlsdecrypt decrypts TLS connections reassembled by the reassembly
+// package, given the secrets of an NSS key log file.
+//
+// It follows the handshake of each connection to learn the client random,
+// version and cipher suite, looks the connection's secrets up in the key
+// log, and removes the record protection of TLS 1.2 (AES-GCM,
+// ChaCha20-Poly1305 and AES-CBC with HMAC-SHA1) and TLS 1.3.  A
+// StreamFactory plugs into a reassembly.StreamPool:
+//
+//	keys, err := tlsdecrypt.ReadKeyLog(file)
+//	...
+//	factory := &tlsdecrypt.StreamFactory{
+//		KeyLog: keys,
+//		Data: func(s *tlsdecrypt.Stream, dir reassembly.TCPFlowDirection, data []byte) {
+//			fmt.Printf("%v %v: %q\n", s.Net, s.Transport, data)
+//		},
+//	}
+//	assembler := reassembly.NewAssembler(reassembly.NewStreamPool(factory))
+//
+// A Decryptor can also be fed stream data directly.
+package tlsdecrypt
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+	"github.com/google/gopacket/reassembly"
+)
+
+// maxRecordLength bounds the length of TLS records, including the expansion
+// of their protection.
+const maxRecordLength = 1<<14 + 2048
+
+// Decryptor decrypts the two directions of one TLS connection.  The data of
+// both directions must be given in the order it was sent, as reassembly
+// does, since the client's keys depend on the server's handshake.
+type Decryptor struct {
+	keys *KeyLog
+
+	// clientDir is the direction the ClientHello was sent in.
+	clientDir    reassembly.TCPFlowDirection
+	clientRandom []byte
+	serverRandom []byte
+	version      layers.TLSVersion
+	suite        cipherSuite
+	ready        bool
+
+	halves [2]halfConn
+}
+
+// halfConn is the state of one direction.
+type halfConn struct {
+	buf       []byte
+	handshake layers.TLSHandshakeReassembler
+	cipher    recordCipher
+	seq       uint64
+	// secret is the current TLS 1.3 traffic secret.
+	secret []byte
+	err    error
+}
+
+// NewDecryptor returns a Decryptor which looks secrets up in keys.
+func NewDecryptor(keys *KeyLog) *Decryptor {
+	return &Decryptor{keys: keys}
+}
+
+func (d *Decryptor) half(dir reassembly.TCPFlowDirection) *halfConn {
+	if dir == reassembly.TCPDirServerToClient {
+		return &d.halves[1]
+	}
+	return &d.halves[0]
+}
+
+// Version returns the negotiated version, once the ServerHello has been
+// seen.
+func (d *Decryptor) Version() layers.TLSVersion {
+	return d.version
+}
+
+// Decrypt takes the next stream data of a direction, and returns the
+// application data of the records it completes.  Once an error is returned,
+// the direction can't be decrypted any further and the error is returned
+// again.
+func (d *Decryptor) Decrypt(dir reassembly.TCPFlowDirection, data []byte) ([]byte, error) {
+	h := d.half(dir)
+	if h.err != nil {
+		return nil, h.err
+	}
+	buf := append(h.buf, data...)
+	h.buf = nil
+	var out []byte
+	for len(buf) >= 5 {
+		length := int(binary.BigEndian.Uint16(buf[3:5]))
+		if length > maxRecordLength {
+			h.err = fmt.Errorf("TLS record of %d bytes", length)
+			return out, h.err
+		}
+		if len(buf) < 5+length {
+			break
+		}
+		var err error
+		out, err = d.record(dir, h, buf[:5], buf[5:5+length], out)
+		if err != nil {
+			h.err = err
+			return out, err
+		}
+		buf = buf[5+length:]
+	}
+	if len(buf) > 0 {
+		h.buf = append([]byte(nil), buf...)
+	}
+	return out, nil
+}
+
+// Skip tells the Decryptor that data of a direction was lost, which it
+// can't decrypt past.
+func (d *Decryptor) Skip(dir reassembly.TCPFlowDirection) {
+	if h := d.half(dir); h.err == nil {
+		h.err = errors.New("stream data lost")
+	}
+}
+
+func (d *Decryptor) record(dir reassembly.TCPFlowDirection, h *halfConn, header, payload, out []byte) ([]byte, error) {
+	typ := layers.TLSType(header[0])
+	// TLS 1.3 sends ChangeCipherSpec in plaintext for compatibility.
+	if h.cipher != nil && !(d.version == 0x0304 && typ == layers.TLSChangeCipherSpec) {
+		var err error
+		if typ, payload, err = h.cipher.decrypt(header, payload, h.seq); err != nil {
+			return out, fmt.Errorf("TLS record %d: %v", h.seq, err)
+		}
+		h.seq++
+	} else if typ == layers.TLSApplicationData && !d.ready && d.clientRandom != nil && dir == d.clientDir {
+		// TLS 1.3 0-RTT data, which isn't decrypted.
+		return out, nil
+	} else if typ == layers.TLSApplicationData {
+		return out, errors.New("TLS application data before the handshake")
+	}
+
+	switch typ {
+	case layers.TLSChangeCipherSpec:
+		if d.version != 0x0304 {
+			return out, d.changeCipherSpec12(dir, h)
+		}
+	case layers.TLSHandshake:
+		messages, err := h.handshake.Add(payload)
+		if err != nil {
+			return out, err
+		}
+		for _, m := range messages {
+			if err := d.handshakeMessage(dir, h, &m); err != nil {
+				return out, err
+			}
+		}
+	case layers.TLSApplicationData:
+		out = append(out, payload...)
+	}
+	return out, nil
+}
+
+func (d *Decryptor) handshakeMessage(dir reassembly.TCPFlowDirection, h *halfConn, m *layers.TLSHandshakeMessage) error {
+	switch {
+	case m.ClientHello != nil:
+		d.clientDir = dir
+		d.clientRandom = m.ClientHello.Random
+	case m.ServerHello != nil && !m.ServerHello.HelloRetryRequest:
+		sh := m.ServerHello
+		d.serverRandom = sh.Random
+		d.version = sh.SelectedVersion()
+		suite, ok := cipherSuites[sh.CipherSuite]
+		if !ok {
+			return fmt.Errorf("unsupported cipher suite %v", sh.CipherSuite)
+		}
+		if d.version != 0x0304 && d.version != 0x0303 {
+			return fmt.Errorf("unsupported version %v", d.version)
+		}
+		d.suite = suite
+		d.ready = true
+		// The client's messages have the server's version.
+		client := d.half(d.clientDir)
+		client.handshake.Version = h.handshake.Version
+		client.handshake.CipherSuite = h.handshake.CipherSuite
+		if d.version == 0x0304 {
+			if err := d.setSecret13(h, LabelServerHandshakeTrafficSecret); err != nil {
+				return err
+			}
+			return d.setSecret13(client, LabelClientHandshakeTrafficSecret)
+		}
+	case m.Type == layers.TLSHandshakeFinished && d.version == 0x0304:
+		label := LabelServerTrafficSecret0
+		if dir == d.clientDir {
+			label = LabelClientTrafficSecret0
+		}
+		return d.setSecret13(h, label)
+	case m.Type == layers.TLSHandshakeKeyUpdate && d.version == 0x0304:
+		return d.updateSecret13(h, d.suite.nextSecret13(h.secret))
+	}
+	return nil
+}
+
+func (d *Decryptor) changeCipherSpec12(dir reassembly.TCPFlowDirection, h *halfConn) error {
+	if !d.ready {
+		return errors.New("TLS ChangeCipherSpec before ServerHello")
+	}
+	master := d.keys.Secret(LabelClientRandom, d.clientRandom)
+	if master == nil {
+		return fmt.Errorf("no %s secret for %x", LabelClientRandom, d.clientRandom)
+	}
+	c, err := d.suite.keys12(master, d.clientRandom, d.serverRandom, dir == d.clientDir)
+	if err != nil {
+		return err
+	}
+	h.cipher, h.seq = c, 0
+	return nil
+}
+
+func (d *Decryptor) setSecret13(h *halfConn, label string) error {
+	secret := d.keys.Secret(label, d.clientRandom)
+	if secret == nil {
+		return fmt.Errorf("no %s secret for %x", label, d.clientRandom)
+	}
+	return d.updateSecret13(h, secret)
+}
+
+func (d *Decryptor) updateSecret13(h *halfConn, secret []byte) error {
+	c, err := d.suite.keys13(secret)
+	if err != nil {
+		return err
+	}
+	h.cipher, h.seq, h.secret = c, 0, secret
+	return nil
+}
+
+// StreamFactory is a reassembly.StreamFactory which creates a Stream for
+// each connection, decrypting it with the secrets of KeyLog.
+type StreamFactory struct {
+	KeyLog *KeyLog
+	// Data is called with the application data decrypted from each
+	// direction of each stream.
+	Data func(s *Stream, dir reassembly.TCPFlowDirection, data []byte)
+	// Error, if set, is called once for each direction which can't be
+	// decrypted, such as one without secrets in KeyLog or with missing
+	// data.  Streams which aren't TLS fail too.
+	Error func(s *Stream, dir reassembly.TCPFlowDirection, err error)
+}
+
+// New implements reassembly.StreamFactory.
+func (f *StreamFactory) New(netFlow, tcpFlow gopacket.Flow, tcp *layers.TCP, ac reassembly.AssemblerContext) reassembly.Stream {
+	return &Stream{
+		Net:       netFlow,
+		Transport: tcpFlow,
+		Decryptor: NewDecryptor(f.KeyLog),
+		factory:   f,
+	}
+}
+
+// Stream is a reassembly.Stream which decrypts a TLS connection.
+type Stream struct {
+	// Net and Transport are the flows of the connection's first packet.
+	Net, Transport gopacket.Flow
+	Decryptor      *Decryptor
+
+	factory *StreamFactory
+	failed  [2]bool
+}
+
+// Accept implements reassembly.Stream, accepting all packets.
+func (s *Stream) Accept(tcp *layers.TCP, ci gopacket.CaptureInfo, dir reassembly.TCPFlowDirection, nextSeq reassembly.Sequence, start *bool, ac reassembly.AssemblerContext) bool {
+	return true
+}
+
+// ReassembledSG implements reassembly.Stream.
+func (s *Stream) ReassembledSG(sg reassembly.ScatterGather, ac reassembly.AssemblerContext) {
+	dir, _, _, skip := sg.Info()
+	failed := &s.failed[0]
+	if dir == reassembly.TCPDirServerToClient {
+		failed = &s.failed[1]
+	}
+	if *failed {
+		return
+	}
+	if skip != 0 {
+		s.Decryptor.Skip(dir)
+	}
+	length, _ := sg.Lengths()
+	data, err := s.Decryptor.Decrypt(dir, sg.Fetch(length))
+	if len(data) > 0 && s.factory.Data != nil {
+		s.factory.Data(s, dir, data)
+	}
+	if err != nil {
+		*failed = true
+		if s.factory.Error != nil {
+			s.factory.Error(s, dir, err)
+		}
+	}
+}
+
+// ReassemblyComplete implements reassembly.Stream.
+func (s *Stream) ReassemblyComplete(ac reassembly.AssemblerContext) bool {
+	return true
+}
@@ -0,0 +1,311 @@
+// Copyright 2019 The GoPacket Authors. All rights reserved.
+//
+// Use of this source code is governed by a BSD-style license
+// that can be found in the LICENSE file in the root of the source
+// tree.
+
+package tlsdecrypt
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"io"
+	"math/big"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+	"github.com/google/gopacket/reassembly"
+)
+
+// capture records the data written by both ends of a connection, in order.
+type capture struct {
+	mu     sync.Mutex
+	chunks []chunk
+}
+
+type chunk struct {
+	dir  reassembly.TCPFlowDirection
+	data []byte
+}
+
+type captureConn struct {
+	net.Conn
+	c   *capture
+	dir reassembly.TCPFlowDirection
+}
+
+func (c *captureConn) Write(b []byte) (int, error) {
+	c.c.mu.Lock()
+	c.c.chunks = append(c.c.chunks, chunk{c.dir, append([]byte(nil), b...)})
+	c.c.mu.Unlock()
+	return c.Conn.Write(b)
+}
+
+func testCertificate(t *testing.T) tls.Certificate {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "example.com"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}
+}
+
+var (
+	testRequest  = bytes.Repeat([]byte("request "), 4000)
+	testResponse = []byte("response")
+)
+
+// runTLS makes a connection between crypto/tls peers with the version and
+// cipher suite, sending testRequest and testResponse, and returns its
+// capture and key log.
+func runTLS(t *testing.T, version uint16, suite uint16) (*capture, *bytes.Buffer) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Skip("no loopback networking:", err)
+	}
+	defer l.Close()
+	c := &capture{}
+	var keyLog bytes.Buffer
+	var suites []uint16
+	if suite != 0 {
+		suites = []uint16{suite}
+	}
+
+	errc := make(chan error, 1)
+	go func() {
+		conn, err := l.Accept()
+		if err != nil {
+			errc <- err
+			return
+		}
+		s := tls.Server(&captureConn{conn, c, reassembly.TCPDirServerToClient}, &tls.Config{
+			Certificates: []tls.Certificate{testCertificate(t)},
+			MaxVersion:   version,
+			CipherSuites: suites,
+		})
+		defer s.Close()
+		request := make([]byte, len(testRequest))
+		if _, err := io.ReadFull(s, request); err != nil {
+			errc <- err
+			return
+		}
+		_, err = s.Write(testResponse)
+		errc <- err
+	}()
+
+	conn, err := net.Dial("tcp", l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	cl := tls.Client(&captureConn{conn, c, reassembly.TCPDirClientToServer}, &tls.Config{
+		InsecureSkipVerify: true,
+		MaxVersion:         version,
+		CipherSuites:       suites,
+		KeyLogWriter:       &keyLog,
+	})
+	defer cl.Close()
+	if _, err := cl.Write(testRequest); err != nil {
+		t.Fatal(err)
+	}
+	response := make([]byte, len(testResponse))
+	if _, err := io.ReadFull(cl, response); err != nil {
+		t.Fatal(err)
+	}
+	if err := <-errc; err != nil {
+		t.Fatal(err)
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c, &keyLog
+}
+
+// assemble feeds the capture to an assembler as TCP segments.
+func (c *capture) assemble(f *StreamFactory) {
+	a := reassembly.NewAssembler(reassembly.NewStreamPool(f))
+	client, server := net.IP{10, 0, 0, 1}, net.IP{10, 0, 0, 2}
+	flow := gopacket.NewFlow(layers.EndpointIPv4, client, server)
+	seq := [2]uint32{1000, 5000}
+	send := func(dir reassembly.TCPFlowDirection, tcp layers.TCP) {
+		i := 0
+		tcp.SrcPort, tcp.DstPort = 40000, 443
+		if dir == reassembly.TCPDirServerToClient {
+			i = 1
+			tcp.SrcPort, tcp.DstPort = 443, 40000
+		}
+		tcp.Seq = seq[i]
+		tcp.ACK = !tcp.SYN || i == 1
+		tcp.Ack = seq[1-i]
+		seq[i] += uint32(len(tcp.Payload))
+		if tcp.SYN || tcp.FIN {
+			seq[i]++
+		}
+		f := flow
+		if i == 1 {
+			f = flow.Reverse()
+		}
+		a.Assemble(f, &tcp)
+	}
+	send(reassembly.TCPDirClientToServer, layers.TCP{SYN: true})
+	send(reassembly.TCPDirServerToClient, layers.TCP{SYN: true})
+	for _, ch := range c.chunks {
+		for data := ch.data; len(data) > 0; {
+			n := 1400
+			if n > len(data) {
+				n = len(data)
+			}
+			send(ch.dir, layers.TCP{BaseLayer: layers.BaseLayer{Payload: data[:n]}})
+			data = data[n:]
+		}
+	}
+	send(reassembly.TCPDirClientToServer, layers.TCP{FIN: true})
+	send(reassembly.TCPDirServerToClient, layers.TCP{FIN: true})
+	a.FlushAll()
+}
+
+func TestDecrypt(t *testing.T) {
+	for _, test := range []struct {
+		name    string
+		version uint16
+		suite   uint16
+	}{
+		{"TLS 1.2 AES-128-GCM", tls.VersionTLS12, tls.TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256},
+		{"TLS 1.2 AES-256-GCM", tls.VersionTLS12, tls.TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384},
+		{"TLS 1.2 ChaCha20-Poly1305", tls.VersionTLS12, tls.TLS_ECDHE_ECDSA_WITH_CHACHA20_POLY1305},
+		{"TLS 1.2 AES-128-CBC-SHA", tls.VersionTLS12, tls.TLS_ECDHE_ECDSA_WITH_AES_128_CBC_SHA},
+		{"TLS 1.2 AES-256-CBC-SHA", tls.VersionTLS12, tls.TLS_ECDHE_ECDSA_WITH_AES_256_CBC_SHA},
+		{"TLS 1.3", tls.VersionTLS13, 0},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			c, keyLog := runTLS(t, test.version, test.suite)
+			keys, err := ReadKeyLog(keyLog)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var data [2][]byte
+			f := &StreamFactory{
+				KeyLog: keys,
+				Data: func(s *Stream, dir reassembly.TCPFlowDirection, b []byte) {
+					i := 0
+					if dir == reassembly.TCPDirServerToClient {
+						i = 1
+					}
+					data[i] = append(data[i], b...)
+				},
+				Error: func(s *Stream, dir reassembly.TCPFlowDirection, err error) {
+					t.Errorf("%v: %v", dir, err)
+				},
+			}
+			c.assemble(f)
+			if !bytes.Equal(data[0], testRequest) {
+				t.Errorf("request %d bytes, want %d", len(data[0]), len(testRequest))
+			}
+			if !bytes.Equal(data[1], testResponse) {
+				t.Errorf("response %q, want %q", data[1], testResponse)
+			}
+		})
+	}
+}
+
+func TestDecryptWithoutKeys(t *testing.T) {
+	for _, version := range []uint16{tls.VersionTLS12, tls.VersionTLS13} {
+		c, _ := runTLS(t, version, 0)
+		var errs []error
+		f := &StreamFactory{
+			KeyLog: NewKeyLog(),
+			Data: func(s *Stream, dir reassembly.TCPFlowDirection, b []byte) {
+				t.Errorf("%v: decrypted %q", dir, b)
+			},
+			Error: func(s *Stream, dir reassembly.TCPFlowDirection, err error) {
+				errs = append(errs, err)
+			},
+		}
+		c.assemble(f)
+		if len(errs) == 0 || !strings.Contains(errs[0].Error(), "secret") {
+			t.Errorf("version %x: errors %v", version, errs)
+		}
+	}
+}
+
+func TestDecryptorChunks(t *testing.T) {
+	c, keyLog := runTLS(t, tls.VersionTLS13, 0)
+	keys := NewKeyLog()
+	// Key logs may be written a piece at a time.
+	for _, b := range keyLog.Bytes() {
+		keys.Write([]byte{b})
+	}
+	d := NewDecryptor(keys)
+	var request []byte
+	for _, ch := range c.chunks {
+		// Feed the data a byte at a time.
+		for i := range ch.data {
+			out, err := d.Decrypt(ch.dir, ch.data[i:i+1])
+			if err != nil {
+				t.Fatal(err)
+			}
+			if ch.dir == reassembly.TCPDirClientToServer {
+				request = append(request, out...)
+			}
+		}
+	}
+	if !bytes.Equal(request, testRequest) {
+		t.Errorf("request %d bytes, want %d", len(request), len(testRequest))
+	}
+	if d.Version() != 0x0304 {
+		t.Errorf("version %v", d.Version())
+	}
+
+	// A corrupted record can't be decrypted.
+	d = NewDecryptor(keys)
+	for i, ch := range c.chunks {
+		data := ch.data
+		if i == len(c.chunks)-1 {
+			data = append([]byte(nil), data...)
+			data[len(data)-1] ^= 1
+		}
+		if _, err := d.Decrypt(ch.dir, data); err != nil {
+			if i != len(c.chunks)-1 {
+				t.Fatalf("chunk %d: %v", i, err)
+			}
+			return
+		}
+	}
+	t.Error("no error for corrupted record")
+}
+
+func TestReadKeyLog(t *testing.T) {
+	keys, err := ReadKeyLog(strings.NewReader("# comment\n\nCLIENT_RANDOM 0102 a0a1\nSERVER_TRAFFIC_SECRET_0 0102 b0b1\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s := keys.Secret(LabelClientRandom, []byte{1, 2}); !bytes.Equal(s, []byte{0xa0, 0xa1}) {
+		t.Errorf("CLIENT_RANDOM secret %x", s)
+	}
+	if s := keys.Secret(LabelServerTrafficSecret0, []byte{1, 2}); !bytes.Equal(s, []byte{0xb0, 0xb1}) {
+		t.Errorf("SERVER_TRAFFIC_SECRET_0 secret %x", s)
+	}
+	if s := keys.Secret(LabelClientRandom, []byte{1, 3}); s != nil {
+		t.Errorf("secret of unknown random %x", s)
+	}
+	if _, err := ReadKeyLog(strings.NewReader("CLIENT_RANDOM 01zz a0\n")); err == nil {
+		t.Error("no error for malformed key log")
+	}
+}